@@ -1,10 +1,16 @@
 package main
 
 import (
+	"context"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/example/pipeline-engine/internal/engine"
+	"github.com/example/pipeline-engine/internal/store"
 	"github.com/example/pipeline-engine/pkg/logging"
+	"github.com/example/pipeline-engine/pkg/pipeline"
 )
 
 type providerRuntime struct {
@@ -25,6 +31,17 @@ func logEnvStatus(runtime providerRuntime) {
 	}
 }
 
+func configureProviderIOLogging() {
+	if fields := getenv(engine.LogRedactFieldsEnvVar); fields != "" {
+		logging.SetRedactFields(strings.Split(fields, ","))
+	}
+	enabled, _ := strconv.ParseBool(getenv(engine.ProviderIOLogEnvVar))
+	logging.SetProviderIO(enabled)
+	if enabled {
+		logging.Warnf("provider request/response logging enabled via %s (effective only at debug level)", engine.ProviderIOLogEnvVar)
+	}
+}
+
 func buildEngine(jobStore engine.JobStore) (engine.Engine, providerRuntime) {
 	profiles := []engine.ProviderProfile{}
 	var runtime providerRuntime
@@ -45,12 +62,93 @@ func buildEngine(jobStore engine.JobStore) (engine.Engine, providerRuntime) {
 		logging.Warnf("Ollama profile not configured; set %s or %s", engine.OllamaEnableEnvVar, engine.OllamaBaseURLEnvVar)
 	}
 
-	if len(profiles) > 0 {
+	cfg := &engine.EngineConfig{
+		Providers:        profiles,
+		JobTTL:           durationFromEnv(engine.JobTTLEnvVar),
+		JobSweepInterval: durationFromEnv(engine.JobSweepIntervalEnvVar),
+	}
+	cfg.DisableDefaultProfiles, _ = strconv.ParseBool(getenv(engine.DisableDefaultProfilesEnvVar))
+	switch {
+	case len(profiles) > 0:
 		logging.Infof("bootstrapping engine with %d provider profile(s)", len(profiles))
-		return engine.NewBasicEngineWithConfig(jobStore, &engine.EngineConfig{Providers: profiles}), runtime
+	case cfg.DisableDefaultProfiles:
+		logging.Warnf("no env-backed providers configured and built-in defaults disabled; register profiles via /v1/config/providers")
+	default:
+		logging.Warnf("no env-backed providers configured; using built-in defaults")
+	}
+	if cfg.DisableDefaultProfiles {
+		logging.Infof("built-in default provider profiles disabled via %s", engine.DisableDefaultProfilesEnvVar)
+	}
+	cfg.StrictPipelineResolution, _ = strconv.ParseBool(getenv(engine.StrictPipelinesEnvVar))
+	cfg.CancelOnDisconnect, _ = strconv.ParseBool(getenv(engine.CancelOnDisconnectEnvVar))
+	if cfg.JobTTL > 0 {
+		logging.Infof("evicting finished jobs after %s", cfg.JobTTL)
+	}
+	cfg.ProfileStore = buildProfileStoreFromEnv()
+	if dir := strings.TrimSpace(getenv(engine.ExportDirEnvVar)); dir != "" {
+		cfg.ExportSinks = map[string]engine.ExportSink{"file": engine.NewFileExportSink(dir)}
+		logging.Infof("export sink \"file\" writes results under %s", dir)
+	}
+	if hosts := strings.TrimSpace(getenv(engine.WebhookAllowedHostsEnvVar)); hosts != "" {
+		cfg.WebhookAllowedHosts = strings.Split(hosts, ",")
+		logging.Infof("webhooks may target internal hosts %s", hosts)
+	}
+	return engine.NewBasicEngineWithConfig(jobStore, cfg), runtime
+}
+
+func buildProfileStoreFromEnv() engine.ProfileStore {
+	path := strings.TrimSpace(getenv(engine.ProfileStorePathEnvVar))
+	if path == "" {
+		return nil
+	}
+	secret := getenv(engine.ProfileStoreSecretEnvVar)
+	if secret == "" {
+		logging.Warnf("%s not set; literal api keys of runtime provider profiles will not be persisted", engine.ProfileStoreSecretEnvVar)
+	}
+	profiles, err := store.NewFileProfileStore(path, secret)
+	if err != nil {
+		logging.Warnf("provider profile store disabled: %v", err)
+		return nil
 	}
-	logging.Warnf("no env-backed providers configured; using built-in defaults")
-	return engine.NewBasicEngine(jobStore), runtime
+	logging.Infof("persisting runtime provider profiles to %s", path)
+	return profiles
+}
+
+// reconcileOrphanedJobs fails or requeues jobs left queued or running by a
+// previous process, per PIPELINE_ENGINE_ORPHAN_POLICY.
+func reconcileOrphanedJobs(eng engine.Engine) {
+	reconciler, ok := eng.(interface {
+		ReconcileJobs(context.Context, engine.OrphanPolicy) (int, error)
+	})
+	if !ok {
+		return
+	}
+	policy, err := engine.ParseOrphanPolicy(getenv(engine.OrphanPolicyEnvVar))
+	if err != nil {
+		logging.Warnf("ignoring %s: %v", engine.OrphanPolicyEnvVar, err)
+		policy = engine.OrphanPolicyFail
+	}
+	n, err := reconciler.ReconcileJobs(context.Background(), policy)
+	if err != nil {
+		logging.Warnf("orphaned job reconciliation failed: %v", err)
+		return
+	}
+	if n > 0 {
+		logging.Infof("reconciled %d orphaned job(s) with policy %s", n, policy)
+	}
+}
+
+func durationFromEnv(key string) time.Duration {
+	raw := strings.TrimSpace(getenv(key))
+	if raw == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d < 0 {
+		logging.Warnf("ignoring invalid %s=%q (expected a duration such as 24h)", key, raw)
+		return 0
+	}
+	return d
 }
 
 func buildOpenAIProfileFromEnv() (engine.ProviderProfile, bool) {
@@ -106,125 +204,74 @@ func registerDemoPipelines(eng engine.Engine, providers providerRuntime) {
 		logging.Warnf("engine does not support pipeline registration; skipping demos")
 		return
 	}
+	register := func(b *pipeline.Builder) bool {
+		def, err := b.Build()
+		if err != nil {
+			logging.Warnf("demo pipeline is invalid; skipping: %v", err)
+			return false
+		}
+		registrar.RegisterPipeline(def)
+		return true
+	}
 	if providers.openAIProfileID != nil {
-		registrar.RegisterPipeline(engine.PipelineDef{
-			Type:    engine.PipelineType("openai.summarize.v1"),
-			Version: "v1",
-			Steps: []engine.StepDef{
-				{
-					ID:                engine.StepID("summarize"),
-					Name:              "OpenAI Summarize",
-					Kind:              engine.StepKindLLM,
-					Mode:              engine.StepModeSingle,
-					ProviderProfileID: *providers.openAIProfileID,
-					OutputType:        engine.ContentText,
-					Export:            true,
-				},
-			},
-		})
-		logging.Infof("registered demo pipeline openai.summarize.v1 for profile %s", *providers.openAIProfileID)
-		registrar.RegisterPipeline(engine.PipelineDef{
-			Type:    engine.PipelineType("openai.chain.v1"),
-			Version: "v1",
-			Steps: []engine.StepDef{
-				{
-					ID:                engine.StepID("summarize"),
-					Name:              "Summarize Input",
-					Kind:              engine.StepKindLLM,
-					Mode:              engine.StepModeSingle,
-					ProviderProfileID: *providers.openAIProfileID,
-					Prompt: &engine.PromptTemplate{
-						System: "You are a concise assistant that writes Japanese summaries when the input is Japanese.",
-						User:   "Summarize the following context:\n{{range .Sources}}{{.Content}}\n{{end}}",
-					},
-					OutputType: engine.ContentText,
-					Export:     false,
-				},
-				{
-					ID:                engine.StepID("polish"),
-					Name:              "Polish Summary",
-					Kind:              engine.StepKindLLM,
-					Mode:              engine.StepModeSingle,
-					DependsOn:         []engine.StepID{engine.StepID("summarize")},
-					ProviderProfileID: *providers.openAIProfileID,
-					Prompt: &engine.PromptTemplate{
-						System: "You are a meticulous proofreader. Keep the tone friendly and preserve Japanese if the input is Japanese.",
-						User:   "Polish the summary below for clarity and fix typos. Output markdown.\n{{with index .Previous \"summarize\"}}{{with index . 0}}{{index .Data \"text\"}}{{end}}{{end}}",
-					},
-					OutputType: engine.ContentMarkdown,
-					Export:     true,
-				},
-			},
-		})
-		logging.Infof("registered demo pipeline openai.chain.v1 for profile %s", *providers.openAIProfileID)
-		registrar.RegisterPipeline(engine.PipelineDef{
-			Type:    engine.PipelineType("openai.funmarkdown.v1"),
-			Version: "v1",
-			Steps: []engine.StepDef{
-				{
-					ID:                engine.StepID("trivia"),
-					Name:              "Random Trivia",
-					Kind:              engine.StepKindLLM,
-					Mode:              engine.StepModeSingle,
-					ProviderProfileID: *providers.openAIProfileID,
-					Prompt: &engine.PromptTemplate{
-						System: "You are a cheerful Japanese trivia guide. Speak naturally but keep lightweight labels.",
-						User:   `ユーザーリクエスト:{{range .Sources}}\n- {{if .Label}}{{.Label}}: {{end}}{{.Content}}{{end}}\n\n以下の順番で回答してください。最初に口語の導入文を 1-2 文、その後に各ラベルを 1 行ずつ記述します。\n1. 口語導入 (例: そういえば… で始める)\n2. タイトル: <8文字程度>\n3. まとめ: <2文で事実と背景>\n4. 理由: <なぜ面白いか 1 文>\n5. ディテール: <音/匂い/触感など 1 文>`,
-					},
-					OutputType: engine.ContentText,
-					Export:     true,
-				},
-				{
-					ID:                engine.StepID("enrich"),
-					Name:              "Enrich Trivia",
-					Kind:              engine.StepKindLLM,
-					Mode:              engine.StepModeSingle,
-					DependsOn:         []engine.StepID{engine.StepID("trivia")},
-					ProviderProfileID: *providers.openAIProfileID,
-					Prompt: &engine.PromptTemplate{
-						System: "You are an insightful narrator who deepens trivia stories while preserving their sections.",
-						User:   `以下のテキストを読み込み、各ラベルの内容を 10-20 文増やしつつ背景やトリビアを補足してください。出力は同じ順序とラベル (口語導入→タイトル:→まとめ:→理由:→ディテール:) のみです。\n\n元テキスト:\n{{with index .Previous "trivia"}}{{with index . 0}}{{index .Data "text"}}{{end}}{{end}}`,
-					},
-					OutputType: engine.ContentText,
-					Export:     true,
-				},
-				{
-					ID:                engine.StepID("markdown"),
-					Name:              "Format Trivia",
-					Kind:              engine.StepKindLLM,
-					Mode:              engine.StepModeSingle,
-					DependsOn:         []engine.StepID{engine.StepID("enrich")},
-					ProviderProfileID: *providers.openAIProfileID,
-					Prompt: &engine.PromptTemplate{
-						System: "You are a tidy Japanese technical writer. Convert lightly structured text into a neat Markdown card.",
-						User:   `次のテキストを読み取り、ラベル行 (タイトル:/まとめ:/理由:/ディテール:) を抽出して Markdown に整形してください。\n- ## <タイトル>\n- 冒頭の口語文を *イタリック* で引用前に挿入\n- まとめは引用 (> ) にして丁寧語へ整える\n- 理由は "### ポイント" の下で番号付き 1 行 (1.)\n- ディテールは "### ディテール" の下で箇条書き 1 行 (- )\n\n入力テキスト:\n{{with index .Previous "enrich"}}{{with index . 0}}{{index .Data "text"}}{{end}}{{end}}`,
-					},
-					OutputType: engine.ContentMarkdown,
-					Export:     true,
-				},
-			},
-		})
-		logging.Infof("registered demo pipeline openai.funmarkdown.v1 for profile %s", *providers.openAIProfileID)
+		openAI := *providers.openAIProfileID
+		if register(pipeline.New("openai.summarize.v1").
+			Step("summarize").Name("OpenAI Summarize").Profile(openAI).Export()) {
+			logging.Infof("registered demo pipeline openai.summarize.v1 for profile %s", openAI)
+		}
+		if register(pipeline.New("openai.chain.v1").
+			DefaultProfile(openAI).
+			Step("summarize").Name("Summarize Input").
+			Prompt(
+				"You are a concise assistant that writes Japanese summaries when the input is Japanese.",
+				"Summarize the following context:\n{{range .Sources}}{{.Content}}\n{{end}}",
+			).
+			Step("polish").Name("Polish Summary").
+			DependsOn("summarize").
+			InputMapping("summary", "/summarize/0/data/text").
+			Prompt(
+				"You are a meticulous proofreader. Keep the tone friendly and preserve Japanese if the input is Japanese.",
+				"Polish the summary below for clarity and fix typos. Output markdown.\n{{.Vars.summary}}",
+			).
+			Output(engine.ContentMarkdown).
+			Export()) {
+			logging.Infof("registered demo pipeline openai.chain.v1 for profile %s", openAI)
+		}
+		if register(pipeline.New("openai.funmarkdown.v1").
+			Step("trivia").Name("Random Trivia").Profile(openAI).
+			Prompt(
+				"You are a cheerful Japanese trivia guide. Speak naturally but keep lightweight labels.",
+				`ユーザーリクエスト:{{range .Sources}}\n- {{if .Label}}{{.Label}}: {{end}}{{.Content}}{{end}}\n\n以下の順番で回答してください。最初に口語の導入文を 1-2 文、その後に各ラベルを 1 行ずつ記述します。\n1. 口語導入 (例: そういえば… で始める)\n2. タイトル: <8文字程度>\n3. まとめ: <2文で事実と背景>\n4. 理由: <なぜ面白いか 1 文>\n5. ディテール: <音/匂い/触感など 1 文>`,
+			).
+			Export().
+			Step("enrich").Name("Enrich Trivia").Profile(openAI).
+			DependsOn("trivia").
+			InputMapping("trivia", "/trivia/0/data/text").
+			Prompt(
+				"You are an insightful narrator who deepens trivia stories while preserving their sections.",
+				`以下のテキストを読み込み、各ラベルの内容を 10-20 文増やしつつ背景やトリビアを補足してください。出力は同じ順序とラベル (口語導入→タイトル:→まとめ:→理由:→ディテール:) のみです。\n\n元テキスト:\n{{.Vars.trivia}}`,
+			).
+			Export().
+			Step("markdown").Name("Format Trivia").Profile(openAI).
+			DependsOn("enrich").
+			InputMapping("enriched", "/enrich/0/data/text").
+			Prompt(
+				"You are a tidy Japanese technical writer. Convert lightly structured text into a neat Markdown card.",
+				`次のテキストを読み取り、ラベル行 (タイトル:/まとめ:/理由:/ディテール:) を抽出して Markdown に整形してください。\n- ## <タイトル>\n- 冒頭の口語文を *イタリック* で引用前に挿入\n- まとめは引用 (> ) にして丁寧語へ整える\n- 理由は "### ポイント" の下で番号付き 1 行 (1.)\n- ディテールは "### ディテール" の下で箇条書き 1 行 (- )\n\n入力テキスト:\n{{.Vars.enriched}}`,
+			).
+			Output(engine.ContentMarkdown).
+			Export()) {
+			logging.Infof("registered demo pipeline openai.funmarkdown.v1 for profile %s", openAI)
+		}
 	} else {
 		logging.Infof("skipping openai demo pipelines; no openai profile configured")
 	}
 	if providers.ollamaProfileID != nil {
-		registrar.RegisterPipeline(engine.PipelineDef{
-			Type:    engine.PipelineType("ollama.summarize.v1"),
-			Version: "v1",
-			Steps: []engine.StepDef{
-				{
-					ID:                engine.StepID("summarize"),
-					Name:              "Ollama Summarize",
-					Kind:              engine.StepKindLLM,
-					Mode:              engine.StepModeSingle,
-					ProviderProfileID: *providers.ollamaProfileID,
-					OutputType:        engine.ContentText,
-					Export:            true,
-				},
-			},
-		})
-		logging.Infof("registered demo pipeline ollama.summarize.v1 for profile %s", *providers.ollamaProfileID)
+		ollama := *providers.ollamaProfileID
+		if register(pipeline.New("ollama.summarize.v1").
+			Step("summarize").Name("Ollama Summarize").Profile(ollama).Export()) {
+			logging.Infof("registered demo pipeline ollama.summarize.v1 for profile %s", ollama)
+		}
 	} else {
 		logging.Infof("skipping ollama demo pipeline; no ollama profile configured")
 	}
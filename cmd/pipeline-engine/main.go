@@ -7,9 +7,11 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/example/pipeline-engine/internal/grpcserver"
 	"github.com/example/pipeline-engine/internal/server"
 	"github.com/example/pipeline-engine/internal/store"
 	"github.com/example/pipeline-engine/pkg/logging"
@@ -22,13 +24,30 @@ func main() {
 	}
 	level := logging.SetLevelFromString(os.Getenv("PIPELINE_ENGINE_LOG_LEVEL"))
 	logging.Infof("log level configured: %s", level.String())
+	configureProviderIOLogging()
 
 	jobStore := store.NewMemoryStore()
 	eng, providers := buildEngine(jobStore)
 	registerDemoPipelines(eng, providers)
+	reconcileOrphanedJobs(eng)
 	srv := server.NewServer(eng)
+	if origins := os.Getenv("PIPELINE_ENGINE_WS_ALLOWED_ORIGINS"); origins != "" {
+		srv.SetAllowedOrigins(strings.Split(origins, ",")...)
+	}
 	logEnvStatus(providers)
 
+	// The gRPC API is opt-in; it shares the engine with the HTTP server.
+	var grpcSrv *grpcserver.Server
+	if grpcAddr := os.Getenv("PIPELINE_ENGINE_GRPC_ADDR"); grpcAddr != "" {
+		grpcSrv = grpcserver.NewServer(eng)
+		go func() {
+			logging.Infof("pipeline engine gRPC listening on %s", grpcAddr)
+			if err := grpcSrv.ListenAndServe(grpcAddr); err != nil {
+				log.Fatalf("gRPC server exited: %v", err)
+			}
+		}()
+	}
+
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
@@ -40,6 +59,12 @@ func main() {
 		if err := srv.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Printf("graceful shutdown failed: %v", err)
 		}
+		if grpcSrv != nil {
+			grpcSrv.Shutdown(shutdownCtx)
+		}
+		if closer, ok := eng.(interface{ Close() }); ok {
+			closer.Close()
+		}
 	}()
 
 	logging.Infof("pipeline engine listening on %s", addr)
@@ -24,10 +24,42 @@ func (f *fakeEngine) CancelJob(ctx context.Context, jobID string, reason string)
 	return nil
 }
 
+func (f *fakeEngine) CancelJobWithDetails(ctx context.Context, jobID string, cancellation engine.Cancellation) error {
+	return nil
+}
+
 func (f *fakeEngine) GetJob(ctx context.Context, jobID string) (*engine.Job, error) {
 	return nil, nil
 }
 
+func (f *fakeEngine) ListJobs(ctx context.Context, filter engine.JobFilter) ([]*engine.Job, error) {
+	return nil, nil
+}
+
+func (f *fakeEngine) JobLineage(ctx context.Context, jobID string) (*engine.JobLineage, error) {
+	return nil, nil
+}
+
+func (f *fakeEngine) DiffJobs(ctx context.Context, jobID, againstID string) (*engine.JobDiff, error) {
+	return nil, nil
+}
+
+func (f *fakeEngine) JobPipeline(ctx context.Context, jobID string) (*engine.PipelineDef, error) {
+	return nil, nil
+}
+
+func (f *fakeEngine) SkipStep(ctx context.Context, jobID string, stepID engine.StepID) error {
+	return nil
+}
+
+func (f *fakeEngine) RuntimeConfig() engine.RuntimeConfig {
+	return engine.RuntimeConfig{}
+}
+
+func (f *fakeEngine) UpdateRuntimeConfig(cfg engine.RuntimeConfig) (engine.RuntimeConfig, error) {
+	return cfg, nil
+}
+
 func (f *fakeEngine) RegisterPipeline(def engine.PipelineDef) {
 	f.regs = append(f.regs, def)
 }
@@ -40,6 +72,14 @@ func (f *fakeEngine) ListPipelines() []engine.PipelineDef {
 	return append([]engine.PipelineDef{}, f.regs...)
 }
 
+func (f *fakeEngine) LintPipeline(def engine.PipelineDef) []engine.Finding {
+	return engine.Lint(def)
+}
+
+func (f *fakeEngine) NewBatchID() string {
+	return "batch-fake"
+}
+
 func TestBuildOpenAIProfileFromEnv(t *testing.T) {
 	t.Run("missing key", func(t *testing.T) {
 		t.Setenv(engine.OpenAIAPIKeyEnvVar, "")
@@ -109,6 +149,27 @@ func TestBuildEngineRegistersProfiles(t *testing.T) {
 	}
 }
 
+func TestBuildEngineDisablesDefaultProfiles(t *testing.T) {
+	t.Setenv(engine.OpenAIAPIKeyEnvVar, "")
+	t.Setenv(engine.OllamaEnableEnvVar, "")
+	t.Setenv(engine.OllamaBaseURLEnvVar, "")
+	def := engine.PipelineDef{
+		Type:  "defaults",
+		Steps: []engine.StepDef{{ID: "s", Kind: engine.StepKindLLM, ProviderProfileID: "default-openai"}},
+	}
+
+	eng, _ := buildEngine(store.NewMemoryStore())
+	if err := eng.(*engine.BasicEngine).ValidatePipeline(def); err != nil {
+		t.Fatalf("expected default profiles to be registered by default: %v", err)
+	}
+
+	t.Setenv(engine.DisableDefaultProfilesEnvVar, "true")
+	eng, _ = buildEngine(store.NewMemoryStore())
+	if err := eng.(*engine.BasicEngine).ValidatePipeline(def); err == nil {
+		t.Fatalf("expected default-openai to be unresolvable with %s set", engine.DisableDefaultProfilesEnvVar)
+	}
+}
+
 func TestRegisterDemoPipelines(t *testing.T) {
 	fake := &fakeEngine{}
 	openID := engine.ProviderProfileID("openai-cli")
@@ -135,4 +196,18 @@ func TestRegisterDemoPipelines(t *testing.T) {
 	if len(expected) != 0 {
 		t.Fatalf("missing pipelines: %v", expected)
 	}
+
+	// The demos use the env-backed profiles only, so they keep working with
+	// PIPELINE_ENGINE_DISABLE_DEFAULT_PROFILES set.
+	for _, def := range fake.regs {
+		for _, step := range def.Steps {
+			profile := step.ProviderProfileID
+			if profile == "" {
+				profile = def.DefaultProviderProfileID
+			}
+			if profile != openID && profile != ollamaID {
+				t.Fatalf("demo pipeline %s step %s uses profile %q", def.Type, step.ID, profile)
+			}
+		}
+	}
 }
@@ -0,0 +1,37 @@
+package engine
+
+import (
+	"fmt"
+	"time"
+)
+
+// CancelSource identifies who or what cancelled a job.
+type CancelSource string
+
+const (
+	CancelByUser    CancelSource = "user"
+	CancelBySystem  CancelSource = "system"
+	CancelByTimeout CancelSource = "timeout"
+)
+
+// Cancellation records why a job was cancelled. It is stored on Job and
+// carried by the job_cancelled streaming event.
+type Cancellation struct {
+	By     CancelSource `json:"by"`
+	Code   string       `json:"code,omitempty"`
+	Reason string       `json:"reason,omitempty"`
+	At     time.Time    `json:"at"`
+	// Strict makes cancelling an already finished job fail with
+	// ErrJobTerminal instead of succeeding as a no-op. It is not stored.
+	Strict bool `json:"-"`
+}
+
+// Validate reports an unknown By value. An empty By defaults to user.
+func (c Cancellation) Validate() error {
+	switch c.By {
+	case "", CancelByUser, CancelBySystem, CancelByTimeout:
+		return nil
+	default:
+		return fmt.Errorf("cancellation by must be one of user, system or timeout: %q", c.By)
+	}
+}
@@ -0,0 +1,50 @@
+package engine
+
+// Event names emitted on job streams (NDJSON, WebSocket and the event log).
+const (
+	EventJobQueued      = "job_queued"
+	EventJobStarted     = "job_started"
+	EventJobStatus      = "job_status"
+	EventJobCompleted   = "job_completed"
+	EventJobFailed      = "job_failed"
+	EventJobCancelled   = "job_cancelled"
+	EventStepStarted    = "step_started"
+	EventStepCompleted  = "step_completed"
+	EventStepFailed     = "step_failed"
+	EventStepCancelled  = "step_cancelled"
+	EventStepSkipped    = "step_skipped"
+	EventProviderChunk  = "provider_chunk"
+	EventItemCompleted  = "item_completed"
+	EventStreamFinished = "stream_finished"
+	EventHeartbeat      = "heartbeat"
+	EventLogTruncated   = "log_truncated"
+	EventError          = "error"
+)
+
+// Capabilities lists what this engine build supports so clients can feature
+// detect instead of assuming a server version.
+type Capabilities struct {
+	JobModes      []string       `json:"job_modes"`
+	ProviderKinds []ProviderKind `json:"provider_kinds"`
+	StepKinds     []StepKind     `json:"step_kinds"`
+	StepModes     []StepMode     `json:"step_modes"`
+	ContentTypes  []ContentType  `json:"content_types"`
+	Events        []string       `json:"events"`
+}
+
+// SupportedCapabilities returns the built-in capabilities. Provider kinds
+// added with RegisterProviderFactory are not included.
+func SupportedCapabilities() Capabilities {
+	return Capabilities{
+		JobModes:      []string{ModeAsync, ModeSync, ModeDryRun},
+		ProviderKinds: []ProviderKind{ProviderOpenAI, ProviderOllama, ProviderImage, ProviderLocal},
+		StepKinds:     []StepKind{StepKindLLM, StepKindImage, StepKindMap, StepKindReduce, StepKindCustom, StepKindRetrieve},
+		StepModes:     []StepMode{StepModeSingle, StepModeFanOut, StepModePerItem},
+		ContentTypes:  []ContentType{ContentText, ContentMarkdown, ContentJSON, ContentImage, ContentEmbedding, ContentTable, ContentBinary},
+		Events: []string{
+			EventJobQueued, EventJobStarted, EventJobStatus, EventJobCompleted, EventJobFailed, EventJobCancelled,
+			EventStepStarted, EventStepCompleted, EventStepFailed, EventStepCancelled, EventStepSkipped,
+			EventProviderChunk, EventItemCompleted, EventStreamFinished, EventHeartbeat, EventLogTruncated, EventError,
+		},
+	}
+}
@@ -0,0 +1,52 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+)
+
+// validateHistory checks that every JobInput.History turn has a known role.
+func validateHistory(history []ChatMessage) error {
+	for i, msg := range history {
+		switch msg.Role {
+		case "system", "user", "assistant":
+		default:
+			return fmt.Errorf("history[%d]: unsupported role %q (expected system, user or assistant)", i, msg.Role)
+		}
+	}
+	return nil
+}
+
+// historyPromptMessages adapts history turns for the context window check.
+func historyPromptMessages(history []ChatMessage, messages []PromptMessage) []PromptMessage {
+	if len(history) == 0 {
+		return messages
+	}
+	all := make([]PromptMessage, 0, len(history)+len(messages))
+	for _, msg := range history {
+		all = append(all, PromptMessage(msg))
+	}
+	return append(all, messages...)
+}
+
+// foldHistory renders history turns as "role: content" lines ahead of the
+// prompt for completion-style providers without a messages array.
+func foldHistory(history []ChatMessage, prompt string) string {
+	if len(history) == 0 {
+		return prompt
+	}
+	var b strings.Builder
+	for _, msg := range history {
+		b.WriteString(msg.Role)
+		b.WriteString(": ")
+		b.WriteString(msg.Content)
+		b.WriteByte('\n')
+	}
+	if prompt != "" {
+		b.WriteString("user: ")
+		b.WriteString(prompt)
+		b.WriteByte('\n')
+	}
+	b.WriteString("assistant:")
+	return b.String()
+}
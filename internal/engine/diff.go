@@ -0,0 +1,246 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ItemDiffStatus tells how an exported item changed between two results.
+type ItemDiffStatus string
+
+const (
+	ItemAdded     ItemDiffStatus = "added"
+	ItemRemoved   ItemDiffStatus = "removed"
+	ItemChanged   ItemDiffStatus = "changed"
+	ItemUnchanged ItemDiffStatus = "unchanged"
+)
+
+// LineOp is the operation of one line in a text diff.
+type LineOp string
+
+const (
+	LineEqual  LineOp = "equal"
+	LineInsert LineOp = "insert"
+	LineDelete LineOp = "delete"
+)
+
+// LineDiff is one line of an item's text diff.
+type LineDiff struct {
+	Op   LineOp `json:"op"`
+	Text string `json:"text"`
+}
+
+// ItemDiff compares the items of two results that share a step, label and
+// shard key.
+type ItemDiff struct {
+	StepID   StepID         `json:"step_id"`
+	Label    string         `json:"label"`
+	ShardKey *string        `json:"shard_key,omitempty"`
+	Status   ItemDiffStatus `json:"status"`
+	// BaseItemID and ItemID are the IDs of the compared items; either is
+	// empty when the item exists on one side only.
+	BaseItemID string `json:"base_item_id,omitempty"`
+	ItemID     string `json:"item_id,omitempty"`
+	// Lines is the line diff of the items' text, set for changed items only.
+	Lines []LineDiff `json:"lines,omitempty"`
+}
+
+// ResultDiff is the item-by-item difference between a base result and a
+// newer one.
+type ResultDiff struct {
+	Items     []ItemDiff `json:"items"`
+	Added     int        `json:"added"`
+	Removed   int        `json:"removed"`
+	Changed   int        `json:"changed"`
+	Unchanged int        `json:"unchanged"`
+}
+
+// JobDiff is the result diff of a job against a related job.
+type JobDiff struct {
+	JobID     string `json:"job_id"`
+	AgainstID string `json:"against_id"`
+	ResultDiff
+}
+
+// maxLineDiffCells bounds the LCS table of a line diff; larger texts are
+// reported as a full replacement.
+const maxLineDiffCells = 1 << 22
+
+// DiffResults compares the items of base and next. Items are paired by step,
+// label and shard key, in order when several share them; either result may
+// be nil. Items are listed in next's order, followed by the removed ones.
+func DiffResults(base, next *JobResult) *ResultDiff {
+	var baseItems, nextItems []ResultItem
+	if base != nil {
+		baseItems = base.Items
+	}
+	if next != nil {
+		nextItems = next.Items
+	}
+	pending := map[string][]ResultItem{}
+	for _, item := range baseItems {
+		key := resultItemKey(item)
+		pending[key] = append(pending[key], item)
+	}
+	// matched counts the base items of each key paired with a next item;
+	// pairing is in order, so the rest of the key's items were removed.
+	matched := map[string]int{}
+
+	diff := &ResultDiff{Items: []ItemDiff{}}
+	for _, item := range nextItems {
+		entry := ItemDiff{StepID: item.StepID, Label: item.Label, ShardKey: item.ShardKey, ItemID: item.ID}
+		key := resultItemKey(item)
+		if matches := pending[key]; len(matches) > 0 {
+			prev := matches[0]
+			pending[key] = matches[1:]
+			matched[key]++
+			entry.BaseItemID = prev.ID
+			before, after := resultItemText(prev), resultItemText(item)
+			if before == after {
+				entry.Status = ItemUnchanged
+				diff.Unchanged++
+			} else {
+				entry.Status = ItemChanged
+				entry.Lines = diffLines(before, after)
+				diff.Changed++
+			}
+		} else {
+			entry.Status = ItemAdded
+			diff.Added++
+		}
+		diff.Items = append(diff.Items, entry)
+	}
+	seen := map[string]int{}
+	for _, item := range baseItems {
+		key := resultItemKey(item)
+		if seen[key]++; seen[key] <= matched[key] {
+			continue
+		}
+		diff.Items = append(diff.Items, ItemDiff{StepID: item.StepID, Label: item.Label, ShardKey: item.ShardKey, Status: ItemRemoved, BaseItemID: item.ID})
+		diff.Removed++
+	}
+	return diff
+}
+
+// DiffJobs diffs the result of jobID against againstID, which must belong to
+// the same rerun tree. An empty againstID diffs against the job's parent.
+func (e *BasicEngine) DiffJobs(ctx context.Context, jobID, againstID string) (*JobDiff, error) {
+	job, err := e.store.GetJob(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+	if againstID == "" {
+		if job.ParentJobID == nil {
+			return nil, fmt.Errorf("%w: job %s has no parent to diff against", ErrInvalidInput, jobID)
+		}
+		againstID = *job.ParentJobID
+	}
+	against, err := e.store.GetJob(ctx, againstID)
+	if err != nil {
+		return nil, err
+	}
+	if e.rerunRoot(ctx, job) != e.rerunRoot(ctx, against) {
+		return nil, fmt.Errorf("%w: jobs %s and %s are not reruns of one another", ErrInvalidInput, jobID, againstID)
+	}
+	return &JobDiff{JobID: job.ID, AgainstID: against.ID, ResultDiff: *DiffResults(against.Result, job.Result)}, nil
+}
+
+// rerunRoot returns the ID of the oldest stored ancestor of job.
+func (e *BasicEngine) rerunRoot(ctx context.Context, job *Job) string {
+	root := job.ID
+	seen := map[string]bool{job.ID: true}
+	for parentID := job.ParentJobID; parentID != nil && !seen[*parentID]; {
+		parent, err := e.store.GetJob(ctx, *parentID)
+		if err != nil {
+			break
+		}
+		seen[parent.ID] = true
+		root = parent.ID
+		parentID = parent.ParentJobID
+	}
+	return root
+}
+
+func resultItemKey(item ResultItem) string {
+	shard := ""
+	if item.ShardKey != nil {
+		shard = *item.ShardKey
+	}
+	return string(item.StepID) + "\x00" + item.Label + "\x00" + shard
+}
+
+// resultItemText renders an item for diffing: the generated text of text
+// items, so prompt and provider metadata do not show up as changes, other
+// data as indented JSON, and sink-exported items as their URI.
+func resultItemText(item ResultItem) string {
+	switch data := item.Data.(type) {
+	case nil:
+		return item.URI
+	case string:
+		return data
+	case map[string]any:
+		if text, ok := data["text"].(string); ok {
+			return text
+		}
+	}
+	raw, err := json.MarshalIndent(item.Data, "", "  ")
+	if err != nil {
+		return fmt.Sprint(item.Data)
+	}
+	return string(raw)
+}
+
+// diffLines returns a line diff of before and after based on their longest
+// common subsequence.
+func diffLines(before, after string) []LineDiff {
+	a, b := strings.Split(before, "\n"), strings.Split(after, "\n")
+	if len(a)*len(b) > maxLineDiffCells {
+		lines := make([]LineDiff, 0, len(a)+len(b))
+		for _, line := range a {
+			lines = append(lines, LineDiff{Op: LineDelete, Text: line})
+		}
+		for _, line := range b {
+			lines = append(lines, LineDiff{Op: LineInsert, Text: line})
+		}
+		return lines
+	}
+	// lcs[i][j] is the LCS length of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	lines := make([]LineDiff, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			lines = append(lines, LineDiff{Op: LineEqual, Text: a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, LineDiff{Op: LineDelete, Text: a[i]})
+			i++
+		default:
+			lines = append(lines, LineDiff{Op: LineInsert, Text: b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		lines = append(lines, LineDiff{Op: LineDelete, Text: a[i]})
+	}
+	for ; j < len(b); j++ {
+		lines = append(lines, LineDiff{Op: LineInsert, Text: b[j]})
+	}
+	return lines
+}
@@ -0,0 +1,74 @@
+package engine_test
+
+import (
+	"testing"
+
+	"github.com/example/pipeline-engine/internal/engine"
+)
+
+func TestDiffResultsPairsItemsByStepAndLabel(t *testing.T) {
+	shard := "a"
+	base := &engine.JobResult{Items: []engine.ResultItem{
+		{ID: "b1", StepID: "summary", Label: "summary", Data: map[string]any{"text": "一行目\n二行目\n三行目", "latency_ms": 120}},
+		{ID: "b2", StepID: "classify", Label: "classify", Data: map[string]any{"route": "faq"}},
+		{ID: "b3", StepID: "map", Label: "map#1", ShardKey: &shard, Data: "同じ"},
+		{ID: "b4", StepID: "dropped", Label: "dropped", Data: "消えた"},
+	}}
+	next := &engine.JobResult{Items: []engine.ResultItem{
+		{ID: "n1", StepID: "summary", Label: "summary", Data: map[string]any{"text": "一行目\n2 行目\n三行目", "latency_ms": 95}},
+		{ID: "n2", StepID: "classify", Label: "classify", Data: map[string]any{"route": "faq"}},
+		{ID: "n3", StepID: "map", Label: "map#1", ShardKey: &shard, Data: "同じ"},
+		{ID: "n4", StepID: "extra", Label: "extra", Data: "追加"},
+	}}
+
+	diff := engine.DiffResults(base, next)
+	if diff.Changed != 1 || diff.Unchanged != 2 || diff.Added != 1 || diff.Removed != 1 || len(diff.Items) != 5 {
+		t.Fatalf("差分の集計が想定外です: %+v", diff)
+	}
+	summary := diff.Items[0]
+	if summary.Status != engine.ItemChanged || summary.BaseItemID != "b1" || summary.ItemID != "n1" {
+		t.Fatalf("summary の差分が想定外です: %+v", summary)
+	}
+	want := []engine.LineDiff{
+		{Op: engine.LineEqual, Text: "一行目"},
+		{Op: engine.LineDelete, Text: "二行目"},
+		{Op: engine.LineInsert, Text: "2 行目"},
+		{Op: engine.LineEqual, Text: "三行目"},
+	}
+	if len(summary.Lines) != len(want) {
+		t.Fatalf("行差分が想定外です: %+v", summary.Lines)
+	}
+	for i := range want {
+		if summary.Lines[i] != want[i] {
+			t.Fatalf("%d 行目の差分が想定外です: %+v", i, summary.Lines[i])
+		}
+	}
+	if diff.Items[1].Status != engine.ItemUnchanged || diff.Items[1].Lines != nil {
+		t.Fatalf("JSON が同じ項目は unchanged になるはずです: %+v", diff.Items[1])
+	}
+	if diff.Items[3].Status != engine.ItemAdded || diff.Items[3].StepID != "extra" {
+		t.Fatalf("追加項目が想定外です: %+v", diff.Items[3])
+	}
+	if diff.Items[4].Status != engine.ItemRemoved || diff.Items[4].BaseItemID != "b4" {
+		t.Fatalf("削除項目が想定外です: %+v", diff.Items[4])
+	}
+}
+
+func TestDiffResultsPairsRepeatedLabelsInOrder(t *testing.T) {
+	base := &engine.JobResult{Items: []engine.ResultItem{
+		{ID: "b1", StepID: "fanout", Label: "fanout", Data: "x"},
+		{ID: "b2", StepID: "fanout", Label: "fanout", Data: "y"},
+	}}
+	next := &engine.JobResult{Items: []engine.ResultItem{
+		{ID: "n1", StepID: "fanout", Label: "fanout", Data: "x"},
+	}}
+	diff := engine.DiffResults(base, next)
+	if diff.Unchanged != 1 || diff.Removed != 1 || diff.Items[1].BaseItemID != "b2" {
+		t.Fatalf("同じラベルの項目は順に対応付けるはずです: %+v", diff)
+	}
+
+	diff = engine.DiffResults(nil, next)
+	if diff.Added != 1 || len(diff.Items) != 1 {
+		t.Fatalf("結果のないジョブとの差分は追加のみのはずです: %+v", diff)
+	}
+}
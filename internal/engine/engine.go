@@ -4,24 +4,72 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
+	"slices"
 	"strings"
 	"sync"
 	"text/template"
 	"time"
 
+	"github.com/example/pipeline-engine/pkg/logging"
 	"github.com/example/pipeline-engine/pkg/metrics"
 )
 
+// ErrPipelineVersionNotFound is returned when a JobRequest pins a pipeline
+// version that is not (or no longer) registered.
+var ErrPipelineVersionNotFound = errors.New("pipeline version not found")
+
+// ErrPipelineNotFound is returned in strict pipeline resolution mode when a
+// JobRequest names a pipeline type that is not registered.
+var ErrPipelineNotFound = errors.New("pipeline not found")
+
+// ErrStepNotFound is returned when a step ID does not belong to the job.
+var ErrStepNotFound = errors.New("step not found")
+
+// ErrStepNotRunning is returned when skipping a step that is not currently running.
+var ErrStepNotRunning = errors.New("step is not running")
+
+// ErrJobTerminal is returned by a strict cancellation of a job that has
+// already finished.
+var ErrJobTerminal = errors.New("job already finished")
+
+// ErrJobExists is returned by JobStore.CreateJob when the job ID is taken.
+// RunJob retries with a fresh ID up to maxJobIDAttempts times.
+var ErrJobExists = errors.New("job already exists")
+
+// maxJobIDAttempts bounds how many IDs RunJob generates for one job.
+const maxJobIDAttempts = 5
+
+// maxPipelineHistory bounds how many versions are retained per pipeline type.
+const maxPipelineHistory = 8
+
 // JobRequest represents the minimal payload required to start a job.
 type JobRequest struct {
-	PipelineType  PipelineType `json:"pipeline_type"`
-	Input         JobInput     `json:"input"`
-	Mode          string       `json:"mode,omitempty"`
-	ParentJobID   *string      `json:"parent_job_id,omitempty"`
-	FromStepID    *StepID      `json:"from_step_id,omitempty"`
-	ReuseUpstream bool         `json:"reuse_upstream,omitempty"`
+	PipelineType    PipelineType `json:"pipeline_type"`
+	PipelineVersion string       `json:"pipeline_version,omitempty"`
+	Input           JobInput     `json:"input"`
+	Mode            string       `json:"mode,omitempty"`
+	ParentJobID     *string      `json:"parent_job_id,omitempty"`
+	FromStepID      *StepID      `json:"from_step_id,omitempty"`
+	ReuseUpstream   bool         `json:"reuse_upstream,omitempty"`
+	ReuseSteps      []StepID     `json:"reuse_steps,omitempty"`
+	BatchID         string       `json:"batch_id,omitempty"`
+	ExportSink      string       `json:"export_sink,omitempty"`
+	Webhook         *Webhook     `json:"webhook,omitempty"`
+	// ExportSteps additionally exports the results of these steps for this
+	// job only, e.g. to inspect intermediate outputs while debugging.
+	ExportSteps []StepID `json:"export_steps,omitempty"`
+	// Seed overrides Input.Options.Seed. The effective seed is stored in the
+	// job's options so reruns reproduce it.
+	Seed *int64 `json:"seed,omitempty"`
+	// EphemeralProviders are provider profiles, typically carrying the
+	// caller's own API key, that this job's steps and fallbacks resolve
+	// before the registry. They live only in memory while the job runs and
+	// are never stored; the job records just their IDs.
+	EphemeralProviders []ProviderProfile `json:"ephemeral_providers,omitempty"`
 }
 
 // Engine is the contract exposed to consumers such as the HTTP server.
@@ -29,37 +77,153 @@ type Engine interface {
 	RunJob(ctx context.Context, req JobRequest) (*Job, error)
 	RunJobStream(ctx context.Context, req JobRequest) (<-chan StreamingEvent, *Job, error)
 	CancelJob(ctx context.Context, jobID string, reason string) error
+	CancelJobWithDetails(ctx context.Context, jobID string, cancellation Cancellation) error
 	GetJob(ctx context.Context, jobID string) (*Job, error)
+	ListJobs(ctx context.Context, filter JobFilter) ([]*Job, error)
+	JobLineage(ctx context.Context, jobID string) (*JobLineage, error)
+	DiffJobs(ctx context.Context, jobID, againstID string) (*JobDiff, error)
+	JobPipeline(ctx context.Context, jobID string) (*PipelineDef, error)
+	SkipStep(ctx context.Context, jobID string, stepID StepID) error
 	ListPipelines() []PipelineDef
+	LintPipeline(def PipelineDef) []Finding
+	NewBatchID() string
 	UpsertProviderProfile(profile ProviderProfile) error
+	RuntimeConfig() RuntimeConfig
+	UpdateRuntimeConfig(cfg RuntimeConfig) (RuntimeConfig, error)
 }
 
 // JobStore is the minimal persistence contract required by the engine.
+// Implementations backed by remote storage should honor ctx cancellation
+// and deadlines; the in-memory store ignores it. CreateJob should return an
+// error wrapping ErrJobExists when the ID is already taken.
 type JobStore interface {
-	CreateJob(job *Job) error
-	UpdateJob(job *Job) error
-	GetJob(id string) (*Job, error)
-	ListJobs() ([]*Job, error)
+	CreateJob(ctx context.Context, job *Job) error
+	UpdateJob(ctx context.Context, job *Job) error
+	GetJob(ctx context.Context, id string) (*Job, error)
+	ListJobs(ctx context.Context) ([]*Job, error)
 }
 
 // EngineConfig describes runtime configuration for the engine.
 type EngineConfig struct {
 	Providers []ProviderProfile
+	// DisableDefaultProfiles skips registering the built-in default-openai,
+	// default-ollama, default-image and default-local profiles, so only
+	// Providers and stored profiles resolve. Steps naming a default profile
+	// then fail with provider_unresolved.
+	DisableDefaultProfiles bool
+	// IDGenerator produces Job and ResultItem IDs. Plug in a time-ordered
+	// scheme such as ULID or UUIDv7 to make IDs sortable; nil keeps the
+	// default random hex IDs.
+	IDGenerator func() string
+	// HTTPTransport is shared by HTTP-backed providers (OpenAI, Ollama) to
+	// configure proxies, TLS and connection pooling. nil uses
+	// http.DefaultTransport. Per-profile timeouts come from Extra.timeout_ms.
+	HTTPTransport *http.Transport
+	// ProviderTimeout is the initial RuntimeConfig.ProviderTimeout; zero
+	// uses 30s.
+	ProviderTimeout time.Duration
+	// MaxConcurrency is the initial RuntimeConfig.MaxConcurrency; zero means
+	// unlimited.
+	MaxConcurrency int
+	// PipelineConcurrency caps the jobs of a pipeline type executing at
+	// once, like PipelineDef.MaxConcurrency; the lower of the two applies.
+	PipelineConcurrency map[PipelineType]int
+	// SimulatedLatency delays every call to the simulated providers (image,
+	// local and steps without a profile) to make demos look realistic. Real
+	// provider calls are never delayed. Zero, the default, disables it.
+	SimulatedLatency time.Duration
+	// CancelOnDisconnect is the initial RuntimeConfig.CancelOnDisconnect.
+	CancelOnDisconnect bool
+	// JobTTL enables a background sweeper that deletes terminal jobs and
+	// their checkpoints once they have not been updated for this long. Zero
+	// keeps jobs forever. The store must implement JobDeleter.
+	JobTTL time.Duration
+	// JobSweepInterval is how often the sweeper runs; zero uses one minute.
+	JobSweepInterval time.Duration
+	// StrictPipelineResolution makes RunJob reject unregistered pipeline
+	// types with ErrPipelineNotFound instead of running a generated
+	// single-step LLM pipeline.
+	StrictPipelineResolution bool
+	// ExportPrompts keeps the rendered data.prompt on exported result items.
+	// By default it is stripped from Job.Result; checkpoints used for reruns
+	// always keep it. StepDef.ExportPrompt enables it per step.
+	ExportPrompts bool
+	// MaxFanOut caps the sources a fan-out step processes (see
+	// limitFanOut); zero means unlimited. Steps can lower it with
+	// Config["max_fan_out"].
+	MaxFanOut int
+	// ShardConcurrency is how many shards of a fan-out or per_item step call
+	// the provider at once; zero or one runs them sequentially. Steps
+	// override it with Config["shard_concurrency"].
+	ShardConcurrency int
+	// ExportSinks are registered by name as with RegisterExportSink.
+	ExportSinks map[string]ExportSink
+	// ProfileStore persists profiles upserted through UpsertProviderProfile
+	// and is loaded on construction, after Providers. nil keeps runtime
+	// profiles in memory only.
+	ProfileStore ProfileStore
+	// WebhookAllowedHosts lists webhook hosts (names or IP literals) that may
+	// resolve to loopback, private or link-local addresses. Webhooks to any
+	// other such address are refused.
+	WebhookAllowedHosts []string
 }
 
 // BasicEngine is a naive single-node engine implementation intended for the v0 milestone.
 type BasicEngine struct {
 	store        JobStore
 	checkpoint   StepCheckpointStore
+	snapshots    PipelineSnapshotStore
 	cancels      map[string]context.CancelFunc
+	stepRuns     map[string]*stepRun
 	mu           sync.Mutex
 	pipelineMu   sync.RWMutex
 	pipelines    map[PipelineType]*PipelineDef
+	pipelineHist map[PipelineType][]*PipelineDef
 	jobPipeline  map[string]*PipelineDef
+	jobSources   map[string][]Source
+	// jobSnapshots holds the per-job PipelineDef snapshots when the store
+	// is not a PipelineSnapshotStore. Unlike jobPipeline it outlives the run.
+	jobSnapshots map[string]*PipelineDef
 	jobPipeMu    sync.RWMutex
 	checkpointMu sync.RWMutex
 	checkpoints  map[string]map[StepID][]ResultItem
 	providers    *ProviderRegistry
+	profileStore ProfileStore
+	httpClients  *providerHTTPClients
+	hookClient   *http.Client
+	// hookHosts are the WebhookAllowedHosts, lowercased. hookCtx is
+	// cancelled by Close to stop webhook watchers, which hookWG tracks;
+	// hookMu keeps new watchers from starting once it is cancelled.
+	hookHosts    map[string]bool
+	hookCtx      context.Context
+	hookCancel   context.CancelFunc
+	hookMu       sync.Mutex
+	hookWG       sync.WaitGroup
+	idGenerator  func() string
+	runtimeMu    sync.RWMutex
+	runtime      RuntimeConfig
+	slotMu       sync.Mutex
+	runningJobs  int
+	runningTypes map[PipelineType]int
+	simLatency   time.Duration
+	typeLimits   map[PipelineType]int
+	slotWake     chan struct{}
+	// startMu serializes the queued→running transition in executeJob with
+	// CancelJobWithDetails so a job cancelled while queued never starts.
+	startMu      sync.Mutex
+	sweepStop    chan struct{}
+	sweepDone    chan struct{}
+	closeOnce    sync.Once
+	strictPipes  bool
+	exportPrompt bool
+	maxFanOut    int
+	shardLimit   int
+	toolMu       sync.RWMutex
+	toolHandlers map[string]StepHandler
+	// exportSinks holds named result sinks; guarded by toolMu.
+	exportSinks map[string]ExportSink
+	// postProcs holds custom transforms; guarded by toolMu.
+	postProcs map[string]PostProcessor
 }
 
 // NewBasicEngine returns an Engine implementation backed by the provided store.
@@ -70,54 +234,175 @@ func NewBasicEngine(store JobStore) *BasicEngine {
 // NewBasicEngineWithConfig wires the engine with the provided configuration.
 func NewBasicEngineWithConfig(store JobStore, cfg *EngineConfig) *BasicEngine {
 	reg := NewProviderRegistry()
-	RegisterDefaultProviderFactories(reg)
-	for _, profile := range defaultProviderProfiles() {
-		reg.RegisterProfile(profile)
+	var transport *http.Transport
+	if cfg != nil {
+		transport = cfg.HTTPTransport
+	}
+	httpClients := newProviderHTTPClients(transport)
+	registerDefaultProviderFactories(reg, httpClients)
+	if cfg == nil || !cfg.DisableDefaultProfiles {
+		for _, profile := range defaultProviderProfiles() {
+			reg.RegisterProfile(profile)
+		}
 	}
+	idGenerator := generateID
+	runtime := RuntimeConfig{ProviderTimeout: defaultProviderTimeout}
 	if cfg != nil {
 		for _, profile := range cfg.Providers {
 			reg.RegisterProfile(profile)
 		}
+		if cfg.IDGenerator != nil {
+			idGenerator = cfg.IDGenerator
+		}
+		if cfg.ProviderTimeout > 0 {
+			runtime.ProviderTimeout = cfg.ProviderTimeout
+			httpClients.setDefaultTimeout(cfg.ProviderTimeout)
+		}
+		if cfg.MaxConcurrency > 0 {
+			runtime.MaxConcurrency = cfg.MaxConcurrency
+		}
+		runtime.CancelOnDisconnect = cfg.CancelOnDisconnect
+		if cfg.ProfileStore != nil {
+			loadStoredProfiles(reg, cfg.ProfileStore)
+		}
 	}
 
-	return &BasicEngine{
-		store:       store,
-		checkpoint:  detectCheckpointStore(store),
-		cancels:     map[string]context.CancelFunc{},
-		pipelines:   map[PipelineType]*PipelineDef{},
-		jobPipeline: map[string]*PipelineDef{},
-		checkpoints: map[string]map[StepID][]ResultItem{},
-		providers:   reg,
+	eng := &BasicEngine{
+		store:        store,
+		checkpoint:   detectCheckpointStore(store),
+		snapshots:    detectPipelineSnapshotStore(store),
+		cancels:      map[string]context.CancelFunc{},
+		stepRuns:     map[string]*stepRun{},
+		pipelines:    map[PipelineType]*PipelineDef{},
+		pipelineHist: map[PipelineType][]*PipelineDef{},
+		jobPipeline:  map[string]*PipelineDef{},
+		jobSources:   map[string][]Source{},
+		jobSnapshots: map[string]*PipelineDef{},
+		checkpoints:  map[string]map[StepID][]ResultItem{},
+		runningTypes: map[PipelineType]int{},
+		typeLimits:   map[PipelineType]int{},
+		toolHandlers: map[string]StepHandler{},
+		postProcs:    map[string]PostProcessor{},
+		exportSinks:  map[string]ExportSink{},
+		providers:    reg,
+		httpClients:  httpClients,
+		hookHosts:    map[string]bool{},
+		idGenerator:  idGenerator,
+		runtime:      runtime,
 	}
+	if cfg != nil {
+		eng.strictPipes = cfg.StrictPipelineResolution
+		eng.exportPrompt = cfg.ExportPrompts
+		eng.maxFanOut = cfg.MaxFanOut
+		eng.shardLimit = cfg.ShardConcurrency
+		eng.profileStore = cfg.ProfileStore
+		eng.simLatency = cfg.SimulatedLatency
+		for pt, limit := range cfg.PipelineConcurrency {
+			eng.typeLimits[pt] = limit
+		}
+		for name, sink := range cfg.ExportSinks {
+			eng.RegisterExportSink(name, sink)
+		}
+		for _, host := range cfg.WebhookAllowedHosts {
+			eng.hookHosts[normalizeWebhookHost(host)] = true
+		}
+	}
+	eng.hookClient = eng.newWebhookClient(transport)
+	eng.hookCtx, eng.hookCancel = context.WithCancel(context.Background())
+	if cfg != nil && cfg.JobTTL > 0 {
+		eng.startJobSweeper(cfg.JobTTL, cfg.JobSweepInterval)
+	}
+	return eng
 }
 
-// RegisterPipeline registers or replaces a pipeline definition.
+// RegisterPipeline registers a pipeline definition as the latest version of its
+// type. Earlier versions stay available for pinned jobs; registering an existing
+// version replaces it.
 func (e *BasicEngine) RegisterPipeline(def PipelineDef) {
 	if def.Type == "" {
 		return
 	}
+	if err := e.ValidatePipeline(def); err != nil {
+		logging.Warnf("pipeline %s %s has configuration problems: %v", def.Type, def.Version, err)
+	}
 	e.pipelineMu.Lock()
 	defer e.pipelineMu.Unlock()
-	e.pipelines[def.Type] = clonePipeline(&def)
+	cloned := clonePipeline(&def)
+	e.pipelines[def.Type] = cloned
+
+	history := e.pipelineHist[def.Type]
+	filtered := make([]*PipelineDef, 0, len(history)+1)
+	for _, prev := range history {
+		if prev.Version != cloned.Version {
+			filtered = append(filtered, prev)
+		}
+	}
+	filtered = append(filtered, cloned)
+	if len(filtered) > maxPipelineHistory {
+		filtered = filtered[len(filtered)-maxPipelineHistory:]
+	}
+	e.pipelineHist[def.Type] = filtered
 }
 
-// RunJob creates a new job and schedules it for asynchronous execution.
+// Job modes accepted in JobRequest.Mode. ModeAsync is the default.
+const (
+	ModeAsync = "async"
+	ModeSync  = "sync"
+	// ModeDryRun runs every step with rendered prompts and resolved providers
+	// but substitutes stub output for provider calls.
+	ModeDryRun = "dry_run"
+)
+
+// RunJob creates a new job and schedules it for asynchronous execution. Sync
+// and dry-run jobs run before RunJob returns and are cancelled when ctx ends.
 func (e *BasicEngine) RunJob(ctx context.Context, req JobRequest) (*Job, error) {
 	if req.PipelineType == "" {
 		return nil, errors.New("pipeline_type is required")
 	}
+	if err := validateSources(req.Input.Sources); err != nil {
+		return nil, err
+	}
+	if err := validateHistory(req.Input.History); err != nil {
+		return nil, err
+	}
+	if err := validateTruncationStrategy(req.Input.Options); err != nil {
+		return nil, err
+	}
 
 	mode := req.Mode
 	if mode == "" {
-		mode = "async"
+		mode = ModeAsync
 	}
 
-	pipeline := e.pipelineForType(req.PipelineType)
+	pipeline, err := e.requestPipeline(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRequiredSources(pipeline, req.Input.Sources); err != nil {
+		return nil, err
+	}
 	if req.FromStepID != nil {
 		if idx := findStepIndex(pipeline.Steps, *req.FromStepID); idx == -1 {
 			return nil, fmt.Errorf("step %s not found in pipeline", *req.FromStepID)
 		}
 	}
+	if err := validateReuseSteps(pipeline, req); err != nil {
+		return nil, err
+	}
+	if err := validateExportSteps(pipeline, req.ExportSteps); err != nil {
+		return nil, err
+	}
+	exportSink, err := e.resolveExportSink(pipeline, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.validateWebhook(req.Webhook); err != nil {
+		return nil, err
+	}
+	ephemeral, err := e.ephemeralProfiles(req.EphemeralProviders)
+	if err != nil {
+		return nil, err
+	}
 
 	stepExecs := make([]StepExecution, len(pipeline.Steps))
 	for i, step := range pipeline.Steps {
@@ -127,9 +412,13 @@ func (e *BasicEngine) RunJob(ctx context.Context, req JobRequest) (*Job, error)
 		stepExecs = []StepExecution{{StepID: StepID("step-1"), Status: StepExecPending}}
 	}
 
+	if req.Seed != nil {
+		req.Input = withSeed(req.Input, *req.Seed)
+	}
+
 	now := time.Now().UTC()
 	job := &Job{
-		ID:              generateID(),
+		ID:              e.idGenerator(),
 		PipelineType:    req.PipelineType,
 		PipelineVersion: pipeline.Version,
 		Status:          JobStatusQueued,
@@ -140,38 +429,102 @@ func (e *BasicEngine) RunJob(ctx context.Context, req JobRequest) (*Job, error)
 		ParentJobID:     req.ParentJobID,
 		RerunFromStep:   req.FromStepID,
 		ReuseUpstream:   req.ReuseUpstream,
+		ReuseSteps:      append([]StepID(nil), req.ReuseSteps...),
+		BatchID:         req.BatchID,
+		ExportSink:      exportSink,
+		ExportSteps:     append([]StepID(nil), req.ExportSteps...),
+		Webhook:         req.Webhook,
 		StepExecutions:  stepExecs,
 	}
 
-	e.cacheJobPipeline(job.ID, pipeline)
+	job.EphemeralProfiles = profileIDs(ephemeral)
 
-	if err := e.store.CreateJob(job); err != nil {
-		e.removeJobPipeline(job.ID)
+	// Sensitive sources only live in memory until the job finishes.
+	job.Input.Sources = redactSources(req.Input.Sources)
+	if err := e.createJob(ctx, job); err != nil {
 		return nil, err
 	}
+	e.cacheJobPipeline(job.ID, pipeline)
+	e.cacheJobSources(job.ID, req.Input.Sources)
+	e.savePipelineSnapshot(job.ID, pipeline)
+	if job.Webhook != nil {
+		queued := *job
+		e.startWebhook(&queued)
+	}
 
-	jobCtx, cancel := context.WithCancel(context.Background())
-	e.setCancel(job.ID, cancel)
-
-	if mode == "sync" {
-		e.executeJob(jobCtx, job.ID)
-		cancel()
-		finalJob, err := e.store.GetJob(job.ID)
-		if err != nil {
-			return nil, err
-		}
-		return finalJob, nil
+	// Dry runs never call providers, so they finish quickly and are always
+	// executed synchronously to return the rendered prompts.
+	if mode == ModeSync || mode == ModeDryRun {
+		return e.runJobSync(withEphemeralProviders(ctx, ephemeral), job.ID, job.PipelineType)
 	}
 
+	// Async jobs outlive the creating request, so they are detached from ctx.
+	jobCtx, cancel := context.WithCancel(withEphemeralProviders(context.Background(), ephemeral))
+	e.setCancel(job.ID, cancel)
 	go func() {
 		defer cancel()
+		if !e.acquireJobSlot(jobCtx, job.PipelineType) {
+			return
+		}
+		defer e.releaseJobSlot(job.PipelineType)
 		e.executeJob(jobCtx, job.ID)
 	}()
 
 	return job, nil
 }
 
-// RunJobStream starts a job and returns a channel that emits status updates.
+// withSeed returns input with Options.Seed set, copying the options so the
+// caller's request is left untouched.
+func withSeed(input JobInput, seed int64) JobInput {
+	var opts JobOptions
+	if input.Options != nil {
+		opts = *input.Options
+	}
+	opts.Seed = &seed
+	input.Options = &opts
+	return input
+}
+
+// runJobSync executes a sync or dry-run job on the caller's goroutine. Its
+// context derives from ctx, so a caller that gives up (e.g. an HTTP client
+// that disconnects) cancels the job instead of leaving it running unobserved.
+func (e *BasicEngine) runJobSync(ctx context.Context, jobID string, pt PipelineType) (*Job, error) {
+	jobCtx, cancel := context.WithCancel(ctx)
+	e.setCancel(jobID, cancel)
+	if e.acquireJobSlot(jobCtx, pt) {
+		e.executeJob(jobCtx, jobID)
+		e.releaseJobSlot(pt)
+	}
+	cancel()
+
+	// executeJob leaves the stored job as is when its context ends, so the
+	// cancellation is recorded here; finished jobs are left untouched.
+	storeCtx := context.WithoutCancel(ctx)
+	if ctx.Err() != nil {
+		cancellation := Cancellation{By: CancelBySystem, Code: "request_cancelled", Reason: "sync request cancelled"}
+		if err := e.CancelJobWithDetails(storeCtx, jobID, cancellation); err != nil {
+			logging.Warnf("failed to cancel job %s after its request ended: %v", jobID, err)
+		}
+	}
+	return e.store.GetJob(storeCtx, jobID)
+}
+
+// createJob stores job, regenerating its ID when the store reports a
+// collision.
+func (e *BasicEngine) createJob(ctx context.Context, job *Job) error {
+	for attempt := 1; ; attempt++ {
+		err := e.store.CreateJob(ctx, job)
+		if !errors.Is(err, ErrJobExists) || attempt >= maxJobIDAttempts {
+			return err
+		}
+		logging.Warnf("job id %s already exists; generating a new one", job.ID)
+		job.ID = e.idGenerator()
+	}
+}
+
+// RunJobStream starts a job and returns a channel that emits status updates,
+// starting with job_queued. The stream stops when ctx ends unless
+// RuntimeConfig.CancelOnDisconnect is set; then ending ctx cancels the job.
 func (e *BasicEngine) RunJobStream(ctx context.Context, req JobRequest) (<-chan StreamingEvent, *Job, error) {
 	job, err := e.RunJob(ctx, req)
 	if err != nil {
@@ -179,23 +532,68 @@ func (e *BasicEngine) RunJobStream(ctx context.Context, req JobRequest) (<-chan
 	}
 
 	events := make(chan StreamingEvent)
-	go e.streamJob(ctx, events, job.ID)
+	if !e.RuntimeConfig().CancelOnDisconnect {
+		go e.streamJob(ctx, events, job)
+		return events, job, nil
+	}
+	// The stream outlives ctx so the resulting job_cancelled event is still
+	// delivered.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		e.streamJob(context.WithoutCancel(ctx), events, job)
+	}()
+	go e.cancelOnDisconnect(ctx, done, job.ID)
 	return events, job, nil
 }
 
-// CancelJob attempts to cancel a running or queued job.
+// cancelOnDisconnect cancels the job when ctx ends before its stream is done.
+func (e *BasicEngine) cancelOnDisconnect(ctx context.Context, done <-chan struct{}, jobID string) {
+	select {
+	case <-done:
+	case <-ctx.Done():
+		cancellation := Cancellation{By: CancelBySystem, Code: "client_disconnected", Reason: "stream client disconnected"}
+		if err := e.CancelJobWithDetails(context.Background(), jobID, cancellation); err != nil {
+			logging.Warnf("failed to cancel job %s after client disconnect: %v", jobID, err)
+		}
+	}
+}
+
+// CancelJob cancels a queued or running job on behalf of a user with a
+// free-form reason.
 func (e *BasicEngine) CancelJob(ctx context.Context, jobID string, reason string) error {
-	job, err := e.store.GetJob(jobID)
+	return e.CancelJobWithDetails(ctx, jobID, Cancellation{By: CancelByUser, Reason: reason})
+}
+
+// CancelJobWithDetails cancels a job and records the structured cancellation
+// on Job.Cancellation. JobError keeps the reason for older clients. Cancelling
+// a finished job is a no-op unless cancellation.Strict is set, in which case
+// it fails with ErrJobTerminal.
+func (e *BasicEngine) CancelJobWithDetails(ctx context.Context, jobID string, cancellation Cancellation) error {
+	if err := cancellation.Validate(); err != nil {
+		return err
+	}
+	e.startMu.Lock()
+	defer e.startMu.Unlock()
+	job, err := e.store.GetJob(ctx, jobID)
 	if err != nil {
 		return err
 	}
 
 	if isTerminal(job.Status) {
+		if cancellation.Strict {
+			return fmt.Errorf("%w: job %s is %s", ErrJobTerminal, jobID, job.Status)
+		}
 		return nil
 	}
 
+	cancellation.Strict = false
+	if cancellation.By == "" {
+		cancellation.By = CancelByUser
+	}
+	reason := cancellation.Reason
 	if reason == "" {
-		reason = "cancelled by user"
+		reason = fmt.Sprintf("cancelled by %s", cancellation.By)
 	}
 
 	cancel := e.getCancel(jobID)
@@ -204,8 +602,10 @@ func (e *BasicEngine) CancelJob(ctx context.Context, jobID string, reason string
 	}
 
 	now := time.Now().UTC()
+	cancellation.At = now
 	job.Status = JobStatusCancelled
 	job.Error = &JobError{Code: "cancelled", Message: reason}
+	job.Cancellation = &cancellation
 	job.UpdatedAt = now
 
 	for i := range job.StepExecutions {
@@ -215,31 +615,81 @@ func (e *BasicEngine) CancelJob(ctx context.Context, jobID string, reason string
 		}
 	}
 
-	if err := e.store.UpdateJob(job); err != nil {
+	if err := e.saveJob(ctx, job); err != nil {
 		return err
 	}
+	metrics.ObserveJobOutcome(string(job.PipelineType), string(JobStatusCancelled), now.Sub(job.CreatedAt))
 
 	e.clearCancel(jobID)
 	return nil
 }
 
+// SkipStep cancels the provider call of a currently running step and marks it
+// skipped. The job keeps running; steps depending on the skipped step see an
+// empty output for it.
+func (e *BasicEngine) SkipStep(ctx context.Context, jobID string, stepID StepID) error {
+	job, err := e.store.GetJob(ctx, jobID)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, exec := range job.StepExecutions {
+		if exec.StepID == stepID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("%w: %s", ErrStepNotFound, stepID)
+	}
+
+	e.mu.Lock()
+	run := e.stepRuns[jobID]
+	if run == nil || run.stepID != stepID {
+		e.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrStepNotRunning, stepID)
+	}
+	run.skipped = true
+	cancel := run.cancel
+	e.mu.Unlock()
+
+	cancel()
+	return nil
+}
+
 // GetJob loads a job from the backing store.
 func (e *BasicEngine) GetJob(ctx context.Context, jobID string) (*Job, error) {
-	return e.store.GetJob(jobID)
+	return e.store.GetJob(ctx, jobID)
 }
 
 func (e *BasicEngine) executeJob(ctx context.Context, jobID string) {
 	defer e.clearCancel(jobID)
 	defer e.removeJobPipeline(jobID)
+	defer e.removeJobSources(jobID)
 
-	job, err := e.store.GetJob(jobID)
-	if err != nil {
+	e.startMu.Lock()
+	job, err := e.store.GetJob(ctx, jobID)
+	// A job cancelled while queued keeps its cancelled state and never runs.
+	if err != nil || isTerminal(job.Status) || ctx.Err() != nil {
+		e.startMu.Unlock()
 		return
 	}
+	if sources := e.loadJobSources(jobID); sources != nil {
+		job.Input.Sources = sources
+	}
 
 	pipeline := e.loadJobPipeline(jobID)
 	if pipeline == nil {
-		pipeline = e.pipelineForType(job.PipelineType)
+		pipeline = e.loadPipelineSnapshot(jobID)
+	}
+	if pipeline == nil {
+		if pinned, err := e.pipelineForVersion(job.PipelineType, job.PipelineVersion); err == nil {
+			pipeline = pinned
+		} else if latest, err := e.pipelineForType(job.PipelineType); err == nil {
+			pipeline = latest
+		} else {
+			pipeline = defaultPipeline(job.PipelineType)
+		}
 	}
 	if len(pipeline.Steps) == 0 {
 		pipeline.Steps = []StepDef{
@@ -256,30 +706,23 @@ func (e *BasicEngine) executeJob(ctx context.Context, jobID string) {
 
 	stepOutputs := make(map[StepID][]ResultItem)
 	startIndex := findStartIndex(pipeline, job.RerunFromStep)
-	if job.ReuseUpstream && job.ParentJobID != nil {
-		if reused := e.loadCheckpoints(*job.ParentJobID); len(reused) > 0 {
-			for idx := 0; idx < startIndex && idx < len(pipeline.Steps); idx++ {
-				step := pipeline.Steps[idx]
-				if items, ok := reused[step.ID]; ok {
-					stepOutputs[step.ID] = cloneResultItems(items)
-					job.StepExecutions[idx].Status = StepExecSkipped
-					if step.Export {
-						appendExportedResults(job, items)
-					}
-				}
-			}
-		}
+	reusedSteps := e.restoreCheckpoints(ctx, job, pipeline, startIndex, stepOutputs)
+	if job.Status == JobStatusFailed {
+		e.startMu.Unlock()
+		return
 	}
 
 	now := time.Now().UTC()
 	job.Status = JobStatusRunning
 	job.UpdatedAt = now
-	if err := e.store.UpdateJob(job); err != nil {
+	err = e.saveJob(ctx, job)
+	e.startMu.Unlock()
+	if err != nil {
 		return
 	}
 
 	for idx, step := range pipeline.Steps {
-		if job.ReuseUpstream && idx < startIndex {
+		if reusedSteps[idx] {
 			continue
 		}
 
@@ -290,25 +733,66 @@ func (e *BasicEngine) executeJob(ctx context.Context, jobID string) {
 		}
 
 		if err := ensureDependencies(step, stepOutputs); err != nil {
-			e.failStep(job, idx, "missing_dependency", err.Error())
+			e.failStep(ctx, job, idx, "missing_dependency", err.Error(), nil)
 			return
 		}
+		if job.Mode != ModeDryRun {
+			if _, err := resolveInputMapping(step, stepOutputs); err != nil {
+				e.failStep(ctx, job, idx, ErrCodeInputMapping, err.Error(), nil)
+				return
+			}
+		}
 
 		start := time.Now().UTC()
 		job.StepExecutions[idx].Status = StepExecRunning
 		job.StepExecutions[idx].StartedAt = ptrTime(start)
-		if err := e.store.UpdateJob(job); err != nil {
+		if err := e.saveJob(ctx, job); err != nil {
 			return
 		}
 
 		prompt := buildPrompt(step, job, stepOutputs)
-		items, execErr := e.runStep(ctx, job, idx, step, prompt, stepOutputs)
+		if job.Mode == ModeDryRun {
+			job.StepExecutions[idx].Prompt = prompt
+		}
+		exportedBefore := exportedItemCount(job)
+		stepCtx, stepCancel := stepContext(ctx, step)
+		e.setStepRun(job.ID, step.ID, stepCancel)
+		items, execErr := e.runStep(stepCtx, job, idx, step, prompt, stepOutputs)
+		skipped := e.clearStepRun(job.ID)
+		if execErr != nil {
+			execErr = stepTimeoutError(stepCtx, step, execErr)
+		}
+		stepCancel()
+		if skipped && ctx.Err() == nil {
+			// A skipped step contributes an empty output so dependants still
+			// run, and drops the shards it already exported so Job.Result
+			// agrees with it.
+			if job.Result != nil {
+				job.Result.Items = job.Result.Items[:exportedBefore]
+			}
+			finish := time.Now().UTC()
+			job.StepExecutions[idx].Status = StepExecSkipped
+			job.StepExecutions[idx].FinishedAt = ptrTime(finish)
+			job.StepExecutions[idx].Error = nil
+			job.UpdatedAt = finish
+			stepOutputs[step.ID] = []ResultItem{}
+			if err := e.saveJob(ctx, job); err != nil {
+				return
+			}
+			continue
+		}
 		if execErr != nil {
-			code := "step_failed"
-			if errors.Is(execErr, context.Canceled) {
-				code = "cancelled"
+			if ctx.Err() != nil {
+				// CancelJobWithDetails stores the cancelled job; only what
+				// the step streamed so far is added to it.
+				e.keepPartialOutput(ctx, job, idx, step, items)
+				return
 			}
-			e.failStep(job, idx, code, execErr.Error())
+			e.failStep(ctx, job, idx, stepErrorCode(execErr), execErr.Error(), stepErrorDetails(execErr))
+			return
+		}
+		if ctx.Err() != nil {
+			// The step ignored the cancellation; keep the stored cancelled job.
 			return
 		}
 
@@ -318,27 +802,39 @@ func (e *BasicEngine) executeJob(ctx context.Context, jobID string) {
 		job.StepExecutions[idx].Error = nil
 		job.UpdatedAt = finish
 		stepOutputs[step.ID] = items
-		e.saveCheckpoint(job.ID, step.ID, items)
-		appendExportedResultsForStep(job, step, items)
-		if err := e.store.UpdateJob(job); err != nil {
+		e.saveCheckpoint(job.ID, step.ID, secretsOf(job.Input.Sources).scrubItems(items))
+		// Fan-out and per-item shards were exported as they completed; only
+		// the remainder is appended here.
+		if exported := exportedItemCount(job) - exportedBefore; exported < len(items) {
+			if err := e.appendExportedResults(ctx, job, step, items[exported:]); err != nil {
+				e.failStep(ctx, job, idx, stepErrorCode(err), err.Error(), stepErrorDetails(err))
+				return
+			}
+		}
+		if err := e.saveJob(ctx, job); err != nil {
 			return
 		}
 	}
 
 	job.Status = JobStatusSucceeded
 	job.UpdatedAt = time.Now().UTC()
-	_ = e.store.UpdateJob(job)
+	_ = e.saveJob(ctx, job)
+	metrics.ObserveJobOutcome(string(job.PipelineType), string(job.Status), job.UpdatedAt.Sub(job.CreatedAt))
 }
 
-func (e *BasicEngine) streamJob(ctx context.Context, ch chan<- StreamingEvent, jobID string) {
+func (e *BasicEngine) streamJob(ctx context.Context, ch chan<- StreamingEvent, queued *Job) {
 	defer close(ch)
 	ticker := time.NewTicker(250 * time.Millisecond)
 	defer ticker.Stop()
 
+	jobID := queued.ID
 	tracker := NewStreamingTracker()
+	for _, event := range tracker.Queued(queued) {
+		ch <- event
+	}
 	var lastStatus JobStatus
 	for {
-		job, err := e.store.GetJob(jobID)
+		job, err := e.store.GetJob(ctx, jobID)
 		if err != nil {
 			ch <- StreamingEvent{Event: "error", JobID: jobID, Data: err.Error()}
 			return
@@ -381,6 +877,28 @@ func (e *BasicEngine) clearCancel(jobID string) {
 	delete(e.cancels, jobID)
 }
 
+// stepRun tracks the step currently executing for a job so it can be skipped.
+type stepRun struct {
+	stepID  StepID
+	cancel  context.CancelFunc
+	skipped bool
+}
+
+func (e *BasicEngine) setStepRun(jobID string, stepID StepID, cancel context.CancelFunc) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.stepRuns[jobID] = &stepRun{stepID: stepID, cancel: cancel}
+}
+
+// clearStepRun forgets the running step and reports whether it was skipped.
+func (e *BasicEngine) clearStepRun(jobID string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	run := e.stepRuns[jobID]
+	delete(e.stepRuns, jobID)
+	return run != nil && run.skipped
+}
+
 func (e *BasicEngine) cacheJobPipeline(jobID string, def *PipelineDef) {
 	if def == nil {
 		return
@@ -399,6 +917,29 @@ func (e *BasicEngine) loadJobPipeline(jobID string) *PipelineDef {
 	return nil
 }
 
+// cacheJobSources keeps the raw input of a job with sensitive sources for
+// executeJob; the stored job only has the redacted copy.
+func (e *BasicEngine) cacheJobSources(jobID string, sources []Source) {
+	if !hasSensitiveSource(sources) {
+		return
+	}
+	e.jobPipeMu.Lock()
+	defer e.jobPipeMu.Unlock()
+	e.jobSources[jobID] = append([]Source(nil), sources...)
+}
+
+func (e *BasicEngine) loadJobSources(jobID string) []Source {
+	e.jobPipeMu.RLock()
+	defer e.jobPipeMu.RUnlock()
+	return e.jobSources[jobID]
+}
+
+func (e *BasicEngine) removeJobSources(jobID string) {
+	e.jobPipeMu.Lock()
+	defer e.jobPipeMu.Unlock()
+	delete(e.jobSources, jobID)
+}
+
 func (e *BasicEngine) removeJobPipeline(jobID string) {
 	e.jobPipeMu.Lock()
 	defer e.jobPipeMu.Unlock()
@@ -417,15 +958,41 @@ func (e *BasicEngine) ListPipelines() []PipelineDef {
 	return defs
 }
 
+// UpsertProviderProfile registers or replaces a profile. The kind is
+// normalized and must have a registered factory (*ProviderKindError). With a
+// ProfileStore configured the profile is persisted before it is registered.
 func (e *BasicEngine) UpsertProviderProfile(profile ProviderProfile) error {
 	if e.providers == nil {
 		e.providers = NewProviderRegistry()
 		RegisterDefaultProviderFactories(e.providers)
 	}
-	e.providers.RegisterProfile(profile)
+	// An empty kind keeps defaulting to local_tool in RegisterProfile.
+	if NormalizeProviderKind(profile.Kind) != "" {
+		if err := e.providers.CheckKind(profile.Kind); err != nil {
+			return err
+		}
+	}
+	profile.Kind = NormalizeProviderKind(profile.Kind)
+	if e.profileStore != nil {
+		if err := e.profileStore.SaveProfile(profile); err != nil {
+			return fmt.Errorf("persist provider profile %s: %w", profile.ID, err)
+		}
+	}
+	e.providers.RegisterProfile(resolveProfileAPIKey(profile))
 	return nil
 }
 
+// RegisterProviderFactory registers factory for kind, replacing the built-in
+// factory of the same kind. Profiles of that kind can then be added with
+// UpsertProviderProfile.
+func (e *BasicEngine) RegisterProviderFactory(kind ProviderKind, factory ProviderFactory) {
+	if e.providers == nil {
+		e.providers = NewProviderRegistry()
+		RegisterDefaultProviderFactories(e.providers)
+	}
+	e.providers.RegisterFactory(kind, factory)
+}
+
 func (e *BasicEngine) saveCheckpoint(jobID string, stepID StepID, items []ResultItem) {
 	if e.checkpoint != nil {
 		e.checkpoint.SaveCheckpoint(jobID, stepID, items)
@@ -461,14 +1028,71 @@ func (e *BasicEngine) loadCheckpoints(jobID string) map[StepID][]ResultItem {
 	return result
 }
 
-func (e *BasicEngine) pipelineForType(pt PipelineType) *PipelineDef {
+func (e *BasicEngine) clearCheckpoints(jobID string) {
+	if e.checkpoint != nil {
+		e.checkpoint.ClearCheckpoints(jobID)
+		return
+	}
+	e.checkpointMu.Lock()
+	defer e.checkpointMu.Unlock()
+	delete(e.checkpoints, jobID)
+}
+
+// pipelineForType resolves the latest registration of pt. Unregistered types
+// get a default single-step pipeline unless strict resolution is enabled.
+func (e *BasicEngine) pipelineForType(pt PipelineType) (*PipelineDef, error) {
 	e.pipelineMu.RLock()
 	def, ok := e.pipelines[pt]
 	e.pipelineMu.RUnlock()
 	if ok {
-		return clonePipeline(def)
+		return clonePipeline(def), nil
+	}
+	if e.strictPipes {
+		return nil, fmt.Errorf("%w: %s", ErrPipelineNotFound, pt)
+	}
+	return defaultPipeline(pt), nil
+}
+
+// pipelineForVersion resolves a pinned pipeline version, falling back to the
+// latest registration when version is empty.
+func (e *BasicEngine) pipelineForVersion(pt PipelineType, version string) (*PipelineDef, error) {
+	if version == "" {
+		return e.pipelineForType(pt)
+	}
+	e.pipelineMu.RLock()
+	history, registered := e.pipelineHist[pt]
+	for _, def := range history {
+		if def.Version == version {
+			e.pipelineMu.RUnlock()
+			return clonePipeline(def), nil
+		}
+	}
+	e.pipelineMu.RUnlock()
+	if !registered {
+		if e.strictPipes {
+			return nil, fmt.Errorf("%w: %s", ErrPipelineNotFound, pt)
+		}
+		if def := defaultPipeline(pt); def.Version == version {
+			return def, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s@%s", ErrPipelineVersionNotFound, pt, version)
+}
+
+// requestPipeline resolves the pipeline req runs. A rerun keeps its parent's
+// version while that version is registered; once it is not (it was pushed
+// out of the history, or the parent ran the implicit default before the
+// type was registered) the rerun uses the parent's pipeline snapshot, or the
+// latest version when there is none.
+func (e *BasicEngine) requestPipeline(req JobRequest) (*PipelineDef, error) {
+	pipeline, err := e.pipelineForVersion(req.PipelineType, req.PipelineVersion)
+	if err == nil || req.ParentJobID == nil || !errors.Is(err, ErrPipelineVersionNotFound) {
+		return pipeline, err
+	}
+	if snapshot := e.loadPipelineSnapshot(*req.ParentJobID); snapshot != nil && snapshot.Type == req.PipelineType && snapshot.Version == req.PipelineVersion {
+		return snapshot, nil
 	}
-	return defaultPipeline(pt)
+	return e.pipelineForType(req.PipelineType)
 }
 
 func clonePipeline(def *PipelineDef) *PipelineDef {
@@ -476,21 +1100,32 @@ func clonePipeline(def *PipelineDef) *PipelineDef {
 		return defaultPipeline("")
 	}
 	copyDef := &PipelineDef{
-		Type:    def.Type,
-		Version: def.Version,
-		Steps:   make([]StepDef, len(def.Steps)),
+		Type:                     def.Type,
+		Version:                  def.Version,
+		Steps:                    make([]StepDef, len(def.Steps)),
+		DefaultProviderProfileID: def.DefaultProviderProfileID,
+		ExportSink:               def.ExportSink,
+		MaxConcurrency:           def.MaxConcurrency,
+	}
+	if def.RequiredSources != nil {
+		req := *def.RequiredSources
+		req.Kinds = append([]SourceKind(nil), req.Kinds...)
+		copyDef.RequiredSources = &req
 	}
 	if copyDef.Version == "" {
 		copyDef.Version = "v0"
 	}
 	if len(def.Steps) == 0 {
 		copyDef.Steps = []StepDef{
-			{ID: StepID("step-1"), Name: "default", Kind: StepKindLLM, Mode: StepModeSingle, OutputType: ContentText, Export: true},
+			{ID: StepID("step-1"), Name: "default", Kind: StepKindLLM, Mode: StepModeSingle, ProviderProfileID: def.DefaultProviderProfileID, OutputType: ContentText, Export: true},
 		}
 		return copyDef
 	}
 	for i, step := range def.Steps {
 		cp := step
+		if cp.ProviderProfileID == "" {
+			cp.ProviderProfileID = def.DefaultProviderProfileID
+		}
 		if cp.ID == "" {
 			cp.ID = StepID(fmt.Sprintf("step-%d", i+1))
 		}
@@ -537,12 +1172,167 @@ func findStartIndex(pipeline *PipelineDef, from *StepID) int {
 	return 0
 }
 
+// RerunOptions selects what a rerun repeats; see NewRerunRequest.
+type RerunOptions struct {
+	FromStepID    *StepID
+	ReuseUpstream bool
+	ReuseSteps    []StepID
+	// OverrideInput replaces the parent's input when set.
+	OverrideInput *JobInput
+	// EphemeralProviders are not inherited from the parent, which never
+	// stored them; a rerun that needs them must supply them again.
+	EphemeralProviders []ProviderProfile
+}
+
+// NewRerunRequest returns the JobRequest that reruns base as its child job. It
+// keeps base's pipeline version (see requestPipeline for versions that are no
+// longer registered), export sink and export steps, and its input unless opts
+// overrides it.
+func NewRerunRequest(base *Job, opts RerunOptions) JobRequest {
+	input := base.Input
+	if opts.OverrideInput != nil {
+		input = *opts.OverrideInput
+		// An override without its own seed keeps the parent's, so the rerun
+		// stays reproducible.
+		if seed := base.Input.Options.seed(); seed != nil && input.Options.seed() == nil {
+			input = withSeed(input, *seed)
+		}
+	}
+	var parentID *string
+	if base.ID != "" {
+		id := base.ID
+		parentID = &id
+	}
+	var fromStep *StepID
+	if opts.FromStepID != nil {
+		step := *opts.FromStepID
+		fromStep = &step
+	}
+	return JobRequest{
+		PipelineType:       base.PipelineType,
+		PipelineVersion:    base.PipelineVersion,
+		Input:              input,
+		Mode:               "rerun",
+		ParentJobID:        parentID,
+		FromStepID:         fromStep,
+		ReuseUpstream:      opts.ReuseUpstream,
+		ReuseSteps:         opts.ReuseSteps,
+		ExportSink:         base.ExportSink,
+		ExportSteps:        base.ExportSteps,
+		EphemeralProviders: opts.EphemeralProviders,
+	}
+}
+
+// validateReuseSteps checks that every step named in ReuseSteps exists, is
+// upstream of FromStepID and has a parent job to take checkpoints from.
+func validateReuseSteps(pipeline *PipelineDef, req JobRequest) error {
+	if len(req.ReuseSteps) == 0 {
+		return nil
+	}
+	if req.ParentJobID == nil {
+		return errors.New("reuse_steps requires parent_job_id")
+	}
+	startIndex := len(pipeline.Steps)
+	if req.FromStepID != nil {
+		startIndex = findStepIndex(pipeline.Steps, *req.FromStepID)
+	}
+	for _, id := range req.ReuseSteps {
+		idx := findStepIndex(pipeline.Steps, id)
+		if idx == -1 {
+			return fmt.Errorf("reuse step %s not found in pipeline", id)
+		}
+		if idx >= startIndex {
+			return fmt.Errorf("reuse step %s is not upstream of from_step_id %s", id, *req.FromStepID)
+		}
+	}
+	return nil
+}
+
+// validateExportSteps rejects JobRequest.ExportSteps entries that name no step
+// of the pipeline.
+func validateExportSteps(pipeline *PipelineDef, ids []StepID) error {
+	for _, id := range ids {
+		if findStepIndex(pipeline.Steps, id) == -1 {
+			return fmt.Errorf("export step %s not found in pipeline", id)
+		}
+	}
+	return nil
+}
+
+// restoreCheckpoints seeds stepOutputs with the parent job's checkpoints and
+// returns the step indexes that must not run again. ReuseSteps reuses exactly
+// the listed steps, and a listed step without a checkpoint still runs;
+// otherwise ReuseUpstream skips every step before startIndex.
+func (e *BasicEngine) restoreCheckpoints(ctx context.Context, job *Job, pipeline *PipelineDef, startIndex int, stepOutputs map[StepID][]ResultItem) map[int]bool {
+	skip := make(map[int]bool)
+	if len(job.ReuseSteps) == 0 {
+		if !job.ReuseUpstream {
+			return skip
+		}
+		for idx := 0; idx < startIndex && idx < len(pipeline.Steps); idx++ {
+			skip[idx] = true
+		}
+	}
+	if job.ParentJobID == nil {
+		return skip
+	}
+	reused := e.loadCheckpoints(*job.ParentJobID)
+	if len(reused) == 0 {
+		return skip
+	}
+	selected := make(map[StepID]bool, len(job.ReuseSteps))
+	for _, id := range job.ReuseSteps {
+		selected[id] = true
+	}
+	for idx, step := range pipeline.Steps {
+		if wanted := selected[step.ID] || len(selected) == 0 && skip[idx]; !wanted {
+			continue
+		}
+		if items, ok := reused[step.ID]; ok {
+			stepOutputs[step.ID] = cloneResultItems(items)
+			job.StepExecutions[idx].Status = StepExecSkipped
+			if err := e.appendExportedResults(ctx, job, step, items); err != nil {
+				e.failStep(ctx, job, idx, stepErrorCode(err), err.Error(), stepErrorDetails(err))
+				return skip
+			}
+			skip[idx] = true
+		}
+	}
+	return skip
+}
+
 func ensureDependencies(step StepDef, outputs map[StepID][]ResultItem) error {
 	for _, dep := range step.DependsOn {
 		if _, ok := outputs[dep]; !ok {
 			return fmt.Errorf("dependency %s not satisfied for step %s", dep, step.ID)
 		}
 	}
+	for _, binding := range step.InputFrom {
+		if _, ok := outputs[binding.Step]; !ok {
+			return fmt.Errorf("input %s from step %s not satisfied for step %s", binding.Name, binding.Step, step.ID)
+		}
+	}
+	for _, name := range mappingNames(step) {
+		if from := mappingStep(step.InputMapping[name]); from != "" {
+			if _, ok := outputs[from]; !ok {
+				return fmt.Errorf("input mapping %s from step %s not satisfied for step %s", name, from, step.ID)
+			}
+		}
+	}
+	return nil
+}
+
+// perItemBase returns the items a per_item step iterates over: the InputFrom
+// binding marked PerItem, or the outputs of the last dependency by default.
+func perItemBase(step StepDef, outputs map[StepID][]ResultItem) []ResultItem {
+	for _, binding := range step.InputFrom {
+		if binding.PerItem {
+			return outputs[binding.Step]
+		}
+	}
+	if len(step.DependsOn) > 0 {
+		return outputs[step.DependsOn[len(step.DependsOn)-1]]
+	}
 	return nil
 }
 
@@ -550,38 +1340,130 @@ type promptContext struct {
 	Job      *Job
 	Step     StepDef
 	Sources  []Source
+	History  []ChatMessage
 	Options  *JobOptions
 	Previous map[string][]ResultItem
+	Inputs   map[string][]ResultItem
+	// Vars holds the values resolved from StepDef.InputMapping.
+	Vars map[string]any
 }
 
-func buildPrompt(step StepDef, job *Job, outputs map[StepID][]ResultItem) string {
-	if step.Prompt == nil {
-		return ""
-	}
+func newPromptContext(step StepDef, job *Job, outputs map[StepID][]ResultItem) promptContext {
 	ctx := promptContext{
 		Job:      job,
 		Step:     step,
 		Sources:  job.Input.Sources,
+		History:  job.Input.History,
 		Options:  job.Input.Options,
 		Previous: map[string][]ResultItem{},
 	}
+	if sources, ok := retrievedSources(step, outputs); ok {
+		ctx.Sources = sources
+	}
+	ctx.Sources = prioritizeSources(step, ctx.Sources)
 	for k, v := range outputs {
 		ctx.Previous[string(k)] = cloneResultItems(v)
 	}
-
-	var b strings.Builder
-	if step.Prompt.System != "" {
-		b.WriteString(executeTemplateText(step.Prompt.System, ctx))
-		b.WriteByte('\n')
+	if len(step.InputFrom) > 0 {
+		ctx.Inputs = make(map[string][]ResultItem, len(step.InputFrom))
+		for _, binding := range step.InputFrom {
+			ctx.Inputs[binding.Name] = cloneResultItems(outputs[binding.Step])
+		}
 	}
-	if step.Prompt.User != "" {
-		b.WriteString(executeTemplateText(step.Prompt.User, ctx))
+	// Unresolved pointers fail the step before it renders, except in dry
+	// runs whose stub outputs lack the structured data.
+	ctx.Vars, _ = resolveInputMapping(step, outputs)
+	return ctx
+}
+
+// RenderPrompt renders the step's PromptTemplate against the given input
+// without running a job, e.g. to preview a pipeline's prompt. Templates that
+// reference .Previous see no upstream outputs.
+func RenderPrompt(step StepDef, input JobInput) string {
+	return buildPrompt(step, &Job{Input: input}, nil)
+}
+
+func buildPrompt(step StepDef, job *Job, outputs map[StepID][]ResultItem) string {
+	if step.Prompt == nil {
+		return ""
 	}
-	return strings.TrimSpace(b.String())
+	return renderPrompt(step, job.Input.Options, newPromptContext(step, job, outputs))
+}
+
+// renderPrompt renders the step's system and user templates against ctx.
+func renderPrompt(step StepDef, opts *JobOptions, ctx promptContext) string {
+	system := ""
+	if step.Prompt.System != "" {
+		system = executeTemplateText(step.Prompt.System, ctx)
+	}
+	if override, mode, ok := systemPromptOverride(step, opts); ok {
+		system = applySystemPrompt(system, override, mode)
+	}
+
+	var b strings.Builder
+	if system != "" {
+		b.WriteString(system)
+		b.WriteByte('\n')
+	}
+	if step.Prompt.User != "" {
+		b.WriteString(executeTemplateText(step.Prompt.User, ctx))
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// buildPromptMessages renders the ordered role messages declared in
+// PromptTemplate.Meta["messages"], applying JobOptions.SystemPromptOverride.
+// It returns nil when none are declared so providers keep their plain prompt
+// behavior.
+func buildPromptMessages(step StepDef, job *Job, outputs map[StepID][]ResultItem) []PromptMessage {
+	if step.Prompt == nil {
+		return nil
+	}
+	return renderPromptMessages(step, job.Input.Options, newPromptContext(step, job, outputs))
+}
+
+func renderPromptMessages(step StepDef, opts *JobOptions, ctx promptContext) []PromptMessage {
+	declared := promptMessagesFromMeta(step.Prompt.Meta)
+	if len(declared) == 0 {
+		return nil
+	}
+	rendered := make([]PromptMessage, 0, len(declared))
+	for _, msg := range declared {
+		rendered = append(rendered, PromptMessage{
+			Role:    msg.Role,
+			Content: strings.TrimSpace(executeTemplateText(msg.Content, ctx)),
+		})
+	}
+	if override, mode, ok := systemPromptOverride(step, opts); ok {
+		rendered = applySystemPromptMessages(rendered, override, mode)
+	}
+	return rendered
+}
+
+func promptMessagesFromMeta(meta map[string]any) []PromptMessage {
+	raw, ok := meta[PromptMetaMessagesKey]
+	if !ok || raw == nil {
+		return nil
+	}
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return nil
+	}
+	var messages []PromptMessage
+	if err := json.Unmarshal(encoded, &messages); err != nil {
+		return nil
+	}
+	filtered := messages[:0]
+	for _, msg := range messages {
+		if msg.Role != "" {
+			filtered = append(filtered, msg)
+		}
+	}
+	return filtered
 }
 
 func executeTemplateText(text string, data any) string {
-	tpl, err := template.New("prompt").Parse(text)
+	tpl, err := template.New("prompt").Funcs(PromptFuncs()).Parse(text)
 	if err != nil {
 		return text
 	}
@@ -599,85 +1481,156 @@ func (e *BasicEngine) runStep(ctx context.Context, job *Job, execIdx int, step S
 	default:
 	}
 
-	time.Sleep(100 * time.Millisecond)
+	if err := injectedFailure(ctx, job, step); err != nil {
+		return nil, err
+	}
+	if unsupported := checkStepFeatures(step); unsupported != nil {
+		return nil, unsupportedFeature(unsupported)
+	}
+
+	if step.Kind == StepKindRetrieve {
+		return e.runRetrieveStep(step, outputs)
+	}
 
-	provider, profile := e.resolveProvider(step)
+	step = applyModelTier(step, job.Input.Options)
+	provider, profile, err := e.resolveProvider(ctx, step)
+	if err != nil {
+		return nil, err
+	}
 	inputCtx := ProviderInput{
 		Sources:  job.Input.Sources,
+		History:  job.Input.History,
 		Options:  job.Input.Options,
 		Previous: outputs,
+		Messages: buildPromptMessages(step, job, outputs),
+	}
+	if sources, ok := retrievedSources(step, outputs); ok {
+		inputCtx.Sources = sources
+	}
+	inputCtx.Sources = prioritizeSources(step, inputCtx.Sources)
+	// Tree reductions check the context window per batch instead.
+	treeReduce := step.Kind == StepKindReduce && needsReduceTree(step, reduceShards(step, outputs))
+	if !treeReduce {
+		summarizer := provider
+		if job.Mode == ModeDryRun {
+			summarizer = nil
+		}
+		truncated, report, err := e.truncatePrompt(ctx, summarizer, profile, step, job, outputs, prompt, &inputCtx)
+		if err != nil {
+			return nil, err
+		}
+		if report != nil {
+			prompt = truncated
+			e.recordTruncation(ctx, job, execIdx, prompt, report)
+		}
+		if err := checkContextWindow(step, profile, prompt, historyPromptMessages(inputCtx.History, inputCtx.Messages)); err != nil {
+			return nil, err
+		}
+	}
+	if job.Mode == ModeDryRun {
+		// The provider was resolved above so misconfiguration still fails the
+		// step; dropping it makes every call below return stub output without
+		// recording metrics.
+		provider = nil
+	}
+
+	if step.Kind == StepKindReduce {
+		return e.runReduceStep(ctx, execIdx, provider, profile, step, job, prompt, inputCtx)
 	}
 
 	switch step.Mode {
 	case StepModeFanOut:
 		return e.runFanOutStep(ctx, execIdx, provider, profile, step, job, prompt, inputCtx)
 	case StepModePerItem:
-		var base []ResultItem
-		if len(step.DependsOn) > 0 {
-			lastDep := step.DependsOn[len(step.DependsOn)-1]
-			if items, ok := outputs[lastDep]; ok {
-				base = items
-			}
-		}
+		base := perItemBase(step, outputs)
 		if len(base) == 0 {
 			return e.runFanOutStep(ctx, execIdx, provider, profile, step, job, prompt, inputCtx)
 		}
 		return e.runPerItemStep(ctx, execIdx, provider, profile, step, job, prompt, inputCtx, base)
-	default:
+	case StepModeSingle, "":
 		return e.runSingleStep(ctx, execIdx, provider, profile, step, job, prompt, inputCtx)
+	default:
+		// checkStepFeatures rejects modes SupportedCapabilities does not list;
+		// this catches a mode listed there but not handled above.
+		return nil, unsupportedFeature(&UnsupportedFeatureError{Step: step.ID, Feature: "step_mode", Value: string(step.Mode), Supported: featureNames(SupportedCapabilities().StepModes)})
 	}
 }
 
 func (e *BasicEngine) runSingleStep(ctx context.Context, execIdx int, provider Provider, profile ProviderProfile, step StepDef, job *Job, prompt string, input ProviderInput) ([]ResultItem, error) {
-	resp, err := e.callProvider(ctx, provider, profile, step, prompt, input)
+	resp, err := e.callProvider(ctx, provider, profile, step, prompt, input, e.recordChunks(ctx, job, execIdx))
 	if err != nil {
+		if ctx.Err() != nil && len(resp.Chunks) > 0 {
+			return []ResultItem{e.buildPartialResult(step, job, prompt, resp)}, err
+		}
 		return nil, err
 	}
-	e.recordChunks(job, execIdx, profile.Kind, resp.Chunks)
 	text := resp.Output
 	meta := resp.Metadata
 	if text == "" {
 		text = fmt.Sprintf("step %s processed %d sources", step.ID, len(job.Input.Sources))
 	}
-	item := buildSingleResult(step, job, prompt, text, meta)
+	item := e.buildSingleResult(step, job, prompt, text, meta)
+	item.ProviderMeta = resp.Meta
 	return []ResultItem{item}, nil
 }
 
 func (e *BasicEngine) runFanOutStep(ctx context.Context, execIdx int, provider Provider, profile ProviderProfile, step StepDef, job *Job, prompt string, input ProviderInput) ([]ResultItem, error) {
-	if len(job.Input.Sources) == 0 {
+	// input.Sources are the job's sources after retrieval, prioritization and
+	// truncation, already ordered by runStep.
+	if len(input.Sources) == 0 {
 		return e.runSingleStep(ctx, execIdx, provider, profile, step, job, prompt, input)
 	}
-	items := make([]ResultItem, len(job.Input.Sources))
-	for i, src := range job.Input.Sources {
+	sources, err := e.limitFanOut(step, input.Sources)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]ResultItem, len(sources))
+	setShardTotal(job, execIdx, len(items))
+	shards := newShardProgress(len(items))
+	record := shards.lockChunks(e.recordChunks(ctx, job, execIdx))
+	err = runShards(ctx, len(sources), e.shardConcurrency(step), func(ctx context.Context, i int) error {
+		src := sources[i]
 		localInput := input
 		localInput.Sources = []Source{src}
-		resp, err := e.callProvider(ctx, provider, profile, step, prompt, localInput)
+		resp, err := e.callProvider(ctx, provider, profile, step, prompt, localInput, record)
+		shards.mu.Lock()
+		defer shards.mu.Unlock()
 		if err != nil {
-			return nil, err
+			return err
 		}
-		e.recordChunks(job, execIdx, profile.Kind, resp.Chunks)
 		text := resp.Output
 		meta := resp.Metadata
 		if text == "" {
 			text = fmt.Sprintf("step %s handled source %s", step.ID, src.Label)
 		}
-		items[i] = buildFanOutResult(step, prompt, src, i, text, meta)
+		items[i] = e.buildFanOutResult(step, prompt, src, i, text, meta)
+		items[i].ProviderMeta = resp.Meta
+		e.completeShard(ctx, job, execIdx, step, shards, items, i)
+		return nil
+	})
+	if err != nil {
+		return unexportedShards(ctx, job, shards, items), err
 	}
 	return items, nil
 }
 
 func (e *BasicEngine) runPerItemStep(ctx context.Context, execIdx int, provider Provider, profile ProviderProfile, step StepDef, job *Job, prompt string, input ProviderInput, base []ResultItem) ([]ResultItem, error) {
 	items := make([]ResultItem, len(base))
-	for i, prev := range base {
+	setShardTotal(job, execIdx, len(items))
+	shards := newShardProgress(len(items))
+	record := shards.lockChunks(e.recordChunks(ctx, job, execIdx))
+	err := runShards(ctx, len(base), e.shardConcurrency(step), func(ctx context.Context, i int) error {
+		prev := base[i]
 		localInput := input
 		localInput.Previous = map[StepID][]ResultItem{
 			prev.StepID: {prev},
 		}
-		resp, err := e.callProvider(ctx, provider, profile, step, prompt, localInput)
+		resp, err := e.callProvider(ctx, provider, profile, step, prompt, localInput, record)
+		shards.mu.Lock()
+		defer shards.mu.Unlock()
 		if err != nil {
-			return nil, err
+			return err
 		}
-		e.recordChunks(job, execIdx, profile.Kind, resp.Chunks)
 		text := resp.Output
 		meta := resp.Metadata
 		if text == "" {
@@ -689,12 +1642,69 @@ func (e *BasicEngine) runPerItemStep(ctx context.Context, execIdx int, provider
 			}
 			text = fmt.Sprintf("step %s refined shard %s", step.ID, shard)
 		}
-		items[i] = buildPerItemResult(step, prompt, prev, i, text, meta)
+		items[i] = e.buildPerItemResult(step, prompt, prev, i, text, meta)
+		items[i].ProviderMeta = resp.Meta
+		e.completeShard(ctx, job, execIdx, step, shards, items, i)
+		return nil
+	})
+	if err != nil {
+		return unexportedShards(ctx, job, shards, items), err
 	}
 	return items, nil
 }
 
-func buildSingleResult(step StepDef, job *Job, prompt, text string, meta map[string]any) ResultItem {
+// runReduceStep aggregates every upstream shard into a single provider call.
+// Streamed chunks are recorded on the reduce step exactly like single steps.
+// Inputs above the step's reduce_token_threshold are first reduced in batches
+// (see reduceTree) and the final call reduces the partial summaries.
+func (e *BasicEngine) runReduceStep(ctx context.Context, execIdx int, provider Provider, profile ProviderProfile, step StepDef, job *Job, prompt string, input ProviderInput) ([]ResultItem, error) {
+	shards := reduceShards(step, input.Previous)
+	if needsReduceTree(step, shards) {
+		return e.runReduceTreeStep(ctx, execIdx, provider, profile, step, job, input.Previous, shards)
+	}
+	localInput := input
+	if len(step.DependsOn) > 0 {
+		localInput.Previous = make(map[StepID][]ResultItem, len(step.DependsOn))
+		for _, dep := range step.DependsOn {
+			localInput.Previous[dep] = input.Previous[dep]
+		}
+	}
+
+	resp, err := e.callProvider(ctx, provider, profile, step, prompt, localInput, e.recordChunks(ctx, job, execIdx))
+	if err != nil {
+		return nil, err
+	}
+	text := resp.Output
+	if text == "" {
+		text = fmt.Sprintf("step %s reduced %d items", step.ID, len(shards))
+	}
+	item := e.buildReduceResult(step, job, prompt, shards, text, resp.Metadata)
+	item.ProviderMeta = resp.Meta
+	return []ResultItem{item}, nil
+}
+
+func (e *BasicEngine) runReduceTreeStep(ctx context.Context, execIdx int, provider Provider, profile ProviderProfile, step StepDef, job *Job, outputs map[StepID][]ResultItem, shards []ResultItem) ([]ResultItem, error) {
+	final, partials, err := e.reduceTree(ctx, provider, profile, step, job, outputs, shards)
+	if err != nil {
+		return nil, err
+	}
+	resp, prompt, err := e.callReduceBatch(ctx, provider, profile, step, job, reduceBatchOutputs(step, outputs, final), e.recordChunks(ctx, job, execIdx))
+	if err != nil {
+		return nil, err
+	}
+	text := resp.Output
+	if text == "" {
+		text = fmt.Sprintf("step %s reduced %d items in %d partials", step.ID, len(shards), len(partials))
+	}
+	item := e.buildReduceResult(step, job, prompt, shards, text, resp.Metadata)
+	item.ProviderMeta = resp.Meta
+	if data, ok := item.Data.(map[string]any); ok {
+		data["partials"] = reducePartialSummaries(partials)
+	}
+	return []ResultItem{item}, nil
+}
+
+func (e *BasicEngine) buildSingleResult(step StepDef, job *Job, prompt, text string, meta map[string]any) ResultItem {
 	label := step.Name
 	if label == "" {
 		label = string(step.ID)
@@ -704,18 +1714,36 @@ func buildSingleResult(step StepDef, job *Job, prompt, text string, meta map[str
 		"prompt":       prompt,
 		"pipelineType": job.PipelineType,
 	}
+	if list := sourceMetadataList(job.Input.Sources); list != nil {
+		data["source_metadata"] = list
+	}
 	mergeMeta(data, meta)
 	return ResultItem{
-		ID:          generateID(),
+		ID:          e.idGenerator(),
 		Label:       label,
 		StepID:      step.ID,
 		Kind:        string(step.Kind),
+		Tag:         step.ExportTag,
 		ContentType: ensureContentType(step.OutputType),
 		Data:        data,
 	}
 }
 
-func buildFanOutResult(step StepDef, prompt string, src Source, idx int, text string, meta map[string]any) ResultItem {
+// buildPartialResult returns the incomplete result of a single step whose
+// provider call was cancelled after streaming resp.Chunks. The text is the
+// raw streamed output; PostProcess transforms are not applied.
+func (e *BasicEngine) buildPartialResult(step StepDef, job *Job, prompt string, resp ProviderResponse) ResultItem {
+	var text strings.Builder
+	for _, chunk := range resp.Chunks {
+		text.WriteString(chunk.Content)
+	}
+	item := e.buildSingleResult(step, job, prompt, text.String(), nil)
+	item.ProviderMeta = resp.Meta
+	item.Incomplete = true
+	return item
+}
+
+func (e *BasicEngine) buildFanOutResult(step StepDef, prompt string, src Source, idx int, text string, meta map[string]any) ResultItem {
 	label := step.Name
 	if label == "" {
 		label = string(step.ID)
@@ -726,20 +1754,24 @@ func buildFanOutResult(step StepDef, prompt string, src Source, idx int, text st
 		"source_kind": src.Kind,
 		"source":      src.Content,
 	}
+	if len(src.Metadata) > 0 {
+		data["source_metadata"] = cloneMap(src.Metadata)
+	}
 	mergeMeta(data, meta)
 	shard := fmt.Sprintf("%s-%d", step.ID, idx)
 	return ResultItem{
-		ID:          generateID(),
+		ID:          e.idGenerator(),
 		Label:       fmt.Sprintf("%s#%d", label, idx+1),
 		StepID:      step.ID,
 		ShardKey:    ptrString(shard),
 		Kind:        string(step.Kind),
+		Tag:         step.ExportTag,
 		ContentType: ensureContentType(step.OutputType),
 		Data:        data,
 	}
 }
 
-func buildPerItemResult(step StepDef, prompt string, prev ResultItem, idx int, text string, meta map[string]any) ResultItem {
+func (e *BasicEngine) buildPerItemResult(step StepDef, prompt string, prev ResultItem, idx int, text string, meta map[string]any) ResultItem {
 	shard := fmt.Sprintf("%s-%d", step.ID, idx)
 	if prev.ShardKey != nil {
 		shard = *prev.ShardKey
@@ -749,56 +1781,455 @@ func buildPerItemResult(step StepDef, prompt string, prev ResultItem, idx int, t
 		"prompt":        prompt,
 		"previous_step": prev.StepID,
 	}
+	if prevData, ok := prev.Data.(map[string]any); ok && prevData["source_metadata"] != nil {
+		data["source_metadata"] = prevData["source_metadata"]
+	}
 	mergeMeta(data, meta)
 	return ResultItem{
-		ID:          generateID(),
+		ID:          e.idGenerator(),
 		Label:       fmt.Sprintf("%s#%d", step.Name, idx+1),
 		StepID:      step.ID,
 		ShardKey:    ptrString(shard),
 		Kind:        string(step.Kind),
+		Tag:         step.ExportTag,
 		ContentType: ensureContentType(step.OutputType),
 		Data:        data,
 	}
 }
 
-func (e *BasicEngine) callProvider(ctx context.Context, provider Provider, profile ProviderProfile, step StepDef, prompt string, input ProviderInput) (ProviderResponse, error) {
+func (e *BasicEngine) buildReduceResult(step StepDef, job *Job, prompt string, shards []ResultItem, text string, meta map[string]any) ResultItem {
+	label := step.Name
+	if label == "" {
+		label = string(step.ID)
+	}
+	shardKeys := make([]string, 0, len(shards))
+	for _, shard := range shards {
+		if shard.ShardKey != nil {
+			shardKeys = append(shardKeys, *shard.ShardKey)
+		}
+	}
+	data := map[string]any{
+		"text":          text,
+		"prompt":        prompt,
+		"reduced_count": len(shards),
+		"shard_keys":    shardKeys,
+	}
+	if list := sourceMetadataList(job.Input.Sources); list != nil {
+		data["source_metadata"] = list
+	}
+	mergeMeta(data, meta)
+	return ResultItem{
+		ID:          e.idGenerator(),
+		Label:       label,
+		StepID:      step.ID,
+		Kind:        string(step.Kind),
+		Tag:         step.ExportTag,
+		ContentType: ensureContentType(step.OutputType),
+		Data:        data,
+	}
+}
+
+// chunkFunc receives each chunk as the provider streams it, with the kind of
+// the provider serving the call, which differs from the step's after a
+// failover. Chunks of attempts that are retried or failed over are delivered
+// too, since they may already have been shown.
+type chunkFunc func(kind ProviderKind, chunk ProviderChunk)
+
+// callProvider invokes the resolved provider, running the tool-calling loop
+// (see callWithTools) when the step declares Tools, and applies the step's
+// PostProcess transforms to the output. onChunk, when not nil, receives the
+// streamed chunks before the call returns.
+func (e *BasicEngine) callProvider(ctx context.Context, provider Provider, profile ProviderProfile, step StepDef, prompt string, input ProviderInput, onChunk chunkFunc) (ProviderResponse, error) {
+	var resp ProviderResponse
+	var err error
+	if len(step.Tools) > 0 && provider != nil {
+		resp, err = e.callWithTools(ctx, provider, profile, step, prompt, input, onChunk)
+	} else {
+		resp, err = e.callProviderOnce(ctx, provider, profile, step, prompt, input, onChunk)
+		if err == nil && len(resp.ToolCalls) > 0 {
+			resp, err = undeclaredToolCalls(step, resp)
+		}
+	}
+	if err != nil || provider == nil {
+		return resp, err
+	}
+	if len(step.PostProcess) > 0 {
+		resp.Output, err = e.postProcess(step, resp.Output)
+		if err != nil {
+			return ProviderResponse{}, err
+		}
+	}
+	if step.OutputFormat == OutputFormatJSONStrict {
+		resp.Output, err = strictJSONOutput(step, resp.Output)
+		if err != nil {
+			return ProviderResponse{}, err
+		}
+	}
+	return resp, nil
+}
+
+// strictJSONOutput checks the output of a json_strict step. Providers
+// without native structured output often wrap the JSON in prose or code
+// fences, so the first JSON value is extracted; output without one fails
+// the step with invalid_json_output.
+func strictJSONOutput(step StepDef, text string) (string, error) {
+	trimmed := strings.TrimSpace(text)
+	if json.Valid([]byte(trimmed)) {
+		return trimmed, nil
+	}
+	extracted, err := postProcessExtractJSON(trimmed, "")
+	if err != nil {
+		return "", &stepError{
+			code:    "invalid_json_output",
+			err:     fmt.Errorf("step %s: json_strict output is not JSON: %w", step.ID, err),
+			details: map[string]any{"output_format": step.OutputFormat},
+		}
+	}
+	return extracted, nil
+}
+
+// simulateLatency waits EngineConfig.SimulatedLatency before a simulated
+// provider call, returning early with ctx's error when it is cancelled.
+func (e *BasicEngine) simulateLatency(ctx context.Context) error {
+	if e.simLatency <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(e.simLatency)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func isSimulatedProvider(provider Provider) bool {
+	switch provider.(type) {
+	case *ImageProvider, *LocalToolProvider:
+		return true
+	}
+	return false
+}
+
+// callProviderOnce invokes the resolved provider, failing over to
+// step.Fallbacks in order on retryable errors. The profile that served the
+// response is recorded as provider_profile_id in its metadata. Steps without a
+// provider return an empty response so the caller falls back to synthetic
+// stub output.
+func (e *BasicEngine) callProviderOnce(ctx context.Context, provider Provider, profile ProviderProfile, step StepDef, prompt string, input ProviderInput, onChunk chunkFunc) (ProviderResponse, error) {
 	if provider == nil {
-		return ProviderResponse{}, nil
-	}
-	start := time.Now()
-	resp, err := provider.Call(ctx, ProviderRequest{
-		Step:    step,
-		Prompt:  prompt,
-		Profile: profile,
-		Input:   input,
-	})
-	metrics.ObserveProviderCall(string(profile.Kind), time.Since(start), err)
+		return ProviderResponse{}, e.simulateLatency(ctx)
+	}
+	primaryID := profile.ID
+	resp, err := e.callProfile(ctx, provider, profile, step, prompt, input, onChunk)
+	for _, fallbackID := range step.Fallbacks {
+		if err == nil || !IsRetryableProviderError(err) || ctx.Err() != nil {
+			break
+		}
+		// Overrides target the primary profile, so fallbacks resolve as registered.
+		fallback, fallbackProfile, resolveErr := e.resolveStepProvider(ctx, StepDef{ID: step.ID, ProviderProfileID: fallbackID})
+		if resolveErr != nil {
+			logging.Warnf("step %s: skipping fallback %s: %v", step.ID, fallbackID, resolveErr)
+			continue
+		}
+		logging.Warnf("step %s: provider %s failed (%v), failing over to %s", step.ID, profile.ID, err, fallbackID)
+		profile = fallbackProfile
+		resp, err = e.callProfile(ctx, fallback, fallbackProfile, step, prompt, input, onChunk)
+	}
+	if err != nil {
+		return resp, err
+	}
+	level, model, tiered := modelTier(step, input.Options)
+	tiered = tiered && profile.ID == primaryID
+	if tiered && resp.Meta.Model == "" {
+		resp.Meta.Model = model
+	}
+	// The flat provider/model keys are a compatibility shim for clients that
+	// predate ResultItem.ProviderMeta; provider Metadata wins over them.
+	meta := resp.Meta.flatMeta()
+	mergeMeta(meta, resp.Metadata)
+	meta["provider_profile_id"] = string(profile.ID)
+	if tiered {
+		meta["model_tier"] = level
+	}
+	resp.Metadata = meta
+	return resp, nil
+}
+
+// callProfile performs a single provider call and applies the step's empty
+// output policy.
+func (e *BasicEngine) callProfile(ctx context.Context, provider Provider, profile ProviderProfile, step StepDef, prompt string, input ProviderInput, onChunk chunkFunc) (ProviderResponse, error) {
+	var streamed func(ProviderChunk)
+	if onChunk != nil {
+		streamed = func(chunk ProviderChunk) { onChunk(profile.Kind, chunk) }
+	}
+	policy := emptyOutputPolicy(step)
+	attempts := 1
+	if policy == EmptyOutputRetry {
+		attempts = 2
+	}
+	for attempt := 0; attempt < attempts; attempt++ {
+		var latency time.Duration
+		resp, retries, err := retryProviderCall(ctx, step, profile, func() (ProviderResponse, error) {
+			start := time.Now()
+			if isSimulatedProvider(provider) {
+				if err := e.simulateLatency(ctx); err != nil {
+					return ProviderResponse{}, err
+				}
+			}
+			resp, err := callStream(ctx, provider, ProviderRequest{
+				Step:    step,
+				Prompt:  prompt,
+				Profile: profile,
+				Input:   input,
+			}, streamed)
+			latency = time.Since(start)
+			if err != nil && ctx.Err() != nil {
+				// Cancelled and timed-out calls are not provider failures.
+				metrics.ObserveProviderModelCancelled(string(profile.Kind), observedModel(profile, resp), latency)
+			} else {
+				metrics.ObserveProviderModelCall(string(profile.Kind), observedModel(profile, resp), latency, err)
+			}
+			return resp, err
+		})
+		if err != nil {
+			return resp, err
+		}
+		if retries > 0 {
+			if resp.Metadata == nil {
+				resp.Metadata = map[string]any{}
+			}
+			resp.Metadata["provider_retries"] = retries
+		}
+		meta := ProviderMeta{Provider: profile.Kind}
+		if resp.Meta != nil {
+			meta = *resp.Meta
+		}
+		meta.LatencyMS = latency.Milliseconds()
+		meta.SeedIgnored = input.Options.seed() != nil && meta.Seed == nil
+		resp.Meta = &meta
+		if strings.TrimSpace(resp.Output) != "" || len(resp.ToolCalls) > 0 || policy == EmptyOutputFallback {
+			return resp, nil
+		}
+	}
+	return ProviderResponse{}, &stepError{
+		code: "empty_output",
+		err:  fmt.Errorf("provider %s returned empty output for step %s", profile.ID, step.ID),
+	}
+}
+
+// callStream calls provider through CallStream, adapting batch providers with
+// AsStreamingProvider. Each chunk is passed to onChunk, when not nil, as it
+// arrives; the final response also carries the streamed chunks, also when the
+// call fails.
+func callStream(ctx context.Context, provider Provider, req ProviderRequest, onChunk func(ProviderChunk)) (ProviderResponse, error) {
+	chunks, wait, err := AsStreamingProvider(provider).CallStream(ctx, req)
+	if err != nil {
+		return ProviderResponse{}, err
+	}
+	var streamed []ProviderChunk
+	for chunk := range chunks {
+		streamed = append(streamed, chunk)
+		if onChunk != nil {
+			onChunk(chunk)
+		}
+	}
+	// Chunks streamed before a failure are kept so they can be recorded.
+	resp, err := wait()
+	resp.Chunks = streamed
 	return resp, err
 }
 
-func (e *BasicEngine) recordChunks(job *Job, execIdx int, kind ProviderKind, chunks []ProviderChunk) {
-	if len(chunks) == 0 || execIdx < 0 || execIdx >= len(job.StepExecutions) {
+// observedModel is the model a call is attributed to in metrics: the one the
+// provider reported, or the profile's (override-merged) default model.
+func observedModel(profile ProviderProfile, resp ProviderResponse) string {
+	if resp.Meta != nil && resp.Meta.Model != "" {
+		return resp.Meta.Model
+	}
+	if model, ok := resp.Metadata["model"].(string); ok && model != "" {
+		return model
+	}
+	return profile.DefaultModel
+}
+
+// EmptyOutputPolicy controls how a step reacts to blank provider output. It is
+// read from StepDef.Config["empty_output"] (EmptyOutputConfigKey).
+type EmptyOutputPolicy string
+
+const (
+	EmptyOutputFail     EmptyOutputPolicy = "fail"
+	EmptyOutputRetry    EmptyOutputPolicy = "retry"
+	EmptyOutputFallback EmptyOutputPolicy = "fallback"
+)
+
+func emptyOutputPolicy(step StepDef) EmptyOutputPolicy {
+	raw, _ := step.ConfigString(EmptyOutputConfigKey)
+	switch policy := EmptyOutputPolicy(strings.ToLower(raw)); policy {
+	case EmptyOutputRetry, EmptyOutputFallback:
+		return policy
+	default:
+		return EmptyOutputFail
+	}
+}
+
+// recordChunks returns the chunkFunc a step passes to callProvider. Each
+// chunk is appended to the step, counted in the provider_chunk_count metric
+// under the provider kind and saved as it arrives, so streams show it before
+// the call returns.
+func (e *BasicEngine) recordChunks(ctx context.Context, job *Job, execIdx int) chunkFunc {
+	if execIdx < 0 || execIdx >= len(job.StepExecutions) {
+		return nil
+	}
+	return func(kind ProviderKind, chunk ProviderChunk) {
+		stepExec := &job.StepExecutions[execIdx]
+		stepExec.Chunks = append(stepExec.Chunks, StepChunk{StepID: stepExec.StepID, Index: len(stepExec.Chunks), Content: chunk.Content})
+		stepExec.ChunkCount = len(stepExec.Chunks)
+		metrics.ObserveProviderChunks(string(kind), 1)
+		job.UpdatedAt = time.Now().UTC()
+		// After a cancellation the stored job may already be cancelled; the
+		// caller stores the chunks instead (see keepPartialOutput and failStep).
+		if ctx.Err() == nil {
+			_ = e.saveJob(ctx, job)
+		}
+	}
+}
+
+// keepPartialOutput adds what step idx streamed before its job was cancelled
+// to the stored job: the step's chunks and, for exported steps, the partial
+// results. It waits for CancelJobWithDetails, which holds startMu while it
+// stores the cancelled job, so neither write is lost.
+func (e *BasicEngine) keepPartialOutput(ctx context.Context, local *Job, idx int, step StepDef, partial []ResultItem) {
+	if len(local.StepExecutions[idx].Chunks) == 0 && len(partial) == 0 {
 		return
 	}
-	stepExec := &job.StepExecutions[execIdx]
-	for _, chunk := range chunks {
-		index := len(stepExec.Chunks)
-		stepExec.Chunks = append(stepExec.Chunks, StepChunk{StepID: stepExec.StepID, Index: index, Content: chunk.Content})
+	storeCtx := context.WithoutCancel(ctx)
+	e.startMu.Lock()
+	defer e.startMu.Unlock()
+	job, err := e.store.GetJob(storeCtx, local.ID)
+	if err != nil || idx >= len(job.StepExecutions) {
+		return
 	}
-	metrics.ObserveProviderChunks(string(kind), len(chunks))
-	job.UpdatedAt = time.Now().UTC()
-	_ = e.store.UpdateJob(job)
+	// The stored sources are redacted; saveJob needs the raw ones to scrub
+	// the chunks.
+	job.Input.Sources = local.Input.Sources
+	job.StepExecutions[idx].Chunks = local.StepExecutions[idx].Chunks
+	job.StepExecutions[idx].ChunkCount = local.StepExecutions[idx].ChunkCount
+	if err := e.appendExportedResults(storeCtx, job, step, partial); err != nil {
+		logging.Warnf("job %s: failed to export partial output of step %s: %v", job.ID, step.ID, err)
+	}
+	_ = e.saveJob(storeCtx, job)
 }
 
-func (e *BasicEngine) resolveProvider(step StepDef) (Provider, ProviderProfile) {
-	if e.providers == nil {
-		return nil, ProviderProfile{}
+// resolveProvider returns a nil provider for steps without a
+// ProviderProfileID, which then produce synthetic output. A step that names a
+// profile the registry cannot resolve fails with provider_unresolved.
+func (e *BasicEngine) resolveProvider(ctx context.Context, step StepDef) (Provider, ProviderProfile, error) {
+	if e.providers == nil || step.ProviderProfileID == "" {
+		return nil, ProviderProfile{}, nil
 	}
-	provider, profile, err := e.providers.Resolve(step)
+	provider, profile, err := e.resolveStepProvider(ctx, step)
 	if err != nil {
-		return nil, ProviderProfile{}
+		details := map[string]any{"profile_id": step.ProviderProfileID}
+		var unresolved *ProviderUnresolvedError
+		if errors.As(err, &unresolved) && unresolved.Kind != "" {
+			details["kind"] = unresolved.Kind
+		}
+		return nil, ProviderProfile{}, &stepError{
+			code:    "provider_unresolved",
+			err:     fmt.Errorf("step %s: %w", step.ID, err),
+			details: details,
+		}
+	}
+	return provider, profile, nil
+}
+
+// ValidatePipeline reports steps (with the pipeline's
+// DefaultProviderProfileID applied) and fallbacks referencing provider
+// profiles that are not currently resolvable, fallbacks on steps without any
+// profile, InputFrom bindings and InputMapping pointers that do not name an
+// earlier step, unknown or mistyped Config keys, unknown PostProcess
+// transforms, and step kinds or modes the engine does not implement.
+// Profiles can still be registered later, so RegisterPipeline only logs
+// these problems.
+func (e *BasicEngine) ValidatePipeline(def PipelineDef) error {
+	var errs []error
+	if def.RequiredSources != nil && def.RequiredSources.MinCount < 0 {
+		errs = append(errs, fmt.Errorf("required_sources.min_count must not be negative: %d", def.RequiredSources.MinCount))
+	}
+	if def.MaxConcurrency < 0 {
+		errs = append(errs, fmt.Errorf("max_concurrency must not be negative: %d", def.MaxConcurrency))
+	}
+	seen := make(map[StepID]bool, len(def.Steps))
+	for _, step := range def.Steps {
+		if unsupported := checkStepFeatures(step); unsupported != nil {
+			errs = append(errs, unsupported)
+		}
+		if err := validateInputBindings(step, seen); err != nil {
+			errs = append(errs, err)
+		}
+		if err := validateInputMapping(step, seen); err != nil {
+			errs = append(errs, err)
+		}
+		if err := validateStepConfig(step); err != nil {
+			errs = append(errs, err)
+		}
+		if err := validateRetrieveStep(step); err != nil {
+			errs = append(errs, err)
+		}
+		if !validTruncationStrategy(step.TruncationStrategy) {
+			errs = append(errs, fmt.Errorf("step %s: truncation_strategy must be one of head, tail, middle, summarize_first or priority: %q", step.ID, step.TruncationStrategy))
+		}
+		if err := e.validatePostProcess(step); err != nil {
+			errs = append(errs, err)
+		}
+		seen[step.ID] = true
+		profileID := step.ProviderProfileID
+		if profileID == "" {
+			profileID = def.DefaultProviderProfileID
+		}
+		if profileID == "" && len(step.Fallbacks) > 0 {
+			errs = append(errs, fmt.Errorf("step %s: fallbacks require a provider_profile_id on the step or a pipeline default_provider_profile_id", step.ID))
+		}
+		if e.providers == nil {
+			continue
+		}
+		ids := step.Fallbacks
+		if profileID != "" {
+			ids = append([]ProviderProfileID{profileID}, ids...)
+		}
+		for _, id := range ids {
+			if _, _, err := e.providers.Resolve(StepDef{ID: step.ID, ProviderProfileID: id}); err != nil {
+				errs = append(errs, fmt.Errorf("step %s: %w", step.ID, err))
+			}
+		}
 	}
-	return provider, profile
+	return errors.Join(errs...)
+}
+
+func validateInputBindings(step StepDef, earlier map[StepID]bool) error {
+	var errs []error
+	names := make(map[string]bool, len(step.InputFrom))
+	perItem := 0
+	for _, binding := range step.InputFrom {
+		switch {
+		case binding.Name == "":
+			errs = append(errs, fmt.Errorf("step %s: input binding for step %s has no name", step.ID, binding.Step))
+		case names[binding.Name]:
+			errs = append(errs, fmt.Errorf("step %s: duplicate input binding %s", step.ID, binding.Name))
+		}
+		names[binding.Name] = true
+		if !earlier[binding.Step] {
+			errs = append(errs, fmt.Errorf("step %s: input %s references step %s, which does not run earlier", step.ID, binding.Name, binding.Step))
+		}
+		if binding.PerItem {
+			perItem++
+		}
+	}
+	if perItem > 1 {
+		errs = append(errs, fmt.Errorf("step %s: only one input binding can be per_item", step.ID))
+	}
+	return errors.Join(errs...)
 }
 
 func mergeMeta(dst map[string]any, meta map[string]any) {
@@ -810,6 +2241,29 @@ func mergeMeta(dst map[string]any, meta map[string]any) {
 	}
 }
 
+// sourceMetadataList returns the metadata of every source in input order, with
+// an empty map for sources without metadata, or nil when no source has any.
+func sourceMetadataList(sources []Source) []map[string]any {
+	found := false
+	for _, src := range sources {
+		if len(src.Metadata) > 0 {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil
+	}
+	list := make([]map[string]any, len(sources))
+	for i, src := range sources {
+		list[i] = cloneMap(src.Metadata)
+		if list[i] == nil {
+			list[i] = map[string]any{}
+		}
+	}
+	return list
+}
+
 func ensureContentType(ct ContentType) ContentType {
 	if ct == "" {
 		return ContentText
@@ -817,21 +2271,134 @@ func ensureContentType(ct ContentType) ContentType {
 	return ct
 }
 
-func appendExportedResultsForStep(job *Job, step StepDef, items []ResultItem) {
-	if !step.Export || len(items) == 0 {
+// completeShard records shard i of a fan-out or per-item step as finished for
+// progress reporting and, for exported steps, persists the results finished
+// so far in shard order, so streams emit item_completed per shard instead of
+// once the step finishes. Callers hold shards.mu.
+func (e *BasicEngine) completeShard(ctx context.Context, job *Job, execIdx int, step StepDef, shards *shardProgress, items []ResultItem, i int) {
+	if execIdx >= 0 && execIdx < len(job.StepExecutions) {
+		job.StepExecutions[execIdx].ShardsDone++
+	}
+	if ctx.Err() != nil {
+		// The stored job may already be cancelled; the step runner hands the
+		// shard to keepPartialOutput instead (see unexportedShards).
+		shards.done[i] = true
 		return
 	}
-	appendExportedResults(job, items)
+	from, to := shards.finish(i)
+	// With an export sink the whole step is exported once it finishes, so
+	// a failed write fails the step instead of a single shard.
+	if job.ExportSink == "" && to > from {
+		_ = e.appendExportedResults(ctx, job, step, items[from:to])
+	}
+	job.UpdatedAt = time.Now().UTC()
+	_ = e.saveJob(ctx, job)
+}
+
+// unexportedShards returns the shards that finished after ctx ended and were
+// therefore not exported by completeShard, so a cancelled job keeps them.
+// With an export sink nothing was exported yet and every finished shard is
+// returned.
+func unexportedShards(ctx context.Context, job *Job, shards *shardProgress, items []ResultItem) []ResultItem {
+	if ctx.Err() == nil {
+		return nil
+	}
+	shards.mu.Lock()
+	defer shards.mu.Unlock()
+	if job.ExportSink != "" {
+		var finished []ResultItem
+		for i, done := range shards.done {
+			if done {
+				finished = append(finished, items[i])
+			}
+		}
+		return finished
+	}
+	from, to := shards.flush()
+	return items[from:to]
 }
 
-func appendExportedResults(job *Job, items []ResultItem) {
-	if len(items) == 0 {
-		return
+func setShardTotal(job *Job, execIdx int, total int) {
+	if execIdx >= 0 && execIdx < len(job.StepExecutions) {
+		job.StepExecutions[execIdx].ShardsTotal = total
+		job.StepExecutions[execIdx].ShardsDone = 0
+	}
+}
+
+// saveJob refreshes the derived Progress before persisting the job. The
+// write ignores ctx cancellation so the final state of a cancelled job still
+// reaches the store; only ctx values are kept.
+func (e *BasicEngine) saveJob(ctx context.Context, job *Job) error {
+	job.Progress = jobProgress(job)
+	return e.store.UpdateJob(context.WithoutCancel(ctx), redactJob(job))
+}
+
+// jobProgress reports completed work as a fraction of non-skipped steps.
+// Running fan-out and per-item steps contribute their finished share of
+// shards; succeeded jobs always report 1.
+func jobProgress(job *Job) float64 {
+	if job.Status == JobStatusSucceeded {
+		return 1
+	}
+	var done, total float64
+	for _, exec := range job.StepExecutions {
+		switch exec.Status {
+		case StepExecSkipped:
+			continue
+		case StepExecSuccess:
+			done++
+		case StepExecRunning:
+			if exec.ShardsTotal > 0 {
+				done += float64(exec.ShardsDone) / float64(exec.ShardsTotal)
+			}
+		}
+		total++
+	}
+	if total == 0 {
+		return 0
+	}
+	return done / total
+}
+
+func exportedItemCount(job *Job) int {
+	if job.Result == nil {
+		return 0
+	}
+	return len(job.Result.Items)
+}
+
+// exportsStep reports whether step's results are exported for job: the step's
+// Export flag or the job's ExportSteps.
+func exportsStep(job *Job, step StepDef) bool {
+	return step.Export || slices.Contains(job.ExportSteps, step.ID)
+}
+
+// appendExportedResults adds copies of an exported step's items to
+// job.Result, stripping the rendered prompt unless prompts are exported.
+// With an export sink the copies are written there first and keep only the
+// returned URI.
+func (e *BasicEngine) appendExportedResults(ctx context.Context, job *Job, step StepDef, items []ResultItem) error {
+	if !exportsStep(job, step) || len(items) == 0 {
+		return nil
 	}
 	if job.Result == nil {
 		job.Result = &JobResult{}
 	}
-	job.Result.Items = append(job.Result.Items, cloneResultItems(items)...)
+	exported := cloneResultItems(items)
+	if !e.exportPrompt && !step.ExportPrompt {
+		for _, item := range exported {
+			if data, ok := item.Data.(map[string]any); ok {
+				delete(data, "prompt")
+			}
+		}
+	}
+	if job.ExportSink != "" {
+		if err := e.writeExportSink(ctx, job, exported); err != nil {
+			return err
+		}
+	}
+	job.Result.Items = append(job.Result.Items, exported...)
+	return nil
 }
 
 func cloneResultItems(items []ResultItem) []ResultItem {
@@ -868,7 +2435,7 @@ func ptrString(s string) *string {
 	return &s
 }
 
-func (e *BasicEngine) failStep(job *Job, idx int, code, message string) {
+func (e *BasicEngine) failStep(ctx context.Context, job *Job, idx int, code, message string, details any) {
 	if idx < 0 || idx >= len(job.StepExecutions) {
 		return
 	}
@@ -876,11 +2443,63 @@ func (e *BasicEngine) failStep(job *Job, idx int, code, message string) {
 	exec := &job.StepExecutions[idx]
 	exec.Status = StepExecFailed
 	exec.FinishedAt = ptrTime(finish)
-	exec.Error = &JobError{Code: code, Message: message}
+	exec.Error = &JobError{Code: code, Message: message, Details: details}
 	job.Status = JobStatusFailed
 	job.Error = exec.Error
 	job.UpdatedAt = finish
-	_ = e.store.UpdateJob(job)
+	_ = e.saveJob(ctx, job)
+	metrics.ObserveJobOutcome(string(job.PipelineType), string(job.Status), finish.Sub(job.CreatedAt))
+}
+
+// stepError carries an explicit error code for a failed step.
+type stepError struct {
+	code    string
+	err     error
+	details any
+}
+
+func (e *stepError) Error() string {
+	return e.err.Error()
+}
+
+func (e *stepError) Unwrap() error {
+	return e.err
+}
+
+func stepErrorDetails(err error) any {
+	var se *stepError
+	if errors.As(err, &se) {
+		return se.details
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return nil
+	}
+	if _, details, ok := providerErrorCode(err); ok {
+		return details
+	}
+	return nil
+}
+
+// stepErrorCode returns the code recorded on a failed step: the stepError
+// code, cancelled, one of the provider_*_error codes, timeout, or
+// step_failed.
+func stepErrorCode(err error) string {
+	var se *stepError
+	switch {
+	case errors.As(err, &se) && se.code != "":
+		return se.code
+	case errors.Is(err, context.Canceled):
+		return "cancelled"
+	}
+	if code, _, ok := providerErrorCode(err); ok {
+		return code
+	}
+	// Checked after provider errors: an HTTP client timeout also matches
+	// DeadlineExceeded but is reported as provider_network_error.
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "timeout"
+	}
+	return "step_failed"
 }
 
 func isTerminal(status JobStatus) bool {
@@ -896,6 +2515,12 @@ func ptrTime(t time.Time) *time.Time {
 	return &t
 }
 
+// NewBatchID returns an identifier used to group jobs submitted together,
+// taken from the engine's ID generator like job IDs.
+func (e *BasicEngine) NewBatchID() string {
+	return "batch-" + e.idGenerator()
+}
+
 func generateID() string {
 	b := make([]byte, 16)
 	if _, err := rand.Read(b); err != nil {
@@ -2,13 +2,28 @@ package engine_test
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"io/fs"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/example/pipeline-engine/internal/engine"
 	"github.com/example/pipeline-engine/internal/store"
+	"github.com/example/pipeline-engine/pkg/enginetest"
+	"github.com/example/pipeline-engine/pkg/logging"
 )
 
 func TestBasicEngine_RunJobWithSamplePipeline(t *testing.T) {
@@ -64,7 +79,8 @@ func TestBasicEngine_CancelJobStopsExecution(t *testing.T) {
 	t.Parallel()
 
 	memoryStore := store.NewMemoryStore()
-	eng := engine.NewBasicEngine(memoryStore)
+	// 実行中の状態を観測できるようスタブ出力のステップに遅延を入れる。
+	eng := engine.NewBasicEngineWithConfig(memoryStore, &engine.EngineConfig{SimulatedLatency: 100 * time.Millisecond})
 
 	ctx := context.Background()
 	job, err := eng.RunJob(ctx, sampleJobRequest())
@@ -82,6 +98,9 @@ func TestBasicEngine_CancelJobStopsExecution(t *testing.T) {
 	if finalJob.Error == nil || finalJob.Error.Code != "cancelled" {
 		t.Fatalf("キャンセル後のエラー情報が不正です: %+v", finalJob.Error)
 	}
+	if c := finalJob.Cancellation; c == nil || c.By != engine.CancelByUser || c.Reason != "test cancel" || c.At.IsZero() {
+		t.Fatalf("文字列の理由が Cancellation に変換されていません: %+v", finalJob.Cancellation)
+	}
 
 	for _, step := range finalJob.StepExecutions {
 		if step.Status != engine.StepExecCancelled {
@@ -90,6 +109,145 @@ func TestBasicEngine_CancelJobStopsExecution(t *testing.T) {
 	}
 }
 
+func TestBasicEngine_CancelJobWithDetailsRecordsCancellation(t *testing.T) {
+	t.Parallel()
+
+	memoryStore := store.NewMemoryStore()
+	// 実行中の状態を観測できるようスタブ出力のステップに遅延を入れる。
+	eng := engine.NewBasicEngineWithConfig(memoryStore, &engine.EngineConfig{SimulatedLatency: 100 * time.Millisecond})
+
+	ctx := context.Background()
+	job, err := eng.RunJob(ctx, sampleJobRequest())
+	if err != nil {
+		t.Fatalf("ジョブの起動に失敗しました: %v", err)
+	}
+	_ = waitForJobStatus(t, memoryStore, job.ID, engine.JobStatusRunning, 2*time.Second)
+
+	if err := eng.CancelJobWithDetails(ctx, job.ID, engine.Cancellation{By: "robot"}); err == nil {
+		t.Fatal("不正な by がエラーになっていません")
+	}
+
+	cancellation := engine.Cancellation{By: engine.CancelByTimeout, Code: "deadline_exceeded"}
+	if err := eng.CancelJobWithDetails(ctx, job.ID, cancellation); err != nil {
+		t.Fatalf("ジョブのキャンセルに失敗しました: %v", err)
+	}
+
+	finalJob := waitForJobStatus(t, memoryStore, job.ID, engine.JobStatusCancelled, 3*time.Second)
+	c := finalJob.Cancellation
+	if c == nil || c.By != engine.CancelByTimeout || c.Code != "deadline_exceeded" || c.At.IsZero() {
+		t.Fatalf("Cancellation が記録されていません: %+v", c)
+	}
+	if finalJob.Error == nil || finalJob.Error.Message != "cancelled by timeout" {
+		t.Fatalf("既定のキャンセル理由が不正です: %+v", finalJob.Error)
+	}
+
+	if err := eng.CancelJob(ctx, job.ID, "again"); err != nil {
+		t.Fatalf("終了済みジョブのキャンセルは既定で no-op のはずです: %v", err)
+	}
+	if err := eng.CancelJobWithDetails(ctx, job.ID, engine.Cancellation{Reason: "again", Strict: true}); !errors.Is(err, engine.ErrJobTerminal) {
+		t.Fatalf("strict なキャンセルは ErrJobTerminal になるべきです: %v", err)
+	}
+	if after, _ := memoryStore.GetJob(ctx, job.ID); after.Cancellation == nil || after.Cancellation.Code != "deadline_exceeded" {
+		t.Fatalf("終了済みジョブの Cancellation が書き換えられました: %+v", after.Cancellation)
+	}
+}
+
+func TestBasicEngine_CancelOnDisconnect(t *testing.T) {
+	t.Parallel()
+
+	memoryStore := store.NewMemoryStore()
+	eng := engine.NewBasicEngineWithConfig(memoryStore, &engine.EngineConfig{CancelOnDisconnect: true, SimulatedLatency: 100 * time.Millisecond})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events, job, err := eng.RunJobStream(ctx, sampleJobRequest())
+	if err != nil {
+		t.Fatalf("ジョブストリームの起動に失敗しました: %v", err)
+	}
+	<-events
+	_ = waitForJobStatus(t, memoryStore, job.ID, engine.JobStatusRunning, 2*time.Second)
+	cancel()
+
+	// 切断後もストリームは job_cancelled まで届く。
+	var cancelled bool
+	timeout := time.After(3 * time.Second)
+	for ev := range events {
+		if ev.Event == "job_cancelled" {
+			cancelled = true
+		}
+		select {
+		case <-timeout:
+			t.Fatal("ストリームの終了待ちがタイムアウトしました")
+		default:
+		}
+	}
+	if !cancelled {
+		t.Fatal("切断後に job_cancelled が送出されていません")
+	}
+	finalJob := waitForJobStatus(t, memoryStore, job.ID, engine.JobStatusCancelled, time.Second)
+	if c := finalJob.Cancellation; c == nil || c.By != engine.CancelBySystem || c.Code != "client_disconnected" {
+		t.Fatalf("切断によるキャンセルが記録されていません: %+v", finalJob.Cancellation)
+	}
+}
+
+func TestBasicEngine_SyncJobCancelledWithRequestContext(t *testing.T) {
+	t.Parallel()
+
+	stub := enginetest.NewStubProvider()
+	stub.SetDefault(enginetest.StubResponse{Output: "遅い応答", Latency: 5 * time.Second})
+	eng := enginetest.NewEngine(t, stub)
+	eng.RegisterPipeline(engine.PipelineDef{
+		Type:  "slow_sync_pipeline",
+		Steps: []engine.StepDef{{ID: "slow", Kind: engine.StepKindLLM, ProviderProfileID: enginetest.StubProfileID}},
+	})
+	req := sampleJobRequest()
+	req.PipelineType = "slow_sync_pipeline"
+	req.Mode = "sync"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	job, err := eng.RunJob(ctx, req)
+	if err != nil {
+		t.Fatalf("ジョブ実行に失敗しました: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("リクエストのキャンセル後も sync ジョブが実行され続けました: %s", elapsed)
+	}
+	if job.Status != engine.JobStatusCancelled {
+		t.Fatalf("sync ジョブがキャンセルされていません: %s", job.Status)
+	}
+	if c := job.Cancellation; c == nil || c.By != engine.CancelBySystem || c.Code != "request_cancelled" {
+		t.Fatalf("リクエスト終了によるキャンセルが記録されていません: %+v", job.Cancellation)
+	}
+	if job.StepExecutions[0].Status != engine.StepExecCancelled {
+		t.Fatalf("実行中のステップがキャンセル扱いになっていません: %+v", job.StepExecutions[0])
+	}
+
+	// async ジョブは作成リクエストのコンテキストから切り離されたまま。
+	stub.SetDefault(enginetest.StubResponse{Output: "完了"})
+	req.Mode = "async"
+	asyncCtx, asyncCancel := context.WithCancel(context.Background())
+	asyncJob, err := eng.RunJob(asyncCtx, req)
+	if err != nil {
+		t.Fatalf("ジョブ作成に失敗しました: %v", err)
+	}
+	asyncCancel()
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		got, err := eng.GetJob(context.Background(), asyncJob.ID)
+		if err != nil {
+			t.Fatalf("ジョブ取得に失敗しました: %v", err)
+		}
+		if got.Status == engine.JobStatusSucceeded {
+			break
+		}
+		if got.Status == engine.JobStatusCancelled || time.Now().After(deadline) {
+			t.Fatalf("async ジョブはリクエスト終了後も完了するべきです: %s", got.Status)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
 func TestBasicEngine_RunJobStreamEmitsStatusTransitions(t *testing.T) {
 	t.Parallel()
 
@@ -107,6 +265,15 @@ func TestBasicEngine_RunJobStreamEmitsStatusTransitions(t *testing.T) {
 		t.Fatal("RunJobStream から返却されたジョブが nil です")
 	}
 
+	select {
+	case first := <-events:
+		if first.Event != "job_queued" || first.Seq != 1 || first.JobID != job.ID {
+			t.Fatalf("最初のイベントが job_queued ではありません: %+v", first)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("job_queued イベントの待機がタイムアウトしました")
+	}
+
 	statuses := make([]engine.JobStatus, 0, 3)
 	timeout := time.After(3 * time.Second)
 
@@ -168,6 +335,7 @@ func TestBasicEngine_RunJobWithRegisteredPipeline(t *testing.T) {
 				OutputType: engine.ContentMarkdown,
 				DependsOn:  []engine.StepID{engine.StepID("ingest")},
 				Export:     true,
+				ExportTag:  "summary",
 			},
 		},
 	}
@@ -198,6 +366,15 @@ func TestBasicEngine_RunJobWithRegisteredPipeline(t *testing.T) {
 	if finalJob.Result == nil || len(finalJob.Result.Items) < 2 {
 		t.Fatalf("multi step ジョブの結果が不足しています: %+v", finalJob.Result)
 	}
+	for _, item := range finalJob.Result.Items {
+		want := ""
+		if item.StepID == engine.StepID("summarize") {
+			want = "summary"
+		}
+		if item.Tag != want {
+			t.Fatalf("ResultItem.Tag に ExportTag が反映されていません: %+v", item)
+		}
+	}
 }
 
 func TestBasicEngine_RerunReuseUpstream(t *testing.T) {
@@ -269,110 +446,3544 @@ func TestBasicEngine_RerunReuseUpstream(t *testing.T) {
 	}
 }
 
-func TestBasicEngine_ProviderOverrideApplied(t *testing.T) {
+func TestBasicEngine_RerunReuseSelectedSteps(t *testing.T) {
 	t.Parallel()
 
 	memoryStore := store.NewMemoryStore()
-	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"dummy"}}]}`))
-	}))
-	defer ts.Close()
-	cfg := &engine.EngineConfig{
-		Providers: []engine.ProviderProfile{
-			{
-				ID:           engine.ProviderProfileID("custom-openai"),
-				Kind:         engine.ProviderOpenAI,
-				DefaultModel: "gpt-dummy",
-				BaseURI:      ts.URL,
-				APIKey:       "test",
-			},
+	eng := engine.NewBasicEngine(memoryStore)
+	pipeline := engine.PipelineDef{
+		Type:    "rerun_selected_pipeline",
+		Version: "v1",
+		Steps: []engine.StepDef{
+			{ID: "collect", Kind: engine.StepKindMap, Mode: engine.StepModeFanOut, OutputType: engine.ContentText},
+			{ID: "summarize", Kind: engine.StepKindLLM, Mode: engine.StepModeSingle, OutputType: engine.ContentText, DependsOn: []engine.StepID{"collect"}},
+			{ID: "finalize", Kind: engine.StepKindLLM, Mode: engine.StepModeSingle, OutputType: engine.ContentMarkdown, DependsOn: []engine.StepID{"summarize"}, Export: true},
 		},
 	}
-	eng := engine.NewBasicEngineWithConfig(memoryStore, cfg)
+	eng.RegisterPipeline(pipeline)
 
+	baseReq := sampleJobRequest()
+	baseReq.PipelineType = pipeline.Type
+	baseReq.Mode = "sync"
+	baseJob, err := eng.RunJob(context.Background(), baseReq)
+	if err != nil || baseJob.Status != engine.JobStatusSucceeded {
+		t.Fatalf("ベースジョブが成功していません: job=%+v err=%v", baseJob, err)
+	}
+
+	parentID := baseJob.ID
+	fromStep := engine.StepID("finalize")
+	rerunReq := sampleJobRequest()
+	rerunReq.PipelineType = pipeline.Type
+	rerunReq.Mode = "sync"
+	rerunReq.ParentJobID = &parentID
+	rerunReq.FromStepID = &fromStep
+	rerunReq.ReuseSteps = []engine.StepID{"collect"}
+
+	rerunJob, err := eng.RunJob(context.Background(), rerunReq)
+	if err != nil {
+		t.Fatalf("rerun ジョブの起動に失敗しました: %v", err)
+	}
+	if rerunJob.Status != engine.JobStatusSucceeded {
+		t.Fatalf("rerun ジョブが success ではありません: %+v", rerunJob)
+	}
+	want := []engine.StepExecutionStatus{engine.StepExecSkipped, engine.StepExecSuccess, engine.StepExecSuccess}
+	for i, status := range want {
+		if got := rerunJob.StepExecutions[i].Status; got != status {
+			t.Fatalf("%s の状態が想定外です: got=%s want=%s", rerunJob.StepExecutions[i].StepID, got, status)
+		}
+	}
+
+	rerunReq.ReuseSteps = []engine.StepID{"finalize"}
+	if _, err := eng.RunJob(context.Background(), rerunReq); err == nil {
+		t.Fatal("from_step_id 以降のステップを reuse_steps に指定した場合はエラーになるべきです")
+	}
+	rerunReq.ReuseSteps = []engine.StepID{"collect"}
+	rerunReq.ParentJobID = nil
+	if _, err := eng.RunJob(context.Background(), rerunReq); err == nil {
+		t.Fatal("parent_job_id なしの reuse_steps はエラーになるべきです")
+	}
+}
+
+func TestBasicEngine_JobPipelineSnapshot(t *testing.T) {
+	t.Parallel()
+
+	memoryStore := store.NewMemoryStore()
+	eng := engine.NewBasicEngine(memoryStore)
 	pipeline := engine.PipelineDef{
-		Type:    "provider_pipeline",
+		Type:    "snapshot_pipeline",
 		Version: "v1",
 		Steps: []engine.StepDef{
-			{
-				ID:                engine.StepID("summarize"),
-				Name:              "Summarize",
-				Kind:              engine.StepKindLLM,
-				Mode:              engine.StepModeSingle,
-				OutputType:        engine.ContentText,
-				Export:            true,
-				ProviderProfileID: engine.ProviderProfileID("custom-openai"),
-				ProviderOverride: map[string]any{
-					"default_model": "gpt-override",
-				},
-			},
+			{ID: "draft", Kind: engine.StepKindLLM, Mode: engine.StepModeSingle, OutputType: engine.ContentText, Prompt: &engine.PromptTemplate{System: "first"}, Export: true},
 		},
 	}
 	eng.RegisterPipeline(pipeline)
 
 	req := sampleJobRequest()
 	req.PipelineType = pipeline.Type
-
+	req.Mode = "sync"
 	job, err := eng.RunJob(context.Background(), req)
 	if err != nil {
-		t.Fatalf("provider pipeline ジョブの起動に失敗しました: %v", err)
+		t.Fatalf("ジョブの実行に失敗しました: %v", err)
 	}
 
-	finalJob := waitForJobStatus(t, memoryStore, job.ID, engine.JobStatusSucceeded, 3*time.Second)
-	if finalJob.Result == nil || len(finalJob.Result.Items) == 0 {
-		t.Fatalf("provider pipeline の結果が空です: %+v", finalJob.Result)
+	// 同じバージョンを別の内容で登録し直しても、ジョブのスナップショットは変わらない。
+	pipeline.Steps = append(pipeline.Steps, engine.StepDef{ID: "review"})
+	pipeline.Steps[0].Prompt = &engine.PromptTemplate{System: "second"}
+	eng.RegisterPipeline(pipeline)
+
+	snapshot, err := eng.JobPipeline(context.Background(), job.ID)
+	if err != nil {
+		t.Fatalf("スナップショットの取得に失敗しました: %v", err)
+	}
+	if len(snapshot.Steps) != 1 || snapshot.Steps[0].Prompt == nil || snapshot.Steps[0].Prompt.System != "first" {
+		t.Fatalf("ジョブ作成時の定義が返っていません: %+v", snapshot)
 	}
+	if _, err := eng.JobPipeline(context.Background(), "missing"); err == nil {
+		t.Fatal("存在しないジョブではエラーになるべきです")
+	}
+}
 
-	data, ok := finalJob.Result.Items[0].Data.(map[string]any)
-	if !ok {
-		t.Fatalf("結果データを map へ変換できません: %+v", finalJob.Result.Items[0].Data)
+func TestBasicEngine_ChatHistory(t *testing.T) {
+	t.Parallel()
+
+	step := engine.StepDef{
+		ID:     "chat",
+		Kind:   engine.StepKindLLM,
+		Prompt: &engine.PromptTemplate{User: "{{range .History}}{{.Role}}={{.Content}};{{end}}"},
 	}
-	if got := data["model"]; got != "gpt-override" {
-		t.Fatalf("プロバイダ override が反映されていません: %v", got)
+	input := engine.JobInput{History: []engine.ChatMessage{{Role: "user", Content: "こんにちは"}, {Role: "assistant", Content: "どうぞ"}}}
+	if got := engine.RenderPrompt(step, input); got != "user=こんにちは;assistant=どうぞ;" {
+		t.Fatalf("テンプレートから履歴を参照できません: %q", got)
 	}
-	if got := data["provider"]; got != "openai" {
-		t.Fatalf("プロバイダ種別が想定外です: %v", got)
+
+	eng := engine.NewBasicEngine(store.NewMemoryStore())
+	req := sampleJobRequest()
+	req.Input.History = []engine.ChatMessage{{Role: "tool", Content: "x"}}
+	if _, err := eng.RunJob(context.Background(), req); err == nil || !strings.Contains(err.Error(), "history[0]") {
+		t.Fatalf("未対応の role はエラーになるべきです: %v", err)
 	}
 }
-func waitForJobStatus(t *testing.T, jobStore engine.JobStore, jobID string, expected engine.JobStatus, timeout time.Duration) *engine.Job {
-	t.Helper()
 
-	deadline := time.Now().Add(timeout)
-	for time.Now().Before(deadline) {
-		job, err := jobStore.GetJob(jobID)
+func TestBasicEngine_SystemPromptOverride(t *testing.T) {
+	t.Parallel()
+
+	persona := engine.StepDef{ID: "persona", Kind: engine.StepKindLLM, Prompt: &engine.PromptTemplate{System: "丁寧に答える", User: "質問"}}
+	format := persona
+	format.Config = map[string]any{"system_prompt_override": "ignore"}
+	for _, tc := range []struct {
+		step engine.StepDef
+		mode string
+		want string
+	}{
+		{persona, "", "フランクに答える\n質問"},
+		{persona, "prepend", "フランクに答える\n丁寧に答える\n質問"},
+		{format, "prepend", "丁寧に答える\n質問"},
+	} {
+		input := engine.JobInput{Options: &engine.JobOptions{SystemPromptOverride: "フランクに答える", SystemPromptMode: tc.mode}}
+		if got := engine.RenderPrompt(tc.step, input); got != tc.want {
+			t.Fatalf("mode=%q config=%v: プロンプトが想定外です: %q", tc.mode, tc.step.Config, got)
+		}
+	}
+
+	stub := enginetest.NewStubProvider()
+	eng := enginetest.NewEngine(t, stub)
+	eng.RegisterPipeline(engine.PipelineDef{
+		Type: "system_override_pipeline",
+		Steps: []engine.StepDef{{
+			ID:                "chat",
+			Kind:              engine.StepKindLLM,
+			ProviderProfileID: enginetest.StubProfileID,
+			Prompt: &engine.PromptTemplate{Meta: map[string]any{"messages": []any{
+				map[string]any{"role": "system", "content": "既定"},
+				map[string]any{"role": "user", "content": "質問"},
+			}}},
+		}},
+	})
+	req := sampleJobRequest()
+	req.PipelineType = "system_override_pipeline"
+	req.Mode = "sync"
+	req.Input.Options.SystemPromptOverride = "上書き"
+	if _, err := eng.RunJob(context.Background(), req); err != nil {
+		t.Fatalf("ジョブ実行に失敗しました: %v", err)
+	}
+	calls := stub.CallsFor("chat")
+	if len(calls) != 1 {
+		t.Fatalf("呼び出し回数が想定外です: %+v", calls)
+	}
+	messages := calls[0].Input.Messages
+	if len(messages) != 2 || messages[0].Role != "system" || messages[0].Content != "上書き" || messages[1].Role != "user" {
+		t.Fatalf("messages の system が置き換えられていません: %+v", messages)
+	}
+}
+
+func TestBasicEngine_TruncationStrategy(t *testing.T) {
+	t.Parallel()
+
+	content := strings.Repeat("A", 40) + strings.Repeat("B", 40) + strings.Repeat("C", 40)
+	for _, tc := range []struct {
+		strategy engine.TruncationStrategy
+		check    func(prompt string) bool
+	}{
+		{engine.TruncateHead, func(p string) bool { return strings.HasPrefix(p, "AAAA") && !strings.Contains(p, "C") }},
+		{engine.TruncateTail, func(p string) bool { return strings.HasSuffix(p, "CCCC") && !strings.Contains(p, "A") }},
+		{engine.TruncateMiddle, func(p string) bool {
+			return strings.HasPrefix(p, "AAAA") && strings.HasSuffix(p, "CCCC") && strings.Count(p, "B") < 40
+		}},
+	} {
+		stub := enginetest.NewStubProvider()
+		eng := enginetest.NewEngine(t, stub)
+		eng.RegisterPipeline(engine.PipelineDef{
+			Type: "truncation_pipeline",
+			Steps: []engine.StepDef{{
+				ID:                 "summarize",
+				Kind:               engine.StepKindLLM,
+				ProviderProfileID:  enginetest.StubProfileID,
+				ProviderOverride:   map[string]any{"context_window": 20},
+				Prompt:             &engine.PromptTemplate{User: "{{range .Sources}}{{.Content}}{{end}}"},
+				TruncationStrategy: tc.strategy,
+			}},
+		})
+		req := sampleJobRequest()
+		req.PipelineType = "truncation_pipeline"
+		req.Mode = "sync"
+		req.Input.Sources = []engine.Source{{Kind: engine.SourceKindLog, Label: "log", Content: content}}
+		job, err := eng.RunJob(context.Background(), req)
 		if err != nil {
-			t.Fatalf("ジョブ %s の取得に失敗しました: %v", jobID, err)
+			t.Fatalf("%s: ジョブ実行に失敗しました: %v", tc.strategy, err)
+		}
+		if job.Status != engine.JobStatusSucceeded {
+			t.Fatalf("%s: ジョブが成功していません: %s %+v", tc.strategy, job.Status, job.Error)
+		}
+		calls := stub.CallsFor("summarize")
+		if len(calls) != 1 {
+			t.Fatalf("%s: 呼び出し回数が想定外です: %d", tc.strategy, len(calls))
+		}
+		prompt := calls[0].Prompt
+		if len(prompt) > 80 || !tc.check(prompt) {
+			t.Fatalf("%s: 切り詰め結果が想定外です: %q", tc.strategy, prompt)
+		}
+		if got := calls[0].Input.Sources[0].Content; got != prompt {
+			t.Fatalf("%s: Provider に渡るソースも切り詰めるべきです: %q", tc.strategy, got)
+		}
+		report := job.StepExecutions[0].Truncation
+		if report == nil || report.Strategy != tc.strategy || report.OriginalTokens != 30 || report.DroppedChars != 120-len(prompt) {
+			t.Fatalf("%s: 切り詰めの記録が想定外です: %+v", tc.strategy, report)
 		}
+	}
 
-		if job.Status == expected {
-			return job
+	stub := enginetest.NewStubProvider()
+	eng := enginetest.NewEngine(t, stub)
+	eng.RegisterPipeline(engine.PipelineDef{
+		Type: "strict_window_pipeline",
+		Steps: []engine.StepDef{{
+			ID:                "summarize",
+			Kind:              engine.StepKindLLM,
+			ProviderProfileID: enginetest.StubProfileID,
+			ProviderOverride:  map[string]any{"context_window": 20},
+			Prompt:            &engine.PromptTemplate{User: "{{range .Sources}}{{.Content}}{{end}}"},
+		}},
+	})
+	req := sampleJobRequest()
+	req.PipelineType = "strict_window_pipeline"
+	req.Mode = "sync"
+	req.Input.Sources = []engine.Source{{Kind: engine.SourceKindLog, Content: content}}
+	job, err := eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ジョブ実行に失敗しました: %v", err)
+	}
+	if job.Status != engine.JobStatusFailed || job.Error == nil || job.Error.Code != "context_window_exceeded" {
+		t.Fatalf("戦略なしでは context_window_exceeded で失敗するべきです: %s %+v", job.Status, job.Error)
+	}
+
+	req.Input.Options.TruncationStrategy = engine.TruncateTail
+	job, err = eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ジョブ実行に失敗しました: %v", err)
+	}
+	if job.Status != engine.JobStatusSucceeded || job.StepExecutions[0].Truncation == nil {
+		t.Fatalf("options.truncation_strategy が適用されていません: %s %+v", job.Status, job.StepExecutions[0])
+	}
+
+	req.Input.Options.TruncationStrategy = "random"
+	if _, err := eng.RunJob(context.Background(), req); err == nil || !strings.Contains(err.Error(), "truncation_strategy") {
+		t.Fatalf("未知の戦略はエラーになるべきです: %v", err)
+	}
+}
+
+func TestBasicEngine_SourcePriority(t *testing.T) {
+	t.Parallel()
+
+	sources := []engine.Source{
+		{Kind: engine.SourceKindLog, Label: "low", Content: "LLLL", Priority: -1},
+		{Kind: engine.SourceKindLog, Label: "plain", Content: "PPPP"},
+		{Kind: engine.SourceKindLog, Label: "high", Content: "HHHH", Priority: 2},
+		{Kind: engine.SourceKindLog, Label: "plain2", Content: "QQQQ"},
+	}
+	for _, tc := range []struct {
+		name     string
+		config   map[string]any
+		template string
+		prompt   string
+		labels   []string
+	}{
+		{"default", nil, "{{range .Sources}}{{.Content}}{{end}}", "LLLLPPPPHHHHQQQQ", []string{"low", "plain", "high", "plain2"}},
+		{"priority", map[string]any{engine.SourceOrderConfigKey: "priority"}, "{{range .Sources}}{{.Content}}{{end}}", "HHHHPPPPQQQQLLLL", []string{"high", "plain", "plain2", "low"}},
+		{"max_sources", map[string]any{engine.SourceOrderConfigKey: "priority", engine.MaxSourcesConfigKey: 2}, "{{range .Sources}}{{.Content}}{{end}}", "HHHHPPPP", []string{"high", "plain"}},
+		{"template", nil, "{{range topSources 2 .Sources}}{{.Content}}{{end}}|{{range byPriority .Sources}}{{.Label}},{{end}}", "HHHHPPPP|high,plain,plain2,low,", []string{"low", "plain", "high", "plain2"}},
+	} {
+		stub := enginetest.NewStubProvider()
+		eng := enginetest.NewEngine(t, stub)
+		eng.RegisterPipeline(engine.PipelineDef{
+			Type: "priority_pipeline",
+			Steps: []engine.StepDef{{
+				ID:                "summarize",
+				Kind:              engine.StepKindLLM,
+				ProviderProfileID: enginetest.StubProfileID,
+				Prompt:            &engine.PromptTemplate{User: tc.template},
+				Config:            tc.config,
+			}},
+		})
+		req := sampleJobRequest()
+		req.PipelineType = "priority_pipeline"
+		req.Mode = "sync"
+		req.Input.Sources = sources
+		job, err := eng.RunJob(context.Background(), req)
+		if err != nil || job.Status != engine.JobStatusSucceeded {
+			t.Fatalf("%s: ジョブが成功していません: %v %+v", tc.name, err, job)
+		}
+		calls := stub.CallsFor("summarize")
+		if len(calls) != 1 || calls[0].Prompt != tc.prompt {
+			t.Fatalf("%s: プロンプトが想定外です: %+v", tc.name, calls)
+		}
+		var labels []string
+		for _, src := range calls[0].Input.Sources {
+			labels = append(labels, src.Label)
+		}
+		if !reflect.DeepEqual(labels, tc.labels) {
+			t.Fatalf("%s: Provider に渡るソースの順序が想定外です: %v", tc.name, labels)
+		}
+		if got := job.Input.Sources[0].Label; got != "low" {
+			t.Fatalf("%s: ジョブ入力の順序は変えないべきです: %s", tc.name, got)
 		}
+	}
 
-		if job.Status == engine.JobStatusFailed || job.Status == engine.JobStatusCancelled {
-			t.Fatalf("ジョブ %s が予期せぬ最終状態になりました: %s", jobID, job.Status)
+	stub := enginetest.NewStubProvider()
+	eng := enginetest.NewEngine(t, stub)
+	eng.RegisterPipeline(engine.PipelineDef{
+		Type: "priority_truncation_pipeline",
+		Steps: []engine.StepDef{{
+			ID:                 "summarize",
+			Kind:               engine.StepKindLLM,
+			ProviderProfileID:  enginetest.StubProfileID,
+			ProviderOverride:   map[string]any{"context_window": 3},
+			Prompt:             &engine.PromptTemplate{User: "{{range .Sources}}{{.Content}}{{end}}"},
+			TruncationStrategy: engine.TruncatePriority,
+		}},
+	})
+	req := sampleJobRequest()
+	req.PipelineType = "priority_truncation_pipeline"
+	req.Mode = "sync"
+	req.Input.Sources = sources
+	job, err := eng.RunJob(context.Background(), req)
+	if err != nil || job.Status != engine.JobStatusSucceeded {
+		t.Fatalf("priority 切り詰めのジョブが成功していません: %v %+v", err, job)
+	}
+	calls := stub.CallsFor("summarize")
+	if len(calls) != 1 || calls[0].Prompt != "PPPPHHHH" {
+		t.Fatalf("優先度の低いソースから外すべきです: %+v", calls)
+	}
+	report := job.StepExecutions[0].Truncation
+	if report == nil || report.DroppedSources != 2 || report.DroppedChars != 8 {
+		t.Fatalf("切り詰めの記録が想定外です: %+v", report)
+	}
+
+	err = eng.ValidatePipeline(engine.PipelineDef{
+		Type:  "invalid_order",
+		Steps: []engine.StepDef{{ID: "s", Kind: engine.StepKindLLM, Config: map[string]any{engine.SourceOrderConfigKey: "random"}}},
+	})
+	if err == nil || !strings.Contains(err.Error(), engine.SourceOrderConfigKey) {
+		t.Fatalf("未知の source_order は検証エラーになるべきです: %v", err)
+	}
+}
+
+func TestBasicEngine_Webhook(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu       sync.Mutex
+		received []engine.StreamingEvent
+		attempts int
+	)
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event engine.StreamingEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("webhook ペイロードのデコードに失敗しました: %v", err)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if event.Event == "job_completed" {
+			attempts++
+			if attempts == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			defer close(done)
+		}
+		if r.Header.Get("X-Pipeline-Event") != event.Event {
+			t.Errorf("X-Pipeline-Event ヘッダーが一致しません: %q", r.Header.Get("X-Pipeline-Event"))
 		}
+		received = append(received, event)
+	}))
+	defer ts.Close()
 
-		time.Sleep(10 * time.Millisecond)
+	eng := engine.NewBasicEngineWithConfig(store.NewMemoryStore(), &engine.EngineConfig{WebhookAllowedHosts: []string{"127.0.0.1"}})
+	defer eng.Close()
+	req := sampleJobRequest()
+	req.Webhook = &engine.Webhook{URL: ts.URL, Events: []string{"step_completed", "job_completed"}}
+	if _, err := eng.RunJob(context.Background(), req); err != nil {
+		t.Fatalf("ジョブの起動に失敗しました: %v", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("job_completed が webhook に届きませんでした")
 	}
 
-	t.Fatalf("ジョブ %s が制限時間内に %s になりませんでした", jobID, expected)
-	return nil
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) < 2 || received[0].Event != "step_completed" || received[len(received)-1].Event != "job_completed" {
+		t.Fatalf("購読したイベントだけが順に届くべきです: %+v", received)
+	}
+	for i := 1; i < len(received); i++ {
+		if received[i].Seq <= received[i-1].Seq {
+			t.Fatalf("Seq が単調増加していません: %+v", received)
+		}
+	}
+	if attempts != 2 {
+		t.Fatalf("失敗した配信は再試行されるべきです: attempts=%d", attempts)
+	}
+
+	req.Webhook = &engine.Webhook{URL: ts.URL, Events: []string{"unknown"}}
+	if _, err := eng.RunJob(context.Background(), req); err == nil {
+		t.Fatal("未対応のイベント名はエラーになるべきです")
+	}
+	req.Webhook = &engine.Webhook{URL: "ftp://example.com"}
+	if _, err := eng.RunJob(context.Background(), req); err == nil {
+		t.Fatal("http(s) 以外の URL はエラーになるべきです")
+	}
 }
 
-func sampleJobRequest() engine.JobRequest {
-	return engine.JobRequest{
-		PipelineType: engine.PipelineType("sample_pipeline"),
-		Input: engine.JobInput{
-			Sources: []engine.Source{
-				{
-					Kind:    engine.SourceKindNote,
-					Label:   "仕様メモ",
-					Content: "このサンプルはパイプラインの疎通確認用です。",
-				},
-			},
-			Options: &engine.JobOptions{
-				Language: "ja",
-			},
-		},
+func TestBasicEngine_WebhookRejectsInternalTargets(t *testing.T) {
+	t.Parallel()
+
+	eng := engine.NewBasicEngineWithConfig(store.NewMemoryStore(), &engine.EngineConfig{WebhookAllowedHosts: []string{"hooks.internal"}})
+	defer eng.Close()
+	for _, target := range []string{
+		"http://127.0.0.1:8080/hook",
+		"http://[::1]/hook",
+		"http://localhost/hook",
+		"http://10.0.0.5/hook",
+		"http://192.168.1.10/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://[::ffff:127.0.0.1]/hook",
+		"http://0.0.0.0/hook",
+	} {
+		req := sampleJobRequest()
+		req.Webhook = &engine.Webhook{URL: target}
+		if _, err := eng.RunJob(context.Background(), req); err == nil || !strings.Contains(err.Error(), "private") {
+			t.Fatalf("%s: 内部アドレスへの webhook は拒否されるべきです: %v", target, err)
+		}
+	}
+	req := sampleJobRequest()
+	req.Webhook = &engine.Webhook{URL: "http://hooks.internal/hook"}
+	if _, err := eng.RunJob(context.Background(), req); err != nil {
+		t.Fatalf("許可リストのホストは受け付けるべきです: %v", err)
+	}
+}
+
+func TestBasicEngine_CloseStopsWebhookRetries(t *testing.T) {
+	t.Parallel()
+
+	var attempts atomic.Int32
+	first := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			close(first)
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	eng := engine.NewBasicEngineWithConfig(store.NewMemoryStore(), &engine.EngineConfig{WebhookAllowedHosts: []string{"127.0.0.1"}})
+	req := sampleJobRequest()
+	req.Webhook = &engine.Webhook{URL: ts.URL}
+	if _, err := eng.RunJob(context.Background(), req); err != nil {
+		t.Fatalf("ジョブの起動に失敗しました: %v", err)
+	}
+	select {
+	case <-first:
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook が送信されませんでした")
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		eng.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close が再試行の待機を打ち切っていません")
+	}
+	time.Sleep(500 * time.Millisecond)
+	if got := attempts.Load(); got != 1 {
+		t.Fatalf("Close 後に webhook が再送されています: attempts=%d", got)
+	}
+}
+
+type failingExportSink struct{}
+
+func (failingExportSink) Export(context.Context, string, engine.ResultItem) (string, error) {
+	return "", errors.New("bucket unavailable")
+}
+
+func TestBasicEngine_ExportSink(t *testing.T) {
+	t.Parallel()
+
+	memoryStore := store.NewMemoryStore()
+	eng := engine.NewBasicEngineWithConfig(memoryStore, &engine.EngineConfig{
+		ExportSinks: map[string]engine.ExportSink{
+			"file":   engine.NewFileExportSink(t.TempDir()),
+			"broken": failingExportSink{},
+		},
+	})
+	eng.RegisterPipeline(engine.PipelineDef{
+		Type:       "sink_pipeline",
+		Version:    "v1",
+		ExportSink: "file",
+		Steps: []engine.StepDef{
+			{ID: "draft", Kind: engine.StepKindLLM, Mode: engine.StepModeSingle, OutputType: engine.ContentText, Export: true},
+		},
+	})
+
+	req := sampleJobRequest()
+	req.PipelineType = "sink_pipeline"
+	job, err := eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ジョブの起動に失敗しました: %v", err)
+	}
+	finalJob := waitForJobStatus(t, memoryStore, job.ID, engine.JobStatusSucceeded, 3*time.Second)
+	if finalJob.ExportSink != "file" || finalJob.Result == nil || len(finalJob.Result.Items) != 1 {
+		t.Fatalf("エクスポート結果が想定外です: %+v", finalJob)
+	}
+	item := finalJob.Result.Items[0]
+	if item.Data != nil || !strings.HasPrefix(item.URI, "file://") {
+		t.Fatalf("結果には URI だけが残るべきです: %+v", item)
+	}
+	written, err := os.ReadFile(strings.TrimPrefix(item.URI, "file://"))
+	if err != nil || len(written) == 0 {
+		t.Fatalf("シンクへ書き込まれていません: %v", err)
+	}
+
+	req.ExportSink = "broken"
+	job, err = eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ジョブの起動に失敗しました: %v", err)
+	}
+	failed := waitForJobStatus(t, memoryStore, job.ID, engine.JobStatusFailed, 3*time.Second)
+	if failed.Error == nil || failed.Error.Code != "export_failed" {
+		t.Fatalf("書き込み失敗は export_failed でジョブを失敗させるべきです: %+v", failed.Error)
+	}
+
+	req.ExportSink = "missing"
+	if _, err := eng.RunJob(context.Background(), req); err == nil {
+		t.Fatal("未登録のシンクはエラーになるべきです")
+	}
+}
+
+// recordingExportSink keeps the items it is asked to export.
+type recordingExportSink struct {
+	mu    sync.Mutex
+	items []engine.ResultItem
+}
+
+func (s *recordingExportSink) Export(_ context.Context, jobID string, item engine.ResultItem) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items = append(s.items, item)
+	return "memory://" + jobID + "/" + item.ID, nil
+}
+
+func TestBasicEngine_ExportSinkScrubsSensitiveSources(t *testing.T) {
+	t.Parallel()
+
+	const secret = "token-5678"
+	recorder := &recordingExportSink{}
+	dir := t.TempDir()
+	eng := engine.NewBasicEngineWithConfig(store.NewMemoryStore(), &engine.EngineConfig{
+		ExportSinks: map[string]engine.ExportSink{
+			"file":   engine.NewFileExportSink(dir),
+			"record": recorder,
+		},
+	})
+	eng.RegisterPipeline(engine.PipelineDef{
+		Type:    "secret_sink_pipeline",
+		Version: "v1",
+		Steps: []engine.StepDef{{
+			ID:           "read",
+			Kind:         engine.StepKindLLM,
+			Mode:         engine.StepModeFanOut,
+			OutputType:   engine.ContentJSON,
+			Prompt:       &engine.PromptTemplate{User: "{{range .Sources}}{{.Content}}{{end}}"},
+			ExportPrompt: true,
+			Export:       true,
+		}},
+	})
+
+	for _, sink := range []string{"record", "file"} {
+		req := sampleJobRequest()
+		req.PipelineType = "secret_sink_pipeline"
+		req.Mode = "sync"
+		req.ExportSink = sink
+		req.Input.Sources = []engine.Source{{Kind: engine.SourceKindNote, Label: "secret", Content: secret, Sensitive: true}}
+		job, err := eng.RunJob(context.Background(), req)
+		if err != nil || job.Status != engine.JobStatusSucceeded {
+			t.Fatalf("%s: ジョブが成功していません: %v %+v", sink, err, job)
+		}
+	}
+
+	recorder.mu.Lock()
+	exported, _ := json.Marshal(recorder.items)
+	recorder.mu.Unlock()
+	if len(recorder.items) == 0 || strings.Contains(string(exported), secret) {
+		t.Fatalf("シンクへ渡す結果に機密ソースの内容が含まれています: %s", exported)
+	}
+	if !strings.Contains(string(exported), logging.RedactedValue) {
+		t.Fatalf("機密ソースの内容は伏せ字に置き換えるべきです: %s", exported)
+	}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		written, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if strings.Contains(string(written), secret) {
+			t.Fatalf("ファイルへ機密ソースの内容が書き込まれています: %s", written)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("エクスポート先の走査に失敗しました: %v", err)
+	}
+}
+
+func TestBasicEngine_ProfileStoreReload(t *testing.T) {
+	t.Parallel()
+
+	var gotAuth atomic.Value
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth.Store(r.Header.Get("Authorization"))
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"stored"}}]}`))
+	}))
+	defer ts.Close()
+
+	profiles, err := store.NewFileProfileStore(t.TempDir()+"/profiles.json", "test-secret")
+	if err != nil {
+		t.Fatalf("プロファイルストアの作成に失敗しました: %v", err)
+	}
+	first := engine.NewBasicEngineWithConfig(store.NewMemoryStore(), &engine.EngineConfig{ProfileStore: profiles})
+	if err := first.UpsertProviderProfile(engine.ProviderProfile{ID: "runtime-openai", Kind: engine.ProviderOpenAI, BaseURI: ts.URL, APIKey: "sk-runtime", DefaultModel: "gpt"}); err != nil {
+		t.Fatalf("プロファイルの upsert に失敗しました: %v", err)
+	}
+
+	// 再起動を模して、同じストアから新しいエンジンを組み立てる。
+	memoryStore := store.NewMemoryStore()
+	eng := engine.NewBasicEngineWithConfig(memoryStore, &engine.EngineConfig{ProfileStore: profiles})
+	eng.RegisterPipeline(engine.PipelineDef{
+		Type:    "stored_profile",
+		Version: "v1",
+		Steps: []engine.StepDef{
+			{ID: "summarize", Kind: engine.StepKindLLM, Mode: engine.StepModeSingle, OutputType: engine.ContentText, Export: true, ProviderProfileID: "runtime-openai"},
+		},
+	})
+	req := sampleJobRequest()
+	req.PipelineType = "stored_profile"
+	job, err := eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ジョブの起動に失敗しました: %v", err)
+	}
+	waitForJobStatus(t, memoryStore, job.ID, engine.JobStatusSucceeded, 3*time.Second)
+	if got, _ := gotAuth.Load().(string); got != "Bearer sk-runtime" {
+		t.Fatalf("保存したプロファイルの API キーが復元されていません: %q", got)
+	}
+}
+
+func TestBasicEngine_ModelTiers(t *testing.T) {
+	t.Parallel()
+
+	var requested sync.Map
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Model string `json:"model"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		requested.Store(payload.Model, true)
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"tiered"}}]}`))
+	}))
+	defer ts.Close()
+
+	memoryStore := store.NewMemoryStore()
+	eng := engine.NewBasicEngineWithConfig(memoryStore, &engine.EngineConfig{
+		Providers: []engine.ProviderProfile{{ID: "tiered", Kind: engine.ProviderOpenAI, BaseURI: ts.URL, APIKey: "k", DefaultModel: "gpt-default"}},
+	})
+	eng.RegisterPipeline(engine.PipelineDef{
+		Type:    "tiered_pipeline",
+		Version: "v1",
+		Steps: []engine.StepDef{
+			{
+				ID: "answer", Kind: engine.StepKindLLM, Mode: engine.StepModeSingle, OutputType: engine.ContentText, Export: true,
+				ProviderProfileID: "tiered",
+				ModelTiers:        map[string]string{"low": "gpt-mini", "high": "gpt-large"},
+			},
+		},
+	})
+
+	cases := []struct {
+		level     string
+		wantModel string
+		wantTier  any
+	}{
+		{level: "High", wantModel: "gpt-large", wantTier: "high"},
+		{level: "medium", wantModel: "gpt-default", wantTier: nil},
+	}
+	for _, tc := range cases {
+		req := sampleJobRequest()
+		req.PipelineType = "tiered_pipeline"
+		req.Input.Options = &engine.JobOptions{DetailLevel: tc.level}
+		job, err := eng.RunJob(context.Background(), req)
+		if err != nil {
+			t.Fatalf("ジョブの起動に失敗しました: %v", err)
+		}
+		finalJob := waitForJobStatus(t, memoryStore, job.ID, engine.JobStatusSucceeded, 3*time.Second)
+		data, _ := finalJob.Result.Items[0].Data.(map[string]any)
+		if data["model"] != tc.wantModel || data["model_tier"] != tc.wantTier {
+			t.Fatalf("detail_level=%s で選ばれたモデルが想定外です: model=%v tier=%v", tc.level, data["model"], data["model_tier"])
+		}
+		if _, ok := requested.Load(tc.wantModel); !ok {
+			t.Fatalf("Provider に %s が送られていません", tc.wantModel)
+		}
+	}
+}
+
+func TestBasicEngine_ProviderOverrideApplied(t *testing.T) {
+	t.Parallel()
+
+	memoryStore := store.NewMemoryStore()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"dummy"}}]}`))
+	}))
+	defer ts.Close()
+	cfg := &engine.EngineConfig{
+		Providers: []engine.ProviderProfile{
+			{
+				ID:           engine.ProviderProfileID("custom-openai"),
+				Kind:         engine.ProviderOpenAI,
+				DefaultModel: "gpt-dummy",
+				BaseURI:      ts.URL,
+				APIKey:       "test",
+			},
+		},
+	}
+	eng := engine.NewBasicEngineWithConfig(memoryStore, cfg)
+
+	pipeline := engine.PipelineDef{
+		Type:    "provider_pipeline",
+		Version: "v1",
+		Steps: []engine.StepDef{
+			{
+				ID:                engine.StepID("summarize"),
+				Name:              "Summarize",
+				Kind:              engine.StepKindLLM,
+				Mode:              engine.StepModeSingle,
+				OutputType:        engine.ContentText,
+				Export:            true,
+				ProviderProfileID: engine.ProviderProfileID("custom-openai"),
+				ProviderOverride: map[string]any{
+					"default_model": "gpt-override",
+				},
+			},
+		},
+	}
+	eng.RegisterPipeline(pipeline)
+
+	req := sampleJobRequest()
+	req.PipelineType = pipeline.Type
+
+	job, err := eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("provider pipeline ジョブの起動に失敗しました: %v", err)
+	}
+
+	finalJob := waitForJobStatus(t, memoryStore, job.ID, engine.JobStatusSucceeded, 3*time.Second)
+	if finalJob.Result == nil || len(finalJob.Result.Items) == 0 {
+		t.Fatalf("provider pipeline の結果が空です: %+v", finalJob.Result)
+	}
+
+	data, ok := finalJob.Result.Items[0].Data.(map[string]any)
+	if !ok {
+		t.Fatalf("結果データを map へ変換できません: %+v", finalJob.Result.Items[0].Data)
+	}
+	if got := data["model"]; got != "gpt-override" {
+		t.Fatalf("プロバイダ override が反映されていません: %v", got)
+	}
+	if got := data["provider"]; got != "openai" {
+		t.Fatalf("プロバイダ種別が想定外です: %v", got)
+	}
+	meta := finalJob.Result.Items[0].ProviderMeta
+	if meta == nil || meta.Provider != engine.ProviderOpenAI || meta.Model != "gpt-override" {
+		t.Fatalf("provider_meta が想定外です: %+v", meta)
+	}
+}
+func TestBasicEngine_ReduceStepRecordsChunks(t *testing.T) {
+	t.Parallel()
+
+	memoryStore := store.NewMemoryStore()
+	longText := strings.Repeat("reduce ", 100)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"choices": []map[string]any{{"message": map[string]any{"content": longText}}},
+		})
+	}))
+	defer ts.Close()
+	cfg := &engine.EngineConfig{
+		Providers: []engine.ProviderProfile{
+			{ID: engine.ProviderProfileID("reduce-openai"), Kind: engine.ProviderOpenAI, BaseURI: ts.URL, APIKey: "test"},
+		},
+	}
+	eng := engine.NewBasicEngineWithConfig(memoryStore, cfg)
+	eng.RegisterPipeline(engine.PipelineDef{
+		Type:    "reduce_pipeline",
+		Version: "v1",
+		Steps: []engine.StepDef{
+			{ID: engine.StepID("shards"), Kind: engine.StepKindMap, Mode: engine.StepModeFanOut},
+			{
+				ID:                engine.StepID("reduce"),
+				Kind:              engine.StepKindReduce,
+				DependsOn:         []engine.StepID{engine.StepID("shards")},
+				ProviderProfileID: engine.ProviderProfileID("reduce-openai"),
+				Export:            true,
+			},
+		},
+	})
+
+	req := sampleJobRequest()
+	req.PipelineType = "reduce_pipeline"
+	req.Mode = "sync"
+	req.Input.Sources = append(req.Input.Sources, engine.Source{Kind: engine.SourceKindNote, Label: "追加", Content: "second"})
+
+	job, err := eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("reduce ジョブの起動に失敗しました: %v", err)
+	}
+	if job.Status != engine.JobStatusSucceeded {
+		t.Fatalf("reduce ジョブが success ではありません: %s %+v", job.Status, job.Error)
+	}
+	chunks := job.StepExecutions[1].Chunks
+	if len(chunks) < 2 {
+		t.Fatalf("reduce ステップに chunk が記録されていません: %+v", job.StepExecutions[1])
+	}
+	for i, chunk := range chunks {
+		if chunk.StepID != engine.StepID("reduce") || chunk.Index != i {
+			t.Fatalf("reduce ステップの chunk が不正です: %+v", chunks)
+		}
+	}
+	if job.Result == nil || len(job.Result.Items) != 1 {
+		t.Fatalf("reduce ステップの結果は 1 件のはずです: %+v", job.Result)
+	}
+	data, _ := job.Result.Items[0].Data.(map[string]any)
+	if data["reduced_count"] != 2 {
+		t.Fatalf("reduced_count が想定外です: %+v", data)
+	}
+}
+
+func TestBasicEngine_ToolCallingLoop(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var requests []map[string]any
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		requests = append(requests, body)
+		round := len(requests)
+		mu.Unlock()
+		message := map[string]any{"content": "晴れです"}
+		if round == 1 {
+			message = map[string]any{
+				"content": "",
+				"tool_calls": []map[string]any{{
+					"id":       "call_1",
+					"type":     "function",
+					"function": map[string]any{"name": "get_weather", "arguments": `{"city":"Tokyo"}`},
+				}},
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"choices": []map[string]any{{"message": message}}})
+	}))
+	defer ts.Close()
+
+	cfg := &engine.EngineConfig{
+		Providers: []engine.ProviderProfile{
+			{ID: engine.ProviderProfileID("tools-openai"), Kind: engine.ProviderOpenAI, BaseURI: ts.URL, APIKey: "test"},
+		},
+	}
+	eng := engine.NewBasicEngineWithConfig(store.NewMemoryStore(), cfg)
+	var gotArgs string
+	eng.RegisterStepHandler("get_weather", func(ctx context.Context, call engine.ToolCall) (string, error) {
+		gotArgs = call.Arguments
+		return "sunny", nil
+	})
+	tool := engine.ToolDef{Name: "get_weather", Parameters: map[string]any{"type": "object"}}
+	eng.RegisterPipeline(engine.PipelineDef{
+		Type:    "tools_pipeline",
+		Version: "v1",
+		Steps: []engine.StepDef{{
+			ID:                engine.StepID("ask"),
+			Kind:              engine.StepKindLLM,
+			ProviderProfileID: engine.ProviderProfileID("tools-openai"),
+			Tools:             []engine.ToolDef{tool},
+			Export:            true,
+		}},
+	})
+	eng.RegisterPipeline(engine.PipelineDef{
+		Type:    "tools_missing_pipeline",
+		Version: "v1",
+		Steps: []engine.StepDef{{
+			ID:                engine.StepID("ask"),
+			Kind:              engine.StepKindLLM,
+			ProviderProfileID: engine.ProviderProfileID("tools-openai"),
+			Tools:             []engine.ToolDef{{Name: "other"}},
+			Export:            true,
+		}},
+	})
+
+	req := sampleJobRequest()
+	req.PipelineType = "tools_pipeline"
+	req.Mode = "sync"
+	job, err := eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("tools ジョブの起動に失敗しました: %v", err)
+	}
+	if job.Status != engine.JobStatusSucceeded {
+		t.Fatalf("tools ジョブが success ではありません: %s %+v", job.Status, job.Error)
+	}
+	if gotArgs != `{"city":"Tokyo"}` {
+		t.Fatalf("ツールの引数が渡されていません: %q", gotArgs)
+	}
+	data, _ := job.Result.Items[0].Data.(map[string]any)
+	if data["text"] != "晴れです" || data["tool_calls"] != 1 {
+		t.Fatalf("ツール呼び出し後の出力が想定外です: %+v", data)
+	}
+	mu.Lock()
+	if len(requests) != 2 {
+		mu.Unlock()
+		t.Fatalf("プロバイダ呼び出しは 2 回のはずです: %d", len(requests))
+	}
+	if tools, _ := requests[0]["tools"].([]any); len(tools) != 1 {
+		t.Fatalf("tools がリクエストに含まれていません: %+v", requests[0])
+	}
+	messages, _ := requests[1]["messages"].([]any)
+	last, _ := messages[len(messages)-1].(map[string]any)
+	mu.Unlock()
+	if last["role"] != "tool" || last["tool_call_id"] != "call_1" || last["content"] != "sunny" {
+		t.Fatalf("ツール結果がモデルに返されていません: %+v", messages)
+	}
+
+	mu.Lock()
+	requests = nil
+	mu.Unlock()
+	req.PipelineType = "tools_missing_pipeline"
+	job, err = eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("tools ジョブの起動に失敗しました: %v", err)
+	}
+	if job.Status != engine.JobStatusFailed || job.Error == nil || job.Error.Code != "tool_unavailable" {
+		t.Fatalf("未宣言ツールの呼び出しは tool_unavailable で失敗するはずです: %s %+v", job.Status, job.Error)
+	}
+}
+
+func TestBasicEngine_ToolCallsOnStepWithoutTools(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		message := map[string]any{
+			"content": r.URL.Query().Get("content"),
+			"tool_calls": []map[string]any{{
+				"id":       "call_1",
+				"type":     "function",
+				"function": map[string]any{"name": "lookup", "arguments": `{"q":"memo"}`},
+			}},
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"choices": []map[string]any{{"message": message, "finish_reason": "tool_calls"}}})
+	}))
+	defer ts.Close()
+
+	cfg := &engine.EngineConfig{
+		Providers: []engine.ProviderProfile{
+			{ID: "calls-only", Kind: engine.ProviderOpenAI, BaseURI: ts.URL, APIKey: "test"},
+			{ID: "with-text", Kind: engine.ProviderOpenAI, BaseURI: ts.URL, APIKey: "test", Extra: map[string]any{"path_template": "/chat/completions?content=answer"}},
+		},
+	}
+	eng := engine.NewBasicEngineWithConfig(store.NewMemoryStore(), cfg)
+	for _, profile := range []engine.ProviderProfileID{"calls-only", "with-text"} {
+		eng.RegisterPipeline(engine.PipelineDef{
+			Type:    engine.PipelineType("no_tools_" + profile),
+			Version: "v1",
+			Steps:   []engine.StepDef{{ID: "ask", Kind: engine.StepKindLLM, ProviderProfileID: profile, Export: true}},
+		})
+	}
+
+	req := sampleJobRequest()
+	req.Mode = "sync"
+	req.PipelineType = "no_tools_calls-only"
+	job, err := eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ジョブの起動に失敗しました: %v", err)
+	}
+	if job.Status != engine.JobStatusFailed || job.Error == nil || job.Error.Code != "tool_unavailable" {
+		t.Fatalf("本文のないツール呼び出しは tool_unavailable で失敗するはずです: %s %+v", job.Status, job.Error)
+	}
+	if details, _ := json.Marshal(job.Error.Details); !strings.Contains(string(details), `"name":"lookup"`) {
+		t.Fatalf("エラー詳細にツール呼び出しが含まれていません: %s", details)
+	}
+
+	req.PipelineType = "no_tools_with-text"
+	job, err = eng.RunJob(context.Background(), req)
+	if err != nil || job.Status != engine.JobStatusSucceeded {
+		t.Fatalf("本文のある応答は成功するはずです: %v %+v", err, job)
+	}
+	data, _ := job.Result.Items[0].Data.(map[string]any)
+	if encoded, _ := json.Marshal(data["requested_tool_calls"]); data["text"] != "answer" || !strings.Contains(string(encoded), `"arguments":"{\"q\":\"memo\"}"`) {
+		t.Fatalf("要求されたツール呼び出しが結果に残っていません: %+v", data)
+	}
+}
+
+func TestBasicEngine_PostProcessTransformsOutput(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"choices": []map[string]any{{"message": map[string]any{"content": "結果です:\n```json\n{\"title\": \"要約\"}\n```"}}},
+		})
+	}))
+	defer ts.Close()
+	cfg := &engine.EngineConfig{
+		Providers: []engine.ProviderProfile{
+			{ID: engine.ProviderProfileID("post-openai"), Kind: engine.ProviderOpenAI, BaseURI: ts.URL, APIKey: "test"},
+		},
+	}
+	eng := engine.NewBasicEngineWithConfig(store.NewMemoryStore(), cfg)
+	for pipeline, post := range map[engine.PipelineType][]string{
+		"post_pipeline":      {"strip_code_fence", "extract_json"},
+		"post_fail_pipeline": {"regex:score=(\\d+)"},
+	} {
+		eng.RegisterPipeline(engine.PipelineDef{
+			Type:    pipeline,
+			Version: "v1",
+			Steps: []engine.StepDef{{
+				ID:                engine.StepID("extract"),
+				Kind:              engine.StepKindLLM,
+				ProviderProfileID: engine.ProviderProfileID("post-openai"),
+				PostProcess:       post,
+				Export:            true,
+			}},
+		})
+	}
+
+	req := sampleJobRequest()
+	req.PipelineType = "post_pipeline"
+	req.Mode = "sync"
+	job, err := eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ジョブ実行に失敗しました: %v", err)
+	}
+	if job.Status != engine.JobStatusSucceeded {
+		t.Fatalf("ジョブが成功していません: %s %+v", job.Status, job.Error)
+	}
+	data, _ := job.Result.Items[0].Data.(map[string]any)
+	if data["text"] != `{"title": "要約"}` {
+		t.Fatalf("post_process が出力に適用されていません: %+v", data)
+	}
+
+	req.PipelineType = "post_fail_pipeline"
+	job, err = eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ジョブ実行に失敗しました: %v", err)
+	}
+	if job.Status != engine.JobStatusFailed || job.Error == nil || job.Error.Code != "post_process_failed" {
+		t.Fatalf("変換に失敗したステップは post_process_failed になるはずです: %s %+v", job.Status, job.Error)
+	}
+}
+
+func TestBasicEngine_PipelineVersionPinning(t *testing.T) {
+	t.Parallel()
+
+	memoryStore := store.NewMemoryStore()
+	eng := engine.NewBasicEngine(memoryStore)
+	eng.RegisterPipeline(engine.PipelineDef{
+		Type:    "versioned_pipeline",
+		Version: "v1",
+		Steps:   []engine.StepDef{{ID: engine.StepID("old"), Kind: engine.StepKindLLM, Export: true}},
+	})
+	eng.RegisterPipeline(engine.PipelineDef{
+		Type:    "versioned_pipeline",
+		Version: "v2",
+		Steps:   []engine.StepDef{{ID: engine.StepID("new"), Kind: engine.StepKindLLM, Export: true}},
+	})
+
+	req := sampleJobRequest()
+	req.PipelineType = "versioned_pipeline"
+	req.Mode = "sync"
+
+	latest, err := eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("最新バージョンのジョブ起動に失敗しました: %v", err)
+	}
+	if latest.PipelineVersion != "v2" || latest.StepExecutions[0].StepID != engine.StepID("new") {
+		t.Fatalf("最新バージョンが使われていません: %s %+v", latest.PipelineVersion, latest.StepExecutions)
+	}
+
+	req.PipelineVersion = "v1"
+	pinned, err := eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("固定バージョンのジョブ起動に失敗しました: %v", err)
+	}
+	if pinned.PipelineVersion != "v1" || pinned.StepExecutions[0].StepID != engine.StepID("old") {
+		t.Fatalf("固定したバージョンが使われていません: %s %+v", pinned.PipelineVersion, pinned.StepExecutions)
+	}
+
+	req.PipelineVersion = "v9"
+	if _, err := eng.RunJob(context.Background(), req); !errors.Is(err, engine.ErrPipelineVersionNotFound) {
+		t.Fatalf("未知のバージョンで ErrPipelineVersionNotFound が返りません: %v", err)
+	}
+}
+
+func TestBasicEngine_RerunOfUnregisteredVersionFallsBack(t *testing.T) {
+	t.Parallel()
+
+	memoryStore := store.NewMemoryStore()
+	eng := engine.NewBasicEngine(memoryStore)
+	run := func(req engine.JobRequest) *engine.Job {
+		t.Helper()
+		req.Mode = "sync"
+		job, err := eng.RunJob(context.Background(), req)
+		if err != nil {
+			t.Fatalf("ジョブ実行に失敗しました: %v", err)
+		}
+		if job.Status != engine.JobStatusSucceeded {
+			t.Fatalf("ジョブが成功していません: %s %+v", job.Status, job.Error)
+		}
+		return job
+	}
+
+	// 種別の登録前に暗黙の既定 (v0) で実行された親。
+	req := sampleJobRequest()
+	req.PipelineType = "late"
+	parent := run(req)
+	if parent.PipelineVersion != "v0" {
+		t.Fatalf("既定パイプラインのバージョンが想定外です: %s", parent.PipelineVersion)
+	}
+	eng.RegisterPipeline(engine.PipelineDef{
+		Type:    "late",
+		Version: "v1",
+		Steps:   []engine.StepDef{{ID: "late-step", Kind: engine.StepKindLLM, Export: true}},
+	})
+	rerun := run(engine.NewRerunRequest(parent, engine.RerunOptions{}))
+	if rerun.PipelineVersion != "v0" || rerun.StepExecutions[0].StepID != "step-1" {
+		t.Fatalf("親ジョブのスナップショットで再実行されていません: %s %+v", rerun.PipelineVersion, rerun.StepExecutions)
+	}
+
+	// 履歴の上限を超えて押し出されたバージョンの親。
+	register := func(version string) {
+		eng.RegisterPipeline(engine.PipelineDef{
+			Type:    "churn",
+			Version: version,
+			Steps:   []engine.StepDef{{ID: engine.StepID("step-" + version), Kind: engine.StepKindLLM, Export: true}},
+		})
+	}
+	register("v1")
+	req.PipelineType = "churn"
+	parent = run(req)
+	for i := 2; i <= 10; i++ {
+		register(fmt.Sprintf("v%d", i))
+	}
+	if _, err := eng.RunJob(context.Background(), engine.JobRequest{PipelineType: "churn", PipelineVersion: "v1"}); !errors.Is(err, engine.ErrPipelineVersionNotFound) {
+		t.Fatalf("v1 が履歴から押し出されていません: %v", err)
+	}
+	rerun = run(engine.NewRerunRequest(parent, engine.RerunOptions{}))
+	if rerun.PipelineVersion != "v1" || rerun.StepExecutions[0].StepID != "step-v1" {
+		t.Fatalf("押し出されたバージョンの親がスナップショットで再実行されていません: %s %+v", rerun.PipelineVersion, rerun.StepExecutions)
+	}
+
+}
+
+func TestBasicEngine_ExportedResultsOmitPromptByDefault(t *testing.T) {
+	t.Parallel()
+
+	req := sampleJobRequest()
+	req.Mode = "sync"
+	promptOf := func(job *engine.Job) (any, bool) {
+		if job.Result == nil || len(job.Result.Items) == 0 {
+			t.Fatalf("エクスポート結果がありません: %+v", job.Result)
+		}
+		data, _ := job.Result.Items[0].Data.(map[string]any)
+		prompt, ok := data["prompt"]
+		return prompt, ok
+	}
+
+	eng := engine.NewBasicEngine(store.NewMemoryStore())
+	job, err := eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ジョブの実行に失敗しました: %v", err)
+	}
+	if prompt, ok := promptOf(job); ok {
+		t.Fatalf("既定ではエクスポート結果に prompt が含まれないはずです: %v", prompt)
+	}
+
+	withPrompts := engine.NewBasicEngineWithConfig(store.NewMemoryStore(), &engine.EngineConfig{ExportPrompts: true})
+	job, err = withPrompts.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ジョブの実行に失敗しました: %v", err)
+	}
+	if _, ok := promptOf(job); !ok {
+		t.Fatal("ExportPrompts 有効時に prompt が含まれていません")
+	}
+
+	eng.RegisterPipeline(engine.PipelineDef{
+		Type:  "prompt_export",
+		Steps: []engine.StepDef{{ID: engine.StepID("debug"), Kind: engine.StepKindLLM, Export: true, ExportPrompt: true}},
+	})
+	req.PipelineType = "prompt_export"
+	job, err = eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ジョブの実行に失敗しました: %v", err)
+	}
+	if _, ok := promptOf(job); !ok {
+		t.Fatal("StepDef.ExportPrompt 有効時に prompt が含まれていません")
+	}
+}
+
+func TestBasicEngine_ReduceTreeBatchesLargeInputs(t *testing.T) {
+	t.Parallel()
+
+	eng := engine.NewBasicEngineWithConfig(store.NewMemoryStore(), &engine.EngineConfig{ExportPrompts: true})
+	eng.RegisterPipeline(engine.PipelineDef{
+		Type: "reduce_tree_pipeline",
+		Steps: []engine.StepDef{
+			{ID: engine.StepID("split"), Kind: engine.StepKindMap, Mode: engine.StepModeFanOut},
+			{
+				ID:        engine.StepID("merge"),
+				Kind:      engine.StepKindReduce,
+				DependsOn: []engine.StepID{"split"},
+				Prompt:    &engine.PromptTemplate{User: `{{range .Previous.split}}[{{index .Data "text"}}]{{end}}`},
+				Config:    map[string]any{"reduce_token_threshold": 1, "reduce_batch_size": 3},
+				Export:    true,
+			},
+		},
+	})
+
+	req := sampleJobRequest()
+	req.PipelineType = "reduce_tree_pipeline"
+	req.Mode = "sync"
+	req.Input.Sources = nil
+	for i := 0; i < 10; i++ {
+		req.Input.Sources = append(req.Input.Sources, engine.Source{Kind: engine.SourceKindNote, Label: fmt.Sprintf("s%d", i), Content: "x"})
+	}
+	job, err := eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ジョブ実行に失敗しました: %v", err)
+	}
+	if job.Status != engine.JobStatusSucceeded || job.Result == nil || len(job.Result.Items) != 1 {
+		t.Fatalf("reduce ジョブの結果が不正です: %s %+v", job.Status, job.Result)
+	}
+
+	data, _ := job.Result.Items[0].Data.(map[string]any)
+	if data["reduced_count"] != 10 {
+		t.Fatalf("reduced_count は元の件数のはずです: %v", data["reduced_count"])
+	}
+	// 10 件 → level 1 で 4 件 → level 2 で 2 件に集約され、最後に 1 回 reduce する。
+	partials, _ := data["partials"].([]map[string]any)
+	if len(partials) != 6 {
+		t.Fatalf("中間結果の件数が想定外です: %+v", partials)
+	}
+	if partials[0]["level"] != 1 || partials[0]["reduced_count"] != 3 || partials[3]["reduced_count"] != 1 {
+		t.Fatalf("level 1 の中間結果が不正です: %+v", partials[:4])
+	}
+	if partials[4]["level"] != 2 || partials[4]["reduced_count"] != 9 || partials[5]["reduced_count"] != 1 {
+		t.Fatalf("level 2 の中間結果が不正です: %+v", partials[4:])
+	}
+	prompt, _ := data["prompt"].(string)
+	if strings.Count(prompt, "[") != 2 || !strings.Contains(prompt, "at level 2") {
+		t.Fatalf("最終 reduce のプロンプトが中間結果から生成されていません: %q", prompt)
+	}
+}
+
+func TestBasicEngine_RetrieveStepSelectsTopK(t *testing.T) {
+	t.Parallel()
+
+	embed := func(vec ...float64) enginetest.StubResponse {
+		return enginetest.StubResponse{Output: "embedded", Metadata: map[string]any{"embedding": vec}}
+	}
+	stub := enginetest.NewStubProvider().
+		Respond("query", embed(1, 0)).
+		Respond("docs", embed(0, 1), embed(0.9, 0.1), embed(0.7, 0.7))
+	eng := enginetest.NewEngine(t, stub)
+	def := engine.PipelineDef{
+		Type: "rag_pipeline",
+		Steps: []engine.StepDef{
+			{ID: "query", Kind: engine.StepKindLLM, ProviderProfileID: enginetest.StubProfileID},
+			{ID: "docs", Kind: engine.StepKindMap, Mode: engine.StepModeFanOut, ProviderProfileID: enginetest.StubProfileID},
+			{
+				ID:        "retrieve",
+				Kind:      engine.StepKindRetrieve,
+				DependsOn: []engine.StepID{"query", "docs"},
+				Config:    map[string]any{"top_k": 2, "similarity": "cosine"},
+				Export:    true,
+			},
+			{ID: "answer", Kind: engine.StepKindLLM, DependsOn: []engine.StepID{"retrieve"}, ProviderProfileID: enginetest.StubProfileID},
+		},
+	}
+	if err := eng.ValidatePipeline(def); err != nil {
+		t.Fatalf("retrieve パイプラインが検証エラーになりました: %v", err)
+	}
+	eng.RegisterPipeline(def)
+
+	req := sampleJobRequest()
+	req.PipelineType = "rag_pipeline"
+	req.Mode = "sync"
+	req.Input.Sources = []engine.Source{
+		{Kind: engine.SourceKindNote, Label: "a", Content: "doc-a"},
+		{Kind: engine.SourceKindCode, Label: "b", Content: "doc-b"},
+		{Kind: engine.SourceKindNote, Label: "c", Content: "doc-c"},
+	}
+	job, err := eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ジョブ実行に失敗しました: %v", err)
+	}
+	if job.Status != engine.JobStatusSucceeded || job.Result == nil || len(job.Result.Items) != 2 {
+		t.Fatalf("retrieve の結果が不正です: %s %+v %+v", job.Status, job.Error, job.Result)
+	}
+	first, _ := job.Result.Items[0].Data.(map[string]any)
+	second, _ := job.Result.Items[1].Data.(map[string]any)
+	if first["source"] != "doc-b" || first["rank"] != 1 || second["source"] != "doc-c" || second["rank"] != 2 {
+		t.Fatalf("類似度順に選ばれていません: %+v / %+v", first, second)
+	}
+
+	calls := stub.CallsFor("answer")
+	if len(calls) != 1 {
+		t.Fatalf("answer ステップの呼び出しが想定外です: %+v", calls)
+	}
+	sources := calls[0].Input.Sources
+	if len(sources) != 2 || sources[0].Content != "doc-b" || sources[0].Kind != engine.SourceKindCode || sources[1].Content != "doc-c" {
+		t.Fatalf("retrieve の結果が後続ステップの sources になっていません: %+v", sources)
+	}
+	if sources[0].Metadata["retrieval_rank"] != 1 {
+		t.Fatalf("retrieval_rank が付与されていません: %+v", sources[0].Metadata)
+	}
+
+	invalid := def
+	invalid.Type = "rag_invalid"
+	invalid.Steps = append([]engine.StepDef(nil), def.Steps...)
+	invalid.Steps[2].Config = map[string]any{"query_step": "answer", "similarity": "jaccard"}
+	err = eng.ValidatePipeline(invalid)
+	if err == nil || !strings.Contains(err.Error(), "query_step") || !strings.Contains(err.Error(), "similarity") {
+		t.Fatalf("不正な retrieve 設定が検出されていません: %v", err)
+	}
+}
+
+func TestBasicEngine_FanOutAfterRetrieveShardsSelectedSources(t *testing.T) {
+	t.Parallel()
+
+	embed := func(vec ...float64) enginetest.StubResponse {
+		return enginetest.StubResponse{Output: "embedded", Metadata: map[string]any{"embedding": vec}}
+	}
+	stub := enginetest.NewStubProvider().
+		Respond("query", embed(1, 0)).
+		Respond("docs", embed(0, 1), embed(0.9, 0.1), embed(0.7, 0.7))
+	eng := enginetest.NewEngine(t, stub)
+	eng.RegisterPipeline(engine.PipelineDef{
+		Type: "rag_fan_out",
+		Steps: []engine.StepDef{
+			{ID: "query", Kind: engine.StepKindLLM, ProviderProfileID: enginetest.StubProfileID},
+			{ID: "docs", Kind: engine.StepKindMap, Mode: engine.StepModeFanOut, ProviderProfileID: enginetest.StubProfileID},
+			{ID: "retrieve", Kind: engine.StepKindRetrieve, DependsOn: []engine.StepID{"query", "docs"}, Config: map[string]any{"top_k": 2}},
+			{ID: "answer", Kind: engine.StepKindLLM, Mode: engine.StepModeFanOut, DependsOn: []engine.StepID{"retrieve"}, ProviderProfileID: enginetest.StubProfileID, Export: true},
+		},
+	})
+
+	req := sampleJobRequest()
+	req.PipelineType = "rag_fan_out"
+	req.Mode = "sync"
+	req.Input.Sources = []engine.Source{
+		{Kind: engine.SourceKindNote, Label: "a", Content: "doc-a"},
+		{Kind: engine.SourceKindNote, Label: "b", Content: "doc-b"},
+		{Kind: engine.SourceKindNote, Label: "c", Content: "doc-c"},
+	}
+	job, err := eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ジョブ実行に失敗しました: %v", err)
+	}
+	if job.Status != engine.JobStatusSucceeded || job.Result == nil || len(job.Result.Items) != 2 {
+		t.Fatalf("fanout の結果が retrieve の件数と一致しません: %s %+v %+v", job.Status, job.Error, job.Result)
+	}
+	calls := stub.CallsFor("answer")
+	if len(calls) != 2 {
+		t.Fatalf("fanout が retrieve の結果ではなく元のソースで分割されています: %d 回", len(calls))
+	}
+	var got []string
+	for _, call := range calls {
+		if len(call.Input.Sources) != 1 {
+			t.Fatalf("シャードごとのソースが 1 件ではありません: %+v", call.Input.Sources)
+		}
+		got = append(got, call.Input.Sources[0].Content)
+	}
+	slices.Sort(got)
+	if !slices.Equal(got, []string{"doc-b", "doc-c"}) {
+		t.Fatalf("fanout のシャードが retrieve の上位件と一致しません: %v", got)
+	}
+}
+
+func TestBasicEngine_RetrieveWithProviderEmbeddings(t *testing.T) {
+	t.Parallel()
+
+	// 単語の有無を次元とする決定的な埋め込みを返す。
+	embed := func(text string) []float64 {
+		vec := make([]float64, 3)
+		for i, word := range []string{"rust", "pasta", "borrow"} {
+			if strings.Contains(text, word) {
+				vec[i] = 1
+			}
+		}
+		return vec
+	}
+	var openAIModel, ollamaModel atomic.Value
+	openAI := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Model string `json:"model"`
+			Input string `json:"input"`
+		}
+		if r.URL.Path != "/embeddings" || json.NewDecoder(r.Body).Decode(&payload) != nil {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		openAIModel.Store(payload.Model)
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"data":  []map[string]any{{"embedding": embed(payload.Input)}},
+			"model": payload.Model,
+		})
+	}))
+	defer openAI.Close()
+	ollama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Model string `json:"model"`
+			Input string `json:"input"`
+		}
+		if r.URL.Path != "/api/embed" || json.NewDecoder(r.Body).Decode(&payload) != nil {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		ollamaModel.Store(payload.Model)
+		_ = json.NewEncoder(w).Encode(map[string]any{"embeddings": [][]float64{embed(payload.Input)}})
+	}))
+	defer ollama.Close()
+
+	memoryStore := store.NewMemoryStore()
+	eng := engine.NewBasicEngineWithConfig(memoryStore, &engine.EngineConfig{Providers: []engine.ProviderProfile{
+		{ID: "embed-openai", Kind: engine.ProviderOpenAI, BaseURI: openAI.URL, APIKey: "sk-test", DefaultModel: "gpt-4o-mini"},
+		{ID: "embed-ollama", Kind: engine.ProviderOllama, BaseURI: ollama.URL, Extra: map[string]any{engine.EmbeddingModelExtraKey: "mxbai-embed-large"}},
+	}})
+	eng.RegisterPipeline(engine.PipelineDef{
+		Type: "provider_rag",
+		Steps: []engine.StepDef{
+			{ID: "query", Kind: engine.StepKindLLM, OutputType: engine.ContentEmbedding, Prompt: &engine.PromptTemplate{User: "rust borrow"}, ProviderProfileID: "embed-openai"},
+			{ID: "docs", Kind: engine.StepKindMap, Mode: engine.StepModeFanOut, OutputType: engine.ContentEmbedding, ProviderProfileID: "embed-ollama"},
+			{
+				ID:        "retrieve",
+				Kind:      engine.StepKindRetrieve,
+				DependsOn: []engine.StepID{"query", "docs"},
+				Config:    map[string]any{"top_k": 2},
+				Export:    true,
+			},
+		},
+	})
+
+	req := sampleJobRequest()
+	req.PipelineType = "provider_rag"
+	req.Mode = "sync"
+	req.Input.Sources = []engine.Source{
+		{Kind: engine.SourceKindNote, Label: "a", Content: "rust ownership"},
+		{Kind: engine.SourceKindNote, Label: "b", Content: "pasta recipe"},
+		{Kind: engine.SourceKindNote, Label: "c", Content: "rust borrow checker"},
+	}
+	job, err := eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ジョブ実行に失敗しました: %v", err)
+	}
+	if job.Status != engine.JobStatusSucceeded || job.Result == nil || len(job.Result.Items) != 2 {
+		t.Fatalf("retrieve の結果が不正です: %s %+v %+v", job.Status, job.Error, job.Result)
+	}
+	first, _ := job.Result.Items[0].Data.(map[string]any)
+	second, _ := job.Result.Items[1].Data.(map[string]any)
+	if first["source"] != "rust borrow checker" || second["source"] != "rust ownership" {
+		t.Fatalf("プロバイダの埋め込みで類似度順に選ばれていません: %+v / %+v", first, second)
+	}
+	if got := openAIModel.Load(); got != "text-embedding-3-small" {
+		t.Fatalf("OpenAI の埋め込みモデルが既定値になっていません: %v", got)
+	}
+	if got := ollamaModel.Load(); got != "mxbai-embed-large" {
+		t.Fatalf("Ollama の埋め込みモデルに extra.embedding_model が使われていません: %v", got)
+	}
+}
+
+func TestBasicEngine_InputFromBindsNamedUpstreams(t *testing.T) {
+	t.Parallel()
+
+	eng := engine.NewBasicEngineWithConfig(store.NewMemoryStore(), &engine.EngineConfig{ExportPrompts: true})
+	def := engine.PipelineDef{
+		Type: "input_from_pipeline",
+		Steps: []engine.StepDef{
+			{ID: engine.StepID("docs"), Kind: engine.StepKindMap, Mode: engine.StepModeFanOut},
+			{ID: engine.StepID("summary"), Kind: engine.StepKindLLM},
+			{
+				ID:        engine.StepID("join"),
+				Kind:      engine.StepKindLLM,
+				Mode:      engine.StepModePerItem,
+				DependsOn: []engine.StepID{"docs", "summary"},
+				InputFrom: []engine.InputBinding{
+					{Name: "doc", Step: "docs", PerItem: true},
+					{Name: "overview", Step: "summary"},
+				},
+				Prompt: &engine.PromptTemplate{User: "{{len .Inputs.doc}}/{{len .Inputs.overview}}"},
+				Export: true,
+			},
+		},
+	}
+	if err := eng.ValidatePipeline(def); err != nil {
+		t.Fatalf("正しい InputFrom が検証エラーになりました: %v", err)
+	}
+	eng.RegisterPipeline(def)
+
+	req := sampleJobRequest()
+	req.PipelineType = "input_from_pipeline"
+	req.Mode = "sync"
+	req.Input.Sources = append(req.Input.Sources, engine.Source{Kind: engine.SourceKindNote, Label: "b", Content: "B"})
+	job, err := eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ジョブ実行に失敗しました: %v", err)
+	}
+	if job.Status != engine.JobStatusSucceeded || job.Result == nil {
+		t.Fatalf("ジョブが成功していません: %s %+v", job.Status, job.Error)
+	}
+	// 最後の依存（summary: 1 件）ではなく per_item 指定の docs（2 件）を基準に実行される。
+	if len(job.Result.Items) != 2 {
+		t.Fatalf("per_item の基準が InputFrom になっていません: %+v", job.Result.Items)
+	}
+	for _, item := range job.Result.Items {
+		data, _ := item.Data.(map[string]any)
+		if data["previous_step"] != engine.StepID("docs") || data["prompt"] != "2/1" {
+			t.Fatalf("InputFrom の束縛が反映されていません: %+v", data)
+		}
+	}
+
+	def.Steps[2].InputFrom = []engine.InputBinding{{Name: "later", Step: "join"}, {Step: "docs"}}
+	if err := eng.ValidatePipeline(def); err == nil {
+		t.Fatal("不正な InputFrom が検証エラーになっていません")
+	}
+}
+
+func TestBasicEngine_ValidatePipelineChecksStepConfig(t *testing.T) {
+	t.Parallel()
+
+	eng := engine.NewBasicEngine(store.NewMemoryStore())
+	valid := engine.PipelineDef{
+		Type:    "config_pipeline",
+		Version: "v1",
+		Steps: []engine.StepDef{
+			{ID: "ask", Kind: engine.StepKindLLM, Config: map[string]any{"empty_output": "Retry", "max_tool_iterations": "3"}},
+			{ID: "merge", Kind: engine.StepKindReduce, DependsOn: []engine.StepID{"ask"}, Config: map[string]any{"reduce_token_threshold": float64(100)}},
+		},
+	}
+	if err := eng.ValidatePipeline(valid); err != nil {
+		t.Fatalf("正しい config が検証エラーになりました: %v", err)
+	}
+	if n, ok := valid.Steps[0].ConfigInt("max_tool_iterations"); !ok || n != 3 {
+		t.Fatalf("ConfigInt が数値文字列を解釈できていません: %d %v", n, ok)
+	}
+	if v, ok := valid.Steps[0].ConfigString("empty_output"); !ok || v != "Retry" {
+		t.Fatalf("ConfigString が想定外です: %q %v", v, ok)
+	}
+
+	invalid := engine.PipelineDef{
+		Type:    "config_pipeline",
+		Version: "v2",
+		Steps: []engine.StepDef{
+			{ID: "ask", Kind: engine.StepKindLLM, Config: map[string]any{
+				"temparature":            0.2,
+				"empty_output":           "ignore",
+				"max_tool_iterations":    "many",
+				"reduce_token_threshold": 100,
+			}},
+		},
+	}
+	err := eng.ValidatePipeline(invalid)
+	if err == nil {
+		t.Fatal("不正な config が検証エラーになっていません")
+	}
+	for _, want := range []string{`"temparature"`, `"empty_output"`, `"max_tool_iterations"`, `"reduce_token_threshold" does not apply`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("検証エラーに %s が含まれていません: %v", want, err)
+		}
+	}
+}
+
+func TestBasicEngine_DisableDefaultProfiles(t *testing.T) {
+	t.Parallel()
+
+	def := engine.PipelineDef{
+		Type:    "defaults_pipeline",
+		Version: "v1",
+		Steps:   []engine.StepDef{{ID: "tool", Kind: engine.StepKindLLM, ProviderProfileID: "default-local", Export: true}},
+	}
+	if err := engine.NewBasicEngine(store.NewMemoryStore()).ValidatePipeline(def); err != nil {
+		t.Fatalf("既定ではデフォルトプロファイルが登録されるはずです: %v", err)
+	}
+
+	eng := engine.NewBasicEngineWithConfig(store.NewMemoryStore(), &engine.EngineConfig{
+		DisableDefaultProfiles: true,
+		Providers:              []engine.ProviderProfile{{ID: "configured", Kind: engine.ProviderLocal}},
+	})
+	err := eng.ValidatePipeline(def)
+	var unresolved *engine.ProviderUnresolvedError
+	if !errors.As(err, &unresolved) || unresolved.ProfileID != "default-local" {
+		t.Fatalf("無効化したデフォルトプロファイルが解決されています: %v", err)
+	}
+	def.Steps[0].ProviderProfileID = "configured"
+	if err := eng.ValidatePipeline(def); err != nil {
+		t.Fatalf("設定したプロファイルは解決できるはずです: %v", err)
+	}
+
+	def.Steps[0].ProviderProfileID = "default-local"
+	eng.RegisterPipeline(def)
+	req := sampleJobRequest()
+	req.PipelineType = "defaults_pipeline"
+	req.Mode = "sync"
+	job, err := eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ジョブの起動に失敗しました: %v", err)
+	}
+	if job.Status != engine.JobStatusFailed || job.Error == nil || job.Error.Code != "provider_unresolved" {
+		t.Fatalf("デフォルトプロファイルを参照するステップは provider_unresolved で失敗するはずです: %s %+v", job.Status, job.Error)
+	}
+}
+
+func TestBasicEngine_StrictPipelineResolution(t *testing.T) {
+	t.Parallel()
+
+	lenient := engine.NewBasicEngine(store.NewMemoryStore())
+	req := sampleJobRequest()
+	req.PipelineType = "openai.sumarize.v1"
+	req.Mode = "sync"
+	if _, err := lenient.RunJob(context.Background(), req); err != nil {
+		t.Fatalf("既定モードでは未登録パイプラインも実行されるはずです: %v", err)
+	}
+
+	strict := engine.NewBasicEngineWithConfig(store.NewMemoryStore(), &engine.EngineConfig{StrictPipelineResolution: true})
+	if _, err := strict.RunJob(context.Background(), req); !errors.Is(err, engine.ErrPipelineNotFound) {
+		t.Fatalf("strict モードで ErrPipelineNotFound が返りません: %v", err)
+	}
+	req.PipelineVersion = "v0"
+	if _, err := strict.RunJob(context.Background(), req); !errors.Is(err, engine.ErrPipelineNotFound) {
+		t.Fatalf("strict モードで既定パイプラインのバージョン指定が通ってしまいます: %v", err)
+	}
+
+	strict.RegisterPipeline(engine.PipelineDef{
+		Type:  "openai.summarize.v1",
+		Steps: []engine.StepDef{{ID: engine.StepID("summarize"), Kind: engine.StepKindLLM, Export: true}},
+	})
+	req.PipelineType = "openai.summarize.v1"
+	req.PipelineVersion = ""
+	if _, err := strict.RunJob(context.Background(), req); err != nil {
+		t.Fatalf("登録済みパイプラインの実行に失敗しました: %v", err)
+	}
+}
+
+func TestBasicEngine_PromptMetaMessages(t *testing.T) {
+	t.Parallel()
+
+	type message struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+	}
+	received := make(chan []message, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Messages []message `json:"messages"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		received <- payload.Messages
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"ok"}}]}`))
+	}))
+	defer ts.Close()
+
+	cfg := &engine.EngineConfig{
+		Providers: []engine.ProviderProfile{
+			{ID: engine.ProviderProfileID("meta-openai"), Kind: engine.ProviderOpenAI, BaseURI: ts.URL, APIKey: "test"},
+		},
+	}
+	eng := engine.NewBasicEngineWithConfig(store.NewMemoryStore(), cfg)
+	eng.RegisterPipeline(engine.PipelineDef{
+		Type:    "meta_pipeline",
+		Version: "v1",
+		Steps: []engine.StepDef{
+			{
+				ID:                engine.StepID("answer"),
+				Kind:              engine.StepKindLLM,
+				ProviderProfileID: engine.ProviderProfileID("meta-openai"),
+				Prompt: &engine.PromptTemplate{
+					User: "{{range .Sources}}{{.Content}}{{end}}",
+					Meta: map[string]any{
+						"messages": []any{
+							map[string]any{"role": "developer", "content": "Reply in {{.Options.Language}}"},
+							map[string]any{"role": "assistant", "content": "回答:"},
+						},
+					},
+				},
+				Export: true,
+			},
+		},
+	})
+
+	req := sampleJobRequest()
+	req.PipelineType = "meta_pipeline"
+	req.Mode = "sync"
+	job, err := eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("meta ジョブの起動に失敗しました: %v", err)
+	}
+	if job.Status != engine.JobStatusSucceeded {
+		t.Fatalf("meta ジョブが success ではありません: %s %+v", job.Status, job.Error)
+	}
+
+	got := <-received
+	want := []message{
+		{Role: "developer", Content: "Reply in ja"},
+		{Role: "user", Content: req.Input.Sources[0].Content},
+		{Role: "assistant", Content: "回答:"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("messages の数が想定外です: %+v", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("messages[%d] が想定外です: got=%+v want=%+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestBasicEngine_EmptyProviderOutputPolicy(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name       string
+		policy     string
+		wantStatus engine.JobStatus
+		wantCalls  int32
+	}{
+		{name: "default fails", policy: "", wantStatus: engine.JobStatusFailed, wantCalls: 1},
+		{name: "retry once", policy: "retry", wantStatus: engine.JobStatusFailed, wantCalls: 2},
+		{name: "fallback", policy: "fallback", wantStatus: engine.JobStatusSucceeded, wantCalls: 1},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var calls int32
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&calls, 1)
+				_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"  \n"}}]}`))
+			}))
+			defer ts.Close()
+
+			cfg := &engine.EngineConfig{
+				Providers: []engine.ProviderProfile{
+					{ID: engine.ProviderProfileID("blank-openai"), Kind: engine.ProviderOpenAI, BaseURI: ts.URL, APIKey: "test"},
+				},
+			}
+			eng := engine.NewBasicEngineWithConfig(store.NewMemoryStore(), cfg)
+			step := engine.StepDef{
+				ID:                engine.StepID("answer"),
+				Kind:              engine.StepKindLLM,
+				ProviderProfileID: engine.ProviderProfileID("blank-openai"),
+				Export:            true,
+			}
+			if tc.policy != "" {
+				step.Config = map[string]any{"empty_output": tc.policy}
+			}
+			eng.RegisterPipeline(engine.PipelineDef{Type: "blank_pipeline", Version: "v1", Steps: []engine.StepDef{step}})
+
+			req := sampleJobRequest()
+			req.PipelineType = "blank_pipeline"
+			req.Mode = "sync"
+			job, err := eng.RunJob(context.Background(), req)
+			if err != nil {
+				t.Fatalf("blank ジョブの起動に失敗しました: %v", err)
+			}
+			if job.Status != tc.wantStatus {
+				t.Fatalf("ジョブ状態が想定外です: got=%s want=%s", job.Status, tc.wantStatus)
+			}
+			if tc.wantStatus == engine.JobStatusFailed && (job.Error == nil || job.Error.Code != "empty_output") {
+				t.Fatalf("empty_output エラーが記録されていません: %+v", job.Error)
+			}
+			if got := atomic.LoadInt32(&calls); got != tc.wantCalls {
+				t.Fatalf("Provider 呼び出し回数が想定外です: got=%d want=%d", got, tc.wantCalls)
+			}
+		})
+	}
+}
+
+func TestBasicEngine_CustomIDGenerator(t *testing.T) {
+	t.Parallel()
+
+	var counter int32
+	cfg := &engine.EngineConfig{
+		IDGenerator: func() string {
+			return fmt.Sprintf("id-%04d", atomic.AddInt32(&counter, 1))
+		},
+	}
+	eng := engine.NewBasicEngineWithConfig(store.NewMemoryStore(), cfg)
+
+	req := sampleJobRequest()
+	req.Mode = "sync"
+	job, err := eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ジョブの起動に失敗しました: %v", err)
+	}
+	if job.ID != "id-0001" {
+		t.Fatalf("Job.ID に IDGenerator が使われていません: %s", job.ID)
+	}
+	if job.Result == nil || len(job.Result.Items) == 0 {
+		t.Fatalf("結果が空です: %+v", job.Result)
+	}
+	for _, item := range job.Result.Items {
+		if !strings.HasPrefix(item.ID, "id-") {
+			t.Fatalf("ResultItem.ID に IDGenerator が使われていません: %s", item.ID)
+		}
+	}
+	if batchID := eng.NewBatchID(); !strings.HasPrefix(batchID, "batch-id-") {
+		t.Fatalf("NewBatchID に IDGenerator が使われていません: %s", batchID)
+	}
+}
+
+func TestBasicEngine_ProviderFailover(t *testing.T) {
+	t.Parallel()
+
+	var primaryCalls, secondaryCalls int32
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&primaryCalls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&secondaryCalls, 1)
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"from secondary"}}]}`))
+	}))
+	defer secondary.Close()
+
+	cfg := &engine.EngineConfig{
+		Providers: []engine.ProviderProfile{
+			{ID: engine.ProviderProfileID("primary"), Kind: engine.ProviderOpenAI, BaseURI: primary.URL, APIKey: "test"},
+			{ID: engine.ProviderProfileID("secondary"), Kind: engine.ProviderOpenAI, BaseURI: secondary.URL, APIKey: "test"},
+		},
+	}
+	eng := engine.NewBasicEngineWithConfig(store.NewMemoryStore(), cfg)
+	eng.RegisterPipeline(engine.PipelineDef{
+		Type:    "failover_pipeline",
+		Version: "v1",
+		Steps: []engine.StepDef{
+			{
+				ID:                engine.StepID("answer"),
+				Kind:              engine.StepKindLLM,
+				ProviderProfileID: engine.ProviderProfileID("primary"),
+				Fallbacks:         []engine.ProviderProfileID{"missing", "secondary"},
+				Export:            true,
+			},
+		},
+	})
+
+	req := sampleJobRequest()
+	req.PipelineType = "failover_pipeline"
+	req.Mode = "sync"
+	job, err := eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("failover ジョブの起動に失敗しました: %v", err)
+	}
+	if job.Status != engine.JobStatusSucceeded {
+		t.Fatalf("failover ジョブが success ではありません: %s %+v", job.Status, job.Error)
+	}
+	data, ok := job.Result.Items[0].Data.(map[string]any)
+	if !ok {
+		t.Fatalf("結果データを map へ変換できません: %+v", job.Result.Items[0].Data)
+	}
+	if data["text"] != "from secondary" || data["provider_profile_id"] != "secondary" {
+		t.Fatalf("フェイルオーバー先の結果が記録されていません: %+v", data)
+	}
+	if atomic.LoadInt32(&primaryCalls) != 1 || atomic.LoadInt32(&secondaryCalls) != 1 {
+		t.Fatalf("Provider 呼び出し回数が想定外です: primary=%d secondary=%d", primaryCalls, secondaryCalls)
+	}
+}
+
+func TestBasicEngine_ProviderRetryHonorsRetryAfter(t *testing.T) {
+	t.Parallel()
+
+	var calls int32
+	var mu sync.Mutex
+	var callTimes []time.Time
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		callTimes = append(callTimes, time.Now())
+		mu.Unlock()
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"retried"}}]}`))
+	}))
+	defer ts.Close()
+
+	cfg := &engine.EngineConfig{
+		Providers: []engine.ProviderProfile{
+			{ID: engine.ProviderProfileID("limited"), Kind: engine.ProviderOpenAI, BaseURI: ts.URL, APIKey: "test"},
+		},
+	}
+	eng := engine.NewBasicEngineWithConfig(store.NewMemoryStore(), cfg)
+	eng.RegisterPipeline(engine.PipelineDef{
+		Type:    "retry_pipeline",
+		Version: "v1",
+		Steps: []engine.StepDef{
+			{
+				ID:                engine.StepID("answer"),
+				Kind:              engine.StepKindLLM,
+				ProviderProfileID: engine.ProviderProfileID("limited"),
+				Config:            map[string]any{engine.ProviderRetriesConfigKey: 2, engine.ProviderRetryBackoffConfigKey: 1},
+				Export:            true,
+			},
+		},
+	})
+
+	req := sampleJobRequest()
+	req.PipelineType = "retry_pipeline"
+	req.Mode = "sync"
+	job, err := eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("retry ジョブの起動に失敗しました: %v", err)
+	}
+	if job.Status != engine.JobStatusSucceeded {
+		t.Fatalf("429 のあと再試行で成功するはずです: %s %+v", job.Status, job.Error)
+	}
+	data, _ := job.Result.Items[0].Data.(map[string]any)
+	if data["text"] != "retried" || data["provider_retries"] != 1 {
+		t.Fatalf("再試行の結果が記録されていません: %+v", data)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(callTimes) != 2 {
+		t.Fatalf("Provider 呼び出し回数が想定外です: %d", len(callTimes))
+	}
+	// provider_retry_backoff_ms is 1ms, so only Retry-After explains the wait.
+	if wait := callTimes[1].Sub(callTimes[0]); wait < time.Second || wait > 2*time.Second {
+		t.Fatalf("Retry-After の 1 秒を待たずに再試行しています: %s", wait)
+	}
+}
+
+func TestBasicEngine_ProviderFailoverSkipsNonRetryableErrors(t *testing.T) {
+	t.Parallel()
+
+	var secondaryCalls int32
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer primary.Close()
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&secondaryCalls, 1)
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"from secondary"}}]}`))
+	}))
+	defer secondary.Close()
+
+	cfg := &engine.EngineConfig{
+		Providers: []engine.ProviderProfile{
+			{ID: engine.ProviderProfileID("primary"), Kind: engine.ProviderOpenAI, BaseURI: primary.URL, APIKey: "test"},
+			{ID: engine.ProviderProfileID("secondary"), Kind: engine.ProviderOpenAI, BaseURI: secondary.URL, APIKey: "test"},
+		},
+	}
+	eng := engine.NewBasicEngineWithConfig(store.NewMemoryStore(), cfg)
+	eng.RegisterPipeline(engine.PipelineDef{
+		Type:    "failover_pipeline",
+		Version: "v1",
+		Steps: []engine.StepDef{
+			{
+				ID:                engine.StepID("answer"),
+				Kind:              engine.StepKindLLM,
+				ProviderProfileID: engine.ProviderProfileID("primary"),
+				Fallbacks:         []engine.ProviderProfileID{"secondary"},
+			},
+		},
+	})
+
+	req := sampleJobRequest()
+	req.PipelineType = "failover_pipeline"
+	req.Mode = "sync"
+	job, err := eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("failover ジョブの起動に失敗しました: %v", err)
+	}
+	if job.Status != engine.JobStatusFailed {
+		t.Fatalf("400 エラーでジョブが失敗していません: %s", job.Status)
+	}
+	if got := atomic.LoadInt32(&secondaryCalls); got != 0 {
+		t.Fatalf("非リトライ対象のエラーでフェイルオーバーしています: %d", got)
+	}
+}
+
+func TestBasicEngine_SkipRunningStep(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	memoryStore := store.NewMemoryStore()
+	cfg := &engine.EngineConfig{
+		Providers: []engine.ProviderProfile{
+			{ID: engine.ProviderProfileID("hang-openai"), Kind: engine.ProviderOpenAI, BaseURI: ts.URL, APIKey: "test"},
+		},
+	}
+	eng := engine.NewBasicEngineWithConfig(memoryStore, cfg)
+	eng.RegisterPipeline(engine.PipelineDef{
+		Type:    "skip_pipeline",
+		Version: "v1",
+		Steps: []engine.StepDef{
+			{ID: engine.StepID("hang"), Kind: engine.StepKindLLM, ProviderProfileID: engine.ProviderProfileID("hang-openai")},
+			{ID: engine.StepID("after"), Kind: engine.StepKindLLM, DependsOn: []engine.StepID{"hang"}, Export: true},
+		},
+	})
+
+	req := sampleJobRequest()
+	req.PipelineType = "skip_pipeline"
+	job, err := eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("skip ジョブの起動に失敗しました: %v", err)
+	}
+
+	if err := eng.SkipStep(context.Background(), job.ID, engine.StepID("after")); !errors.Is(err, engine.ErrStepNotRunning) {
+		t.Fatalf("未実行ステップの skip は ErrStepNotRunning を返すべきです: %v", err)
+	}
+	if err := eng.SkipStep(context.Background(), job.ID, engine.StepID("missing")); !errors.Is(err, engine.ErrStepNotFound) {
+		t.Fatalf("存在しないステップの skip は ErrStepNotFound を返すべきです: %v", err)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		err := eng.SkipStep(context.Background(), job.ID, engine.StepID("hang"))
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, engine.ErrStepNotRunning) || time.Now().After(deadline) {
+			t.Fatalf("実行中ステップの skip に失敗しました: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	finalJob := waitForJobStatus(t, memoryStore, job.ID, engine.JobStatusSucceeded, 3*time.Second)
+	if got := finalJob.StepExecutions[0].Status; got != engine.StepExecSkipped {
+		t.Fatalf("hang ステップが skipped になっていません: %s", got)
+	}
+	if got := finalJob.StepExecutions[1].Status; got != engine.StepExecSuccess {
+		t.Fatalf("後続ステップが実行されていません: %s", got)
+	}
+	if finalJob.Result == nil || len(finalJob.Result.Items) != 1 {
+		t.Fatalf("後続ステップの結果が想定外です: %+v", finalJob.Result)
+	}
+}
+
+func TestBasicEngine_MaxConcurrencyQueuesJobs(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	memoryStore := store.NewMemoryStore()
+	eng := engine.NewBasicEngineWithConfig(memoryStore, &engine.EngineConfig{
+		Providers: []engine.ProviderProfile{
+			{ID: engine.ProviderProfileID("hang-openai"), Kind: engine.ProviderOpenAI, BaseURI: ts.URL, APIKey: "test"},
+		},
+		MaxConcurrency: 1,
+	})
+	eng.RegisterPipeline(engine.PipelineDef{
+		Type:    "slot_pipeline",
+		Version: "v1",
+		Steps: []engine.StepDef{
+			{ID: engine.StepID("hang"), Kind: engine.StepKindLLM, ProviderProfileID: engine.ProviderProfileID("hang-openai")},
+		},
+	})
+	req := sampleJobRequest()
+	req.PipelineType = "slot_pipeline"
+
+	first, err := eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("1 件目のジョブ起動に失敗しました: %v", err)
+	}
+	waitForJobStatus(t, memoryStore, first.ID, engine.JobStatusRunning, 3*time.Second)
+	second, err := eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("2 件目のジョブ起動に失敗しました: %v", err)
+	}
+	defer func() {
+		_ = eng.CancelJob(context.Background(), first.ID, "")
+		_ = eng.CancelJob(context.Background(), second.ID, "")
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	if job, _ := memoryStore.GetJob(context.Background(), second.ID); job.Status != engine.JobStatusQueued {
+		t.Fatalf("上限到達時は queued のまま待機するはずです: %s", job.Status)
+	}
+
+	cfg := eng.RuntimeConfig()
+	cfg.MaxConcurrency = 2
+	if _, err := eng.UpdateRuntimeConfig(cfg); err != nil {
+		t.Fatalf("ランタイム設定の更新に失敗しました: %v", err)
+	}
+	waitForJobStatus(t, memoryStore, second.ID, engine.JobStatusRunning, 3*time.Second)
+}
+
+func TestBasicEngine_PipelineMaxConcurrencyQueuesJobs(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	memoryStore := store.NewMemoryStore()
+	eng := engine.NewBasicEngineWithConfig(memoryStore, &engine.EngineConfig{
+		Providers: []engine.ProviderProfile{
+			{ID: engine.ProviderProfileID("hang-openai"), Kind: engine.ProviderOpenAI, BaseURI: ts.URL, APIKey: "test"},
+		},
+		MaxConcurrency:      3,
+		PipelineConcurrency: map[engine.PipelineType]int{"capped_by_config": 1},
+	})
+	steps := []engine.StepDef{
+		{ID: engine.StepID("hang"), Kind: engine.StepKindLLM, ProviderProfileID: engine.ProviderProfileID("hang-openai")},
+	}
+	eng.RegisterPipeline(engine.PipelineDef{Type: "capped_by_def", Version: "v1", MaxConcurrency: 1, Steps: steps})
+	eng.RegisterPipeline(engine.PipelineDef{Type: "capped_by_config", Version: "v1", Steps: steps})
+	eng.RegisterPipeline(engine.PipelineDef{Type: "uncapped", Version: "v1", Steps: steps})
+
+	run := func(pt engine.PipelineType) *engine.Job {
+		t.Helper()
+		req := sampleJobRequest()
+		req.PipelineType = pt
+		job, err := eng.RunJob(context.Background(), req)
+		if err != nil {
+			t.Fatalf("%s のジョブ起動に失敗しました: %v", pt, err)
+		}
+		t.Cleanup(func() { _ = eng.CancelJob(context.Background(), job.ID, "") })
+		return job
+	}
+
+	for _, pt := range []engine.PipelineType{"capped_by_def", "capped_by_config"} {
+		first := run(pt)
+		waitForJobStatus(t, memoryStore, first.ID, engine.JobStatusRunning, 3*time.Second)
+		second := run(pt)
+		time.Sleep(100 * time.Millisecond)
+		if job, _ := memoryStore.GetJob(context.Background(), second.ID); job.Status != engine.JobStatusQueued {
+			t.Fatalf("%s の上限到達時は queued のまま待機するはずです: %s", pt, job.Status)
+		}
+	}
+	// 待機中のジョブはグローバルの枠を消費しないため、他のパイプラインは実行できる。
+	other := run("uncapped")
+	waitForJobStatus(t, memoryStore, other.ID, engine.JobStatusRunning, 3*time.Second)
+
+	inFlight := eng.PipelineInFlight()
+	if inFlight["capped_by_def"] != 1 || inFlight["capped_by_config"] != 1 || inFlight["uncapped"] != 1 {
+		t.Fatalf("パイプラインごとの実行数が想定外です: %v", inFlight)
+	}
+
+	if err := eng.ValidatePipeline(engine.PipelineDef{Type: "bad", MaxConcurrency: -1}); err == nil {
+		t.Fatalf("負の max_concurrency はエラーになるべきです")
+	}
+}
+
+func TestBasicEngine_CancelQueuedJobNeverRuns(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	memoryStore := store.NewMemoryStore()
+	eng := engine.NewBasicEngineWithConfig(memoryStore, &engine.EngineConfig{
+		Providers: []engine.ProviderProfile{
+			{ID: engine.ProviderProfileID("hang-openai"), Kind: engine.ProviderOpenAI, BaseURI: ts.URL, APIKey: "test"},
+		},
+		MaxConcurrency: 1,
+	})
+	eng.RegisterPipeline(engine.PipelineDef{
+		Type:    "queued_cancel_pipeline",
+		Version: "v1",
+		Steps: []engine.StepDef{
+			{ID: engine.StepID("first"), Kind: engine.StepKindLLM, ProviderProfileID: engine.ProviderProfileID("hang-openai")},
+			{ID: engine.StepID("second"), Kind: engine.StepKindLLM, DependsOn: []engine.StepID{"first"}},
+		},
+	})
+	req := sampleJobRequest()
+	req.PipelineType = "queued_cancel_pipeline"
+
+	blocker, err := eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("1 件目のジョブ起動に失敗しました: %v", err)
+	}
+	waitForJobStatus(t, memoryStore, blocker.ID, engine.JobStatusRunning, 3*time.Second)
+	for deadline := time.Now().Add(3 * time.Second); calls.Load() == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("1 件目のジョブが Provider を呼び出しませんでした")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	queued, err := eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("2 件目のジョブ起動に失敗しました: %v", err)
+	}
+	if err := eng.CancelJob(context.Background(), queued.ID, "no longer needed"); err != nil {
+		t.Fatalf("queued ジョブのキャンセルに失敗しました: %v", err)
+	}
+	// スロットを空けても、キャンセル済みのジョブは実行されない。
+	if err := eng.CancelJob(context.Background(), blocker.ID, ""); err != nil {
+		t.Fatalf("1 件目のジョブのキャンセルに失敗しました: %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	job, err := memoryStore.GetJob(context.Background(), queued.ID)
+	if err != nil {
+		t.Fatalf("ジョブの取得に失敗しました: %v", err)
+	}
+	if job.Status != engine.JobStatusCancelled || job.Cancellation == nil || job.Cancellation.Reason != "no longer needed" {
+		t.Fatalf("キャンセル状態が維持されていません: %s %+v", job.Status, job.Cancellation)
+	}
+	for _, exec := range job.StepExecutions {
+		if exec.StartedAt != nil || exec.Status != engine.StepExecCancelled {
+			t.Fatalf("キャンセル済みジョブのステップが実行されています: %+v", job.StepExecutions)
+		}
+	}
+	if got := calls.Load(); got != 1 {
+		t.Fatalf("Provider はブロック中のジョブからの 1 回だけ呼ばれるはずです: %d", got)
+	}
+}
+
+func TestBasicEngine_UpdateRuntimeConfigProviderTimeout(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	memoryStore := store.NewMemoryStore()
+	eng := engine.NewBasicEngineWithConfig(memoryStore, &engine.EngineConfig{
+		Providers: []engine.ProviderProfile{
+			{ID: engine.ProviderProfileID("hang-openai"), Kind: engine.ProviderOpenAI, BaseURI: ts.URL, APIKey: "test"},
+		},
+	})
+	if got := eng.RuntimeConfig().ProviderTimeout; got != 30*time.Second {
+		t.Fatalf("既定の provider timeout が想定外です: %s", got)
+	}
+	if _, err := eng.UpdateRuntimeConfig(engine.RuntimeConfig{ProviderTimeout: time.Millisecond}); err == nil {
+		t.Fatal("範囲外の provider timeout はエラーになるべきです")
+	}
+	if _, err := eng.UpdateRuntimeConfig(engine.RuntimeConfig{ProviderTimeout: 200 * time.Millisecond, MaxConcurrency: -1}); err == nil {
+		t.Fatal("負の max concurrency はエラーになるべきです")
+	}
+	if _, err := eng.UpdateRuntimeConfig(engine.RuntimeConfig{ProviderTimeout: 200 * time.Millisecond}); err != nil {
+		t.Fatalf("ランタイム設定の更新に失敗しました: %v", err)
+	}
+
+	eng.RegisterPipeline(engine.PipelineDef{
+		Type:    "timeout_pipeline",
+		Version: "v1",
+		Steps: []engine.StepDef{
+			{ID: engine.StepID("hang"), Kind: engine.StepKindLLM, ProviderProfileID: engine.ProviderProfileID("hang-openai")},
+		},
+	})
+	req := sampleJobRequest()
+	req.PipelineType = "timeout_pipeline"
+	job, err := eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ジョブの起動に失敗しました: %v", err)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		current, err := memoryStore.GetJob(context.Background(), job.ID)
+		if err != nil {
+			t.Fatalf("ジョブの取得に失敗しました: %v", err)
+		}
+		if current.Status == engine.JobStatusFailed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("更新後の provider timeout が適用されていません: %s", current.Status)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestBasicEngine_PipelineDefaultProviderProfile(t *testing.T) {
+	t.Parallel()
+
+	newServer := func(output string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"choices": []map[string]any{{"message": map[string]any{"content": output}}},
+			})
+		}))
+	}
+	shared := newServer("共通プロファイル")
+	defer shared.Close()
+	special := newServer("個別プロファイル")
+	defer special.Close()
+	eng := engine.NewBasicEngineWithConfig(store.NewMemoryStore(), &engine.EngineConfig{
+		Providers: []engine.ProviderProfile{
+			{ID: engine.ProviderProfileID("shared"), Kind: engine.ProviderOpenAI, BaseURI: shared.URL, APIKey: "test"},
+			{ID: engine.ProviderProfileID("special"), Kind: engine.ProviderOpenAI, BaseURI: special.URL, APIKey: "test"},
+		},
+	})
+	def := engine.PipelineDef{
+		Type:                     "default_profile_pipeline",
+		Version:                  "v1",
+		DefaultProviderProfileID: engine.ProviderProfileID("shared"),
+		Steps: []engine.StepDef{
+			{ID: engine.StepID("inherit"), Kind: engine.StepKindLLM, Export: true},
+			{ID: engine.StepID("override"), Kind: engine.StepKindLLM, DependsOn: []engine.StepID{"inherit"}, ProviderProfileID: engine.ProviderProfileID("special"), Export: true},
+		},
+	}
+	if err := eng.ValidatePipeline(def); err != nil {
+		t.Fatalf("既定プロファイルを持つパイプラインは有効なはずです: %v", err)
+	}
+	eng.RegisterPipeline(def)
+
+	req := sampleJobRequest()
+	req.PipelineType = "default_profile_pipeline"
+	req.Mode = "sync"
+	job, err := eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ジョブ実行に失敗しました: %v", err)
+	}
+	if job.Status != engine.JobStatusSucceeded || len(job.Result.Items) != 2 {
+		t.Fatalf("ジョブが成功していません: %s %+v", job.Status, job.Error)
+	}
+	for i, want := range []string{"共通プロファイル", "個別プロファイル"} {
+		data, _ := job.Result.Items[i].Data.(map[string]any)
+		if data["text"] != want {
+			t.Fatalf("%s の Provider が想定外です: %+v", job.Result.Items[i].StepID, data)
+		}
+	}
+
+	invalid := engine.PipelineDef{
+		Type:                     "default_profile_invalid",
+		Version:                  "v1",
+		DefaultProviderProfileID: engine.ProviderProfileID("ghost-default"),
+		Steps:                    []engine.StepDef{{ID: engine.StepID("inherit"), Kind: engine.StepKindLLM}},
+	}
+	if err := eng.ValidatePipeline(invalid); err == nil || !strings.Contains(err.Error(), "ghost-default") {
+		t.Fatalf("未登録の既定プロファイルが検出されていません: %v", err)
+	}
+	orphan := engine.PipelineDef{
+		Type:    "fallback_without_profile",
+		Version: "v1",
+		Steps:   []engine.StepDef{{ID: engine.StepID("orphan"), Kind: engine.StepKindLLM, Fallbacks: []engine.ProviderProfileID{"shared"}}},
+	}
+	if err := eng.ValidatePipeline(orphan); err == nil || !strings.Contains(err.Error(), "fallbacks require") {
+		t.Fatalf("プロファイルなしの fallbacks が検出されていません: %v", err)
+	}
+}
+
+func TestBasicEngine_UnresolvedProviderFailsStep(t *testing.T) {
+	t.Parallel()
+
+	memoryStore := store.NewMemoryStore()
+	eng := engine.NewBasicEngineWithConfig(memoryStore, &engine.EngineConfig{
+		Providers: []engine.ProviderProfile{
+			{ID: engine.ProviderProfileID("unknown-kind"), Kind: engine.ProviderKind("bogus")},
+		},
+	})
+	def := engine.PipelineDef{
+		Type:    "unresolved_pipeline",
+		Version: "v1",
+		Steps: []engine.StepDef{
+			{ID: engine.StepID("ghost"), Kind: engine.StepKindLLM, ProviderProfileID: engine.ProviderProfileID("ghost-profile")},
+		},
+	}
+	if err := eng.ValidatePipeline(def); err == nil || !strings.Contains(err.Error(), "ghost-profile") {
+		t.Fatalf("未登録プロファイルが検出されていません: %v", err)
+	}
+	eng.RegisterPipeline(def)
+
+	cases := []struct {
+		pipeline engine.PipelineType
+		profile  string
+		kind     string
+	}{
+		{pipeline: "unresolved_pipeline", profile: "ghost-profile"},
+		{pipeline: "unresolved_kind_pipeline", profile: "unknown-kind", kind: "bogus"},
+	}
+	eng.RegisterPipeline(engine.PipelineDef{
+		Type:    "unresolved_kind_pipeline",
+		Version: "v1",
+		Steps: []engine.StepDef{
+			{ID: engine.StepID("ghost"), Kind: engine.StepKindLLM, ProviderProfileID: engine.ProviderProfileID("unknown-kind")},
+		},
+	})
+
+	for _, tc := range cases {
+		req := sampleJobRequest()
+		req.PipelineType = tc.pipeline
+		req.Mode = "sync"
+		job, err := eng.RunJob(context.Background(), req)
+		if err != nil {
+			t.Fatalf("ジョブ実行に失敗しました: %v", err)
+		}
+		if job.Status != engine.JobStatusFailed {
+			t.Fatalf("%s: ジョブが失敗していません: %s", tc.pipeline, job.Status)
+		}
+		if len(job.StepExecutions) != 1 || job.StepExecutions[0].Error == nil {
+			t.Fatalf("%s: ステップエラーが記録されていません: %+v", tc.pipeline, job.StepExecutions)
+		}
+		stepErr := job.StepExecutions[0].Error
+		if stepErr.Code != "provider_unresolved" {
+			t.Fatalf("%s: エラーコードが想定外です: %s", tc.pipeline, stepErr.Code)
+		}
+		raw, _ := json.Marshal(stepErr.Details)
+		var details map[string]string
+		if err := json.Unmarshal(raw, &details); err != nil {
+			t.Fatalf("details のデコードに失敗しました: %v", err)
+		}
+		if details["profile_id"] != tc.profile || details["kind"] != tc.kind {
+			t.Fatalf("%s: details が想定外です: %v", tc.pipeline, details)
+		}
+	}
+}
+
+func TestBasicEngine_JobTTLEvictsFinishedJobs(t *testing.T) {
+	t.Parallel()
+
+	memoryStore := store.NewMemoryStore()
+	eng := engine.NewBasicEngineWithConfig(memoryStore, &engine.EngineConfig{
+		JobTTL:           10 * time.Millisecond,
+		JobSweepInterval: 10 * time.Millisecond,
+	})
+	defer eng.Close()
+
+	req := sampleJobRequest()
+	req.Mode = "sync"
+	job, err := eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ジョブ実行に失敗しました: %v", err)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		if _, err := memoryStore.GetJob(context.Background(), job.ID); errors.Is(err, store.ErrJobNotFound) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("TTL を過ぎたジョブが削除されていません")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if cp := memoryStore.LoadCheckpoints(job.ID); cp != nil {
+		t.Fatalf("削除されたジョブの checkpoint が残っています: %+v", cp)
+	}
+
+	eng.Close()
+}
+
+func TestBasicEngine_RetriesCollidingJobIDs(t *testing.T) {
+	t.Parallel()
+
+	memoryStore := store.NewMemoryStore()
+	var mu sync.Mutex
+	ids := []string{"dup", "dup", "dup", "fresh"}
+	eng := engine.NewBasicEngineWithConfig(memoryStore, &engine.EngineConfig{
+		IDGenerator: func() string {
+			mu.Lock()
+			defer mu.Unlock()
+			if len(ids) == 0 {
+				return "dup"
+			}
+			id := ids[0]
+			ids = ids[1:]
+			return id
+		},
+	})
+
+	req := sampleJobRequest()
+	req.Mode = "sync"
+	first, err := eng.RunJob(context.Background(), req)
+	if err != nil || first.ID != "dup" {
+		t.Fatalf("最初のジョブ作成に失敗しました: job=%+v err=%v", first, err)
+	}
+	second, err := eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ID 衝突時に再採番されていません: %v", err)
+	}
+	if second.ID != "fresh" || second.Status != engine.JobStatusSucceeded {
+		t.Fatalf("再採番したジョブが想定外です: %+v", second)
+	}
+
+	// 生成器が同じ ID を返し続ける場合は上限回数で諦める。
+	if _, err := eng.RunJob(context.Background(), req); !errors.Is(err, store.ErrJobExists) {
+		t.Fatalf("再試行の上限で ErrJobExists を返すべきです: %v", err)
+	}
+}
+
+func TestBasicEngine_ReconcileOrphanedJobs(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	memoryStore := store.NewMemoryStore()
+	seed := func(id string, status engine.JobStatus) {
+		now := time.Now().UTC()
+		job := &engine.Job{
+			ID:           id,
+			PipelineType: engine.PipelineType("orphan.pipeline"),
+			Status:       status,
+			CreatedAt:    now,
+			UpdatedAt:    now,
+			Input:        engine.JobInput{Sources: []engine.Source{{Kind: engine.SourceKindNote, Content: "hello"}}},
+			Mode:         "async",
+			StepExecutions: []engine.StepExecution{
+				{StepID: engine.StepID("step-1"), Status: engine.StepExecRunning, StartedAt: &now},
+			},
+		}
+		if err := memoryStore.CreateJob(ctx, job); err != nil {
+			t.Fatalf("ジョブの登録に失敗しました: %v", err)
+		}
+	}
+	seed("orphan-running", engine.JobStatusRunning)
+	seed("orphan-queued", engine.JobStatusQueued)
+	seed("done", engine.JobStatusSucceeded)
+
+	eng := engine.NewBasicEngine(memoryStore)
+	n, err := eng.ReconcileJobs(ctx, engine.OrphanPolicyFail)
+	if err != nil {
+		t.Fatalf("reconcile に失敗しました: %v", err)
+	}
+	if n != 2 {
+		t.Fatalf("reconcile 件数が想定外です: %d", n)
+	}
+	failed, _ := memoryStore.GetJob(ctx, "orphan-running")
+	if failed.Status != engine.JobStatusFailed || failed.Error == nil || failed.Error.Code != "orphaned" {
+		t.Fatalf("orphaned として失敗していません: %+v", failed)
+	}
+	if failed.StepExecutions[0].Status != engine.StepExecFailed {
+		t.Fatalf("実行中だったステップが失敗扱いになっていません: %+v", failed.StepExecutions[0])
+	}
+	if done, _ := memoryStore.GetJob(ctx, "done"); done.Status != engine.JobStatusSucceeded {
+		t.Fatalf("完了済みジョブが変更されています: %+v", done)
+	}
+
+	seed("orphan-requeue", engine.JobStatusRunning)
+	if _, err := eng.ReconcileJobs(ctx, engine.OrphanPolicyRequeue); err != nil {
+		t.Fatalf("reconcile に失敗しました: %v", err)
+	}
+	requeued := waitForJobStatus(t, memoryStore, "orphan-requeue", engine.JobStatusSucceeded, 3*time.Second)
+	if requeued.Error != nil || requeued.Result == nil {
+		t.Fatalf("再キューしたジョブの結果が想定外です: %+v", requeued)
+	}
+
+	if _, err := engine.ParseOrphanPolicy("retry"); err == nil {
+		t.Fatalf("未知のポリシーがエラーになりません")
+	}
+}
+
+func TestBasicEngine_DryRunSkipsProviderCalls(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"ok"}}]}`))
+	}))
+	defer ts.Close()
+
+	eng := engine.NewBasicEngineWithConfig(store.NewMemoryStore(), &engine.EngineConfig{
+		Providers: []engine.ProviderProfile{
+			{ID: engine.ProviderProfileID("dry-openai"), Kind: engine.ProviderOpenAI, BaseURI: ts.URL, APIKey: "test"},
+		},
+	})
+	eng.RegisterPipeline(engine.PipelineDef{
+		Type:    "dry_pipeline",
+		Version: "v1",
+		Steps: []engine.StepDef{
+			{
+				ID:                engine.StepID("answer"),
+				Kind:              engine.StepKindLLM,
+				ProviderProfileID: engine.ProviderProfileID("dry-openai"),
+				Prompt:            &engine.PromptTemplate{User: "要約: {{range .Sources}}{{.Content}}{{end}}"},
+				Export:            true,
+			},
+		},
+	})
+
+	req := sampleJobRequest()
+	req.PipelineType = "dry_pipeline"
+	req.Mode = engine.ModeDryRun
+	job, err := eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("dry_run ジョブの起動に失敗しました: %v", err)
+	}
+	if job.Status != engine.JobStatusSucceeded {
+		t.Fatalf("dry_run ジョブが完了していません: %s %+v", job.Status, job.Error)
+	}
+	if n := calls.Load(); n != 0 {
+		t.Fatalf("dry_run で Provider が %d 回呼び出されました", n)
+	}
+	want := "要約: " + req.Input.Sources[0].Content
+	if got := job.StepExecutions[0].Prompt; got != want {
+		t.Fatalf("レンダリング済みプロンプトが想定外です: got=%q want=%q", got, want)
+	}
+	if job.Result == nil || len(job.Result.Items) == 0 {
+		t.Fatalf("dry_run でもスタブ結果が返るべきです: %+v", job.Result)
+	}
+}
+
+func TestBasicEngine_SourceMetadataPropagatesToResults(t *testing.T) {
+	t.Parallel()
+
+	eng := engine.NewBasicEngine(store.NewMemoryStore())
+	eng.RegisterPipeline(engine.PipelineDef{
+		Type:    "provenance_pipeline",
+		Version: "v1",
+		Steps: []engine.StepDef{
+			{ID: engine.StepID("split"), Kind: engine.StepKindMap, Mode: engine.StepModeFanOut, Export: true},
+			{ID: engine.StepID("refine"), Kind: engine.StepKindLLM, Mode: engine.StepModePerItem, DependsOn: []engine.StepID{"split"}, Export: true},
+			{ID: engine.StepID("merge"), Kind: engine.StepKindReduce, DependsOn: []engine.StepID{"refine"}, Export: true},
+		},
+	})
+
+	req := sampleJobRequest()
+	req.PipelineType = "provenance_pipeline"
+	req.Mode = "sync"
+	req.Input.Sources = []engine.Source{
+		{Kind: engine.SourceKindNote, Label: "a", Content: "A", Metadata: map[string]any{"filename": "a.txt"}},
+		{Kind: engine.SourceKindNote, Label: "b", Content: "B"},
+	}
+	job, err := eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ジョブ実行に失敗しました: %v", err)
+	}
+	if job.Status != engine.JobStatusSucceeded || job.Result == nil {
+		t.Fatalf("ジョブが成功していません: %s %+v", job.Status, job.Error)
+	}
+
+	byStep := map[engine.StepID][]map[string]any{}
+	for _, item := range job.Result.Items {
+		data, _ := item.Data.(map[string]any)
+		byStep[item.StepID] = append(byStep[item.StepID], data)
+	}
+	for _, stepID := range []engine.StepID{"split", "refine"} {
+		items := byStep[stepID]
+		if len(items) != 2 {
+			t.Fatalf("%s の結果数が想定外です: %+v", stepID, items)
+		}
+		meta, _ := items[0]["source_metadata"].(map[string]any)
+		if meta["filename"] != "a.txt" {
+			t.Fatalf("%s の 1 件目に source_metadata が引き継がれていません: %+v", stepID, items[0])
+		}
+		if _, ok := items[1]["source_metadata"]; ok {
+			t.Fatalf("%s の metadata を持たないソースに source_metadata が付与されています: %+v", stepID, items[1])
+		}
+	}
+	merged := byStep["merge"]
+	if len(merged) != 1 {
+		t.Fatalf("merge の結果数が想定外です: %+v", merged)
+	}
+	list, _ := merged[0]["source_metadata"].([]map[string]any)
+	if len(list) != 2 || list[0]["filename"] != "a.txt" || len(list[1]) != 0 {
+		t.Fatalf("reduce 結果の source_metadata が想定外です: %+v", merged[0]["source_metadata"])
+	}
+}
+
+func TestBasicEngine_SensitiveSourcesAreRedacted(t *testing.T) {
+	t.Parallel()
+
+	const secret = "社外秘: token-1234"
+	var mu sync.Mutex
+	var bodies []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(raw))
+		mu.Unlock()
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"choices": []map[string]any{{"message": map[string]any{"content": "受け取った内容: " + secret}}},
+		})
+	}))
+	defer ts.Close()
+	cfg := &engine.EngineConfig{
+		Providers: []engine.ProviderProfile{
+			{ID: engine.ProviderProfileID("secret-openai"), Kind: engine.ProviderOpenAI, BaseURI: ts.URL, APIKey: "test"},
+		},
+	}
+	eng := engine.NewBasicEngineWithConfig(store.NewMemoryStore(), cfg)
+	eng.RegisterPipeline(engine.PipelineDef{
+		Type:    "secret_pipeline",
+		Version: "v1",
+		Steps: []engine.StepDef{{
+			ID:                engine.StepID("read"),
+			Kind:              engine.StepKindLLM,
+			Mode:              engine.StepModeFanOut,
+			ProviderProfileID: engine.ProviderProfileID("secret-openai"),
+			Prompt:            &engine.PromptTemplate{User: "{{range .Sources}}{{.Content}}{{end}}"},
+			ExportPrompt:      true,
+			Export:            true,
+		}},
+	})
+
+	req := sampleJobRequest()
+	req.PipelineType = "secret_pipeline"
+	req.Mode = "sync"
+	req.Input.Sources = []engine.Source{{Kind: engine.SourceKindNote, Label: "secret", Content: secret, Sensitive: true}}
+	job, err := eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ジョブ実行に失敗しました: %v", err)
+	}
+	if job.Status != engine.JobStatusSucceeded {
+		t.Fatalf("ジョブが成功していません: %s %+v", job.Status, job.Error)
+	}
+	mu.Lock()
+	sent := strings.Join(bodies, "\n")
+	mu.Unlock()
+	if !strings.Contains(sent, "token-1234") {
+		t.Fatalf("Provider には機密ソースの内容がそのまま渡されるべきです: %s", sent)
+	}
+
+	stored, err := eng.GetJob(context.Background(), job.ID)
+	if err != nil {
+		t.Fatalf("ジョブ取得に失敗しました: %v", err)
+	}
+	for _, j := range []*engine.Job{job, stored} {
+		encoded, _ := json.Marshal(j)
+		if strings.Contains(string(encoded), "token-1234") {
+			t.Fatalf("保存・返却されるジョブに機密ソースの内容が含まれています: %s", encoded)
+		}
+		src := j.Input.Sources[0]
+		if !src.Sensitive || !src.Redacted || src.Content != logging.RedactedValue {
+			t.Fatalf("機密ソースが伏せ字になっていません: %+v", src)
+		}
+	}
+	data, _ := stored.Result.Items[0].Data.(map[string]any)
+	if data["source"] != logging.RedactedValue || data["prompt"] != logging.RedactedValue {
+		t.Fatalf("結果の source / prompt が伏せ字になっていません: %+v", data)
+	}
+
+	rerun := req
+	rerun.Input = stored.Input
+	if _, err := eng.RunJob(context.Background(), rerun); !errors.Is(err, engine.ErrInvalidSource) {
+		t.Fatalf("伏せ字済みのソースでの再実行は拒否されるべきです: %v", err)
+	}
+}
+
+// trackingStore feeds every persisted job snapshot through a StreamingTracker
+// so tests can observe the events a polling stream would emit.
+type trackingStore struct {
+	*store.MemoryStore
+	mu      sync.Mutex
+	tracker *engine.StreamingTracker
+	events  []engine.StreamingEvent
+}
+
+func (s *trackingStore) UpdateJob(ctx context.Context, job *engine.Job) error {
+	if err := s.MemoryStore.UpdateJob(ctx, job); err != nil {
+		return err
+	}
+	snapshot, err := s.MemoryStore.GetJob(ctx, job.ID)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, s.tracker.Diff(snapshot)...)
+	return nil
+}
+
+func TestBasicEngine_FanOutLimit(t *testing.T) {
+	t.Parallel()
+
+	eng := engine.NewBasicEngineWithConfig(store.NewMemoryStore(), &engine.EngineConfig{MaxFanOut: 2})
+	for pipeline, config := range map[engine.PipelineType]map[string]any{
+		"fan_out_reject":   nil,
+		"fan_out_truncate": {engine.FanOutOverflowConfigKey: "truncate"},
+		"fan_out_step_cap": {engine.MaxFanOutConfigKey: 1, engine.FanOutOverflowConfigKey: "truncate"},
+		"fan_out_raise":    {engine.MaxFanOutConfigKey: 10},
+	} {
+		eng.RegisterPipeline(engine.PipelineDef{
+			Type:    pipeline,
+			Version: "v1",
+			Steps:   []engine.StepDef{{ID: engine.StepID("split"), Kind: engine.StepKindMap, Mode: engine.StepModeFanOut, Config: config, Export: true}},
+		})
+	}
+
+	run := func(pipeline engine.PipelineType) *engine.Job {
+		req := sampleJobRequest()
+		req.PipelineType = pipeline
+		req.Mode = "sync"
+		req.Input.Sources = []engine.Source{
+			{Kind: engine.SourceKindNote, Label: "a", Content: "A"},
+			{Kind: engine.SourceKindNote, Label: "b", Content: "B"},
+			{Kind: engine.SourceKindNote, Label: "c", Content: "C"},
+		}
+		job, err := eng.RunJob(context.Background(), req)
+		if err != nil {
+			t.Fatalf("ジョブ実行に失敗しました: %v", err)
+		}
+		return job
+	}
+
+	for _, pipeline := range []engine.PipelineType{"fan_out_reject", "fan_out_raise"} {
+		job := run(pipeline)
+		if job.Status != engine.JobStatusFailed || job.Error == nil || job.Error.Code != "fan_out_limit_exceeded" {
+			t.Fatalf("%s: 上限を超える fan-out は拒否されるべきです: %s %+v", pipeline, job.Status, job.Error)
+		}
+		details, _ := job.Error.Details.(map[string]any)
+		if details["limit"] != 2 || details["sources"] != 3 {
+			t.Fatalf("%s: エラー詳細に上限とソース数が含まれていません: %+v", pipeline, job.Error.Details)
+		}
+	}
+	for pipeline, want := range map[engine.PipelineType]int{"fan_out_truncate": 2, "fan_out_step_cap": 1} {
+		job := run(pipeline)
+		if job.Status != engine.JobStatusSucceeded || len(job.Result.Items) != want {
+			t.Fatalf("%s: 先頭 %d 件に切り詰められていません: %s %+v", pipeline, want, job.Status, job.Result)
+		}
+	}
+}
+
+func TestBasicEngine_JSONStrictOutputExtractedPostHoc(t *testing.T) {
+	t.Parallel()
+
+	stub := enginetest.NewStubProvider().
+		Respond("fenced", enginetest.StubResponse{Output: "結果です。\n```json\n{\"route\": \"faq\"}\n```"}).
+		Respond("prose", enginetest.StubResponse{Output: "JSON では答えられません"})
+	eng := enginetest.NewEngine(t, stub)
+	for _, id := range []engine.StepID{"fenced", "prose"} {
+		eng.RegisterPipeline(engine.PipelineDef{
+			Type: engine.PipelineType("json_strict_" + id),
+			Steps: []engine.StepDef{
+				{ID: id, Kind: engine.StepKindLLM, ProviderProfileID: enginetest.StubProfileID, OutputFormat: engine.OutputFormatJSONStrict, Export: true},
+			},
+		})
+	}
+	run := func(id engine.StepID) *engine.Job {
+		req := sampleJobRequest()
+		req.PipelineType = engine.PipelineType("json_strict_" + id)
+		req.Mode = "sync"
+		job, err := eng.RunJob(context.Background(), req)
+		if err != nil {
+			t.Fatalf("ジョブ実行に失敗しました: %v", err)
+		}
+		return job
+	}
+
+	job := run("fenced")
+	if job.Status != engine.JobStatusSucceeded {
+		t.Fatalf("コードフェンス内の JSON は抽出されるべきです: %s %+v", job.Status, job.Error)
+	}
+	data, _ := job.Result.Items[0].Data.(map[string]any)
+	if data["text"] != `{"route": "faq"}` {
+		t.Fatalf("抽出された JSON が想定外です: %+v", job.Result.Items[0].Data)
+	}
+
+	job = run("prose")
+	if job.Status != engine.JobStatusFailed || job.Error == nil || job.Error.Code != "invalid_json_output" {
+		t.Fatalf("JSON を含まない出力は invalid_json_output で失敗するべきです: %s %+v", job.Status, job.Error)
+	}
+}
+
+func TestBasicEngine_UnsupportedStepFeature(t *testing.T) {
+	t.Parallel()
+
+	eng := engine.NewBasicEngine(store.NewMemoryStore())
+	for pipeline, step := range map[engine.PipelineType]engine.StepDef{
+		"unsupported_kind": {ID: "loop", Kind: engine.StepKind("loop"), Export: true},
+		"unsupported_mode": {ID: "batch", Kind: engine.StepKindLLM, Mode: engine.StepMode("batch"), Export: true},
+	} {
+		def := engine.PipelineDef{Type: pipeline, Version: "v1", Steps: []engine.StepDef{step}}
+		var unsupported *engine.UnsupportedFeatureError
+		if err := eng.ValidatePipeline(def); !errors.As(err, &unsupported) || unsupported.Step != step.ID {
+			t.Fatalf("%s: ValidatePipeline が未対応の機能を報告していません: %v", pipeline, err)
+		}
+		eng.RegisterPipeline(def)
+	}
+
+	for pipeline, want := range map[engine.PipelineType][2]string{
+		"unsupported_kind": {"step_kind", "loop"},
+		"unsupported_mode": {"step_mode", "batch"},
+	} {
+		req := sampleJobRequest()
+		req.PipelineType = pipeline
+		req.Mode = "sync"
+		job, err := eng.RunJob(context.Background(), req)
+		if err != nil {
+			t.Fatalf("ジョブ実行に失敗しました: %v", err)
+		}
+		if job.Status != engine.JobStatusFailed || job.Error == nil || job.Error.Code != engine.ErrCodeUnsupportedFeature {
+			t.Fatalf("%s: 未対応の機能は unsupported_feature で失敗するべきです: %s %+v", pipeline, job.Status, job.Error)
+		}
+		details, _ := job.Error.Details.(map[string]any)
+		if details["feature"] != want[0] || details["value"] != want[1] || details["supported"] == nil {
+			t.Fatalf("%s: エラー詳細が想定外です: %+v", pipeline, job.Error.Details)
+		}
+	}
+}
+
+func TestBasicEngine_ExportStepsOverride(t *testing.T) {
+	t.Parallel()
+
+	eng := engine.NewBasicEngine(store.NewMemoryStore())
+	eng.RegisterPipeline(engine.PipelineDef{
+		Type:    "export_steps_pipeline",
+		Version: "v1",
+		Steps: []engine.StepDef{
+			{ID: "draft", Kind: engine.StepKindLLM},
+			{ID: "split", Kind: engine.StepKindMap, Mode: engine.StepModeFanOut, DependsOn: []engine.StepID{"draft"}},
+			{ID: "final", Kind: engine.StepKindLLM, DependsOn: []engine.StepID{"split"}, Export: true},
+		},
+	})
+	req := sampleJobRequest()
+	req.PipelineType = "export_steps_pipeline"
+	req.Mode = "sync"
+	req.Input.Sources = append(req.Input.Sources, engine.Source{Kind: engine.SourceKindNote, Label: "追加", Content: "2 件目"})
+
+	stepsOf := func(job *engine.Job) []engine.StepID {
+		var steps []engine.StepID
+		for _, item := range job.Result.Items {
+			steps = append(steps, item.StepID)
+		}
+		return steps
+	}
+	job, err := eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ジョブ実行に失敗しました: %v", err)
+	}
+	if got := stepsOf(job); !reflect.DeepEqual(got, []engine.StepID{"final"}) {
+		t.Fatalf("既定では Export のステップだけが出力されるべきです: %v", got)
+	}
+
+	req.ExportSteps = []engine.StepID{"split"}
+	job, err = eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ジョブ実行に失敗しました: %v", err)
+	}
+	if got := stepsOf(job); !reflect.DeepEqual(got, []engine.StepID{"split", "split", "final"}) {
+		t.Fatalf("export_steps の中間結果が出力されていません: %v", got)
+	}
+	if !reflect.DeepEqual(job.ExportSteps, []engine.StepID{"split"}) {
+		t.Fatalf("ジョブに export_steps が記録されていません: %v", job.ExportSteps)
+	}
+	if pipelines := eng.ListPipelines(); pipelines[0].Steps[1].Export {
+		t.Fatal("export_steps がパイプライン定義を書き換えました")
+	}
+
+	req.ExportSteps = []engine.StepID{"missing"}
+	if _, err := eng.RunJob(context.Background(), req); err == nil || !strings.Contains(err.Error(), "export step missing") {
+		t.Fatalf("未知のステップはエラーになるべきです: %v", err)
+	}
+}
+
+func TestBasicEngine_FanOutExportsItemsPerShard(t *testing.T) {
+	t.Parallel()
+
+	tracked := &trackingStore{MemoryStore: store.NewMemoryStore(), tracker: engine.NewStreamingTracker()}
+	eng := engine.NewBasicEngine(tracked)
+	eng.RegisterPipeline(engine.PipelineDef{
+		Type:    "shard_export_pipeline",
+		Version: "v1",
+		Steps: []engine.StepDef{
+			{ID: engine.StepID("split"), Kind: engine.StepKindMap, Mode: engine.StepModeFanOut, Export: true},
+			{ID: engine.StepID("refine"), Kind: engine.StepKindLLM, Mode: engine.StepModePerItem, DependsOn: []engine.StepID{"split"}, Export: true},
+		},
+	})
+
+	req := sampleJobRequest()
+	req.PipelineType = "shard_export_pipeline"
+	req.Mode = "sync"
+	req.Input.Sources = []engine.Source{
+		{Kind: engine.SourceKindNote, Label: "a", Content: "A"},
+		{Kind: engine.SourceKindNote, Label: "b", Content: "B"},
+		{Kind: engine.SourceKindNote, Label: "c", Content: "C"},
+	}
+	job, err := eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ジョブ実行に失敗しました: %v", err)
+	}
+	if job.Status != engine.JobStatusSucceeded || job.Result == nil || len(job.Result.Items) != 6 {
+		t.Fatalf("ジョブ結果が想定外です: %s %+v", job.Status, job.Result)
+	}
+
+	tracked.mu.Lock()
+	defer tracked.mu.Unlock()
+	for _, stepID := range []engine.StepID{"split", "refine"} {
+		items := 0
+		completed := false
+		for _, ev := range tracked.events {
+			switch ev.Event {
+			case "item_completed":
+				if ev.Data.(engine.ResultItem).StepID != stepID {
+					continue
+				}
+				if completed {
+					t.Fatalf("%s の item_completed が step_completed より後に届きました", stepID)
+				}
+				items++
+			case "step_completed":
+				if ev.Data.(engine.StepExecution).StepID == stepID {
+					completed = true
+				}
+			}
+		}
+		if items != 3 || !completed {
+			t.Fatalf("%s の item_completed がシャードごとに送出されていません: items=%d completed=%v", stepID, items, completed)
+		}
+	}
+}
+
+func TestBasicEngine_ReportsProgress(t *testing.T) {
+	t.Parallel()
+
+	tracked := &trackingStore{MemoryStore: store.NewMemoryStore(), tracker: engine.NewStreamingTracker()}
+	eng := engine.NewBasicEngine(tracked)
+	eng.RegisterPipeline(engine.PipelineDef{
+		Type:    "progress_pipeline",
+		Version: "v1",
+		Steps: []engine.StepDef{
+			{ID: engine.StepID("split"), Kind: engine.StepKindMap, Mode: engine.StepModeFanOut},
+			{ID: engine.StepID("merge"), Kind: engine.StepKindReduce, DependsOn: []engine.StepID{"split"}, Export: true},
+		},
+	})
+
+	req := sampleJobRequest()
+	req.PipelineType = "progress_pipeline"
+	req.Mode = "sync"
+	req.Input.Sources = []engine.Source{
+		{Kind: engine.SourceKindNote, Label: "a", Content: "A"},
+		{Kind: engine.SourceKindNote, Label: "b", Content: "B"},
+	}
+	job, err := eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ジョブ実行に失敗しました: %v", err)
+	}
+	if job.Progress != 1 {
+		t.Fatalf("完了したジョブの progress が 1 ではありません: %v", job.Progress)
+	}
+	if job.StepExecutions[0].ShardsDone != 2 || job.StepExecutions[0].ShardsTotal != 2 {
+		t.Fatalf("シャード進捗が記録されていません: %+v", job.StepExecutions[0])
+	}
+
+	tracked.mu.Lock()
+	var progress []float64
+	for _, ev := range tracked.events {
+		if ev.Event == "job_status" {
+			progress = append(progress, ev.Data.(*engine.Job).Progress)
+		}
+	}
+	tracked.mu.Unlock()
+	want := []float64{0, 0.25, 0.5, 1, 1}
+	if len(progress) != len(want) {
+		t.Fatalf("job_status の progress 推移が想定外です: %v", progress)
+	}
+	for i := range want {
+		if progress[i] != want[i] {
+			t.Fatalf("job_status の progress 推移が想定外です: got=%v want=%v", progress, want)
+		}
+	}
+
+	// 上流を再利用したリランでは skipped ステップを分母から除く。
+	rerun := sampleJobRequest()
+	rerun.PipelineType = "progress_pipeline"
+	rerun.Mode = "sync"
+	rerun.Input = req.Input
+	rerun.ParentJobID = &job.ID
+	rerun.FromStepID = func() *engine.StepID { id := engine.StepID("merge"); return &id }()
+	rerun.ReuseUpstream = true
+	child, err := eng.RunJob(context.Background(), rerun)
+	if err != nil {
+		t.Fatalf("リランに失敗しました: %v", err)
+	}
+	if child.StepExecutions[0].Status != engine.StepExecSkipped || child.Progress != 1 {
+		t.Fatalf("リランの progress が想定外です: %v %+v", child.Progress, child.StepExecutions)
+	}
+}
+
+func waitForJobStatus(t *testing.T, jobStore engine.JobStore, jobID string, expected engine.JobStatus, timeout time.Duration) *engine.Job {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		job, err := jobStore.GetJob(context.Background(), jobID)
+		if err != nil {
+			t.Fatalf("ジョブ %s の取得に失敗しました: %v", jobID, err)
+		}
+
+		if job.Status == expected {
+			return job
+		}
+
+		if job.Status == engine.JobStatusFailed || job.Status == engine.JobStatusCancelled {
+			t.Fatalf("ジョブ %s が予期せぬ最終状態になりました: %s", jobID, job.Status)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("ジョブ %s が制限時間内に %s になりませんでした", jobID, expected)
+	return nil
+}
+
+func sampleJobRequest() engine.JobRequest {
+	return engine.JobRequest{
+		PipelineType: engine.PipelineType("sample_pipeline"),
+		Input: engine.JobInput{
+			Sources: []engine.Source{
+				{
+					Kind:    engine.SourceKindNote,
+					Label:   "仕様メモ",
+					Content: "このサンプルはパイプラインの疎通確認用です。",
+				},
+			},
+			Options: &engine.JobOptions{
+				Language: "ja",
+			},
+		},
+	}
+}
+
+func TestBasicEngine_SeedIgnoredByUnseededProvider(t *testing.T) {
+	t.Parallel()
+
+	eng := engine.NewBasicEngine(store.NewMemoryStore())
+	eng.RegisterPipeline(engine.PipelineDef{
+		Type:    "seed_pipeline",
+		Version: "v1",
+		Steps: []engine.StepDef{
+			{ID: "tool", Kind: engine.StepKindLLM, ProviderProfileID: "default-local", Export: true},
+		},
+	})
+	req := sampleJobRequest()
+	req.PipelineType = "seed_pipeline"
+	req.Mode = "sync"
+	seed := int64(7)
+	req.Seed = &seed
+
+	job, err := eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ジョブ実行に失敗しました: %v", err)
+	}
+	if job.Status != engine.JobStatusSucceeded || job.Result == nil || len(job.Result.Items) != 1 {
+		t.Fatalf("ジョブが成功していません: %s %+v", job.Status, job.Error)
+	}
+	if meta := job.Result.Items[0].ProviderMeta; meta == nil || !meta.SeedIgnored || meta.Seed != nil {
+		t.Fatalf("seed 非対応の Provider では seed_ignored が記録されるべきです: %+v", meta)
+	}
+	if job.Input.Options == nil || job.Input.Options.Seed == nil || *job.Input.Options.Seed != 7 {
+		t.Fatalf("seed がジョブの options に保存されていません: %+v", job.Input.Options)
+	}
+	if req.Input.Options != nil && req.Input.Options.Seed != nil {
+		t.Fatalf("呼び出し元のリクエストを書き換えてはいけません: %+v", req.Input.Options)
+	}
+}
+
+func TestBasicEngine_InputMapping(t *testing.T) {
+	t.Parallel()
+
+	eng := engine.NewBasicEngine(store.NewMemoryStore())
+	def := engine.PipelineDef{
+		Type:    "input_mapping_pipeline",
+		Version: "v1",
+		Steps: []engine.StepDef{
+			{ID: "classify", Kind: engine.StepKindLLM},
+			{
+				ID:           "route",
+				Kind:         engine.StepKindLLM,
+				DependsOn:    []engine.StepID{"classify"},
+				InputMapping: map[string]string{"label": "/classify/0/data/text"},
+				Prompt:       &engine.PromptTemplate{User: `label={{.Vars.label}} step={{jsonPointer .Previous "/classify/0/step_id"}}`},
+				Export:       true,
+				ExportPrompt: true,
+			},
+		},
+	}
+	eng.RegisterPipeline(def)
+	req := sampleJobRequest()
+	req.PipelineType = "input_mapping_pipeline"
+	req.Mode = "sync"
+	req.ExportSteps = []engine.StepID{"classify"}
+
+	job, err := eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ジョブ実行に失敗しました: %v", err)
+	}
+	if job.Status != engine.JobStatusSucceeded || job.Result == nil || len(job.Result.Items) != 2 {
+		t.Fatalf("ジョブが成功していません: %s %+v", job.Status, job.Error)
+	}
+	classified, _ := job.Result.Items[0].Data.(map[string]any)
+	routed, _ := job.Result.Items[1].Data.(map[string]any)
+	want := fmt.Sprintf("label=%v step=classify", classified["text"])
+	if routed["prompt"] != want {
+		t.Fatalf("input_mapping の値がテンプレートに渡されていません: got %q want %q", routed["prompt"], want)
+	}
+
+	def.Steps[1].InputMapping = map[string]string{"label": "/classify/0/data/route"}
+	def.Type = "input_mapping_missing"
+	eng.RegisterPipeline(def)
+	req.PipelineType = "input_mapping_missing"
+	job, err = eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ジョブ実行に失敗しました: %v", err)
+	}
+	if job.Status != engine.JobStatusFailed || job.Error == nil || job.Error.Code != engine.ErrCodeInputMapping {
+		t.Fatalf("解決できないポインタはステップを失敗させるべきです: %s %+v", job.Status, job.Error)
+	}
+
+	def.Steps[1].InputMapping = map[string]string{"label": "/route/0/data/text"}
+	if err := eng.ValidatePipeline(def); err == nil || !strings.Contains(err.Error(), "input mapping label") {
+		t.Fatalf("後続ステップを参照する input_mapping は検証エラーになるべきです: %v", err)
+	}
+}
+
+func TestBasicEngine_CancelledProviderCallNotCountedAsError(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	memoryStore := store.NewMemoryStore()
+	eng := engine.NewBasicEngineWithConfig(memoryStore, &engine.EngineConfig{
+		Providers: []engine.ProviderProfile{
+			{ID: "hang-openai", Kind: engine.ProviderOpenAI, BaseURI: ts.URL, APIKey: "test", DefaultModel: "cancel-probe"},
+		},
+	})
+	eng.RegisterPipeline(engine.PipelineDef{
+		Type:    "cancel_metrics_pipeline",
+		Version: "v1",
+		Steps: []engine.StepDef{
+			{ID: "hang", Kind: engine.StepKindLLM, ProviderProfileID: "hang-openai"},
+		},
+	})
+	req := sampleJobRequest()
+	req.PipelineType = "cancel_metrics_pipeline"
+	modelMetric := func(name string) string {
+		if v := expvar.Get(name).(*expvar.Map).Get("openai/cancel-probe"); v != nil {
+			return v.String()
+		}
+		return ""
+	}
+	cancelledBefore, errorsBefore := modelMetric("provider_model_call_cancelled"), modelMetric("provider_model_call_errors")
+
+	job, err := eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ジョブの起動に失敗しました: %v", err)
+	}
+	waitForJobStatus(t, memoryStore, job.ID, engine.JobStatusRunning, 3*time.Second)
+	time.Sleep(50 * time.Millisecond)
+	if err := eng.CancelJob(context.Background(), job.ID, "途中で中断"); err != nil {
+		t.Fatalf("キャンセルに失敗しました: %v", err)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for modelMetric("provider_model_call_cancelled") == cancelledBefore {
+		if time.Now().After(deadline) {
+			t.Fatalf("中断された呼び出しが provider_model_call_cancelled に記録されていません: %q", modelMetric("provider_model_call_cancelled"))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := modelMetric("provider_model_call_errors"); got != errorsBefore {
+		t.Fatalf("中断された呼び出しを Provider エラーとして数えてはいけません: %q -> %q", errorsBefore, got)
+	}
+	final, _ := memoryStore.GetJob(context.Background(), job.ID)
+	if final.Status != engine.JobStatusCancelled || final.Error == nil || final.Error.Code != "cancelled" {
+		t.Fatalf("ジョブは cancelled で終わるべきです: %s %+v", final.Status, final.Error)
+	}
+}
+
+func TestBasicEngine_RequiredSources(t *testing.T) {
+	t.Parallel()
+
+	eng := engine.NewBasicEngine(store.NewMemoryStore())
+	eng.RegisterPipeline(engine.PipelineDef{
+		Type:            "required_sources_pipeline",
+		Version:         "v1",
+		Steps:           []engine.StepDef{{ID: "summary", Kind: engine.StepKindLLM, Export: true}},
+		RequiredSources: &engine.SourceRequirement{MinCount: 1, Kinds: []engine.SourceKind{engine.SourceKindNote, engine.SourceKindLog}},
+	})
+	run := func(sources ...engine.Source) error {
+		_, err := eng.RunJob(context.Background(), engine.JobRequest{
+			PipelineType: "required_sources_pipeline",
+			Mode:         "sync",
+			Input:        engine.JobInput{Sources: sources},
+		})
+		return err
+	}
+
+	err := run()
+	var reqErr *engine.SourceRequirementError
+	if !errors.Is(err, engine.ErrInvalidInput) || !errors.As(err, &reqErr) || reqErr.Count != 0 {
+		t.Fatalf("ソースなしのジョブは invalid_input で拒否されるべきです: %v", err)
+	}
+	err = run(engine.Source{Kind: engine.SourceKindNote, Content: "memo"}, engine.Source{Kind: engine.SourceKindCode, Content: "main()"})
+	if !errors.As(err, &reqErr) || !reflect.DeepEqual(reqErr.InvalidKinds, []int{1}) {
+		t.Fatalf("許可されていない kind のソースは拒否されるべきです: %v", err)
+	}
+	if err := run(engine.Source{Kind: engine.SourceKindLog, Content: "error"}); err != nil {
+		t.Fatalf("条件を満たすジョブは受け付けるべきです: %v", err)
+	}
+	jobs, _ := eng.ListJobs(context.Background(), engine.JobFilter{})
+	if len(jobs) != 1 {
+		t.Fatalf("拒否されたジョブは作成されるべきではありません: %d", len(jobs))
+	}
+
+	if err := eng.ValidatePipeline(engine.PipelineDef{Type: "bad", RequiredSources: &engine.SourceRequirement{MinCount: -1}}); err == nil {
+		t.Fatal("負の min_count は検証エラーになるべきです")
+	}
+}
+
+func TestBasicEngine_EphemeralProviders(t *testing.T) {
+	t.Parallel()
+
+	stub := enginetest.NewStubProvider()
+	eng := enginetest.NewEngine(t, stub)
+	if err := eng.UpsertProviderProfile(engine.ProviderProfile{ID: "tenant", Kind: enginetest.StubKind, APIKey: "server-key"}); err != nil {
+		t.Fatalf("プロファイル登録に失敗しました: %v", err)
+	}
+	eng.RegisterPipeline(engine.PipelineDef{
+		Type:    "byok_pipeline",
+		Version: "v1",
+		Steps:   []engine.StepDef{{ID: "answer", Kind: engine.StepKindLLM, ProviderProfileID: "tenant", Export: true}},
+	})
+	ctx := context.Background()
+	run := func(profiles ...engine.ProviderProfile) (*engine.Job, error) {
+		return eng.RunJob(ctx, engine.JobRequest{
+			PipelineType:       "byok_pipeline",
+			Mode:               "sync",
+			Input:              engine.JobInput{Sources: []engine.Source{{Kind: engine.SourceKindNote, Content: "memo"}}},
+			EphemeralProviders: profiles,
+		})
+	}
+
+	job, err := run(engine.ProviderProfile{ID: "tenant", Kind: "STUB", APIKey: "sk-tenant-secret"})
+	if err != nil || job.Status != engine.JobStatusSucceeded {
+		t.Fatalf("ジョブが成功していません: %v %+v", err, job)
+	}
+	calls := stub.CallsFor("answer")
+	if len(calls) != 1 || calls[0].Profile.APIKey != "sk-tenant-secret" {
+		t.Fatalf("ephemeral プロファイルが優先されていません: %+v", calls)
+	}
+	if !reflect.DeepEqual(job.EphemeralProfiles, []engine.ProviderProfileID{"tenant"}) {
+		t.Fatalf("ephemeral_profiles が記録されていません: %+v", job.EphemeralProfiles)
+	}
+	stored, _ := eng.GetJob(ctx, job.ID)
+	encoded, _ := json.Marshal(stored)
+	if strings.Contains(string(encoded), "sk-tenant-secret") {
+		t.Fatalf("保存されたジョブに API キーが含まれています: %s", encoded)
+	}
+	if _, err := run(); err != nil {
+		t.Fatalf("ジョブ実行に失敗しました: %v", err)
+	}
+	if calls := stub.CallsFor("answer"); calls[1].Profile.APIKey != "server-key" {
+		t.Fatalf("ephemeral プロファイルが次のジョブに残っています: %+v", calls[1].Profile)
+	}
+
+	for name, profile := range map[string]engine.ProviderProfile{
+		"API キーなし":  {ID: "tenant", Kind: enginetest.StubKind},
+		"未登録の kind": {ID: "tenant", Kind: "anthropic", APIKey: "sk"},
+		"ID なし":     {Kind: enginetest.StubKind, APIKey: "sk"},
+	} {
+		if _, err := run(profile); !errors.Is(err, engine.ErrInvalidInput) {
+			t.Fatalf("%s: invalid_input で拒否されるべきです: %v", name, err)
+		}
+	}
+
+	memoryStore := store.NewMemoryStore()
+	now := time.Now().UTC()
+	orphan := &engine.Job{
+		ID:                "orphan-byok",
+		PipelineType:      "byok_pipeline",
+		Status:            engine.JobStatusRunning,
+		CreatedAt:         now,
+		UpdatedAt:         now,
+		Input:             engine.JobInput{Sources: []engine.Source{{Kind: engine.SourceKindNote, Content: "memo"}}},
+		Mode:              "async",
+		StepExecutions:    []engine.StepExecution{{StepID: "answer", Status: engine.StepExecRunning, StartedAt: &now}},
+		EphemeralProfiles: []engine.ProviderProfileID{"tenant"},
+	}
+	if err := memoryStore.CreateJob(ctx, orphan); err != nil {
+		t.Fatalf("ジョブの登録に失敗しました: %v", err)
+	}
+	restarted := engine.NewBasicEngine(memoryStore)
+	defer restarted.Close()
+	if _, err := restarted.ReconcileJobs(ctx, engine.OrphanPolicyRequeue); err != nil {
+		t.Fatalf("reconcile に失敗しました: %v", err)
+	}
+	if failed, _ := memoryStore.GetJob(ctx, "orphan-byok"); failed.Status != engine.JobStatusFailed || failed.Error.Code != "orphaned" {
+		t.Fatalf("ephemeral プロファイルを使うジョブは再キューされるべきではありません: %+v", failed)
+	}
+}
+
+// midStreamProvider streams its chunks, signals started and then blocks
+// until the call is cancelled.
+type midStreamProvider struct {
+	chunks  []string
+	started chan struct{}
+}
+
+func (p *midStreamProvider) Call(ctx context.Context, req engine.ProviderRequest) (engine.ProviderResponse, error) {
+	<-ctx.Done()
+	return engine.ProviderResponse{}, ctx.Err()
+}
+
+func (p *midStreamProvider) CallStream(ctx context.Context, req engine.ProviderRequest) (<-chan engine.ProviderChunk, func() (engine.ProviderResponse, error), error) {
+	ch := make(chan engine.ProviderChunk, len(p.chunks))
+	for _, c := range p.chunks {
+		ch <- engine.ProviderChunk{Content: c}
+	}
+	close(ch)
+	close(p.started)
+	return ch, func() (engine.ProviderResponse, error) {
+		<-ctx.Done()
+		return engine.ProviderResponse{Meta: &engine.ProviderMeta{Provider: "midstream", Model: "slow-model"}}, ctx.Err()
+	}, nil
+}
+
+func TestBasicEngine_CancelKeepsPartialStreamedOutput(t *testing.T) {
+	t.Parallel()
+
+	memoryStore := store.NewMemoryStore()
+	eng := engine.NewBasicEngine(memoryStore)
+	defer eng.Close()
+	provider := &midStreamProvider{chunks: []string{"長い回答の", "途中まで"}, started: make(chan struct{})}
+	eng.RegisterProviderFactory("midstream", func(engine.ProviderProfile) engine.Provider { return provider })
+	if err := eng.UpsertProviderProfile(engine.ProviderProfile{ID: "midstream", Kind: "midstream"}); err != nil {
+		t.Fatalf("プロファイル登録に失敗しました: %v", err)
+	}
+	eng.RegisterPipeline(engine.PipelineDef{
+		Type:    "partial_pipeline",
+		Version: "v1",
+		Steps: []engine.StepDef{
+			{ID: "collect", Kind: engine.StepKindMap, Mode: engine.StepModeSingle, Export: true},
+			{ID: "answer", Kind: engine.StepKindLLM, Mode: engine.StepModeSingle, DependsOn: []engine.StepID{"collect"}, ProviderProfileID: "midstream", PostProcess: []string{"truncate:2"}, Export: true},
+		},
+	})
+
+	ctx := context.Background()
+	job, err := eng.RunJob(ctx, engine.JobRequest{
+		PipelineType: "partial_pipeline",
+		Input:        engine.JobInput{Sources: []engine.Source{{Kind: engine.SourceKindNote, Content: "memo"}}},
+	})
+	if err != nil {
+		t.Fatalf("ジョブの起動に失敗しました: %v", err)
+	}
+	select {
+	case <-provider.started:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Provider が呼び出されませんでした")
+	}
+	if err := eng.CancelJob(ctx, job.ID, "enough"); err != nil {
+		t.Fatalf("ジョブのキャンセルに失敗しました: %v", err)
+	}
+
+	var cancelled *engine.Job
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		cancelled, _ = memoryStore.GetJob(ctx, job.ID)
+		if cancelled.Result != nil && len(cancelled.Result.Items) == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if cancelled.Status != engine.JobStatusCancelled || cancelled.Result == nil || len(cancelled.Result.Items) != 2 {
+		t.Fatalf("キャンセルされたジョブに部分結果が残っていません: %s %+v", cancelled.Status, cancelled.Result)
+	}
+	if collected := cancelled.Result.Items[0]; collected.StepID != "collect" || collected.Incomplete {
+		t.Fatalf("完了済みステップの結果が想定外です: %+v", collected)
+	}
+	partial := cancelled.Result.Items[1]
+	data, _ := partial.Data.(map[string]any)
+	if partial.StepID != "answer" || !partial.Incomplete || data["text"] != "長い回答の途中まで" {
+		t.Fatalf("部分結果が想定外です: %+v", partial)
+	}
+	if partial.ProviderMeta == nil || partial.ProviderMeta.Model != "slow-model" {
+		t.Fatalf("部分結果に provider_meta が残っていません: %+v", partial.ProviderMeta)
+	}
+	exec := cancelled.StepExecutions[1]
+	if exec.Status != engine.StepExecCancelled || exec.ChunkCount != 2 || len(exec.Chunks) != 2 {
+		t.Fatalf("キャンセルされたステップの chunk が残っていません: %+v", exec)
+	}
+	if cancelled.Error == nil || cancelled.Error.Code != "cancelled" {
+		t.Fatalf("キャンセル情報が上書きされています: %+v", cancelled.Error)
 	}
 }
@@ -0,0 +1,96 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ephemeralProvidersKey is the context key under which a running job carries
+// its JobRequest.EphemeralProviders.
+type ephemeralProvidersKey struct{}
+
+// ephemeralProfiles validates the request-scoped profiles of a job and returns
+// them by ID, normalized like registered profiles. Their APIKey is used as
+// given: the env: prefix is not resolved, so callers cannot read the server's
+// environment, and a profile without a key is rejected so it never falls back
+// to server credentials.
+func (e *BasicEngine) ephemeralProfiles(profiles []ProviderProfile) (map[ProviderProfileID]ProviderProfile, error) {
+	if len(profiles) == 0 {
+		return nil, nil
+	}
+	if e.providers == nil {
+		return nil, fmt.Errorf("%w: ephemeral_providers require a provider registry", ErrInvalidInput)
+	}
+	byID := make(map[ProviderProfileID]ProviderProfile, len(profiles))
+	var errs []error
+	for i, profile := range profiles {
+		if profile.ID == "" {
+			errs = append(errs, fmt.Errorf("ephemeral_providers[%d]: id is required", i))
+			continue
+		}
+		if _, ok := byID[profile.ID]; ok {
+			errs = append(errs, fmt.Errorf("ephemeral_providers[%d]: duplicate id %s", i, profile.ID))
+			continue
+		}
+		if profile.APIKey == "" {
+			errs = append(errs, fmt.Errorf("ephemeral_providers[%d]: api_key is required", i))
+			continue
+		}
+		profile.Kind = NormalizeProviderKind(profile.Kind)
+		if profile.Kind == "" {
+			profile.Kind = ProviderLocal
+		}
+		if err := e.providers.CheckKind(profile.Kind); err != nil {
+			errs = append(errs, fmt.Errorf("ephemeral_providers[%d]: %w", i, err))
+			continue
+		}
+		extra := make(map[string]any, len(profile.Extra))
+		for key, val := range profile.Extra {
+			extra[key] = val
+		}
+		profile.Extra = extra
+		byID[profile.ID] = profile
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidInput, err)
+	}
+	return byID, nil
+}
+
+// profileIDs returns the IDs of profiles in sorted order.
+func profileIDs(profiles map[ProviderProfileID]ProviderProfile) []ProviderProfileID {
+	if len(profiles) == 0 {
+		return nil
+	}
+	ids := make([]ProviderProfileID, 0, len(profiles))
+	for id := range profiles {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// withEphemeralProviders returns ctx carrying profiles for the job run. They
+// live only as long as the run and are never written to the job store.
+func withEphemeralProviders(ctx context.Context, profiles map[ProviderProfileID]ProviderProfile) context.Context {
+	if len(profiles) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, ephemeralProvidersKey{}, profiles)
+}
+
+func ephemeralProviders(ctx context.Context) map[ProviderProfileID]ProviderProfile {
+	profiles, _ := ctx.Value(ephemeralProvidersKey{}).(map[ProviderProfileID]ProviderProfile)
+	return profiles
+}
+
+// resolveStepProvider resolves step's provider profile, preferring the job's
+// ephemeral profiles over the registry.
+func (e *BasicEngine) resolveStepProvider(ctx context.Context, step StepDef) (Provider, ProviderProfile, error) {
+	if profile, ok := ephemeralProviders(ctx)[step.ProviderProfileID]; ok {
+		return e.providers.instantiate(profile, step.ProviderOverride)
+	}
+	return e.providers.Resolve(step)
+}
@@ -0,0 +1,133 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// ExportSink writes an exported ResultItem to external storage and returns
+// a URI referencing it. When a job has a sink, Job.Result keeps only that
+// URI (ResultItem.URI) instead of the item data; checkpoints keep the data.
+type ExportSink interface {
+	Export(ctx context.Context, jobID string, item ResultItem) (string, error)
+}
+
+// RegisterExportSink registers a sink that pipelines and jobs can select by
+// name via PipelineDef.ExportSink or JobRequest.ExportSink.
+func (e *BasicEngine) RegisterExportSink(name string, sink ExportSink) {
+	if name == "" || sink == nil {
+		return
+	}
+	e.toolMu.Lock()
+	defer e.toolMu.Unlock()
+	e.exportSinks[name] = sink
+}
+
+func (e *BasicEngine) exportSink(name string) (ExportSink, bool) {
+	e.toolMu.RLock()
+	defer e.toolMu.RUnlock()
+	sink, ok := e.exportSinks[name]
+	return sink, ok
+}
+
+// resolveExportSink picks the job's sink name (the request wins over the
+// pipeline) and checks that it is registered.
+func (e *BasicEngine) resolveExportSink(pipeline *PipelineDef, req JobRequest) (string, error) {
+	name := req.ExportSink
+	if name == "" {
+		name = pipeline.ExportSink
+	}
+	if name == "" {
+		return "", nil
+	}
+	if _, ok := e.exportSink(name); !ok {
+		return "", fmt.Errorf("export sink %q is not registered", name)
+	}
+	return name, nil
+}
+
+// writeExportSink replaces each item's data with the URI returned by the
+// job's sink. Sinks receive the items with sensitive source content scrubbed,
+// as the JobStore does. Any failure fails the step with export_failed.
+func (e *BasicEngine) writeExportSink(ctx context.Context, job *Job, items []ResultItem) error {
+	sink, ok := e.exportSink(job.ExportSink)
+	if !ok {
+		return &stepError{
+			code:    "export_failed",
+			err:     fmt.Errorf("export sink %q is not registered", job.ExportSink),
+			details: map[string]any{"sink": job.ExportSink},
+		}
+	}
+	scrubbed := secretsOf(job.Input.Sources).scrubItems(items)
+	for i := range items {
+		uri, err := sink.Export(ctx, job.ID, scrubbed[i])
+		if err != nil {
+			return &stepError{
+				code:    "export_failed",
+				err:     fmt.Errorf("export item %s to sink %s: %w", items[i].ID, job.ExportSink, err),
+				details: map[string]any{"sink": job.ExportSink, "item_id": items[i].ID},
+			}
+		}
+		items[i].URI = uri
+		items[i].Data = nil
+	}
+	return nil
+}
+
+// FileExportSink writes each item to <Dir>/<job ID>/<item ID>.<ext>: text
+// and markdown items as their text, binary items as their bytes and
+// anything else as JSON. It returns file:// URIs.
+type FileExportSink struct {
+	Dir string
+}
+
+// NewFileExportSink returns a filesystem sink rooted at dir.
+func NewFileExportSink(dir string) *FileExportSink {
+	return &FileExportSink{Dir: dir}
+}
+
+// Export implements ExportSink.
+func (s *FileExportSink) Export(ctx context.Context, jobID string, item ResultItem) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	body, ext, err := exportBody(item)
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(s.Dir, filepath.Base(jobID))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	path, err := filepath.Abs(filepath.Join(dir, filepath.Base(item.ID)+ext))
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, body, 0o644); err != nil {
+		return "", err
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String(), nil
+}
+
+func exportBody(item ResultItem) ([]byte, string, error) {
+	switch item.ContentType {
+	case ContentBinary:
+		data, _, err := item.BinaryData()
+		return data, ".bin", err
+	case ContentText, ContentMarkdown:
+		if data, ok := item.Data.(map[string]any); ok {
+			if text, ok := data["text"].(string); ok {
+				if item.ContentType == ContentMarkdown {
+					return []byte(text), ".md", nil
+				}
+				return []byte(text), ".txt", nil
+			}
+		}
+	}
+	body, err := json.MarshalIndent(item.Data, "", "  ")
+	return body, ".json", err
+}
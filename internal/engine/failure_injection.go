@@ -0,0 +1,60 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// FailureInjection requests an artificial failure for a job so clients can
+// exercise their retry and error-handling paths. It is ignored unless the
+// binary is built with the failinject build tag.
+type FailureInjection struct {
+	// StepID selects the step that fails. Empty means the first executed step.
+	StepID StepID `json:"step_id,omitempty"`
+	// Code is reported as the step and job error code (default "injected_failure").
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+	// TimeoutMs makes the step hang for the given duration and then fail with
+	// the "timeout" code instead of failing immediately.
+	TimeoutMs int `json:"timeout_ms,omitempty"`
+}
+
+// FailureInjectionEnabled reports whether JobOptions.InjectFailure is honoured
+// by this build.
+func FailureInjectionEnabled() bool {
+	return failureInjectionEnabled
+}
+
+func injectedFailure(ctx context.Context, job *Job, step StepDef) error {
+	if !failureInjectionEnabled || job == nil || job.Input.Options == nil {
+		return nil
+	}
+	inj := job.Input.Options.InjectFailure
+	if inj == nil {
+		return nil
+	}
+	if inj.StepID != "" && inj.StepID != step.ID {
+		return nil
+	}
+	if inj.TimeoutMs > 0 {
+		timer := time.NewTimer(time.Duration(inj.TimeoutMs) * time.Millisecond)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+		return &stepError{code: "timeout", err: fmt.Errorf("step %s timed out after %dms (injected)", step.ID, inj.TimeoutMs)}
+	}
+	code := inj.Code
+	if code == "" {
+		code = "injected_failure"
+	}
+	message := inj.Message
+	if message == "" {
+		message = fmt.Sprintf("injected failure for step %s", step.ID)
+	}
+	return &stepError{code: code, err: errors.New(message)}
+}
@@ -0,0 +1,6 @@
+//go:build !failinject
+
+package engine
+
+// failureInjectionEnabled is false in production builds so InjectFailure is a no-op.
+const failureInjectionEnabled = false
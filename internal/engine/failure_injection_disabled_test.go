@@ -0,0 +1,32 @@
+//go:build !failinject
+
+package engine_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/example/pipeline-engine/internal/engine"
+	"github.com/example/pipeline-engine/internal/store"
+)
+
+func TestBasicEngine_InjectFailureIgnoredWithoutBuildTag(t *testing.T) {
+	t.Parallel()
+
+	if engine.FailureInjectionEnabled() {
+		t.Fatal("failinject タグなしのビルドで failure injection が有効になっています")
+	}
+
+	eng := engine.NewBasicEngine(store.NewMemoryStore())
+	req := sampleJobRequest()
+	req.Mode = "sync"
+	req.Input.Options.InjectFailure = &engine.FailureInjection{Code: "boom"}
+
+	job, err := eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ジョブの起動に失敗しました: %v", err)
+	}
+	if job.Status != engine.JobStatusSucceeded {
+		t.Fatalf("本番ビルドで注入が無視されていません: %s %+v", job.Status, job.Error)
+	}
+}
@@ -0,0 +1,6 @@
+//go:build failinject
+
+package engine
+
+// failureInjectionEnabled is only true for test/staging builds (-tags failinject).
+const failureInjectionEnabled = true
@@ -0,0 +1,67 @@
+//go:build failinject
+
+package engine_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/example/pipeline-engine/internal/engine"
+	"github.com/example/pipeline-engine/internal/store"
+)
+
+func TestBasicEngine_InjectFailureFailsNamedStep(t *testing.T) {
+	t.Parallel()
+
+	memoryStore := store.NewMemoryStore()
+	eng := engine.NewBasicEngine(memoryStore)
+	eng.RegisterPipeline(engine.PipelineDef{
+		Type:    "inject_pipeline",
+		Version: "v1",
+		Steps: []engine.StepDef{
+			{ID: engine.StepID("first"), Kind: engine.StepKindLLM, Export: true},
+			{ID: engine.StepID("second"), Kind: engine.StepKindLLM, DependsOn: []engine.StepID{"first"}, Export: true},
+		},
+	})
+
+	req := sampleJobRequest()
+	req.PipelineType = "inject_pipeline"
+	req.Mode = "sync"
+	req.Input.Options.InjectFailure = &engine.FailureInjection{StepID: "second", Code: "rate_limited", Message: "boom"}
+
+	job, err := eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ジョブの起動に失敗しました: %v", err)
+	}
+	if job.Status != engine.JobStatusFailed {
+		t.Fatalf("ジョブが failed になっていません: %s", job.Status)
+	}
+	if job.Error == nil || job.Error.Code != "rate_limited" || job.Error.Message != "boom" {
+		t.Fatalf("注入したエラーが反映されていません: %+v", job.Error)
+	}
+	if job.StepExecutions[0].Status != engine.StepExecSuccess {
+		t.Fatalf("対象外のステップが success ではありません: %+v", job.StepExecutions[0])
+	}
+	if job.StepExecutions[1].Status != engine.StepExecFailed {
+		t.Fatalf("対象ステップが failed ではありません: %+v", job.StepExecutions[1])
+	}
+}
+
+func TestBasicEngine_InjectFailureTimeout(t *testing.T) {
+	t.Parallel()
+
+	memoryStore := store.NewMemoryStore()
+	eng := engine.NewBasicEngine(memoryStore)
+
+	req := sampleJobRequest()
+	req.Mode = "sync"
+	req.Input.Options.InjectFailure = &engine.FailureInjection{TimeoutMs: 20}
+
+	job, err := eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ジョブの起動に失敗しました: %v", err)
+	}
+	if job.Status != engine.JobStatusFailed || job.Error == nil || job.Error.Code != "timeout" {
+		t.Fatalf("timeout として失敗していません: %s %+v", job.Status, job.Error)
+	}
+}
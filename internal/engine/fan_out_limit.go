@@ -0,0 +1,55 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/example/pipeline-engine/pkg/logging"
+)
+
+const (
+	// MaxFanOutConfigKey caps how many sources a fan-out step calls the
+	// provider for. It can only lower EngineConfig.MaxFanOut.
+	MaxFanOutConfigKey = "max_fan_out"
+	// FanOutOverflowConfigKey selects the FanOutOverflowPolicy of a step.
+	FanOutOverflowConfigKey = "fan_out_overflow"
+)
+
+// FanOutOverflowPolicy controls a fan-out step whose sources exceed its
+// limit. Rejecting is the default so a job never silently loses input;
+// truncate processes only the first sources up to the limit.
+type FanOutOverflowPolicy string
+
+const (
+	FanOutOverflowReject   FanOutOverflowPolicy = "reject"
+	FanOutOverflowTruncate FanOutOverflowPolicy = "truncate"
+)
+
+// fanOutLimit is the smaller of the engine-wide and the step's positive
+// limits, or 0 when neither is set.
+func (e *BasicEngine) fanOutLimit(step StepDef) int {
+	limit := e.maxFanOut
+	if stepLimit, ok := step.ConfigInt(MaxFanOutConfigKey); ok && stepLimit > 0 && (limit <= 0 || stepLimit < limit) {
+		limit = stepLimit
+	}
+	return limit
+}
+
+// limitFanOut applies the step's fan-out limit to sources, failing with
+// fan_out_limit_exceeded or truncating according to fan_out_overflow.
+func (e *BasicEngine) limitFanOut(step StepDef, sources []Source) ([]Source, error) {
+	limit := e.fanOutLimit(step)
+	if limit <= 0 || len(sources) <= limit {
+		return sources, nil
+	}
+	raw, _ := step.ConfigString(FanOutOverflowConfigKey)
+	if FanOutOverflowPolicy(strings.ToLower(raw)) == FanOutOverflowTruncate {
+		logging.Warnf("step %s: fan-out truncated from %d to %d sources", step.ID, len(sources), limit)
+		return sources[:limit], nil
+	}
+	return nil, &stepError{
+		code:    "fan_out_limit_exceeded",
+		err:     fmt.Errorf("step %s: %d sources exceed the fan-out limit of %d", step.ID, len(sources), limit),
+		details: map[string]any{"limit": limit, "sources": len(sources)},
+	}
+}
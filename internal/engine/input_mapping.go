@@ -0,0 +1,172 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// ErrCodeInputMapping is the job error code of a step whose InputMapping
+// pointer does not resolve against the upstream outputs.
+const ErrCodeInputMapping = "input_mapping_unresolved"
+
+// PromptFuncs returns the functions available to prompt templates:
+//
+//	jsonPointer  resolves an RFC 6901 pointer against a value, e.g.
+//	             {{jsonPointer .Previous "/classify/0/data/route"}}; it yields
+//	             nil when the pointer does not resolve.
+//	byPriority   orders sources by descending Priority, keeping the input
+//	             order among equals, e.g. {{range byPriority .Sources}}.
+//	topSources   keeps the n sources of highest priority in that order, e.g.
+//	             {{range topSources 3 .Sources}}; a negative n yields none.
+func PromptFuncs() template.FuncMap {
+	return template.FuncMap{
+		"jsonPointer": func(doc any, pointer string) any {
+			value, err := resolveJSONPointer(doc, pointer)
+			if err != nil {
+				return nil
+			}
+			return value
+		},
+		"byPriority": sortSourcesByPriority,
+		"topSources": topSources,
+	}
+}
+
+// mappingStep returns the step a StepDef.InputMapping pointer reads from: its
+// first reference token.
+func mappingStep(pointer string) StepID {
+	tokens, err := pointerTokens(pointer)
+	if err != nil || len(tokens) == 0 {
+		return ""
+	}
+	return StepID(tokens[0])
+}
+
+// resolveInputMapping resolves the step's InputMapping pointers against the
+// upstream outputs, keyed by step ID like .Previous. Pointers that do not
+// resolve are reported in the error and left out of the result.
+func resolveInputMapping(step StepDef, outputs map[StepID][]ResultItem) (map[string]any, error) {
+	if len(step.InputMapping) == 0 {
+		return nil, nil
+	}
+	doc, err := pointerDocument(outputs)
+	if err != nil {
+		return nil, err
+	}
+	vars := make(map[string]any, len(step.InputMapping))
+	var errs []error
+	for _, name := range mappingNames(step) {
+		pointer := step.InputMapping[name]
+		value, err := resolveJSONPointer(doc, pointer)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("input mapping %s (%s): %w", name, pointer, err))
+			continue
+		}
+		vars[name] = value
+	}
+	return vars, errors.Join(errs...)
+}
+
+// mappingNames returns the InputMapping variable names in sorted order.
+func mappingNames(step StepDef) []string {
+	names := make([]string, 0, len(step.InputMapping))
+	for name := range step.InputMapping {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// pointerDocument converts the step outputs to their JSON form, so pointers
+// address result items by their JSON field names (e.g. "data").
+func pointerDocument(outputs map[StepID][]ResultItem) (any, error) {
+	encoded, err := json.Marshal(outputs)
+	if err != nil {
+		return nil, err
+	}
+	var doc any
+	if err := json.Unmarshal(encoded, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// resolveJSONPointer walks doc along pointer. Values other than maps and
+// slices of the JSON data model are first converted through JSON.
+func resolveJSONPointer(doc any, pointer string) (any, error) {
+	tokens, err := pointerTokens(pointer)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) > 0 {
+		switch doc.(type) {
+		case map[string]any, []any:
+		default:
+			encoded, err := json.Marshal(doc)
+			if err != nil {
+				return nil, err
+			}
+			if err := json.Unmarshal(encoded, &doc); err != nil {
+				return nil, err
+			}
+		}
+	}
+	current := doc
+	for i, token := range tokens {
+		at := "/" + strings.Join(tokens[:i+1], "/")
+		switch node := current.(type) {
+		case map[string]any:
+			value, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("%s not found", at)
+			}
+			current = value
+		case []any:
+			idx, err := strconv.Atoi(token)
+			if err != nil || idx < 0 || idx >= len(node) || (len(token) > 1 && token[0] == '0') {
+				return nil, fmt.Errorf("%s: index out of range of %d items", at, len(node))
+			}
+			current = node[idx]
+		default:
+			return nil, fmt.Errorf("%s: cannot index into %T", at, current)
+		}
+	}
+	return current, nil
+}
+
+// pointerTokens splits an RFC 6901 pointer into unescaped reference tokens.
+func pointerTokens(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("json pointer %q must start with /", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// validateInputMapping checks that every InputMapping entry is a well-formed
+// pointer into a step that runs earlier.
+func validateInputMapping(step StepDef, earlier map[StepID]bool) error {
+	var errs []error
+	for _, name := range mappingNames(step) {
+		pointer := step.InputMapping[name]
+		if _, err := pointerTokens(pointer); err != nil {
+			errs = append(errs, fmt.Errorf("step %s: input mapping %s: %w", step.ID, name, err))
+			continue
+		}
+		if from := mappingStep(pointer); !earlier[from] {
+			errs = append(errs, fmt.Errorf("step %s: input mapping %s references step %q, which does not run earlier", step.ID, name, from))
+		}
+	}
+	return errors.Join(errs...)
+}
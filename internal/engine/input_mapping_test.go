@@ -0,0 +1,47 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResolveJSONPointer(t *testing.T) {
+	doc := map[string]any{
+		"classify": []any{map[string]any{"data": map[string]any{"route": "billing", "a/b": 1, "m~n": 2}}},
+	}
+	cases := []struct {
+		pointer string
+		want    any
+		wantErr bool
+	}{
+		{pointer: "", want: doc},
+		{pointer: "/classify/0/data/route", want: "billing"},
+		{pointer: "/classify/0/data/a~1b", want: 1},
+		{pointer: "/classify/0/data/m~0n", want: 2},
+		{pointer: "/classify/1", wantErr: true},
+		{pointer: "/classify/00", wantErr: true},
+		{pointer: "/classify/0/data/route/x", wantErr: true},
+		{pointer: "/missing", wantErr: true},
+		{pointer: "classify", wantErr: true},
+	}
+	for _, tc := range cases {
+		got, err := resolveJSONPointer(doc, tc.pointer)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%q: expected error, got %v", tc.pointer, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: got %v, %v; want %v", tc.pointer, got, err, tc.want)
+		}
+	}
+}
+
+func TestResolveJSONPointerConvertsStructs(t *testing.T) {
+	outputs := map[string][]ResultItem{"classify": {{StepID: "classify", Data: map[string]any{"route": "sales"}}}}
+	got, err := resolveJSONPointer(outputs, "/classify/0/step_id")
+	if err != nil || got != "classify" {
+		t.Fatalf("got %v, %v", got, err)
+	}
+}
@@ -0,0 +1,93 @@
+package engine
+
+import (
+	"context"
+	"time"
+
+	"github.com/example/pipeline-engine/pkg/logging"
+	"github.com/example/pipeline-engine/pkg/metrics"
+)
+
+const defaultJobSweepInterval = time.Minute
+
+// JobDeleter is an optional extension a JobStore can implement so the job
+// sweeper can evict expired jobs.
+type JobDeleter interface {
+	DeleteJob(ctx context.Context, id string) error
+}
+
+// startJobSweeper launches a goroutine that evicts terminal jobs whose
+// UpdatedAt is older than ttl. It is stopped by Close.
+func (e *BasicEngine) startJobSweeper(ttl, interval time.Duration) {
+	if _, ok := e.store.(JobDeleter); !ok {
+		logging.Warnf("job TTL configured but the job store cannot delete jobs; expiry disabled")
+		return
+	}
+	if interval <= 0 {
+		interval = defaultJobSweepInterval
+	}
+	e.sweepStop = make(chan struct{})
+	e.sweepDone = make(chan struct{})
+	go func() {
+		defer close(e.sweepDone)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-e.sweepStop:
+				return
+			case now := <-ticker.C:
+				e.sweepExpiredJobs(now, ttl)
+			}
+		}
+	}()
+}
+
+// sweepExpiredJobs deletes terminal jobs last updated before now-ttl together
+// with their checkpoints and returns how many were evicted.
+func (e *BasicEngine) sweepExpiredJobs(now time.Time, ttl time.Duration) int {
+	deleter, ok := e.store.(JobDeleter)
+	if !ok {
+		return 0
+	}
+	ctx := context.Background()
+	jobs, err := e.store.ListJobs(ctx)
+	if err != nil {
+		logging.Warnf("job sweeper failed to list jobs: %v", err)
+		return 0
+	}
+	evicted := 0
+	for _, job := range jobs {
+		if !isTerminal(job.Status) || now.Sub(job.UpdatedAt) < ttl {
+			continue
+		}
+		if err := deleter.DeleteJob(ctx, job.ID); err != nil {
+			logging.Warnf("job sweeper failed to delete job %s: %v", job.ID, err)
+			continue
+		}
+		e.clearCheckpoints(job.ID)
+		e.removeJobPipeline(job.ID)
+		e.removePipelineSnapshot(job.ID)
+		e.removeJobSources(job.ID)
+		evicted++
+	}
+	if evicted > 0 {
+		metrics.ObserveJobsEvicted(evicted)
+		logging.Debugf("job sweeper evicted %d job(s)", evicted)
+	}
+	return evicted
+}
+
+// Close stops background work: webhook deliveries, which are abandoned
+// mid-retry, and the job sweeper. It is safe to call more than once and on
+// engines without a sweeper.
+func (e *BasicEngine) Close() {
+	e.closeOnce.Do(func() {
+		e.stopWebhooks()
+		if e.sweepStop == nil {
+			return
+		}
+		close(e.sweepStop)
+		<-e.sweepDone
+	})
+}
@@ -0,0 +1,80 @@
+package engine
+
+import "context"
+
+// JobFilter narrows ListJobs. Zero-valued fields match every job.
+type JobFilter struct {
+	ParentJobID string
+}
+
+// Matches reports whether job satisfies the filter.
+func (f JobFilter) Matches(job *Job) bool {
+	if job == nil {
+		return false
+	}
+	if f.ParentJobID != "" && (job.ParentJobID == nil || *job.ParentJobID != f.ParentJobID) {
+		return false
+	}
+	return true
+}
+
+// JobQuerier is an optional extension a JobStore can implement to filter jobs
+// itself instead of having the engine scan ListJobs.
+type JobQuerier interface {
+	QueryJobs(ctx context.Context, filter JobFilter) ([]*Job, error)
+}
+
+// JobLineage describes where a job sits in a rerun tree.
+type JobLineage struct {
+	Job *Job `json:"job"`
+	// Ancestors lists the parent chain from the root job down to the direct
+	// parent. The chain stops early when an ancestor is no longer stored.
+	Ancestors []*Job `json:"ancestors"`
+	// Children lists jobs rerun directly from Job.
+	Children []*Job `json:"children"`
+}
+
+// ListJobs returns stored jobs matching filter in store order.
+func (e *BasicEngine) ListJobs(ctx context.Context, filter JobFilter) ([]*Job, error) {
+	if querier, ok := e.store.(JobQuerier); ok {
+		return querier.QueryJobs(ctx, filter)
+	}
+	jobs, err := e.store.ListJobs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	matched := make([]*Job, 0, len(jobs))
+	for _, job := range jobs {
+		if filter.Matches(job) {
+			matched = append(matched, job)
+		}
+	}
+	return matched, nil
+}
+
+// JobLineage returns the ancestor chain and immediate children of a job.
+func (e *BasicEngine) JobLineage(ctx context.Context, jobID string) (*JobLineage, error) {
+	job, err := e.store.GetJob(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+	var ancestors []*Job
+	seen := map[string]bool{job.ID: true}
+	for parentID := job.ParentJobID; parentID != nil && !seen[*parentID]; {
+		parent, err := e.store.GetJob(ctx, *parentID)
+		if err != nil {
+			break
+		}
+		seen[parent.ID] = true
+		ancestors = append([]*Job{parent}, ancestors...)
+		parentID = parent.ParentJobID
+	}
+	children, err := e.ListJobs(ctx, JobFilter{ParentJobID: job.ID})
+	if err != nil {
+		return nil, err
+	}
+	if ancestors == nil {
+		ancestors = []*Job{}
+	}
+	return &JobLineage{Job: job, Ancestors: ancestors, Children: children}, nil
+}
@@ -0,0 +1,313 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// Severity ranks a lint Finding.
+type Severity string
+
+const (
+	// SeverityError marks definitions that fail or misbehave at run time.
+	SeverityError Severity = "error"
+	// SeverityWarning marks likely mistakes that still run.
+	SeverityWarning Severity = "warning"
+	// SeverityInfo marks behavior worth knowing about, such as stub output.
+	SeverityInfo Severity = "info"
+)
+
+// Finding is one problem reported by Lint. Step is empty for findings about
+// the pipeline as a whole.
+type Finding struct {
+	Severity Severity `json:"severity"`
+	Code     string   `json:"code"`
+	Step     StepID   `json:"step,omitempty"`
+	Message  string   `json:"message"`
+}
+
+// Lint reports likely mistakes in def without registering it: duplicate or
+// out-of-order step references, prompt templates that do not parse or read
+// .Previous, .Inputs or .Vars entries that do not exist, intermediate steps
+// whose output nobody reads, and first steps that ignore the job sources.
+// It needs no engine; BasicEngine.LintPipeline adds the provider profile
+// checks of ValidatePipeline.
+func Lint(def PipelineDef) []Finding {
+	var findings []Finding
+	add := func(severity Severity, code string, step StepID, format string, args ...any) {
+		findings = append(findings, Finding{Severity: severity, Code: code, Step: step, Message: fmt.Sprintf(format, args...)})
+	}
+	if def.Type == "" {
+		add(SeverityError, "missing_type", "", "pipeline type is required")
+	}
+	if len(def.Steps) == 0 {
+		add(SeverityError, "no_steps", "", "pipeline has no steps")
+		return findings
+	}
+
+	index := make(map[StepID]int, len(def.Steps))
+	for i, step := range def.Steps {
+		if _, ok := index[step.ID]; ok {
+			add(SeverityError, "duplicate_step_id", step.ID, "step id %s is used more than once", step.ID)
+			continue
+		}
+		index[step.ID] = i
+	}
+	// earlier reports whether id names a step that runs before position i.
+	earlier := func(id StepID, i int) bool {
+		at, ok := index[id]
+		return ok && at < i
+	}
+	describe := func(id StepID) string {
+		if _, ok := index[id]; ok {
+			return fmt.Sprintf("step %s, which runs later", id)
+		}
+		return fmt.Sprintf("step %s, which does not exist", id)
+	}
+
+	consumed := map[StepID]bool{}
+	exported := false
+	for i, step := range def.Steps {
+		exported = exported || step.Export
+		upstream := len(step.InputFrom) > 0 || len(step.InputMapping) > 0
+		for _, dep := range step.DependsOn {
+			consumed[dep] = true
+			upstream = true
+			if !earlier(dep, i) {
+				add(SeverityError, "unknown_dependency", step.ID, "depends on %s", describe(dep))
+			}
+		}
+		for _, binding := range step.InputFrom {
+			consumed[binding.Step] = true
+		}
+		for _, pointer := range step.InputMapping {
+			consumed[mappingStep(pointer)] = true
+		}
+
+		refs, err := promptReferences(step.Prompt)
+		if err != nil {
+			add(SeverityError, "template_error", step.ID, "prompt template does not parse: %v", err)
+		}
+		for _, id := range refs.previous {
+			consumed[id] = true
+			upstream = true
+			if !earlier(id, i) {
+				add(SeverityError, "unknown_previous_step", step.ID, "prompt reads .Previous of %s", describe(id))
+			}
+		}
+		inputs := make(map[string]bool, len(step.InputFrom))
+		for _, binding := range step.InputFrom {
+			inputs[binding.Name] = true
+		}
+		for _, name := range refs.inputs {
+			if !inputs[name] {
+				add(SeverityError, "unknown_input", step.ID, "prompt reads .Inputs.%s, which input_from does not bind", name)
+			}
+		}
+		for _, name := range refs.vars {
+			if _, ok := step.InputMapping[name]; !ok {
+				add(SeverityError, "unknown_var", step.ID, "prompt reads .Vars.%s, which input_mapping does not define", name)
+			}
+		}
+		if step.Prompt != nil && err == nil && !upstream && !refs.sources && step.Kind != StepKindRetrieve {
+			add(SeverityWarning, "ignores_sources", step.ID, "prompt reads neither .Sources nor any upstream output")
+		}
+		if step.ProviderProfileID == "" && def.DefaultProviderProfileID == "" && (step.Kind == StepKindLLM || step.Kind == StepKindImage) {
+			add(SeverityInfo, "no_provider", step.ID, "step has no provider profile and produces stub output")
+		}
+	}
+	for _, step := range def.Steps {
+		if !step.Export && !consumed[step.ID] {
+			add(SeverityWarning, "unconsumed_step", step.ID, "step is not exported and no later step reads its output")
+		}
+	}
+	if !exported {
+		add(SeverityWarning, "no_exported_steps", "", "no step is exported, so jobs have an empty result")
+	}
+	return findings
+}
+
+// LintPipeline runs Lint and adds the problems ValidatePipeline finds in def
+// against this engine's providers and post-processors, such as profiles that
+// are not registered. Unlike RegisterPipeline it never registers def.
+func (e *BasicEngine) LintPipeline(def PipelineDef) []Finding {
+	findings := Lint(def)
+	for _, err := range flattenErrors(e.ValidatePipeline(def)) {
+		code := "invalid_definition"
+		var unresolved *ProviderUnresolvedError
+		var unsupported *UnsupportedFeatureError
+		switch {
+		case errors.As(err, &unresolved):
+			code = "unknown_profile"
+		case errors.As(err, &unsupported):
+			code = ErrCodeUnsupportedFeature
+		}
+		msg := err.Error()
+		var step StepID
+		for _, s := range def.Steps {
+			if rest, ok := strings.CutPrefix(msg, "step "+string(s.ID)+": "); ok {
+				step, msg = s.ID, rest
+				break
+			}
+		}
+		findings = append(findings, Finding{Severity: SeverityError, Code: code, Step: step, Message: msg})
+	}
+	return findings
+}
+
+// flattenErrors splits err, and errors joined inside it, into its leaves.
+func flattenErrors(err error) []error {
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		if err == nil {
+			return nil
+		}
+		return []error{err}
+	}
+	var errs []error
+	for _, inner := range joined.Unwrap() {
+		errs = append(errs, flattenErrors(inner)...)
+	}
+	return errs
+}
+
+// promptRefs lists what a step's prompt templates read from the prompt
+// context.
+type promptRefs struct {
+	sources  bool
+	previous []StepID
+	inputs   []string
+	vars     []string
+}
+
+// promptReferences collects the .Sources, .Previous, .Inputs and .Vars
+// entries the system, user and message templates of prompt read, either as
+// fields (.Previous.summarize), through index (index .Previous "summarize")
+// or through jsonPointer (jsonPointer .Previous "/summarize/0").
+func promptReferences(prompt *PromptTemplate) (promptRefs, error) {
+	var refs promptRefs
+	if prompt == nil {
+		return refs, nil
+	}
+	texts := []string{prompt.System, prompt.User}
+	for _, msg := range promptMessagesFromMeta(prompt.Meta) {
+		texts = append(texts, msg.Content)
+	}
+	var errs []error
+	for _, text := range texts {
+		if text == "" {
+			continue
+		}
+		tpl, err := template.New("prompt").Funcs(PromptFuncs()).Parse(text)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		refs.walk(tpl.Tree.Root)
+	}
+	return refs, errors.Join(errs...)
+}
+
+func (r *promptRefs) walk(node parse.Node) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			r.walk(child)
+		}
+	case *parse.ActionNode:
+		r.walk(n.Pipe)
+	case *parse.IfNode:
+		r.walkBranch(&n.BranchNode)
+	case *parse.RangeNode:
+		r.walkBranch(&n.BranchNode)
+	case *parse.WithNode:
+		r.walkBranch(&n.BranchNode)
+	case *parse.TemplateNode:
+		r.walk(n.Pipe)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			r.walk(cmd)
+		}
+	case *parse.CommandNode:
+		r.command(n.Args)
+		for _, arg := range n.Args {
+			r.walk(arg)
+		}
+	case *parse.ChainNode:
+		r.walk(n.Node)
+	case *parse.FieldNode:
+		r.field(n.Ident, "")
+	}
+}
+
+func (r *promptRefs) walkBranch(n *parse.BranchNode) {
+	r.walk(n.Pipe)
+	r.walk(n.List)
+	r.walk(n.ElseList)
+}
+
+// command records the key of index .Previous "id" style lookups and the step
+// of jsonPointer .Previous "/id/..." calls.
+func (r *promptRefs) command(args []parse.Node) {
+	if len(args) < 3 {
+		return
+	}
+	ident, ok := args[0].(*parse.IdentifierNode)
+	if !ok {
+		return
+	}
+	field, ok := args[1].(*parse.FieldNode)
+	if !ok || len(field.Ident) != 1 {
+		return
+	}
+	key, ok := args[2].(*parse.StringNode)
+	if !ok {
+		return
+	}
+	switch ident.Ident {
+	case "index":
+		r.field(field.Ident, key.Text)
+	case "jsonPointer":
+		if field.Ident[0] == "Previous" {
+			if step := mappingStep(key.Text); step != "" {
+				r.previous = append(r.previous, step)
+			}
+		}
+	}
+}
+
+// field records a context field access; key is the map key when it is
+// given separately, as with index.
+func (r *promptRefs) field(ident []string, key string) {
+	if len(ident) == 0 {
+		return
+	}
+	if key == "" && len(ident) > 1 {
+		key = ident[1]
+	}
+	switch ident[0] {
+	case "Sources":
+		r.sources = true
+	case "Previous":
+		if key != "" {
+			r.previous = append(r.previous, StepID(key))
+		}
+	case "Inputs":
+		if key != "" {
+			r.inputs = append(r.inputs, key)
+		}
+	case "Vars":
+		if key != "" {
+			r.vars = append(r.vars, key)
+		}
+	}
+}
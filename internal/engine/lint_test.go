@@ -0,0 +1,144 @@
+package engine_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/example/pipeline-engine/internal/engine"
+	"github.com/example/pipeline-engine/internal/store"
+)
+
+// findingCodes returns the codes of findings keyed by step, with "" for
+// pipeline-level findings.
+func findingCodes(findings []engine.Finding) map[engine.StepID][]string {
+	codes := map[engine.StepID][]string{}
+	for _, f := range findings {
+		codes[f.Step] = append(codes[f.Step], f.Code)
+	}
+	return codes
+}
+
+func hasCode(codes []string, code string) bool {
+	for _, c := range codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+func TestLintReportsTemplateAndReferenceProblems(t *testing.T) {
+	def := engine.PipelineDef{
+		Type:                     "lint.demo",
+		DefaultProviderProfileID: "default",
+		Steps: []engine.StepDef{
+			{ID: "classify", Kind: engine.StepKindLLM, Prompt: &engine.PromptTemplate{User: "分類してください"}},
+			{ID: "orphan", Kind: engine.StepKindLLM, Prompt: &engine.PromptTemplate{User: "{{range .Sources}}{{.Content}}{{end}}"}},
+			{
+				ID:           "draft",
+				Kind:         engine.StepKindLLM,
+				DependsOn:    []engine.StepID{"classify", "final"},
+				InputMapping: map[string]string{"route": "/classify/0/data/route"},
+				Prompt: &engine.PromptTemplate{
+					System: "{{.Vars.route}} {{.Vars.tone}}",
+					User:   `{{index .Previous "classify"}} {{jsonPointer .Previous "/missing/0"}} {{.Inputs.notes}}`,
+				},
+			},
+			{ID: "broken", Kind: engine.StepKindLLM, DependsOn: []engine.StepID{"draft"}, Prompt: &engine.PromptTemplate{User: "{{.Previous.draft"}},
+			{ID: "final", Kind: engine.StepKindLLM, Export: true, Prompt: &engine.PromptTemplate{User: "{{with .Previous.broken}}{{.}}{{end}}"}},
+		},
+	}
+	codes := findingCodes(engine.Lint(def))
+
+	if !hasCode(codes["classify"], "ignores_sources") {
+		t.Fatalf("classify に ignores_sources が報告されていません: %v", codes["classify"])
+	}
+	if !hasCode(codes["orphan"], "unconsumed_step") || hasCode(codes["orphan"], "ignores_sources") {
+		t.Fatalf("orphan の指摘が想定外です: %v", codes["orphan"])
+	}
+	for _, want := range []string{"unknown_dependency", "unknown_var", "unknown_previous_step", "unknown_input"} {
+		if !hasCode(codes["draft"], want) {
+			t.Fatalf("draft に %s が報告されていません: %v", want, codes["draft"])
+		}
+	}
+	if hasCode(codes["classify"], "unconsumed_step") || hasCode(codes["draft"], "unconsumed_step") {
+		t.Fatalf("DependsOn や index で参照されるステップが未使用と判定されています: %v", codes)
+	}
+	if !hasCode(codes["broken"], "template_error") {
+		t.Fatalf("broken にテンプレートエラーが報告されていません: %v", codes["broken"])
+	}
+	if len(codes["final"]) != 0 || len(codes[""]) != 0 {
+		t.Fatalf("final とパイプライン全体に指摘は不要です: %v", codes)
+	}
+	if hasCode(codes["classify"], "no_provider") {
+		t.Fatalf("既定プロファイルがあるのに no_provider が報告されています: %v", codes)
+	}
+}
+
+func TestLintReportsPipelineLevelProblems(t *testing.T) {
+	codes := findingCodes(engine.Lint(engine.PipelineDef{}))
+	if !hasCode(codes[""], "missing_type") || !hasCode(codes[""], "no_steps") {
+		t.Fatalf("空の定義の指摘が想定外です: %v", codes)
+	}
+
+	findings := engine.Lint(engine.PipelineDef{
+		Type: "lint.dup",
+		Steps: []engine.StepDef{
+			{ID: "a", Kind: engine.StepKindLLM},
+			{ID: "a", Kind: engine.StepKindLLM},
+		},
+	})
+	codes = findingCodes(findings)
+	if !hasCode(codes["a"], "duplicate_step_id") || !hasCode(codes["a"], "no_provider") || !hasCode(codes[""], "no_exported_steps") {
+		t.Fatalf("重複 ID やエクスポートなしの指摘が想定外です: %v", codes)
+	}
+	for _, f := range findings {
+		if f.Code == "no_provider" && f.Severity != engine.SeverityInfo {
+			t.Fatalf("no_provider は info であるべきです: %+v", f)
+		}
+		if f.Code == "duplicate_step_id" && f.Severity != engine.SeverityError {
+			t.Fatalf("duplicate_step_id は error であるべきです: %+v", f)
+		}
+	}
+}
+
+func TestBasicEngine_LintPipelineReportsUnsupportedFeatures(t *testing.T) {
+	eng := engine.NewBasicEngine(store.NewMemoryStore())
+	findings := eng.LintPipeline(engine.PipelineDef{
+		Type:  "lint.unsupported",
+		Steps: []engine.StepDef{{ID: "loop", Kind: engine.StepKind("loop"), Export: true}},
+	})
+	for _, f := range findings {
+		if f.Code == engine.ErrCodeUnsupportedFeature && f.Step == "loop" && f.Severity == engine.SeverityError {
+			return
+		}
+	}
+	t.Fatalf("未対応の step kind が unsupported_feature として報告されていません: %+v", findings)
+}
+
+func TestBasicEngine_LintPipelineReportsUnknownProfiles(t *testing.T) {
+	eng := engine.NewBasicEngine(store.NewMemoryStore())
+	def := engine.PipelineDef{
+		Type: "lint.profiles",
+		Steps: []engine.StepDef{
+			{
+				ID:                "summary",
+				Kind:              engine.StepKindLLM,
+				ProviderProfileID: "missing",
+				Export:            true,
+				Prompt:            &engine.PromptTemplate{User: "{{range .Sources}}{{.Content}}{{end}}"},
+			},
+		},
+	}
+	findings := eng.LintPipeline(def)
+	if len(findings) != 1 {
+		t.Fatalf("指摘数が想定外です: %+v", findings)
+	}
+	f := findings[0]
+	if f.Code != "unknown_profile" || f.Step != "summary" || f.Severity != engine.SeverityError || !strings.Contains(f.Message, "missing") || strings.HasPrefix(f.Message, "step ") {
+		t.Fatalf("未登録プロファイルの指摘が想定外です: %+v", f)
+	}
+	if len(eng.ListPipelines()) != 0 {
+		t.Fatalf("LintPipeline がパイプラインを登録しています: %+v", eng.ListPipelines())
+	}
+}
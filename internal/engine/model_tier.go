@@ -0,0 +1,38 @@
+package engine
+
+import "strings"
+
+// modelTier returns the model StepDef.ModelTiers maps the job's
+// JobOptions.DetailLevel to. Levels match case-insensitively.
+func modelTier(step StepDef, opts *JobOptions) (level, model string, ok bool) {
+	if len(step.ModelTiers) == 0 || opts == nil {
+		return "", "", false
+	}
+	level = strings.ToLower(strings.TrimSpace(opts.DetailLevel))
+	if level == "" {
+		return "", "", false
+	}
+	for key, candidate := range step.ModelTiers {
+		if strings.ToLower(key) == level && candidate != "" {
+			return level, candidate, true
+		}
+	}
+	return "", "", false
+}
+
+// applyModelTier returns step with the matching tier's model as its
+// default_model provider override. Without a match the step keeps its
+// override or the profile default.
+func applyModelTier(step StepDef, opts *JobOptions) StepDef {
+	_, model, ok := modelTier(step, opts)
+	if !ok {
+		return step
+	}
+	override := make(map[string]any, len(step.ProviderOverride)+1)
+	for key, val := range step.ProviderOverride {
+		override[key] = val
+	}
+	override["default_model"] = model
+	step.ProviderOverride = override
+	return step
+}
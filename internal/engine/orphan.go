@@ -0,0 +1,122 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/example/pipeline-engine/pkg/logging"
+)
+
+// OrphanPolicy decides what ReconcileJobs does with orphaned jobs.
+type OrphanPolicy string
+
+const (
+	// OrphanPolicyFail marks orphaned jobs failed with the orphaned code.
+	OrphanPolicyFail OrphanPolicy = "fail"
+	// OrphanPolicyRequeue resets orphaned jobs to queued and runs them again
+	// from the first step.
+	OrphanPolicyRequeue OrphanPolicy = "requeue"
+)
+
+// ParseOrphanPolicy parses "fail" or "requeue" case-insensitively; an empty
+// string yields OrphanPolicyFail.
+func ParseOrphanPolicy(raw string) (OrphanPolicy, error) {
+	switch policy := OrphanPolicy(strings.ToLower(strings.TrimSpace(raw))); policy {
+	case "":
+		return OrphanPolicyFail, nil
+	case OrphanPolicyFail, OrphanPolicyRequeue:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown orphan policy %q (expected %q or %q)", raw, OrphanPolicyFail, OrphanPolicyRequeue)
+	}
+}
+
+// ReconcileJobs applies policy to orphaned jobs: stored jobs that are queued
+// or running but have no goroutine in this engine, as left behind when a
+// process with a persistent store exits mid-job. It returns how many jobs
+// were reconciled. Call it on startup after registering pipelines and before
+// accepting requests.
+//
+// Jobs with sensitive sources or ephemeral provider profiles cannot be
+// requeued because only their redacted content or profile IDs were stored;
+// they are failed under either policy.
+func (e *BasicEngine) ReconcileJobs(ctx context.Context, policy OrphanPolicy) (int, error) {
+	jobs, err := e.store.ListJobs(ctx)
+	if err != nil {
+		return 0, err
+	}
+	reconciled := 0
+	for _, job := range jobs {
+		if isTerminal(job.Status) || e.getCancel(job.ID) != nil {
+			continue
+		}
+		if policy == OrphanPolicyRequeue && !hasSensitiveSource(job.Input.Sources) && len(job.EphemeralProfiles) == 0 {
+			if err := e.requeueJob(ctx, job); err != nil {
+				logging.Warnf("failed to requeue orphaned job %s: %v", job.ID, err)
+				continue
+			}
+			logging.Infof("requeued orphaned job %s", job.ID)
+		} else {
+			if err := e.failOrphanedJob(ctx, job); err != nil {
+				logging.Warnf("failed to mark orphaned job %s failed: %v", job.ID, err)
+				continue
+			}
+			logging.Warnf("marked orphaned job %s (%s) failed", job.ID, job.PipelineType)
+		}
+		reconciled++
+	}
+	return reconciled, nil
+}
+
+// failOrphanedJob fails job and the step that was running when it was
+// abandoned, if any.
+func (e *BasicEngine) failOrphanedJob(ctx context.Context, job *Job) error {
+	now := time.Now().UTC()
+	jobErr := &JobError{Code: "orphaned", Message: "job was interrupted by an engine restart"}
+	for i := range job.StepExecutions {
+		exec := &job.StepExecutions[i]
+		if exec.Status == StepExecRunning {
+			exec.Status = StepExecFailed
+			exec.FinishedAt = ptrTime(now)
+			exec.Error = jobErr
+		}
+	}
+	job.Status = JobStatusFailed
+	job.Error = jobErr
+	job.UpdatedAt = now
+	return e.store.UpdateJob(ctx, job)
+}
+
+// requeueJob resets job to its queued state and schedules it like an async
+// RunJob.
+func (e *BasicEngine) requeueJob(ctx context.Context, job *Job) error {
+	for i := range job.StepExecutions {
+		job.StepExecutions[i] = StepExecution{StepID: job.StepExecutions[i].StepID, Status: StepExecPending}
+	}
+	job.Status = JobStatusQueued
+	job.Progress = 0
+	job.Result = nil
+	job.Error = nil
+	job.UpdatedAt = time.Now().UTC()
+	if err := e.store.UpdateJob(ctx, job); err != nil {
+		return err
+	}
+	if job.Webhook != nil {
+		queued := *job
+		e.startWebhook(&queued)
+	}
+
+	jobCtx, cancel := context.WithCancel(context.Background())
+	e.setCancel(job.ID, cancel)
+	go func() {
+		defer cancel()
+		if !e.acquireJobSlot(jobCtx, job.PipelineType) {
+			return
+		}
+		defer e.releaseJobSlot(job.PipelineType)
+		e.executeJob(jobCtx, job.ID)
+	}()
+	return nil
+}
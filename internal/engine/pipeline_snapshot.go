@@ -0,0 +1,73 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+)
+
+// PipelineSnapshotStore is an optional extension a JobStore can implement to
+// persist the effective PipelineDef each job ran with, so the definition
+// stays available after the pipeline is re-registered or the engine restarts.
+type PipelineSnapshotStore interface {
+	SavePipelineSnapshot(jobID string, def PipelineDef)
+	LoadPipelineSnapshot(jobID string) (PipelineDef, bool)
+	DeletePipelineSnapshot(jobID string)
+}
+
+func detectPipelineSnapshotStore(store JobStore) PipelineSnapshotStore {
+	if ps, ok := store.(PipelineSnapshotStore); ok {
+		return ps
+	}
+	return nil
+}
+
+// JobPipeline returns the pipeline definition snapshotted when the job was
+// created. It fails with ErrPipelineNotFound when the job has no snapshot.
+func (e *BasicEngine) JobPipeline(ctx context.Context, jobID string) (*PipelineDef, error) {
+	if _, err := e.store.GetJob(ctx, jobID); err != nil {
+		return nil, err
+	}
+	def := e.loadPipelineSnapshot(jobID)
+	if def == nil {
+		return nil, fmt.Errorf("%w: no snapshot for job %s", ErrPipelineNotFound, jobID)
+	}
+	return def, nil
+}
+
+func (e *BasicEngine) savePipelineSnapshot(jobID string, def *PipelineDef) {
+	if def == nil {
+		return
+	}
+	if e.snapshots != nil {
+		e.snapshots.SavePipelineSnapshot(jobID, *clonePipeline(def))
+		return
+	}
+	e.jobPipeMu.Lock()
+	defer e.jobPipeMu.Unlock()
+	e.jobSnapshots[jobID] = clonePipeline(def)
+}
+
+func (e *BasicEngine) loadPipelineSnapshot(jobID string) *PipelineDef {
+	if e.snapshots != nil {
+		if def, ok := e.snapshots.LoadPipelineSnapshot(jobID); ok {
+			return clonePipeline(&def)
+		}
+		return nil
+	}
+	e.jobPipeMu.RLock()
+	defer e.jobPipeMu.RUnlock()
+	if def, ok := e.jobSnapshots[jobID]; ok {
+		return clonePipeline(def)
+	}
+	return nil
+}
+
+func (e *BasicEngine) removePipelineSnapshot(jobID string) {
+	if e.snapshots != nil {
+		e.snapshots.DeletePipelineSnapshot(jobID)
+		return
+	}
+	e.jobPipeMu.Lock()
+	defer e.jobPipeMu.Unlock()
+	delete(e.jobSnapshots, jobID)
+}
@@ -0,0 +1,167 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// PostProcessor transforms a step's provider output before it becomes a
+// ResultItem. arg is the text after the first ':' of the StepDef.PostProcess
+// entry ("truncate:200" calls the truncate processor with "200"), or empty.
+type PostProcessor func(text, arg string) (string, error)
+
+var builtinPostProcessors = map[string]PostProcessor{
+	"trim":             postProcessTrim,
+	"strip_code_fence": postProcessStripCodeFence,
+	"extract_json":     postProcessExtractJSON,
+	"truncate":         postProcessTruncate,
+	"regex":            postProcessRegex,
+}
+
+// RegisterPostProcessor registers a custom transform usable in
+// StepDef.PostProcess. Built-in names cannot be replaced.
+func (e *BasicEngine) RegisterPostProcessor(name string, processor PostProcessor) {
+	if name == "" || processor == nil {
+		return
+	}
+	if _, builtin := builtinPostProcessors[name]; builtin {
+		return
+	}
+	e.toolMu.Lock()
+	defer e.toolMu.Unlock()
+	e.postProcs[name] = processor
+}
+
+func (e *BasicEngine) postProcessor(name string) (PostProcessor, bool) {
+	if processor, ok := builtinPostProcessors[name]; ok {
+		return processor, true
+	}
+	e.toolMu.RLock()
+	defer e.toolMu.RUnlock()
+	processor, ok := e.postProcs[name]
+	return processor, ok
+}
+
+func splitPostProcess(spec string) (name, arg string) {
+	name, arg, _ = strings.Cut(spec, ":")
+	return strings.TrimSpace(name), arg
+}
+
+// postProcess runs the step's PostProcess transforms over text in order.
+// A failing or unknown transform fails the step with post_process_failed.
+func (e *BasicEngine) postProcess(step StepDef, text string) (string, error) {
+	for _, spec := range step.PostProcess {
+		name, arg := splitPostProcess(spec)
+		processor, ok := e.postProcessor(name)
+		if !ok {
+			return "", &stepError{
+				code:    "post_process_failed",
+				err:     fmt.Errorf("step %s: unknown post-processor %s", step.ID, name),
+				details: map[string]any{"post_process": spec},
+			}
+		}
+		out, err := processor(text, arg)
+		if err != nil {
+			return "", &stepError{
+				code:    "post_process_failed",
+				err:     fmt.Errorf("step %s: post-processor %s: %w", step.ID, name, err),
+				details: map[string]any{"post_process": spec},
+			}
+		}
+		text = out
+	}
+	return text, nil
+}
+
+// validatePostProcess reports PostProcess entries naming unknown transforms
+// and built-in entries with invalid arguments.
+func (e *BasicEngine) validatePostProcess(step StepDef) error {
+	var errs []error
+	for _, spec := range step.PostProcess {
+		name, arg := splitPostProcess(spec)
+		if _, ok := e.postProcessor(name); !ok {
+			errs = append(errs, fmt.Errorf("step %s: unknown post-processor %q", step.ID, name))
+			continue
+		}
+		var err error
+		switch name {
+		case "truncate":
+			_, err = postProcessTruncate("", arg)
+		case "regex":
+			_, err = regexp.Compile(arg)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("step %s: post-processor %q: %w", step.ID, spec, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func postProcessTrim(text, _ string) (string, error) {
+	return strings.TrimSpace(text), nil
+}
+
+// postProcessStripCodeFence removes a Markdown code fence (with an optional
+// language tag) wrapping the whole output. Unfenced text is returned as is.
+func postProcessStripCodeFence(text, _ string) (string, error) {
+	trimmed := strings.TrimSpace(text)
+	if !strings.HasPrefix(trimmed, "```") || !strings.HasSuffix(trimmed, "```") || len(trimmed) < 6 {
+		return text, nil
+	}
+	body := strings.TrimSuffix(trimmed, "```")
+	newline := strings.IndexByte(body, '\n')
+	if newline < 0 {
+		return strings.TrimSpace(strings.TrimPrefix(body, "```")), nil
+	}
+	return strings.TrimSpace(body[newline+1:]), nil
+}
+
+// postProcessExtractJSON returns the first JSON object or array in text,
+// skipping any prose or code fence around it.
+func postProcessExtractJSON(text, _ string) (string, error) {
+	for i := 0; i < len(text); i++ {
+		if text[i] != '{' && text[i] != '[' {
+			continue
+		}
+		var raw json.RawMessage
+		if err := json.NewDecoder(strings.NewReader(text[i:])).Decode(&raw); err == nil {
+			return string(raw), nil
+		}
+	}
+	return "", errors.New("no JSON object or array found")
+}
+
+// postProcessTruncate keeps the first arg runes of text.
+func postProcessTruncate(text, arg string) (string, error) {
+	limit, err := strconv.Atoi(strings.TrimSpace(arg))
+	if err != nil || limit <= 0 {
+		return "", fmt.Errorf("truncate needs a positive length, got %q", arg)
+	}
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text, nil
+	}
+	return string(runes[:limit]), nil
+}
+
+// postProcessRegex returns the first submatch of the pattern in arg, or the
+// whole match when the pattern has no groups.
+func postProcessRegex(text, arg string) (string, error) {
+	re, err := regexp.Compile(arg)
+	if err != nil {
+		return "", err
+	}
+	match := re.FindStringSubmatch(text)
+	switch {
+	case match == nil:
+		return "", fmt.Errorf("pattern %q did not match", arg)
+	case len(match) > 1:
+		return match[1], nil
+	default:
+		return match[0], nil
+	}
+}
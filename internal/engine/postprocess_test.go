@@ -0,0 +1,84 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuiltinPostProcessors(t *testing.T) {
+	cases := []struct {
+		name    string
+		spec    string
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{name: "trim", spec: "trim", input: "  answer \n", want: "answer"},
+		{name: "strip fence with language", spec: "strip_code_fence", input: "```json\n{\"a\":1}\n```\n", want: `{"a":1}`},
+		{name: "strip fence without language", spec: "strip_code_fence", input: "```\nplain\n```", want: "plain"},
+		{name: "strip fence leaves unfenced text", spec: "strip_code_fence", input: "no fence", want: "no fence"},
+		{name: "extract json object", spec: "extract_json", input: "Here you go: {\"a\": [1, 2]} hope it helps", want: `{"a": [1, 2]}`},
+		{name: "extract json skips invalid bracket", spec: "extract_json", input: "[see below] [1,2]", want: "[1,2]"},
+		{name: "extract json without json", spec: "extract_json", input: "nothing here", wantErr: true},
+		{name: "truncate runes", spec: "truncate:3", input: "日本語テキスト", want: "日本語"},
+		{name: "truncate short text", spec: "truncate:10", input: "short", want: "short"},
+		{name: "truncate invalid length", spec: "truncate:abc", input: "text", wantErr: true},
+		{name: "regex group", spec: `regex:score: (\d+)`, input: "final score: 42 points", want: "42"},
+		{name: "regex whole match", spec: `regex:\d+`, input: "abc 7 def", want: "7"},
+		{name: "regex no match", spec: `regex:\d+`, input: "abc", wantErr: true},
+	}
+	eng := NewBasicEngine(nil)
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := eng.postProcess(StepDef{ID: "step", PostProcess: []string{tc.spec}}, tc.input)
+			if tc.wantErr {
+				if err == nil || stepErrorCode(err) != "post_process_failed" {
+					t.Fatalf("expected post_process_failed, got %q %v", got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Fatalf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPostProcessChainAndCustomProcessors(t *testing.T) {
+	eng := NewBasicEngine(nil)
+	eng.RegisterPostProcessor("upper", func(text, _ string) (string, error) {
+		return strings.ToUpper(text), nil
+	})
+	eng.RegisterPostProcessor("trim", func(text, _ string) (string, error) {
+		return "overridden", nil
+	})
+
+	step := StepDef{ID: "chain", PostProcess: []string{"strip_code_fence", "trim", "upper", "truncate:5"}}
+	got, err := eng.postProcess(step, "```\n  hello world \n```")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != "HELLO" {
+		t.Fatalf("transforms should run in order and built-ins cannot be replaced, got %q", got)
+	}
+
+	if _, err := eng.postProcess(StepDef{ID: "bad", PostProcess: []string{"missing"}}, "x"); stepErrorCode(err) != "post_process_failed" {
+		t.Fatalf("unknown processor should fail the step: %v", err)
+	}
+	invalid := StepDef{ID: "bad", PostProcess: []string{"missing", "truncate:0", "regex:(", "upper"}}
+	err = eng.validatePostProcess(invalid)
+	if err == nil {
+		t.Fatal("expected validation error")
+	}
+	for _, want := range []string{`"missing"`, `"truncate:0"`, `"regex:("`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("validation error should mention %s: %v", want, err)
+		}
+	}
+	if strings.Contains(err.Error(), "upper") {
+		t.Fatalf("registered custom processor should be valid: %v", err)
+	}
+}
@@ -0,0 +1,44 @@
+package engine
+
+import (
+	"os"
+	"strings"
+
+	"github.com/example/pipeline-engine/pkg/logging"
+)
+
+// ProfileStore persists provider profiles upserted at runtime so they are
+// reloaded when the engine restarts. Implementations decide how APIKey is
+// protected at rest.
+type ProfileStore interface {
+	SaveProfile(profile ProviderProfile) error
+	LoadProfiles() ([]ProviderProfile, error)
+}
+
+// APIKeyEnvPrefix marks an APIKey that references an environment variable
+// (e.g. "env:OPENAI_API_KEY") instead of holding the secret itself. The
+// reference is what gets persisted; the value is read on registration.
+const APIKeyEnvPrefix = "env:"
+
+func resolveProfileAPIKey(profile ProviderProfile) ProviderProfile {
+	if name, ok := strings.CutPrefix(profile.APIKey, APIKeyEnvPrefix); ok {
+		profile.APIKey = os.Getenv(strings.TrimSpace(name))
+	}
+	return profile
+}
+
+// loadStoredProfiles registers the persisted profiles on top of the
+// env-configured ones. Load errors are logged; whatever was readable is
+// still registered.
+func loadStoredProfiles(reg *ProviderRegistry, profiles ProfileStore) {
+	stored, err := profiles.LoadProfiles()
+	if err != nil {
+		logging.Warnf("failed to load stored provider profiles: %v", err)
+	}
+	for _, profile := range stored {
+		reg.RegisterProfile(resolveProfileAPIKey(profile))
+	}
+	if len(stored) > 0 {
+		logging.Infof("loaded %d stored provider profile(s)", len(stored))
+	}
+}
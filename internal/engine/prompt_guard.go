@@ -0,0 +1,76 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+)
+
+// contextWindowExtraKey is the ProviderProfile.Extra key holding the model's
+// context window in tokens. It can be set per step through provider_override.
+const contextWindowExtraKey = "context_window"
+
+// estimateTokens approximates the token count of text using the common
+// "about four characters per token" heuristic.
+func estimateTokens(text string) int {
+	runes := utf8.RuneCountInString(text)
+	if runes == 0 {
+		return 0
+	}
+	return (runes + 3) / 4
+}
+
+// checkContextWindow fails early when the prompt is estimated to exceed the
+// context window configured on the resolved profile. Steps with a
+// TruncationStrategy are shortened by truncatePrompt before this check.
+func checkContextWindow(step StepDef, profile ProviderProfile, prompt string, messages []PromptMessage) error {
+	limit, ok := extraInt(profile.Extra, contextWindowExtraKey)
+	if !ok || limit <= 0 {
+		return nil
+	}
+	estimated := estimatePromptTokens(profile, prompt, messages)
+	if estimated <= limit {
+		return nil
+	}
+	model := profile.DefaultModel
+	if model == "" {
+		model = string(profile.Kind)
+	}
+	return &stepError{
+		code: "context_window_exceeded",
+		err:  fmt.Errorf("step %s prompt is ~%d tokens, exceeding the %d token context window of %s", step.ID, estimated, limit, model),
+	}
+}
+
+// estimatePromptTokens estimates the tokens a call sends: the prompt, the
+// role messages and the profile's system prompt.
+func estimatePromptTokens(profile ProviderProfile, prompt string, messages []PromptMessage) int {
+	estimated := estimateTokens(prompt)
+	for _, msg := range messages {
+		estimated += estimateTokens(msg.Content)
+	}
+	if sys, ok := profile.Extra["system_prompt"].(string); ok {
+		estimated += estimateTokens(sys)
+	}
+	return estimated
+}
+
+func extraInt(extra map[string]any, key string) (int, bool) {
+	switch v := extra[key].(type) {
+	case int:
+		return v, true
+	case int64:
+		return int(v), true
+	case float64:
+		return int(v), true
+	case json.Number:
+		n, err := v.Int64()
+		return int(n), err == nil
+	case string:
+		n, err := strconv.Atoi(v)
+		return n, err == nil
+	default:
+		return 0, false
+	}
+}
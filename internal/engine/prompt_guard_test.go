@@ -0,0 +1,75 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckContextWindow(t *testing.T) {
+	step := StepDef{ID: StepID("summarize")}
+	profile := ProviderProfile{ID: "openai", Kind: ProviderOpenAI, DefaultModel: "gpt-small", Extra: map[string]any{"context_window": float64(10)}}
+
+	if err := checkContextWindow(step, profile, "short prompt", nil); err != nil {
+		t.Fatalf("unexpected error for small prompt: %v", err)
+	}
+
+	err := checkContextWindow(step, profile, strings.Repeat("a", 100), nil)
+	if err == nil {
+		t.Fatal("expected context window error")
+	}
+	if code := stepErrorCode(err); code != "context_window_exceeded" {
+		t.Fatalf("unexpected code: %s", code)
+	}
+	if !strings.Contains(err.Error(), "summarize") || !strings.Contains(err.Error(), "~25 tokens") {
+		t.Fatalf("error should name the step and estimate: %v", err)
+	}
+
+	unlimited := ProviderProfile{ID: "openai", Kind: ProviderOpenAI}
+	if err := checkContextWindow(step, unlimited, strings.Repeat("a", 100), nil); err != nil {
+		t.Fatalf("profiles without context_window should not be checked: %v", err)
+	}
+}
+
+func TestMergeProfileDoesNotMutateBaseExtra(t *testing.T) {
+	base := ProviderProfile{ID: "openai", Extra: map[string]any{"system_prompt": "sys"}}
+	merged := mergeProfile(base, map[string]any{"context_window": 8})
+	if _, ok := base.Extra["context_window"]; ok {
+		t.Fatalf("override leaked into base profile: %+v", base.Extra)
+	}
+	if merged.Extra["context_window"] != 8 || merged.Extra["system_prompt"] != "sys" {
+		t.Fatalf("unexpected merged extra: %+v", merged.Extra)
+	}
+}
+
+func TestCutSources(t *testing.T) {
+	sources := []Source{
+		{Label: "a", Content: "aaaa"},
+		{Label: "b", Content: "bbbb"},
+		{Label: "c", Content: "cccc"},
+	}
+	join := func(sources []Source) string {
+		var parts []string
+		for _, src := range sources {
+			parts = append(parts, src.Content)
+		}
+		return strings.Join(parts, "|")
+	}
+	for _, tc := range []struct {
+		strategy TruncationStrategy
+		drop     int
+		want     string
+	}{
+		{TruncateHead, 6, "aaaa|bb"},
+		{TruncateTail, 6, "bb|cccc"},
+		{TruncateMiddle, 6, "aaa|ccc"},
+		{TruncateMiddle, 12, ""},
+		{TruncateHead, 0, "aaaa|bbbb|cccc"},
+	} {
+		if got := join(cutSources(sources, tc.drop, tc.strategy)); got != tc.want {
+			t.Fatalf("%s drop=%d: got %q, want %q", tc.strategy, tc.drop, got, tc.want)
+		}
+	}
+	if sources[1].Content != "bbbb" {
+		t.Fatalf("cutSources mutated its input: %+v", sources)
+	}
+}
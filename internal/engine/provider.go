@@ -5,8 +5,10 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 )
 
 // ProviderRequest represents the context passed to concrete providers.
@@ -20,26 +22,280 @@ type ProviderRequest struct {
 // ProviderInput shares job-level context with providers.
 type ProviderInput struct {
 	Sources  []Source
+	History  []ChatMessage
 	Options  *JobOptions
 	Previous map[StepID][]ResultItem
+	// Messages holds rendered PromptTemplate.Meta messages; empty means the
+	// provider should send the plain prompt.
+	Messages []PromptMessage
+	// ToolTurns holds earlier tool-calling rounds of this call, in order,
+	// which the provider appends to the conversation.
+	ToolTurns []ToolTurn
 }
 
 // ProviderResponse wraps a provider output payload.
 type ProviderResponse struct {
-	Output   string
+	Output string
+	// Meta describes the call; the engine fills in LatencyMS and stores it
+	// as ResultItem.ProviderMeta.
+	Meta *ProviderMeta
+	// Metadata holds extra keys merged into ResultItem.Data.
 	Metadata map[string]any
 	Chunks   []ProviderChunk
+	// ToolCalls asks the engine to run tools and call the provider again.
+	ToolCalls []ToolCall
+}
+
+// ProviderMeta is the typed description of how a provider served a call.
+type ProviderMeta struct {
+	Provider     ProviderKind `json:"provider,omitempty"`
+	Model        string       `json:"model,omitempty"`
+	FinishReason string       `json:"finish_reason,omitempty"`
+	Usage        *TokenUsage  `json:"usage,omitempty"`
+	LatencyMS    int64        `json:"latency_ms"`
+	// Seed is the JobOptions.Seed the provider sampled with. SeedIgnored is
+	// set by the engine when a seed was requested but the provider did not
+	// report using it.
+	Seed        *int64 `json:"seed,omitempty"`
+	SeedIgnored bool   `json:"seed_ignored,omitempty"`
+}
+
+// TokenUsage is the token accounting reported by the provider.
+type TokenUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// flatMeta returns the keys ProviderMeta historically contributed to
+// ResultItem.Data. They are kept for compatibility with existing clients.
+func (m *ProviderMeta) flatMeta() map[string]any {
+	if m == nil {
+		return nil
+	}
+	flat := map[string]any{}
+	if m.Provider != "" {
+		flat["provider"] = string(m.Provider)
+	}
+	if m.Model != "" {
+		flat["model"] = m.Model
+	}
+	return flat
+}
+
+// ToolCall is a function call requested by the model. Arguments is the raw
+// JSON arguments string.
+type ToolCall struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// ToolTurn is one tool-calling round: the assistant reply that requested the
+// calls and the result of each call.
+type ToolTurn struct {
+	Content string
+	Calls   []ToolCall
+	Results []ToolResult
+}
+
+// ToolResult is the output of a tool call fed back to the model.
+type ToolResult struct {
+	CallID  string
+	Content string
 }
 
 type ProviderChunk struct {
 	Content string
 }
 
+// Job error codes of steps that failed calling a remote provider.
+const (
+	ErrCodeProviderNetwork = "provider_network_error"
+	ErrCodeProviderAPI     = "provider_api_error"
+	ErrCodeProviderDecode  = "provider_decode_error"
+	ErrCodeProviderRefused = "provider_refused"
+)
+
+// ProviderNetworkError reports a provider request that never received an HTTP
+// response (connection failure, timeout).
+type ProviderNetworkError struct {
+	Kind ProviderKind
+	Err  error
+}
+
+func (e *ProviderNetworkError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ProviderNetworkError) Unwrap() error {
+	return e.Err
+}
+
+// ProviderAPIError reports an HTTP error status returned by a provider.
+// RetryAfter is the delay the provider asked for before trying again
+// (Retry-After or retry-after-ms), zero when it sent none.
+type ProviderAPIError struct {
+	Kind       ProviderKind
+	StatusCode int
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *ProviderAPIError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ProviderAPIError) Unwrap() error {
+	return e.Err
+}
+
+// ProviderDecodeError reports a provider response that could not be decoded
+// or lacked the expected content.
+type ProviderDecodeError struct {
+	Kind ProviderKind
+	Err  error
+}
+
+func (e *ProviderDecodeError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ProviderDecodeError) Unwrap() error {
+	return e.Err
+}
+
+// ProviderRefusalError reports a response in which the model declined to
+// answer: an explicit refusal message, or no content because the provider's
+// content filter stopped generation.
+type ProviderRefusalError struct {
+	Kind ProviderKind
+	// Refusal is the model's explanation, empty when the provider gave none.
+	Refusal      string
+	FinishReason string
+}
+
+func (e *ProviderRefusalError) Error() string {
+	if e.Refusal != "" {
+		return fmt.Sprintf("%s refused the request: %s", e.Kind, e.Refusal)
+	}
+	return fmt.Sprintf("%s returned no content (finish_reason %s)", e.Kind, e.FinishReason)
+}
+
+// providerErrorCode returns the job error code and details of a provider
+// failure, or ok=false when err is not a provider error.
+func providerErrorCode(err error) (code string, details map[string]any, ok bool) {
+	var netErr *ProviderNetworkError
+	var apiErr *ProviderAPIError
+	var decodeErr *ProviderDecodeError
+	var refusalErr *ProviderRefusalError
+	switch {
+	case errors.As(err, &netErr):
+		return ErrCodeProviderNetwork, map[string]any{"provider": string(netErr.Kind)}, true
+	case errors.As(err, &apiErr):
+		return ErrCodeProviderAPI, map[string]any{"provider": string(apiErr.Kind), "status_code": apiErr.StatusCode}, true
+	case errors.As(err, &decodeErr):
+		return ErrCodeProviderDecode, map[string]any{"provider": string(decodeErr.Kind)}, true
+	case errors.As(err, &refusalErr):
+		details := map[string]any{"provider": string(refusalErr.Kind)}
+		if refusalErr.Refusal != "" {
+			details["refusal"] = refusalErr.Refusal
+		}
+		if refusalErr.FinishReason != "" {
+			details["finish_reason"] = refusalErr.FinishReason
+		}
+		return ErrCodeProviderRefused, details, true
+	default:
+		return "", nil, false
+	}
+}
+
+// ProviderUnresolvedError reports a provider profile that cannot be turned
+// into a Provider: the profile is not registered (Kind is empty) or no factory
+// is registered for its kind.
+type ProviderUnresolvedError struct {
+	ProfileID ProviderProfileID
+	Kind      ProviderKind
+}
+
+func (e *ProviderUnresolvedError) Error() string {
+	if e.Kind == "" {
+		return fmt.Sprintf("provider profile %s not found", e.ProfileID)
+	}
+	return fmt.Sprintf("provider kind %s of profile %s not registered", e.Kind, e.ProfileID)
+}
+
+// IsRetryableProviderError reports whether err is a transient provider failure
+// (network error, 429 or 5xx) that another attempt or profile may recover
+// from. Decode errors are not retried.
+func IsRetryableProviderError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var netErr *ProviderNetworkError
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var apiErr *ProviderAPIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= http.StatusInternalServerError
+	}
+	return false
+}
+
 // Provider describes an abstract LLM / tool executor.
 type Provider interface {
 	Call(ctx context.Context, req ProviderRequest) (ProviderResponse, error)
 }
 
+// StreamingProvider is implemented by providers that stream output as it is
+// generated. CallStream returns a channel of chunks, closed when the call ends
+// (including on cancellation), and a wait function that returns the final
+// response once the channel is drained. The engine records the streamed chunks
+// as the step's chunks; the final response's Chunks are ignored.
+type StreamingProvider interface {
+	Provider
+	CallStream(ctx context.Context, req ProviderRequest) (<-chan ProviderChunk, func() (ProviderResponse, error), error)
+}
+
+// AsStreamingProvider returns p itself when it streams, or an adapter that
+// performs the batch Call and replays its Chunks on the stream.
+func AsStreamingProvider(p Provider) StreamingProvider {
+	if streaming, ok := p.(StreamingProvider); ok {
+		return streaming
+	}
+	return batchStreamingProvider{Provider: p}
+}
+
+// batchStreamingProvider adapts a batch Provider to StreamingProvider.
+type batchStreamingProvider struct {
+	Provider
+}
+
+func (b batchStreamingProvider) CallStream(ctx context.Context, req ProviderRequest) (<-chan ProviderChunk, func() (ProviderResponse, error), error) {
+	chunks := make(chan ProviderChunk)
+	done := make(chan struct{})
+	var resp ProviderResponse
+	var err error
+	go func() {
+		defer close(done)
+		defer close(chunks)
+		resp, err = b.Call(ctx, req)
+		for _, chunk := range resp.Chunks {
+			select {
+			case chunks <- chunk:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	wait := func() (ProviderResponse, error) {
+		<-done
+		return resp, err
+	}
+	return chunks, wait, nil
+}
+
 // ProviderFactory instantiates a Provider using a specific profile.
 type ProviderFactory func(profile ProviderProfile) Provider
 
@@ -65,6 +321,7 @@ func (r *ProviderRegistry) RegisterProfile(profile ProviderProfile) {
 	}
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	profile.Kind = NormalizeProviderKind(profile.Kind)
 	if profile.Kind == "" {
 		profile.Kind = ProviderLocal
 	}
@@ -81,7 +338,52 @@ func (r *ProviderRegistry) RegisterFactory(kind ProviderKind, factory ProviderFa
 	}
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	r.factories[kind] = factory
+	r.factories[NormalizeProviderKind(kind)] = factory
+}
+
+// NormalizeProviderKind trims and lower-cases kind so that "OpenAI" matches
+// the registered "openai" factory.
+func NormalizeProviderKind(kind ProviderKind) ProviderKind {
+	return ProviderKind(strings.ToLower(strings.TrimSpace(string(kind))))
+}
+
+// ProviderKindError reports a profile kind without a registered factory.
+type ProviderKindError struct {
+	Kind      ProviderKind
+	Supported []ProviderKind
+}
+
+func (e *ProviderKindError) Error() string {
+	names := make([]string, len(e.Supported))
+	for i, kind := range e.Supported {
+		names[i] = string(kind)
+	}
+	return fmt.Sprintf("unsupported provider kind %q (supported: %s)", e.Kind, strings.Join(names, ", "))
+}
+
+// Kinds returns the kinds that have a registered factory, sorted.
+func (r *ProviderRegistry) Kinds() []ProviderKind {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	kinds := make([]ProviderKind, 0, len(r.factories))
+	for kind := range r.factories {
+		kinds = append(kinds, kind)
+	}
+	sort.Slice(kinds, func(i, j int) bool { return kinds[i] < kinds[j] })
+	return kinds
+}
+
+// CheckKind returns a *ProviderKindError unless kind (after normalization)
+// has a registered factory.
+func (r *ProviderRegistry) CheckKind(kind ProviderKind) error {
+	kind = NormalizeProviderKind(kind)
+	r.mu.RLock()
+	_, ok := r.factories[kind]
+	r.mu.RUnlock()
+	if ok {
+		return nil
+	}
+	return &ProviderKindError{Kind: kind, Supported: r.Kinds()}
 }
 
 // Resolve returns a Provider based on the Step definition.
@@ -94,13 +396,24 @@ func (r *ProviderRegistry) Resolve(step StepDef) (Provider, ProviderProfile, err
 
 	profile, ok := r.profiles[step.ProviderProfileID]
 	if !ok {
-		return nil, ProviderProfile{}, fmt.Errorf("provider profile %s not found", step.ProviderProfileID)
+		return nil, ProviderProfile{}, &ProviderUnresolvedError{ProfileID: step.ProviderProfileID}
 	}
-	merged := mergeProfile(profile, step.ProviderOverride)
+	return r.instantiateLocked(profile, step.ProviderOverride)
+}
+
+// instantiate builds a Provider for a profile that is not registered, such
+// as a job's ephemeral profile.
+func (r *ProviderRegistry) instantiate(profile ProviderProfile, overrides map[string]any) (Provider, ProviderProfile, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.instantiateLocked(profile, overrides)
+}
 
+func (r *ProviderRegistry) instantiateLocked(profile ProviderProfile, overrides map[string]any) (Provider, ProviderProfile, error) {
+	merged := mergeProfile(profile, overrides)
 	factory := r.factories[merged.Kind]
 	if factory == nil {
-		return nil, ProviderProfile{}, fmt.Errorf("provider kind %s not registered", merged.Kind)
+		return nil, ProviderProfile{}, &ProviderUnresolvedError{ProfileID: profile.ID, Kind: merged.Kind}
 	}
 	return factory(merged), merged, nil
 }
@@ -110,8 +423,9 @@ func mergeProfile(base ProviderProfile, overrides map[string]any) ProviderProfil
 		return base
 	}
 	result := base
-	if result.Extra == nil {
-		result.Extra = map[string]any{}
+	result.Extra = make(map[string]any, len(base.Extra)+len(overrides))
+	for key, val := range base.Extra {
+		result.Extra[key] = val
 	}
 	for key, val := range overrides {
 		lower := strings.ToLower(key)
@@ -151,12 +465,25 @@ func buildChunksFromText(text string) []ProviderChunk {
 }
 
 // RegisterDefaultProviderFactories registers stub providers for supported kinds.
+// HTTP-backed providers use http.DefaultTransport.
 func RegisterDefaultProviderFactories(reg *ProviderRegistry) {
+	RegisterDefaultProviderFactoriesWithTransport(reg, nil)
+}
+
+// RegisterDefaultProviderFactoriesWithTransport is like
+// RegisterDefaultProviderFactories but routes HTTP-backed providers through the
+// given shared transport (proxy, TLS, connection pool). A nil transport uses
+// http.DefaultTransport.
+func RegisterDefaultProviderFactoriesWithTransport(reg *ProviderRegistry, transport *http.Transport) {
+	registerDefaultProviderFactories(reg, newProviderHTTPClients(transport))
+}
+
+func registerDefaultProviderFactories(reg *ProviderRegistry, clients *providerHTTPClients) {
 	reg.RegisterFactory(ProviderOpenAI, func(profile ProviderProfile) Provider {
-		return &OpenAIProvider{profile: profile}
+		return &OpenAIProvider{profile: profile, client: clients.forProfile(profile)}
 	})
 	reg.RegisterFactory(ProviderOllama, func(profile ProviderProfile) Provider {
-		return &OllamaProvider{profile: profile}
+		return &OllamaProvider{profile: profile, client: clients.forProfile(profile)}
 	})
 	reg.RegisterFactory(ProviderImage, func(profile ProviderProfile) Provider {
 		return &ImageProvider{profile: profile}
@@ -182,7 +509,7 @@ func (p *ImageProvider) Call(ctx context.Context, req ProviderRequest) (Provider
 	default:
 	}
 	text := fmt.Sprintf("image provider %s generated assets for step %s", p.profile.ID, req.Step.ID)
-	return ProviderResponse{Output: text, Metadata: map[string]any{"provider": p.profile.Kind}}, nil
+	return ProviderResponse{Output: text, Meta: &ProviderMeta{Provider: p.profile.Kind}}, nil
 }
 
 // LocalToolProvider simulates local shell/tool execution.
@@ -200,6 +527,8 @@ func (p *LocalToolProvider) Call(ctx context.Context, req ProviderRequest) (Prov
 	return ProviderResponse{Output: output, Metadata: map[string]any{"tool": p.profile.ID}}, nil
 }
 
+// defaultProviderProfiles are registered on every engine unless
+// EngineConfig.DisableDefaultProfiles is set.
 func defaultProviderProfiles() []ProviderProfile {
 	return []ProviderProfile{
 		{
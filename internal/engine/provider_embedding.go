@@ -0,0 +1,173 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/example/pipeline-engine/pkg/logging"
+)
+
+// Steps whose OutputType is ContentEmbedding make the OpenAI and Ollama
+// providers request an embedding of the step's text instead of a completion.
+// The vector is returned under EmbeddingDataKey so retrieve steps can use it.
+const (
+	// EmbeddingModelExtraKey names the embedding model in ProviderProfile.Extra;
+	// DefaultModel is the chat model and is not used for embeddings.
+	EmbeddingModelExtraKey = "embedding_model"
+	// EmbeddingPathTemplateExtraKey replaces the embeddings path of
+	// OpenAI-compatible servers, like path_template for chat completions.
+	EmbeddingPathTemplateExtraKey = "embedding_path_template"
+
+	defaultOpenAIEmbeddingModel        = "text-embedding-3-small"
+	defaultOpenAIEmbeddingPathTemplate = "/embeddings"
+	defaultOllamaEmbeddingModel        = "nomic-embed-text"
+)
+
+func wantsEmbedding(step StepDef) bool {
+	return step.OutputType == ContentEmbedding
+}
+
+// embeddingInput returns the text embedded for a call. Fan-out shards embed
+// their own source, since the prompt is shared by every shard; other steps
+// embed the rendered prompt, or their sources when they have no prompt.
+func embeddingInput(req ProviderRequest) string {
+	if req.Step.Mode != StepModeFanOut && req.Prompt != "" {
+		return req.Prompt
+	}
+	var parts []string
+	for _, src := range req.Input.Sources {
+		if src.Content != "" {
+			parts = append(parts, src.Content)
+		}
+	}
+	if len(parts) == 0 {
+		return req.Prompt
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+func embeddingModel(profile ProviderProfile, fallback string) string {
+	if model, ok := profile.Extra[EmbeddingModelExtraKey].(string); ok && strings.TrimSpace(model) != "" {
+		return strings.TrimSpace(model)
+	}
+	return fallback
+}
+
+// embeddingResponse builds the provider response of an embedding call. The
+// embedded text becomes the output so retrieved items keep it.
+func embeddingResponse(text string, vec []float64, meta *ProviderMeta) ProviderResponse {
+	return ProviderResponse{Output: text, Meta: meta, Metadata: map[string]any{EmbeddingDataKey: vec}}
+}
+
+type openAIEmbeddingRequest struct {
+	Model string `json:"model"`
+	Input string `json:"input"`
+}
+
+type openAIEmbeddingResponse struct {
+	Data []struct {
+		Embedding []float64 `json:"embedding"`
+	} `json:"data"`
+	Model string      `json:"model"`
+	Usage *TokenUsage `json:"usage"`
+}
+
+func callOpenAIEmbedding(ctx context.Context, req ProviderRequest, profile ProviderProfile, client httpDoer, apiKey string) (ProviderResponse, error) {
+	model := embeddingModel(profile, defaultOpenAIEmbeddingModel)
+	endpoint, err := openAIEndpointFor(profile, model, EmbeddingPathTemplateExtraKey, defaultOpenAIEmbeddingPathTemplate)
+	if err != nil {
+		return ProviderResponse{}, err
+	}
+	text := embeddingInput(req)
+
+	logging.Debugf("openai embedding start profile=%s model=%s", profile.ID, model)
+	resp, respBody, err := sendOpenAIRequest(ctx, client, endpoint, profile, apiKey, openAIEmbeddingRequest{Model: model, Input: text})
+	if err != nil {
+		logging.Errorf("openai embedding error profile=%s err=%v", profile.ID, err)
+		return ProviderResponse{}, providerCallError(ctx, ProviderOpenAI, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		err := fmt.Errorf("openai api error: %s", resp.Status)
+		logging.Errorf("openai embedding failed profile=%s err=%v", profile.ID, err)
+		return ProviderResponse{}, &ProviderAPIError{Kind: ProviderOpenAI, StatusCode: resp.StatusCode, RetryAfter: parseRetryAfter(resp.Header, time.Now()), Err: err}
+	}
+
+	var decoded openAIEmbeddingResponse
+	if err := json.NewDecoder(respBody).Decode(&decoded); err != nil {
+		return ProviderResponse{}, providerBodyError(ctx, ProviderOpenAI, fmt.Errorf("decode openai embedding response: %w", err))
+	}
+	if len(decoded.Data) == 0 || len(decoded.Data[0].Embedding) == 0 {
+		return ProviderResponse{}, &ProviderDecodeError{Kind: ProviderOpenAI, Err: errors.New("openai response missing embedding")}
+	}
+	if decoded.Model != "" {
+		model = decoded.Model
+	}
+	logging.Debugf("openai embedding success profile=%s model=%s", profile.ID, model)
+	return embeddingResponse(text, decoded.Data[0].Embedding, &ProviderMeta{Provider: ProviderOpenAI, Model: model, Usage: decoded.Usage}), nil
+}
+
+type ollamaEmbedRequest struct {
+	Model string `json:"model"`
+	Input string `json:"input"`
+}
+
+type ollamaEmbedResponse struct {
+	Model           string      `json:"model"`
+	Embeddings      [][]float64 `json:"embeddings"`
+	PromptEvalCount int         `json:"prompt_eval_count"`
+}
+
+func callOllamaEmbedding(ctx context.Context, req ProviderRequest, profile ProviderProfile, client httpDoer, base string) (ProviderResponse, error) {
+	model := embeddingModel(profile, defaultOllamaEmbeddingModel)
+	text := embeddingInput(req)
+	body, err := json.Marshal(ollamaEmbedRequest{Model: model, Input: text})
+	if err != nil {
+		return ProviderResponse{}, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(base, "/")+"/api/embed", bytes.NewReader(body))
+	if err != nil {
+		return ProviderResponse{}, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	logging.Debugf("ollama embedding start profile=%s model=%s", profile.ID, model)
+	logProviderRequest(ProviderOllama, profile.ID, httpReq, body)
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		logging.Errorf("ollama embedding error profile=%s err=%v", profile.ID, err)
+		return ProviderResponse{}, providerCallError(ctx, ProviderOllama, err)
+	}
+	defer resp.Body.Close()
+	respBody := logProviderResponse(ProviderOllama, profile.ID, resp)
+
+	if resp.StatusCode >= 400 {
+		err := fmt.Errorf("ollama api error: %s", resp.Status)
+		logging.Errorf("ollama embedding failed profile=%s err=%v", profile.ID, err)
+		return ProviderResponse{}, &ProviderAPIError{Kind: ProviderOllama, StatusCode: resp.StatusCode, RetryAfter: parseRetryAfter(resp.Header, time.Now()), Err: err}
+	}
+
+	var decoded ollamaEmbedResponse
+	if err := json.NewDecoder(respBody).Decode(&decoded); err != nil {
+		return ProviderResponse{}, providerBodyError(ctx, ProviderOllama, fmt.Errorf("decode ollama embed response: %w", err))
+	}
+	if len(decoded.Embeddings) == 0 || len(decoded.Embeddings[0]) == 0 {
+		return ProviderResponse{}, &ProviderDecodeError{Kind: ProviderOllama, Err: errors.New("ollama response missing embedding")}
+	}
+	if decoded.Model != "" {
+		model = decoded.Model
+	}
+	meta := &ProviderMeta{Provider: ProviderOllama, Model: model}
+	if decoded.PromptEvalCount > 0 {
+		meta.Usage = &TokenUsage{PromptTokens: decoded.PromptEvalCount, TotalTokens: decoded.PromptEvalCount}
+	}
+	logging.Debugf("ollama embedding success profile=%s model=%s", profile.ID, model)
+	return embeddingResponse(text, decoded.Embeddings[0], meta), nil
+}
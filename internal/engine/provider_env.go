@@ -5,4 +5,23 @@ const (
 	OllamaBaseURLEnvVar = "PIPELINE_ENGINE_OLLAMA_BASE_URL"
 	OllamaModelEnvVar   = "PIPELINE_ENGINE_OLLAMA_MODEL"
 	OllamaEnableEnvVar  = "PIPELINE_ENGINE_ENABLE_OLLAMA"
+
+	ProviderIOLogEnvVar   = "PIPELINE_ENGINE_LOG_PROVIDER_IO"
+	LogRedactFieldsEnvVar = "PIPELINE_ENGINE_LOG_REDACT_FIELDS"
+
+	JobTTLEnvVar           = "PIPELINE_ENGINE_JOB_TTL"
+	JobSweepIntervalEnvVar = "PIPELINE_ENGINE_JOB_SWEEP_INTERVAL"
+
+	StrictPipelinesEnvVar        = "PIPELINE_ENGINE_STRICT_PIPELINES"
+	CancelOnDisconnectEnvVar     = "PIPELINE_ENGINE_CANCEL_ON_DISCONNECT"
+	DisableDefaultProfilesEnvVar = "PIPELINE_ENGINE_DISABLE_DEFAULT_PROFILES"
+
+	ProfileStorePathEnvVar   = "PIPELINE_ENGINE_PROFILE_STORE"
+	ProfileStoreSecretEnvVar = "PIPELINE_ENGINE_PROFILE_SECRET"
+
+	ExportDirEnvVar = "PIPELINE_ENGINE_EXPORT_DIR"
+
+	WebhookAllowedHostsEnvVar = "PIPELINE_ENGINE_WEBHOOK_ALLOWED_HOSTS"
+
+	OrphanPolicyEnvVar = "PIPELINE_ENGINE_ORPHAN_POLICY"
 )
@@ -0,0 +1,205 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultProviderTimeout bounds a single provider HTTP call.
+	defaultProviderTimeout = 30 * time.Second
+	// providerTimeoutExtraKey overrides the timeout per profile, in milliseconds.
+	providerTimeoutExtraKey = "timeout_ms"
+	// providerConnectTimeoutExtraKey bounds the wait for response headers
+	// (dial, TLS, request upload and time to first byte), in milliseconds.
+	providerConnectTimeoutExtraKey = "connect_timeout_ms"
+	// providerIdleTimeoutExtraKey bounds the gap between two reads of the
+	// response body, in milliseconds.
+	providerIdleTimeoutExtraKey = "idle_timeout_ms"
+)
+
+// providerTimeouts is the timeout set of one profile. total is the
+// http.Client Timeout; connect and idle are enforced by timeoutRoundTripper
+// and are disabled when zero.
+type providerTimeouts struct {
+	total   time.Duration
+	connect time.Duration
+	idle    time.Duration
+}
+
+// providerHTTPClients hands out http.Clients that share one transport so
+// connection pools, proxy and TLS settings are reused across provider calls.
+// Clients are cached per timeout set since the factories run on every resolve.
+type providerHTTPClients struct {
+	transport      http.RoundTripper
+	mu             sync.Mutex
+	clients        map[providerTimeouts]*http.Client
+	defaultTimeout time.Duration
+}
+
+func newProviderHTTPClients(transport *http.Transport) *providerHTTPClients {
+	var rt http.RoundTripper = transport
+	if transport == nil {
+		rt = http.DefaultTransport
+	}
+	return &providerHTTPClients{transport: rt, clients: map[providerTimeouts]*http.Client{}, defaultTimeout: defaultProviderTimeout}
+}
+
+// setDefaultTimeout changes the timeout for profiles without timeout_ms.
+// Providers resolved afterwards pick up the new value.
+func (c *providerHTTPClients) setDefaultTimeout(timeout time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.defaultTimeout = timeout
+}
+
+func (c *providerHTTPClients) forProfile(profile ProviderProfile) *http.Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	timeouts := providerTimeouts{total: c.defaultTimeout}
+	if ms, ok := extraInt(profile.Extra, providerTimeoutExtraKey); ok && ms > 0 {
+		timeouts.total = time.Duration(ms) * time.Millisecond
+	}
+	if ms, ok := extraInt(profile.Extra, providerConnectTimeoutExtraKey); ok && ms > 0 {
+		timeouts.connect = time.Duration(ms) * time.Millisecond
+	}
+	if ms, ok := extraInt(profile.Extra, providerIdleTimeoutExtraKey); ok && ms > 0 {
+		timeouts.idle = time.Duration(ms) * time.Millisecond
+	}
+	client, ok := c.clients[timeouts]
+	if !ok {
+		transport := c.transport
+		if timeouts.connect > 0 || timeouts.idle > 0 {
+			transport = &timeoutRoundTripper{base: c.transport, connect: timeouts.connect, idle: timeouts.idle}
+		}
+		client = &http.Client{Transport: transport, Timeout: timeouts.total}
+		c.clients[timeouts] = client
+	}
+	return client
+}
+
+// providerTimeoutError reports that a provider exceeded its connect or idle
+// timeout. It deliberately does not wrap context.Canceled so the failure is
+// classified as a retryable network error rather than a cancellation.
+type providerTimeoutError struct {
+	phase string
+	after time.Duration
+}
+
+func (e *providerTimeoutError) Error() string {
+	return fmt.Sprintf("provider %s timeout after %s", e.phase, e.after)
+}
+
+// Timeout reports true, matching net.Error.
+func (e *providerTimeoutError) Timeout() bool { return true }
+
+// timeoutRoundTripper enforces the per-phase timeouts on top of the client's
+// total Timeout, so a long streamed generation can keep a generous total
+// while a server that stalls before or during the response fails fast.
+type timeoutRoundTripper struct {
+	base    http.RoundTripper
+	connect time.Duration
+	idle    time.Duration
+}
+
+func (t *timeoutRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	var fired atomic.Bool
+	var timer *time.Timer
+	if t.connect > 0 {
+		timer = time.AfterFunc(t.connect, func() {
+			fired.Store(true)
+			cancel()
+		})
+	}
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if timer != nil {
+		timer.Stop()
+	}
+	if fired.Load() {
+		cancel()
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return nil, &providerTimeoutError{phase: "connect", after: t.connect}
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = newIdleTimeoutBody(resp.Body, t.idle, cancel)
+	return resp, nil
+}
+
+// idleTimeoutBody cancels the request when no body bytes arrive for idle,
+// and releases the request context on Close.
+type idleTimeoutBody struct {
+	io.ReadCloser
+	idle   time.Duration
+	cancel context.CancelFunc
+	timer  *time.Timer
+	fired  atomic.Bool
+}
+
+func newIdleTimeoutBody(body io.ReadCloser, idle time.Duration, cancel context.CancelFunc) *idleTimeoutBody {
+	b := &idleTimeoutBody{ReadCloser: body, idle: idle, cancel: cancel}
+	if idle > 0 {
+		b.timer = time.AfterFunc(idle, func() {
+			b.fired.Store(true)
+			cancel()
+		})
+	}
+	return b
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if b.fired.Load() {
+		return n, &providerTimeoutError{phase: "idle", after: b.idle}
+	}
+	if b.timer != nil && n > 0 {
+		b.timer.Reset(b.idle)
+	}
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// providerCallError classifies a failed provider request. When ctx has ended
+// the call was abandoned by its caller (job cancellation or a step timeout),
+// so ctx's error is returned instead of a ProviderNetworkError: it is then
+// recorded as cancelled or timeout rather than as a provider failure, and not
+// retried.
+func providerCallError(ctx context.Context, kind ProviderKind, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return fmt.Errorf("%s call abandoned: %w", kind, ctxErr)
+	}
+	return &ProviderNetworkError{Kind: kind, Err: err}
+}
+
+// providerBodyError classifies a failure to read a provider response body:
+// an abandoned call as providerCallError does, an idle timeout as a
+// (retryable) network failure, anything else as a decode error.
+func providerBodyError(ctx context.Context, kind ProviderKind, err error) error {
+	if ctx.Err() != nil {
+		return providerCallError(ctx, kind, err)
+	}
+	var timeoutErr *providerTimeoutError
+	if errors.As(err, &timeoutErr) {
+		return &ProviderNetworkError{Kind: kind, Err: err}
+	}
+	return &ProviderDecodeError{Kind: kind, Err: err}
+}
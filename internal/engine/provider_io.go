@@ -0,0 +1,36 @@
+package engine
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+
+	"github.com/example/pipeline-engine/pkg/logging"
+)
+
+// maxLoggedBodyBytes caps how much of a provider response is buffered for logging.
+const maxLoggedBodyBytes = 64 << 10
+
+// logProviderRequest logs the outgoing provider request with credentials and
+// configured fields redacted. It is a no-op unless provider I/O logging is on.
+func logProviderRequest(kind ProviderKind, profileID ProviderProfileID, req *http.Request, body []byte) {
+	if !logging.ProviderIOEnabled() {
+		return
+	}
+	logging.Debugf("%s request profile=%s url=%s%s headers=%v body=%s", kind, profileID, req.URL.Host, req.URL.Path, logging.RedactHeaders(req.Header), logging.RedactJSON(body))
+}
+
+// logProviderResponse logs the provider response and returns a reader that
+// yields the same body for decoding.
+func logProviderResponse(kind ProviderKind, profileID ProviderProfileID, resp *http.Response) io.Reader {
+	if !logging.ProviderIOEnabled() {
+		return resp.Body
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxLoggedBodyBytes))
+	if err != nil {
+		logging.Debugf("%s response profile=%s status=%s body read error=%v", kind, profileID, resp.Status, err)
+	} else {
+		logging.Debugf("%s response profile=%s status=%s headers=%v body=%s", kind, profileID, resp.Status, logging.RedactHeaders(resp.Header), logging.RedactJSON(body))
+	}
+	return io.MultiReader(bytes.NewReader(body), resp.Body)
+}
@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -13,7 +12,8 @@ import (
 	"github.com/example/pipeline-engine/pkg/logging"
 )
 
-// OllamaProvider calls a local Ollama HTTP endpoint.
+// OllamaProvider calls a local Ollama HTTP endpoint: /api/generate, or
+// /api/embed for steps with an embedding output type.
 type OllamaProvider struct {
 	profile ProviderProfile
 	client  httpDoer
@@ -27,7 +27,7 @@ func (p *OllamaProvider) httpClient() httpDoer {
 	if p.client != nil {
 		return p.client
 	}
-	return &http.Client{Timeout: 30 * time.Second}
+	return &http.Client{Timeout: defaultProviderTimeout}
 }
 
 type ollamaRequest struct {
@@ -39,9 +39,12 @@ type ollamaRequest struct {
 }
 
 type ollamaResponse struct {
-	Response string `json:"response"`
-	Model    string `json:"model"`
-	Done     bool   `json:"done"`
+	Response        string `json:"response"`
+	Model           string `json:"model"`
+	Done            bool   `json:"done"`
+	DoneReason      string `json:"done_reason"`
+	PromptEvalCount int    `json:"prompt_eval_count"`
+	EvalCount       int    `json:"eval_count"`
 }
 
 func callOllama(ctx context.Context, req ProviderRequest, profile ProviderProfile, client httpDoer) (ProviderResponse, error) {
@@ -53,9 +56,12 @@ func callOllama(ctx context.Context, req ProviderRequest, profile ProviderProfil
 	if base == "" {
 		base = "http://127.0.0.1:11434"
 	}
+	if wantsEmbedding(req.Step) {
+		return callOllamaEmbedding(ctx, req, profile, client, base)
+	}
 	url := strings.TrimRight(base, "/") + "/api/generate"
 
-	prompt := req.Prompt
+	prompt := foldHistory(req.Input.History, req.Prompt)
 	reqPayload := ollamaRequest{Model: model, Prompt: prompt, Stream: false}
 	if req.Profile.Extra != nil {
 		if sys, ok := req.Profile.Extra["system_prompt"].(string); ok && sys != "" {
@@ -78,34 +84,41 @@ func callOllama(ctx context.Context, req ProviderRequest, profile ProviderProfil
 	httpReq.Header.Set("Content-Type", "application/json")
 
 	logging.Debugf("ollama call start profile=%s model=%s", profile.ID, model)
+	logProviderRequest(ProviderOllama, profile.ID, httpReq, body)
 	resp, err := client.Do(httpReq)
 	if err != nil {
 		logging.Errorf("ollama call error profile=%s err=%v", profile.ID, err)
-		return ProviderResponse{}, err
+		return ProviderResponse{}, providerCallError(ctx, ProviderOllama, err)
 	}
 	defer resp.Body.Close()
+	respBody := logProviderResponse(ProviderOllama, profile.ID, resp)
 
 	if resp.StatusCode >= 400 {
 		err := fmt.Errorf("ollama api error: %s", resp.Status)
 		logging.Errorf("ollama call failed profile=%s err=%v", profile.ID, err)
-		return ProviderResponse{}, err
+		return ProviderResponse{}, &ProviderAPIError{Kind: ProviderOllama, StatusCode: resp.StatusCode, RetryAfter: parseRetryAfter(resp.Header, time.Now()), Err: err}
 	}
 
 	var decoded ollamaResponse
-	if err := json.NewDecoder(resp.Body).Decode(&decoded); err != nil {
-		return ProviderResponse{}, err
-	}
-	if decoded.Response == "" {
-		return ProviderResponse{}, errors.New("ollama response is empty")
+	if err := json.NewDecoder(respBody).Decode(&decoded); err != nil {
+		return ProviderResponse{}, providerBodyError(ctx, ProviderOllama, fmt.Errorf("decode ollama response: %w", err))
 	}
 	modelName := decoded.Model
 	if modelName == "" {
 		modelName = model
 	}
-	meta := map[string]any{
-		"provider": "ollama",
-		"model":    modelName,
+	meta := &ProviderMeta{
+		Provider:     ProviderOllama,
+		Model:        modelName,
+		FinishReason: decoded.DoneReason,
+	}
+	if decoded.PromptEvalCount > 0 || decoded.EvalCount > 0 {
+		meta.Usage = &TokenUsage{
+			PromptTokens:     decoded.PromptEvalCount,
+			CompletionTokens: decoded.EvalCount,
+			TotalTokens:      decoded.PromptEvalCount + decoded.EvalCount,
+		}
 	}
-    logging.Debugf("ollama call success profile=%s model=%s", profile.ID, modelName)
-    return ProviderResponse{Output: decoded.Response, Metadata: meta, Chunks: buildChunksFromText(decoded.Response)}, nil
+	logging.Debugf("ollama call success profile=%s model=%s", profile.ID, modelName)
+	return ProviderResponse{Output: decoded.Response, Meta: meta, Chunks: buildChunksFromText(decoded.Response)}, nil
 }
@@ -6,7 +6,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"strings"
 	"time"
@@ -14,7 +16,8 @@ import (
 	"github.com/example/pipeline-engine/pkg/logging"
 )
 
-// OpenAIProvider calls the OpenAI chat completions API.
+// OpenAIProvider calls the OpenAI chat completions API, or the embeddings API
+// for steps with an embedding output type.
 type OpenAIProvider struct {
 	profile ProviderProfile
 	client  httpDoer
@@ -28,26 +31,324 @@ func (p *OpenAIProvider) httpClient() httpDoer {
 	if p.client != nil {
 		return p.client
 	}
-	return &http.Client{Timeout: 30 * time.Second}
+	return &http.Client{Timeout: defaultProviderTimeout}
 }
 
 type openAIRequest struct {
 	Model       string          `json:"model"`
 	Messages    []openAIMessage `json:"messages"`
 	Temperature float64         `json:"temperature"`
+	Tools       []openAITool    `json:"tools,omitempty"`
+	Seed        *int64          `json:"seed,omitempty"`
+	// ResponseFormat requests native structured output for json_strict
+	// steps; see openAIResponseFormatFor.
+	ResponseFormat *openAIResponseFormat `json:"response_format,omitempty"`
+}
+
+type openAIResponseFormat struct {
+	Type       string            `json:"type"`
+	JSONSchema *openAIJSONSchema `json:"json_schema,omitempty"`
+}
+
+type openAIJSONSchema struct {
+	Name   string         `json:"name"`
+	Schema map[string]any `json:"schema"`
+	Strict bool           `json:"strict"`
+}
+
+// openAIResponseFormatFor returns the response_format of a json_strict step:
+// a strict json_schema when the step declares OutputSchema, JSON mode
+// (json_object) otherwise. Profiles whose models support neither set
+// Extra["response_format"] to false and rely on post-hoc extraction.
+func openAIResponseFormatFor(step StepDef, profile ProviderProfile) *openAIResponseFormat {
+	if step.OutputFormat != OutputFormatJSONStrict {
+		return nil
+	}
+	if enabled, ok := profile.Extra["response_format"].(bool); ok && !enabled {
+		return nil
+	}
+	if len(step.OutputSchema) == 0 {
+		return &openAIResponseFormat{Type: "json_object"}
+	}
+	return &openAIResponseFormat{Type: "json_schema", JSONSchema: &openAIJSONSchema{Name: openAISchemaName(step.ID), Schema: step.OutputSchema, Strict: true}}
+}
+
+// openAISchemaName derives a json_schema name, which OpenAI limits to 64
+// letters, digits, '_' and '-', from the step ID.
+func openAISchemaName(id StepID) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '_' || r == '-' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') {
+			return r
+		}
+		return '_'
+	}, string(id))
+	if name == "" {
+		name = "output"
+	}
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
+
+// unsupportedResponseFormat reports whether a 400 response rejects the
+// request's response_format, as models without structured output support
+// and JSON mode prompts lacking the word "json" do.
+func unsupportedResponseFormat(status int, body io.Reader) bool {
+	if status != http.StatusBadRequest {
+		return false
+	}
+	raw, _ := io.ReadAll(io.LimitReader(body, 4096))
+	return bytes.Contains(bytes.ToLower(raw), []byte("response_format"))
 }
 
 type openAIMessage struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
+	// ImageURLs are sent as image_url content parts after the text, which
+	// vision-capable models accept.
+	ImageURLs []string `json:"-"`
+	// ToolCalls is set on assistant messages that requested tools, and
+	// ToolCallID on the tool messages answering them.
+	ToolCalls  []openAIToolCall `json:"-"`
+	ToolCallID string           `json:"-"`
+}
+
+type openAITool struct {
+	Type     string             `json:"type"`
+	Function openAIToolFunction `json:"function"`
+}
+
+type openAIToolFunction struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Parameters  map[string]any `json:"parameters,omitempty"`
+}
+
+type openAIToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+func openAITools(tools []ToolDef) []openAITool {
+	if len(tools) == 0 {
+		return nil
+	}
+	converted := make([]openAITool, 0, len(tools))
+	for _, tool := range tools {
+		converted = append(converted, openAITool{
+			Type:     "function",
+			Function: openAIToolFunction{Name: tool.Name, Description: tool.Description, Parameters: tool.Parameters},
+		})
+	}
+	return converted
+}
+
+// appendOpenAIToolTurns replays earlier tool-calling rounds: the assistant
+// message with its tool_calls followed by one tool message per result.
+func appendOpenAIToolTurns(messages []openAIMessage, turns []ToolTurn) []openAIMessage {
+	for _, turn := range turns {
+		assistant := openAIMessage{Role: "assistant", Content: turn.Content}
+		for _, call := range turn.Calls {
+			tc := openAIToolCall{ID: call.ID, Type: "function"}
+			tc.Function.Name = call.Name
+			tc.Function.Arguments = call.Arguments
+			assistant.ToolCalls = append(assistant.ToolCalls, tc)
+		}
+		messages = append(messages, assistant)
+		for _, result := range turn.Results {
+			messages = append(messages, openAIMessage{Role: "tool", Content: result.Content, ToolCallID: result.CallID})
+		}
+	}
+	return messages
+}
+
+type openAIContentPart struct {
+	Type     string             `json:"type"`
+	Text     string             `json:"text,omitempty"`
+	ImageURL *openAIImageURLRef `json:"image_url,omitempty"`
+}
+
+type openAIImageURLRef struct {
+	URL string `json:"url"`
+}
+
+// MarshalJSON keeps the plain string content unless images are attached, in
+// which case content becomes an array of text and image_url parts.
+func (m openAIMessage) MarshalJSON() ([]byte, error) {
+	if len(m.ImageURLs) == 0 {
+		return json.Marshal(struct {
+			Role       string           `json:"role"`
+			Content    string           `json:"content"`
+			ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+			ToolCallID string           `json:"tool_call_id,omitempty"`
+		}{m.Role, m.Content, m.ToolCalls, m.ToolCallID})
+	}
+	parts := make([]openAIContentPart, 0, len(m.ImageURLs)+1)
+	if m.Content != "" {
+		parts = append(parts, openAIContentPart{Type: "text", Text: m.Content})
+	}
+	for _, u := range m.ImageURLs {
+		parts = append(parts, openAIContentPart{Type: "image_url", ImageURL: &openAIImageURLRef{URL: u}})
+	}
+	return json.Marshal(struct {
+		Role    string              `json:"role"`
+		Content []openAIContentPart `json:"content"`
+	}{m.Role, parts})
+}
+
+// attachOpenAIImages adds image sources to the last user message, appending a
+// user message when the conversation has none.
+func attachOpenAIImages(messages []openAIMessage, sources []Source) []openAIMessage {
+	var urls []string
+	for _, src := range sources {
+		if src.IsImage() {
+			urls = append(urls, src.DataURL())
+		}
+	}
+	if len(urls) == 0 {
+		return messages
+	}
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			messages[i].ImageURLs = append(messages[i].ImageURLs, urls...)
+			return messages
+		}
+	}
+	return append(messages, openAIMessage{Role: "user", ImageURLs: urls})
 }
 
 type openAIResponse struct {
 	Choices []struct {
 		Message struct {
-			Content string `json:"content"`
+			Content   string           `json:"content"`
+			Refusal   string           `json:"refusal"`
+			ToolCalls []openAIToolCall `json:"tool_calls"`
 		} `json:"message"`
+		FinishReason string `json:"finish_reason"`
 	} `json:"choices"`
+	Model string      `json:"model"`
+	Usage *TokenUsage `json:"usage"`
+}
+
+// buildOpenAIMessages maps rendered prompt messages onto the chat messages
+// array. When the declared messages contain no user turn, the plain prompt is
+// inserted as one ahead of any trailing assistant prefill.
+func buildOpenAIMessages(req ProviderRequest) []openAIMessage {
+	if len(req.Input.Messages) == 0 {
+		return []openAIMessage{{Role: "user", Content: req.Prompt}}
+	}
+	messages := make([]openAIMessage, 0, len(req.Input.Messages)+1)
+	hasUser := false
+	for _, msg := range req.Input.Messages {
+		if msg.Role == "user" {
+			hasUser = true
+		}
+		messages = append(messages, openAIMessage{Role: msg.Role, Content: msg.Content})
+	}
+	if hasUser || req.Prompt == "" {
+		return messages
+	}
+	at := len(messages)
+	for at > 0 && messages[at-1].Role == "assistant" {
+		at--
+	}
+	messages = append(messages[:at], append([]openAIMessage{{Role: "user", Content: req.Prompt}}, messages[at:]...)...)
+	return messages
+}
+
+// prependOpenAIHistory inserts earlier conversation turns after any leading
+// system messages, so the step's own turn stays last.
+func prependOpenAIHistory(messages []openAIMessage, history []ChatMessage) []openAIMessage {
+	if len(history) == 0 {
+		return messages
+	}
+	at := 0
+	for at < len(messages) && messages[at].Role == "system" {
+		at++
+	}
+	out := make([]openAIMessage, 0, len(messages)+len(history))
+	out = append(out, messages[:at]...)
+	for _, msg := range history {
+		out = append(out, openAIMessage{Role: msg.Role, Content: msg.Content})
+	}
+	return append(out, messages[at:]...)
+}
+
+const defaultOpenAIPathTemplate = "/chat/completions"
+
+// openAIEndpoint builds the chat completions URL. Profile Extra keys adapt it
+// to OpenAI-compatible servers: path_template replaces the path appended to
+// the base URI ({model} expands to the escaped model or Azure deployment
+// name) and api_version adds the api-version query parameter.
+func openAIEndpoint(profile ProviderProfile, model string) (string, error) {
+	return openAIEndpointFor(profile, model, "path_template", defaultOpenAIPathTemplate)
+}
+
+// openAIEndpointFor builds an endpoint URL whose path is defaultPath or the
+// template in Extra[templateKey].
+func openAIEndpointFor(profile ProviderProfile, model, templateKey, defaultPath string) (string, error) {
+	base := profile.BaseURI
+	if base == "" {
+		base = "https://api.openai.com/v1"
+	}
+	path := defaultPath
+	if tmpl, ok := profile.Extra[templateKey].(string); ok && strings.TrimSpace(tmpl) != "" {
+		path = strings.ReplaceAll(strings.TrimSpace(tmpl), "{model}", url.PathEscape(model))
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+	}
+	endpoint := strings.TrimRight(base, "/") + path
+	version, _ := profile.Extra["api_version"].(string)
+	if version = strings.TrimSpace(version); version == "" {
+		return endpoint, nil
+	}
+	parsed, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("openai endpoint %q: %w", endpoint, err)
+	}
+	query := parsed.Query()
+	query.Set("api-version", version)
+	parsed.RawQuery = query.Encode()
+	return parsed.String(), nil
+}
+
+// setOpenAIAuth sends the API key as a bearer token, or verbatim in the header
+// named by Extra["auth_header"] (e.g. "api-key" for Azure OpenAI).
+func setOpenAIAuth(header http.Header, profile ProviderProfile, apiKey string) {
+	name, _ := profile.Extra["auth_header"].(string)
+	name = strings.TrimSpace(name)
+	if name == "" || strings.EqualFold(name, "Authorization") {
+		header.Set("Authorization", "Bearer "+apiKey)
+		return
+	}
+	header.Set(name, apiKey)
+}
+
+// sendOpenAIRequest posts payload to endpoint and returns the response with
+// a reader over its (logged) body. The caller closes resp.Body.
+func sendOpenAIRequest(ctx context.Context, client httpDoer, endpoint string, profile ProviderProfile, apiKey string, payload any) (*http.Response, io.Reader, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	setOpenAIAuth(httpReq.Header, profile, apiKey)
+	httpReq.Header.Set("Content-Type", "application/json")
+	logProviderRequest(ProviderOpenAI, profile.ID, httpReq, body)
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, logProviderResponse(ProviderOpenAI, profile.ID, resp), nil
 }
 
 func callOpenAI(ctx context.Context, req ProviderRequest, profile ProviderProfile, client httpDoer) (ProviderResponse, error) {
@@ -62,56 +363,82 @@ func callOpenAI(ctx context.Context, req ProviderRequest, profile ProviderProfil
 	if apiKey == "" {
 		return ProviderResponse{}, errors.New("openai api key is not configured")
 	}
-	base := profile.BaseURI
-	if base == "" {
-		base = "https://api.openai.com/v1"
+	if wantsEmbedding(req.Step) {
+		return callOpenAIEmbedding(ctx, req, profile, client, apiKey)
 	}
-	url := strings.TrimRight(base, "/") + "/chat/completions"
-
-	messages := []openAIMessage{{Role: "user", Content: req.Prompt}}
-	if sys, ok := req.Profile.Extra["system_prompt"].(string); ok && sys != "" {
-		messages = append([]openAIMessage{{Role: "system", Content: sys}}, messages...)
-	}
-	payload := openAIRequest{Model: model, Messages: messages, Temperature: 0}
-	body, err := json.Marshal(payload)
+	endpoint, err := openAIEndpoint(profile, model)
 	if err != nil {
 		return ProviderResponse{}, err
 	}
 
-	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
-	if err != nil {
-		return ProviderResponse{}, err
+	messages := attachOpenAIImages(buildOpenAIMessages(req), req.Input.Sources)
+	messages = prependOpenAIHistory(messages, req.Input.History)
+	if sys, ok := req.Profile.Extra["system_prompt"].(string); ok && sys != "" {
+		messages = append([]openAIMessage{{Role: "system", Content: sys}}, messages...)
+	}
+	messages = appendOpenAIToolTurns(messages, req.Input.ToolTurns)
+	payload := openAIRequest{
+		Model:          model,
+		Messages:       messages,
+		Temperature:    0,
+		Tools:          openAITools(req.Step.Tools),
+		Seed:           req.Input.Options.seed(),
+		ResponseFormat: openAIResponseFormatFor(req.Step, req.Profile),
 	}
-	httpReq.Header.Set("Authorization", "Bearer "+apiKey)
-	httpReq.Header.Set("Content-Type", "application/json")
 
 	logging.Debugf("openai call start profile=%s model=%s", profile.ID, model)
-	resp, err := client.Do(httpReq)
+	resp, respBody, err := sendOpenAIRequest(ctx, client, endpoint, profile, apiKey, payload)
+	if err == nil && payload.ResponseFormat != nil && unsupportedResponseFormat(resp.StatusCode, respBody) {
+		// The engine still extracts and checks the JSON after the call.
+		resp.Body.Close()
+		logging.Warnf("openai profile=%s model=%s rejected response_format %s, retrying without it", profile.ID, model, payload.ResponseFormat.Type)
+		payload.ResponseFormat = nil
+		resp, respBody, err = sendOpenAIRequest(ctx, client, endpoint, profile, apiKey, payload)
+	}
 	if err != nil {
 		logging.Errorf("openai call error profile=%s err=%v", profile.ID, err)
-		return ProviderResponse{}, err
+		return ProviderResponse{}, providerCallError(ctx, ProviderOpenAI, err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode >= 400 {
 		err := fmt.Errorf("openai api error: %s", resp.Status)
 		logging.Errorf("openai call failed profile=%s err=%v", profile.ID, err)
-		return ProviderResponse{}, err
+		return ProviderResponse{}, &ProviderAPIError{Kind: ProviderOpenAI, StatusCode: resp.StatusCode, RetryAfter: parseRetryAfter(resp.Header, time.Now()), Err: err}
 	}
 
 	var decoded openAIResponse
-	if err := json.NewDecoder(resp.Body).Decode(&decoded); err != nil {
-		return ProviderResponse{}, err
+	if err := json.NewDecoder(respBody).Decode(&decoded); err != nil {
+		return ProviderResponse{}, providerBodyError(ctx, ProviderOpenAI, fmt.Errorf("decode openai response: %w", err))
 	}
 	if len(decoded.Choices) == 0 {
-		return ProviderResponse{}, errors.New("openai response missing choices")
+		return ProviderResponse{}, &ProviderDecodeError{Kind: ProviderOpenAI, Err: errors.New("openai response missing choices")}
 	}
 
-	text := decoded.Choices[0].Message.Content
-	meta := map[string]any{
-		"provider": "openai",
-		"model":    model,
+	message := decoded.Choices[0].Message
+	text := message.Content
+	finishReason := decoded.Choices[0].FinishReason
+	meta := &ProviderMeta{
+		Provider:     ProviderOpenAI,
+		Model:        model,
+		FinishReason: finishReason,
+		Usage:        decoded.Usage,
+		Seed:         payload.Seed,
+	}
+	// A refusal replaces the content; a content filter stop may leave none.
+	if message.Refusal != "" || (finishReason == "content_filter" && text == "" && len(message.ToolCalls) == 0) {
+		logging.Warnf("openai call refused profile=%s model=%s finish_reason=%s", profile.ID, model, finishReason)
+		return ProviderResponse{Meta: meta}, &ProviderRefusalError{Kind: ProviderOpenAI, Refusal: message.Refusal, FinishReason: finishReason}
+	}
+	logging.Debugf("openai call success profile=%s model=%s", profile.ID, model)
+	if len(message.ToolCalls) > 0 {
+		calls := make([]ToolCall, 0, len(message.ToolCalls))
+		for _, tc := range message.ToolCalls {
+			calls = append(calls, ToolCall{ID: tc.ID, Name: tc.Function.Name, Arguments: tc.Function.Arguments})
+		}
+		// Intermediate rounds are not streamed as chunks; only the final
+		// answer is.
+		return ProviderResponse{Output: text, Meta: meta, ToolCalls: calls}, nil
 	}
-    logging.Debugf("openai call success profile=%s model=%s", profile.ID, model)
-    return ProviderResponse{Output: text, Metadata: meta, Chunks: buildChunksFromText(text)}, nil
+	return ProviderResponse{Output: text, Meta: meta, Chunks: buildChunksFromText(text)}, nil
 }
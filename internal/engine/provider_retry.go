@@ -0,0 +1,109 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/example/pipeline-engine/pkg/logging"
+)
+
+// ProviderRetriesConfigKey sets how many times a step retries a retryable
+// provider failure (network error, 429 or 5xx) on the same profile before
+// failing over to its fallbacks. Zero, the default, fails over at once.
+const ProviderRetriesConfigKey = "provider_retries"
+
+// ProviderRetryBackoffConfigKey sets the base delay in milliseconds of the
+// exponential backoff between provider retries.
+const ProviderRetryBackoffConfigKey = "provider_retry_backoff_ms"
+
+const (
+	defaultProviderRetryBackoff = 500 * time.Millisecond
+	// maxProviderRetryDelay bounds backoff delays. A provider asking for a
+	// longer Retry-After is not retried, so the step fails over instead.
+	maxProviderRetryDelay = 30 * time.Second
+)
+
+// retryProviderCall runs call, retrying retryable provider errors up to the
+// step's provider_retries. Each retry waits the delay the provider advised
+// with Retry-After, or an exponential backoff otherwise, both with jitter so
+// parallel shards rate limited together do not retry in lockstep. The number
+// of retries a successful call needed is returned with it.
+func retryProviderCall(ctx context.Context, step StepDef, profile ProviderProfile, call func() (ProviderResponse, error)) (ProviderResponse, int, error) {
+	retries, _ := step.ConfigInt(ProviderRetriesConfigKey)
+	base := defaultProviderRetryBackoff
+	if ms, ok := step.ConfigInt(ProviderRetryBackoffConfigKey); ok && ms > 0 {
+		base = time.Duration(ms) * time.Millisecond
+	}
+	for attempt := 1; ; attempt++ {
+		resp, err := call()
+		if err == nil || attempt > retries || !IsRetryableProviderError(err) || ctx.Err() != nil {
+			return resp, attempt - 1, err
+		}
+		delay, ok := providerRetryDelay(err, attempt, base)
+		if !ok {
+			return resp, attempt - 1, err
+		}
+		logging.Warnf("step %s: provider %s failed (%v), retrying in %s (%d/%d)", step.ID, profile.ID, err, delay, attempt, retries)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return resp, attempt - 1, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// providerRetryDelay returns how long to wait before retry attempt (1-based)
+// after err. An advised Retry-After is waited in full plus up to 10% jitter;
+// otherwise the delay is base doubled per attempt, of which a random half is
+// jitter. ok is false when the advised delay exceeds maxProviderRetryDelay.
+func providerRetryDelay(err error, attempt int, base time.Duration) (time.Duration, bool) {
+	var apiErr *ProviderAPIError
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+		if apiErr.RetryAfter > maxProviderRetryDelay {
+			return 0, false
+		}
+		return apiErr.RetryAfter + jitter(apiErr.RetryAfter/10), true
+	}
+	delay := base << (attempt - 1)
+	if delay <= 0 || delay > maxProviderRetryDelay {
+		delay = maxProviderRetryDelay
+	}
+	return delay/2 + jitter(delay/2), true
+}
+
+func jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int64N(int64(max) + 1))
+}
+
+// parseRetryAfter returns the delay a rate limited or unavailable response
+// asks for: OpenAI's retry-after-ms, or the standard Retry-After in seconds
+// or as an HTTP date relative to now. It is zero when neither is usable.
+func parseRetryAfter(header http.Header, now time.Time) time.Duration {
+	if ms, err := strconv.ParseFloat(strings.TrimSpace(header.Get("Retry-After-Ms")), 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+	value := strings.TrimSpace(header.Get("Retry-After"))
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds <= 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
@@ -1,11 +1,20 @@
 package engine
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/example/pipeline-engine/pkg/logging"
 )
 
 func TestOpenAIProviderCall(t *testing.T) {
@@ -13,7 +22,7 @@ func TestOpenAIProviderCall(t *testing.T) {
 		if got := r.Header.Get("Authorization"); got != "Bearer test-key" {
 			t.Fatalf("unexpected auth header: %s", got)
 		}
-		_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"hello"}}]}`))
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"hello"},"finish_reason":"stop"}],"usage":{"prompt_tokens":3,"completion_tokens":1,"total_tokens":4}}`))
 	}))
 	defer sr.Close()
 
@@ -27,6 +36,314 @@ func TestOpenAIProviderCall(t *testing.T) {
 	if resp.Output != "hello" {
 		t.Fatalf("unexpected output: %s", resp.Output)
 	}
+	meta := resp.Meta
+	if meta == nil || meta.Provider != ProviderOpenAI || meta.Model != "gpt-test" || meta.FinishReason != "stop" {
+		t.Fatalf("unexpected meta: %+v", meta)
+	}
+	if meta.Usage == nil || meta.Usage.TotalTokens != 4 || meta.Usage.PromptTokens != 3 {
+		t.Fatalf("unexpected usage: %+v", meta.Usage)
+	}
+}
+
+func TestOpenAIProviderCallSendsSeed(t *testing.T) {
+	var payload map[string]any
+	sr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload = nil
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"seeded"}}]}`))
+	}))
+	defer sr.Close()
+
+	profile := ProviderProfile{ID: "openai", Kind: ProviderOpenAI, BaseURI: sr.URL, APIKey: "test-key"}
+	provider := &OpenAIProvider{profile: profile, client: sr.Client()}
+
+	seed := int64(42)
+	resp, err := provider.Call(context.Background(), ProviderRequest{Prompt: "hi", Profile: profile, Input: ProviderInput{Options: &JobOptions{Seed: &seed}}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if payload["seed"] != float64(42) {
+		t.Fatalf("seed not sent: %+v", payload)
+	}
+	if resp.Meta == nil || resp.Meta.Seed == nil || *resp.Meta.Seed != 42 {
+		t.Fatalf("seed not reported in meta: %+v", resp.Meta)
+	}
+
+	if _, err := provider.Call(context.Background(), ProviderRequest{Prompt: "hi", Profile: profile}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := payload["seed"]; ok {
+		t.Fatalf("seed should be omitted when unset: %+v", payload)
+	}
+}
+
+func TestProviderCallAbandonedByCaller(t *testing.T) {
+	release := make(chan struct{})
+	sr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer sr.Close()
+	defer close(release)
+
+	profile := ProviderProfile{ID: "openai", Kind: ProviderOpenAI, BaseURI: sr.URL, APIKey: "test-key"}
+	provider := &OpenAIProvider{profile: profile, client: sr.Client()}
+	call := func(ctx context.Context) error {
+		_, err := provider.Call(ctx, ProviderRequest{Prompt: "hi", Profile: profile})
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	err := call(ctx)
+	var netErr *ProviderNetworkError
+	if !errors.Is(err, context.Canceled) || errors.As(err, &netErr) {
+		t.Fatalf("cancelled call should return context.Canceled, not a network error: %v", err)
+	}
+	if code := stepErrorCode(err); code != "cancelled" || IsRetryableProviderError(err) {
+		t.Fatalf("unexpected classification of cancelled call: code=%s", code)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = call(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) {
+		t.Fatalf("timed out call should return context.DeadlineExceeded, not a network error: %v", err)
+	}
+	if code := stepErrorCode(err); code != "timeout" || IsRetryableProviderError(err) || stepErrorDetails(err) != nil {
+		t.Fatalf("unexpected classification of timed out call: code=%s", code)
+	}
+}
+
+func TestOpenAIProviderRequestsResponseFormat(t *testing.T) {
+	var payload map[string]any
+	sr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload = nil
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"{\"ok\":true}"}}]}`))
+	}))
+	defer sr.Close()
+
+	profile := ProviderProfile{ID: "openai", Kind: ProviderOpenAI, BaseURI: sr.URL, APIKey: "test-key"}
+	provider := &OpenAIProvider{profile: profile, client: sr.Client()}
+	schema := map[string]any{"type": "object", "properties": map[string]any{"ok": map[string]any{"type": "boolean"}}}
+	call := func(step StepDef, profile ProviderProfile) map[string]any {
+		t.Helper()
+		if _, err := provider.Call(context.Background(), ProviderRequest{Step: step, Prompt: "answer in json", Profile: profile}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		format, _ := payload["response_format"].(map[string]any)
+		return format
+	}
+
+	format := call(StepDef{ID: "classify.v1", OutputFormat: OutputFormatJSONStrict, OutputSchema: schema}, profile)
+	jsonSchema, _ := format["json_schema"].(map[string]any)
+	if format["type"] != "json_schema" || jsonSchema["name"] != "classify_v1" || jsonSchema["strict"] != true || jsonSchema["schema"] == nil {
+		t.Fatalf("unexpected json_schema response_format: %+v", format)
+	}
+	if format := call(StepDef{ID: "classify", OutputFormat: OutputFormatJSONStrict}, profile); format["type"] != "json_object" {
+		t.Fatalf("expected json_object without a schema: %+v", format)
+	}
+	if format := call(StepDef{ID: "classify", OutputFormat: OutputFormatJSONLoose, OutputSchema: schema}, profile); format != nil {
+		t.Fatalf("response_format is only for json_strict steps: %+v", format)
+	}
+	disabled := profile
+	disabled.Extra = map[string]any{"response_format": false}
+	if format := call(StepDef{ID: "classify", OutputFormat: OutputFormatJSONStrict, OutputSchema: schema}, disabled); format != nil {
+		t.Fatalf("response_format should be omitted when the profile disables it: %+v", format)
+	}
+}
+
+func TestOpenAIProviderRetriesWithoutUnsupportedResponseFormat(t *testing.T) {
+	var formats []any
+	sr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		formats = append(formats, payload["response_format"])
+		if payload["response_format"] != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":{"message":"Invalid parameter: 'response_format' of type 'json_schema' is not supported with this model.","param":"response_format"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"{\"ok\":true}"}}]}`))
+	}))
+	defer sr.Close()
+
+	profile := ProviderProfile{ID: "openai", Kind: ProviderOpenAI, BaseURI: sr.URL, APIKey: "test-key"}
+	provider := &OpenAIProvider{profile: profile, client: sr.Client()}
+	step := StepDef{ID: "classify", OutputFormat: OutputFormatJSONStrict, OutputSchema: map[string]any{"type": "object"}}
+	resp, err := provider.Call(context.Background(), ProviderRequest{Step: step, Prompt: "hi", Profile: profile})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Output != `{"ok":true}` || len(formats) != 2 || formats[1] != nil {
+		t.Fatalf("expected a retry without response_format, got output %q after %v", resp.Output, formats)
+	}
+}
+
+func TestOpenAIProviderCallAzureStyle(t *testing.T) {
+	sr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/openai/deployments/gpt-4o/chat/completions" {
+			t.Fatalf("unexpected path: %s", r.URL.Path)
+		}
+		if got := r.URL.Query().Get("api-version"); got != "2024-06-01" {
+			t.Fatalf("unexpected api-version: %q", got)
+		}
+		if got := r.Header.Get("api-key"); got != "azure-key" {
+			t.Fatalf("unexpected api-key header: %q", got)
+		}
+		if got := r.Header.Get("Authorization"); got != "" {
+			t.Fatalf("authorization header should not be sent: %q", got)
+		}
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"azure"}}]}`))
+	}))
+	defer sr.Close()
+
+	profile := ProviderProfile{
+		ID:           "azure",
+		Kind:         ProviderOpenAI,
+		BaseURI:      sr.URL,
+		APIKey:       "azure-key",
+		DefaultModel: "gpt-4o",
+		Extra: map[string]any{
+			"auth_header":   "api-key",
+			"api_version":   "2024-06-01",
+			"path_template": "/openai/deployments/{model}/chat/completions",
+		},
+	}
+	provider := &OpenAIProvider{profile: profile, client: sr.Client()}
+
+	resp, err := provider.Call(context.Background(), ProviderRequest{Prompt: "hi", Profile: profile})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Output != "azure" {
+		t.Fatalf("unexpected output: %s", resp.Output)
+	}
+}
+
+func TestOpenAIProviderCallSendsImageSources(t *testing.T) {
+	var payload struct {
+		Messages []struct {
+			Role    string          `json:"role"`
+			Content json.RawMessage `json:"content"`
+		} `json:"messages"`
+	}
+	sr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Fatalf("failed to decode request: %v", err)
+		}
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"a dot"}}]}`))
+	}))
+	defer sr.Close()
+
+	profile := ProviderProfile{ID: "openai", Kind: ProviderOpenAI, BaseURI: sr.URL, APIKey: "test-key", DefaultModel: "gpt-4o"}
+	provider := &OpenAIProvider{profile: profile, client: sr.Client()}
+	png := decodeTinyPNG(t)
+	_, err := provider.Call(context.Background(), ProviderRequest{
+		Prompt:  "describe",
+		Profile: profile,
+		Input: ProviderInput{Sources: []Source{
+			{Kind: SourceKindNote, Content: "memo"},
+			{Kind: SourceKindRaw, Data: png, MimeType: "image/png"},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(payload.Messages) != 1 || payload.Messages[0].Role != "user" {
+		t.Fatalf("unexpected messages: %+v", payload.Messages)
+	}
+	var parts []struct {
+		Type     string `json:"type"`
+		Text     string `json:"text"`
+		ImageURL struct {
+			URL string `json:"url"`
+		} `json:"image_url"`
+	}
+	if err := json.Unmarshal(payload.Messages[0].Content, &parts); err != nil {
+		t.Fatalf("content should be an array of parts: %s", payload.Messages[0].Content)
+	}
+	if len(parts) != 2 || parts[0].Type != "text" || parts[0].Text != "describe" {
+		t.Fatalf("unexpected text part: %+v", parts)
+	}
+	if parts[1].Type != "image_url" || parts[1].ImageURL.URL != "data:image/png;base64,"+tinyPNG {
+		t.Fatalf("unexpected image part: %+v", parts[1])
+	}
+}
+
+func TestOpenAIEndpointDefaults(t *testing.T) {
+	got, err := openAIEndpoint(ProviderProfile{}, "gpt-4o-mini")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != "https://api.openai.com/v1/chat/completions" {
+		t.Fatalf("unexpected default endpoint: %s", got)
+	}
+	got, err = openAIEndpoint(ProviderProfile{BaseURI: "http://vllm:8000/v1/", Extra: map[string]any{"api_version": "v2"}}, "m")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != "http://vllm:8000/v1/chat/completions?api-version=v2" {
+		t.Fatalf("unexpected endpoint: %s", got)
+	}
+	azure := ProviderProfile{BaseURI: "https://azure.example", Extra: map[string]any{EmbeddingPathTemplateExtraKey: "/deployments/{model}/embeddings"}}
+	got, err = openAIEndpointFor(azure, "embed", EmbeddingPathTemplateExtraKey, defaultOpenAIEmbeddingPathTemplate)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != "https://azure.example/deployments/embed/embeddings" {
+		t.Fatalf("unexpected embedding endpoint: %s", got)
+	}
+}
+
+func TestProvidersSendChatHistory(t *testing.T) {
+	history := []ChatMessage{{Role: "user", Content: "hi"}, {Role: "assistant", Content: "hello!"}}
+	sr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/generate" {
+			var payload ollamaRequest
+			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+				t.Fatalf("failed to decode request: %v", err)
+			}
+			want := "user: hi\nassistant: hello!\nuser: and now?\nassistant:"
+			if payload.Prompt != want {
+				t.Fatalf("history not folded into prompt: %q", payload.Prompt)
+			}
+			_, _ = w.Write([]byte(`{"response":"ok","done":true}`))
+			return
+		}
+		var payload struct {
+			Messages []struct {
+				Role    string `json:"role"`
+				Content string `json:"content"`
+			} `json:"messages"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Fatalf("failed to decode request: %v", err)
+		}
+		var got []string
+		for _, msg := range payload.Messages {
+			got = append(got, msg.Role+":"+msg.Content)
+		}
+		if want := "system:sys|user:hi|assistant:hello!|user:and now?"; strings.Join(got, "|") != want {
+			t.Fatalf("unexpected messages: %v", got)
+		}
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"ok"}}]}`))
+	}))
+	defer sr.Close()
+
+	input := ProviderInput{History: history}
+	openAI := ProviderProfile{ID: "openai", Kind: ProviderOpenAI, BaseURI: sr.URL, APIKey: "k", Extra: map[string]any{"system_prompt": "sys"}}
+	if _, err := (&OpenAIProvider{profile: openAI, client: sr.Client()}).Call(context.Background(), ProviderRequest{Prompt: "and now?", Profile: openAI, Input: input}); err != nil {
+		t.Fatalf("openai call failed: %v", err)
+	}
+	ollama := ProviderProfile{ID: "ollama", Kind: ProviderOllama, BaseURI: sr.URL}
+	if _, err := (&OllamaProvider{profile: ollama, client: sr.Client()}).Call(context.Background(), ProviderRequest{Prompt: "and now?", Profile: ollama, Input: input}); err != nil {
+		t.Fatalf("ollama call failed: %v", err)
+	}
 }
 
 func TestOllamaProviderCall(t *testing.T) {
@@ -53,7 +370,7 @@ func TestOllamaProviderCall(t *testing.T) {
 		if temp, ok := payload.Options["temperature"].(float64); !ok || temp != 0.1 {
 			t.Fatalf("options temperature mismatch: %#v", payload.Options)
 		}
-		_, _ = w.Write([]byte(`{"response":"ok","model":"llama3","done":true}`))
+		_, _ = w.Write([]byte(`{"response":"ok","model":"llama3","done":true,"done_reason":"stop","prompt_eval_count":5,"eval_count":2}`))
 	}))
 	defer sr.Close()
 
@@ -72,6 +389,9 @@ func TestOllamaProviderCall(t *testing.T) {
 	if resp.Output != "ok" {
 		t.Fatalf("unexpected output: %s", resp.Output)
 	}
+	if meta := resp.Meta; meta == nil || meta.FinishReason != "stop" || meta.Usage == nil || meta.Usage.TotalTokens != 7 {
+		t.Fatalf("unexpected meta: %+v", meta)
+	}
 }
 
 func TestOllamaProviderCallHTTPError(t *testing.T) {
@@ -83,7 +403,461 @@ func TestOllamaProviderCallHTTPError(t *testing.T) {
 	profile := ProviderProfile{ID: "ollama", Kind: ProviderOllama, BaseURI: sr.URL, DefaultModel: "llama3"}
 	provider := &OllamaProvider{profile: profile, client: sr.Client()}
 
-	if _, err := provider.Call(context.Background(), ProviderRequest{Prompt: "hello", Profile: profile}); err == nil {
+	_, err := provider.Call(context.Background(), ProviderRequest{Prompt: "hello", Profile: profile})
+	if err == nil {
 		t.Fatal("expected error")
 	}
+	if IsRetryableProviderError(err) {
+		t.Fatalf("400 should not be retryable: %v", err)
+	}
+}
+
+func TestIsRetryableProviderError(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "plain error", err: errors.New("boom"), want: false},
+		{name: "transport", err: &ProviderNetworkError{Kind: ProviderOpenAI, Err: errors.New("connection refused")}, want: true},
+		{name: "rate limited", err: &ProviderAPIError{Kind: ProviderOpenAI, StatusCode: http.StatusTooManyRequests, Err: errors.New("429")}, want: true},
+		{name: "server error", err: &ProviderAPIError{Kind: ProviderOllama, StatusCode: http.StatusBadGateway, Err: errors.New("502")}, want: true},
+		{name: "client error", err: &ProviderAPIError{Kind: ProviderOpenAI, StatusCode: http.StatusUnauthorized, Err: errors.New("401")}, want: false},
+		{name: "decode error", err: &ProviderDecodeError{Kind: ProviderOpenAI, Err: errors.New("unexpected EOF")}, want: false},
+		{name: "refusal", err: &ProviderRefusalError{Kind: ProviderOpenAI, Refusal: "no"}, want: false},
+		{name: "cancelled", err: &ProviderNetworkError{Kind: ProviderOpenAI, Err: context.Canceled}, want: false},
+	}
+	for _, tc := range cases {
+		if got := IsRetryableProviderError(tc.err); got != tc.want {
+			t.Errorf("%s: got %v want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	cases := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+	}{
+		{name: "none", header: http.Header{}, want: 0},
+		{name: "seconds", header: http.Header{"Retry-After": {"3"}}, want: 3 * time.Second},
+		{name: "http date", header: http.Header{"Retry-After": {now.Add(90 * time.Second).Format(http.TimeFormat)}}, want: 90 * time.Second},
+		{name: "past date", header: http.Header{"Retry-After": {now.Add(-time.Minute).Format(http.TimeFormat)}}, want: 0},
+		{name: "garbage", header: http.Header{"Retry-After": {"soon"}}, want: 0},
+		{name: "milliseconds win", header: http.Header{"Retry-After": {"3"}, "Retry-After-Ms": {"250"}}, want: 250 * time.Millisecond},
+	}
+	for _, tc := range cases {
+		if got := parseRetryAfter(tc.header, now); got != tc.want {
+			t.Errorf("%s: got %s want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestProviderRetryDelay(t *testing.T) {
+	advised := &ProviderAPIError{Kind: ProviderOpenAI, StatusCode: http.StatusTooManyRequests, RetryAfter: 2 * time.Second, Err: errors.New("429")}
+	plain := &ProviderNetworkError{Kind: ProviderOpenAI, Err: errors.New("reset")}
+	for i := 0; i < 50; i++ {
+		if d, ok := providerRetryDelay(advised, 3, time.Millisecond); !ok || d < 2*time.Second || d > 2200*time.Millisecond {
+			t.Fatalf("advised delay should be Retry-After plus up to 10%% jitter, got %s ok=%v", d, ok)
+		}
+		if d, _ := providerRetryDelay(plain, 1, 100*time.Millisecond); d < 50*time.Millisecond || d > 100*time.Millisecond {
+			t.Fatalf("first backoff should be within [base/2, base], got %s", d)
+		}
+		if d, _ := providerRetryDelay(plain, 3, 100*time.Millisecond); d < 200*time.Millisecond || d > 400*time.Millisecond {
+			t.Fatalf("third backoff should be within [2base, 4base], got %s", d)
+		}
+	}
+	tooLong := &ProviderAPIError{Kind: ProviderOpenAI, StatusCode: http.StatusTooManyRequests, RetryAfter: time.Hour, Err: errors.New("429")}
+	if _, ok := providerRetryDelay(tooLong, 1, time.Millisecond); ok {
+		t.Fatalf("a Retry-After beyond the cap should not be retried")
+	}
+	if d, _ := providerRetryDelay(plain, 40, time.Second); d > maxProviderRetryDelay {
+		t.Fatalf("backoff should be capped, got %s", d)
+	}
+}
+
+func TestOpenAIProviderReportsRetryAfter(t *testing.T) {
+	sr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "7")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer sr.Close()
+	profile := ProviderProfile{ID: "openai", Kind: ProviderOpenAI, BaseURI: sr.URL, APIKey: "test"}
+	provider := &OpenAIProvider{profile: profile, client: sr.Client()}
+	_, err := provider.Call(context.Background(), ProviderRequest{Prompt: "hi", Profile: profile})
+	var apiErr *ProviderAPIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusTooManyRequests || apiErr.RetryAfter != 7*time.Second {
+		t.Fatalf("expected a 429 with RetryAfter 7s, got %#v", err)
+	}
+}
+
+func TestProviderErrorsMapToStepErrorCodes(t *testing.T) {
+	garbage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`not json`))
+	}))
+	defer garbage.Close()
+	unauthorized := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer unauthorized.Close()
+	closed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	closedURL := closed.URL
+	closed.Close()
+
+	cases := []struct {
+		name     string
+		baseURI  string
+		wantCode string
+	}{
+		{name: "decode", baseURI: garbage.URL, wantCode: ErrCodeProviderDecode},
+		{name: "api", baseURI: unauthorized.URL, wantCode: ErrCodeProviderAPI},
+		{name: "network", baseURI: closedURL, wantCode: ErrCodeProviderNetwork},
+	}
+	for _, tc := range cases {
+		profile := ProviderProfile{ID: "openai", Kind: ProviderOpenAI, BaseURI: tc.baseURI, APIKey: "test"}
+		provider := &OpenAIProvider{profile: profile, client: http.DefaultClient}
+		_, err := provider.Call(context.Background(), ProviderRequest{Prompt: "hello", Profile: profile})
+		if err == nil {
+			t.Fatalf("%s: expected error", tc.name)
+		}
+		if code := stepErrorCode(err); code != tc.wantCode {
+			t.Fatalf("%s: got code %s want %s (%v)", tc.name, code, tc.wantCode, err)
+		}
+		details, _ := stepErrorDetails(err).(map[string]any)
+		if details["provider"] != "openai" {
+			t.Fatalf("%s: details should name the provider: %+v", tc.name, details)
+		}
+		if tc.wantCode == ErrCodeProviderAPI && details["status_code"] != http.StatusUnauthorized {
+			t.Fatalf("api error details should carry the status code: %+v", details)
+		}
+	}
+
+	cancelled := &ProviderNetworkError{Kind: ProviderOpenAI, Err: context.Canceled}
+	if code := stepErrorCode(cancelled); code != "cancelled" {
+		t.Fatalf("cancelled network errors should keep the cancelled code, got %s", code)
+	}
+}
+
+func TestOpenAIProviderRefusals(t *testing.T) {
+	var body string
+	sr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(body))
+	}))
+	defer sr.Close()
+	profile := ProviderProfile{ID: "openai", Kind: ProviderOpenAI, BaseURI: sr.URL, APIKey: "test"}
+	provider := &OpenAIProvider{profile: profile, client: sr.Client()}
+
+	cases := []struct {
+		name    string
+		body    string
+		details map[string]any
+	}{
+		{
+			name:    "refusal",
+			body:    `{"choices":[{"message":{"content":null,"refusal":"I can't help with that."},"finish_reason":"stop"}]}`,
+			details: map[string]any{"provider": "openai", "refusal": "I can't help with that.", "finish_reason": "stop"},
+		},
+		{
+			name:    "content filter",
+			body:    `{"choices":[{"message":{"content":null},"finish_reason":"content_filter"}]}`,
+			details: map[string]any{"provider": "openai", "finish_reason": "content_filter"},
+		},
+	}
+	for _, tc := range cases {
+		body = tc.body
+		resp, err := provider.Call(context.Background(), ProviderRequest{Prompt: "hi", Profile: profile})
+		var refusal *ProviderRefusalError
+		if !errors.As(err, &refusal) {
+			t.Fatalf("%s: expected a refusal error, got %v", tc.name, err)
+		}
+		if code := stepErrorCode(err); code != ErrCodeProviderRefused {
+			t.Fatalf("%s: got code %s", tc.name, code)
+		}
+		details, _ := stepErrorDetails(err).(map[string]any)
+		if len(details) != len(tc.details) {
+			t.Fatalf("%s: unexpected details: %+v", tc.name, details)
+		}
+		for key, want := range tc.details {
+			if details[key] != want {
+				t.Fatalf("%s: details[%s] = %v, want %v", tc.name, key, details[key], want)
+			}
+		}
+		if resp.Meta == nil || resp.Meta.FinishReason != tc.details["finish_reason"] {
+			t.Fatalf("%s: meta should be kept with the error: %+v", tc.name, resp.Meta)
+		}
+	}
+
+	// Filtered responses that still carry content, and truncated ones, are
+	// answers; the finish reason is reported in the meta.
+	for _, reason := range []string{"content_filter", "length"} {
+		body = `{"choices":[{"message":{"content":"partial"},"finish_reason":"` + reason + `"}]}`
+		resp, err := provider.Call(context.Background(), ProviderRequest{Prompt: "hi", Profile: profile})
+		if err != nil || resp.Output != "partial" || resp.Meta.FinishReason != reason {
+			t.Fatalf("%s: unexpected response %+v err=%v", reason, resp, err)
+		}
+	}
+}
+
+func TestOpenAIProviderLogsRedactedIO(t *testing.T) {
+	sr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"hello"}}]}`))
+	}))
+	defer sr.Close()
+
+	var buf bytes.Buffer
+	orig := log.Writer()
+	log.SetOutput(&buf)
+	prevLevel := logging.CurrentLevel()
+	logging.SetLevel(logging.LevelDebug)
+	logging.SetProviderIO(true)
+	defer func() {
+		logging.SetProviderIO(false)
+		logging.SetLevel(prevLevel)
+		log.SetOutput(orig)
+	}()
+
+	profile := ProviderProfile{ID: "openai", Kind: ProviderOpenAI, BaseURI: sr.URL, APIKey: "sk-secret", DefaultModel: "gpt-test"}
+	provider := &OpenAIProvider{profile: profile, client: sr.Client()}
+	resp, err := provider.Call(context.Background(), ProviderRequest{Prompt: "hi", Profile: profile})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Output != "hello" {
+		t.Fatalf("response body should still be decoded: %s", resp.Output)
+	}
+	out := buf.String()
+	if strings.Contains(out, "sk-secret") {
+		t.Fatalf("api key leaked into logs: %s", out)
+	}
+	if !strings.Contains(out, "openai request") || !strings.Contains(out, "openai response") {
+		t.Fatalf("provider io not logged: %s", out)
+	}
+}
+
+func TestDefaultProviderFactoriesShareTransport(t *testing.T) {
+	transport := &http.Transport{MaxIdleConnsPerHost: 4}
+	reg := NewProviderRegistry()
+	RegisterDefaultProviderFactoriesWithTransport(reg, transport)
+	reg.RegisterProfile(ProviderProfile{ID: "openai", Kind: ProviderOpenAI})
+	reg.RegisterProfile(ProviderProfile{ID: "ollama", Kind: ProviderOllama, Extra: map[string]any{"timeout_ms": 1500}})
+
+	resolveClient := func(id ProviderProfileID) *http.Client {
+		provider, _, err := reg.Resolve(StepDef{ProviderProfileID: id})
+		if err != nil {
+			t.Fatalf("resolve %s: %v", id, err)
+		}
+		switch p := provider.(type) {
+		case *OpenAIProvider:
+			return p.client.(*http.Client)
+		case *OllamaProvider:
+			return p.client.(*http.Client)
+		}
+		t.Fatalf("unexpected provider type %T", provider)
+		return nil
+	}
+
+	openai := resolveClient("openai")
+	if openai.Transport != transport {
+		t.Fatal("openai provider does not use the configured transport")
+	}
+	if openai.Timeout != defaultProviderTimeout {
+		t.Fatalf("unexpected default timeout: %s", openai.Timeout)
+	}
+	if again := resolveClient("openai"); again != openai {
+		t.Fatal("http.Client should be reused across resolves")
+	}
+
+	ollama := resolveClient("ollama")
+	if ollama.Transport != transport {
+		t.Fatal("ollama provider does not use the configured transport")
+	}
+	if ollama.Timeout != 1500*time.Millisecond {
+		t.Fatalf("timeout_ms override not applied: %s", ollama.Timeout)
+	}
+}
+
+func TestProviderConnectAndIdleTimeouts(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch strings.Split(r.URL.Path, "/")[1] {
+		case "slow_headers":
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+			return
+		case "stalled_body":
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(`{"model":"m","response":`))
+			w.(http.Flusher).Flush()
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		_, _ = w.Write([]byte(`{"model":"m","response":"ok"}`))
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	reg := NewProviderRegistry()
+	RegisterDefaultProviderFactoriesWithTransport(reg, nil)
+	call := func(query string, extra map[string]any) (ProviderResponse, time.Duration, error) {
+		profile := ProviderProfile{ID: ProviderProfileID("ollama-" + query), Kind: ProviderOllama, BaseURI: ts.URL + "/" + query, Extra: extra}
+		reg.RegisterProfile(profile)
+		provider, resolved, err := reg.Resolve(StepDef{ProviderProfileID: profile.ID})
+		if err != nil {
+			t.Fatalf("resolve %s: %v", profile.ID, err)
+		}
+		start := time.Now()
+		resp, err := provider.Call(context.Background(), ProviderRequest{Prompt: "hi", Profile: resolved})
+		return resp, time.Since(start), err
+	}
+
+	for _, query := range []string{"slow_headers", "stalled_body"} {
+		extra := map[string]any{"timeout_ms": 10000, "connect_timeout_ms": 100, "idle_timeout_ms": 100}
+		_, elapsed, err := call(query, extra)
+		var netErr *ProviderNetworkError
+		if !errors.As(err, &netErr) || !IsRetryableProviderError(err) {
+			t.Fatalf("%s: expected a retryable network error, got %v", query, err)
+		}
+		if !strings.Contains(err.Error(), "timeout") {
+			t.Fatalf("%s: error should mention the timeout: %v", query, err)
+		}
+		if elapsed > 2*time.Second {
+			t.Fatalf("%s: the short timeout did not cancel the call (took %s)", query, elapsed)
+		}
+	}
+
+	resp, _, err := call("fast", map[string]any{"connect_timeout_ms": 1000, "idle_timeout_ms": 1000})
+	if err != nil || resp.Output != "ok" {
+		t.Fatalf("fast server should succeed with timeouts configured: resp=%+v err=%v", resp, err)
+	}
+}
+
+type streamingStubProvider struct {
+	chunks []string
+}
+
+func (s streamingStubProvider) Call(ctx context.Context, req ProviderRequest) (ProviderResponse, error) {
+	return ProviderResponse{Output: "batch"}, nil
+}
+
+func (s streamingStubProvider) CallStream(ctx context.Context, req ProviderRequest) (<-chan ProviderChunk, func() (ProviderResponse, error), error) {
+	ch := make(chan ProviderChunk, len(s.chunks))
+	for _, c := range s.chunks {
+		ch <- ProviderChunk{Content: c}
+	}
+	close(ch)
+	return ch, func() (ProviderResponse, error) {
+		return ProviderResponse{Output: strings.Join(s.chunks, ""), Chunks: []ProviderChunk{{Content: "ignored"}}}, nil
+	}, nil
+}
+
+type batchStubProvider struct {
+	resp ProviderResponse
+	err  error
+}
+
+func (b batchStubProvider) Call(ctx context.Context, req ProviderRequest) (ProviderResponse, error) {
+	return b.resp, b.err
+}
+
+func TestProviderRegistryCheckKind(t *testing.T) {
+	registry := NewProviderRegistry()
+	RegisterDefaultProviderFactories(registry)
+
+	if err := registry.CheckKind(" OpenAI "); err != nil {
+		t.Fatalf("expected mixed-case kind to be accepted: %v", err)
+	}
+	registry.RegisterProfile(ProviderProfile{ID: "p", Kind: " Ollama"})
+	if _, profile, err := registry.Resolve(StepDef{ProviderProfileID: "p"}); err != nil || profile.Kind != ProviderOllama {
+		t.Fatalf("expected normalized kind, got %q (err=%v)", profile.Kind, err)
+	}
+
+	err := registry.CheckKind("anthropic")
+	var kindErr *ProviderKindError
+	if !errors.As(err, &kindErr) {
+		t.Fatalf("expected ProviderKindError, got %v", err)
+	}
+	if kindErr.Kind != "anthropic" || len(kindErr.Supported) != len(registry.Kinds()) {
+		t.Fatalf("unexpected error details: %+v", kindErr)
+	}
+	for i := 1; i < len(kindErr.Supported); i++ {
+		if kindErr.Supported[i-1] > kindErr.Supported[i] {
+			t.Fatalf("supported kinds not sorted: %v", kindErr.Supported)
+		}
+	}
+}
+
+func TestCallStreamPrefersStreamingProviders(t *testing.T) {
+	eng := NewBasicEngine(nil)
+	profile := ProviderProfile{ID: "stream", Kind: ProviderLocal}
+	step := StepDef{ID: "step"}
+
+	var live []string
+	onChunk := func(kind ProviderKind, chunk ProviderChunk) { live = append(live, string(kind)+":"+chunk.Content) }
+	resp, err := eng.callProfile(context.Background(), streamingStubProvider{chunks: []string{"he", "llo"}}, profile, step, "prompt", ProviderInput{}, onChunk)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Output != "hello" || len(resp.Chunks) != 2 || resp.Chunks[0].Content != "he" || resp.Chunks[1].Content != "llo" {
+		t.Fatalf("streaming provider should be called through CallStream: %+v", resp)
+	}
+	if len(live) != 2 || live[0] != "local_tool:he" || live[1] != "local_tool:llo" {
+		t.Fatalf("chunks should be passed to onChunk with the serving kind: %v", live)
+	}
+
+	batch := batchStubProvider{resp: ProviderResponse{Output: "done", Chunks: []ProviderChunk{{Content: "do"}, {Content: "ne"}}}}
+	resp, err = eng.callProfile(context.Background(), batch, profile, step, "prompt", ProviderInput{}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Output != "done" || len(resp.Chunks) != 2 || resp.Chunks[1].Content != "ne" {
+		t.Fatalf("batch provider chunks should be replayed through the adapter: %+v", resp)
+	}
+
+	failing := batchStubProvider{err: &ProviderAPIError{Kind: ProviderLocal, StatusCode: http.StatusBadGateway, Err: errors.New("502")}}
+	chunks, wait, err := AsStreamingProvider(failing).CallStream(context.Background(), ProviderRequest{Step: step})
+	if err != nil {
+		t.Fatalf("adapter should report call errors from wait: %v", err)
+	}
+	for range chunks {
+		t.Fatal("failed call should not stream chunks")
+	}
+	if _, err := wait(); !IsRetryableProviderError(err) {
+		t.Fatalf("adapter should return the provider error: %v", err)
+	}
+}
+
+func TestWebhookDialRejectsNamesResolvingToInternalAddresses(t *testing.T) {
+	var hits atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer ts.Close()
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+
+	e := NewBasicEngine(nil)
+	defer e.Close()
+	// Validation is bypassed here: the dial check alone must refuse a name
+	// that resolves to loopback.
+	target := "http://localhost:" + port + "/hook"
+	err := postWebhook(context.Background(), e.hookClient, target, StreamingEvent{Event: "job_completed"}, []byte("{}"))
+	if err == nil || !strings.Contains(err.Error(), "not a public address") {
+		t.Fatalf("expected the dial to be refused, got %v", err)
+	}
+	if hits.Load() != 0 {
+		t.Fatal("internal target must not receive the webhook")
+	}
+
+	allowed := NewBasicEngineWithConfig(nil, &EngineConfig{WebhookAllowedHosts: []string{"LocalHost"}})
+	defer allowed.Close()
+	if err := postWebhook(context.Background(), allowed.hookClient, target, StreamingEvent{Event: "job_completed"}, []byte("{}")); err != nil {
+		t.Fatalf("allowlisted host should be reachable: %v", err)
+	}
 }
@@ -0,0 +1,186 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// Reduce steps read these StepDef.Config keys to switch to hierarchical
+// reduction for large inputs.
+const (
+	// ReduceTokenThresholdConfigKey enables tree reduction once the upstream
+	// items are estimated to exceed this many tokens.
+	ReduceTokenThresholdConfigKey = "reduce_token_threshold"
+	// ReduceBatchSizeConfigKey is the number of items reduced per
+	// intermediate provider call.
+	ReduceBatchSizeConfigKey = "reduce_batch_size"
+
+	defaultReduceBatchSize = 8
+)
+
+// reduceTreeConfig returns the token threshold and batch size of a reduce
+// step, or ok=false when tree reduction is not configured.
+func reduceTreeConfig(step StepDef) (threshold, batchSize int, ok bool) {
+	threshold, ok = step.ConfigInt(ReduceTokenThresholdConfigKey)
+	if !ok || threshold <= 0 {
+		return 0, 0, false
+	}
+	batchSize, ok = step.ConfigInt(ReduceBatchSizeConfigKey)
+	if !ok || batchSize < 2 {
+		batchSize = defaultReduceBatchSize
+	}
+	return threshold, batchSize, true
+}
+
+// needsReduceTree reports whether the reduce step's upstream items exceed its
+// configured token threshold.
+func needsReduceTree(step StepDef, items []ResultItem) bool {
+	threshold, batchSize, ok := reduceTreeConfig(step)
+	return ok && len(items) > batchSize && estimateItemsTokens(items) > threshold
+}
+
+func estimateItemsTokens(items []ResultItem) int {
+	total := 0
+	for _, item := range items {
+		if data, ok := item.Data.(map[string]any); ok {
+			if text, ok := data["text"].(string); ok {
+				total += estimateTokens(text)
+				continue
+			}
+		}
+		encoded, err := json.Marshal(item.Data)
+		if err == nil {
+			total += estimateTokens(string(encoded))
+		}
+	}
+	return total
+}
+
+// reduceShards returns the upstream items of a reduce step in depends_on
+// order.
+func reduceShards(step StepDef, outputs map[StepID][]ResultItem) []ResultItem {
+	var shards []ResultItem
+	for _, dep := range step.DependsOn {
+		shards = append(shards, outputs[dep]...)
+	}
+	return shards
+}
+
+// reduceBatchOutputs replaces the step's dependencies in outputs with the
+// items of one batch so the step's prompt template renders only that batch.
+// Partial summaries from earlier levels are presented as outputs of the first
+// dependency.
+func reduceBatchOutputs(step StepDef, outputs map[StepID][]ResultItem, batch []ResultItem) map[StepID][]ResultItem {
+	scoped := make(map[StepID][]ResultItem, len(outputs))
+	for id, items := range outputs {
+		scoped[id] = items
+	}
+	deps := make(map[StepID]bool, len(step.DependsOn))
+	for _, dep := range step.DependsOn {
+		deps[dep] = true
+		scoped[dep] = nil
+	}
+	for _, item := range batch {
+		key := item.StepID
+		if !deps[key] {
+			key = step.DependsOn[0]
+		}
+		scoped[key] = append(scoped[key], item)
+	}
+	return scoped
+}
+
+// reduceTree collapses shards level by level: every batch of batchSize items
+// is reduced by one provider call into a partial summary until the partials
+// fit under the token threshold (or into a single batch). It returns the
+// items for the final reduce call together with every partial produced.
+func (e *BasicEngine) reduceTree(ctx context.Context, provider Provider, profile ProviderProfile, step StepDef, job *Job, outputs map[StepID][]ResultItem, shards []ResultItem) ([]ResultItem, []ResultItem, error) {
+	threshold, batchSize, _ := reduceTreeConfig(step)
+	items := shards
+	var partials []ResultItem
+	for level := 1; len(items) > batchSize && estimateItemsTokens(items) > threshold; level++ {
+		next := make([]ResultItem, 0, (len(items)+batchSize-1)/batchSize)
+		for start := 0; start < len(items); start += batchSize {
+			batch := items[start:min(start+batchSize, len(items))]
+			resp, _, err := e.callReduceBatch(ctx, provider, profile, step, job, reduceBatchOutputs(step, outputs, batch), nil)
+			if err != nil {
+				return nil, nil, err
+			}
+			leaves := 0
+			for _, item := range batch {
+				leaves += reducedLeafCount(item)
+			}
+			text := resp.Output
+			if text == "" {
+				text = fmt.Sprintf("step %s reduced %d items at level %d", step.ID, len(batch), level)
+			}
+			data := map[string]any{
+				"text":          text,
+				"level":         level,
+				"reduced_count": leaves,
+			}
+			mergeMeta(data, resp.Metadata)
+			next = append(next, ResultItem{
+				ID:          e.idGenerator(),
+				Label:       fmt.Sprintf("%s level %d #%d", step.ID, level, len(next)+1),
+				StepID:      step.ID,
+				ShardKey:    ptrString(fmt.Sprintf("%s-L%d-%d", step.ID, level, len(next))),
+				Kind:        string(step.Kind),
+				ContentType: ensureContentType(step.OutputType),
+				Data:        data,
+			})
+		}
+		partials = append(partials, next...)
+		items = next
+	}
+	return items, partials, nil
+}
+
+// callReduceBatch renders the step's prompt against one batch and calls the
+// provider with it, checking the batch prompt against the context window.
+func (e *BasicEngine) callReduceBatch(ctx context.Context, provider Provider, profile ProviderProfile, step StepDef, job *Job, outputs map[StepID][]ResultItem, onChunk chunkFunc) (ProviderResponse, string, error) {
+	prompt := buildPrompt(step, job, outputs)
+	input := ProviderInput{
+		Sources:  job.Input.Sources,
+		History:  job.Input.History,
+		Options:  job.Input.Options,
+		Previous: outputs,
+		Messages: buildPromptMessages(step, job, outputs),
+	}
+	if err := checkContextWindow(step, profile, prompt, historyPromptMessages(input.History, input.Messages)); err != nil {
+		return ProviderResponse{}, prompt, err
+	}
+	resp, err := e.callProvider(ctx, provider, profile, step, prompt, input, onChunk)
+	return resp, prompt, err
+}
+
+// reducedLeafCount is the number of original upstream items an item stands
+// for: partial summaries carry it as reduced_count, other items count once.
+func reducedLeafCount(item ResultItem) int {
+	if data, ok := item.Data.(map[string]any); ok {
+		if _, isPartial := data["level"]; isPartial {
+			if n, ok := extraInt(data, "reduced_count"); ok && n > 0 {
+				return n
+			}
+		}
+	}
+	return 1
+}
+
+// reducePartialSummaries is the observable form of the intermediate partials
+// stored on the final reduce result as data.partials.
+func reducePartialSummaries(partials []ResultItem) []map[string]any {
+	summaries := make([]map[string]any, 0, len(partials))
+	for _, partial := range partials {
+		data, _ := partial.Data.(map[string]any)
+		summary := map[string]any{
+			"shard_key":     *partial.ShardKey,
+			"level":         data["level"],
+			"reduced_count": data["reduced_count"],
+			"text":          data["text"],
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries
+}
@@ -0,0 +1,274 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Retrieve steps read these StepDef.Config keys. The query dependency
+// defaults to the first entry of DependsOn; every other dependency supplies
+// candidates.
+const (
+	// RetrieveQueryStepConfigKey names the dependency whose items carry the
+	// query embeddings.
+	RetrieveQueryStepConfigKey = "query_step"
+	// RetrieveTopKConfigKey is the number of candidates selected.
+	RetrieveTopKConfigKey = "top_k"
+	// RetrieveSimilarityConfigKey selects the SimilarityMetric.
+	RetrieveSimilarityConfigKey = "similarity"
+
+	// EmbeddingDataKey is the ResultItem data key holding an embedding
+	// vector. Providers set it through ProviderResponse.Metadata.
+	EmbeddingDataKey = "embedding"
+
+	defaultRetrieveTopK = 3
+)
+
+// SimilarityMetric scores a candidate embedding against a query embedding;
+// higher scores are more similar.
+type SimilarityMetric string
+
+const (
+	SimilarityCosine SimilarityMetric = "cosine"
+	SimilarityDot    SimilarityMetric = "dot"
+	// SimilarityEuclidean scores 1/(1+distance).
+	SimilarityEuclidean SimilarityMetric = "euclidean"
+)
+
+// retrieveConfig returns the query dependency, k and metric of a retrieve
+// step.
+func retrieveConfig(step StepDef) (StepID, int, SimilarityMetric) {
+	var query StepID
+	if id, ok := step.ConfigString(RetrieveQueryStepConfigKey); ok && id != "" {
+		query = StepID(id)
+	} else if len(step.DependsOn) > 0 {
+		query = step.DependsOn[0]
+	}
+	k, ok := step.ConfigInt(RetrieveTopKConfigKey)
+	if !ok || k <= 0 {
+		k = defaultRetrieveTopK
+	}
+	metric := SimilarityCosine
+	if value, ok := step.ConfigString(RetrieveSimilarityConfigKey); ok && value != "" {
+		metric = SimilarityMetric(strings.ToLower(value))
+	}
+	return query, k, metric
+}
+
+// validateRetrieveStep reports a retrieve step whose query_step is not one of
+// its dependencies or that has no candidate dependency.
+func validateRetrieveStep(step StepDef) error {
+	if step.Kind != StepKindRetrieve {
+		return nil
+	}
+	query, _, _ := retrieveConfig(step)
+	var errs []error
+	if !containsStepID(step.DependsOn, query) {
+		errs = append(errs, fmt.Errorf("step %s: query_step %q must be listed in depends_on", step.ID, query))
+	}
+	if len(step.DependsOn) < 2 {
+		errs = append(errs, fmt.Errorf("step %s: retrieve steps need a candidate dependency besides the query step", step.ID))
+	}
+	return errors.Join(errs...)
+}
+
+func containsStepID(ids []StepID, id StepID) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
+
+type scoredItem struct {
+	item  ResultItem
+	score float64
+}
+
+// runRetrieveStep scores every candidate item against the query embeddings,
+// keeping each candidate's best score, and returns the top k. Candidates
+// without an embedding are ignored. Steps that depend on a retrieve step
+// receive the selected items as their sources (see retrievedSources).
+func (e *BasicEngine) runRetrieveStep(step StepDef, outputs map[StepID][]ResultItem) ([]ResultItem, error) {
+	queryStep, k, metric := retrieveConfig(step)
+	var queries [][]float64
+	for _, item := range outputs[queryStep] {
+		if vec, ok := itemEmbedding(item); ok {
+			queries = append(queries, vec)
+		}
+	}
+	if len(queries) == 0 {
+		return nil, &stepError{
+			code:    "retrieval_query_missing",
+			err:     fmt.Errorf("step %s: no %s embedding in the output of query step %s", step.ID, EmbeddingDataKey, queryStep),
+			details: map[string]any{"query_step": string(queryStep)},
+		}
+	}
+
+	var scored []scoredItem
+	for _, dep := range step.DependsOn {
+		if dep == queryStep {
+			continue
+		}
+		for _, item := range outputs[dep] {
+			vec, ok := itemEmbedding(item)
+			if !ok {
+				continue
+			}
+			best := math.Inf(-1)
+			for _, query := range queries {
+				if len(query) != len(vec) {
+					return nil, &stepError{
+						code:    "embedding_dimension_mismatch",
+						err:     fmt.Errorf("step %s: query embedding has %d dimensions, candidate %s has %d", step.ID, len(query), item.ID, len(vec)),
+						details: map[string]any{"query": len(query), "candidate": len(vec), "item_id": item.ID},
+					}
+				}
+				if score := similarity(metric, query, vec); score > best {
+					best = score
+				}
+			}
+			scored = append(scored, scoredItem{item: item, score: best})
+		}
+	}
+	sort.SliceStable(scored, func(i, j int) bool { return scored[i].score > scored[j].score })
+	if len(scored) > k {
+		scored = scored[:k]
+	}
+
+	items := make([]ResultItem, 0, len(scored))
+	for rank, hit := range scored {
+		items = append(items, e.buildRetrieveResult(step, hit, rank, metric))
+	}
+	return items, nil
+}
+
+func (e *BasicEngine) buildRetrieveResult(step StepDef, hit scoredItem, rank int, metric SimilarityMetric) ResultItem {
+	label := step.Name
+	if label == "" {
+		label = string(step.ID)
+	}
+	data := map[string]any{
+		"score":          hit.score,
+		"rank":           rank + 1,
+		"similarity":     string(metric),
+		"candidate_id":   hit.item.ID,
+		"candidate_step": string(hit.item.StepID),
+	}
+	if src, ok := hit.item.Data.(map[string]any); ok {
+		for _, key := range []string{"text", "source", "source_kind", "source_metadata"} {
+			if value, ok := src[key]; ok {
+				data[key] = value
+			}
+		}
+	}
+	return ResultItem{
+		ID:          e.idGenerator(),
+		Label:       fmt.Sprintf("%s#%d", label, rank+1),
+		StepID:      step.ID,
+		ShardKey:    hit.item.ShardKey,
+		Kind:        string(step.Kind),
+		Tag:         step.ExportTag,
+		ContentType: ensureContentType(step.OutputType),
+		Data:        data,
+	}
+}
+
+// retrievedSources converts the items of the step's retrieve dependencies
+// into sources, in rank order, so the step runs against the retrieved context
+// instead of the job's input sources. ok is false when the step depends on no
+// retrieve step.
+func retrievedSources(step StepDef, outputs map[StepID][]ResultItem) ([]Source, bool) {
+	var sources []Source
+	found := false
+	for _, dep := range step.DependsOn {
+		for _, item := range outputs[dep] {
+			if item.Kind != string(StepKindRetrieve) {
+				continue
+			}
+			found = true
+			data, _ := item.Data.(map[string]any)
+			src := Source{Kind: SourceKindRaw, Label: item.Label}
+			if kind, ok := data["source_kind"].(SourceKind); ok {
+				src.Kind = kind
+			} else if kind, ok := data["source_kind"].(string); ok && kind != "" {
+				src.Kind = SourceKind(kind)
+			}
+			if content, ok := data["source"].(string); ok && content != "" {
+				src.Content = content
+			} else if text, ok := data["text"].(string); ok {
+				src.Content = text
+			}
+			src.Metadata = map[string]any{"retrieval_score": data["score"], "retrieval_rank": data["rank"]}
+			if meta, ok := data["source_metadata"].(map[string]any); ok {
+				for key, value := range meta {
+					src.Metadata[key] = value
+				}
+			}
+			sources = append(sources, src)
+		}
+	}
+	return sources, found
+}
+
+// itemEmbedding returns the item's embedding vector, accepting float slices
+// and the []any a JSON round trip produces.
+func itemEmbedding(item ResultItem) ([]float64, bool) {
+	data, ok := item.Data.(map[string]any)
+	if !ok {
+		return nil, false
+	}
+	switch raw := data[EmbeddingDataKey].(type) {
+	case []float64:
+		return raw, len(raw) > 0
+	case []float32:
+		vec := make([]float64, len(raw))
+		for i, v := range raw {
+			vec[i] = float64(v)
+		}
+		return vec, len(vec) > 0
+	case []any:
+		vec := make([]float64, len(raw))
+		for i, v := range raw {
+			f, ok := v.(float64)
+			if !ok {
+				return nil, false
+			}
+			vec[i] = f
+		}
+		return vec, len(vec) > 0
+	}
+	return nil, false
+}
+
+func similarity(metric SimilarityMetric, a, b []float64) float64 {
+	switch metric {
+	case SimilarityDot:
+		return dot(a, b)
+	case SimilarityEuclidean:
+		var sum float64
+		for i := range a {
+			d := a[i] - b[i]
+			sum += d * d
+		}
+		return 1 / (1 + math.Sqrt(sum))
+	default:
+		norm := math.Sqrt(dot(a, a)) * math.Sqrt(dot(b, b))
+		if norm == 0 {
+			return 0
+		}
+		return dot(a, b) / norm
+	}
+}
+
+func dot(a, b []float64) float64 {
+	var sum float64
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
@@ -0,0 +1,139 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/example/pipeline-engine/pkg/metrics"
+)
+
+const (
+	minProviderTimeout = 100 * time.Millisecond
+	maxProviderTimeout = 10 * time.Minute
+	maxJobConcurrency  = 1024
+)
+
+// RuntimeConfig holds engine settings that can be changed while the engine
+// runs. Changes apply to provider calls and jobs started afterwards.
+type RuntimeConfig struct {
+	// ProviderTimeout bounds HTTP provider calls whose profile has no
+	// Extra.timeout_ms.
+	ProviderTimeout time.Duration
+	// MaxConcurrency caps the number of jobs executing at once; further jobs
+	// stay queued until a slot frees up. 0 means unlimited.
+	MaxConcurrency int
+	// CancelOnDisconnect makes RunJobStream cancel the job when the caller's
+	// context ends before the job finishes, so an abandoned stream stops
+	// spending provider budget.
+	CancelOnDisconnect bool
+}
+
+// Validate rejects out-of-range settings.
+func (c RuntimeConfig) Validate() error {
+	if c.ProviderTimeout < minProviderTimeout || c.ProviderTimeout > maxProviderTimeout {
+		return fmt.Errorf("provider timeout must be between %s and %s", minProviderTimeout, maxProviderTimeout)
+	}
+	if c.MaxConcurrency < 0 || c.MaxConcurrency > maxJobConcurrency {
+		return fmt.Errorf("max concurrency must be between 0 and %d", maxJobConcurrency)
+	}
+	return nil
+}
+
+// RuntimeConfig returns the current runtime settings.
+func (e *BasicEngine) RuntimeConfig() RuntimeConfig {
+	e.runtimeMu.RLock()
+	defer e.runtimeMu.RUnlock()
+	return e.runtime
+}
+
+// UpdateRuntimeConfig validates and applies cfg, returning the effective settings.
+func (e *BasicEngine) UpdateRuntimeConfig(cfg RuntimeConfig) (RuntimeConfig, error) {
+	if err := cfg.Validate(); err != nil {
+		return e.RuntimeConfig(), err
+	}
+	e.runtimeMu.Lock()
+	e.runtime = cfg
+	e.runtimeMu.Unlock()
+	e.httpClients.setDefaultTimeout(cfg.ProviderTimeout)
+	// A raised limit may admit queued jobs.
+	e.wakeJobSlots()
+	return cfg, nil
+}
+
+// acquireJobSlot blocks until a job of type pt may execute under both
+// MaxConcurrency and the pipeline's concurrency limit. The two are taken
+// together, so a job waiting for its pipeline does not hold a global slot
+// other pipelines could use. It returns false when ctx is cancelled while
+// waiting.
+func (e *BasicEngine) acquireJobSlot(ctx context.Context, pt PipelineType) bool {
+	for {
+		limit := e.RuntimeConfig().MaxConcurrency
+		typeLimit := e.pipelineConcurrency(pt)
+		e.slotMu.Lock()
+		if (limit <= 0 || e.runningJobs < limit) && (typeLimit <= 0 || e.runningTypes[pt] < typeLimit) {
+			e.runningJobs++
+			e.runningTypes[pt]++
+			e.slotMu.Unlock()
+			metrics.ObservePipelineInFlight(string(pt), 1)
+			return true
+		}
+		if e.slotWake == nil {
+			e.slotWake = make(chan struct{})
+		}
+		wake := e.slotWake
+		e.slotMu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-wake:
+		}
+	}
+}
+
+func (e *BasicEngine) releaseJobSlot(pt PipelineType) {
+	e.slotMu.Lock()
+	e.runningJobs--
+	if e.runningTypes[pt]--; e.runningTypes[pt] <= 0 {
+		delete(e.runningTypes, pt)
+	}
+	e.slotMu.Unlock()
+	metrics.ObservePipelineInFlight(string(pt), -1)
+	e.wakeJobSlots()
+}
+
+// pipelineConcurrency returns the concurrency limit of pipeline type pt: the
+// lower of EngineConfig.PipelineConcurrency and the MaxConcurrency of its
+// latest registration, ignoring unset (zero) values. Zero means unlimited.
+func (e *BasicEngine) pipelineConcurrency(pt PipelineType) int {
+	limit := e.typeLimits[pt]
+	e.pipelineMu.RLock()
+	def, ok := e.pipelines[pt]
+	e.pipelineMu.RUnlock()
+	if ok && def.MaxConcurrency > 0 && (limit <= 0 || def.MaxConcurrency < limit) {
+		limit = def.MaxConcurrency
+	}
+	return limit
+}
+
+// PipelineInFlight returns the number of jobs executing per pipeline type.
+// Queued jobs waiting for a slot are not counted.
+func (e *BasicEngine) PipelineInFlight() map[PipelineType]int {
+	e.slotMu.Lock()
+	defer e.slotMu.Unlock()
+	counts := make(map[PipelineType]int, len(e.runningTypes))
+	for pt, n := range e.runningTypes {
+		counts[pt] = n
+	}
+	return counts
+}
+
+func (e *BasicEngine) wakeJobSlots() {
+	e.slotMu.Lock()
+	defer e.slotMu.Unlock()
+	if e.slotWake != nil {
+		close(e.slotWake)
+		e.slotWake = nil
+	}
+}
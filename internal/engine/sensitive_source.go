@@ -0,0 +1,134 @@
+package engine
+
+import (
+	"strings"
+
+	"github.com/example/pipeline-engine/pkg/logging"
+)
+
+// Sources marked Sensitive are only held in memory while their job runs.
+// Providers receive the raw content, but every copy of the job written to the
+// JobStore or a StepCheckpointStore (and therefore every API response and
+// streamed event) has the source content replaced by logging.RedactedValue,
+// and any occurrence of that content in prompts, chunks and result data is
+// scrubbed the same way.
+
+// redactSources returns sources with sensitive content and data replaced. The
+// returned sources are marked Redacted so they cannot be resubmitted.
+func redactSources(sources []Source) []Source {
+	if !hasSensitiveSource(sources) {
+		return sources
+	}
+	redacted := make([]Source, len(sources))
+	for i, src := range sources {
+		if src.Sensitive {
+			src.Content = logging.RedactedValue
+			src.Data = nil
+			src.Redacted = true
+		}
+		redacted[i] = src
+	}
+	return redacted
+}
+
+func hasSensitiveSource(sources []Source) bool {
+	for _, src := range sources {
+		if src.Sensitive {
+			return true
+		}
+	}
+	return false
+}
+
+// sourceSecrets is the non-empty content of a job's sensitive sources.
+type sourceSecrets []string
+
+func secretsOf(sources []Source) sourceSecrets {
+	var secrets sourceSecrets
+	for _, src := range sources {
+		if src.Sensitive && !src.Redacted && src.Content != "" {
+			secrets = append(secrets, src.Content)
+		}
+	}
+	return secrets
+}
+
+func (s sourceSecrets) scrub(text string) string {
+	for _, secret := range s {
+		text = strings.ReplaceAll(text, secret, logging.RedactedValue)
+	}
+	return text
+}
+
+func (s sourceSecrets) scrubValue(v any) any {
+	switch val := v.(type) {
+	case string:
+		return s.scrub(val)
+	case map[string]any:
+		out := make(map[string]any, len(val))
+		for k, item := range val {
+			out[k] = s.scrubValue(item)
+		}
+		return out
+	case []map[string]any:
+		out := make([]map[string]any, len(val))
+		for i, item := range val {
+			out[i], _ = s.scrubValue(item).(map[string]any)
+		}
+		return out
+	case []any:
+		out := make([]any, len(val))
+		for i, item := range val {
+			out[i] = s.scrubValue(item)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+func (s sourceSecrets) scrubItems(items []ResultItem) []ResultItem {
+	if len(s) == 0 || len(items) == 0 {
+		return items
+	}
+	scrubbed := make([]ResultItem, len(items))
+	for i, item := range items {
+		item.Data = s.scrubValue(item.Data)
+		scrubbed[i] = item
+	}
+	return scrubbed
+}
+
+// redactJob returns the copy of job that may be persisted: sensitive sources
+// are redacted and their content is scrubbed from prompts, chunks and result
+// items. Jobs without sensitive sources are returned as is.
+func redactJob(job *Job) *Job {
+	if job == nil || !hasSensitiveSource(job.Input.Sources) {
+		return job
+	}
+	secrets := secretsOf(job.Input.Sources)
+	redacted := *job
+	redacted.Input.Sources = redactSources(job.Input.Sources)
+	if len(secrets) == 0 {
+		return &redacted
+	}
+	redacted.StepExecutions = make([]StepExecution, len(job.StepExecutions))
+	for i, exec := range job.StepExecutions {
+		exec.Prompt = secrets.scrub(exec.Prompt)
+		if len(exec.Chunks) > 0 {
+			chunks := make([]StepChunk, len(exec.Chunks))
+			for j, chunk := range exec.Chunks {
+				chunk.Content = secrets.scrub(chunk.Content)
+				chunks[j] = chunk
+			}
+			exec.Chunks = chunks
+		}
+		redacted.StepExecutions[i] = exec
+	}
+	if job.Result != nil {
+		result := *job.Result
+		result.Items = secrets.scrubItems(job.Result.Items)
+		redacted.Result = &result
+	}
+	return &redacted
+}
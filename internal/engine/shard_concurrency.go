@@ -0,0 +1,114 @@
+package engine
+
+import (
+	"context"
+	"sync"
+)
+
+// ShardConcurrencyConfigKey sets how many shards of a fan-out or per_item
+// step call the provider at once, overriding EngineConfig.ShardConcurrency.
+const ShardConcurrencyConfigKey = "shard_concurrency"
+
+// shardConcurrency returns the step's positive shard_concurrency, else the
+// engine default; 1 runs shards one after another.
+func (e *BasicEngine) shardConcurrency(step StepDef) int {
+	if n, ok := step.ConfigInt(ShardConcurrencyConfigKey); ok && n > 0 {
+		return n
+	}
+	if e.shardLimit > 0 {
+		return e.shardLimit
+	}
+	return 1
+}
+
+// shardProgress serializes the job updates of a step's concurrently running
+// shards. Every finished shard counts towards ShardsDone right away, while
+// results are exported in shard order so Job.Result matches a sequential run.
+type shardProgress struct {
+	mu       sync.Mutex
+	done     []bool
+	exported int
+}
+
+func newShardProgress(total int) *shardProgress {
+	return &shardProgress{done: make([]bool, total)}
+}
+
+// finish marks shard i done and returns the range of shards that became
+// exportable, i.e. the newly completed prefix.
+func (p *shardProgress) finish(i int) (from, to int) {
+	p.done[i] = true
+	return p.flush()
+}
+
+// flush returns the range of finished shards not yet exported and marks them
+// exported.
+func (p *shardProgress) flush() (from, to int) {
+	from = p.exported
+	for p.exported < len(p.done) && p.done[p.exported] {
+		p.exported++
+	}
+	return from, p.exported
+}
+
+// lockChunks serializes record, which updates the job, across the step's
+// concurrently streaming shards.
+func (p *shardProgress) lockChunks(record chunkFunc) chunkFunc {
+	if record == nil {
+		return nil
+	}
+	return func(kind ProviderKind, chunk ProviderChunk) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		record(kind, chunk)
+	}
+}
+
+// runShards calls fn for shards 0..n-1 with at most limit calls in flight.
+// fn writes its result into a slot indexed by shard, so callers keep shard
+// order regardless of completion order. The first error cancels the shards
+// still running and is returned; shards not yet started are never run.
+func runShards(ctx context.Context, n, limit int, fn func(ctx context.Context, i int) error) error {
+	if limit <= 1 || n <= 1 {
+		for i := 0; i < n && ctx.Err() == nil; i++ {
+			if err := fn(ctx, i); err != nil {
+				return err
+			}
+		}
+		return ctx.Err()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	sem := make(chan struct{}, limit)
+	for i := 0; i < n; i++ {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := fn(ctx, i); err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}(i)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
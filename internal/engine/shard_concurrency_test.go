@@ -0,0 +1,337 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// shardProbeProvider tracks how many calls run at once. Calls wait for delay,
+// or until cancelled when delay is zero, and fail for the shard in failShard.
+type shardProbeProvider struct {
+	mu        sync.Mutex
+	delay     time.Duration
+	failShard string
+	inflight  int
+	peak      int
+	started   int
+	returned  int
+}
+
+func (p *shardProbeProvider) Call(ctx context.Context, req ProviderRequest) (ProviderResponse, error) {
+	p.mu.Lock()
+	p.started++
+	p.inflight++
+	p.peak = max(p.peak, p.inflight)
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.inflight--
+		p.returned++
+		p.mu.Unlock()
+	}()
+
+	shard := ""
+	for _, items := range req.Input.Previous {
+		if len(items) == 1 && items[0].ShardKey != nil {
+			shard = *items[0].ShardKey
+		}
+	}
+	if p.delay == 0 {
+		<-ctx.Done()
+		return ProviderResponse{}, ctx.Err()
+	}
+	select {
+	case <-ctx.Done():
+		return ProviderResponse{}, ctx.Err()
+	case <-time.After(p.delay):
+	}
+	if shard == p.failShard {
+		return ProviderResponse{}, fmt.Errorf("shard %s failed", shard)
+	}
+	return ProviderResponse{Output: "refined " + shard}, nil
+}
+
+func (p *shardProbeProvider) counts() (peak, started, returned int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.peak, p.started, p.returned
+}
+
+func newShardProbeEngine(t *testing.T, provider *shardProbeProvider, sources int) (*BasicEngine, JobRequest) {
+	t.Helper()
+	eng := NewBasicEngine(newCheckpointStore())
+	t.Cleanup(eng.Close)
+	eng.providers.RegisterFactory("probe", func(ProviderProfile) Provider { return provider })
+	eng.providers.RegisterProfile(ProviderProfile{ID: "probe", Kind: "probe"})
+	eng.RegisterPipeline(PipelineDef{
+		Type:    "per_item_concurrency",
+		Version: "v1",
+		Steps: []StepDef{
+			{ID: "split", Kind: StepKindMap, Mode: StepModeFanOut},
+			{
+				ID:                "refine",
+				Kind:              StepKindLLM,
+				Mode:              StepModePerItem,
+				DependsOn:         []StepID{"split"},
+				ProviderProfileID: "probe",
+				Export:            true,
+				Config:            map[string]any{ShardConcurrencyConfigKey: 3},
+			},
+		},
+	})
+	req := JobRequest{PipelineType: "per_item_concurrency", Mode: ModeSync}
+	for i := 0; i < sources; i++ {
+		req.Input.Sources = append(req.Input.Sources, Source{Kind: SourceKindNote, Label: fmt.Sprintf("s%d", i), Content: "x"})
+	}
+	return eng, req
+}
+
+func TestPerItemShardConcurrency(t *testing.T) {
+	provider := &shardProbeProvider{delay: 100 * time.Millisecond}
+	eng, req := newShardProbeEngine(t, provider, 6)
+
+	job, err := eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ジョブ実行に失敗しました: %v", err)
+	}
+	if job.Status != JobStatusSucceeded {
+		t.Fatalf("ジョブが成功していません: %s %+v", job.Status, job.Error)
+	}
+	if peak, _, _ := provider.counts(); peak != 3 {
+		t.Fatalf("同時実行数が shard_concurrency と一致しません: %d", peak)
+	}
+	if len(job.Result.Items) != 6 {
+		t.Fatalf("結果件数が想定外です: %d", len(job.Result.Items))
+	}
+	for i, item := range job.Result.Items {
+		want := fmt.Sprintf("split-%d", i)
+		if item.ShardKey == nil || *item.ShardKey != want || item.Data.(map[string]any)["text"] != "refined "+want {
+			t.Fatalf("結果 %d がシャード順になっていません: %+v", i, item)
+		}
+	}
+	if exec := job.StepExecutions[1]; exec.ShardsDone != 6 || exec.ShardsTotal != 6 {
+		t.Fatalf("シャードの進捗が想定外です: %+v", exec)
+	}
+}
+
+func TestPerItemShardConcurrencyFailsOnFirstError(t *testing.T) {
+	provider := &shardProbeProvider{delay: 50 * time.Millisecond, failShard: "split-1"}
+	eng, req := newShardProbeEngine(t, provider, 9)
+
+	job, err := eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ジョブ実行に失敗しました: %v", err)
+	}
+	if job.Status != JobStatusFailed || job.Error == nil || job.Error.Message != "shard split-1 failed" {
+		t.Fatalf("最初のシャードのエラーで失敗するべきです: %s %+v", job.Status, job.Error)
+	}
+	if _, started, _ := provider.counts(); started == 9 {
+		t.Fatal("エラー後も残りのシャードが開始されました")
+	}
+}
+
+func TestPerItemShardConcurrencyStopsOnCancel(t *testing.T) {
+	provider := &shardProbeProvider{}
+	eng, req := newShardProbeEngine(t, provider, 8)
+	req.Mode = ModeAsync
+
+	job, err := eng.RunJob(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ジョブ作成に失敗しました: %v", err)
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		if _, started, _ := provider.counts(); started == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("シャードが並行に開始されませんでした")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := eng.CancelJob(context.Background(), job.ID, "stop"); err != nil {
+		t.Fatalf("キャンセルに失敗しました: %v", err)
+	}
+	for {
+		if _, _, returned := provider.counts(); returned == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("実行中のシャードがキャンセルで中断されませんでした")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, started, _ := provider.counts(); started != 3 {
+		t.Fatalf("キャンセル後に新しいシャードが開始されました: %d", started)
+	}
+	if got, _ := eng.GetJob(context.Background(), job.ID); got.Status != JobStatusCancelled {
+		t.Fatalf("ジョブがキャンセルされていません: %s", got.Status)
+	}
+}
+
+func TestRunShardsSequentialByDefault(t *testing.T) {
+	var order []int
+	err := runShards(context.Background(), 4, 1, func(ctx context.Context, i int) error {
+		order = append(order, i)
+		if i == 2 {
+			return errors.New("boom")
+		}
+		return nil
+	})
+	if err == nil || err.Error() != "boom" || len(order) != 3 {
+		t.Fatalf("逐次実行はエラーで止まるべきです: %v %v", err, order)
+	}
+}
+
+// stubbornProvider ignores cancellation and answers after delay.
+type stubbornProvider struct {
+	delay    time.Duration
+	mu       sync.Mutex
+	started  int
+	returned int
+}
+
+func (p *stubbornProvider) Call(ctx context.Context, req ProviderRequest) (ProviderResponse, error) {
+	p.mu.Lock()
+	p.started++
+	p.mu.Unlock()
+	time.Sleep(p.delay)
+	p.mu.Lock()
+	p.returned++
+	p.mu.Unlock()
+	return ProviderResponse{Output: "done " + req.Input.Sources[0].Label}, nil
+}
+
+func (p *stubbornProvider) counts() (started, returned int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.started, p.returned
+}
+
+func TestFanOutShardFinishingAfterCancelKeepsJobCancelled(t *testing.T) {
+	provider := &stubbornProvider{delay: 150 * time.Millisecond}
+	eng := NewBasicEngine(snapshotStore{newCheckpointStore()})
+	t.Cleanup(eng.Close)
+	eng.providers.RegisterFactory("stubborn", func(ProviderProfile) Provider { return provider })
+	eng.providers.RegisterProfile(ProviderProfile{ID: "stubborn", Kind: "stubborn"})
+	eng.RegisterPipeline(PipelineDef{
+		Type:    "stubborn_fan_out",
+		Version: "v1",
+		Steps: []StepDef{
+			{
+				ID:                "docs",
+				Kind:              StepKindMap,
+				Mode:              StepModeFanOut,
+				ProviderProfileID: "stubborn",
+				Export:            true,
+				Config:            map[string]any{ShardConcurrencyConfigKey: 1},
+			},
+		},
+	})
+	job, err := eng.RunJob(context.Background(), JobRequest{PipelineType: "stubborn_fan_out", Mode: ModeAsync, Input: JobInput{Sources: []Source{
+		{Kind: SourceKindNote, Label: "a", Content: "x"},
+		{Kind: SourceKindNote, Label: "b", Content: "y"},
+	}}})
+	if err != nil {
+		t.Fatalf("ジョブ作成に失敗しました: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := eng.CancelJob(context.Background(), job.ID, "stop"); err != nil {
+		t.Fatalf("キャンセルに失敗しました: %v", err)
+	}
+
+	// キャンセル後に完了したシャードの保存がキャンセル状態を上書きしないこと。
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		got, _ := eng.GetJob(context.Background(), job.ID)
+		if _, returned := provider.counts(); returned == 1 && got.Result != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("完了したシャードの結果が保存されませんでした: %+v", got)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	got, _ := eng.GetJob(context.Background(), job.ID)
+	if got.Status != JobStatusCancelled {
+		t.Fatalf("キャンセルしたジョブの状態が上書きされました: %s", got.Status)
+	}
+	if len(got.Result.Items) != 1 || got.Result.Items[0].Data.(map[string]any)["text"] != "done a" {
+		t.Fatalf("キャンセル後に完了したシャードの結果が残っていません: %+v", got.Result)
+	}
+	if started, _ := provider.counts(); started != 1 {
+		t.Fatalf("キャンセル後に次のシャードが開始されました: %d", started)
+	}
+}
+
+// labelGateProvider answers sources labelled fast right away and waits for
+// cancellation on the others.
+type labelGateProvider struct{}
+
+func (labelGateProvider) Call(ctx context.Context, req ProviderRequest) (ProviderResponse, error) {
+	if req.Input.Sources[0].Label == "fast" {
+		return ProviderResponse{Output: "fast done"}, nil
+	}
+	<-ctx.Done()
+	return ProviderResponse{}, ctx.Err()
+}
+
+func TestSkippedFanOutStepDropsExportedShards(t *testing.T) {
+	eng := NewBasicEngine(snapshotStore{newCheckpointStore()})
+	t.Cleanup(eng.Close)
+	eng.providers.RegisterFactory("gate", func(ProviderProfile) Provider { return labelGateProvider{} })
+	eng.providers.RegisterProfile(ProviderProfile{ID: "gate", Kind: "gate"})
+	eng.RegisterPipeline(PipelineDef{
+		Type:    "skip_fan_out",
+		Version: "v1",
+		Steps: []StepDef{
+			{ID: "docs", Kind: StepKindMap, Mode: StepModeFanOut, ProviderProfileID: "gate", Export: true},
+			{ID: "after", Kind: StepKindLLM, DependsOn: []StepID{"docs"}},
+		},
+	})
+	job, err := eng.RunJob(context.Background(), JobRequest{PipelineType: "skip_fan_out", Mode: ModeAsync, Input: JobInput{Sources: []Source{
+		{Kind: SourceKindNote, Label: "fast", Content: "x"},
+		{Kind: SourceKindNote, Label: "slow", Content: "y"},
+	}}})
+	if err != nil {
+		t.Fatalf("ジョブ作成に失敗しました: %v", err)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		got, _ := eng.GetJob(context.Background(), job.ID)
+		if got.Result != nil && len(got.Result.Items) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("最初のシャードがエクスポートされませんでした: %+v", got.Result)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := eng.SkipStep(context.Background(), job.ID, "docs"); err != nil {
+		t.Fatalf("スキップに失敗しました: %v", err)
+	}
+	var got *Job
+	for {
+		got, _ = eng.GetJob(context.Background(), job.ID)
+		if got.Status == JobStatusSucceeded {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("ジョブが完了しませんでした: %s", got.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got.StepExecutions[0].Status != StepExecSkipped {
+		t.Fatalf("docs ステップが skipped になっていません: %+v", got.StepExecutions[0])
+	}
+	if len(got.Result.Items) != 0 {
+		t.Fatalf("スキップしたステップのシャード結果が残っています: %+v", got.Result.Items)
+	}
+}
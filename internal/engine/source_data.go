@@ -0,0 +1,88 @@
+package engine
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// MaxSourceDataBytes caps the decoded size of a single Source.Data payload.
+const MaxSourceDataBytes = 20 << 20
+
+// ErrInvalidSource reports a job source whose binary payload was rejected.
+var ErrInvalidSource = errors.New("invalid source")
+
+// validateSources checks binary payloads: Data must fit MaxSourceDataBytes and
+// carry a well-formed MimeType, and image/* payloads must actually look like
+// an image so providers are not sent mislabeled bytes.
+func validateSources(sources []Source) error {
+	for i, src := range sources {
+		if src.Redacted {
+			return fmt.Errorf("%w: sources[%d]: sensitive content was redacted and must be sent again", ErrInvalidSource, i)
+		}
+		if len(src.Data) == 0 {
+			if src.MimeType != "" {
+				return fmt.Errorf("%w: sources[%d]: mime_type requires data", ErrInvalidSource, i)
+			}
+			continue
+		}
+		if len(src.Data) > MaxSourceDataBytes {
+			return fmt.Errorf("%w: sources[%d]: data is %d bytes, limit is %d", ErrInvalidSource, i, len(src.Data), MaxSourceDataBytes)
+		}
+		if src.MimeType == "" {
+			return fmt.Errorf("%w: sources[%d]: mime_type is required with data", ErrInvalidSource, i)
+		}
+		mediaType, _, err := mime.ParseMediaType(src.MimeType)
+		if err != nil {
+			return fmt.Errorf("%w: sources[%d]: mime_type %q: %v", ErrInvalidSource, i, src.MimeType, err)
+		}
+		if strings.HasPrefix(mediaType, "image/") && !strings.HasPrefix(http.DetectContentType(src.Data), "image/") {
+			return fmt.Errorf("%w: sources[%d]: data does not match mime_type %s", ErrInvalidSource, i, mediaType)
+		}
+	}
+	return nil
+}
+
+// IsImage reports whether the source carries image bytes.
+func (s Source) IsImage() bool {
+	return len(s.Data) > 0 && strings.HasPrefix(strings.ToLower(s.MimeType), "image/")
+}
+
+// DataURL returns the payload as an RFC 2397 data URL.
+func (s Source) DataURL() string {
+	return "data:" + s.MimeType + ";base64," + base64.StdEncoding.EncodeToString(s.Data)
+}
+
+// BinaryResultData builds ResultItem.Data for ContentBinary items. The bytes
+// are stored base64-encoded under "data_base64" so they survive JSON.
+func BinaryResultData(data []byte, mimeType string) map[string]any {
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	return map[string]any{
+		"data_base64": base64.StdEncoding.EncodeToString(data),
+		"mime_type":   mimeType,
+	}
+}
+
+// BinaryData decodes the bytes and MIME type of a ContentBinary item built
+// with BinaryResultData, including after a JSON round trip.
+func (r ResultItem) BinaryData() ([]byte, string, error) {
+	data, ok := r.Data.(map[string]any)
+	if !ok {
+		return nil, "", errors.New("result item has no binary data")
+	}
+	encoded, ok := data["data_base64"].(string)
+	if !ok {
+		return nil, "", errors.New("result item has no data_base64")
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, "", fmt.Errorf("decode data_base64: %w", err)
+	}
+	mimeType, _ := data["mime_type"].(string)
+	return decoded, mimeType, nil
+}
@@ -0,0 +1,87 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// tinyPNG is a 1x1 transparent PNG.
+const tinyPNG = "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNk+M9QDwADhgGAWjR9awAAAABJRU5ErkJggg=="
+
+func decodeTinyPNG(t *testing.T) []byte {
+	t.Helper()
+	data, err := base64.StdEncoding.DecodeString(tinyPNG)
+	if err != nil {
+		t.Fatalf("failed to decode fixture: %v", err)
+	}
+	return data
+}
+
+func TestValidateSources(t *testing.T) {
+	png := decodeTinyPNG(t)
+	cases := []struct {
+		name    string
+		source  Source
+		wantErr bool
+	}{
+		{name: "text only", source: Source{Kind: SourceKindNote, Content: "memo"}},
+		{name: "image", source: Source{Kind: SourceKindRaw, Data: png, MimeType: "image/png"}},
+		{name: "pdf", source: Source{Kind: SourceKindRaw, Data: []byte("%PDF-1.4"), MimeType: "application/pdf"}},
+		{name: "missing mime type", source: Source{Kind: SourceKindRaw, Data: png}, wantErr: true},
+		{name: "malformed mime type", source: Source{Kind: SourceKindRaw, Data: png, MimeType: "image/"}, wantErr: true},
+		{name: "mislabeled image", source: Source{Kind: SourceKindRaw, Data: []byte("plain text"), MimeType: "image/png"}, wantErr: true},
+		{name: "mime type without data", source: Source{Kind: SourceKindNote, MimeType: "image/png"}, wantErr: true},
+		{name: "too large", source: Source{Kind: SourceKindRaw, Data: make([]byte, MaxSourceDataBytes+1), MimeType: "application/octet-stream"}, wantErr: true},
+	}
+	for _, tc := range cases {
+		err := validateSources([]Source{tc.source})
+		if tc.wantErr != (err != nil) {
+			t.Fatalf("%s: unexpected error: %v", tc.name, err)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidSource) {
+			t.Fatalf("%s: error should wrap ErrInvalidSource: %v", tc.name, err)
+		}
+	}
+}
+
+func TestSourceDataRoundTripsAsBase64(t *testing.T) {
+	png := decodeTinyPNG(t)
+	raw, err := json.Marshal(Source{Kind: SourceKindRaw, Data: png, MimeType: "image/png"})
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	if !bytes.Contains(raw, []byte(`"data":"`+tinyPNG+`"`)) {
+		t.Fatalf("data should be encoded as base64: %s", raw)
+	}
+	var decoded Source
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	if !bytes.Equal(decoded.Data, png) || !decoded.IsImage() {
+		t.Fatalf("source did not round-trip: %+v", decoded)
+	}
+}
+
+func TestBinaryResultItemRoundTrip(t *testing.T) {
+	payload := []byte{0x00, 0xff, 0x10, 0x80}
+	item := ResultItem{ID: "bin-1", ContentType: ContentBinary, Data: BinaryResultData(payload, "")}
+
+	raw, err := json.Marshal(item)
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	var decoded ResultItem
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	got, mimeType, err := decoded.BinaryData()
+	if err != nil {
+		t.Fatalf("BinaryData failed: %v", err)
+	}
+	if !bytes.Equal(got, payload) || mimeType != "application/octet-stream" {
+		t.Fatalf("binary item did not round-trip: %v %s", got, mimeType)
+	}
+}
@@ -0,0 +1,97 @@
+package engine
+
+import (
+	"cmp"
+	"slices"
+)
+
+const (
+	// SourceOrderConfigKey selects the SourceOrder a step's prompt and
+	// provider see its sources in.
+	SourceOrderConfigKey = "source_order"
+	// MaxSourcesConfigKey keeps only the first sources of a step, after
+	// ordering, so the prompt can be bounded by count.
+	MaxSourcesConfigKey = "max_sources"
+)
+
+// SourceOrder controls the order of a step's sources. Input order is the
+// default; priority orders them by Source.Priority, highest first, keeping the
+// input order among equal priorities.
+type SourceOrder string
+
+const (
+	SourceOrderInput    SourceOrder = "input"
+	SourceOrderPriority SourceOrder = "priority"
+)
+
+// prioritizeSources applies the step's source_order and max_sources to
+// sources. Without either key sources are returned unchanged.
+func prioritizeSources(step StepDef, sources []Source) []Source {
+	if order, _ := step.ConfigString(SourceOrderConfigKey); SourceOrder(order) == SourceOrderPriority {
+		sources = sortSourcesByPriority(sources)
+	}
+	if limit, ok := step.ConfigInt(MaxSourcesConfigKey); ok && limit > 0 && len(sources) > limit {
+		sources = sources[:limit]
+	}
+	return sources
+}
+
+// sortSourcesByPriority returns a copy of sources ordered by descending
+// priority; sources of equal priority keep their order.
+func sortSourcesByPriority(sources []Source) []Source {
+	sorted := slices.Clone(sources)
+	slices.SortStableFunc(sorted, func(a, b Source) int {
+		return cmp.Compare(b.Priority, a.Priority)
+	})
+	return sorted
+}
+
+// topSources returns the n sources of highest priority in priority order.
+func topSources(n int, sources []Source) []Source {
+	sorted := sortSourcesByPriority(sources)
+	if n < 0 {
+		n = 0
+	}
+	if len(sorted) > n {
+		sorted = sorted[:n]
+	}
+	return sorted
+}
+
+// dropLowPrioritySources removes whole sources, lowest priority first and the
+// later of equal priorities first, until at least drop runes are gone. The
+// remaining sources keep their order. Sources carrying only binary data free
+// no runes and are kept.
+func dropLowPrioritySources(sources []Source, drop int) []Source {
+	if drop <= 0 {
+		return sources
+	}
+	order := make([]int, len(sources))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		if c := cmp.Compare(sources[a].Priority, sources[b].Priority); c != 0 {
+			return c
+		}
+		return cmp.Compare(b, a)
+	})
+	dropped := make([]bool, len(sources))
+	for _, i := range order {
+		if drop <= 0 {
+			break
+		}
+		if sources[i].Content == "" {
+			continue
+		}
+		dropped[i] = true
+		drop -= sourceRunes(sources[i : i+1])
+	}
+	out := make([]Source, 0, len(sources))
+	for i, src := range sources {
+		if !dropped[i] {
+			out = append(out, src)
+		}
+	}
+	return out
+}
@@ -0,0 +1,86 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ErrInvalidInput reports a job whose input does not satisfy the pipeline's
+// declared requirements; see SourceRequirementError.
+var ErrInvalidInput = errors.New("invalid input")
+
+// SourceRequirement declares the sources a pipeline needs, checked by RunJob
+// before the job is created. MinCount is the minimum number of sources and
+// Kinds, when set, the only source kinds accepted.
+type SourceRequirement struct {
+	MinCount int          `json:"min_count,omitempty"`
+	Kinds    []SourceKind `json:"kinds,omitempty"`
+}
+
+// SourceRequirementError reports job sources rejected by a pipeline's
+// RequiredSources. It matches ErrInvalidInput.
+type SourceRequirementError struct {
+	Pipeline    PipelineType
+	Requirement SourceRequirement
+	// Count is the number of sources submitted and InvalidKinds the indexes
+	// of sources whose kind is not allowed.
+	Count        int
+	InvalidKinds []int
+}
+
+func (e *SourceRequirementError) Error() string {
+	var problems []string
+	if e.Count < e.Requirement.MinCount {
+		problems = append(problems, fmt.Sprintf("requires at least %d source(s), got %d", e.Requirement.MinCount, e.Count))
+	}
+	if len(e.InvalidKinds) > 0 {
+		kinds := make([]string, len(e.Requirement.Kinds))
+		for i, kind := range e.Requirement.Kinds {
+			kinds[i] = string(kind)
+		}
+		problems = append(problems, fmt.Sprintf("sources %v have kinds outside %s", e.InvalidKinds, strings.Join(kinds, ", ")))
+	}
+	return fmt.Sprintf("pipeline %s %s", e.Pipeline, strings.Join(problems, "; "))
+}
+
+func (e *SourceRequirementError) Is(target error) bool {
+	return target == ErrInvalidInput
+}
+
+// Details returns the error details reported to API clients.
+func (e *SourceRequirementError) Details() map[string]any {
+	details := map[string]any{"count": e.Count}
+	if e.Requirement.MinCount > 0 {
+		details["min_count"] = e.Requirement.MinCount
+	}
+	if len(e.Requirement.Kinds) > 0 {
+		details["kinds"] = e.Requirement.Kinds
+	}
+	if len(e.InvalidKinds) > 0 {
+		details["invalid_sources"] = e.InvalidKinds
+	}
+	return details
+}
+
+// checkRequiredSources validates sources against the pipeline's
+// RequiredSources; pipelines without the declaration accept any input.
+func checkRequiredSources(def *PipelineDef, sources []Source) error {
+	req := def.RequiredSources
+	if req == nil {
+		return nil
+	}
+	var invalid []int
+	if len(req.Kinds) > 0 {
+		for i, src := range sources {
+			if !slices.Contains(req.Kinds, src.Kind) {
+				invalid = append(invalid, i)
+			}
+		}
+	}
+	if len(sources) >= req.MinCount && len(invalid) == 0 {
+		return nil
+	}
+	return &SourceRequirementError{Pipeline: def.Type, Requirement: *req, Count: len(sources), InvalidKinds: invalid}
+}
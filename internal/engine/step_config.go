@@ -0,0 +1,134 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// EmptyOutputConfigKey selects the EmptyOutputPolicy of a step.
+const EmptyOutputConfigKey = "empty_output"
+
+type stepConfigKind int
+
+const (
+	stepConfigInt stepConfigKind = iota
+	stepConfigString
+)
+
+// stepConfigKey describes a StepDef.Config key the engine reads. kinds limits
+// the step kinds the key applies to; empty means every kind.
+type stepConfigKey struct {
+	typ    stepConfigKind
+	kinds  []StepKind
+	values []string
+}
+
+var knownStepConfigKeys = map[string]stepConfigKey{
+	EmptyOutputConfigKey: {
+		typ:    stepConfigString,
+		values: []string{string(EmptyOutputFail), string(EmptyOutputRetry), string(EmptyOutputFallback)},
+	},
+	MaxToolIterationsConfigKey:    {typ: stepConfigInt},
+	StepTimeoutConfigKey:          {typ: stepConfigInt},
+	ProviderRetriesConfigKey:      {typ: stepConfigInt},
+	ProviderRetryBackoffConfigKey: {typ: stepConfigInt},
+	MaxFanOutConfigKey:            {typ: stepConfigInt},
+	ShardConcurrencyConfigKey:     {typ: stepConfigInt},
+	ReduceTokenThresholdConfigKey: {typ: stepConfigInt, kinds: []StepKind{StepKindReduce}},
+	ReduceBatchSizeConfigKey:      {typ: stepConfigInt, kinds: []StepKind{StepKindReduce}},
+	RetrieveQueryStepConfigKey:    {typ: stepConfigString, kinds: []StepKind{StepKindRetrieve}},
+	RetrieveTopKConfigKey:         {typ: stepConfigInt, kinds: []StepKind{StepKindRetrieve}},
+	RetrieveSimilarityConfigKey: {
+		typ:    stepConfigString,
+		kinds:  []StepKind{StepKindRetrieve},
+		values: []string{string(SimilarityCosine), string(SimilarityDot), string(SimilarityEuclidean)},
+	},
+	SystemPromptOverrideConfigKey: {
+		typ:    stepConfigString,
+		values: []string{systemPromptOverrideApply, systemPromptOverrideIgnore},
+	},
+	FanOutOverflowConfigKey: {
+		typ:    stepConfigString,
+		values: []string{string(FanOutOverflowReject), string(FanOutOverflowTruncate)},
+	},
+	SourceOrderConfigKey: {
+		typ:    stepConfigString,
+		values: []string{string(SourceOrderInput), string(SourceOrderPriority)},
+	},
+	MaxSourcesConfigKey: {typ: stepConfigInt},
+}
+
+// ConfigInt returns Config[key] as an int. Numbers and numeric strings are
+// accepted; ok is false when the key is missing or not an integer.
+func (s StepDef) ConfigInt(key string) (int, bool) {
+	return extraInt(s.Config, key)
+}
+
+// ConfigString returns Config[key] trimmed, or ok=false when the key is
+// missing or not a string.
+func (s StepDef) ConfigString(key string) (string, bool) {
+	v, ok := s.Config[key].(string)
+	if !ok {
+		return "", false
+	}
+	return strings.TrimSpace(v), true
+}
+
+// validateStepConfig reports Config keys the engine does not know, keys that
+// do not apply to the step's kind, and values of the wrong type.
+func validateStepConfig(step StepDef) error {
+	keys := make([]string, 0, len(step.Config))
+	for key := range step.Config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var errs []error
+	for _, key := range keys {
+		known, ok := knownStepConfigKeys[key]
+		if !ok {
+			errs = append(errs, fmt.Errorf("step %s: unknown config key %q", step.ID, key))
+			continue
+		}
+		if len(known.kinds) > 0 && !containsStepKind(known.kinds, step.Kind) {
+			errs = append(errs, fmt.Errorf("step %s: config key %q does not apply to %s steps", step.ID, key, step.Kind))
+			continue
+		}
+		switch known.typ {
+		case stepConfigInt:
+			if _, ok := step.ConfigInt(key); !ok {
+				errs = append(errs, fmt.Errorf("step %s: config key %q must be an integer", step.ID, key))
+			}
+		case stepConfigString:
+			value, ok := step.ConfigString(key)
+			if !ok {
+				errs = append(errs, fmt.Errorf("step %s: config key %q must be a string", step.ID, key))
+				continue
+			}
+			if len(known.values) > 0 && !containsString(known.values, strings.ToLower(value)) {
+				errs = append(errs, fmt.Errorf("step %s: config key %q must be one of %s", step.ID, key, strings.Join(known.values, ", ")))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func containsStepKind(kinds []StepKind, kind StepKind) bool {
+	for _, k := range kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
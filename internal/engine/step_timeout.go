@@ -0,0 +1,37 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// StepTimeoutConfigKey bounds a step's whole run, provider calls, retries
+// and fallbacks included, in milliseconds.
+const StepTimeoutConfigKey = "timeout_ms"
+
+// stepContext derives the context a step runs under: cancellable for
+// SkipStep and bounded by the step's timeout_ms when set.
+func stepContext(ctx context.Context, step StepDef) (context.Context, context.CancelFunc) {
+	if ms, ok := step.ConfigInt(StepTimeoutConfigKey); ok && ms > 0 {
+		return context.WithTimeout(ctx, time.Duration(ms)*time.Millisecond)
+	}
+	return context.WithCancel(ctx)
+}
+
+// stepTimeoutError reports a step that exceeded its timeout_ms as
+// step_timeout. Outputs of earlier steps stay exported and checkpointed, and
+// the chunks the step streamed before the deadline stay on its
+// StepExecution, so the job can be rerun from this step with ReuseUpstream.
+func stepTimeoutError(stepCtx context.Context, step StepDef, err error) error {
+	if !errors.Is(stepCtx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+	ms, _ := step.ConfigInt(StepTimeoutConfigKey)
+	return &stepError{
+		code:    "step_timeout",
+		err:     fmt.Errorf("step %s timed out after %dms: %w", step.ID, ms, err),
+		details: map[string]any{"timeout_ms": ms},
+	}
+}
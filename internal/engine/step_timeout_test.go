@@ -0,0 +1,152 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// checkpointStore is a minimal JobStore that also keeps step checkpoints.
+type checkpointStore struct {
+	mu          sync.Mutex
+	jobs        map[string]*Job
+	checkpoints map[string]map[StepID][]ResultItem
+}
+
+func newCheckpointStore() *checkpointStore {
+	return &checkpointStore{jobs: map[string]*Job{}, checkpoints: map[string]map[StepID][]ResultItem{}}
+}
+
+func (s *checkpointStore) CreateJob(ctx context.Context, job *Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.jobs[job.ID]; ok {
+		return ErrJobExists
+	}
+	s.jobs[job.ID] = job
+	return nil
+}
+
+func (s *checkpointStore) UpdateJob(ctx context.Context, job *Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[job.ID] = job
+	return nil
+}
+
+func (s *checkpointStore) GetJob(ctx context.Context, id string) (*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, errors.New("job not found")
+	}
+	return job, nil
+}
+
+func (s *checkpointStore) ListJobs(ctx context.Context) ([]*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := make([]*Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+func (s *checkpointStore) SaveCheckpoint(jobID string, stepID StepID, items []ResultItem) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.checkpoints[jobID] == nil {
+		s.checkpoints[jobID] = map[StepID][]ResultItem{}
+	}
+	s.checkpoints[jobID][stepID] = items
+}
+
+func (s *checkpointStore) LoadCheckpoints(jobID string) map[StepID][]ResultItem {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.checkpoints[jobID]
+}
+
+func (s *checkpointStore) ClearCheckpoints(jobID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.checkpoints, jobID)
+}
+
+// stallingProvider streams its chunks and then blocks until the call is
+// cancelled.
+type stallingProvider struct {
+	chunks []string
+}
+
+func (p stallingProvider) Call(ctx context.Context, req ProviderRequest) (ProviderResponse, error) {
+	<-ctx.Done()
+	return ProviderResponse{}, ctx.Err()
+}
+
+func (p stallingProvider) CallStream(ctx context.Context, req ProviderRequest) (<-chan ProviderChunk, func() (ProviderResponse, error), error) {
+	ch := make(chan ProviderChunk, len(p.chunks))
+	for _, c := range p.chunks {
+		ch <- ProviderChunk{Content: c}
+	}
+	close(ch)
+	return ch, func() (ProviderResponse, error) {
+		<-ctx.Done()
+		return ProviderResponse{}, ctx.Err()
+	}, nil
+}
+
+func TestStepTimeoutKeepsPartialResults(t *testing.T) {
+	jobStore := newCheckpointStore()
+	eng := NewBasicEngine(jobStore)
+	defer eng.Close()
+	eng.providers.RegisterFactory("stalling", func(ProviderProfile) Provider {
+		return stallingProvider{chunks: []string{"途中", "まで"}}
+	})
+	eng.providers.RegisterProfile(ProviderProfile{ID: "slow", Kind: "stalling"})
+	eng.RegisterPipeline(PipelineDef{
+		Type:    "timeout_pipeline",
+		Version: "v1",
+		Steps: []StepDef{
+			{ID: "collect", Kind: StepKindMap, Mode: StepModeSingle, Export: true},
+			{
+				ID:                "draft",
+				Kind:              StepKindLLM,
+				Mode:              StepModeSingle,
+				DependsOn:         []StepID{"collect"},
+				ProviderProfileID: "slow",
+				Export:            true,
+				Config:            map[string]any{StepTimeoutConfigKey: 50},
+			},
+		},
+	})
+
+	job, err := eng.RunJob(context.Background(), JobRequest{
+		PipelineType: "timeout_pipeline",
+		Mode:         "sync",
+		Input:        JobInput{Sources: []Source{{Kind: SourceKindNote, Content: "memo"}}},
+	})
+	if err != nil {
+		t.Fatalf("ジョブ実行に失敗しました: %v", err)
+	}
+	if job.Status != JobStatusFailed || job.Error == nil || job.Error.Code != "step_timeout" {
+		t.Fatalf("step_timeout で失敗するはずです: status=%s err=%+v", job.Status, job.Error)
+	}
+	if job.Result == nil || len(job.Result.Items) != 1 || job.Result.Items[0].StepID != "collect" {
+		t.Fatalf("完了済みステップの結果が残っていません: %+v", job.Result)
+	}
+	draft := job.StepExecutions[1]
+	if draft.Status != StepExecFailed || len(draft.Chunks) != 2 || draft.Chunks[0].Content != "途中" || draft.Chunks[1].Index != 1 {
+		t.Fatalf("タイムアウトしたステップの chunk が保持されていません: %+v", draft)
+	}
+	checkpoints := jobStore.LoadCheckpoints(job.ID)
+	if len(checkpoints["collect"]) != 1 {
+		t.Fatalf("完了済みステップの checkpoint がありません: %+v", checkpoints)
+	}
+	if _, ok := checkpoints["draft"]; ok {
+		t.Fatalf("タイムアウトしたステップの checkpoint が保存されています: %+v", checkpoints)
+	}
+}
@@ -0,0 +1,71 @@
+package engine
+
+import "encoding/json"
+
+// JobStatusEvent is the payload of job_queued, job_started, job_status,
+// job_completed, job_failed, job_cancelled and stream_finished events.
+type JobStatusEvent struct {
+	Job
+}
+
+// StepEvent is the payload of step_* events.
+type StepEvent struct {
+	StepExecution
+}
+
+// ChunkEvent is the payload of provider_chunk events.
+type ChunkEvent struct {
+	StepChunk
+}
+
+// ItemEvent is the payload of item_completed events.
+type ItemEvent struct {
+	ResultItem
+}
+
+// The payload types embed the domain types, so they marshal to exactly the
+// same JSON the engine emits for *Job, StepExecution, StepChunk and ResultItem.
+
+// UnmarshalJSON decodes Data into the payload type matching Event
+// (JobStatusEvent, StepEvent, ChunkEvent or ItemEvent). Unknown events, such
+// as error, keep the generic decoding of encoding/json.
+func (e *StreamingEvent) UnmarshalJSON(b []byte) error {
+	var raw struct {
+		Seq   uint64          `json:"seq"`
+		Event string          `json:"event"`
+		JobID string          `json:"job_id"`
+		Data  json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	*e = StreamingEvent{Seq: raw.Seq, Event: raw.Event, JobID: raw.JobID}
+	if len(raw.Data) == 0 || string(raw.Data) == "null" {
+		return nil
+	}
+
+	var err error
+	switch raw.Event {
+	case EventJobQueued, EventJobStarted, EventJobStatus, EventJobCompleted, EventJobFailed, EventJobCancelled, EventStreamFinished:
+		var payload JobStatusEvent
+		err = json.Unmarshal(raw.Data, &payload)
+		e.Data = payload
+	case EventStepStarted, EventStepCompleted, EventStepFailed, EventStepCancelled, EventStepSkipped:
+		var payload StepEvent
+		err = json.Unmarshal(raw.Data, &payload)
+		e.Data = payload
+	case EventProviderChunk:
+		var payload ChunkEvent
+		err = json.Unmarshal(raw.Data, &payload)
+		e.Data = payload
+	case EventItemCompleted:
+		var payload ItemEvent
+		err = json.Unmarshal(raw.Data, &payload)
+		e.Data = payload
+	default:
+		var payload interface{}
+		err = json.Unmarshal(raw.Data, &payload)
+		e.Data = payload
+	}
+	return err
+}
@@ -0,0 +1,72 @@
+package engine
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestStreamingEventUnmarshalTypedPayloads(t *testing.T) {
+	now := time.Unix(0, 0).UTC()
+	job := &Job{ID: "job-1", PipelineType: "demo", Status: JobStatusRunning, CreatedAt: now, UpdatedAt: now}
+	events := []StreamingEvent{
+		{Seq: 1, Event: "job_status", JobID: "job-1", Data: job},
+		{Seq: 2, Event: "step_started", JobID: "job-1", Data: StepExecution{StepID: "step-1", Status: StepExecRunning}},
+		{Seq: 3, Event: "provider_chunk", JobID: "job-1", Data: StepChunk{StepID: "step-1", Index: 0, Content: "hi"}},
+		{Seq: 4, Event: "item_completed", JobID: "job-1", Data: ResultItem{ID: "item-1", StepID: "step-1", ContentType: ContentText, Data: map[string]any{"text": "hi"}}},
+		{Seq: 5, Event: "error", JobID: "job-1", Data: "boom"},
+		{Event: "heartbeat", JobID: "job-1"},
+	}
+
+	for _, want := range events {
+		raw, err := json.Marshal(want)
+		if err != nil {
+			t.Fatalf("%s のエンコードに失敗しました: %v", want.Event, err)
+		}
+		var got StreamingEvent
+		if err := json.Unmarshal(raw, &got); err != nil {
+			t.Fatalf("%s のデコードに失敗しました: %v", want.Event, err)
+		}
+		if got.Seq != want.Seq || got.Event != want.Event || got.JobID != want.JobID {
+			t.Fatalf("共通フィールドが一致しません: %+v", got)
+		}
+
+		switch data := got.Data.(type) {
+		case JobStatusEvent:
+			if data.ID != "job-1" || data.Status != JobStatusRunning {
+				t.Fatalf("JobStatusEvent の内容が不正です: %+v", data)
+			}
+		case StepEvent:
+			if data.StepID != "step-1" || data.Status != StepExecRunning {
+				t.Fatalf("StepEvent の内容が不正です: %+v", data)
+			}
+		case ChunkEvent:
+			if data.Content != "hi" {
+				t.Fatalf("ChunkEvent の内容が不正です: %+v", data)
+			}
+		case ItemEvent:
+			if text, _ := data.Data.(map[string]any)["text"].(string); data.ID != "item-1" || text != "hi" {
+				t.Fatalf("ItemEvent の内容が不正です: %+v", data)
+			}
+		case string:
+			if want.Event != "error" || data != "boom" {
+				t.Fatalf("error イベントの内容が不正です: %+v", got)
+			}
+		case nil:
+			if want.Data != nil {
+				t.Fatalf("%s の data が失われました", want.Event)
+			}
+		default:
+			t.Fatalf("%s の data 型が想定外です: %T", want.Event, got.Data)
+		}
+
+		// 型付きペイロードを再エンコードしてもワイヤ形式は変わらない。
+		again, err := json.Marshal(got)
+		if err != nil {
+			t.Fatalf("%s の再エンコードに失敗しました: %v", want.Event, err)
+		}
+		if string(again) != string(raw) {
+			t.Fatalf("ワイヤ形式が変化しました:\n%s\n%s", raw, again)
+		}
+	}
+}
@@ -1,12 +1,30 @@
 package engine
 
+import "sort"
+
 // StreamingTracker tracks job state to emit incremental StreamingEvent values.
+//
+// A stream opens with the job_queued event returned by Queued. Events
+// produced by a single Diff follow a fixed order so steps that change in the
+// same snapshot are reported deterministically:
+//
+//  1. job_started / job_status (also emitted when only Progress changed)
+//  2. step events grouped per step in StepExecutions order: step_started,
+//     then the step's provider_chunk events, then its terminal step event
+//  3. item_completed events grouped by step in StepExecutions order
+//  4. job_completed / job_failed / job_cancelled and stream_finished
+//
+// Every event is numbered with a Seq that increases monotonically across
+// Queued and Diff calls, starting at 1.
 type StreamingTracker struct {
 	lastStatus    JobStatus
+	lastProgress  float64
 	stepStatus    map[StepID]StepExecutionStatus
 	lastItemCount int
+	sentQueued    bool
 	sentStarted   bool
 	chunkCount    map[StepID]int
+	seq           uint64
 }
 
 // NewStreamingTracker returns an initialized tracker.
@@ -14,6 +32,17 @@ func NewStreamingTracker() *StreamingTracker {
 	return &StreamingTracker{stepStatus: map[StepID]StepExecutionStatus{}, chunkCount: map[StepID]int{}}
 }
 
+// Queued returns the job_queued event for the job as accepted by RunJob. It
+// is returned only once per tracker; later calls return nil.
+func (t *StreamingTracker) Queued(job *Job) []StreamingEvent {
+	if job == nil || t.sentQueued {
+		return nil
+	}
+	t.sentQueued = true
+	t.seq++
+	return []StreamingEvent{{Seq: t.seq, Event: "job_queued", JobID: job.ID, Data: job}}
+}
+
 // Diff compares the provided job against prior state and returns events to emit.
 func (t *StreamingTracker) Diff(job *Job) []StreamingEvent {
 	if job == nil {
@@ -21,7 +50,13 @@ func (t *StreamingTracker) Diff(job *Job) []StreamingEvent {
 	}
 	events := make([]StreamingEvent, 0, 4)
 
+	var terminal []StreamingEvent
+	if job.Status == t.lastStatus && job.Progress != t.lastProgress {
+		t.lastProgress = job.Progress
+		events = append(events, StreamingEvent{Event: "job_status", JobID: job.ID, Data: job})
+	}
 	if job.Status != t.lastStatus {
+		t.lastProgress = job.Progress
 		if job.Status == JobStatusRunning && !t.sentStarted {
 			events = append(events, StreamingEvent{Event: "job_started", JobID: job.ID, Data: job})
 			t.sentStarted = true
@@ -36,12 +71,15 @@ func (t *StreamingTracker) Diff(job *Job) []StreamingEvent {
 			case JobStatusCancelled:
 				name = "job_cancelled"
 			}
-			events = append(events, StreamingEvent{Event: name, JobID: job.ID, Data: job})
-			events = append(events, StreamingEvent{Event: "stream_finished", JobID: job.ID, Data: job})
+			terminal = append(terminal,
+				StreamingEvent{Event: name, JobID: job.ID, Data: job},
+				StreamingEvent{Event: "stream_finished", JobID: job.ID, Data: job},
+			)
 		}
 	}
 
 	for _, step := range job.StepExecutions {
+		var finished *StreamingEvent
 		prev := t.stepStatus[step.StepID]
 		if step.Status != prev {
 			t.stepStatus[step.StepID] = step.Status
@@ -49,22 +87,24 @@ func (t *StreamingTracker) Diff(job *Job) []StreamingEvent {
 			case StepExecRunning:
 				events = append(events, StreamingEvent{Event: "step_started", JobID: job.ID, Data: step})
 			case StepExecSuccess:
-				events = append(events, StreamingEvent{Event: "step_completed", JobID: job.ID, Data: step})
+				finished = &StreamingEvent{Event: "step_completed", JobID: job.ID, Data: step}
 			case StepExecFailed:
-				events = append(events, StreamingEvent{Event: "step_failed", JobID: job.ID, Data: step})
+				finished = &StreamingEvent{Event: "step_failed", JobID: job.ID, Data: step}
 			case StepExecCancelled:
-				events = append(events, StreamingEvent{Event: "step_cancelled", JobID: job.ID, Data: step})
+				finished = &StreamingEvent{Event: "step_cancelled", JobID: job.ID, Data: step}
+			case StepExecSkipped:
+				finished = &StreamingEvent{Event: "step_skipped", JobID: job.ID, Data: step}
 			}
 		}
 
-		if len(step.Chunks) > 0 {
-			seen := t.chunkCount[step.StepID]
-			if len(step.Chunks) > seen {
-				for _, chunk := range step.Chunks[seen:] {
-					events = append(events, StreamingEvent{Event: "provider_chunk", JobID: job.ID, Data: chunk})
-				}
-				t.chunkCount[step.StepID] = len(step.Chunks)
+		if seen := t.chunkCount[step.StepID]; len(step.Chunks) > seen {
+			for _, chunk := range step.Chunks[seen:] {
+				events = append(events, StreamingEvent{Event: "provider_chunk", JobID: job.ID, Data: chunk})
 			}
+			t.chunkCount[step.StepID] = len(step.Chunks)
+		}
+		if finished != nil {
+			events = append(events, *finished)
 		}
 	}
 
@@ -72,12 +112,39 @@ func (t *StreamingTracker) Diff(job *Job) []StreamingEvent {
 	if job.Result != nil {
 		itemCount = len(job.Result.Items)
 	}
-	if job.Result != nil && itemCount > t.lastItemCount {
-		for i := t.lastItemCount; i < len(job.Result.Items); i++ {
-			events = append(events, StreamingEvent{Event: "item_completed", JobID: job.ID, Data: job.Result.Items[i]})
+	if itemCount > t.lastItemCount {
+		for _, item := range itemsByStep(job.StepExecutions, job.Result.Items[t.lastItemCount:]) {
+			events = append(events, StreamingEvent{Event: "item_completed", JobID: job.ID, Data: item})
 		}
 	}
 	t.lastItemCount = itemCount
 
+	events = append(events, terminal...)
+	for i := range events {
+		t.seq++
+		events[i].Seq = t.seq
+	}
 	return events
 }
+
+// itemsByStep stably orders items by the position of their step in execs.
+// Items from steps not listed keep their relative order at the end.
+func itemsByStep(execs []StepExecution, items []ResultItem) []ResultItem {
+	if len(items) < 2 {
+		return items
+	}
+	order := make(map[StepID]int, len(execs))
+	for i, exec := range execs {
+		order[exec.StepID] = i
+	}
+	rank := func(item ResultItem) int {
+		if idx, ok := order[item.StepID]; ok {
+			return idx
+		}
+		return len(execs)
+	}
+	sorted := make([]ResultItem, len(items))
+	copy(sorted, items)
+	sort.SliceStable(sorted, func(i, j int) bool { return rank(sorted[i]) < rank(sorted[j]) })
+	return sorted
+}
@@ -1,6 +1,11 @@
 package engine
 
-import "testing"
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
 
 func TestStreamingTrackerEmitsJobAndStepEvents(t *testing.T) {
 	tracker := NewStreamingTracker()
@@ -43,6 +48,23 @@ if !containsEvent(events, "stream_finished") {
 	}
 }
 
+func TestStreamingTrackerQueuedOpensStreamOnce(t *testing.T) {
+	tracker := NewStreamingTracker()
+	job := &Job{ID: "job-q", Status: JobStatusQueued}
+
+	events := tracker.Queued(job)
+	if len(events) != 1 || events[0].Event != "job_queued" || events[0].Seq != 1 {
+		t.Fatalf("job_queued イベントが想定外です: %+v", events)
+	}
+	if again := tracker.Queued(job); again != nil {
+		t.Fatalf("job_queued が再送されています: %+v", again)
+	}
+	events = tracker.Diff(job)
+	if len(events) == 0 || events[0].Seq != 2 {
+		t.Fatalf("Diff の seq が job_queued から続いていません: %+v", events)
+	}
+}
+
 func TestStreamingTrackerEmitsChunkWhileRunning(t *testing.T) {
 	tracker := NewStreamingTracker()
 	job := &Job{ID: "job-2", Status: JobStatusRunning, StepExecutions: []StepExecution{{StepID: StepID("step-run"), Status: StepExecRunning}}}
@@ -59,6 +81,239 @@ func TestStreamingTrackerEmitsChunkWhileRunning(t *testing.T) {
 	}
 }
 
+// snapshotStore copies jobs in and out like the memory store, so a stream
+// reading the store does not share the job the engine is updating.
+type snapshotStore struct {
+	*checkpointStore
+}
+
+func (s snapshotStore) CreateJob(ctx context.Context, job *Job) error {
+	return s.checkpointStore.CreateJob(ctx, snapshotJob(job))
+}
+
+func (s snapshotStore) UpdateJob(ctx context.Context, job *Job) error {
+	return s.checkpointStore.UpdateJob(ctx, snapshotJob(job))
+}
+
+func (s snapshotStore) GetJob(ctx context.Context, id string) (*Job, error) {
+	job, err := s.checkpointStore.GetJob(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return snapshotJob(job), nil
+}
+
+func snapshotJob(job *Job) *Job {
+	out := *job
+	out.StepExecutions = slices.Clone(job.StepExecutions)
+	for i := range out.StepExecutions {
+		out.StepExecutions[i].Chunks = slices.Clone(out.StepExecutions[i].Chunks)
+	}
+	if job.Result != nil {
+		result := *job.Result
+		result.Items = slices.Clone(job.Result.Items)
+		out.Result = &result
+	}
+	return &out
+}
+
+func TestStreamingEmitsReduceStepChunksInOrder(t *testing.T) {
+	eng := NewBasicEngine(snapshotStore{newCheckpointStore()})
+	t.Cleanup(eng.Close)
+	chunks := []ProviderChunk{{Content: "part-0"}, {Content: "part-1"}, {Content: "tail"}}
+	eng.providers.RegisterFactory("reducer", func(ProviderProfile) Provider {
+		return batchStubProvider{resp: ProviderResponse{Output: "merged", Chunks: chunks}}
+	})
+	eng.providers.RegisterProfile(ProviderProfile{ID: "reducer", Kind: "reducer"})
+	eng.RegisterPipeline(PipelineDef{
+		Type:    "reduce_stream",
+		Version: "v1",
+		Steps: []StepDef{
+			{ID: "shards", Kind: StepKindMap, Mode: StepModeFanOut},
+			{ID: "merge", Kind: StepKindReduce, DependsOn: []StepID{"shards"}, ProviderProfileID: "reducer", Export: true},
+		},
+	})
+	req := JobRequest{PipelineType: "reduce_stream", Mode: ModeAsync, Input: JobInput{Sources: []Source{
+		{Kind: SourceKindNote, Label: "a", Content: "alpha"},
+		{Kind: SourceKindNote, Label: "b", Content: "beta"},
+	}}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, _, err := eng.RunJobStream(ctx, req)
+	if err != nil {
+		t.Fatalf("ストリームの開始に失敗しました: %v", err)
+	}
+	var received []StreamingEvent
+	for evt := range stream {
+		received = append(received, evt)
+	}
+
+	var got []StepChunk
+	var completed *StepExecution
+	for _, evt := range received {
+		switch data := evt.Data.(type) {
+		case StepChunk:
+			if data.StepID != "merge" {
+				continue
+			}
+			if completed != nil {
+				t.Fatalf("step_completed の後に reduce ステップの chunk が流れています: %+v", received)
+			}
+			got = append(got, data)
+		case StepExecution:
+			if evt.Event == "step_completed" && data.StepID == "merge" {
+				completed = &data
+			}
+		}
+	}
+	if completed == nil || completed.Status != StepExecSuccess || completed.ChunkCount != len(chunks) {
+		t.Fatalf("reduce ステップの step_completed が想定外です: %+v", completed)
+	}
+	if len(got) != len(chunks) {
+		t.Fatalf("reduce ステップの chunk 数が想定外です: %+v", got)
+	}
+	for i, chunk := range got {
+		if chunk.Index != i || chunk.Content != chunks[i].Content {
+			t.Fatalf("reduce ステップの chunk 順序が不正です: %+v", got)
+		}
+	}
+	if last := received[len(received)-1]; last.Event != "stream_finished" {
+		t.Fatalf("ストリームが stream_finished で終わっていません: %+v", last)
+	}
+}
+
+// gatedStreamingProvider streams its first chunk right away and the rest
+// only after release is closed.
+type gatedStreamingProvider struct {
+	release chan struct{}
+}
+
+func (p gatedStreamingProvider) Call(ctx context.Context, req ProviderRequest) (ProviderResponse, error) {
+	return ProviderResponse{}, nil
+}
+
+func (p gatedStreamingProvider) CallStream(ctx context.Context, req ProviderRequest) (<-chan ProviderChunk, func() (ProviderResponse, error), error) {
+	ch := make(chan ProviderChunk)
+	go func() {
+		defer close(ch)
+		ch <- ProviderChunk{Content: "first"}
+		<-p.release
+		ch <- ProviderChunk{Content: "second"}
+	}()
+	return ch, func() (ProviderResponse, error) {
+		return ProviderResponse{Output: "first second"}, nil
+	}, nil
+}
+
+func TestStreamingEmitsChunksBeforeProviderReturns(t *testing.T) {
+	eng := NewBasicEngine(snapshotStore{newCheckpointStore()})
+	t.Cleanup(eng.Close)
+	provider := gatedStreamingProvider{release: make(chan struct{})}
+	eng.providers.RegisterFactory("gated", func(ProviderProfile) Provider { return provider })
+	eng.providers.RegisterProfile(ProviderProfile{ID: "gated", Kind: "gated"})
+	eng.RegisterPipeline(PipelineDef{
+		Type:    "live_stream",
+		Version: "v1",
+		Steps:   []StepDef{{ID: "draft", Kind: StepKindLLM, ProviderProfileID: "gated", Export: true}},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, _, err := eng.RunJobStream(ctx, JobRequest{PipelineType: "live_stream", Mode: ModeAsync})
+	if err != nil {
+		t.Fatalf("ストリームの開始に失敗しました: %v", err)
+	}
+	released := false
+	var got []string
+	for evt := range stream {
+		chunk, ok := evt.Data.(StepChunk)
+		if !ok {
+			continue
+		}
+		got = append(got, chunk.Content)
+		if !released {
+			// Provider の呼び出しはまだ返っていない。
+			released = true
+			close(provider.release)
+		}
+	}
+	if len(got) != 2 || got[0] != "first" || got[1] != "second" {
+		t.Fatalf("Provider の呼び出し中に chunk が流れていません: %v", got)
+	}
+}
+
+func TestStreamingTrackerOrdersConcurrentStepTransitions(t *testing.T) {
+	tracker := NewStreamingTracker()
+	job := &Job{ID: "job-4", Status: JobStatusRunning, StepExecutions: []StepExecution{
+		{StepID: StepID("left"), Status: StepExecPending},
+		{StepID: StepID("right"), Status: StepExecPending},
+	}}
+	first := tracker.Diff(job)
+
+	// 2 つのステップが同じスナップショットで遷移し、結果は right→left の順で追加される。
+	job.StepExecutions[0].Status = StepExecSuccess
+	job.StepExecutions[0].Chunks = []StepChunk{{StepID: StepID("left"), Index: 0, Content: "l"}}
+	job.StepExecutions[1].Status = StepExecRunning
+	job.StepExecutions[1].Chunks = []StepChunk{{StepID: StepID("right"), Index: 0, Content: "r"}}
+	job.Result = &JobResult{Items: []ResultItem{
+		{ID: "item-r", StepID: StepID("right")},
+		{ID: "item-l", StepID: StepID("left")},
+	}}
+	job.Status = JobStatusSucceeded
+	second := tracker.Diff(job)
+
+	var got []string
+	for _, evt := range second {
+		label := evt.Event
+		switch data := evt.Data.(type) {
+		case StepExecution:
+			label += ":" + string(data.StepID)
+		case StepChunk:
+			label += ":" + string(data.StepID)
+		case ResultItem:
+			label += ":" + data.ID
+		}
+		got = append(got, label)
+	}
+	want := []string{
+		"job_status",
+		"provider_chunk:left", "step_completed:left",
+		"step_started:right", "provider_chunk:right",
+		"item_completed:item-l", "item_completed:item-r",
+		"job_completed", "stream_finished",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("イベント数が想定外です: got=%v want=%v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("イベント順序が想定外です: got=%v want=%v", got, want)
+		}
+	}
+
+	seq := uint64(0)
+	for _, evt := range append(first, second...) {
+		if evt.Seq != seq+1 {
+			t.Fatalf("Seq が連番になっていません: %d の次が %d", seq, evt.Seq)
+		}
+		seq = evt.Seq
+	}
+}
+
+func chunkEvents(events []StreamingEvent) []StepChunk {
+	var chunks []StepChunk
+	for _, evt := range events {
+		if evt.Event != "provider_chunk" {
+			continue
+		}
+		if chunk, ok := evt.Data.(StepChunk); ok {
+			chunks = append(chunks, chunk)
+		}
+	}
+	return chunks
+}
+
 func containsEvent(events []StreamingEvent, name string) bool {
 	for _, evt := range events {
 		if evt.Event == name {
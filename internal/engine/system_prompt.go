@@ -0,0 +1,66 @@
+package engine
+
+import "strings"
+
+// SystemPromptMode controls how JobOptions.SystemPromptOverride combines
+// with a step's PromptTemplate.System.
+type SystemPromptMode string
+
+const (
+	// SystemPromptReplace uses the override instead of the step's system
+	// prompt. It is the default.
+	SystemPromptReplace SystemPromptMode = "replace"
+	// SystemPromptPrepend puts the override before the step's system prompt.
+	SystemPromptPrepend SystemPromptMode = "prepend"
+)
+
+// SystemPromptOverrideConfigKey lets a step opt out of
+// JobOptions.SystemPromptOverride with the value "ignore", e.g. for steps
+// whose system prompt enforces an output format.
+const SystemPromptOverrideConfigKey = "system_prompt_override"
+
+const (
+	systemPromptOverrideApply  = "apply"
+	systemPromptOverrideIgnore = "ignore"
+)
+
+// systemPromptOverride returns the job's system prompt override and mode for
+// step, or ok=false when none is set or the step opts out. Unknown modes fall
+// back to SystemPromptReplace.
+func systemPromptOverride(step StepDef, opts *JobOptions) (string, SystemPromptMode, bool) {
+	if opts == nil || strings.TrimSpace(opts.SystemPromptOverride) == "" {
+		return "", "", false
+	}
+	if value, ok := step.ConfigString(SystemPromptOverrideConfigKey); ok && strings.EqualFold(value, systemPromptOverrideIgnore) {
+		return "", "", false
+	}
+	mode := SystemPromptReplace
+	if SystemPromptMode(strings.ToLower(strings.TrimSpace(opts.SystemPromptMode))) == SystemPromptPrepend {
+		mode = SystemPromptPrepend
+	}
+	return strings.TrimSpace(opts.SystemPromptOverride), mode, true
+}
+
+// applySystemPrompt combines the rendered step system prompt with the
+// override. The override is used verbatim, not as a template.
+func applySystemPrompt(system, override string, mode SystemPromptMode) string {
+	if mode == SystemPromptPrepend && system != "" {
+		return override + "\n" + system
+	}
+	return override
+}
+
+// applySystemPromptMessages applies the override to declared role messages:
+// replace drops the declared system messages, and either mode puts the
+// override first as a system message.
+func applySystemPromptMessages(messages []PromptMessage, override string, mode SystemPromptMode) []PromptMessage {
+	out := make([]PromptMessage, 0, len(messages)+1)
+	out = append(out, PromptMessage{Role: "system", Content: override})
+	for _, msg := range messages {
+		if mode == SystemPromptReplace && msg.Role == "system" {
+			continue
+		}
+		out = append(out, msg)
+	}
+	return out
+}
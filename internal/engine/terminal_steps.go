@@ -0,0 +1,29 @@
+package engine
+
+// TerminalSteps returns the exported steps of def whose output no other step
+// consumes through DependsOn, InputFrom or InputMapping, i.e. the steps
+// producing the pipeline's final results. Intermediate exported steps are excluded.
+func TerminalSteps(def *PipelineDef) map[StepID]bool {
+	if def == nil {
+		return nil
+	}
+	consumed := map[StepID]bool{}
+	for _, step := range def.Steps {
+		for _, dep := range step.DependsOn {
+			consumed[dep] = true
+		}
+		for _, binding := range step.InputFrom {
+			consumed[binding.Step] = true
+		}
+		for _, pointer := range step.InputMapping {
+			consumed[mappingStep(pointer)] = true
+		}
+	}
+	terminal := map[StepID]bool{}
+	for _, step := range def.Steps {
+		if step.Export && !consumed[step.ID] {
+			terminal[step.ID] = true
+		}
+	}
+	return terminal
+}
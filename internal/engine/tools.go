@@ -0,0 +1,114 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// MaxToolIterationsConfigKey is the StepDef.Config key bounding how many
+// tool-calling rounds a step may run before it fails.
+const MaxToolIterationsConfigKey = "max_tool_iterations"
+
+const defaultMaxToolIterations = 5
+
+// StepHandler executes a tool call requested by the model. The returned
+// string is sent back to the model as the tool result; an error is reported
+// to the model as well so it can recover.
+type StepHandler func(ctx context.Context, call ToolCall) (string, error)
+
+// RegisterStepHandler registers the handler that runs tool calls named name.
+// Registering an existing name replaces its handler.
+func (e *BasicEngine) RegisterStepHandler(name string, handler StepHandler) {
+	if name == "" || handler == nil {
+		return
+	}
+	e.toolMu.Lock()
+	defer e.toolMu.Unlock()
+	e.toolHandlers[name] = handler
+}
+
+func (e *BasicEngine) stepHandler(name string) (StepHandler, bool) {
+	e.toolMu.RLock()
+	defer e.toolMu.RUnlock()
+	handler, ok := e.toolHandlers[name]
+	return handler, ok
+}
+
+// callWithTools calls the provider until it answers without tool calls,
+// running the requested tools between rounds and feeding their results back
+// through ProviderInput.ToolTurns. A step exceeding max_tool_iterations fails
+// with tool_iterations_exceeded; a call to a tool the step does not declare or
+// that has no registered handler fails with tool_unavailable.
+func (e *BasicEngine) callWithTools(ctx context.Context, provider Provider, profile ProviderProfile, step StepDef, prompt string, input ProviderInput, onChunk chunkFunc) (ProviderResponse, error) {
+	limit, ok := step.ConfigInt(MaxToolIterationsConfigKey)
+	if !ok || limit <= 0 {
+		limit = defaultMaxToolIterations
+	}
+	declared := make(map[string]bool, len(step.Tools))
+	for _, tool := range step.Tools {
+		declared[tool.Name] = true
+	}
+
+	calls := 0
+	for round := 0; ; round++ {
+		resp, err := e.callProviderOnce(ctx, provider, profile, step, prompt, input, onChunk)
+		if err != nil {
+			return resp, err
+		}
+		if len(resp.ToolCalls) == 0 {
+			if calls > 0 {
+				resp.Metadata["tool_calls"] = calls
+			}
+			return resp, nil
+		}
+		if round >= limit {
+			return ProviderResponse{}, &stepError{
+				code: "tool_iterations_exceeded",
+				err:  fmt.Errorf("step %s exceeded %d tool-calling rounds", step.ID, limit),
+			}
+		}
+		turn := ToolTurn{Content: resp.Output, Calls: resp.ToolCalls}
+		for _, call := range resp.ToolCalls {
+			handler, ok := e.stepHandler(call.Name)
+			if !declared[call.Name] || !ok {
+				return ProviderResponse{}, &stepError{
+					code:    "tool_unavailable",
+					err:     fmt.Errorf("step %s: model called tool %s, which is not declared or has no handler", step.ID, call.Name),
+					details: map[string]any{"tool": call.Name},
+				}
+			}
+			content, err := handler(ctx, call)
+			if err != nil {
+				if ctx.Err() != nil {
+					return ProviderResponse{}, ctx.Err()
+				}
+				content = fmt.Sprintf("error: %v", err)
+			}
+			turn.Results = append(turn.Results, ToolResult{CallID: call.ID, Content: content})
+			calls++
+		}
+		input.ToolTurns = append(input.ToolTurns, turn)
+	}
+}
+
+// undeclaredToolCalls handles tool calls returned to a step without Tools,
+// which the engine cannot run. Calls alongside a text answer are kept in the
+// result as data.requested_tool_calls; calls without one fail the step with
+// tool_unavailable, listing them in the details.
+func undeclaredToolCalls(step StepDef, resp ProviderResponse) (ProviderResponse, error) {
+	calls := resp.ToolCalls
+	if strings.TrimSpace(resp.Output) == "" {
+		return ProviderResponse{}, &stepError{
+			code:    "tool_unavailable",
+			err:     fmt.Errorf("step %s: model answered only with a call to tool %s, but the step declares no tools", step.ID, calls[0].Name),
+			details: map[string]any{"tool": calls[0].Name, "tool_calls": calls},
+		}
+	}
+	if resp.Metadata == nil {
+		resp.Metadata = map[string]any{}
+	}
+	resp.Metadata["requested_tool_calls"] = calls
+	resp.ToolCalls = nil
+	return resp, nil
+}
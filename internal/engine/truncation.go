@@ -0,0 +1,250 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/example/pipeline-engine/pkg/logging"
+)
+
+// TruncationStrategy selects how a step's sources are shortened when its
+// prompt is estimated to exceed the profile's context_window. Without a
+// strategy the step fails with context_window_exceeded.
+type TruncationStrategy string
+
+const (
+	// TruncateHead keeps the beginning of the combined sources.
+	TruncateHead TruncationStrategy = "head"
+	// TruncateTail keeps the end of the combined sources.
+	TruncateTail TruncationStrategy = "tail"
+	// TruncateMiddle keeps the beginning and the end and drops the middle.
+	TruncateMiddle TruncationStrategy = "middle"
+	// TruncateSummarizeFirst asks the step's provider to summarize oversized
+	// sources, then drops the middle of whatever still does not fit.
+	TruncateSummarizeFirst TruncationStrategy = "summarize_first"
+	// TruncatePriority drops whole sources, lowest Source.Priority first.
+	TruncatePriority TruncationStrategy = "priority"
+)
+
+// maxTruncationPasses bounds the re-render loop for templates that repeat
+// sources, where one cut is not enough.
+const maxTruncationPasses = 3
+
+// PromptTruncation records that a step's sources were shortened to fit the
+// context window.
+type PromptTruncation struct {
+	Strategy       TruncationStrategy `json:"strategy"`
+	OriginalTokens int                `json:"original_tokens"`
+	// DroppedChars counts source characters removed (or replaced by
+	// summaries); DroppedTokens is its estimate in tokens.
+	DroppedChars   int `json:"dropped_chars"`
+	DroppedTokens  int `json:"dropped_tokens"`
+	DroppedSources int `json:"dropped_sources,omitempty"`
+	// Summarized counts sources replaced by a provider summary.
+	Summarized int `json:"summarized,omitempty"`
+}
+
+func validTruncationStrategy(strategy TruncationStrategy) bool {
+	switch strategy {
+	case "", TruncateHead, TruncateTail, TruncateMiddle, TruncateSummarizeFirst, TruncatePriority:
+		return true
+	default:
+		return false
+	}
+}
+
+// validateTruncationStrategy reports an unknown JobOptions.TruncationStrategy.
+func validateTruncationStrategy(opts *JobOptions) error {
+	if opts == nil || validTruncationStrategy(opts.TruncationStrategy) {
+		return nil
+	}
+	return fmt.Errorf("options.truncation_strategy must be one of head, tail, middle, summarize_first or priority: %q", opts.TruncationStrategy)
+}
+
+// truncationStrategy returns the strategy for step; the job's option wins over
+// the step's.
+func truncationStrategy(step StepDef, opts *JobOptions) TruncationStrategy {
+	if opts != nil && opts.TruncationStrategy != "" {
+		return opts.TruncationStrategy
+	}
+	return step.TruncationStrategy
+}
+
+// truncatePrompt shortens the sources of a step whose prompt exceeds the
+// profile's context_window, re-rendering the prompt and messages from the
+// shortened sources into prompt and input. It returns a nil report when
+// nothing was truncated; the context window check still runs afterwards.
+func (e *BasicEngine) truncatePrompt(ctx context.Context, provider Provider, profile ProviderProfile, step StepDef, job *Job, outputs map[StepID][]ResultItem, prompt string, input *ProviderInput) (string, *PromptTruncation, error) {
+	strategy := truncationStrategy(step, job.Input.Options)
+	limit, ok := extraInt(profile.Extra, contextWindowExtraKey)
+	if strategy == "" || step.Prompt == nil || !ok || limit <= 0 {
+		return prompt, nil, nil
+	}
+	estimated := estimatePromptTokens(profile, prompt, historyPromptMessages(input.History, input.Messages))
+	if estimated <= limit {
+		return prompt, nil, nil
+	}
+
+	pctx := newPromptContext(step, job, outputs)
+	original := pctx.Sources
+	report := &PromptTruncation{Strategy: strategy, OriginalTokens: estimated}
+	sources := original
+	cut := strategy
+	if strategy == TruncateSummarizeFirst {
+		cut = TruncateMiddle
+		if provider != nil {
+			budget := sourceRunes(sources) - (estimated-limit)*4
+			summarized, count, err := e.summarizeSources(ctx, provider, profile, step, sources, budget/4, limit)
+			if err != nil {
+				return prompt, nil, err
+			}
+			sources, report.Summarized = summarized, count
+		}
+	}
+
+	for pass := 0; pass < maxTruncationPasses; pass++ {
+		pctx.Sources = sources
+		prompt = renderPrompt(step, job.Input.Options, pctx)
+		input.Messages = renderPromptMessages(step, job.Input.Options, pctx)
+		next := estimatePromptTokens(profile, prompt, historyPromptMessages(input.History, input.Messages))
+		if next <= limit || sourceRunes(sources) == 0 {
+			break
+		}
+		// A quarter more than the estimated overflow absorbs the rounding
+		// of the four-characters-per-token heuristic.
+		drop := (next-limit)*4 + (next - limit)
+		if cut == TruncatePriority {
+			sources = dropLowPrioritySources(sources, drop)
+		} else {
+			sources = cutSources(sources, drop, cut)
+		}
+	}
+	input.Sources = sources
+
+	report.DroppedChars = sourceRunes(original) - sourceRunes(sources)
+	report.DroppedTokens = (report.DroppedChars + 3) / 4
+	report.DroppedSources = len(original) - len(sources)
+	logging.Warnf("step %s: prompt of ~%d tokens exceeds the %d token context window; %s truncation dropped %d source characters", step.ID, estimated, limit, strategy, report.DroppedChars)
+	return prompt, report, nil
+}
+
+// summarizeSources replaces each source whose share of the budget is too
+// small with a provider summary of at most that many tokens. Sources longer
+// than the context window are cut before they are sent.
+func (e *BasicEngine) summarizeSources(ctx context.Context, provider Provider, profile ProviderProfile, step StepDef, sources []Source, budgetTokens, limit int) ([]Source, int, error) {
+	total := sourceRunes(sources)
+	if total == 0 {
+		return sources, 0, nil
+	}
+	summaryStep := StepDef{ID: step.ID, Kind: StepKindLLM, ProviderProfileID: step.ProviderProfileID, ProviderOverride: step.ProviderOverride}
+	out := make([]Source, len(sources))
+	count := 0
+	for i, src := range sources {
+		out[i] = src
+		runes := utf8.RuneCountInString(src.Content)
+		share := budgetTokens * runes / total
+		if share < 1 {
+			share = 1
+		}
+		if estimateTokens(src.Content) <= share {
+			continue
+		}
+		content := src.Content
+		if maxRunes := limit * 3; runes > maxRunes {
+			content = string([]rune(content)[:maxRunes])
+		}
+		prompt := fmt.Sprintf("Summarize the following %s in at most %d tokens, keeping the facts needed to work with it.\n\n%s", sourceNoun(src), share, content)
+		resp, err := e.callProviderOnce(ctx, provider, profile, summaryStep, prompt, ProviderInput{Sources: []Source{src}}, nil)
+		if err != nil {
+			return nil, 0, err
+		}
+		if summary := strings.TrimSpace(resp.Output); summary != "" {
+			out[i].Content = summary
+			count++
+		}
+	}
+	return out, count, nil
+}
+
+func sourceNoun(src Source) string {
+	if src.Kind == "" {
+		return "source"
+	}
+	return string(src.Kind) + " source"
+}
+
+// cutSources removes drop runes from the combined source contents: from the
+// end for head, from the start for tail, and from the middle otherwise.
+// Sources left empty are removed unless they carry binary data.
+func cutSources(sources []Source, drop int, strategy TruncationStrategy) []Source {
+	total := sourceRunes(sources)
+	if drop <= 0 || total == 0 {
+		return sources
+	}
+	if drop > total {
+		drop = total
+	}
+	keep := total - drop
+	var keepHead int
+	switch strategy {
+	case TruncateHead:
+		keepHead = keep
+	case TruncateTail:
+		keepHead = 0
+	default:
+		keepHead = keep / 2
+	}
+	tailStart := total - (keep - keepHead)
+
+	out := make([]Source, 0, len(sources))
+	offset := 0
+	for _, src := range sources {
+		runes := []rune(src.Content)
+		start, end := offset, offset+len(runes)
+		offset = end
+		var kept []rune
+		// Runes in [0, keepHead) and [tailStart, total) survive.
+		if start < keepHead {
+			kept = append(kept, runes[:min(end, keepHead)-start]...)
+		}
+		if end > tailStart {
+			from := max(start, tailStart, keepHead) - start
+			if from < len(runes) {
+				kept = append(kept, runes[from:]...)
+			}
+		}
+		if len(kept) == len(runes) {
+			out = append(out, src)
+			continue
+		}
+		if len(kept) == 0 && len(src.Data) == 0 {
+			continue
+		}
+		src.Content = string(kept)
+		out = append(out, src)
+	}
+	return out
+}
+
+func sourceRunes(sources []Source) int {
+	total := 0
+	for _, src := range sources {
+		total += utf8.RuneCountInString(src.Content)
+	}
+	return total
+}
+
+// recordTruncation stores the truncation report, and for dry runs the
+// re-rendered prompt, on the step's execution.
+func (e *BasicEngine) recordTruncation(ctx context.Context, job *Job, execIdx int, prompt string, report *PromptTruncation) {
+	if execIdx < 0 || execIdx >= len(job.StepExecutions) {
+		return
+	}
+	job.StepExecutions[execIdx].Truncation = report
+	if job.Mode == ModeDryRun {
+		job.StepExecutions[execIdx].Prompt = prompt
+	}
+	_ = e.saveJob(ctx, job)
+}
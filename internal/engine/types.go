@@ -48,6 +48,24 @@ type PromptTemplate struct {
 	Meta   map[string]any `json:"meta,omitempty"`
 }
 
+// PromptMetaMessagesKey is the PromptTemplate.Meta key holding an ordered list
+// of PromptMessage values (e.g. developer instructions or assistant prefill).
+const PromptMetaMessagesKey = "messages"
+
+// PromptMessage is a single role-tagged message. Content is rendered with the
+// same template context as PromptTemplate.User.
+type PromptMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// ChatMessage is one earlier conversation turn supplied with JobInput.History.
+// Role is "system", "user" or "assistant".
+type ChatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
 type PipelineType string
 
 type StepKind string
@@ -58,6 +76,11 @@ const (
 	StepKindMap    StepKind = "map"
 	StepKindReduce StepKind = "reduce"
 	StepKindCustom StepKind = "custom"
+	// StepKindRetrieve selects the upstream items most similar to a query by
+	// embedding, without calling a provider; see runRetrieveStep. The query
+	// and candidate steps produce the embeddings: OpenAI and Ollama profiles
+	// do so for steps with OutputType ContentEmbedding.
+	StepKindRetrieve StepKind = "retrieve"
 )
 
 type StepMode string
@@ -71,25 +94,84 @@ const (
 type StepID string
 
 type StepDef struct {
-	ID                StepID            `json:"id"`
-	Name              string            `json:"name"`
-	Kind              StepKind          `json:"kind"`
-	Mode              StepMode          `json:"mode,omitempty"`
-	DependsOn         []StepID          `json:"depends_on"`
-	ProviderProfileID ProviderProfileID `json:"provider_profile_id"`
-	ProviderOverride  map[string]any    `json:"provider_override,omitempty"`
-	Prompt            *PromptTemplate   `json:"prompt,omitempty"`
-	OutputType        ContentType       `json:"output_type"`
-	OutputFormat      OutputFormat      `json:"output_format,omitempty"`
-	Config            map[string]any    `json:"config,omitempty"`
-	Export            bool              `json:"export,omitempty"`
-	ExportTag         string            `json:"export_tag,omitempty"`
+	ID        StepID   `json:"id"`
+	Name      string   `json:"name"`
+	Kind      StepKind `json:"kind"`
+	Mode      StepMode `json:"mode,omitempty"`
+	DependsOn []StepID `json:"depends_on"`
+	// InputFrom binds upstream step outputs to names available to prompt
+	// templates as .Inputs.<name>.
+	InputFrom []InputBinding `json:"input_from,omitempty"`
+	// InputMapping binds template variables, available as .Vars.<name>, to
+	// JSON pointers into upstream outputs whose first token is the step ID,
+	// e.g. {"route": "/classify/0/data/route"}.
+	InputMapping      map[string]string   `json:"input_mapping,omitempty"`
+	ProviderProfileID ProviderProfileID   `json:"provider_profile_id"`
+	ProviderOverride  map[string]any      `json:"provider_override,omitempty"`
+	Fallbacks         []ProviderProfileID `json:"fallbacks,omitempty"`
+	Prompt            *PromptTemplate     `json:"prompt,omitempty"`
+	OutputType        ContentType         `json:"output_type"`
+	OutputFormat      OutputFormat        `json:"output_format,omitempty"`
+	// OutputSchema is the JSON Schema of a json_strict step's output.
+	// Providers with native structured output (OpenAI) are asked to follow
+	// it; the output of any provider must still parse as JSON.
+	OutputSchema map[string]any `json:"output_schema,omitempty"`
+	Config       map[string]any `json:"config,omitempty"`
+	Export       bool           `json:"export,omitempty"`
+	ExportTag    string         `json:"export_tag,omitempty"`
+	// ExportPrompt keeps data.prompt on this step's exported results even
+	// when EngineConfig.ExportPrompts is off.
+	ExportPrompt bool `json:"export_prompt,omitempty"`
+	// Tools are offered to providers that support function calling; calls
+	// are dispatched to handlers registered with RegisterStepHandler.
+	Tools []ToolDef `json:"tools,omitempty"`
+	// PostProcess names transforms (see PostProcessor) applied in order to
+	// the provider output before it becomes a result, e.g.
+	// ["strip_code_fence", "extract_json"]. Entries take an argument after
+	// ':' such as "truncate:200".
+	PostProcess []string `json:"post_process,omitempty"`
+	// ModelTiers maps JobOptions.DetailLevel values (e.g. "low", "high") to
+	// the model this step uses as its default_model override. Levels without
+	// a tier keep the profile default.
+	ModelTiers map[string]string `json:"model_tiers,omitempty"`
+	// TruncationStrategy shortens the sources instead of failing when the
+	// prompt exceeds the profile's context_window.
+	// JobOptions.TruncationStrategy takes precedence.
+	TruncationStrategy TruncationStrategy `json:"truncation_strategy,omitempty"`
+}
+
+// ToolDef declares a function the model may call. Parameters is a JSON Schema
+// object describing its arguments.
+type ToolDef struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Parameters  map[string]any `json:"parameters,omitempty"`
+}
+
+// InputBinding names the outputs of an upstream step. A per_item step
+// iterates over the binding marked PerItem instead of its last dependency.
+type InputBinding struct {
+	Name    string `json:"name"`
+	Step    StepID `json:"step"`
+	PerItem bool   `json:"per_item,omitempty"`
 }
 
 type PipelineDef struct {
 	Type    PipelineType `json:"type"`
 	Version string       `json:"version"`
 	Steps   []StepDef    `json:"steps"`
+	// DefaultProviderProfileID is used by steps that leave
+	// ProviderProfileID empty.
+	DefaultProviderProfileID ProviderProfileID `json:"default_provider_profile_id,omitempty"`
+	// ExportSink names a registered ExportSink that exported items are
+	// written to; JobRequest.ExportSink overrides it per job.
+	ExportSink string `json:"export_sink,omitempty"`
+	// RequiredSources rejects jobs whose sources the pipeline cannot work
+	// with; nil accepts any input.
+	RequiredSources *SourceRequirement `json:"required_sources,omitempty"`
+	// MaxConcurrency caps how many jobs of this pipeline type execute at
+	// once; further jobs stay queued. 0 means no per-pipeline limit.
+	MaxConcurrency int `json:"max_concurrency,omitempty"`
 }
 
 type SourceKind string
@@ -106,17 +188,52 @@ type Source struct {
 	Label    string         `json:"label"`
 	Content  string         `json:"content"`
 	Metadata map[string]any `json:"metadata,omitempty"`
+	// Data carries binary content such as images or PDFs; it is base64 in
+	// JSON and requires MimeType.
+	Data     []byte `json:"data,omitempty"`
+	MimeType string `json:"mime_type,omitempty"`
+	// Sensitive keeps the content out of storage and API responses; see
+	// redactJob. Redacted marks a stored copy whose content was replaced.
+	Sensitive bool `json:"sensitive,omitempty"`
+	Redacted  bool `json:"redacted,omitempty"`
+	// Priority ranks the source for steps with source_order "priority", the
+	// byPriority and topSources template functions and the priority
+	// truncation strategy; higher comes first. It does not change the
+	// default input order.
+	Priority float64 `json:"priority,omitempty"`
 }
 
 type JobOptions struct {
-	MaxTokens   int    `json:"max_tokens,omitempty"`
-	DetailLevel string `json:"detail_level,omitempty"`
-	Language    string `json:"language,omitempty"`
+	MaxTokens     int               `json:"max_tokens,omitempty"`
+	DetailLevel   string            `json:"detail_level,omitempty"`
+	Language      string            `json:"language,omitempty"`
+	InjectFailure *FailureInjection `json:"inject_failure,omitempty"`
+	// SystemPromptOverride replaces (or, with SystemPromptMode "prepend",
+	// precedes) the system prompt of every step with a PromptTemplate,
+	// except steps whose config sets system_prompt_override to "ignore".
+	SystemPromptOverride string `json:"system_prompt_override,omitempty"`
+	SystemPromptMode     string `json:"system_prompt_mode,omitempty"`
+	// TruncationStrategy overrides StepDef.TruncationStrategy for every step.
+	TruncationStrategy TruncationStrategy `json:"truncation_strategy,omitempty"`
+	// Seed asks providers for reproducible sampling. Providers that cannot
+	// honor it set ProviderMeta.SeedIgnored.
+	Seed *int64 `json:"seed,omitempty"`
+}
+
+// seed returns the requested seed, or nil when o is nil or has none.
+func (o *JobOptions) seed() *int64 {
+	if o == nil {
+		return nil
+	}
+	return o.Seed
 }
 
 type JobInput struct {
-	Sources []Source    `json:"sources"`
-	Options *JobOptions `json:"options,omitempty"`
+	Sources []Source `json:"sources"`
+	// History holds prior conversation turns, oldest first. Chat providers
+	// send them ahead of the step's messages; templates see .History.
+	History []ChatMessage `json:"history,omitempty"`
+	Options *JobOptions   `json:"options,omitempty"`
 }
 
 type ContentTypeAlias = ContentType
@@ -131,6 +248,15 @@ type ResultItem struct {
 	Tag         string      `json:"tag,omitempty"`
 	ContentType ContentType `json:"content_type"`
 	Data        any         `json:"data"`
+	// URI references the content written by the job's ExportSink; Data is
+	// nil in that case.
+	URI string `json:"uri,omitempty"`
+	// ProviderMeta describes the provider call that produced the item; nil
+	// for items produced without a provider.
+	ProviderMeta *ProviderMeta `json:"provider_meta,omitempty"`
+	// Incomplete marks the partial output a single step had streamed when
+	// its job was cancelled; it is never checkpointed.
+	Incomplete bool `json:"incomplete,omitempty"`
 }
 
 type JobResult struct {
@@ -172,6 +298,17 @@ type StepExecution struct {
 	FinishedAt *time.Time          `json:"finished_at,omitempty"`
 	Error      *JobError           `json:"error,omitempty"`
 	Chunks     []StepChunk         `json:"chunks,omitempty"`
+	// ChunkCount is the number of chunks the step's provider calls streamed,
+	// so clients can gauge streaming throughput without reading Chunks.
+	ChunkCount int `json:"chunk_count,omitempty"`
+	// ShardsDone and ShardsTotal report fan-out / per-item shard progress.
+	ShardsDone  int `json:"shards_done,omitempty"`
+	ShardsTotal int `json:"shards_total,omitempty"`
+	// Prompt is the rendered prompt, recorded only for dry_run jobs.
+	Prompt string `json:"prompt,omitempty"`
+	// Truncation is set when the step's sources were shortened to fit the
+	// context window.
+	Truncation *PromptTruncation `json:"truncation,omitempty"`
 }
 
 type StepChunk struct {
@@ -185,6 +322,7 @@ type Job struct {
 	PipelineType    PipelineType    `json:"pipeline_type"`
 	PipelineVersion string          `json:"pipeline_version"`
 	Status          JobStatus       `json:"status"`
+	Progress        float64         `json:"progress"` // completed fraction (0.0–1.0)
 	CreatedAt       time.Time       `json:"created_at"`
 	UpdatedAt       time.Time       `json:"updated_at"`
 	Input           JobInput        `json:"input"`
@@ -195,6 +333,15 @@ type Job struct {
 	Mode            string          `json:"mode,omitempty"`
 	RerunFromStep   *StepID         `json:"rerun_from_step,omitempty"`
 	ReuseUpstream   bool            `json:"reuse_upstream,omitempty"`
+	ReuseSteps      []StepID        `json:"reuse_steps,omitempty"`
+	BatchID         string          `json:"batch_id,omitempty"`
+	ExportSink      string          `json:"export_sink,omitempty"`
+	ExportSteps     []StepID        `json:"export_steps,omitempty"`
+	Webhook         *Webhook        `json:"webhook,omitempty"`
+	Cancellation    *Cancellation   `json:"cancellation,omitempty"`
+	// EphemeralProfiles lists the IDs of the JobRequest.EphemeralProviders
+	// the job ran with; the profiles themselves are never stored.
+	EphemeralProfiles []ProviderProfileID `json:"ephemeral_profiles,omitempty"`
 }
 
 type StepCheckpoint struct {
@@ -0,0 +1,55 @@
+package engine
+
+import (
+	"fmt"
+	"slices"
+)
+
+// ErrCodeUnsupportedFeature is the JobError code of a step using a step kind
+// or mode this engine build does not implement.
+const ErrCodeUnsupportedFeature = "unsupported_feature"
+
+// UnsupportedFeatureError reports a step kind or mode the engine does not
+// implement, so such steps fail instead of quietly running as a single llm
+// step. Feature is "step_kind" or "step_mode".
+type UnsupportedFeatureError struct {
+	Step      StepID
+	Feature   string
+	Value     string
+	Supported []string
+}
+
+func (e *UnsupportedFeatureError) Error() string {
+	return fmt.Sprintf("step %s: unsupported %s %q (supported: %v)", e.Step, e.Feature, e.Value, e.Supported)
+}
+
+// checkStepFeatures returns an UnsupportedFeatureError for the first kind or
+// mode of step that SupportedCapabilities does not list. Empty values take
+// the defaults clonePipeline fills in.
+func checkStepFeatures(step StepDef) *UnsupportedFeatureError {
+	caps := SupportedCapabilities()
+	if step.Kind != "" && !slices.Contains(caps.StepKinds, step.Kind) {
+		return &UnsupportedFeatureError{Step: step.ID, Feature: "step_kind", Value: string(step.Kind), Supported: featureNames(caps.StepKinds)}
+	}
+	if step.Mode != "" && !slices.Contains(caps.StepModes, step.Mode) {
+		return &UnsupportedFeatureError{Step: step.ID, Feature: "step_mode", Value: string(step.Mode), Supported: featureNames(caps.StepModes)}
+	}
+	return nil
+}
+
+// unsupportedFeature wraps err as the step's unsupported_feature failure.
+func unsupportedFeature(err *UnsupportedFeatureError) error {
+	return &stepError{
+		code:    ErrCodeUnsupportedFeature,
+		err:     err,
+		details: map[string]any{"feature": err.Feature, "value": err.Value, "supported": err.Supported},
+	}
+}
+
+func featureNames[T ~string](values []T) []string {
+	names := make([]string, len(values))
+	for i, v := range values {
+		names[i] = string(v)
+	}
+	return names
+}
@@ -0,0 +1,244 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/example/pipeline-engine/pkg/logging"
+)
+
+// Webhook subscribes a callback URL to a job's streaming events. Events
+// lists the event names to deliver; empty means only the terminal
+// job_completed, job_failed and job_cancelled events.
+type Webhook struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events,omitempty"`
+}
+
+const (
+	webhookPollInterval = 250 * time.Millisecond
+	webhookTimeout      = 10 * time.Second
+	webhookMaxAttempts  = 3
+	webhookRetryDelay   = 200 * time.Millisecond
+)
+
+var webhookEvents = map[string]bool{
+	"job_queued": true, "job_started": true, "job_status": true,
+	"job_completed": true, "job_failed": true, "job_cancelled": true,
+	"step_started": true, "step_completed": true, "step_failed": true,
+	"step_cancelled": true, "step_skipped": true,
+	"provider_chunk": true, "item_completed": true,
+}
+
+var defaultWebhookEvents = []string{"job_completed", "job_failed", "job_cancelled"}
+
+// sharedAddressSpace is 100.64.0.0/10 (RFC 6598), which netip does not
+// classify as private but which hosts cloud metadata services such as
+// 100.100.100.200.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// publicAddr reports whether ip may receive webhooks without being listed in
+// EngineConfig.WebhookAllowedHosts: loopback, private, link-local (including
+// the 169.254.169.254 metadata address), multicast and unspecified addresses
+// may not.
+func publicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsValid() && !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() && !ip.IsInterfaceLocalMulticast() && !ip.IsMulticast() &&
+		!ip.IsUnspecified() && !sharedAddressSpace.Contains(ip)
+}
+
+func normalizeWebhookHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.Trim(strings.TrimSpace(host), "[]")), ".")
+}
+
+// validateWebhook checks the hook's URL and events. IP literals and localhost
+// names that are not public are refused here; names are resolved when the
+// webhook connects, where webhookDialContext applies the same check.
+func (e *BasicEngine) validateWebhook(hook *Webhook) error {
+	if hook == nil {
+		return nil
+	}
+	u, err := url.Parse(hook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("webhook url must be an absolute http(s) URL: %q", hook.URL)
+	}
+	host := normalizeWebhookHost(u.Hostname())
+	if !e.hookHosts[host] {
+		if ip, err := netip.ParseAddr(host); (err == nil && !publicAddr(ip)) || host == "localhost" || strings.HasSuffix(host, ".localhost") {
+			return fmt.Errorf("webhook url must not target a loopback, private or link-local address: %q", hook.URL)
+		}
+	}
+	for _, name := range hook.Events {
+		if !webhookEvents[name] {
+			return fmt.Errorf("webhook event %q is not supported", name)
+		}
+	}
+	return nil
+}
+
+// newWebhookClient returns the client used for webhooks. It uses a copy of
+// the providers' transport whose connections are checked by
+// webhookDialContext, so redirects are covered too.
+func (e *BasicEngine) newWebhookClient(base *http.Transport) *http.Client {
+	var transport *http.Transport
+	if base != nil {
+		transport = base.Clone()
+	} else {
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	}
+	transport.DialContext = e.webhookDialContext(&net.Dialer{Timeout: webhookTimeout, KeepAlive: 30 * time.Second})
+	return &http.Client{Timeout: webhookTimeout, Transport: transport}
+}
+
+// webhookDialContext dials allowlisted hosts as is and everything else only
+// if the resolved address is public. The address is checked right before
+// connecting, so names that resolve, or rebind, to internal addresses are
+// refused as well.
+func (e *BasicEngine) webhookDialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	checked := *dialer
+	checked.Control = func(network, address string, _ syscall.RawConn) error {
+		addrPort, err := netip.ParseAddrPort(address)
+		if err != nil {
+			return err
+		}
+		if !publicAddr(addrPort.Addr()) {
+			return fmt.Errorf("webhook target %s is not a public address", address)
+		}
+		return nil
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if host, _, err := net.SplitHostPort(addr); err == nil && e.hookHosts[normalizeWebhookHost(host)] {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		return checked.DialContext(ctx, network, addr)
+	}
+}
+
+// startWebhook starts watching a queued job for its webhook unless the
+// engine is closed.
+func (e *BasicEngine) startWebhook(queued *Job) {
+	e.hookMu.Lock()
+	defer e.hookMu.Unlock()
+	if e.hookCtx.Err() != nil {
+		return
+	}
+	e.hookWG.Add(1)
+	go func() {
+		defer e.hookWG.Done()
+		e.watchWebhook(e.hookCtx, queued)
+	}()
+}
+
+// stopWebhooks cancels the webhook watchers and waits for them to return.
+func (e *BasicEngine) stopWebhooks() {
+	e.hookMu.Lock()
+	e.hookCancel()
+	e.hookMu.Unlock()
+	e.hookWG.Wait()
+}
+
+// watchWebhook derives the job's events from store snapshots, like
+// streamJob, and delivers the subscribed ones in Seq order until the job
+// reaches a terminal status or ctx is done. Each event is retried with
+// backoff before it is dropped, so delivery is best-effort but never
+// reordered.
+func (e *BasicEngine) watchWebhook(ctx context.Context, queued *Job) {
+	hook := queued.Webhook
+	subscribed := map[string]bool{}
+	events := hook.Events
+	if len(events) == 0 {
+		events = defaultWebhookEvents
+	}
+	for _, name := range events {
+		subscribed[name] = true
+	}
+
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+	tracker := NewStreamingTracker()
+	pending := tracker.Queued(queued)
+	for {
+		for _, event := range pending {
+			if subscribed[event.Event] {
+				e.deliverWebhook(ctx, hook.URL, event)
+			}
+		}
+		job, err := e.store.GetJob(ctx, queued.ID)
+		if err != nil {
+			return
+		}
+		pending = tracker.Diff(job)
+		if isTerminal(job.Status) {
+			for _, event := range pending {
+				if subscribed[event.Event] {
+					e.deliverWebhook(ctx, hook.URL, event)
+				}
+			}
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *BasicEngine) deliverWebhook(ctx context.Context, target string, event StreamingEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		logging.Warnf("webhook for job %s: encode %s: %v", event.JobID, event.Event, err)
+		return
+	}
+	delay := webhookRetryDelay
+	for attempt := 1; ; attempt++ {
+		err = postWebhook(ctx, e.hookClient, target, event, body)
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+		if attempt == webhookMaxAttempts {
+			logging.Warnf("webhook for job %s: dropping %s (seq %d) after %d attempts: %v", event.JobID, event.Event, event.Seq, attempt, err)
+			return
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
+
+func postWebhook(ctx context.Context, client *http.Client, target string, event StreamingEvent, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Pipeline-Event", event.Event)
+	req.Header.Set("X-Pipeline-Seq", strconv.FormatUint(event.Seq, 10))
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("callback returned %s", resp.Status)
+	}
+	return nil
+}
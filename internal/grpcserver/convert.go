@@ -0,0 +1,255 @@
+package grpcserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/example/pipeline-engine/internal/engine"
+	"github.com/example/pipeline-engine/pkg/grpc/pipelinev1"
+)
+
+// jobRequestFromProto converts a RunJobRequest payload into an engine
+// request. Malformed *_json fields are reported with their field name.
+func jobRequestFromProto(req *pipelinev1.JobRequest) (engine.JobRequest, error) {
+	out := engine.JobRequest{
+		PipelineType:    engine.PipelineType(req.GetPipelineType()),
+		PipelineVersion: req.GetPipelineVersion(),
+		Mode:            req.GetMode(),
+		BatchID:         req.GetBatchId(),
+		ExportSink:      req.GetExportSink(),
+		Seed:            req.Seed,
+	}
+	input, err := jobInputFromProto(req.GetInput())
+	if err != nil {
+		return out, err
+	}
+	out.Input = input
+	for _, id := range req.GetExportSteps() {
+		out.ExportSteps = append(out.ExportSteps, engine.StepID(id))
+	}
+	if hook := req.GetWebhook(); hook != nil {
+		out.Webhook = &engine.Webhook{URL: hook.GetUrl(), Events: hook.GetEvents()}
+	}
+	if err := unmarshalField("ephemeral_providers_json", req.GetEphemeralProvidersJson(), &out.EphemeralProviders); err != nil {
+		return out, err
+	}
+	return out, nil
+}
+
+func jobInputFromProto(in *pipelinev1.JobInput) (engine.JobInput, error) {
+	var out engine.JobInput
+	for _, src := range in.GetSources() {
+		source := engine.Source{
+			Kind:      engine.SourceKind(src.GetKind()),
+			Label:     src.GetLabel(),
+			Content:   src.GetContent(),
+			Data:      src.GetData(),
+			MimeType:  src.GetMimeType(),
+			Sensitive: src.GetSensitive(),
+			Redacted:  src.GetRedacted(),
+			Priority:  src.GetPriority(),
+		}
+		if err := unmarshalField("metadata_json", src.GetMetadataJson(), &source.Metadata); err != nil {
+			return out, err
+		}
+		out.Sources = append(out.Sources, source)
+	}
+	for _, msg := range in.GetHistory() {
+		out.History = append(out.History, engine.ChatMessage{Role: msg.GetRole(), Content: msg.GetContent()})
+	}
+	if err := unmarshalField("options_json", in.GetOptionsJson(), &out.Options); err != nil {
+		return out, err
+	}
+	return out, nil
+}
+
+// unmarshalField decodes an optional JSON field; empty leaves v unchanged.
+func unmarshalField(name string, raw []byte, v any) error {
+	if len(raw) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return nil
+}
+
+func jobToProto(job *engine.Job) (*pipelinev1.Job, error) {
+	out := &pipelinev1.Job{
+		Id:              job.ID,
+		PipelineType:    string(job.PipelineType),
+		PipelineVersion: job.PipelineVersion,
+		Status:          string(job.Status),
+		Progress:        job.Progress,
+		CreatedAt:       timestampToProto(&job.CreatedAt),
+		UpdatedAt:       timestampToProto(&job.UpdatedAt),
+		Mode:            job.Mode,
+		BatchId:         job.BatchID,
+	}
+	if job.ParentJobID != nil {
+		out.ParentJobId = *job.ParentJobID
+	}
+	if job.RerunFromStep != nil {
+		out.RerunFromStep = string(*job.RerunFromStep)
+	}
+	if job.Result != nil {
+		result, err := jobResultToProto(job.Result)
+		if err != nil {
+			return nil, err
+		}
+		out.Result = result
+	}
+	var err error
+	if out.Error, err = jobErrorToProto(job.Error); err != nil {
+		return nil, err
+	}
+	for _, exec := range job.StepExecutions {
+		step, err := stepToProto(exec)
+		if err != nil {
+			return nil, err
+		}
+		out.StepExecutions = append(out.StepExecutions, step)
+	}
+	return out, nil
+}
+
+func jobResultToProto(result *engine.JobResult) (*pipelinev1.JobResult, error) {
+	out := &pipelinev1.JobResult{}
+	for _, item := range result.Items {
+		converted, err := resultItemToProto(item)
+		if err != nil {
+			return nil, err
+		}
+		out.Items = append(out.Items, converted)
+	}
+	if len(result.Meta) > 0 {
+		meta, err := json.Marshal(result.Meta)
+		if err != nil {
+			return nil, fmt.Errorf("encode result meta: %w", err)
+		}
+		out.MetaJson = meta
+	}
+	return out, nil
+}
+
+func resultItemToProto(item engine.ResultItem) (*pipelinev1.ResultItem, error) {
+	data, err := json.Marshal(item.Data)
+	if err != nil {
+		return nil, fmt.Errorf("encode item %s: %w", item.ID, err)
+	}
+	out := &pipelinev1.ResultItem{
+		Id:          item.ID,
+		Label:       item.Label,
+		StepId:      string(item.StepID),
+		ShardKey:    item.ShardKey,
+		IsPrimary:   item.IsPrimary,
+		Kind:        item.Kind,
+		Tag:         item.Tag,
+		ContentType: string(item.ContentType),
+		DataJson:    data,
+		Uri:         item.URI,
+		Incomplete:  item.Incomplete,
+	}
+	if meta := item.ProviderMeta; meta != nil {
+		out.ProviderMeta = &pipelinev1.ProviderMeta{
+			Provider:     string(meta.Provider),
+			Model:        meta.Model,
+			FinishReason: meta.FinishReason,
+			LatencyMs:    meta.LatencyMS,
+			Seed:         meta.Seed,
+			SeedIgnored:  meta.SeedIgnored,
+		}
+		if usage := meta.Usage; usage != nil {
+			out.ProviderMeta.Usage = &pipelinev1.TokenUsage{
+				PromptTokens:     int64(usage.PromptTokens),
+				CompletionTokens: int64(usage.CompletionTokens),
+				TotalTokens:      int64(usage.TotalTokens),
+			}
+		}
+	}
+	return out, nil
+}
+
+func jobErrorToProto(jobErr *engine.JobError) (*pipelinev1.JobError, error) {
+	if jobErr == nil {
+		return nil, nil
+	}
+	out := &pipelinev1.JobError{Code: jobErr.Code, Message: jobErr.Message}
+	if jobErr.Details != nil {
+		details, err := json.Marshal(jobErr.Details)
+		if err != nil {
+			return nil, fmt.Errorf("encode error details: %w", err)
+		}
+		out.DetailsJson = details
+	}
+	return out, nil
+}
+
+func stepToProto(exec engine.StepExecution) (*pipelinev1.StepExecution, error) {
+	jobErr, err := jobErrorToProto(exec.Error)
+	if err != nil {
+		return nil, err
+	}
+	return &pipelinev1.StepExecution{
+		StepId:      string(exec.StepID),
+		Status:      string(exec.Status),
+		StartedAt:   timestampToProto(exec.StartedAt),
+		FinishedAt:  timestampToProto(exec.FinishedAt),
+		Error:       jobErr,
+		ChunkCount:  int64(exec.ChunkCount),
+		ShardsDone:  int64(exec.ShardsDone),
+		ShardsTotal: int64(exec.ShardsTotal),
+		Prompt:      exec.Prompt,
+	}, nil
+}
+
+func timestampToProto(t *time.Time) *timestamppb.Timestamp {
+	if t == nil || t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
+
+// eventToProto converts a StreamingEvent, typing the payloads the engine
+// emits and falling back to data_json for anything else.
+func eventToProto(evt engine.StreamingEvent) (*pipelinev1.JobEvent, error) {
+	out := &pipelinev1.JobEvent{Seq: evt.Seq, Event: evt.Event, JobId: evt.JobID}
+	if msg, ok := evt.Data.(string); ok && evt.Event == engine.EventError {
+		out.Payload = &pipelinev1.JobEvent_Error{Error: msg}
+		return out, nil
+	}
+	switch data := evt.Data.(type) {
+	case engine.Job:
+		return eventToProto(engine.StreamingEvent{Seq: evt.Seq, Event: evt.Event, JobID: evt.JobID, Data: &data})
+	case *engine.Job:
+		job, err := jobToProto(data)
+		if err != nil {
+			return nil, err
+		}
+		out.Payload = &pipelinev1.JobEvent_Job{Job: job}
+	case engine.StepExecution:
+		step, err := stepToProto(data)
+		if err != nil {
+			return nil, err
+		}
+		out.Payload = &pipelinev1.JobEvent_Step{Step: step}
+	case engine.StepChunk:
+		out.Payload = &pipelinev1.JobEvent_Chunk{Chunk: &pipelinev1.StepChunk{StepId: string(data.StepID), Index: int64(data.Index), Content: data.Content}}
+	case engine.ResultItem:
+		item, err := resultItemToProto(data)
+		if err != nil {
+			return nil, err
+		}
+		out.Payload = &pipelinev1.JobEvent_Item{Item: item}
+	default:
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return nil, fmt.Errorf("encode %s event: %w", evt.Event, err)
+		}
+		out.Payload = &pipelinev1.JobEvent_DataJson{DataJson: raw}
+	}
+	return out, nil
+}
@@ -0,0 +1,208 @@
+// Package grpcserver exposes engine.Engine over gRPC (see
+// proto/pipelineengine/v1/engine.proto). It calls the engine directly and
+// converts between engine types and their typed messages.
+package grpcserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/example/pipeline-engine/internal/engine"
+	"github.com/example/pipeline-engine/internal/store"
+	"github.com/example/pipeline-engine/pkg/grpc/pipelinev1"
+)
+
+// Service implements pipelinev1.PipelineEngineServer on top of an engine.
+type Service struct {
+	pipelinev1.UnimplementedPipelineEngineServer
+	engine engine.Engine
+}
+
+// NewService returns a Service backed by e.
+func NewService(e engine.Engine) *Service {
+	return &Service{engine: e}
+}
+
+// Server is a gRPC server exposing a Service.
+type Server struct {
+	grpcServer *grpc.Server
+}
+
+// NewServer registers a Service for e on a new gRPC server.
+func NewServer(e engine.Engine, opts ...grpc.ServerOption) *Server {
+	srv := grpc.NewServer(opts...)
+	pipelinev1.RegisterPipelineEngineServer(srv, NewService(e))
+	return &Server{grpcServer: srv}
+}
+
+// ListenAndServe listens on addr and serves until Shutdown.
+func (s *Server) ListenAndServe(addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(lis)
+}
+
+// Serve accepts connections on lis until Shutdown.
+func (s *Server) Serve(lis net.Listener) error {
+	return s.grpcServer.Serve(lis)
+}
+
+// Shutdown stops accepting calls and waits for running ones until ctx ends,
+// then closes the remaining connections.
+func (s *Server) Shutdown(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		s.grpcServer.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.grpcServer.Stop()
+	}
+}
+
+// RunJob creates a job; sync jobs are cancelled when the call ends.
+func (s *Service) RunJob(ctx context.Context, req *pipelinev1.RunJobRequest) (*pipelinev1.JobReply, error) {
+	jobReq, err := decodeJobRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	job, err := s.engine.RunJob(ctx, jobReq)
+	if err != nil {
+		return nil, engineError(err)
+	}
+	return jobReply(job)
+}
+
+// RunJobStream creates a job and forwards its events until the engine closes
+// the stream or the client goes away.
+func (s *Service) RunJobStream(req *pipelinev1.RunJobRequest, stream pipelinev1.PipelineEngine_RunJobStreamServer) error {
+	jobReq, err := decodeJobRequest(req)
+	if err != nil {
+		return err
+	}
+	ctx := stream.Context()
+	events, _, err := s.engine.RunJobStream(ctx, jobReq)
+	if err != nil {
+		return engineError(err)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case evt, ok := <-events:
+			if !ok {
+				return nil
+			}
+			msg, err := eventToProto(evt)
+			if err != nil {
+				return status.Error(codes.Internal, err.Error())
+			}
+			if err := stream.Send(msg); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// GetJob returns a stored job.
+func (s *Service) GetJob(ctx context.Context, req *pipelinev1.GetJobRequest) (*pipelinev1.JobReply, error) {
+	job, err := s.engine.GetJob(ctx, req.GetJobId())
+	if err != nil {
+		return nil, engineError(err)
+	}
+	return jobReply(job)
+}
+
+// CancelJob cancels a job and returns its updated state.
+func (s *Service) CancelJob(ctx context.Context, req *pipelinev1.CancelJobRequest) (*pipelinev1.JobReply, error) {
+	cancellation := engine.Cancellation{
+		By:     engine.CancelSource(req.GetBy()),
+		Code:   req.GetCode(),
+		Reason: req.GetReason(),
+		Strict: req.GetStrict(),
+	}
+	if err := cancellation.Validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := s.engine.CancelJobWithDetails(ctx, req.GetJobId(), cancellation); err != nil {
+		return nil, engineError(err)
+	}
+	return s.GetJob(ctx, &pipelinev1.GetJobRequest{JobId: req.GetJobId()})
+}
+
+// RerunJob starts a child job of req.JobId.
+func (s *Service) RerunJob(ctx context.Context, req *pipelinev1.RerunJobRequest) (*pipelinev1.JobReply, error) {
+	opts := engine.RerunOptions{ReuseUpstream: req.GetReuseUpstream()}
+	if id := req.GetFromStepId(); id != "" {
+		step := engine.StepID(id)
+		opts.FromStepID = &step
+	}
+	for _, id := range req.GetReuseSteps() {
+		opts.ReuseSteps = append(opts.ReuseSteps, engine.StepID(id))
+	}
+	if override := req.GetOverrideInput(); override != nil {
+		input, err := jobInputFromProto(override)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid override_input: %v", err)
+		}
+		opts.OverrideInput = &input
+	}
+
+	base, err := s.engine.GetJob(ctx, req.GetJobId())
+	if err != nil {
+		return nil, engineError(err)
+	}
+	job, err := s.engine.RunJob(ctx, engine.NewRerunRequest(base, opts))
+	if err != nil {
+		return nil, engineError(err)
+	}
+	return jobReply(job)
+}
+
+func decodeJobRequest(req *pipelinev1.RunJobRequest) (engine.JobRequest, error) {
+	if req.GetRequest() == nil {
+		return engine.JobRequest{}, status.Error(codes.InvalidArgument, "request is required")
+	}
+	jobReq, err := jobRequestFromProto(req.GetRequest())
+	if err != nil {
+		return jobReq, status.Errorf(codes.InvalidArgument, "invalid request: %v", err)
+	}
+	return jobReq, nil
+}
+
+func jobReply(job *engine.Job) (*pipelinev1.JobReply, error) {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "encode job: %v", err)
+	}
+	typed, err := jobToProto(job)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &pipelinev1.JobReply{JobId: job.ID, Status: string(job.Status), JobJson: data, Job: typed}, nil
+}
+
+// engineError maps engine errors to gRPC status codes, mirroring the HTTP
+// status codes of handleEngineError.
+func engineError(err error) error {
+	switch {
+	case errors.Is(err, store.ErrJobNotFound), errors.Is(err, engine.ErrStepNotFound), errors.Is(err, engine.ErrPipelineNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, engine.ErrStepNotRunning), errors.Is(err, engine.ErrJobTerminal):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	default:
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+}
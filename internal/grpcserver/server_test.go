@@ -0,0 +1,200 @@
+package grpcserver_test
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/example/pipeline-engine/internal/engine"
+	"github.com/example/pipeline-engine/internal/grpcserver"
+	"github.com/example/pipeline-engine/internal/store"
+	"github.com/example/pipeline-engine/pkg/grpc/pipelinev1"
+)
+
+func newTestClient(t *testing.T) pipelinev1.PipelineEngineClient {
+	t.Helper()
+	// キャンセルが実行中に届くようスタブ出力のステップに遅延を入れる。
+	eng := engine.NewBasicEngineWithConfig(store.NewMemoryStore(), &engine.EngineConfig{SimulatedLatency: 50 * time.Millisecond})
+	t.Cleanup(eng.Close)
+	eng.RegisterPipeline(engine.PipelineDef{
+		Type:    "grpc_pipeline",
+		Version: "v1",
+		Steps: []engine.StepDef{
+			{ID: "draft", Kind: engine.StepKindLLM},
+			{ID: "final", Kind: engine.StepKindLLM, DependsOn: []engine.StepID{"draft"}, Export: true},
+		},
+	})
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpcserver.NewServer(eng)
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(func() { srv.Shutdown(context.Background()) })
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("gRPC クライアントの作成に失敗しました: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return pipelinev1.NewPipelineEngineClient(conn)
+}
+
+func runJobRequest(mode string) *pipelinev1.RunJobRequest {
+	return &pipelinev1.RunJobRequest{Request: &pipelinev1.JobRequest{
+		PipelineType: "grpc_pipeline",
+		Mode:         mode,
+		Input: &pipelinev1.JobInput{
+			Sources:     []*pipelinev1.Source{{Kind: string(engine.SourceKindNote), Label: "memo", Content: "gRPC 経由", MetadataJson: []byte(`{"path":"memo.txt"}`)}},
+			OptionsJson: []byte(`{"language":"ja"}`),
+		},
+	}}
+}
+
+func decodeJob(t *testing.T, reply *pipelinev1.JobReply) engine.Job {
+	t.Helper()
+	var job engine.Job
+	if err := json.Unmarshal(reply.GetJobJson(), &job); err != nil {
+		t.Fatalf("job_json の復号に失敗しました: %v", err)
+	}
+	if job.ID != reply.GetJobId() || string(job.Status) != reply.GetStatus() {
+		t.Fatalf("job_id / status が job_json と一致しません: %+v", reply)
+	}
+	typed := reply.GetJob()
+	if typed.GetId() != job.ID || typed.GetStatus() != string(job.Status) || len(typed.GetStepExecutions()) != len(job.StepExecutions) {
+		t.Fatalf("job が job_json と一致しません: %+v", typed)
+	}
+	if job.Result != nil && len(typed.GetResult().GetItems()) != len(job.Result.Items) {
+		t.Fatalf("job.result が job_json と一致しません: %+v", typed.GetResult())
+	}
+	return job
+}
+
+func TestServiceRunGetAndRerunJob(t *testing.T) {
+	t.Parallel()
+	client := newTestClient(t)
+	ctx := context.Background()
+
+	reply, err := client.RunJob(ctx, runJobRequest("sync"))
+	if err != nil {
+		t.Fatalf("RunJob に失敗しました: %v", err)
+	}
+	job := decodeJob(t, reply)
+	if job.Status != engine.JobStatusSucceeded || job.Result == nil || len(job.Result.Items) != 1 {
+		t.Fatalf("sync ジョブの結果が想定外です: %s %+v", job.Status, job.Result)
+	}
+	if src := job.Input.Sources[0]; src.Metadata["path"] != "memo.txt" || job.Input.Options == nil || job.Input.Options.Language != "ja" {
+		t.Fatalf("metadata_json / options_json が反映されていません: %+v %+v", src, job.Input.Options)
+	}
+	item := reply.GetJob().GetResult().GetItems()[0]
+	var data map[string]any
+	if err := json.Unmarshal(item.GetDataJson(), &data); err != nil || item.GetStepId() != "final" || data["text"] == nil {
+		t.Fatalf("結果アイテムが想定外です: %+v %v", item, err)
+	}
+
+	got, err := client.GetJob(ctx, &pipelinev1.GetJobRequest{JobId: job.ID})
+	if err != nil || got.GetStatus() != string(engine.JobStatusSucceeded) {
+		t.Fatalf("GetJob が想定外です: %+v %v", got, err)
+	}
+
+	rerun, err := client.RerunJob(ctx, &pipelinev1.RerunJobRequest{JobId: job.ID, FromStepId: "final", ReuseUpstream: true})
+	if err != nil {
+		t.Fatalf("RerunJob に失敗しました: %v", err)
+	}
+	child := decodeJob(t, rerun)
+	if child.ParentJobID == nil || *child.ParentJobID != job.ID || child.RerunFromStep == nil || *child.RerunFromStep != "final" {
+		t.Fatalf("リランの親子関係が想定外です: %+v", child)
+	}
+
+	if _, err := client.GetJob(ctx, &pipelinev1.GetJobRequest{JobId: "missing"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("存在しないジョブは NotFound になるべきです: %v", err)
+	}
+	if _, err := client.RunJob(ctx, &pipelinev1.RunJobRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("request のないリクエストは InvalidArgument になるべきです: %v", err)
+	}
+	bad := runJobRequest("sync")
+	bad.Request.Input.OptionsJson = []byte("{")
+	if _, err := client.RunJob(ctx, bad); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("不正な options_json は InvalidArgument になるべきです: %v", err)
+	}
+}
+
+func TestServiceRunJobStream(t *testing.T) {
+	t.Parallel()
+	client := newTestClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stream, err := client.RunJobStream(ctx, runJobRequest(""))
+	if err != nil {
+		t.Fatalf("RunJobStream に失敗しました: %v", err)
+	}
+	var events []string
+	var completed *pipelinev1.Job
+	var stepsCompleted []string
+	var items []*pipelinev1.ResultItem
+	for {
+		evt, err := stream.Recv()
+		if err != nil {
+			break
+		}
+		events = append(events, evt.GetEvent())
+		switch evt.GetEvent() {
+		case engine.EventJobCompleted:
+			completed = evt.GetJob()
+		case engine.EventStepCompleted:
+			stepsCompleted = append(stepsCompleted, evt.GetStep().GetStepId())
+		case engine.EventItemCompleted:
+			items = append(items, evt.GetItem())
+		}
+	}
+	if len(events) == 0 || events[0] != engine.EventJobQueued || events[len(events)-1] != engine.EventStreamFinished {
+		t.Fatalf("イベント列が想定外です: %v", events)
+	}
+	if completed.GetStatus() != string(engine.JobStatusSucceeded) || len(completed.GetResult().GetItems()) != 1 {
+		t.Fatalf("job_completed のペイロードが想定外です: %+v", completed)
+	}
+	if len(stepsCompleted) != 2 || stepsCompleted[0] != "draft" || stepsCompleted[1] != "final" {
+		t.Fatalf("step_completed のペイロードが想定外です: %v", stepsCompleted)
+	}
+	if len(items) == 0 || items[0].GetId() == "" || len(items[0].GetDataJson()) == 0 {
+		t.Fatalf("item_completed のペイロードが想定外です: %+v", items)
+	}
+}
+
+func TestServiceCancelJob(t *testing.T) {
+	t.Parallel()
+	client := newTestClient(t)
+	ctx := context.Background()
+
+	reply, err := client.RunJob(ctx, runJobRequest("async"))
+	if err != nil {
+		t.Fatalf("RunJob に失敗しました: %v", err)
+	}
+	cancelled, err := client.CancelJob(ctx, &pipelinev1.CancelJobRequest{JobId: reply.GetJobId(), Reason: "不要になった"})
+	if err != nil {
+		t.Fatalf("CancelJob に失敗しました: %v", err)
+	}
+	job := decodeJob(t, cancelled)
+	if job.Status != engine.JobStatusCancelled || job.Cancellation == nil || job.Cancellation.Reason != "不要になった" {
+		t.Fatalf("キャンセルが記録されていません: %+v", job)
+	}
+
+	_, err = client.CancelJob(ctx, &pipelinev1.CancelJobRequest{JobId: reply.GetJobId(), Strict: true})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("終了済みジョブの strict キャンセルは FailedPrecondition になるべきです: %v", err)
+	}
+	_, err = client.CancelJob(ctx, &pipelinev1.CancelJobRequest{JobId: reply.GetJobId(), By: "robot"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("未知の by は InvalidArgument になるべきです: %v", err)
+	}
+}
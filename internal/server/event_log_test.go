@@ -0,0 +1,49 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/example/pipeline-engine/internal/engine"
+)
+
+func TestHandlerEventLogSweeperEvictsAllJobState(t *testing.T) {
+	t.Parallel()
+
+	h := NewHandler(nil, time.Time{}, "")
+	h.eventLogRetention = 10 * time.Millisecond
+	h.startRecorder("job-swept", func() {
+		h.recordEvent("job-swept", engine.StreamingEvent{Event: "job_completed"})
+	})
+	_, wake, _ := h.eventsSince("job-swept", 0)
+
+	h.StartEventLogSweeper(5 * time.Millisecond)
+	defer h.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		h.eventMu.RLock()
+		remaining := len(h.eventSeq) + len(h.eventLogs) + len(h.eventWake) + len(h.recorders) + len(h.eventFinished)
+		h.eventMu.RUnlock()
+		if remaining == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("スイーパーがジョブごとの状態を破棄していません: 残り %d 件", remaining)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	select {
+	case <-wake:
+	default:
+		t.Fatal("破棄時に待機中のクライアントが起こされていません")
+	}
+
+	h.Close()
+	h.Close()
+	select {
+	case <-h.sweepDone:
+	default:
+		t.Fatal("Close 後もスイーパーが動いています")
+	}
+}
@@ -1,10 +1,12 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"strconv"
 	"strings"
@@ -26,16 +28,81 @@ type Handler struct {
 	eventMu   sync.RWMutex
 	eventSeq  map[string]uint64
 	eventLogs map[string][]engine.StreamingEvent
+	// eventWake is closed and replaced whenever an event is appended so
+	// streaming clients can wait for new events without polling.
+	eventWake map[string]chan struct{}
+	// recorders tracks jobs whose events are being written to eventLogs:
+	// true while the recorder runs, false once it has finished.
+	recorders map[string]bool
+	// eventFinished records when each job's recorder finished. Finished logs
+	// are evicted, with every other per-job entry, once they are older than
+	// the retention window.
+	eventFinished map[string]time.Time
+	// sweepStop and sweepDone run the background eviction started by
+	// StartEventLogSweeper; closeOnce guards Close.
+	sweepStop chan struct{}
+	sweepDone chan struct{}
+	closeOnce sync.Once
+
+	settingsMu        sync.RWMutex
+	heartbeatInterval time.Duration
+	maxBodyBytes      int64
+	eventLogMax       int
+	eventLogRetention time.Duration
+	// allowedOrigins and wsRequestTimeout configure job WebSockets; see
+	// SetAllowedOrigins and SetWebSocketRequestTimeout.
+	allowedOrigins   []string
+	wsRequestTimeout time.Duration
 }
 
 type rerunRequest struct {
 	FromStepID    *engine.StepID   `json:"from_step_id"`
 	ReuseUpstream bool             `json:"reuse_upstream"`
+	ReuseSteps    []engine.StepID  `json:"reuse_steps"`
 	OverrideInput *engine.JobInput `json:"override_input"`
+	// EphemeralProviders must be supplied again for every rerun; the parent
+	// job never stored them.
+	EphemeralProviders []engine.ProviderProfile `json:"ephemeral_providers"`
 }
 
 type jobResponse struct {
 	Job *engine.Job `json:"job"`
+	// Pipeline is the definition snapshotted at job creation; only set for
+	// GET /v1/jobs/{id}?include_pipeline=true.
+	Pipeline *engine.PipelineDef `json:"pipeline,omitempty"`
+}
+
+type jobListResponse struct {
+	Jobs []*engine.Job `json:"jobs"`
+}
+
+type eventListResponse struct {
+	Events []engine.StreamingEvent `json:"events"`
+}
+
+type batchJobItem struct {
+	Index int              `json:"index"`
+	Job   *engine.Job      `json:"job,omitempty"`
+	Error *apiErrorPayload `json:"error,omitempty"`
+}
+
+type batchJobsResponse struct {
+	BatchID string         `json:"batch_id"`
+	Items   []batchJobItem `json:"items"`
+	Created int            `json:"created"`
+	Failed  int            `json:"failed"`
+}
+
+// maxBatchJobs caps the number of requests accepted by POST /v1/jobs/batch.
+const maxBatchJobs = 100
+
+type resultsResponse struct {
+	JobID  string              `json:"job_id"`
+	Tag    string              `json:"tag,omitempty"`
+	Items  []engine.ResultItem `json:"items"`
+	Total  int                 `json:"total"`
+	Limit  int                 `json:"limit,omitempty"`
+	Offset int                 `json:"offset"`
 }
 
 type apiErrorPayload struct {
@@ -48,6 +115,12 @@ type apiErrorResponse struct {
 	Error apiErrorPayload `json:"error"`
 }
 
+// capabilitiesResponse is the body of GET /v1/capabilities.
+type capabilitiesResponse struct {
+	Version string `json:"version"`
+	engine.Capabilities
+}
+
 type providerProfileRequest struct {
 	ID           engine.ProviderProfileID `json:"id"`
 	Kind         engine.ProviderKind      `json:"kind"`
@@ -58,9 +131,54 @@ type providerProfileRequest struct {
 }
 
 type engineConfigRequest struct {
-	LogLevel string `json:"log_level"`
+	LogLevel            string `json:"log_level"`
+	ProviderTimeoutMS   *int64 `json:"provider_timeout_ms"`
+	MaxConcurrency      *int   `json:"max_concurrency"`
+	HeartbeatIntervalMS *int64 `json:"heartbeat_interval_ms"`
+	MaxRequestBodyBytes *int64 `json:"max_request_body_bytes"`
+	EventLogMaxEvents   *int   `json:"event_log_max_events"`
+	EventLogRetentionMS *int64 `json:"event_log_retention_ms"`
+	CancelOnDisconnect  *bool  `json:"cancel_on_disconnect"`
+}
+
+type engineConfigResponse struct {
+	LogLevel            string `json:"log_level"`
+	ProviderTimeoutMS   int64  `json:"provider_timeout_ms"`
+	MaxConcurrency      int    `json:"max_concurrency"`
+	HeartbeatIntervalMS int64  `json:"heartbeat_interval_ms"`
+	MaxRequestBodyBytes int64  `json:"max_request_body_bytes"`
+	EventLogMaxEvents   int    `json:"event_log_max_events"`
+	EventLogRetentionMS int64  `json:"event_log_retention_ms"`
+	CancelOnDisconnect  bool   `json:"cancel_on_disconnect"`
 }
 
+// logTruncatedData is the payload of the log_truncated marker sent when
+// events after after_seq were evicted; first_seq is the oldest retained one.
+type logTruncatedData struct {
+	AfterSeq uint64 `json:"after_seq"`
+	FirstSeq uint64 `json:"first_seq"`
+}
+
+const (
+	// defaultMaxRequestBodyBytes caps JSON request bodies (10 MiB).
+	defaultMaxRequestBodyBytes = 10 << 20
+	minRequestBodyBytes        = 1 << 10
+	maxRequestBodyBytes        = 1 << 30
+	// Heartbeats are disabled (0) by default.
+	minHeartbeatInterval = 100 * time.Millisecond
+	maxHeartbeatInterval = 5 * time.Minute
+	// Each job's event log keeps its newest defaultEventLogMaxEvents events
+	// and is evicted defaultEventLogRetention after its recorder finished.
+	defaultEventLogMaxEvents = 10000
+	minEventLogMaxEvents     = 10
+	maxEventLogMaxEvents     = 1000000
+	defaultEventLogRetention = 15 * time.Minute
+	maxEventLogRetention     = 7 * 24 * time.Hour
+	// defaultEventLogSweepInterval is how often the background sweeper
+	// evicts expired event logs.
+	defaultEventLogSweepInterval = time.Minute
+)
+
 // NewHandler creates a Handler.
 func NewHandler(e engine.Engine, startedAt time.Time, version string) *Handler {
 	if startedAt.IsZero() {
@@ -75,18 +193,89 @@ func NewHandler(e engine.Engine, startedAt time.Time, version string) *Handler {
 		version:   version,
 		eventSeq:  map[string]uint64{},
 		eventLogs: map[string][]engine.StreamingEvent{},
+		eventWake: map[string]chan struct{}{},
+		recorders: map[string]bool{},
+
+		eventFinished: map[string]time.Time{},
+
+		maxBodyBytes:      defaultMaxRequestBodyBytes,
+		eventLogMax:       defaultEventLogMaxEvents,
+		eventLogRetention: defaultEventLogRetention,
+		wsRequestTimeout:  defaultWebSocketRequestTimeout,
 	}
 }
 
 // Register registers all HTTP routes.
 func (h *Handler) Register(mux *http.ServeMux) {
-	mux.HandleFunc("/health", h.handleHealth)
-	mux.HandleFunc("/v1/jobs", h.handleJobs)
-	mux.HandleFunc("/v1/jobs/", h.handleJobOps)
-	mux.HandleFunc("/v1/config/providers", h.handleProviderConfig)
-	mux.HandleFunc("/v1/config/engine", h.handleEngineConfig)
-	mux.HandleFunc("/v1/config/pipelines", h.handlePipelineList)
-	mux.HandleFunc("/v1/metrics", h.handleMetrics)
+	mux.HandleFunc("/health", prettyJSON(h.handleHealth))
+	mux.HandleFunc("/v1/capabilities", prettyJSON(h.handleCapabilities))
+	mux.HandleFunc("/v1/jobs", prettyJSON(h.limitBody(h.handleJobs)))
+	mux.HandleFunc("/v1/jobs/batch", prettyJSON(h.limitBody(h.handleJobBatch)))
+	mux.HandleFunc("/v1/jobs/ws", prettyJSON(h.createJobWebSocket))
+	mux.HandleFunc("/v1/jobs/", prettyJSON(h.limitBody(h.handleJobOps)))
+	mux.HandleFunc("/v1/config/providers", prettyJSON(h.limitBody(h.handleProviderConfig)))
+	mux.HandleFunc("/v1/config/engine", prettyJSON(h.limitBody(h.handleEngineConfig)))
+	mux.HandleFunc("/v1/config/pipelines", prettyJSON(h.handlePipelineList))
+	mux.HandleFunc("/v1/config/pipelines/lint", prettyJSON(h.limitBody(h.handlePipelineLint)))
+	mux.HandleFunc("/v1/metrics", prettyJSON(h.handleMetrics))
+}
+
+// prettyJSON makes writeJSON indent its output when the request has
+// ?pretty=true. NDJSON streams stay one compact event per line.
+func prettyJSON(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if pretty, _ := strconv.ParseBool(r.URL.Query().Get("pretty")); pretty {
+			w = &prettyResponseWriter{ResponseWriter: w}
+		}
+		next(w, r)
+	}
+}
+
+// prettyResponseWriter marks a response whose JSON documents are indented.
+type prettyResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (p *prettyResponseWriter) Flush() {
+	if flusher, ok := p.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (p *prettyResponseWriter) Unwrap() http.ResponseWriter {
+	return p.ResponseWriter
+}
+
+// newJSONEncoder returns an encoder for a single JSON document, indented when
+// the client asked for ?pretty=true.
+func newJSONEncoder(w http.ResponseWriter) *json.Encoder {
+	enc := json.NewEncoder(w)
+	if _, ok := w.(*prettyResponseWriter); ok {
+		enc.SetIndent("", "  ")
+	}
+	return enc
+}
+
+// limitBody caps the request body at the current max_request_body_bytes.
+func (h *Handler) limitBody(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, h.currentMaxBodyBytes())
+		}
+		next(w, r)
+	}
+}
+
+func (h *Handler) currentMaxBodyBytes() int64 {
+	h.settingsMu.RLock()
+	defer h.settingsMu.RUnlock()
+	return h.maxBodyBytes
+}
+
+func (h *Handler) currentHeartbeatInterval() time.Duration {
+	h.settingsMu.RLock()
+	defer h.settingsMu.RUnlock()
+	return h.heartbeatInterval
 }
 
 func (h *Handler) handleHealth(w http.ResponseWriter, r *http.Request) {
@@ -98,10 +287,20 @@ func (h *Handler) handleHealth(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, payload)
 }
 
+func (h *Handler) handleCapabilities(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, capabilitiesResponse{Version: h.version, Capabilities: engine.SupportedCapabilities()})
+}
+
 func (h *Handler) handleJobs(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodPost:
 		h.createJob(w, r)
+	case http.MethodGet:
+		h.listJobs(w, r)
 	default:
 		writeMethodNotAllowed(w)
 	}
@@ -134,6 +333,50 @@ func (h *Handler) handleJobOps(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		h.streamExistingJob(w, r, jobID)
+	case "ws":
+		if r.Method != http.MethodGet {
+			writeMethodNotAllowed(w)
+			return
+		}
+		h.streamJobWebSocket(w, r, jobID)
+	case "results":
+		if len(parts) > 3 || (len(parts) == 3 && parts[2] == "") {
+			writeNotFound(w)
+			return
+		}
+		if r.Method != http.MethodGet {
+			writeMethodNotAllowed(w)
+			return
+		}
+		if len(parts) == 3 {
+			h.getJobResultItem(w, r, jobID, parts[2])
+			return
+		}
+		h.getJobResults(w, r, jobID)
+	case "lineage":
+		if r.Method != http.MethodGet {
+			writeMethodNotAllowed(w)
+			return
+		}
+		h.getJobLineage(w, r, jobID)
+	case "diff":
+		if r.Method != http.MethodGet {
+			writeMethodNotAllowed(w)
+			return
+		}
+		h.getJobDiff(w, r, jobID)
+	case "wait":
+		if r.Method != http.MethodGet {
+			writeMethodNotAllowed(w)
+			return
+		}
+		h.waitJob(w, r, jobID)
+	case "events":
+		if r.Method != http.MethodGet {
+			writeMethodNotAllowed(w)
+			return
+		}
+		h.getJobEvents(w, r, jobID)
 	case "cancel":
 		if r.Method != http.MethodPost {
 			writeMethodNotAllowed(w)
@@ -146,6 +389,16 @@ func (h *Handler) handleJobOps(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		h.rerunJob(w, r, jobID)
+	case "steps":
+		if len(parts) != 4 || parts[2] == "" || parts[3] != "skip" {
+			writeNotFound(w)
+			return
+		}
+		if r.Method != http.MethodPost {
+			writeMethodNotAllowed(w)
+			return
+		}
+		h.skipStep(w, r, jobID, engine.StepID(parts[2]))
 	default:
 		writeNotFound(w)
 	}
@@ -159,7 +412,7 @@ func (h *Handler) handleProviderConfig(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 	var payload providerProfileRequest
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		writeAPIError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("invalid payload: %v", err), nil)
+		writePayloadError(w, err)
 		return
 	}
 	if payload.ID == "" {
@@ -168,13 +421,18 @@ func (h *Handler) handleProviderConfig(w http.ResponseWriter, r *http.Request) {
 	}
 	profile := engine.ProviderProfile{
 		ID:           payload.ID,
-		Kind:         payload.Kind,
+		Kind:         engine.NormalizeProviderKind(payload.Kind),
 		BaseURI:      payload.BaseURI,
 		APIKey:       payload.APIKey,
 		DefaultModel: payload.DefaultModel,
 		Extra:        payload.Extra,
 	}
 	if err := h.engine.UpsertProviderProfile(profile); err != nil {
+		var kindErr *engine.ProviderKindError
+		if errors.As(err, &kindErr) {
+			writeAPIError(w, http.StatusBadRequest, "invalid_request", err.Error(), map[string]any{"kind": kindErr.Kind, "supported_kinds": kindErr.Supported})
+			return
+		}
 		writeAPIError(w, http.StatusInternalServerError, "config_error", err.Error(), nil)
 		return
 	}
@@ -182,26 +440,113 @@ func (h *Handler) handleProviderConfig(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) handleEngineConfig(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, h.engineConfigSnapshot())
+		return
+	case http.MethodPost:
+	default:
 		writeMethodNotAllowed(w)
 		return
 	}
 	defer r.Body.Close()
 	var payload engineConfigRequest
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil && !errors.Is(err, io.EOF) {
-		writeAPIError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("invalid payload: %v", err), nil)
+		writePayloadError(w, err)
+		return
+	}
+	if payload.LogLevel == "" && payload.ProviderTimeoutMS == nil && payload.MaxConcurrency == nil &&
+		payload.HeartbeatIntervalMS == nil && payload.MaxRequestBodyBytes == nil &&
+		payload.EventLogMaxEvents == nil && payload.EventLogRetentionMS == nil && payload.CancelOnDisconnect == nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", "no configuration provided", nil)
 		return
 	}
-	resp := map[string]any{}
+
+	// Validate everything before applying anything so a bad field leaves the
+	// current configuration untouched.
+	runtime := h.engine.RuntimeConfig()
+	if payload.ProviderTimeoutMS != nil {
+		runtime.ProviderTimeout = time.Duration(*payload.ProviderTimeoutMS) * time.Millisecond
+	}
+	if payload.MaxConcurrency != nil {
+		runtime.MaxConcurrency = *payload.MaxConcurrency
+	}
+	if payload.CancelOnDisconnect != nil {
+		runtime.CancelOnDisconnect = *payload.CancelOnDisconnect
+	}
+	if err := runtime.Validate(); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_config", err.Error(), nil)
+		return
+	}
+	if payload.HeartbeatIntervalMS != nil {
+		interval := time.Duration(*payload.HeartbeatIntervalMS) * time.Millisecond
+		if interval != 0 && (interval < minHeartbeatInterval || interval > maxHeartbeatInterval) {
+			writeAPIError(w, http.StatusBadRequest, "invalid_config",
+				fmt.Sprintf("heartbeat interval must be 0 (disabled) or between %s and %s", minHeartbeatInterval, maxHeartbeatInterval), nil)
+			return
+		}
+	}
+	if payload.MaxRequestBodyBytes != nil {
+		if size := *payload.MaxRequestBodyBytes; size < minRequestBodyBytes || size > maxRequestBodyBytes {
+			writeAPIError(w, http.StatusBadRequest, "invalid_config",
+				fmt.Sprintf("max request body bytes must be between %d and %d", minRequestBodyBytes, maxRequestBodyBytes), nil)
+			return
+		}
+	}
+	if payload.EventLogMaxEvents != nil {
+		if n := *payload.EventLogMaxEvents; n < minEventLogMaxEvents || n > maxEventLogMaxEvents {
+			writeAPIError(w, http.StatusBadRequest, "invalid_config",
+				fmt.Sprintf("event log max events must be between %d and %d", minEventLogMaxEvents, maxEventLogMaxEvents), nil)
+			return
+		}
+	}
+	if payload.EventLogRetentionMS != nil {
+		if retention := time.Duration(*payload.EventLogRetentionMS) * time.Millisecond; retention < 0 || retention > maxEventLogRetention {
+			writeAPIError(w, http.StatusBadRequest, "invalid_config",
+				fmt.Sprintf("event log retention must be between 0 and %s", maxEventLogRetention), nil)
+			return
+		}
+	}
+
 	if payload.LogLevel != "" {
-		level := logging.SetLevelFromString(payload.LogLevel)
-		resp["log_level"] = level.String()
+		logging.SetLevelFromString(payload.LogLevel)
 	}
-	if len(resp) == 0 {
-		writeAPIError(w, http.StatusBadRequest, "invalid_request", "no configuration provided", nil)
+	if _, err := h.engine.UpdateRuntimeConfig(runtime); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_config", err.Error(), nil)
 		return
 	}
-	writeJSON(w, http.StatusOK, resp)
+	h.settingsMu.Lock()
+	if payload.HeartbeatIntervalMS != nil {
+		h.heartbeatInterval = time.Duration(*payload.HeartbeatIntervalMS) * time.Millisecond
+	}
+	if payload.MaxRequestBodyBytes != nil {
+		h.maxBodyBytes = *payload.MaxRequestBodyBytes
+	}
+	if payload.EventLogMaxEvents != nil {
+		h.eventLogMax = *payload.EventLogMaxEvents
+	}
+	if payload.EventLogRetentionMS != nil {
+		h.eventLogRetention = time.Duration(*payload.EventLogRetentionMS) * time.Millisecond
+	}
+	h.settingsMu.Unlock()
+	writeJSON(w, http.StatusOK, h.engineConfigSnapshot())
+}
+
+// engineConfigSnapshot reports the effective runtime configuration.
+func (h *Handler) engineConfigSnapshot() engineConfigResponse {
+	runtime := h.engine.RuntimeConfig()
+	h.settingsMu.RLock()
+	defer h.settingsMu.RUnlock()
+	return engineConfigResponse{
+		LogLevel:            logging.CurrentLevel().String(),
+		ProviderTimeoutMS:   runtime.ProviderTimeout.Milliseconds(),
+		MaxConcurrency:      runtime.MaxConcurrency,
+		HeartbeatIntervalMS: h.heartbeatInterval.Milliseconds(),
+		MaxRequestBodyBytes: h.maxBodyBytes,
+		EventLogMaxEvents:   h.eventLogMax,
+		EventLogRetentionMS: h.eventLogRetention.Milliseconds(),
+		CancelOnDisconnect:  runtime.CancelOnDisconnect,
+	}
 }
 
 func (h *Handler) handlePipelineList(w http.ResponseWriter, r *http.Request) {
@@ -213,55 +558,136 @@ func (h *Handler) handlePipelineList(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]any{"pipelines": pipelines})
 }
 
+// handlePipelineLint reports the lint findings of a pipeline definition
+// without registering it.
+func (h *Handler) handlePipelineLint(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w)
+		return
+	}
+	var def engine.PipelineDef
+	if err := json.NewDecoder(r.Body).Decode(&def); err != nil {
+		writePayloadError(w, err)
+		return
+	}
+	findings := h.engine.LintPipeline(def)
+	if findings == nil {
+		findings = []engine.Finding{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"findings": findings})
+}
+
 func (h *Handler) handleMetrics(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		writeMethodNotAllowed(w)
 		return
 	}
 	payload := map[string]any{
-		"provider_call_count":   snapshotExpvarMap("provider_call_count"),
-		"provider_call_latency": snapshotExpvarMap("provider_call_latency_ms"),
-		"provider_call_errors":  snapshotExpvarMap("provider_call_errors"),
-		"provider_chunk_count":  snapshotExpvarMap("provider_chunk_count"),
+		"provider_call_count":     snapshotExpvarMap("provider_call_count"),
+		"provider_call_latency":   snapshotExpvarMap("provider_call_latency_ms"),
+		"provider_call_errors":    snapshotExpvarMap("provider_call_errors"),
+		"provider_call_cancelled": snapshotExpvarMap("provider_call_cancelled"),
+		"provider_chunk_count":    snapshotExpvarMap("provider_chunk_count"),
+		// Keyed "<kind>/<model>".
+		"provider_model_call_count":     snapshotExpvarMap("provider_model_call_count"),
+		"provider_model_call_latency":   snapshotExpvarMap("provider_model_call_latency_ms"),
+		"provider_model_call_errors":    snapshotExpvarMap("provider_model_call_errors"),
+		"provider_model_call_cancelled": snapshotExpvarMap("provider_model_call_cancelled"),
+		"job_count":                     snapshotExpvarMap("job_count"),
+		// Keyed "<pipeline_type>/<status>".
+		"job_pipeline_count": snapshotExpvarMap("job_pipeline_count"),
+		// Cumulative le_<ms> buckets plus count and sum.
+		"job_duration_ms": snapshotExpvarMap("job_duration_ms"),
+		// Jobs executing now per pipeline type; queued jobs are not counted.
+		"pipeline_in_flight": snapshotExpvarMap("pipeline_in_flight"),
 	}
 	writeJSON(w, http.StatusOK, payload)
 }
 
+func (h *Handler) handleJobBatch(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		h.createJobBatch(w, r)
+	default:
+		writeMethodNotAllowed(w)
+	}
+}
+
+func (h *Handler) createJobBatch(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var reqs []engine.JobRequest
+	if err := json.NewDecoder(r.Body).Decode(&reqs); err != nil {
+		writePayloadError(w, err)
+		return
+	}
+	if len(reqs) == 0 {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", "at least one job request is required", nil)
+		return
+	}
+	if len(reqs) > maxBatchJobs {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("batch exceeds %d job requests", maxBatchJobs), nil)
+		return
+	}
+
+	resp := batchJobsResponse{
+		BatchID: h.engine.NewBatchID(),
+		Items:   make([]batchJobItem, len(reqs)),
+	}
+	for i, req := range reqs {
+		req.BatchID = resp.BatchID
+		item := batchJobItem{Index: i}
+		if job, err := h.engine.RunJob(r.Context(), req); err != nil {
+			_, payload := engineErrorPayload(err)
+			item.Error = &payload
+			resp.Failed++
+		} else {
+			item.Job = job
+			resp.Created++
+		}
+		resp.Items[i] = item
+	}
+
+	writeJSON(w, http.StatusAccepted, resp)
+}
+
 func (h *Handler) createJob(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 	var req engine.JobRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeAPIError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("invalid payload: %v", err), nil)
+		writePayloadError(w, err)
 		return
 	}
 
 	if r.URL.Query().Get("stream") == "true" {
-		events, job, err := h.engine.RunJobStream(r.Context(), req)
-		if err != nil {
-			handleEngineError(w, err)
+		format, ok := parseStreamFormat(w, r)
+		if !ok {
 			return
 		}
-		w.Header().Set("Content-Type", "application/x-ndjson")
-		enc := json.NewEncoder(w)
-		flusher, _ := w.(http.Flusher)
-
-		queued := h.appendEvent(engine.StreamingEvent{Event: "job_queued", JobID: job.ID, Data: job})
-		if err := enc.Encode(queued); err != nil {
-			return
+		// The engine stream outlives this request so that events emitted after
+		// a disconnect still reach the log for clients resuming with after_seq.
+		// With CancelOnDisconnect the request context is passed instead, and the
+		// engine cancels the job (still streaming its events) once it ends.
+		streamCtx := context.WithoutCancel(r.Context())
+		if h.engine.RuntimeConfig().CancelOnDisconnect {
+			streamCtx = r.Context()
 		}
-		if flusher != nil {
-			flusher.Flush()
+		events, job, err := h.engine.RunJobStream(streamCtx, req)
+		if err != nil {
+			handleEngineError(w, err)
+			return
 		}
+		final := h.finalItemsFilter(r, job.ID)
+		out := newEventWriter(w, format)
+		defer out.Close()
 
-		for event := range events {
-			event = h.appendEvent(event)
-			if err := enc.Encode(event); err != nil {
-				return
-			}
-			if flusher != nil {
-				flusher.Flush()
-			}
+		h.evictEventLogs(time.Now())
+		// The engine opens the stream with job_queued; log it before following
+		// so a client that disconnects right away has still received it.
+		if event, ok := <-events; ok {
+			h.recordEvent(job.ID, event)
 		}
+		h.startRecorder(job.ID, func() { h.recordEvents(job.ID, events) })
+		h.followEvents(r.Context(), out, job.ID, 0, final)
 		return
 	}
 
@@ -280,20 +706,297 @@ func (h *Handler) getJob(w http.ResponseWriter, r *http.Request, jobID string) {
 		handleEngineError(w, err)
 		return
 	}
+	if r.URL.Query().Get("include_results") == "false" {
+		trimmed := *job
+		trimmed.Result = nil
+		job = &trimmed
+	}
+	resp := jobResponse{Job: job}
+	if r.URL.Query().Get("include_pipeline") == "true" {
+		// Jobs created before snapshots existed are returned without one.
+		pipeline, err := h.engine.JobPipeline(r.Context(), jobID)
+		if err != nil && !errors.Is(err, engine.ErrPipelineNotFound) {
+			handleEngineError(w, err)
+			return
+		}
+		resp.Pipeline = pipeline
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// listJobs serves GET /v1/jobs. parent_job_id limits the listing to reruns
+// created directly from that job.
+func (h *Handler) listJobs(w http.ResponseWriter, r *http.Request) {
+	filter := engine.JobFilter{ParentJobID: strings.TrimSpace(r.URL.Query().Get("parent_job_id"))}
+	jobs, err := h.engine.ListJobs(r.Context(), filter)
+	if err != nil {
+		handleEngineError(w, err)
+		return
+	}
+	if jobs == nil {
+		jobs = []*engine.Job{}
+	}
+	writeJSON(w, http.StatusOK, jobListResponse{Jobs: jobs})
+}
+
+func (h *Handler) getJobLineage(w http.ResponseWriter, r *http.Request, jobID string) {
+	lineage, err := h.engine.JobLineage(r.Context(), jobID)
+	if err != nil {
+		handleEngineError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, lineage)
+}
+
+// getJobDiff serves GET /v1/jobs/{id}/diff: the exported items of the job
+// compared with those of the job named by against, or of its parent when
+// against is omitted.
+func (h *Handler) getJobDiff(w http.ResponseWriter, r *http.Request, jobID string) {
+	diff, err := h.engine.DiffJobs(r.Context(), jobID, r.URL.Query().Get("against"))
+	if err != nil {
+		handleEngineError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, diff)
+}
+
+// defaultJobWait and maxJobWait bound the long poll of GET
+// /v1/jobs/{id}/wait so it does not outlive proxy idle timeouts; clients
+// waiting longer repeat the request.
+const (
+	defaultJobWait = 30 * time.Second
+	maxJobWait     = 60 * time.Second
+)
+
+// waitJob serves GET /v1/jobs/{id}/wait: it blocks until the job is terminal
+// or timeout (a duration such as "30s" or seconds, default 30s, at most 60s)
+// elapses and returns the job at that point. Clients tell the two apart by
+// its status. Waiting follows the job's event log, recorded by the same
+// poller as streams, so any number of waiting clients share one poller per
+// job.
+func (h *Handler) waitJob(w http.ResponseWriter, r *http.Request, jobID string) {
+	timeout, err := parseJobWait(r.URL.Query().Get("timeout"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", err.Error(), nil)
+		return
+	}
+	ctx := r.Context()
+	job, err := h.engine.GetJob(ctx, jobID)
+	if err != nil {
+		handleEngineError(w, err)
+		return
+	}
+	if !isTerminal(job.Status) && timeout > 0 {
+		h.evictEventLogs(time.Now())
+		h.startRecorder(jobID, func() { h.pollJobEvents(jobID) })
+		if !h.awaitJobEnd(ctx, jobID, timeout) {
+			return
+		}
+		if job, err = h.engine.GetJob(ctx, jobID); err != nil {
+			handleEngineError(w, err)
+			return
+		}
+	}
 	writeJobResponse(w, http.StatusOK, job)
 }
 
+func parseJobWait(raw string) (time.Duration, error) {
+	if raw == "" {
+		return defaultJobWait, nil
+	}
+	timeout, err := time.ParseDuration(raw)
+	if err != nil {
+		seconds, convErr := strconv.Atoi(raw)
+		if convErr != nil {
+			return 0, fmt.Errorf("invalid timeout: %s", raw)
+		}
+		timeout = time.Duration(seconds) * time.Second
+	}
+	if timeout < 0 {
+		return 0, fmt.Errorf("timeout must not be negative: %s", raw)
+	}
+	return min(timeout, maxJobWait), nil
+}
+
+// awaitJobEnd waits until the job's event log records the end of the job,
+// its recorder stops, or timeout elapses. It returns false when ctx is done
+// first.
+func (h *Handler) awaitJobEnd(ctx context.Context, jobID string, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	var lastSeq uint64
+	for {
+		events, wake, live := h.eventsSince(jobID, lastSeq)
+		for _, event := range events {
+			lastSeq = event.Seq
+			switch event.Event {
+			case engine.EventJobCompleted, engine.EventJobFailed, engine.EventJobCancelled, engine.EventStreamFinished:
+				return true
+			}
+		}
+		if !live {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timer.C:
+			return true
+		case <-wake:
+		}
+	}
+}
+
+// getJobEvents serves GET /v1/jobs/{id}/events: the job's logged events after
+// after_seq as a JSON array. Jobs that were never streamed start a recorder
+// and return its first snapshot.
+func (h *Handler) getJobEvents(w http.ResponseWriter, r *http.Request, jobID string) {
+	var afterSeq uint64
+	if raw := r.URL.Query().Get("after_seq"); raw != "" {
+		val, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("invalid after_seq: %s", raw), nil)
+			return
+		}
+		afterSeq = val
+	}
+
+	ctx := r.Context()
+	h.evictEventLogs(time.Now())
+	if !h.hasEventLog(jobID) {
+		if _, err := h.engine.GetJob(ctx, jobID); err != nil {
+			handleEngineError(w, err)
+			return
+		}
+		h.startRecorder(jobID, func() { h.pollJobEvents(jobID) })
+	}
+	events, wake, live := h.eventsSince(jobID, afterSeq)
+	for live && !h.hasEventLog(jobID) {
+		select {
+		case <-ctx.Done():
+			return
+		case <-wake:
+		}
+		events, wake, live = h.eventsSince(jobID, afterSeq)
+	}
+	if events == nil {
+		events = []engine.StreamingEvent{}
+	}
+	writeJSON(w, http.StatusOK, eventListResponse{Events: events})
+}
+
+// getJobResultItem serves GET /v1/jobs/{id}/results/{itemID}. The item is
+// returned as JSON by default; render=html converts it via renderResultItem.
+func (h *Handler) getJobResultItem(w http.ResponseWriter, r *http.Request, jobID, itemID string) {
+	mode := r.URL.Query().Get("render")
+	if mode != "" && mode != renderRaw && mode != renderHTML {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("unsupported render: %s", mode), nil)
+		return
+	}
+	job, err := h.engine.GetJob(r.Context(), jobID)
+	if err != nil {
+		handleEngineError(w, err)
+		return
+	}
+	var item *engine.ResultItem
+	if job.Result != nil {
+		for i := range job.Result.Items {
+			if job.Result.Items[i].ID == itemID {
+				item = &job.Result.Items[i]
+				break
+			}
+		}
+	}
+	if item == nil {
+		writeNotFound(w)
+		return
+	}
+	if mode != renderHTML {
+		writeJSON(w, http.StatusOK, item)
+		return
+	}
+	body, contentType, err := renderResultItem(*item)
+	if err != nil {
+		writeAPIError(w, http.StatusNotAcceptable, "not_renderable", err.Error(), nil)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if item.ContentType == engine.ContentBinary {
+		// Binary bytes are caller-controlled: never let the browser display
+		// them inline under the API's origin.
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": item.ID}))
+		w.Header().Set("Content-Security-Policy", binaryContentSecurityPolicy)
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
+
+func (h *Handler) getJobResults(w http.ResponseWriter, r *http.Request, jobID string) {
+	query := r.URL.Query()
+	limit, err := parseNonNegativeInt(query.Get("limit"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("invalid limit: %v", err), nil)
+		return
+	}
+	offset, err := parseNonNegativeInt(query.Get("offset"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("invalid offset: %v", err), nil)
+		return
+	}
+
+	job, err := h.engine.GetJob(r.Context(), jobID)
+	if err != nil {
+		handleEngineError(w, err)
+		return
+	}
+	tag := query.Get("tag")
+	items := []engine.ResultItem{}
+	if job.Result != nil {
+		for _, item := range job.Result.Items {
+			if tag == "" || item.Tag == tag {
+				items = append(items, item)
+			}
+		}
+	}
+
+	total := len(items)
+	if offset > total {
+		offset = total
+	}
+	end := total
+	if limit > 0 && offset+limit < total {
+		end = offset + limit
+	}
+	writeJSON(w, http.StatusOK, resultsResponse{
+		JobID:  job.ID,
+		Tag:    tag,
+		Items:  items[offset:end],
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+	})
+}
+
 func (h *Handler) cancelJob(w http.ResponseWriter, r *http.Request, jobID string) {
 	defer r.Body.Close()
 	var payload struct {
-		Reason string `json:"reason"`
+		Reason string              `json:"reason"`
+		By     engine.CancelSource `json:"by"`
+		Code   string              `json:"code"`
+		Strict bool                `json:"strict"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil && !errors.Is(err, io.EOF) {
-		writeAPIError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("invalid payload: %v", err), nil)
+		writePayloadError(w, err)
 		return
 	}
 
-	if err := h.engine.CancelJob(r.Context(), jobID, payload.Reason); err != nil {
+	cancellation := engine.Cancellation{By: payload.By, Code: payload.Code, Reason: payload.Reason, Strict: payload.Strict}
+	if err := cancellation.Validate(); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", err.Error(), nil)
+		return
+	}
+	if err := h.engine.CancelJobWithDetails(r.Context(), jobID, cancellation); err != nil {
 		handleEngineError(w, err)
 		return
 	}
@@ -307,45 +1010,42 @@ func (h *Handler) cancelJob(w http.ResponseWriter, r *http.Request, jobID string
 	writeJobResponse(w, http.StatusOK, job)
 }
 
-func (h *Handler) rerunJob(w http.ResponseWriter, r *http.Request, jobID string) {
-	defer r.Body.Close()
-	var payload rerunRequest
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil && !errors.Is(err, io.EOF) {
-		writeAPIError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("invalid payload: %v", err), nil)
+func (h *Handler) skipStep(w http.ResponseWriter, r *http.Request, jobID string, stepID engine.StepID) {
+	if err := h.engine.SkipStep(r.Context(), jobID, stepID); err != nil {
+		handleEngineError(w, err)
 		return
 	}
 
-	baseJob, err := h.engine.GetJob(r.Context(), jobID)
+	job, err := h.engine.GetJob(r.Context(), jobID)
 	if err != nil {
 		handleEngineError(w, err)
 		return
 	}
 
-	nextInput := baseJob.Input
-	if payload.OverrideInput != nil {
-		nextInput = *payload.OverrideInput
-	}
+	writeJobResponse(w, http.StatusAccepted, job)
+}
 
-	var parentID *string
-	if baseJob.ID != "" {
-		id := baseJob.ID
-		parentID = &id
+func (h *Handler) rerunJob(w http.ResponseWriter, r *http.Request, jobID string) {
+	defer r.Body.Close()
+	var payload rerunRequest
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil && !errors.Is(err, io.EOF) {
+		writePayloadError(w, err)
+		return
 	}
 
-	var fromStep *engine.StepID
-	if payload.FromStepID != nil {
-		step := *payload.FromStepID
-		fromStep = &step
+	baseJob, err := h.engine.GetJob(r.Context(), jobID)
+	if err != nil {
+		handleEngineError(w, err)
+		return
 	}
 
-	req := engine.JobRequest{
-		PipelineType:  baseJob.PipelineType,
-		Input:         nextInput,
-		Mode:          "rerun",
-		ParentJobID:   parentID,
-		FromStepID:    fromStep,
-		ReuseUpstream: payload.ReuseUpstream,
-	}
+	req := engine.NewRerunRequest(baseJob, engine.RerunOptions{
+		FromStepID:         payload.FromStepID,
+		ReuseUpstream:      payload.ReuseUpstream,
+		ReuseSteps:         payload.ReuseSteps,
+		OverrideInput:      payload.OverrideInput,
+		EphemeralProviders: payload.EphemeralProviders,
+	})
 
 	job, err := h.engine.RunJob(r.Context(), req)
 	if err != nil {
@@ -357,82 +1057,215 @@ func (h *Handler) rerunJob(w http.ResponseWriter, r *http.Request, jobID string)
 }
 
 func (h *Handler) streamExistingJob(w http.ResponseWriter, r *http.Request, jobID string) {
-	w.Header().Set("Content-Type", "application/x-ndjson")
-	enc := json.NewEncoder(w)
-	flusher, _ := w.(http.Flusher)
+	format, ok := parseStreamFormat(w, r)
+	if !ok {
+		return
+	}
+	out := newEventWriter(w, format)
+	defer out.Close()
+
+	ctx := r.Context()
+	afterSeq, stepFilter, err := h.streamResumePoint(r, jobID)
+	if err != nil {
+		h.writeStreamError(out, jobID, err)
+		return
+	}
+
+	h.evictEventLogs(time.Now())
+	if !h.hasEventLog(jobID) {
+		if _, err := h.engine.GetJob(ctx, jobID); err != nil {
+			h.writeStreamError(out, jobID, err)
+			return
+		}
+	}
+	h.startRecorder(jobID, func() { h.pollJobEvents(jobID) })
+	h.followEvents(ctx, out, jobID, afterSeq, eventFilters{stepFilter, h.finalItemsFilter(r, jobID)})
+}
 
+// streamResumePoint reads after_seq and after_step for streaming endpoints.
+// Only after_step needs the job, so only it can fail.
+func (h *Handler) streamResumePoint(r *http.Request, jobID string) (uint64, *stepStartFilter, error) {
 	var afterSeq uint64
 	if raw := r.URL.Query().Get("after_seq"); raw != "" {
 		if val, err := strconv.ParseUint(raw, 10, 64); err == nil {
 			afterSeq = val
 		}
 	}
+	raw := r.URL.Query().Get("after_step")
+	if raw == "" {
+		return afterSeq, nil, nil
+	}
+	job, err := h.engine.GetJob(r.Context(), jobID)
+	if err != nil {
+		return 0, nil, err
+	}
+	return afterSeq, newStepStartFilter(job, engine.StepID(raw)), nil
+}
 
-	ctx := r.Context()
-	ticker := time.NewTicker(250 * time.Millisecond)
-	defer ticker.Stop()
-
-	tracker := engine.NewStreamingTracker()
+// followEvents writes logged events after afterSeq and then waits for new ones
+// until stream_finished is written, the job's recorder has finished and the
+// log is drained, or the client goes away.
+func (h *Handler) followEvents(ctx context.Context, out eventWriter, jobID string, afterSeq uint64, filter eventFilter) {
 	lastSeq := afterSeq
-
 	for {
-		sent := false
-		if events := h.eventsAfter(jobID, lastSeq); len(events) > 0 {
-			for _, event := range events {
-				if event.Seq <= lastSeq {
-					continue
-				}
-				if err := enc.Encode(event); err != nil {
-					return
-				}
-				if flusher != nil {
-					flusher.Flush()
-				}
-				lastSeq = event.Seq
-				sent = true
-				if event.Event == "stream_finished" {
-					return
-				}
+		events, wake, live := h.eventsSince(jobID, lastSeq)
+		for _, event := range events {
+			lastSeq = event.Seq
+			if filter != nil && !filter.allow(event) {
+				continue
 			}
-		} else if !h.hasEventLog(jobID) {
-			job, err := h.engine.GetJob(ctx, jobID)
-			if err != nil {
-				h.writeStreamError(enc, flusher, jobID, err)
+			if err := out.Write(event); err != nil {
 				return
 			}
-
-			for _, event := range tracker.Diff(job) {
-				event = h.appendEvent(event)
-				if event.Seq <= lastSeq {
-					continue
-				}
-				if err := enc.Encode(event); err != nil {
-					return
-				}
-				if flusher != nil {
-					flusher.Flush()
-				}
-				lastSeq = event.Seq
-				sent = true
-				if event.Event == "stream_finished" {
-					return
-				}
+			if event.Event == "stream_finished" {
+				return
 			}
-
-			if isTerminal(job.Status) && !sent {
+		}
+		if len(events) == 0 && !live {
+			return
+		}
+		var heartbeat <-chan time.Time
+		if interval := h.currentHeartbeatInterval(); interval > 0 {
+			heartbeat = time.After(interval)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-wake:
+		case <-heartbeat:
+			if err := out.Heartbeat(jobID); err != nil {
 				return
 			}
 		}
+	}
+}
+
+// startRecorder runs record in the background unless the job already has a
+// recorder. Each job is recorded at most once, so every event, provider_chunk
+// included, gets a single Seq regardless of how many clients stream it.
+func (h *Handler) startRecorder(jobID string, record func()) {
+	h.eventMu.Lock()
+	if _, ok := h.recorders[jobID]; ok {
+		h.eventMu.Unlock()
+		return
+	}
+	h.recorders[jobID] = true
+	h.eventMu.Unlock()
+
+	go func() {
+		defer h.finishRecorder(jobID)
+		record()
+	}()
+}
 
-		if sent {
+func (h *Handler) finishRecorder(jobID string) {
+	h.eventMu.Lock()
+	defer h.eventMu.Unlock()
+	h.recorders[jobID] = false
+	h.eventFinished[jobID] = time.Now()
+	h.wakeLocked(jobID)
+}
+
+// evictEventLogs drops the logs of jobs whose recorder finished more than the
+// retention window ago, together with their sequence counters. It runs
+// whenever a stream or event log is requested and periodically from the
+// sweeper; a job whose log was evicted is recorded again from its current
+// state, starting over at Seq 1.
+func (h *Handler) evictEventLogs(now time.Time) {
+	h.eventMu.Lock()
+	defer h.eventMu.Unlock()
+	h.settingsMu.RLock()
+	retention := h.eventLogRetention
+	h.settingsMu.RUnlock()
+	for jobID, finished := range h.eventFinished {
+		if now.Sub(finished) < retention || h.recorders[jobID] {
 			continue
 		}
+		h.wakeLocked(jobID)
+		delete(h.eventSeq, jobID)
+		delete(h.eventLogs, jobID)
+		delete(h.recorders, jobID)
+		delete(h.eventFinished, jobID)
+	}
+}
 
-		select {
-		case <-ctx.Done():
+// StartEventLogSweeper evicts expired event logs every interval in the
+// background, so the logs of jobs nobody asks about again are freed too. It
+// is stopped by Close and does nothing when already running.
+func (h *Handler) StartEventLogSweeper(interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultEventLogSweepInterval
+	}
+	h.eventMu.Lock()
+	defer h.eventMu.Unlock()
+	if h.sweepStop != nil {
+		return
+	}
+	h.sweepStop = make(chan struct{})
+	h.sweepDone = make(chan struct{})
+	go func(stop <-chan struct{}, done chan<- struct{}) {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				h.evictEventLogs(now)
+			}
+		}
+	}(h.sweepStop, h.sweepDone)
+}
+
+// Close stops the event log sweeper. It is safe to call more than once and on
+// handlers without a sweeper.
+func (h *Handler) Close() {
+	h.closeOnce.Do(func() {
+		h.eventMu.Lock()
+		stop, done := h.sweepStop, h.sweepDone
+		h.eventMu.Unlock()
+		if stop == nil {
+			return
+		}
+		close(stop)
+		<-done
+	})
+}
+
+// recordEvents logs every event from an engine stream.
+func (h *Handler) recordEvents(jobID string, events <-chan engine.StreamingEvent) {
+	for event := range events {
+		h.recordEvent(jobID, event)
+	}
+}
+
+func (h *Handler) recordEvent(jobID string, event engine.StreamingEvent) {
+	if event.JobID == "" {
+		event.JobID = jobID
+	}
+	h.appendEvent(event)
+}
+
+// pollJobEvents logs events derived from job snapshots for jobs that were not
+// created through the streaming endpoint.
+func (h *Handler) pollJobEvents(jobID string) {
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+
+	ctx := context.Background()
+	tracker := engine.NewStreamingTracker()
+	for {
+		job, err := h.engine.GetJob(ctx, jobID)
+		if err != nil {
+			h.appendEvent(engine.StreamingEvent{Event: "error", JobID: jobID, Data: err.Error()})
+			return
+		}
+		h.appendEvents(tracker.Diff(job))
+		if isTerminal(job.Status) {
 			return
-		case <-ticker.C:
 		}
+		<-ticker.C
 	}
 }
 
@@ -446,11 +1279,37 @@ func isTerminal(status engine.JobStatus) bool {
 }
 
 func handleEngineError(w http.ResponseWriter, err error) {
+	status, payload := engineErrorPayload(err)
+	writeAPIError(w, status, payload.Code, payload.Message, payload.Details)
+}
+
+// engineErrorPayload maps an engine error to its HTTP status and API error,
+// for whole responses and for the per-item errors of batch requests alike.
+func engineErrorPayload(err error) (int, apiErrorPayload) {
+	payload := apiErrorPayload{Message: err.Error()}
+	var sourcesErr *engine.SourceRequirementError
 	switch {
-	case errors.Is(err, store.ErrJobNotFound):
-		writeAPIError(w, http.StatusNotFound, "not_found", err.Error(), nil)
+	case errors.As(err, &sourcesErr):
+		payload.Code, payload.Details = "invalid_input", sourcesErr.Details()
+		return http.StatusBadRequest, payload
+	case errors.Is(err, engine.ErrInvalidInput):
+		payload.Code = "invalid_input"
+		return http.StatusBadRequest, payload
+	case errors.Is(err, store.ErrJobNotFound), errors.Is(err, engine.ErrStepNotFound):
+		payload.Code = "not_found"
+		return http.StatusNotFound, payload
+	case errors.Is(err, engine.ErrPipelineNotFound):
+		payload.Code = "pipeline_not_found"
+		return http.StatusNotFound, payload
+	case errors.Is(err, engine.ErrStepNotRunning):
+		payload.Code = "step_not_running"
+		return http.StatusConflict, payload
+	case errors.Is(err, engine.ErrJobTerminal):
+		payload.Code = "job_terminal"
+		return http.StatusConflict, payload
 	default:
-		writeAPIError(w, http.StatusBadRequest, "invalid_request", err.Error(), nil)
+		payload.Code = "invalid_request"
+		return http.StatusBadRequest, payload
 	}
 }
 
@@ -463,18 +1322,194 @@ func writeAPIError(w http.ResponseWriter, status int, code, message string, deta
 	writeJSON(w, status, payload)
 }
 
-func (h *Handler) writeStreamError(enc *json.Encoder, flusher http.Flusher, jobID string, err error) {
+// eventFilter decides per client which logged events are written; the log
+// itself always keeps every event.
+type eventFilter interface {
+	allow(evt engine.StreamingEvent) bool
+}
+
+// eventFilters allows an event only when every filter allows it. Every
+// filter sees every event, so stateful filters stay in sync.
+type eventFilters []eventFilter
+
+func (fs eventFilters) allow(evt engine.StreamingEvent) bool {
+	allowed := true
+	for _, f := range fs {
+		if !f.allow(evt) {
+			allowed = false
+		}
+	}
+	return allowed
+}
+
+// finalItemFilter drops item_completed events of exported steps that feed
+// other steps, so clients passing final_only=true only see the pipeline's
+// final results. A nil filter allows every event.
+type finalItemFilter struct {
+	steps map[engine.StepID]bool
+}
+
+// finalItemsFilter reads final_only for streaming endpoints. It returns nil
+// when final_only is not set or the job's pipeline snapshot is unavailable.
+func (h *Handler) finalItemsFilter(r *http.Request, jobID string) *finalItemFilter {
+	if r.URL.Query().Get("final_only") != "true" {
+		return nil
+	}
+	pipeline, err := h.engine.JobPipeline(r.Context(), jobID)
+	if err != nil {
+		return nil
+	}
+	return &finalItemFilter{steps: engine.TerminalSteps(pipeline)}
+}
+
+func (f *finalItemFilter) allow(evt engine.StreamingEvent) bool {
+	if f == nil || evt.Event != "item_completed" {
+		return true
+	}
+	item, ok := evt.Data.(engine.ResultItem)
+	return !ok || f.steps[item.StepID]
+}
+
+// stepStartFilter drops events emitted before the given step started so a
+// client can resume a stream by step rather than by sequence number. The first
+// step_* event for the step (normally step_started; a job replayed without an
+// event log only reports each step's latest state) opens the filter. Terminal
+// job events are always delivered. A nil filter allows every event.
+type stepStartFilter struct {
+	stepID  engine.StepID
+	started bool
+}
+
+// newStepStartFilter returns nil when the step is not part of the job, which
+// replays the stream from the beginning.
+func newStepStartFilter(job *engine.Job, stepID engine.StepID) *stepStartFilter {
+	for _, exec := range job.StepExecutions {
+		if exec.StepID == stepID {
+			return &stepStartFilter{stepID: stepID}
+		}
+	}
+	return nil
+}
+
+func (f *stepStartFilter) allow(evt engine.StreamingEvent) bool {
+	if f == nil || f.started {
+		return true
+	}
+	switch evt.Event {
+	case "job_completed", "job_failed", "job_cancelled", "stream_finished", "error", "log_truncated":
+		return true
+	}
+	if exec, ok := evt.Data.(engine.StepExecution); ok && exec.StepID == f.stepID && strings.HasPrefix(evt.Event, "step_") {
+		f.started = true
+		return true
+	}
+	return false
+}
+
+func (h *Handler) writeStreamError(out eventWriter, jobID string, err error) {
 	evt := h.appendEvent(engine.StreamingEvent{Event: "error", JobID: jobID, Data: err.Error()})
-	_ = enc.Encode(evt)
-	if flusher != nil {
-		flusher.Flush()
+	_ = out.Write(evt)
+}
+
+const (
+	streamFormatNDJSON = "ndjson"
+	streamFormatArray  = "array"
+)
+
+// parseStreamFormat reads ?format= for streaming endpoints, writing a 400 and
+// returning false for unknown values. NDJSON is the default.
+func parseStreamFormat(w http.ResponseWriter, r *http.Request) (string, bool) {
+	switch format := r.URL.Query().Get("format"); format {
+	case "", streamFormatNDJSON:
+		return streamFormatNDJSON, true
+	case streamFormatArray:
+		return streamFormatArray, true
+	default:
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("unsupported stream format %q", format), nil)
+		return "", false
+	}
+}
+
+// eventWriter emits streaming events in the encoding requested by the client.
+type eventWriter interface {
+	Write(evt engine.StreamingEvent) error
+	// Heartbeat keeps an idle stream alive. Heartbeats carry no seq and are
+	// not recorded in the event log.
+	Heartbeat(jobID string) error
+	Close() error
+}
+
+func newEventWriter(w http.ResponseWriter, format string) eventWriter {
+	if format == streamFormatArray {
+		w.Header().Set("Content-Type", "application/json")
+		return &arrayEventWriter{w: w, events: []engine.StreamingEvent{}}
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	return &ndjsonEventWriter{enc: json.NewEncoder(w), flusher: flusher}
+}
+
+// ndjsonEventWriter writes one event per line and flushes immediately.
+type ndjsonEventWriter struct {
+	enc     *json.Encoder
+	flusher http.Flusher
+}
+
+func (n *ndjsonEventWriter) Write(evt engine.StreamingEvent) error {
+	if err := n.enc.Encode(evt); err != nil {
+		return err
+	}
+	if n.flusher != nil {
+		n.flusher.Flush()
 	}
+	return nil
+}
+
+func (n *ndjsonEventWriter) Heartbeat(jobID string) error {
+	return n.Write(engine.StreamingEvent{Event: "heartbeat", JobID: jobID})
+}
+
+func (n *ndjsonEventWriter) Close() error {
+	return nil
+}
+
+// arrayEventWriter buffers events and writes them as a single JSON array once
+// the stream ends.
+type arrayEventWriter struct {
+	w      http.ResponseWriter
+	events []engine.StreamingEvent
+}
+
+func (a *arrayEventWriter) Write(evt engine.StreamingEvent) error {
+	a.events = append(a.events, evt)
+	return nil
+}
+
+// Heartbeat is a no-op since nothing is sent until the array is complete.
+func (a *arrayEventWriter) Heartbeat(jobID string) error {
+	return nil
+}
+
+func (a *arrayEventWriter) Close() error {
+	return newJSONEncoder(a.w).Encode(a.events)
 }
 
 func writeJSON(w http.ResponseWriter, status int, v interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
-	_ = json.NewEncoder(w).Encode(v)
+	_ = newJSONEncoder(w).Encode(v)
+}
+
+// writePayloadError reports a request body that could not be decoded, using
+// 413 when it exceeded max_request_body_bytes.
+func writePayloadError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeAPIError(w, http.StatusRequestEntityTooLarge, "request_too_large",
+			fmt.Sprintf("request body exceeds %d bytes", tooLarge.Limit), nil)
+		return
+	}
+	writeAPIError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("invalid payload: %v", err), nil)
 }
 
 func writeNotFound(w http.ResponseWriter) {
@@ -487,39 +1522,96 @@ func (h *Handler) appendEvent(evt engine.StreamingEvent) engine.StreamingEvent {
 	}
 	h.eventMu.Lock()
 	defer h.eventMu.Unlock()
+	evt = h.appendEventLocked(evt)
+	h.wakeLocked(evt.JobID)
+	return evt
+}
+
+// appendEvents logs a batch atomically so readers never observe half of one
+// snapshot diff.
+func (h *Handler) appendEvents(events []engine.StreamingEvent) {
+	h.eventMu.Lock()
+	defer h.eventMu.Unlock()
+	for _, evt := range events {
+		if evt.JobID == "" {
+			continue
+		}
+		evt = h.appendEventLocked(evt)
+		h.wakeLocked(evt.JobID)
+	}
+}
+
+// appendEventLocked assigns the next Seq and logs evt. eventMu must be held.
+func (h *Handler) appendEventLocked(evt engine.StreamingEvent) engine.StreamingEvent {
 	seq := h.eventSeq[evt.JobID] + 1
 	evt.Seq = seq
 	h.eventSeq[evt.JobID] = seq
-	h.eventLogs[evt.JobID] = append(h.eventLogs[evt.JobID], evt)
+	log := append(h.eventLogs[evt.JobID], evt)
+	h.settingsMu.RLock()
+	limit := h.eventLogMax
+	h.settingsMu.RUnlock()
+	if limit > 0 && len(log) > limit {
+		// Reslicing drops the oldest events; the backing array is
+		// reallocated with only the retained events once append outgrows it.
+		log = log[len(log)-limit:]
+	}
+	h.eventLogs[evt.JobID] = log
 	return evt
 }
 
-func (h *Handler) eventsAfter(jobID string, afterSeq uint64) []engine.StreamingEvent {
-	h.eventMu.RLock()
-	defer h.eventMu.RUnlock()
-	events := h.eventLogs[jobID]
-	if len(events) == 0 {
-		return nil
+// wakeLocked notifies clients waiting on the job's log. eventMu must be held.
+func (h *Handler) wakeLocked(jobID string) {
+	if wake, ok := h.eventWake[jobID]; ok {
+		close(wake)
+		delete(h.eventWake, jobID)
 	}
-	result := make([]engine.StreamingEvent, 0, len(events))
-	for _, evt := range events {
+}
+
+// eventsSince returns events after afterSeq, a channel closed on the next
+// append, and whether a recorder is still adding events for the job. When
+// events after afterSeq were evicted, the result starts with a log_truncated
+// marker whose Seq precedes the oldest retained event. An afterSeq beyond a
+// finished log's last Seq comes from an evicted log; the marker then has Seq
+// 0 and the whole rebuilt log follows.
+func (h *Handler) eventsSince(jobID string, afterSeq uint64) ([]engine.StreamingEvent, <-chan struct{}, bool) {
+	h.eventMu.Lock()
+	defer h.eventMu.Unlock()
+	var result []engine.StreamingEvent
+	log := h.eventLogs[jobID]
+	if len(log) > 0 && afterSeq > h.eventSeq[jobID] && !h.recorders[jobID] {
+		// afterSeq was assigned by a log that has since been evicted; the
+		// rebuilt log numbers its events from 1 again, so send all of it.
+		result = append(result, engine.StreamingEvent{
+			Event: "log_truncated",
+			JobID: jobID,
+			Data:  logTruncatedData{AfterSeq: afterSeq, FirstSeq: log[0].Seq},
+		})
+		afterSeq = 0
+	} else if len(log) > 0 && afterSeq+1 < log[0].Seq {
+		result = append(result, engine.StreamingEvent{
+			Seq:   log[0].Seq - 1,
+			Event: "log_truncated",
+			JobID: jobID,
+			Data:  logTruncatedData{AfterSeq: afterSeq, FirstSeq: log[0].Seq},
+		})
+	}
+	for _, evt := range log {
 		if evt.Seq > afterSeq {
 			result = append(result, evt)
 		}
 	}
-	return result
+	wake, ok := h.eventWake[jobID]
+	if !ok {
+		wake = make(chan struct{})
+		h.eventWake[jobID] = wake
+	}
+	return result, wake, h.recorders[jobID]
 }
 
 func (h *Handler) hasEventLog(jobID string) bool {
 	h.eventMu.RLock()
 	defer h.eventMu.RUnlock()
-	if seq, ok := h.eventSeq[jobID]; ok && seq > 0 {
-		return true
-	}
-	if events := h.eventLogs[jobID]; len(events) > 0 {
-		return true
-	}
-	return false
+	return len(h.eventLogs[jobID]) > 0
 }
 
 func (h *Handler) lastLoggedEvent(jobID string) *engine.StreamingEvent {
@@ -533,6 +1625,20 @@ func (h *Handler) lastLoggedEvent(jobID string) *engine.StreamingEvent {
 	return &evt
 }
 
+func parseNonNegativeInt(raw string) (int, error) {
+	if raw == "" {
+		return 0, nil
+	}
+	val, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, err
+	}
+	if val < 0 {
+		return 0, errors.New("must not be negative")
+	}
+	return val, nil
+}
+
 func writeMethodNotAllowed(w http.ResponseWriter) {
 	writeAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed", nil)
 }
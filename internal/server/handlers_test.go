@@ -3,11 +3,15 @@ package server_test
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -42,6 +46,82 @@ func TestHandlerHealth(t *testing.T) {
 	}
 }
 
+func TestHandlerCapabilities(t *testing.T) {
+	t.Parallel()
+
+	mux := newTestMux(&stubEngine{})
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/capabilities", nil)
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assertStatus(t, resp.Code, http.StatusOK)
+
+	var payload struct {
+		Version string `json:"version"`
+		engine.Capabilities
+	}
+	decodeJSON(t, resp.Body.Bytes(), &payload)
+	if payload.Version != "test-version" {
+		t.Fatalf("version が想定外です: %+v", payload)
+	}
+	want := engine.SupportedCapabilities()
+	if !reflect.DeepEqual(payload.Capabilities, want) {
+		t.Fatalf("capabilities が想定外です: %+v", payload.Capabilities)
+	}
+	if !slices.Contains(payload.JobModes, engine.ModeDryRun) || !slices.Contains(payload.StepKinds, engine.StepKindRetrieve) || !slices.Contains(payload.Events, engine.EventLogTruncated) {
+		t.Fatalf("主要な項目が含まれていません: %+v", payload.Capabilities)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/capabilities", nil)
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assertStatus(t, resp.Code, http.StatusMethodNotAllowed)
+}
+
+func TestHandlerPrettyJSON(t *testing.T) {
+	t.Parallel()
+
+	mux := newTestMux(&stubEngine{})
+	get := func(target string) string {
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, target, nil))
+		assertStatus(t, resp.Code, http.StatusOK)
+		return resp.Body.String()
+	}
+
+	if body := get("/health"); strings.Count(body, "\n") != 1 {
+		t.Fatalf("既定のレスポンスは 1 行の JSON であるべきです: %q", body)
+	}
+	body := get("/health?pretty=true")
+	if !strings.Contains(body, "\n  \"status\": \"ok\"") {
+		t.Fatalf("pretty=true のレスポンスがインデントされていません: %q", body)
+	}
+	var payload map[string]interface{}
+	decodeJSON(t, []byte(body), &payload)
+
+	evCh := make(chan engine.StreamingEvent, 2)
+	evCh <- engine.StreamingEvent{Event: "job_queued", JobID: "job-pretty", Data: minimalJob("job-pretty")}
+	evCh <- engine.StreamingEvent{Event: "stream_finished", JobID: "job-pretty"}
+	close(evCh)
+	stream := newTestMux(&stubEngine{
+		runJobStreamFunc: func(ctx context.Context, req engine.JobRequest) (<-chan engine.StreamingEvent, *engine.Job, error) {
+			return evCh, minimalJob("job-pretty"), nil
+		},
+	})
+	req := httptest.NewRequest(http.MethodPost, "/v1/jobs?stream=true&pretty=true", bytes.NewBufferString(`{"pipeline_type":"demo","input":{"sources":[]}}`))
+	resp := httptest.NewRecorder()
+	stream.ServeHTTP(resp, req)
+	assertStatus(t, resp.Code, http.StatusOK)
+	lines := strings.Split(strings.TrimSpace(resp.Body.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("pretty=true でも NDJSON は 1 行 1 イベントであるべきです: %q", resp.Body.String())
+	}
+	for _, line := range lines {
+		var evt engine.StreamingEvent
+		decodeJSON(t, []byte(line), &evt)
+	}
+}
+
 func TestHandlerCreateJob(t *testing.T) {
 	t.Parallel()
 
@@ -79,10 +159,83 @@ func TestHandlerCreateJob(t *testing.T) {
 	}
 }
 
+func TestHandlerCreateJobBatch(t *testing.T) {
+	t.Parallel()
+
+	var received []engine.JobRequest
+	stub := &stubEngine{
+		runJobFunc: func(ctx context.Context, req engine.JobRequest) (*engine.Job, error) {
+			received = append(received, req)
+			if req.PipelineType == "" {
+				return nil, errors.New("pipeline_type is required")
+			}
+			if req.PipelineType == "missing" {
+				return nil, fmt.Errorf("%w: missing", engine.ErrPipelineNotFound)
+			}
+			job := minimalJob(fmt.Sprintf("job-%d", len(received)))
+			job.BatchID = req.BatchID
+			return job, nil
+		},
+	}
+	mux := newTestMux(stub)
+
+	body := bytes.NewBufferString(`[{"pipeline_type":"demo","input":{"sources":[]}},{"input":{"sources":[]}},{"pipeline_type":"missing","input":{"sources":[]}}]`)
+	req := httptest.NewRequest(http.MethodPost, "/v1/jobs/batch", body)
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+
+	assertStatus(t, resp.Code, http.StatusAccepted)
+
+	var payload struct {
+		BatchID string `json:"batch_id"`
+		Items   []struct {
+			Index int         `json:"index"`
+			Job   *engine.Job `json:"job"`
+			Error *struct {
+				Code string `json:"code"`
+			} `json:"error"`
+		} `json:"items"`
+		Created int `json:"created"`
+		Failed  int `json:"failed"`
+	}
+	decodeJSON(t, resp.Body.Bytes(), &payload)
+
+	if payload.BatchID == "" || payload.Created != 1 || payload.Failed != 2 || len(payload.Items) != 3 {
+		t.Fatalf("バッチレスポンスが想定外です: %+v", payload)
+	}
+	if payload.Items[0].Job == nil || payload.Items[0].Job.BatchID != payload.BatchID {
+		t.Fatalf("1 件目のジョブに batch_id が付与されていません: %+v", payload.Items[0])
+	}
+	if payload.Items[1].Index != 1 || payload.Items[1].Error == nil || payload.Items[1].Error.Code != "invalid_request" {
+		t.Fatalf("2 件目のエラーが想定外です: %+v", payload.Items[1])
+	}
+	// 単発の作成と同じく、エラーの種類ごとのコードを返す。
+	if payload.Items[2].Error == nil || payload.Items[2].Error.Code != "pipeline_not_found" {
+		t.Fatalf("3 件目のエラーが想定外です: %+v", payload.Items[2])
+	}
+	for _, r := range received {
+		if r.BatchID != payload.BatchID {
+			t.Fatalf("RunJob に batch_id が渡されていません: %+v", r)
+		}
+	}
+}
+
+func TestHandlerCreateJobBatchRejectsEmpty(t *testing.T) {
+	t.Parallel()
+
+	mux := newTestMux(&stubEngine{})
+	req := httptest.NewRequest(http.MethodPost, "/v1/jobs/batch", bytes.NewBufferString(`[]`))
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+
+	assertStatus(t, resp.Code, http.StatusBadRequest)
+}
+
 func TestHandlerCreateJobStream(t *testing.T) {
 	t.Parallel()
 
-	evCh := make(chan engine.StreamingEvent, 3)
+	evCh := make(chan engine.StreamingEvent, 4)
+	evCh <- engine.StreamingEvent{Event: "job_queued", JobID: "job-stream", Data: minimalJob("job-stream")}
 	evCh <- engine.StreamingEvent{Event: "job_status", JobID: "job-stream", Data: minimalJob("job-stream")}
 	evCh <- engine.StreamingEvent{Event: "job_completed", JobID: "job-stream", Data: minimalJob("job-stream")}
 	evCh <- engine.StreamingEvent{Event: "stream_finished", JobID: "job-stream", Data: minimalJob("job-stream")}
@@ -133,6 +286,147 @@ func TestHandlerCreateJobStream(t *testing.T) {
 	}
 }
 
+func TestHandlerCreateJobStreamArrayFormat(t *testing.T) {
+	t.Parallel()
+
+	evCh := make(chan engine.StreamingEvent, 3)
+	evCh <- engine.StreamingEvent{Event: "job_queued", JobID: "job-array", Data: minimalJob("job-array")}
+	evCh <- engine.StreamingEvent{Event: "job_completed", JobID: "job-array", Data: minimalJob("job-array")}
+	evCh <- engine.StreamingEvent{Event: "stream_finished", JobID: "job-array", Data: minimalJob("job-array")}
+	close(evCh)
+
+	stub := &stubEngine{
+		runJobStreamFunc: func(ctx context.Context, req engine.JobRequest) (<-chan engine.StreamingEvent, *engine.Job, error) {
+			return evCh, minimalJob("job-array"), nil
+		},
+	}
+	mux := newTestMux(stub)
+
+	body := bytes.NewBufferString(`{"pipeline_type":"demo","input":{"sources":[]}}`)
+	req := httptest.NewRequest(http.MethodPost, "/v1/jobs?stream=true&format=array", body)
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+
+	assertStatus(t, resp.Code, http.StatusOK)
+	if ct := resp.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("Content-Type が想定外です: %s", ct)
+	}
+
+	var events []engine.StreamingEvent
+	decodeJSON(t, resp.Body.Bytes(), &events)
+	if len(events) != 3 || events[0].Event != "job_queued" || events[2].Event != "stream_finished" {
+		t.Fatalf("配列形式のイベントが想定外です: %+v", events)
+	}
+}
+
+func TestHandlerCreateJobStreamCancelOnDisconnect(t *testing.T) {
+	t.Parallel()
+
+	for _, cancelOnDisconnect := range []bool{false, true} {
+		var streamCtx context.Context
+		stub := &stubEngine{
+			runtime: engine.RuntimeConfig{CancelOnDisconnect: cancelOnDisconnect},
+			runJobStreamFunc: func(ctx context.Context, req engine.JobRequest) (<-chan engine.StreamingEvent, *engine.Job, error) {
+				streamCtx = ctx
+				evCh := make(chan engine.StreamingEvent, 1)
+				evCh <- engine.StreamingEvent{Event: "stream_finished", JobID: "job-dc"}
+				close(evCh)
+				return evCh, minimalJob("job-dc"), nil
+			},
+		}
+		mux := newTestMux(stub)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		body := bytes.NewBufferString(`{"pipeline_type":"demo","input":{"sources":[]}}`)
+		req := httptest.NewRequest(http.MethodPost, "/v1/jobs?stream=true", body).WithContext(ctx)
+		mux.ServeHTTP(httptest.NewRecorder(), req)
+		cancel()
+
+		// 切断でキャンセルする設定のときだけ、リクエストのコンテキストがエンジンへ渡る。
+		if got := streamCtx.Err() != nil; got != cancelOnDisconnect {
+			t.Fatalf("cancel_on_disconnect=%v でエンジンのコンテキストの扱いが想定外です", cancelOnDisconnect)
+		}
+	}
+}
+
+func TestHandlerCreateJobStreamRejectsUnknownFormat(t *testing.T) {
+	t.Parallel()
+
+	mux := newTestMux(&stubEngine{})
+	body := bytes.NewBufferString(`{"pipeline_type":"demo","input":{"sources":[]}}`)
+	req := httptest.NewRequest(http.MethodPost, "/v1/jobs?stream=true&format=xml", body)
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+
+	assertStatus(t, resp.Code, http.StatusBadRequest)
+}
+
+func TestHandlerStreamReplaysChunksAfterDisconnect(t *testing.T) {
+	t.Parallel()
+
+	evCh := make(chan engine.StreamingEvent, 1)
+	evCh <- engine.StreamingEvent{Event: "job_queued", JobID: "job-chunks", Data: minimalJob("job-chunks")}
+	stub := &stubEngine{
+		runJobStreamFunc: func(ctx context.Context, req engine.JobRequest) (<-chan engine.StreamingEvent, *engine.Job, error) {
+			return evCh, minimalJob("job-chunks"), nil
+		},
+	}
+	mux := newTestMux(stub)
+
+	// 最初のクライアントは job_queued を受け取った直後に切断される。
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	body := bytes.NewBufferString(`{"pipeline_type":"demo","input":{"sources":[]}}`)
+	req := httptest.NewRequest(http.MethodPost, "/v1/jobs?stream=true", body).WithContext(ctx)
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	var first engine.StreamingEvent
+	decodeJSON(t, resp.Body.Bytes(), &first)
+	if first.Event != "job_queued" || first.Seq != 1 {
+		t.Fatalf("切断前のイベントが想定外です: %+v", first)
+	}
+
+	// 切断後に発生したチャンクもイベントログへ記録される。
+	for i := 0; i < 3; i++ {
+		evCh <- engine.StreamingEvent{Event: "provider_chunk", JobID: "job-chunks", Data: engine.StepChunk{StepID: "step-1", Index: i, Content: fmt.Sprintf("c%d", i)}}
+	}
+	evCh <- engine.StreamingEvent{Event: "stream_finished", JobID: "job-chunks"}
+	close(evCh)
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/jobs/job-chunks/stream?after_seq=2", nil)
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assertStatus(t, resp.Code, http.StatusOK)
+
+	var events []engine.StreamingEvent
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var evt engine.StreamingEvent
+		if err := dec.Decode(&evt); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			t.Fatalf("再接続ストリームの解析に失敗しました: %v", err)
+		}
+		events = append(events, evt)
+	}
+	if len(events) != 3 {
+		t.Fatalf("取りこぼしたイベントのみが再送されるはずです: %+v", events)
+	}
+	for i, evt := range events[:2] {
+		if evt.Event != "provider_chunk" || evt.Seq != uint64(i+3) {
+			t.Fatalf("チャンクの seq が連続していません: %+v", events)
+		}
+		chunk, _ := evt.Data.(engine.ChunkEvent)
+		if chunk.Content != fmt.Sprintf("c%d", i+1) {
+			t.Fatalf("チャンクの順序が不正です: %+v", evt)
+		}
+	}
+	if events[2].Event != "stream_finished" {
+		t.Fatalf("最後のイベントが stream_finished ではありません: %+v", events[2])
+	}
+}
+
 func TestHandlerStreamExistingJobAfterSeq(t *testing.T) {
 	t.Parallel()
 
@@ -172,13 +466,513 @@ func TestHandlerStreamExistingJobAfterSeq(t *testing.T) {
 		events = append(events, evt)
 	}
 
-	if len(events) == 0 {
-		t.Fatalf("再開後にイベントが取得できませんでした: %+v", events)
-	}
-	for _, evt := range events {
-		if evt.Seq <= 1 {
-			t.Fatalf("seq が after_seq 以下のイベントが含まれています: %+v", evt)
-		}
+	if len(events) == 0 {
+		t.Fatalf("再開後にイベントが取得できませんでした: %+v", events)
+	}
+	for _, evt := range events {
+		if evt.Seq <= 1 {
+			t.Fatalf("seq が after_seq 以下のイベントが含まれています: %+v", evt)
+		}
+	}
+}
+
+func TestHandlerGetJobEvents(t *testing.T) {
+	t.Parallel()
+
+	job := minimalJob("job-events")
+	job.Status = engine.JobStatusSucceeded
+	job.StepExecutions = []engine.StepExecution{{StepID: engine.StepID("step-1"), Status: engine.StepExecSuccess}}
+
+	stub := &stubEngine{
+		getJobFunc: func(ctx context.Context, jobID string) (*engine.Job, error) {
+			if jobID != "job-events" {
+				return nil, store.ErrJobNotFound
+			}
+			return job, nil
+		},
+	}
+	mux := newTestMux(stub)
+
+	fetch := func(path string) (int, []engine.StreamingEvent) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		var payload struct {
+			Events []engine.StreamingEvent `json:"events"`
+		}
+		if resp.Code == http.StatusOK {
+			if err := json.Unmarshal(resp.Body.Bytes(), &payload); err != nil {
+				t.Fatalf("イベント一覧の JSON 解析に失敗しました: %v", err)
+			}
+		}
+		return resp.Code, payload.Events
+	}
+
+	code, events := fetch("/v1/jobs/job-events/events")
+	if code != http.StatusOK || len(events) < 3 {
+		t.Fatalf("イベント一覧が取得できません: %d %+v", code, events)
+	}
+	for i, evt := range events {
+		if evt.Seq != uint64(i+1) {
+			t.Fatalf("seq が連番ではありません: %+v", events)
+		}
+	}
+	if last := events[len(events)-1]; last.Event != "stream_finished" {
+		t.Fatalf("最後のイベントが stream_finished ではありません: %+v", last)
+	}
+
+	code, tail := fetch("/v1/jobs/job-events/events?after_seq=2")
+	if code != http.StatusOK || len(tail) != len(events)-2 || tail[0].Seq != 3 {
+		t.Fatalf("after_seq 以降のイベントが不正です: %d %+v", code, tail)
+	}
+
+	if code, _ := fetch("/v1/jobs/job-events/events?after_seq=abc"); code != http.StatusBadRequest {
+		t.Fatalf("不正な after_seq のステータスコードが不正です: %d", code)
+	}
+	if code, _ := fetch("/v1/jobs/unknown/events"); code != http.StatusNotFound {
+		t.Fatalf("存在しないジョブのステータスコードが不正です: %d", code)
+	}
+}
+
+func TestHandlerEventLogIsBoundedAndEvicted(t *testing.T) {
+	t.Parallel()
+
+	evCh := make(chan engine.StreamingEvent, 32)
+	evCh <- engine.StreamingEvent{Event: "job_queued", JobID: "job-bounded", Data: minimalJob("job-bounded")}
+	for i := 0; i < 24; i++ {
+		evCh <- engine.StreamingEvent{Event: "provider_chunk", JobID: "job-bounded", Data: engine.StepChunk{StepID: "step-1", Index: i}}
+	}
+	evCh <- engine.StreamingEvent{Event: "job_completed", JobID: "job-bounded", Data: minimalJob("job-bounded")}
+	evCh <- engine.StreamingEvent{Event: "stream_finished", JobID: "job-bounded", Data: minimalJob("job-bounded")}
+	close(evCh)
+
+	finished := minimalJob("job-bounded")
+	finished.Status = engine.JobStatusSucceeded
+	stub := &stubEngine{
+		runJobStreamFunc: func(ctx context.Context, req engine.JobRequest) (<-chan engine.StreamingEvent, *engine.Job, error) {
+			job := minimalJob("job-bounded")
+			job.Status = engine.JobStatusQueued
+			return evCh, job, nil
+		},
+		getJobFunc: func(ctx context.Context, jobID string) (*engine.Job, error) {
+			return finished, nil
+		},
+		runtime: engine.RuntimeConfig{ProviderTimeout: 30 * time.Second},
+	}
+	mux := newTestMux(stub)
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+	fetchEvents := func(path string) []engine.StreamingEvent {
+		resp := serve(http.MethodGet, path, "")
+		assertStatus(t, resp.Code, http.StatusOK)
+		var payload struct {
+			Events []engine.StreamingEvent `json:"events"`
+		}
+		decodeJSON(t, resp.Body.Bytes(), &payload)
+		return payload.Events
+	}
+
+	assertStatus(t, serve(http.MethodPost, "/v1/config/engine", `{"event_log_max_events":10}`).Code, http.StatusOK)
+	assertStatus(t, serve(http.MethodPost, "/v1/jobs?stream=true", `{"pipeline_type":"demo","input":{"sources":[]}}`).Code, http.StatusOK)
+
+	// job_queued + 24 chunk + job_completed + stream_finished = 27 件のうち最新 10 件だけが残る。
+	events := fetchEvents("/v1/jobs/job-bounded/events")
+	if len(events) != 11 || events[0].Event != "log_truncated" || events[0].Seq != 17 || events[1].Seq != 18 || events[10].Seq != 27 {
+		t.Fatalf("リングバッファの内容が想定外です: %+v", events)
+	}
+	if data, _ := events[0].Data.(map[string]any); data["first_seq"] != float64(18) {
+		t.Fatalf("log_truncated の first_seq が不正です: %+v", events[0])
+	}
+	if tail := fetchEvents("/v1/jobs/job-bounded/events?after_seq=20"); len(tail) != 7 || tail[0].Seq != 21 {
+		t.Fatalf("保持範囲内の after_seq で log_truncated が返っています: %+v", tail)
+	}
+
+	// 保持期間 0 にすると終了済みのログは seq ごと破棄され、1 から再構築される。
+	// 破棄前の seq で再開したクライアントには log_truncated の後にログ全体を返す。
+	assertStatus(t, serve(http.MethodPost, "/v1/config/engine", `{"event_log_retention_ms":0}`).Code, http.StatusOK)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		events = fetchEvents("/v1/jobs/job-bounded/events?after_seq=20")
+		if len(events) > 0 && events[0].Event == "log_truncated" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("終了済みのイベントログが破棄されていません: %+v", events)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if events[0].Seq != 0 || len(events) < 2 || events[1].Seq != 1 {
+		t.Fatalf("再構築したログが先頭から返されていません: %+v", events)
+	}
+	if data, _ := events[0].Data.(map[string]any); data["after_seq"] != float64(20) || data["first_seq"] != float64(1) {
+		t.Fatalf("再構築時の log_truncated が不正です: %+v", events[0])
+	}
+}
+
+func TestHandlerStreamExistingJobAfterStep(t *testing.T) {
+	t.Parallel()
+
+	job := minimalJob("job-after-step")
+	job.Status = engine.JobStatusSucceeded
+	job.StepExecutions = []engine.StepExecution{
+		{StepID: engine.StepID("step-1"), Status: engine.StepExecSuccess},
+		{StepID: engine.StepID("step-2"), Status: engine.StepExecSuccess},
+	}
+	stub := &stubEngine{
+		getJobFunc: func(ctx context.Context, jobID string) (*engine.Job, error) {
+			return job, nil
+		},
+	}
+	mux := newTestMux(stub)
+
+	streamEvents := func(query string) []engine.StreamingEvent {
+		req := httptest.NewRequest(http.MethodGet, "/v1/jobs/job-after-step/stream?format=array&"+query, nil)
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		assertStatus(t, resp.Code, http.StatusOK)
+		var events []engine.StreamingEvent
+		decodeJSON(t, resp.Body.Bytes(), &events)
+		return events
+	}
+
+	all := streamEvents("")
+	events := streamEvents("after_step=step-2")
+	if len(events) == 0 || len(events) >= len(all) {
+		t.Fatalf("after_step で絞り込まれていません: all=%d filtered=%d", len(all), len(events))
+	}
+	if all[len(all)-1].Event != "stream_finished" {
+		t.Fatalf("stream_finished がストリームの末尾にありません: %+v", all)
+	}
+	if events[0].Event != "step_completed" {
+		t.Fatalf("最初のイベントが step-2 のイベントではありません: %+v", events[0])
+	}
+	raw, _ := json.Marshal(events[0].Data)
+	if !strings.Contains(string(raw), `"step-2"`) {
+		t.Fatalf("最初のイベントが step-2 のものではありません: %s", raw)
+	}
+	if last := events[len(events)-1]; last.Event != "stream_finished" {
+		t.Fatalf("終端イベントが含まれていません: %+v", last)
+	}
+
+	if got := streamEvents("after_step=unknown"); len(got) != len(all) {
+		t.Fatalf("未知のステップでは先頭から再生するべきです: all=%d got=%d", len(all), len(got))
+	}
+}
+
+func TestHandlerStreamExistingJobFinalOnly(t *testing.T) {
+	t.Parallel()
+
+	job := minimalJob("job-final-only")
+	job.Status = engine.JobStatusSucceeded
+	job.StepExecutions = []engine.StepExecution{
+		{StepID: engine.StepID("draft"), Status: engine.StepExecSuccess},
+		{StepID: engine.StepID("polish"), Status: engine.StepExecSuccess},
+	}
+	job.Result = &engine.JobResult{Items: []engine.ResultItem{
+		{ID: "item-draft", StepID: engine.StepID("draft")},
+		{ID: "item-polish", StepID: engine.StepID("polish")},
+	}}
+	stub := &stubEngine{
+		getJobFunc: func(ctx context.Context, jobID string) (*engine.Job, error) {
+			return job, nil
+		},
+		jobPipelineFunc: func(ctx context.Context, jobID string) (*engine.PipelineDef, error) {
+			return &engine.PipelineDef{Steps: []engine.StepDef{
+				{ID: engine.StepID("draft"), Export: true},
+				{ID: engine.StepID("polish"), Export: true, DependsOn: []engine.StepID{engine.StepID("draft")}},
+			}}, nil
+		},
+	}
+	mux := newTestMux(stub)
+
+	itemSteps := func(query string) []string {
+		req := httptest.NewRequest(http.MethodGet, "/v1/jobs/job-final-only/stream?format=array&"+query, nil)
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		assertStatus(t, resp.Code, http.StatusOK)
+		var events []engine.StreamingEvent
+		decodeJSON(t, resp.Body.Bytes(), &events)
+		var steps []string
+		for _, evt := range events {
+			if evt.Event == "item_completed" {
+				item := evt.Data.(engine.ItemEvent)
+				steps = append(steps, string(item.StepID))
+			}
+		}
+		if last := events[len(events)-1]; last.Event != "stream_finished" {
+			t.Fatalf("終端イベントが含まれていません: %+v", last)
+		}
+		return steps
+	}
+
+	if got := itemSteps(""); len(got) != 2 {
+		t.Fatalf("final_only なしでは全ステップの item_completed を配信するべきです: %v", got)
+	}
+	if got := itemSteps("final_only=true"); len(got) != 1 || got[0] != "polish" {
+		t.Fatalf("final_only=true で中間ステップの item_completed が除外されていません: %v", got)
+	}
+}
+
+func TestHandlerGetJobResultsFiltersByTag(t *testing.T) {
+	t.Parallel()
+
+	job := minimalJob("job-tags")
+	job.Status = engine.JobStatusSucceeded
+	job.Result = &engine.JobResult{Items: []engine.ResultItem{
+		{ID: "item-1", StepID: "summary", Tag: "summary"},
+		{ID: "item-2", StepID: "report", Tag: "report"},
+		{ID: "item-3", StepID: "report", Tag: "report"},
+	}}
+	stub := &stubEngine{
+		getJobFunc: func(ctx context.Context, jobID string) (*engine.Job, error) {
+			return job, nil
+		},
+	}
+	mux := newTestMux(stub)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/jobs/job-tags/results?tag=report", nil)
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+
+	assertStatus(t, resp.Code, http.StatusOK)
+	var payload struct {
+		JobID string              `json:"job_id"`
+		Tag   string              `json:"tag"`
+		Items []engine.ResultItem `json:"items"`
+	}
+	decodeJSON(t, resp.Body.Bytes(), &payload)
+	if payload.JobID != "job-tags" || payload.Tag != "report" {
+		t.Fatalf("results レスポンスのメタ情報が不正です: %+v", payload)
+	}
+	if len(payload.Items) != 2 || payload.Items[0].ID != "item-2" || payload.Items[1].ID != "item-3" {
+		t.Fatalf("tag でフィルタされていません: %+v", payload.Items)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/jobs/job-tags/results", nil)
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assertStatus(t, resp.Code, http.StatusOK)
+	decodeJSON(t, resp.Body.Bytes(), &payload)
+	if len(payload.Items) != 3 {
+		t.Fatalf("tag 未指定時は全件を返すはずです: %+v", payload.Items)
+	}
+}
+
+func TestHandlerGetJobResultsPagination(t *testing.T) {
+	t.Parallel()
+
+	job := minimalJob("job-pages")
+	items := make([]engine.ResultItem, 5)
+	for i := range items {
+		items[i] = engine.ResultItem{ID: fmt.Sprintf("item-%d", i)}
+	}
+	job.Result = &engine.JobResult{Items: items}
+	stub := &stubEngine{
+		getJobFunc: func(ctx context.Context, jobID string) (*engine.Job, error) {
+			return job, nil
+		},
+	}
+	mux := newTestMux(stub)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/jobs/job-pages/results?limit=2&offset=3", nil)
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+
+	assertStatus(t, resp.Code, http.StatusOK)
+	var payload struct {
+		Items  []engine.ResultItem `json:"items"`
+		Total  int                 `json:"total"`
+		Offset int                 `json:"offset"`
+	}
+	decodeJSON(t, resp.Body.Bytes(), &payload)
+	if payload.Total != 5 || payload.Offset != 3 || len(payload.Items) != 2 || payload.Items[0].ID != "item-3" {
+		t.Fatalf("ページングが正しくありません: %+v", payload)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/jobs/job-pages/results?limit=-1", nil)
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assertStatus(t, resp.Code, http.StatusBadRequest)
+}
+
+func TestHandlerGetJobResultItemRender(t *testing.T) {
+	t.Parallel()
+
+	png := []byte("\x89PNG\r\n\x1a\n0000")
+	job := minimalJob("job-render")
+	job.Result = &engine.JobResult{Items: []engine.ResultItem{
+		{ID: "md", ContentType: engine.ContentMarkdown, Data: map[string]any{
+			"text": "# Title\n\n**bold** `x` <script>alert(1)</script> <img src=x onerror=alert(1)>\n\n- [ok](https://example.com)\n- [bad](javascript:alert(1))",
+		}},
+		{ID: "img", ContentType: engine.ContentImage, Data: map[string]any{
+			"image_base64": base64.StdEncoding.EncodeToString(png),
+		}},
+		{ID: "svg", ContentType: engine.ContentImage, Data: map[string]any{
+			"image_base64": base64.StdEncoding.EncodeToString([]byte(`<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`)),
+			"mime_type":    "image/svg+xml",
+		}},
+		{ID: "json", ContentType: engine.ContentJSON, Data: map[string]any{"k": "v"}},
+		{ID: "bin", ContentType: engine.ContentBinary, Data: engine.BinaryResultData([]byte("%PDF-1.4"), "application/pdf")},
+	}}
+	stub := &stubEngine{
+		getJobFunc: func(ctx context.Context, jobID string) (*engine.Job, error) {
+			return job, nil
+		},
+	}
+	mux := newTestMux(stub)
+	get := func(path string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, path, nil))
+		return resp
+	}
+
+	resp := get("/v1/jobs/job-render/results/md")
+	assertStatus(t, resp.Code, http.StatusOK)
+	var item engine.ResultItem
+	decodeJSON(t, resp.Body.Bytes(), &item)
+	if item.ID != "md" || item.ContentType != engine.ContentMarkdown {
+		t.Fatalf("render 未指定時はアイテムを JSON で返すはずです: %+v", item)
+	}
+
+	resp = get("/v1/jobs/job-render/results/md?render=html")
+	assertStatus(t, resp.Code, http.StatusOK)
+	if ct := resp.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Fatalf("Content-Type が text/html ではありません: %s", ct)
+	}
+	body := resp.Body.String()
+	for _, want := range []string{"<h1>Title</h1>", "<strong>bold</strong>", `<a href="https://example.com" rel="nofollow">ok</a>`, "<code>x</code>"} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("HTML に %q が含まれていません: %s", want, body)
+		}
+	}
+	if strings.Contains(body, "<script") || strings.Contains(body, "onerror") || strings.Contains(body, "javascript:") {
+		t.Fatalf("HTML がサニタイズされていません: %s", body)
+	}
+
+	resp = get("/v1/jobs/job-render/results/img?render=html")
+	assertStatus(t, resp.Code, http.StatusOK)
+	if ct := resp.Header().Get("Content-Type"); ct != "image/png" {
+		t.Fatalf("画像の Content-Type が不正です: %s", ct)
+	}
+	if !bytes.Equal(resp.Body.Bytes(), png) {
+		t.Fatalf("画像データがデコードされていません: %q", resp.Body.Bytes())
+	}
+
+	resp = get("/v1/jobs/job-render/results/bin?render=html")
+	assertStatus(t, resp.Code, http.StatusOK)
+	if ct := resp.Header().Get("Content-Type"); ct != "application/pdf" || resp.Body.String() != "%PDF-1.4" {
+		t.Fatalf("バイナリアイテムがデコードされていません: %s %q", ct, resp.Body.String())
+	}
+	if cd := resp.Header().Get("Content-Disposition"); cd != `attachment; filename=bin` {
+		t.Fatalf("バイナリアイテムは添付ファイルとして返すはずです: %q", cd)
+	}
+	if resp.Header().Get("X-Content-Type-Options") != "nosniff" {
+		t.Fatalf("バイナリアイテムに nosniff が付いていません: %v", resp.Header())
+	}
+	if csp := resp.Header().Get("Content-Security-Policy"); !strings.Contains(csp, "default-src 'none'") || !strings.Contains(csp, "sandbox") {
+		t.Fatalf("バイナリアイテムの Content-Security-Policy が不正です: %q", csp)
+	}
+
+	if resp.Header().Get("X-Content-Type-Options") != "nosniff" {
+		t.Fatalf("画像に nosniff が付いていません: %v", resp.Header())
+	}
+	resp = get("/v1/jobs/job-render/results/svg?render=html")
+	assertStatus(t, resp.Code, http.StatusNotAcceptable)
+	if strings.Contains(resp.Body.String(), "<svg") {
+		t.Fatalf("SVG 画像は返さないはずです: %s", resp.Body.String())
+	}
+	assertStatus(t, get("/v1/jobs/job-render/results/json?render=html").Code, http.StatusNotAcceptable)
+	assertStatus(t, get("/v1/jobs/job-render/results/md?render=pdf").Code, http.StatusBadRequest)
+	assertStatus(t, get("/v1/jobs/job-render/results/missing").Code, http.StatusNotFound)
+}
+
+func TestHandlerGetJobExcludeResults(t *testing.T) {
+	t.Parallel()
+
+	job := minimalJob("job-light")
+	job.Result = &engine.JobResult{Items: []engine.ResultItem{{ID: "item-1"}}}
+	stub := &stubEngine{
+		getJobFunc: func(ctx context.Context, jobID string) (*engine.Job, error) {
+			return job, nil
+		},
+	}
+	mux := newTestMux(stub)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/jobs/job-light?include_results=false", nil)
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+
+	assertStatus(t, resp.Code, http.StatusOK)
+	var payload struct {
+		Job *engine.Job `json:"job"`
+	}
+	decodeJSON(t, resp.Body.Bytes(), &payload)
+	if payload.Job == nil || payload.Job.Result != nil {
+		t.Fatalf("include_results=false で結果が除外されていません: %+v", payload.Job)
+	}
+	if job.Result == nil {
+		t.Fatal("エンジンから取得したジョブが書き換えられています")
+	}
+}
+
+func TestHandlerGetJobIncludePipeline(t *testing.T) {
+	t.Parallel()
+
+	stub := &stubEngine{
+		getJobFunc: func(ctx context.Context, jobID string) (*engine.Job, error) {
+			return minimalJob(jobID), nil
+		},
+		jobPipelineFunc: func(ctx context.Context, jobID string) (*engine.PipelineDef, error) {
+			if jobID == "job-legacy" {
+				return nil, engine.ErrPipelineNotFound
+			}
+			return &engine.PipelineDef{Type: "demo", Version: "v1", Steps: []engine.StepDef{{ID: "step-1"}}}, nil
+		},
+	}
+	mux := newTestMux(stub)
+	get := func(target string) map[string]json.RawMessage {
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, target, nil))
+		assertStatus(t, resp.Code, http.StatusOK)
+		var payload map[string]json.RawMessage
+		decodeJSON(t, resp.Body.Bytes(), &payload)
+		return payload
+	}
+
+	if payload := get("/v1/jobs/job-1"); payload["pipeline"] != nil {
+		t.Fatalf("include_pipeline なしでパイプライン定義が含まれています: %s", payload["pipeline"])
+	}
+	var pipeline engine.PipelineDef
+	decodeJSON(t, get("/v1/jobs/job-1?include_pipeline=true")["pipeline"], &pipeline)
+	if pipeline.Version != "v1" || len(pipeline.Steps) != 1 {
+		t.Fatalf("スナップショットが返っていません: %+v", pipeline)
+	}
+	if payload := get("/v1/jobs/job-legacy?include_pipeline=true"); payload["job"] == nil || payload["pipeline"] != nil {
+		t.Fatalf("スナップショットのないジョブはパイプラインなしで返すべきです: %+v", payload)
+	}
+}
+
+func TestHandlerCancelJobRejectsUnknownSource(t *testing.T) {
+	t.Parallel()
+
+	stub := &stubEngine{
+		cancelJobFunc: func(ctx context.Context, jobID string, reason string) error {
+			t.Fatal("不正な by で CancelJob が呼び出されました")
+			return nil
+		},
+	}
+	mux := newTestMux(stub)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/jobs/job-55/cancel", strings.NewReader(`{"reason":"x","by":"robot"}`))
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("不正な by のステータスコードが不正です: %d", resp.Code)
 	}
 }
 
@@ -232,6 +1026,36 @@ func TestHandlerCancelJob(t *testing.T) {
 	}
 }
 
+func TestHandlerStrictCancelOfFinishedJob(t *testing.T) {
+	t.Parallel()
+
+	stub := &stubEngine{
+		cancelJobFunc: func(ctx context.Context, jobID string, reason string) error {
+			return fmt.Errorf("%w: job %s is succeeded", engine.ErrJobTerminal, jobID)
+		},
+	}
+	mux := newTestMux(stub)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/jobs/job-55/cancel", strings.NewReader(`{"reason":"x","strict":true}`))
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusConflict {
+		t.Fatalf("終了済みジョブの strict キャンセルは 409 のはずです: %d", resp.Code)
+	}
+	if !stub.cancellation.Strict {
+		t.Fatalf("strict フラグがエンジンに渡っていません: %+v", stub.cancellation)
+	}
+	var payload struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &payload); err != nil || payload.Error.Code != "job_terminal" {
+		t.Fatalf("エラーコードが想定外です: %s (%v)", resp.Body.String(), err)
+	}
+}
+
 func TestHandlerGetJobNotFound(t *testing.T) {
 	t.Parallel()
 
@@ -284,6 +1108,81 @@ func TestHandlerUpsertProviderProfile(t *testing.T) {
 	}
 }
 
+func TestHandlerCreateJobRequiredSources(t *testing.T) {
+	t.Parallel()
+	eng := engine.NewBasicEngine(store.NewMemoryStore())
+	eng.RegisterPipeline(engine.PipelineDef{
+		Type:            "summarize",
+		Version:         "v1",
+		Steps:           []engine.StepDef{{ID: "summary", Kind: engine.StepKindLLM, Export: true}},
+		RequiredSources: &engine.SourceRequirement{MinCount: 1, Kinds: []engine.SourceKind{engine.SourceKindNote, engine.SourceKindLog}},
+	})
+	mux := newTestMux(eng)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/jobs", strings.NewReader(`{"pipeline_type":"summarize","input":{"sources":[]}}`))
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assertStatus(t, resp.Code, http.StatusBadRequest)
+	var payload struct {
+		Error struct {
+			Code    string         `json:"code"`
+			Details map[string]any `json:"details"`
+		} `json:"error"`
+	}
+	decodeJSON(t, resp.Body.Bytes(), &payload)
+	if payload.Error.Code != "invalid_input" || payload.Error.Details["min_count"] != float64(1) || payload.Error.Details["count"] != float64(0) {
+		t.Fatalf("ソース不足のエラー内容が不正です: %+v", payload.Error)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/jobs", strings.NewReader(`{"pipeline_type":"summarize","input":{"sources":[{"kind":"log","content":"error"}]}}`))
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assertStatus(t, resp.Code, http.StatusAccepted)
+}
+
+func TestHandlerUpsertProviderProfileKind(t *testing.T) {
+	t.Parallel()
+	eng := engine.NewBasicEngine(store.NewMemoryStore())
+	mux := newTestMux(eng)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/config/providers", strings.NewReader(`{"id":"bad","kind":"anthropic"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assertStatus(t, resp.Code, http.StatusBadRequest)
+	var payload struct {
+		Error struct {
+			Code    string         `json:"code"`
+			Details map[string]any `json:"details"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		t.Fatalf("レスポンスのデコードに失敗しました: %v", err)
+	}
+	if payload.Error.Code != "invalid_request" || payload.Error.Details["kind"] != "anthropic" {
+		t.Fatalf("未対応 kind のエラー内容が不正です: %+v", payload.Error)
+	}
+	if kinds, ok := payload.Error.Details["supported_kinds"].([]any); !ok || len(kinds) == 0 {
+		t.Fatalf("supported_kinds が返っていません: %+v", payload.Error.Details)
+	}
+
+	var received engine.ProviderProfile
+	stub := &stubEngine{
+		upsertProfileFunc: func(p engine.ProviderProfile) error {
+			received = p
+			return nil
+		},
+	}
+	req = httptest.NewRequest(http.MethodPost, "/v1/config/providers", strings.NewReader(`{"id":"mixed","kind":" OpenAI "}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+	newTestMux(stub).ServeHTTP(resp, req)
+	assertStatus(t, resp.Code, http.StatusOK)
+	if received.Kind != engine.ProviderOpenAI {
+		t.Fatalf("kind が正規化されていません: %q", received.Kind)
+	}
+}
+
 func TestHandlerUpsertProviderProfileInvalidPayload(t *testing.T) {
 	t.Parallel()
 	stub := &stubEngine{}
@@ -306,13 +1205,247 @@ func TestHandlerUpdateEngineConfig(t *testing.T) {
 	resp := httptest.NewRecorder()
 	mux.ServeHTTP(resp, req)
 	assertStatus(t, resp.Code, http.StatusOK)
-	var payload map[string]string
+	var payload map[string]any
 	decodeJSON(t, resp.Body.Bytes(), &payload)
 	if payload["log_level"] != "debug" {
 		t.Fatalf("expected response log_level debug, got %+v", payload)
 	}
 }
 
+func TestHandlerJobLineage(t *testing.T) {
+	stor := store.NewMemoryStore()
+	eng := engine.NewBasicEngine(stor)
+	mux := newTestMux(eng)
+
+	withParent := func(id, parent string) *engine.Job {
+		job := minimalJob(id)
+		if parent != "" {
+			job.ParentJobID = &parent
+		}
+		if err := stor.CreateJob(context.Background(), job); err != nil {
+			t.Fatalf("ジョブの登録に失敗しました: %v", err)
+		}
+		return job
+	}
+	withParent("job-a", "")
+	withParent("job-b", "job-a")
+	withParent("job-c", "job-a")
+	withParent("job-d", "job-b")
+	withParent("job-x", "")
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/jobs?parent_job_id=job-a", nil)
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assertStatus(t, resp.Code, http.StatusOK)
+	var list struct {
+		Jobs []engine.Job `json:"jobs"`
+	}
+	decodeJSON(t, resp.Body.Bytes(), &list)
+	if len(list.Jobs) != 2 || list.Jobs[0].ID != "job-b" || list.Jobs[1].ID != "job-c" {
+		t.Fatalf("parent_job_id で絞り込まれていません: %+v", list.Jobs)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/jobs", nil)
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assertStatus(t, resp.Code, http.StatusOK)
+	decodeJSON(t, resp.Body.Bytes(), &list)
+	if len(list.Jobs) != 5 {
+		t.Fatalf("フィルタなしで全件が返っていません: %d", len(list.Jobs))
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/jobs/job-d/lineage", nil)
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assertStatus(t, resp.Code, http.StatusOK)
+	var lineage engine.JobLineage
+	decodeJSON(t, resp.Body.Bytes(), &lineage)
+	if lineage.Job == nil || lineage.Job.ID != "job-d" {
+		t.Fatalf("lineage の対象ジョブが想定外です: %+v", lineage.Job)
+	}
+	if len(lineage.Ancestors) != 2 || lineage.Ancestors[0].ID != "job-a" || lineage.Ancestors[1].ID != "job-b" {
+		t.Fatalf("祖先チェーンがルートから並んでいません: %+v", lineage.Ancestors)
+	}
+	if len(lineage.Children) != 0 {
+		t.Fatalf("子ジョブを持たないはずです: %+v", lineage.Children)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/jobs/job-a/lineage", nil)
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assertStatus(t, resp.Code, http.StatusOK)
+	lineage = engine.JobLineage{}
+	decodeJSON(t, resp.Body.Bytes(), &lineage)
+	if len(lineage.Ancestors) != 0 || len(lineage.Children) != 2 {
+		t.Fatalf("ルートジョブの lineage が想定外です: %+v", lineage)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/jobs/missing/lineage", nil)
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assertStatus(t, resp.Code, http.StatusNotFound)
+}
+
+func TestHandlerWaitJob(t *testing.T) {
+	stor := store.NewMemoryStore()
+	eng := engine.NewBasicEngineWithConfig(stor, &engine.EngineConfig{SimulatedLatency: 300 * time.Millisecond})
+	mux := newTestMux(eng)
+
+	job, err := eng.RunJob(context.Background(), engine.JobRequest{
+		PipelineType: "wait_pipeline",
+		Input:        engine.JobInput{Sources: []engine.Source{{Kind: engine.SourceKindNote, Label: "memo", Content: "待機"}}},
+	})
+	if err != nil {
+		t.Fatalf("ジョブの起動に失敗しました: %v", err)
+	}
+
+	wait := func(query string) engine.Job {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/v1/jobs/"+job.ID+"/wait"+query, nil)
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		assertStatus(t, resp.Code, http.StatusOK)
+		var payload struct {
+			Job engine.Job `json:"job"`
+		}
+		decodeJSON(t, resp.Body.Bytes(), &payload)
+		return payload.Job
+	}
+
+	start := time.Now()
+	if got := wait("?timeout=50ms"); got.Status == engine.JobStatusSucceeded {
+		t.Fatalf("タイムアウト時点ではジョブは終了していないはずです: %s", got.Status)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("タイムアウト後すぐに応答するはずです: %s", elapsed)
+	}
+	if got := wait("?timeout=10"); got.Status != engine.JobStatusSucceeded || got.Result == nil {
+		t.Fatalf("終了したジョブが返されるはずです: %s", got.Status)
+	}
+	// 終了済みのジョブは待たずに返る。
+	if got := wait(""); got.Status != engine.JobStatusSucceeded {
+		t.Fatalf("終了済みジョブの状態が想定外です: %s", got.Status)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/jobs/"+job.ID+"/wait?timeout=soon", nil)
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assertStatus(t, resp.Code, http.StatusBadRequest)
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/jobs/missing/wait", nil)
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assertStatus(t, resp.Code, http.StatusNotFound)
+}
+
+func TestHandlerJobDiff(t *testing.T) {
+	stor := store.NewMemoryStore()
+	mux := newTestMux(engine.NewBasicEngine(stor))
+
+	create := func(id, parent, text string) {
+		job := minimalJob(id)
+		if parent != "" {
+			job.ParentJobID = &parent
+		}
+		job.Status = engine.JobStatusSucceeded
+		job.Result = &engine.JobResult{Items: []engine.ResultItem{
+			{ID: id + "-1", Label: "final", StepID: "final", Kind: "final", ContentType: engine.ContentText, Data: text},
+		}}
+		if err := stor.CreateJob(context.Background(), job); err != nil {
+			t.Fatalf("ジョブの登録に失敗しました: %v", err)
+		}
+	}
+	create("job-a", "", "要約\n旧版")
+	create("job-b", "job-a", "要約\n新版")
+	create("job-x", "", "無関係")
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/jobs/job-b/diff", nil)
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assertStatus(t, resp.Code, http.StatusOK)
+	var diff engine.JobDiff
+	decodeJSON(t, resp.Body.Bytes(), &diff)
+	if diff.AgainstID != "job-a" || diff.Changed != 1 || len(diff.Items) != 1 {
+		t.Fatalf("against 省略時は親との差分になるはずです: %+v", diff)
+	}
+	if lines := diff.Items[0].Lines; len(lines) != 3 || lines[0].Op != engine.LineEqual || lines[1].Text != "旧版" || lines[2].Op != engine.LineInsert {
+		t.Fatalf("行差分が想定外です: %+v", lines)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/jobs/job-a/diff?against=job-b", nil)
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assertStatus(t, resp.Code, http.StatusOK)
+
+	for _, path := range []string{"/v1/jobs/job-b/diff?against=job-x", "/v1/jobs/job-a/diff"} {
+		req = httptest.NewRequest(http.MethodGet, path, nil)
+		resp = httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		assertStatus(t, resp.Code, http.StatusBadRequest)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/jobs/job-b/diff?against=missing", nil)
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assertStatus(t, resp.Code, http.StatusNotFound)
+}
+
+func TestHandlerUpdateEngineRuntimeConfig(t *testing.T) {
+	stub := &stubEngine{runtime: engine.RuntimeConfig{ProviderTimeout: 30 * time.Second}}
+	mux := newTestMux(stub)
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/config/engine", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := post(`{"provider_timeout_ms":5000,"max_concurrency":4,"heartbeat_interval_ms":1000,"max_request_body_bytes":2048,"cancel_on_disconnect":true}`)
+	assertStatus(t, resp.Code, http.StatusOK)
+	var payload struct {
+		ProviderTimeoutMS   int64 `json:"provider_timeout_ms"`
+		MaxConcurrency      int   `json:"max_concurrency"`
+		HeartbeatIntervalMS int64 `json:"heartbeat_interval_ms"`
+		MaxRequestBodyBytes int64 `json:"max_request_body_bytes"`
+	}
+	decodeJSON(t, resp.Body.Bytes(), &payload)
+	if payload.ProviderTimeoutMS != 5000 || payload.MaxConcurrency != 4 || payload.HeartbeatIntervalMS != 1000 || payload.MaxRequestBodyBytes != 2048 {
+		t.Fatalf("反映後の設定値が想定外です: %+v", payload)
+	}
+	if stub.runtime.ProviderTimeout != 5*time.Second || stub.runtime.MaxConcurrency != 4 || !stub.runtime.CancelOnDisconnect {
+		t.Fatalf("エンジンに設定が適用されていません: %+v", stub.runtime)
+	}
+
+	for _, body := range []string{
+		`{"provider_timeout_ms":0}`,
+		`{"max_concurrency":-1}`,
+		`{"heartbeat_interval_ms":5}`,
+		`{"max_request_body_bytes":10}`,
+		`{"max_concurrency":8,"heartbeat_interval_ms":-1}`,
+		`{"event_log_max_events":1}`,
+		`{"event_log_retention_ms":-1}`,
+	} {
+		assertStatus(t, post(body).Code, http.StatusBadRequest)
+	}
+	if stub.runtime.MaxConcurrency != 4 {
+		t.Fatalf("不正な値を含むリクエストで設定が変更されています: %+v", stub.runtime)
+	}
+
+	// 更新した max_request_body_bytes を超えるボディは 413 になる。
+	resp = post(`{"log_level":"info","padding":"` + strings.Repeat("x", 4096) + `"}`)
+	assertStatus(t, resp.Code, http.StatusRequestEntityTooLarge)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/config/engine", nil)
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assertStatus(t, resp.Code, http.StatusOK)
+	decodeJSON(t, resp.Body.Bytes(), &payload)
+	if payload.MaxConcurrency != 4 {
+		t.Fatalf("GET で現在の設定が返っていません: %+v", payload)
+	}
+}
+
 func TestHandlerUpdateEngineConfigRequiresValue(t *testing.T) {
 	stor := store.NewMemoryStore()
 	eng := engine.NewBasicEngine(stor)
@@ -326,6 +1459,43 @@ func TestHandlerUpdateEngineConfigRequiresValue(t *testing.T) {
 	}
 }
 
+func TestHandlerSkipStep(t *testing.T) {
+	t.Parallel()
+
+	var skipped engine.StepID
+	stub := &stubEngine{
+		skipStepFunc: func(ctx context.Context, jobID string, stepID engine.StepID) error {
+			if stepID == "idle" {
+				return fmt.Errorf("%w: %s", engine.ErrStepNotRunning, stepID)
+			}
+			skipped = stepID
+			return nil
+		},
+		getJobFunc: func(ctx context.Context, jobID string) (*engine.Job, error) {
+			return minimalJob(jobID), nil
+		},
+	}
+	mux := newTestMux(stub)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/jobs/job-1/steps/shard-2/skip", nil)
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assertStatus(t, resp.Code, http.StatusAccepted)
+	if skipped != "shard-2" {
+		t.Fatalf("SkipStep に渡された step_id が想定外です: %s", skipped)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/jobs/job-1/steps/idle/skip", nil)
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assertStatus(t, resp.Code, http.StatusConflict)
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/jobs/job-1/steps/shard-2/skip", nil)
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assertStatus(t, resp.Code, http.StatusMethodNotAllowed)
+}
+
 func TestHandlerRerunJob(t *testing.T) {
 	t.Parallel()
 
@@ -401,7 +1571,7 @@ func TestHandlerMethodNotAllowed(t *testing.T) {
 	t.Parallel()
 
 	mux := newTestMux(&stubEngine{})
-	req := httptest.NewRequest(http.MethodGet, "/v1/jobs", nil)
+	req := httptest.NewRequest(http.MethodDelete, "/v1/jobs", nil)
 	resp := httptest.NewRecorder()
 	mux.ServeHTTP(resp, req)
 
@@ -463,7 +1633,8 @@ func TestHandlePipelineList(t *testing.T) {
 func TestHandleMetrics(t *testing.T) {
 	t.Parallel()
 
-	metrics.ObserveProviderCall("openai", time.Millisecond, nil)
+	metrics.ObserveProviderModelCall("openai", "gpt-handler-test", time.Millisecond, nil)
+	metrics.ObserveJobOutcome("handler.metrics.v1", "succeeded", time.Millisecond)
 	mux := newTestMux(&stubEngine{})
 	req := httptest.NewRequest(http.MethodGet, "/v1/metrics", nil)
 	resp := httptest.NewRecorder()
@@ -475,15 +1646,28 @@ func TestHandleMetrics(t *testing.T) {
 	if counts := payload["provider_call_count"]; counts == nil || counts["openai"] == 0 {
 		t.Fatalf("metrics payload missing provider_call_count: %+v", payload)
 	}
+	if counts := payload["provider_model_call_count"]; counts == nil || counts["openai/gpt-handler-test"] == 0 {
+		t.Fatalf("metrics payload missing provider_model_call_count: %+v", payload)
+	}
+	if counts := payload["job_pipeline_count"]; counts == nil || counts["handler.metrics.v1/succeeded"] == 0 {
+		t.Fatalf("metrics payload missing job_pipeline_count: %+v", payload)
+	}
+	if hist := payload["job_duration_ms"]; hist == nil || hist["le_100"] == 0 || hist["count"] == 0 {
+		t.Fatalf("metrics payload missing job_duration_ms: %+v", payload)
+	}
 }
 
 type stubEngine struct {
 	runJobFunc        func(ctx context.Context, req engine.JobRequest) (*engine.Job, error)
 	runJobStreamFunc  func(ctx context.Context, req engine.JobRequest) (<-chan engine.StreamingEvent, *engine.Job, error)
 	cancelJobFunc     func(ctx context.Context, jobID string, reason string) error
+	cancellation      engine.Cancellation
 	getJobFunc        func(ctx context.Context, jobID string) (*engine.Job, error)
+	jobPipelineFunc   func(ctx context.Context, jobID string) (*engine.PipelineDef, error)
+	skipStepFunc      func(ctx context.Context, jobID string, stepID engine.StepID) error
 	upsertProfileFunc func(engine.ProviderProfile) error
 	pipelines         []engine.PipelineDef
+	runtime           engine.RuntimeConfig
 }
 
 func (s *stubEngine) RunJob(ctx context.Context, req engine.JobRequest) (*engine.Job, error) {
@@ -507,6 +1691,11 @@ func (s *stubEngine) CancelJob(ctx context.Context, jobID string, reason string)
 	return s.cancelJobFunc(ctx, jobID, reason)
 }
 
+func (s *stubEngine) CancelJobWithDetails(ctx context.Context, jobID string, cancellation engine.Cancellation) error {
+	s.cancellation = cancellation
+	return s.CancelJob(ctx, jobID, cancellation.Reason)
+}
+
 func (s *stubEngine) GetJob(ctx context.Context, jobID string) (*engine.Job, error) {
 	if s.getJobFunc == nil {
 		return nil, errors.New("getJob not implemented")
@@ -514,6 +1703,44 @@ func (s *stubEngine) GetJob(ctx context.Context, jobID string) (*engine.Job, err
 	return s.getJobFunc(ctx, jobID)
 }
 
+func (s *stubEngine) ListJobs(ctx context.Context, filter engine.JobFilter) ([]*engine.Job, error) {
+	return nil, errors.New("listJobs not implemented")
+}
+
+func (s *stubEngine) JobLineage(ctx context.Context, jobID string) (*engine.JobLineage, error) {
+	return nil, errors.New("jobLineage not implemented")
+}
+
+func (s *stubEngine) DiffJobs(ctx context.Context, jobID, againstID string) (*engine.JobDiff, error) {
+	return nil, errors.New("diffJobs not implemented")
+}
+
+func (s *stubEngine) JobPipeline(ctx context.Context, jobID string) (*engine.PipelineDef, error) {
+	if s.jobPipelineFunc == nil {
+		return nil, errors.New("jobPipeline not implemented")
+	}
+	return s.jobPipelineFunc(ctx, jobID)
+}
+
+func (s *stubEngine) SkipStep(ctx context.Context, jobID string, stepID engine.StepID) error {
+	if s.skipStepFunc == nil {
+		return errors.New("skipStep not implemented")
+	}
+	return s.skipStepFunc(ctx, jobID, stepID)
+}
+
+func (s *stubEngine) RuntimeConfig() engine.RuntimeConfig {
+	return s.runtime
+}
+
+func (s *stubEngine) UpdateRuntimeConfig(cfg engine.RuntimeConfig) (engine.RuntimeConfig, error) {
+	if err := cfg.Validate(); err != nil {
+		return s.runtime, err
+	}
+	s.runtime = cfg
+	return cfg, nil
+}
+
 func (s *stubEngine) UpsertProviderProfile(profile engine.ProviderProfile) error {
 	if s.upsertProfileFunc == nil {
 		return errors.New("upsert not implemented")
@@ -525,6 +1752,14 @@ func (s *stubEngine) ListPipelines() []engine.PipelineDef {
 	return s.pipelines
 }
 
+func (s *stubEngine) LintPipeline(def engine.PipelineDef) []engine.Finding {
+	return engine.Lint(def)
+}
+
+func (s *stubEngine) NewBatchID() string {
+	return "batch-stub"
+}
+
 func minimalJob(id string) *engine.Job {
 	now := time.Now().UTC()
 	return &engine.Job{
@@ -559,3 +1794,75 @@ func decodeJSON(t *testing.T, data []byte, v interface{}) {
 		t.Fatalf("JSON 解析に失敗しました: %v", err)
 	}
 }
+
+func TestHandlerCreateJobEphemeralProviders(t *testing.T) {
+	t.Parallel()
+	eng := engine.NewBasicEngine(store.NewMemoryStore())
+	eng.RegisterPipeline(engine.PipelineDef{
+		Type:    "summarize",
+		Version: "v1",
+		Steps:   []engine.StepDef{{ID: "summary", Kind: engine.StepKindLLM, Export: true}},
+	})
+	mux := newTestMux(eng)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/jobs", strings.NewReader(`{"pipeline_type":"summarize","input":{"sources":[{"kind":"note","content":"memo"}]},"ephemeral_providers":[{"id":"tenant","kind":"openai"}]}`))
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assertStatus(t, resp.Code, http.StatusBadRequest)
+	var payload struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	decodeJSON(t, resp.Body.Bytes(), &payload)
+	if payload.Error.Code != "invalid_input" {
+		t.Fatalf("API キーのない ephemeral プロファイルは invalid_input になるべきです: %+v", payload.Error)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/jobs", strings.NewReader(`{"pipeline_type":"summarize","mode":"sync","input":{"sources":[{"kind":"note","content":"memo"}]},"ephemeral_providers":[{"id":"tenant","kind":"openai","api_key":"sk-tenant-secret"}]}`))
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assertStatus(t, resp.Code, http.StatusAccepted)
+	if body := resp.Body.String(); strings.Contains(body, "sk-tenant-secret") || !strings.Contains(body, `"ephemeral_profiles":["tenant"]`) {
+		t.Fatalf("レスポンスに API キーが含まれるか、プロファイル ID が記録されていません: %s", body)
+	}
+}
+
+func TestHandlerPipelineLint(t *testing.T) {
+	t.Parallel()
+	eng := engine.NewBasicEngine(store.NewMemoryStore())
+	mux := newTestMux(eng)
+
+	body := `{"type":"lint.demo","steps":[{"id":"draft","kind":"llm","provider_profile_id":"missing","prompt":{"user":"{{.Previous.outline}}"}},{"id":"final","kind":"llm","depends_on":["draft"],"export":true}]}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/config/pipelines/lint", strings.NewReader(body))
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assertStatus(t, resp.Code, http.StatusOK)
+	var payload struct {
+		Findings []engine.Finding `json:"findings"`
+	}
+	decodeJSON(t, resp.Body.Bytes(), &payload)
+	codes := map[string]engine.StepID{}
+	for _, f := range payload.Findings {
+		codes[f.Code] = f.Step
+	}
+	if codes["unknown_previous_step"] != "draft" || codes["unknown_profile"] != "draft" {
+		t.Fatalf("lint の指摘が想定外です: %+v", payload.Findings)
+	}
+	if pipelines := eng.ListPipelines(); len(pipelines) != 0 {
+		t.Fatalf("lint でパイプラインが登録されています: %+v", pipelines)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/config/pipelines/lint", strings.NewReader(`{"type":"ok","steps":[{"id":"s","kind":"map","export":true}]}`))
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assertStatus(t, resp.Code, http.StatusOK)
+	if got := strings.TrimSpace(resp.Body.String()); got != `{"findings":[]}` {
+		t.Fatalf("指摘がない場合は空配列を返すべきです: %s", got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/config/pipelines/lint", nil)
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assertStatus(t, resp.Code, http.StatusMethodNotAllowed)
+}
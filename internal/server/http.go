@@ -17,6 +17,7 @@ type Server struct {
 	mux        *http.ServeMux
 	startedAt  time.Time
 	version    string
+	handler    *Handler
 	httpServer *http.Server
 }
 
@@ -26,7 +27,13 @@ func NewServer(e engine.Engine) *Server {
 	mux := http.NewServeMux()
 	handler := NewHandler(e, started, Version)
 	handler.Register(mux)
-	return &Server{engine: e, mux: mux, startedAt: started, version: Version}
+	return &Server{engine: e, mux: mux, startedAt: started, version: Version, handler: handler}
+}
+
+// SetAllowedOrigins lists the browser origins allowed to open job WebSockets
+// besides the server's own; see Handler.SetAllowedOrigins.
+func (s *Server) SetAllowedOrigins(origins ...string) {
+	s.handler.SetAllowedOrigins(origins...)
 }
 
 // ListenAndServe starts listening on the provided address.
@@ -36,6 +43,7 @@ func (s *Server) ListenAndServe(addr string) error {
 		Handler: s.mux,
 	}
 	s.httpServer = srv
+	s.handler.StartEventLogSweeper(defaultEventLogSweepInterval)
 	return srv.ListenAndServe()
 }
 
@@ -44,8 +52,10 @@ func (s *Server) Handler() http.Handler {
 	return s.mux
 }
 
-// Shutdown gracefully stops the underlying HTTP server.
+// Shutdown gracefully stops the underlying HTTP server and the event log
+// sweeper.
 func (s *Server) Shutdown(ctx context.Context) error {
+	s.handler.Close()
 	if s.httpServer == nil {
 		return nil
 	}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"net/http"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+
+	"github.com/example/pipeline-engine/internal/engine"
+)
+
+const (
+	renderRaw  = "raw"
+	renderHTML = "html"
+)
+
+// binaryContentSecurityPolicy is sent with binary items so that, even when a
+// browser opens one, it cannot load resources or run script.
+const binaryContentSecurityPolicy = "default-src 'none'; sandbox"
+
+// errNotRenderable reports result items that have no HTML representation.
+type errNotRenderable struct {
+	contentType engine.ContentType
+	reason      string
+}
+
+func (e errNotRenderable) Error() string {
+	return fmt.Sprintf("%s item cannot be rendered: %s", e.contentType, e.reason)
+}
+
+// renderResultItem converts a result item into a body and Content-Type for
+// render=html. Markdown is converted to sanitized HTML, text is wrapped in
+// <pre>, and image items are decoded from Data["image_base64"] and served only
+// when their sniffed type is PNG, JPEG, GIF or WebP. Binary items are served
+// as their decoded bytes; the handler sends them as attachments.
+func renderResultItem(item engine.ResultItem) ([]byte, string, error) {
+	data, _ := item.Data.(map[string]any)
+	switch item.ContentType {
+	case engine.ContentMarkdown:
+		text, _ := data["text"].(string)
+		out, err := renderMarkdown(text)
+		if err != nil {
+			return nil, "", errNotRenderable{contentType: item.ContentType, reason: err.Error()}
+		}
+		return []byte(out), "text/html; charset=utf-8", nil
+	case engine.ContentText:
+		text, _ := data["text"].(string)
+		return []byte("<pre>" + html.EscapeString(text) + "</pre>\n"), "text/html; charset=utf-8", nil
+	case engine.ContentImage:
+		encoded, _ := data["image_base64"].(string)
+		if encoded == "" {
+			return nil, "", errNotRenderable{contentType: item.ContentType, reason: "image_base64 is missing"}
+		}
+		body, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, "", errNotRenderable{contentType: item.ContentType, reason: "image_base64 is not valid base64"}
+		}
+		mimeType := http.DetectContentType(body)
+		if !renderableImageTypes[mimeType] {
+			return nil, "", errNotRenderable{contentType: item.ContentType, reason: fmt.Sprintf("image type %s is not allowed", mimeType)}
+		}
+		return body, mimeType, nil
+	case engine.ContentBinary:
+		body, mimeType, err := item.BinaryData()
+		if err != nil {
+			return nil, "", errNotRenderable{contentType: item.ContentType, reason: err.Error()}
+		}
+		return body, mimeType, nil
+	default:
+		return nil, "", errNotRenderable{contentType: item.ContentType, reason: "unsupported content type"}
+	}
+}
+
+// markdownRenderer converts CommonMark with GitHub extensions. Raw HTML in
+// the source is dropped rather than passed through.
+var markdownRenderer = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+// markdownPolicy sanitizes the rendered HTML: only user-generated-content
+// elements survive, links are limited to safe schemes and get rel="nofollow".
+var markdownPolicy = bluemonday.UGCPolicy()
+
+// renderMarkdown converts Markdown into sanitized HTML.
+func renderMarkdown(src string) (string, error) {
+	var buf bytes.Buffer
+	if err := markdownRenderer.Convert([]byte(src), &buf); err != nil {
+		return "", err
+	}
+	return markdownPolicy.Sanitize(buf.String()), nil
+}
+
+// renderableImageTypes are the image types served by render=html. Anything
+// else, SVG in particular, can carry script and is refused.
+var renderableImageTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
@@ -0,0 +1,275 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/example/pipeline-engine/internal/engine"
+)
+
+// defaultWebSocketRequestTimeout bounds how long /v1/jobs/ws waits for the
+// JobRequest after the handshake.
+const defaultWebSocketRequestTimeout = 30 * time.Second
+
+// wsWriteTimeout bounds each frame written to a client.
+const wsWriteTimeout = 10 * time.Second
+
+// wsControlMessage is a client message sent on a job WebSocket. The cancel
+// fields match POST /v1/jobs/{id}/cancel.
+type wsControlMessage struct {
+	Action string              `json:"action"`
+	Reason string              `json:"reason"`
+	By     engine.CancelSource `json:"by"`
+	Code   string              `json:"code"`
+}
+
+// SetAllowedOrigins lists the browser origins (such as
+// "https://app.example.com") that may open job WebSockets besides the
+// server's own origin. "*" allows any origin.
+func (h *Handler) SetAllowedOrigins(origins ...string) {
+	allowed := make([]string, 0, len(origins))
+	for _, origin := range origins {
+		if origin = strings.TrimRight(strings.TrimSpace(origin), "/"); origin != "" {
+			allowed = append(allowed, origin)
+		}
+	}
+	h.settingsMu.Lock()
+	defer h.settingsMu.Unlock()
+	h.allowedOrigins = allowed
+}
+
+// SetWebSocketRequestTimeout sets how long /v1/jobs/ws waits for the
+// JobRequest; non-positive values restore the default.
+func (h *Handler) SetWebSocketRequestTimeout(timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = defaultWebSocketRequestTimeout
+	}
+	h.settingsMu.Lock()
+	defer h.settingsMu.Unlock()
+	h.wsRequestTimeout = timeout
+}
+
+// originAllowed accepts requests without an Origin header, which browsers
+// always send on WebSocket handshakes, same-origin requests and the origins
+// set by SetAllowedOrigins. Everything else is refused so that other sites
+// cannot drive jobs through a visitor's browser.
+func (h *Handler) originAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && u.Host != "" && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	h.settingsMu.RLock()
+	defer h.settingsMu.RUnlock()
+	for _, allowed := range h.allowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// upgradeWebSocket performs the opening handshake and runs serve on the
+// connection, closing it when serve returns. Failures before the handshake
+// are answered with a JSON API error.
+func (h *Handler) upgradeWebSocket(w http.ResponseWriter, r *http.Request, serve func(conn *websocket.Conn)) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+	if !headerContainsToken(r.Header, "Connection", "upgrade") || !headerContainsToken(r.Header, "Upgrade", "websocket") || r.Header.Get("Sec-WebSocket-Key") == "" {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", "websocket upgrade required", nil)
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		writeAPIError(w, http.StatusUpgradeRequired, "invalid_request", "unsupported websocket version", nil)
+		return
+	}
+	if !h.originAllowed(r) {
+		writeAPIError(w, http.StatusForbidden, "origin_not_allowed", "websocket origin not allowed", map[string]any{"origin": r.Header.Get("Origin")})
+		return
+	}
+	hijacker := hijackableWriter(w)
+	if hijacker == nil {
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "websocket upgrade not supported by this connection", nil)
+		return
+	}
+	maxBytes := int(h.currentMaxBodyBytes())
+	websocket.Server{
+		// The origin was checked above; an empty Origin is allowed for
+		// non-browser clients, which the default check would refuse.
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler: func(conn *websocket.Conn) {
+			conn.MaxPayloadBytes = maxBytes
+			serve(conn)
+		},
+	}.ServeHTTP(hijacker, r)
+}
+
+// hijackableWriter unwraps w, such as a prettyResponseWriter, down to a
+// writer that can be hijacked, or returns nil.
+func hijackableWriter(w http.ResponseWriter) http.ResponseWriter {
+	for {
+		if _, ok := w.(http.Hijacker); ok {
+			return w
+		}
+		unwrapper, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return nil
+		}
+		w = unwrapper.Unwrap()
+	}
+}
+
+func headerContainsToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsEventWriter sends each streaming event as one JSON text frame.
+type wsEventWriter struct {
+	conn *websocket.Conn
+}
+
+func (ws *wsEventWriter) Write(evt engine.StreamingEvent) error {
+	payload, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+	_ = ws.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	return websocket.Message.Send(ws.conn, string(payload))
+}
+
+func (ws *wsEventWriter) Heartbeat(jobID string) error {
+	return ws.Write(engine.StreamingEvent{Event: "heartbeat", JobID: jobID})
+}
+
+func (ws *wsEventWriter) Close() error {
+	return ws.conn.Close()
+}
+
+// createJobWebSocket serves GET /v1/jobs/ws: the first client message is the
+// JobRequest, after which the socket behaves like streamJobWebSocket.
+func (h *Handler) createJobWebSocket(w http.ResponseWriter, r *http.Request) {
+	h.upgradeWebSocket(w, r, func(conn *websocket.Conn) {
+		h.serveCreateJobWebSocket(r, conn)
+	})
+}
+
+func (h *Handler) serveCreateJobWebSocket(r *http.Request, conn *websocket.Conn) {
+	out := &wsEventWriter{conn: conn}
+	defer out.Close()
+
+	// A client that never sends its JobRequest must not hold the socket.
+	_ = conn.SetReadDeadline(time.Now().Add(h.currentWebSocketRequestTimeout()))
+	var message []byte
+	if err := websocket.Message.Receive(conn, &message); err != nil {
+		return
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+	var req engine.JobRequest
+	if err := json.Unmarshal(message, &req); err != nil {
+		_ = out.Write(engine.StreamingEvent{Event: "error", Data: fmt.Sprintf("invalid payload: %v", err)})
+		return
+	}
+	events, job, err := h.engine.RunJobStream(context.WithoutCancel(r.Context()), req)
+	if err != nil {
+		_ = out.Write(engine.StreamingEvent{Event: "error", Data: err.Error()})
+		return
+	}
+
+	h.evictEventLogs(time.Now())
+	if event, ok := <-events; ok {
+		h.recordEvent(job.ID, event)
+	}
+	h.startRecorder(job.ID, func() { h.recordEvents(job.ID, events) })
+	h.serveWebSocket(r.Context(), conn, out, job.ID, 0, h.finalItemsFilter(r, job.ID))
+}
+
+// streamJobWebSocket serves GET /v1/jobs/{id}/ws, pushing the same events as
+// /v1/jobs/{id}/stream (after_seq and after_step included) as text frames.
+func (h *Handler) streamJobWebSocket(w http.ResponseWriter, r *http.Request, jobID string) {
+	afterSeq, stepFilter, err := h.streamResumePoint(r, jobID)
+	if err != nil {
+		handleEngineError(w, err)
+		return
+	}
+	h.evictEventLogs(time.Now())
+	if !h.hasEventLog(jobID) {
+		if _, err := h.engine.GetJob(r.Context(), jobID); err != nil {
+			handleEngineError(w, err)
+			return
+		}
+	}
+	h.upgradeWebSocket(w, r, func(conn *websocket.Conn) {
+		out := &wsEventWriter{conn: conn}
+		defer out.Close()
+
+		h.startRecorder(jobID, func() { h.pollJobEvents(jobID) })
+		h.serveWebSocket(r.Context(), conn, out, jobID, afterSeq, eventFilters{stepFilter, h.finalItemsFilter(r, jobID)})
+	})
+}
+
+func (h *Handler) currentWebSocketRequestTimeout() time.Duration {
+	h.settingsMu.RLock()
+	defer h.settingsMu.RUnlock()
+	return h.wsRequestTimeout
+}
+
+// serveWebSocket follows the job's events while reading control messages
+// from the client. It returns when the stream ends or the client goes away.
+func (h *Handler) serveWebSocket(ctx context.Context, conn *websocket.Conn, out *wsEventWriter, jobID string, afterSeq uint64, filter eventFilter) {
+	// A hijacked request's context is not cancelled when the client leaves.
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancel()
+	go func() {
+		defer cancel()
+		for {
+			var message []byte
+			if err := websocket.Message.Receive(conn, &message); err != nil {
+				return
+			}
+			h.handleWebSocketControl(ctx, out, jobID, message)
+		}
+	}()
+	h.followEvents(ctx, out, jobID, afterSeq, filter)
+}
+
+// handleWebSocketControl applies a client control message. Failures are sent
+// back as an error event on this socket only; they are not logged for replay.
+func (h *Handler) handleWebSocketControl(ctx context.Context, out *wsEventWriter, jobID string, message []byte) {
+	var control wsControlMessage
+	if err := json.Unmarshal(message, &control); err != nil {
+		_ = out.Write(engine.StreamingEvent{Event: "error", JobID: jobID, Data: fmt.Sprintf("invalid control message: %v", err)})
+		return
+	}
+	switch control.Action {
+	case "cancel":
+		cancellation := engine.Cancellation{By: control.By, Code: control.Code, Reason: control.Reason}
+		err := cancellation.Validate()
+		if err == nil {
+			err = h.engine.CancelJobWithDetails(ctx, jobID, cancellation)
+		}
+		if err != nil {
+			_ = out.Write(engine.StreamingEvent{Event: "error", JobID: jobID, Data: err.Error()})
+		}
+	default:
+		_ = out.Write(engine.StreamingEvent{Event: "error", JobID: jobID, Data: fmt.Sprintf("unsupported action %q", control.Action)})
+	}
+}
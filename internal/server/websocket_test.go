@@ -0,0 +1,244 @@
+package server_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/example/pipeline-engine/internal/engine"
+	"github.com/example/pipeline-engine/internal/server"
+)
+
+func TestHandlerJobWebSocket(t *testing.T) {
+	t.Parallel()
+
+	evCh := make(chan engine.StreamingEvent, 4)
+	evCh <- engine.StreamingEvent{Event: "job_queued", JobID: "job-ws", Data: minimalJob("job-ws")}
+	cancelled := make(chan string, 1)
+	stub := &stubEngine{
+		runJobStreamFunc: func(ctx context.Context, req engine.JobRequest) (<-chan engine.StreamingEvent, *engine.Job, error) {
+			if req.PipelineType != "demo" {
+				t.Errorf("最初のメッセージが JobRequest として解釈されていません: %+v", req)
+			}
+			return evCh, minimalJob("job-ws"), nil
+		},
+		cancelJobFunc: func(ctx context.Context, jobID string, reason string) error {
+			cancelled <- jobID + ":" + reason
+			return nil
+		},
+	}
+	srv := httptest.NewServer(newTestMux(stub))
+	defer srv.Close()
+
+	conn, br := dialWebSocket(t, srv, "/v1/jobs/ws")
+	defer conn.Close()
+	writeClientFrame(t, conn, `{"pipeline_type":"demo","input":{"sources":[]}}`)
+	if evt := readServerEvent(t, br); evt.Event != "job_queued" || evt.Seq != 1 {
+		t.Fatalf("最初のフレームが job_queued ではありません: %+v", evt)
+	}
+
+	writeClientFrame(t, conn, `{"action":"pause"}`)
+	if evt := readServerEvent(t, br); evt.Event != "error" || evt.Seq != 0 {
+		t.Fatalf("未知の action はログに残らない error を返すべきです: %+v", evt)
+	}
+
+	writeClientFrame(t, conn, `{"action":"cancel","reason":"ws"}`)
+	select {
+	case got := <-cancelled:
+		if got != "job-ws:ws" {
+			t.Fatalf("キャンセル対象が想定外です: %s", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("cancel メッセージでジョブがキャンセルされていません")
+	}
+	evCh <- engine.StreamingEvent{Event: "job_cancelled", JobID: "job-ws", Data: minimalJob("job-ws")}
+	evCh <- engine.StreamingEvent{Event: "stream_finished", JobID: "job-ws"}
+	close(evCh)
+	if evt := readServerEvent(t, br); evt.Event != "job_cancelled" || evt.Seq != 2 {
+		t.Fatalf("job_cancelled が届いていません: %+v", evt)
+	}
+	if evt := readServerEvent(t, br); evt.Event != "stream_finished" {
+		t.Fatalf("stream_finished が届いていません: %+v", evt)
+	}
+	if opcode, _ := readServerFrame(t, br); opcode != 0x8 {
+		t.Fatalf("ストリーム終了後は close フレームが届くべきです: opcode=%d", opcode)
+	}
+}
+
+func TestHandlerJobWebSocketRequiresUpgrade(t *testing.T) {
+	t.Parallel()
+
+	mux := newTestMux(&stubEngine{
+		getJobFunc: func(ctx context.Context, jobID string) (*engine.Job, error) {
+			return minimalJob(jobID), nil
+		},
+	})
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/v1/jobs/job-1/ws", nil))
+	assertStatus(t, resp.Code, http.StatusBadRequest)
+
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/v1/jobs/job-1/ws", nil))
+	assertStatus(t, resp.Code, http.StatusMethodNotAllowed)
+}
+
+func TestHandlerJobWebSocketOriginPolicy(t *testing.T) {
+	t.Parallel()
+
+	stub := &stubEngine{
+		getJobFunc: func(ctx context.Context, jobID string) (*engine.Job, error) {
+			return minimalJob(jobID), nil
+		},
+	}
+	mux := http.NewServeMux()
+	handler := server.NewHandler(stub, time.Unix(0, 0), "test-version")
+	handler.SetAllowedOrigins("https://app.example.com/")
+	handler.Register(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	resp := handshakeWebSocket(t, srv, "/v1/jobs/ws", "https://evil.example.com")
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("許可されていない Origin は拒否されるべきです: %d", resp.StatusCode)
+	}
+	var body struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Error.Code != "origin_not_allowed" {
+		t.Fatalf("エラーコードが想定外です: %v %+v", err, body)
+	}
+
+	for _, origin := range []string{"", srv.URL, "https://app.example.com"} {
+		if resp := handshakeWebSocket(t, srv, "/v1/jobs/job-1/ws", origin); resp.StatusCode != http.StatusSwitchingProtocols {
+			t.Fatalf("Origin %q は許可されるべきです: %d", origin, resp.StatusCode)
+		}
+	}
+}
+
+func TestHandlerJobWebSocketRequestTimeout(t *testing.T) {
+	t.Parallel()
+
+	mux := http.NewServeMux()
+	handler := server.NewHandler(&stubEngine{}, time.Unix(0, 0), "test-version")
+	handler.SetWebSocketRequestTimeout(50 * time.Millisecond)
+	handler.Register(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	conn, br := dialWebSocket(t, srv, "/v1/jobs/ws")
+	defer conn.Close()
+	// JobRequest を送らないクライアントは期限切れで切断される。
+	if opcode, _ := readServerFrame(t, br); opcode != 0x8 {
+		t.Fatalf("JobRequest が届かなければ close フレームで切断されるべきです: opcode=%d", opcode)
+	}
+}
+
+// handshakeWebSocket sends an opening handshake with the given Origin (none
+// when empty) and returns the server's response.
+func handshakeWebSocket(t *testing.T, srv *httptest.Server, path, origin string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+	if err != nil {
+		t.Fatalf("リクエストの作成に失敗しました: %v", err)
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("ハンドシェイクに失敗しました: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func dialWebSocket(t *testing.T, srv *httptest.Server, path string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatalf("接続に失敗しました: %v", err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	handshake := "GET " + path + " HTTP/1.1\r\n" +
+		"Host: " + strings.TrimPrefix(srv.URL, "http://") + "\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n"
+	if _, err := conn.Write([]byte(handshake)); err != nil {
+		t.Fatalf("ハンドシェイクの送信に失敗しました: %v", err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("ハンドシェイクの応答を読めません: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("WebSocket へのアップグレードに失敗しました: %d %v", resp.StatusCode, resp.Header)
+	}
+	return conn, br
+}
+
+func writeClientFrame(t *testing.T, conn net.Conn, text string) {
+	t.Helper()
+	mask := [4]byte{1, 2, 3, 4}
+	frame := []byte{0x81, 0x80 | byte(len(text))}
+	frame = append(frame, mask[:]...)
+	for i := 0; i < len(text); i++ {
+		frame = append(frame, text[i]^mask[i%4])
+	}
+	if _, err := conn.Write(frame); err != nil {
+		t.Fatalf("フレームの送信に失敗しました: %v", err)
+	}
+}
+
+func readServerFrame(t *testing.T, br *bufio.Reader) (byte, []byte) {
+	t.Helper()
+	var head [2]byte
+	if _, err := io.ReadFull(br, head[:]); err != nil {
+		t.Fatalf("フレームを読めません: %v", err)
+	}
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		_, _ = io.ReadFull(br, ext[:])
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		_, _ = io.ReadFull(br, ext[:])
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(br, payload); err != nil {
+		t.Fatalf("フレーム本体を読めません: %v", err)
+	}
+	return head[0] & 0x0F, payload
+}
+
+func readServerEvent(t *testing.T, br *bufio.Reader) engine.StreamingEvent {
+	t.Helper()
+	opcode, payload := readServerFrame(t, br)
+	if opcode != 0x1 {
+		t.Fatalf("テキストフレームではありません: opcode=%d", opcode)
+	}
+	var evt engine.StreamingEvent
+	if err := json.Unmarshal(payload, &evt); err != nil {
+		t.Fatalf("イベントの JSON 解析に失敗しました: %v", err)
+	}
+	return evt
+}
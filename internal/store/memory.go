@@ -1,7 +1,9 @@
 package store
 
 import (
+	"context"
 	"errors"
+	"sort"
 	"sync"
 
 	"github.com/example/pipeline-engine/internal/engine"
@@ -9,7 +11,7 @@ import (
 
 var (
 	// ErrJobExists indicates that a job with the same ID already exists.
-	ErrJobExists = errors.New("job already exists")
+	ErrJobExists = engine.ErrJobExists
 	// ErrJobNotFound indicates that the requested job does not exist.
 	ErrJobNotFound = errors.New("job not found")
 )
@@ -19,6 +21,7 @@ type MemoryStore struct {
 	mu           sync.RWMutex
 	jobs         map[string]*engine.Job
 	checkpoints  map[string]map[engine.StepID][]engine.ResultItem
+	snapshots    map[string]engine.PipelineDef
 }
 
 // NewMemoryStore initializes a new in-memory store.
@@ -26,11 +29,12 @@ func NewMemoryStore() *MemoryStore {
 	return &MemoryStore{
 		jobs:        map[string]*engine.Job{},
 		checkpoints: map[string]map[engine.StepID][]engine.ResultItem{},
+		snapshots:   map[string]engine.PipelineDef{},
 	}
 }
 
 // CreateJob stores a brand-new job.
-func (s *MemoryStore) CreateJob(job *engine.Job) error {
+func (s *MemoryStore) CreateJob(_ context.Context, job *engine.Job) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -43,7 +47,7 @@ func (s *MemoryStore) CreateJob(job *engine.Job) error {
 }
 
 // UpdateJob overwrites the stored job with the provided definition.
-func (s *MemoryStore) UpdateJob(job *engine.Job) error {
+func (s *MemoryStore) UpdateJob(_ context.Context, job *engine.Job) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -56,7 +60,7 @@ func (s *MemoryStore) UpdateJob(job *engine.Job) error {
 }
 
 // GetJob returns the job that matches the provided identifier.
-func (s *MemoryStore) GetJob(id string) (*engine.Job, error) {
+func (s *MemoryStore) GetJob(_ context.Context, id string) (*engine.Job, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -68,8 +72,9 @@ func (s *MemoryStore) GetJob(id string) (*engine.Job, error) {
 	return cloneJob(job), nil
 }
 
-// ListJobs returns all stored jobs.
-func (s *MemoryStore) ListJobs() ([]*engine.Job, error) {
+// ListJobs returns all stored jobs ordered by ID, which is chronological when
+// the engine is configured with a time-ordered IDGenerator.
+func (s *MemoryStore) ListJobs(_ context.Context) ([]*engine.Job, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -77,6 +82,37 @@ func (s *MemoryStore) ListJobs() ([]*engine.Job, error) {
 	for _, job := range s.jobs {
 		result = append(result, cloneJob(job))
 	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result, nil
+}
+
+// DeleteJob removes a job and its checkpoints.
+func (s *MemoryStore) DeleteJob(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.jobs[id]; !ok {
+		return ErrJobNotFound
+	}
+
+	delete(s.jobs, id)
+	delete(s.checkpoints, id)
+	delete(s.snapshots, id)
+	return nil
+}
+
+// QueryJobs returns jobs matching filter ordered by ID, like ListJobs.
+func (s *MemoryStore) QueryJobs(_ context.Context, filter engine.JobFilter) ([]*engine.Job, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]*engine.Job, 0)
+	for _, job := range s.jobs {
+		if filter.Matches(job) {
+			result = append(result, cloneJob(job))
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
 	return result, nil
 }
 
@@ -86,6 +122,11 @@ func cloneJob(job *engine.Job) *engine.Job {
 	}
 
 	copyJob := *job
+	if job.Input.Sources != nil {
+		sources := make([]engine.Source, len(job.Input.Sources))
+		copy(sources, job.Input.Sources)
+		copyJob.Input.Sources = sources
+	}
 	if job.StepExecutions != nil {
 		steps := make([]engine.StepExecution, len(job.StepExecutions))
 		copy(steps, job.StepExecutions)
@@ -105,6 +146,9 @@ func cloneJob(job *engine.Job) *engine.Job {
 
 // Ensure MemoryStore implements the JobStore interface.
 var _ engine.JobStore = (*MemoryStore)(nil)
+var _ engine.JobDeleter = (*MemoryStore)(nil)
+var _ engine.JobQuerier = (*MemoryStore)(nil)
+var _ engine.PipelineSnapshotStore = (*MemoryStore)(nil)
 
 // StepCheckpointStore exposes persistence operations for step checkpoints.
 type StepCheckpointStore interface {
@@ -145,6 +189,28 @@ func (s *MemoryStore) ClearCheckpoints(jobID string) {
 	delete(s.checkpoints, jobID)
 }
 
+// SavePipelineSnapshot records the pipeline definition a job was created with.
+func (s *MemoryStore) SavePipelineSnapshot(jobID string, def engine.PipelineDef) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snapshots[jobID] = def
+}
+
+// LoadPipelineSnapshot returns the pipeline definition recorded for the job.
+func (s *MemoryStore) LoadPipelineSnapshot(jobID string) (engine.PipelineDef, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	def, ok := s.snapshots[jobID]
+	return def, ok
+}
+
+// DeletePipelineSnapshot drops the recorded pipeline definition.
+func (s *MemoryStore) DeletePipelineSnapshot(jobID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.snapshots, jobID)
+}
+
 func cloneResultItems(items []engine.ResultItem) []engine.ResultItem {
 	if len(items) == 0 {
 		return nil
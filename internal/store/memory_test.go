@@ -1,6 +1,8 @@
 package store_test
 
 import (
+	"context"
+	"errors"
 	"testing"
 	"time"
 
@@ -14,11 +16,11 @@ func TestMemoryStore_CreateAndGetJob(t *testing.T) {
 	memoryStore := store.NewMemoryStore()
 	job := newTestJob("job-create")
 
-	if err := memoryStore.CreateJob(job); err != nil {
+	if err := memoryStore.CreateJob(context.Background(), job); err != nil {
 		t.Fatalf("CreateJob に失敗しました: %v", err)
 	}
 
-	retrieved, err := memoryStore.GetJob(job.ID)
+	retrieved, err := memoryStore.GetJob(context.Background(), job.ID)
 	if err != nil {
 		t.Fatalf("保存済みジョブの取得に失敗しました: %v", err)
 	}
@@ -34,7 +36,7 @@ func TestMemoryStore_CreateAndGetJob(t *testing.T) {
 	retrieved.StepExecutions[0].Status = engine.StepExecFailed
 	retrieved.Result.Items[0].Label = "changed"
 
-	reloaded, err := memoryStore.GetJob(job.ID)
+	reloaded, err := memoryStore.GetJob(context.Background(), job.ID)
 	if err != nil {
 		t.Fatalf("ジョブの再取得に失敗しました: %v", err)
 	}
@@ -52,17 +54,17 @@ func TestMemoryStore_UpdateJob(t *testing.T) {
 
 	memoryStore := store.NewMemoryStore()
 	job := newTestJob("job-update")
-	if err := memoryStore.CreateJob(job); err != nil {
+	if err := memoryStore.CreateJob(context.Background(), job); err != nil {
 		t.Fatalf("CreateJob に失敗しました: %v", err)
 	}
 
 	job.Status = engine.JobStatusRunning
 	job.StepExecutions[0].Status = engine.StepExecRunning
-	if err := memoryStore.UpdateJob(job); err != nil {
+	if err := memoryStore.UpdateJob(context.Background(), job); err != nil {
 		t.Fatalf("UpdateJob に失敗しました: %v", err)
 	}
 
-	updated, err := memoryStore.GetJob(job.ID)
+	updated, err := memoryStore.GetJob(context.Background(), job.ID)
 	if err != nil {
 		t.Fatalf("Update 後の取得に失敗しました: %v", err)
 	}
@@ -82,14 +84,14 @@ func TestMemoryStore_ListJobsReturnsCopies(t *testing.T) {
 	jobA := newTestJob("job-a")
 	jobB := newTestJob("job-b")
 
-	if err := memoryStore.CreateJob(jobA); err != nil {
+	if err := memoryStore.CreateJob(context.Background(), jobA); err != nil {
 		t.Fatalf("jobA の作成に失敗しました: %v", err)
 	}
-	if err := memoryStore.CreateJob(jobB); err != nil {
+	if err := memoryStore.CreateJob(context.Background(), jobB); err != nil {
 		t.Fatalf("jobB の作成に失敗しました: %v", err)
 	}
 
-	jobs, err := memoryStore.ListJobs()
+	jobs, err := memoryStore.ListJobs(context.Background())
 	if err != nil {
 		t.Fatalf("ListJobs の実行に失敗しました: %v", err)
 	}
@@ -97,12 +99,15 @@ func TestMemoryStore_ListJobsReturnsCopies(t *testing.T) {
 	if len(jobs) != 2 {
 		t.Fatalf("ジョブ数が想定外です: %d", len(jobs))
 	}
+	if jobs[0].ID != "job-a" || jobs[1].ID != "job-b" {
+		t.Fatalf("ListJobs が ID 順になっていません: %s, %s", jobs[0].ID, jobs[1].ID)
+	}
 
 	for _, j := range jobs {
 		j.Status = engine.JobStatusFailed
 	}
 
-	reloadedA, err := memoryStore.GetJob(jobA.ID)
+	reloadedA, err := memoryStore.GetJob(context.Background(), jobA.ID)
 	if err != nil {
 		t.Fatalf("jobA の再取得に失敗しました: %v", err)
 	}
@@ -142,6 +147,36 @@ func TestMemoryStore_Checkpoints(t *testing.T) {
 	}
 }
 
+func TestMemoryStore_DeleteJob(t *testing.T) {
+	t.Parallel()
+
+	memoryStore := store.NewMemoryStore()
+	if err := memoryStore.CreateJob(context.Background(), newTestJob("job-1")); err != nil {
+		t.Fatalf("CreateJob が失敗しました: %v", err)
+	}
+	memoryStore.SaveCheckpoint("job-1", engine.StepID("step-1"), []engine.ResultItem{{ID: "item-1"}})
+	memoryStore.SavePipelineSnapshot("job-1", engine.PipelineDef{Type: "demo", Version: "v1"})
+	if def, ok := memoryStore.LoadPipelineSnapshot("job-1"); !ok || def.Version != "v1" {
+		t.Fatalf("パイプラインのスナップショットが保存されていません: %+v", def)
+	}
+
+	if err := memoryStore.DeleteJob(context.Background(), "job-1"); err != nil {
+		t.Fatalf("DeleteJob が失敗しました: %v", err)
+	}
+	if _, err := memoryStore.GetJob(context.Background(), "job-1"); !errors.Is(err, store.ErrJobNotFound) {
+		t.Fatalf("削除後もジョブが取得できます: %v", err)
+	}
+	if cp := memoryStore.LoadCheckpoints("job-1"); cp != nil {
+		t.Fatalf("削除後も checkpoint が残っています: %+v", cp)
+	}
+	if _, ok := memoryStore.LoadPipelineSnapshot("job-1"); ok {
+		t.Fatal("削除後もパイプラインのスナップショットが残っています")
+	}
+	if err := memoryStore.DeleteJob(context.Background(), "job-1"); !errors.Is(err, store.ErrJobNotFound) {
+		t.Fatalf("存在しないジョブの削除で ErrJobNotFound が返りません: %v", err)
+	}
+}
+
 func newTestJob(id string) *engine.Job {
 	now := time.Now().UTC()
 	return &engine.Job{
@@ -0,0 +1,182 @@
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/example/pipeline-engine/internal/engine"
+)
+
+// FileProfileStore persists provider profiles as a JSON file. Literal API
+// keys are sealed with AES-GCM using a key derived from the configured
+// secret; "env:" references are stored as-is. Without a secret, literal keys
+// are not written at all and must be supplied again after a restart.
+type FileProfileStore struct {
+	mu   sync.Mutex
+	path string
+	aead cipher.AEAD
+}
+
+type storedProfile struct {
+	engine.ProviderProfile
+	// APIKeyEnc is base64(nonce || ciphertext) of the literal API key.
+	APIKeyEnc string `json:"api_key_enc,omitempty"`
+}
+
+// NewFileProfileStore returns a store backed by path. secret may be empty,
+// in which case only env references are persisted for API keys.
+func NewFileProfileStore(path, secret string) (*FileProfileStore, error) {
+	s := &FileProfileStore{path: path}
+	if secret == "" {
+		return s, nil
+	}
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	s.aead, err = cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// SaveProfile upserts the profile by ID and rewrites the file.
+func (s *FileProfileStore) SaveProfile(profile engine.ProviderProfile) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records, err := s.read()
+	if err != nil {
+		return err
+	}
+	record, err := s.seal(profile)
+	if err != nil {
+		return err
+	}
+	replaced := false
+	for i := range records {
+		if records[i].ID == profile.ID {
+			records[i] = record
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+	return s.write(records)
+}
+
+// LoadProfiles returns every stored profile with API keys decrypted. A key
+// that cannot be decrypted is left empty and reported in the returned error.
+func (s *FileProfileStore) LoadProfiles() ([]engine.ProviderProfile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	profiles := make([]engine.ProviderProfile, 0, len(records))
+	var errs []error
+	for _, record := range records {
+		profile := record.ProviderProfile
+		if record.APIKeyEnc != "" {
+			key, err := s.open(profile.ID, record.APIKeyEnc)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("profile %s: %w", profile.ID, err))
+			}
+			profile.APIKey = key
+		}
+		profiles = append(profiles, profile)
+	}
+	return profiles, errors.Join(errs...)
+}
+
+func (s *FileProfileStore) seal(profile engine.ProviderProfile) (storedProfile, error) {
+	record := storedProfile{ProviderProfile: profile}
+	if profile.APIKey == "" || strings.HasPrefix(profile.APIKey, engine.APIKeyEnvPrefix) {
+		return record, nil
+	}
+	record.APIKey = ""
+	if s.aead == nil {
+		return record, nil
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return storedProfile{}, err
+	}
+	sealed := s.aead.Seal(nonce, nonce, []byte(profile.APIKey), []byte(profile.ID))
+	record.APIKeyEnc = base64.StdEncoding.EncodeToString(sealed)
+	return record, nil
+}
+
+func (s *FileProfileStore) open(id engine.ProviderProfileID, encoded string) (string, error) {
+	if s.aead == nil {
+		return "", errors.New("api key is encrypted but no secret is configured")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < s.aead.NonceSize() {
+		return "", errors.New("malformed encrypted api key")
+	}
+	nonce, ciphertext := sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():]
+	plain, err := s.aead.Open(nil, nonce, ciphertext, []byte(id))
+	if err != nil {
+		return "", errors.New("cannot decrypt api key (wrong secret?)")
+	}
+	return string(plain), nil
+}
+
+func (s *FileProfileStore) read() ([]storedProfile, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var records []storedProfile
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", s.path, err)
+	}
+	return records, nil
+}
+
+// write replaces the file atomically so a crash never leaves it truncated.
+func (s *FileProfileStore) write(records []storedProfile) error {
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
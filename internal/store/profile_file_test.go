@@ -0,0 +1,82 @@
+package store_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/example/pipeline-engine/internal/engine"
+	"github.com/example/pipeline-engine/internal/store"
+)
+
+func TestFileProfileStore_RoundTrip(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "profiles.json")
+	profiles, err := store.NewFileProfileStore(path, "secret")
+	if err != nil {
+		t.Fatalf("ストアの作成に失敗しました: %v", err)
+	}
+	if loaded, err := profiles.LoadProfiles(); err != nil || len(loaded) != 0 {
+		t.Fatalf("ファイルが無い場合は空で返るべきです: %v %v", loaded, err)
+	}
+	if err := profiles.SaveProfile(engine.ProviderProfile{ID: "b", Kind: engine.ProviderOpenAI, APIKey: "sk-literal"}); err != nil {
+		t.Fatalf("SaveProfile に失敗しました: %v", err)
+	}
+	if err := profiles.SaveProfile(engine.ProviderProfile{ID: "a", Kind: engine.ProviderOpenAI, APIKey: "env:OPENAI_KEY"}); err != nil {
+		t.Fatalf("SaveProfile に失敗しました: %v", err)
+	}
+	if err := profiles.SaveProfile(engine.ProviderProfile{ID: "b", Kind: engine.ProviderOpenAI, APIKey: "sk-updated", DefaultModel: "gpt"}); err != nil {
+		t.Fatalf("SaveProfile（上書き）に失敗しました: %v", err)
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("保存ファイルを読めません: %v", err)
+	}
+	if strings.Contains(string(raw), "sk-updated") || strings.Contains(string(raw), "sk-literal") {
+		t.Fatalf("API キーが平文で保存されています: %s", raw)
+	}
+
+	reopened, _ := store.NewFileProfileStore(path, "secret")
+	loaded, err := reopened.LoadProfiles()
+	if err != nil {
+		t.Fatalf("LoadProfiles に失敗しました: %v", err)
+	}
+	if len(loaded) != 2 || loaded[0].ID != "a" || loaded[1].ID != "b" {
+		t.Fatalf("プロファイルが ID 順に 2 件復元されていません: %+v", loaded)
+	}
+	if loaded[0].APIKey != "env:OPENAI_KEY" {
+		t.Fatalf("環境変数参照はそのまま保存されるべきです: %q", loaded[0].APIKey)
+	}
+	if loaded[1].APIKey != "sk-updated" || loaded[1].DefaultModel != "gpt" {
+		t.Fatalf("上書きしたプロファイルが復元されていません: %+v", loaded[1])
+	}
+
+	wrong, _ := store.NewFileProfileStore(path, "other")
+	loaded, err = wrong.LoadProfiles()
+	if err == nil || len(loaded) != 2 || loaded[1].APIKey != "" {
+		t.Fatalf("異なる秘密鍵では復号できずエラーになるべきです: %+v %v", loaded, err)
+	}
+}
+
+func TestFileProfileStore_NoSecretSkipsLiteralKeys(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "profiles.json")
+	profiles, err := store.NewFileProfileStore(path, "")
+	if err != nil {
+		t.Fatalf("ストアの作成に失敗しました: %v", err)
+	}
+	if err := profiles.SaveProfile(engine.ProviderProfile{ID: "p", Kind: engine.ProviderOpenAI, APIKey: "sk-literal"}); err != nil {
+		t.Fatalf("SaveProfile に失敗しました: %v", err)
+	}
+	loaded, err := profiles.LoadProfiles()
+	if err != nil || len(loaded) != 1 {
+		t.Fatalf("プロファイルの復元に失敗しました: %+v %v", loaded, err)
+	}
+	if loaded[0].APIKey != "" {
+		t.Fatalf("秘密鍵が無い場合は API キーを保存すべきではありません: %q", loaded[0].APIKey)
+	}
+}
@@ -0,0 +1,176 @@
+// Package enginetest provides a scriptable StubProvider and an engine wired
+// with it, so pipelines can be tested without a live LLM.
+package enginetest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/example/pipeline-engine/internal/engine"
+	"github.com/example/pipeline-engine/internal/store"
+)
+
+const (
+	// StubKind is the provider kind NewEngine registers StubProvider under.
+	StubKind engine.ProviderKind = "stub"
+	// StubProfileID is the profile steps reference to call the StubProvider.
+	StubProfileID engine.ProviderProfileID = "stub"
+)
+
+// StubResponse is one canned reply of a StubProvider.
+type StubResponse struct {
+	// Output is the text returned to the step.
+	Output string
+	// Chunks are streamed before the call returns; empty streams nothing.
+	Chunks []string
+	// Metadata is merged into the result item's data.
+	Metadata map[string]any
+	// Err fails the call, e.g. with an *engine.ProviderAPIError to exercise
+	// fallbacks.
+	Err error
+	// Latency delays the reply; the call returns the context error if it is
+	// cancelled first.
+	Latency time.Duration
+}
+
+// StubProvider is an engine.Provider that replies with canned responses and
+// records every request it receives. Responses queued for a step are used in
+// order and the last one repeats; steps without queued responses get the
+// default response. A request carrying JobOptions.Seed instead gets the
+// queued response at index seed mod len(queued), without consuming it, so
+// seeded runs are reproducible.
+type StubProvider struct {
+	mu        sync.Mutex
+	responses map[engine.StepID][]StubResponse
+	fallback  *StubResponse
+	calls     []engine.ProviderRequest
+}
+
+// NewStubProvider returns a StubProvider that answers every step with
+// "stub output for step <id>".
+func NewStubProvider() *StubProvider {
+	return &StubProvider{responses: map[engine.StepID][]StubResponse{}}
+}
+
+// Respond queues responses for step and returns p for chaining.
+func (p *StubProvider) Respond(step engine.StepID, responses ...StubResponse) *StubProvider {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.responses[step] = append(p.responses[step], responses...)
+	return p
+}
+
+// SetDefault sets the response for steps without queued responses.
+func (p *StubProvider) SetDefault(resp StubResponse) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.fallback = &resp
+}
+
+// Calls returns the requests received so far, in call order.
+func (p *StubProvider) Calls() []engine.ProviderRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]engine.ProviderRequest(nil), p.calls...)
+}
+
+// CallsFor returns the requests received for step.
+func (p *StubProvider) CallsFor(step engine.StepID) []engine.ProviderRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var calls []engine.ProviderRequest
+	for _, call := range p.calls {
+		if call.Step.ID == step {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// Factory returns a ProviderFactory that always yields p, for use with
+// BasicEngine.RegisterProviderFactory or ProviderRegistry.RegisterFactory.
+func (p *StubProvider) Factory() engine.ProviderFactory {
+	return func(engine.ProviderProfile) engine.Provider { return p }
+}
+
+// Call implements engine.Provider.
+func (p *StubProvider) Call(ctx context.Context, req engine.ProviderRequest) (engine.ProviderResponse, error) {
+	resp := p.next(req)
+	if resp.Latency > 0 {
+		timer := time.NewTimer(resp.Latency)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return engine.ProviderResponse{}, ctx.Err()
+		case <-timer.C:
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return engine.ProviderResponse{}, err
+	}
+	out := engine.ProviderResponse{
+		Output:   resp.Output,
+		Metadata: resp.Metadata,
+		Meta:     &engine.ProviderMeta{Provider: req.Profile.Kind, Model: req.Profile.DefaultModel, Seed: requestSeed(req)},
+	}
+	for _, chunk := range resp.Chunks {
+		out.Chunks = append(out.Chunks, engine.ProviderChunk{Content: chunk})
+	}
+	if resp.Err != nil {
+		return out, resp.Err
+	}
+	return out, nil
+}
+
+func (p *StubProvider) next(req engine.ProviderRequest) StubResponse {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls = append(p.calls, req)
+	if queued := p.responses[req.Step.ID]; len(queued) > 0 {
+		if seed := requestSeed(req); seed != nil {
+			idx := *seed % int64(len(queued))
+			if idx < 0 {
+				idx += int64(len(queued))
+			}
+			return queued[idx]
+		}
+		resp := queued[0]
+		if len(queued) > 1 {
+			p.responses[req.Step.ID] = queued[1:]
+		}
+		return resp
+	}
+	if p.fallback != nil {
+		return *p.fallback
+	}
+	return StubResponse{Output: fmt.Sprintf("stub output for step %s", req.Step.ID)}
+}
+
+func requestSeed(req engine.ProviderRequest) *int64 {
+	if req.Input.Options == nil {
+		return nil
+	}
+	return req.Input.Options.Seed
+}
+
+// NewEngine returns a BasicEngine backed by a MemoryStore with provider
+// registered under StubKind and the StubProfileID profile. The engine is
+// closed when tb finishes.
+func NewEngine(tb testing.TB, provider *StubProvider) *engine.BasicEngine {
+	return NewEngineWithConfig(tb, provider, nil)
+}
+
+// NewEngineWithConfig is like NewEngine but passes cfg to the engine.
+func NewEngineWithConfig(tb testing.TB, provider *StubProvider, cfg *engine.EngineConfig) *engine.BasicEngine {
+	tb.Helper()
+	eng := engine.NewBasicEngineWithConfig(store.NewMemoryStore(), cfg)
+	tb.Cleanup(eng.Close)
+	eng.RegisterProviderFactory(StubKind, provider.Factory())
+	if err := eng.UpsertProviderProfile(engine.ProviderProfile{ID: StubProfileID, Kind: StubKind}); err != nil {
+		tb.Fatalf("register stub provider profile: %v", err)
+	}
+	return eng
+}
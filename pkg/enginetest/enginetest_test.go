@@ -0,0 +1,175 @@
+package enginetest_test
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/example/pipeline-engine/internal/engine"
+	"github.com/example/pipeline-engine/internal/store"
+	"github.com/example/pipeline-engine/pkg/enginetest"
+)
+
+func registerSummary(eng *engine.BasicEngine) {
+	eng.RegisterPipeline(engine.PipelineDef{
+		Type:    "summary",
+		Version: "v1",
+		Steps: []engine.StepDef{
+			{ID: "draft", Kind: engine.StepKindLLM, Mode: engine.StepModeSingle, ProviderProfileID: enginetest.StubProfileID},
+			{ID: "final", Kind: engine.StepKindLLM, Mode: engine.StepModeSingle, ProviderProfileID: enginetest.StubProfileID, DependsOn: []engine.StepID{"draft"}, Export: true},
+		},
+	})
+}
+
+func syncRequest() engine.JobRequest {
+	return engine.JobRequest{
+		PipelineType: "summary",
+		Mode:         "sync",
+		Input:        engine.JobInput{Sources: []engine.Source{{Kind: engine.SourceKindNote, Content: "memo"}}},
+	}
+}
+
+// stubChunkCount reads the stub provider's provider_chunk_count metric.
+func stubChunkCount() int64 {
+	if v, ok := expvar.Get("provider_chunk_count").(*expvar.Map).Get(string(enginetest.StubKind)).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func TestStubProviderCannedResponses(t *testing.T) {
+	stub := enginetest.NewStubProvider().
+		Respond("final", enginetest.StubResponse{Output: "要約です", Chunks: []string{"要約", "です"}, Metadata: map[string]any{"score": 1}})
+	eng := enginetest.NewEngine(t, stub)
+	registerSummary(eng)
+	chunksBefore := stubChunkCount()
+
+	job, err := eng.RunJob(context.Background(), syncRequest())
+	if err != nil {
+		t.Fatalf("ジョブ実行に失敗しました: %v", err)
+	}
+	if job.Status != engine.JobStatusSucceeded || job.Result == nil || len(job.Result.Items) != 1 {
+		t.Fatalf("ジョブが成功していません: %s %+v", job.Status, job.Error)
+	}
+	data, _ := job.Result.Items[0].Data.(map[string]any)
+	if data["text"] != "要約です" || data["score"] != 1 {
+		t.Fatalf("canned response が反映されていません: %+v", data)
+	}
+	if chunks := job.StepExecutions[1].Chunks; len(chunks) != 2 || chunks[1].Content != "です" {
+		t.Fatalf("chunk が記録されていません: %+v", chunks)
+	}
+	if job.StepExecutions[0].ChunkCount != 0 || job.StepExecutions[1].ChunkCount != 2 {
+		t.Fatalf("chunk_count が想定外です: %d %d", job.StepExecutions[0].ChunkCount, job.StepExecutions[1].ChunkCount)
+	}
+	if got := stubChunkCount() - chunksBefore; got != 2 {
+		t.Fatalf("provider_chunk_count の増分が想定外です: %d", got)
+	}
+	calls := stub.CallsFor("final")
+	if len(stub.Calls()) != 2 || len(calls) != 1 || calls[0].Profile.ID != enginetest.StubProfileID {
+		t.Fatalf("呼び出し記録が想定外です: %+v", stub.Calls())
+	}
+}
+
+func TestStubProviderErrorsAndLatency(t *testing.T) {
+	stub := enginetest.NewStubProvider().
+		Respond("draft", enginetest.StubResponse{Err: &engine.ProviderAPIError{Kind: enginetest.StubKind, StatusCode: http.StatusBadGateway, Err: errors.New("bad gateway")}})
+	eng := enginetest.NewEngine(t, stub)
+	registerSummary(eng)
+
+	job, err := eng.RunJob(context.Background(), syncRequest())
+	if err != nil {
+		t.Fatalf("ジョブ実行に失敗しました: %v", err)
+	}
+	if job.Status != engine.JobStatusFailed || job.Error == nil || job.Error.Code != "provider_api_error" {
+		t.Fatalf("provider_api_error で失敗するはずです: %s %+v", job.Status, job.Error)
+	}
+
+	slow := enginetest.NewStubProvider()
+	slow.SetDefault(enginetest.StubResponse{Output: "late", Latency: time.Second})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	resp, err := slow.Call(ctx, engine.ProviderRequest{Step: engine.StepDef{ID: "draft"}})
+	if !errors.Is(err, context.DeadlineExceeded) || resp.Output != "" {
+		t.Fatalf("latency 中のキャンセルが反映されていません: resp=%+v err=%v", resp, err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("キャンセル後も待ち続けています: %s", elapsed)
+	}
+}
+
+func ExampleStubProvider() {
+	stub := enginetest.NewStubProvider().
+		Respond("draft", enginetest.StubResponse{Output: "hello from the stub"})
+
+	eng := engine.NewBasicEngine(store.NewMemoryStore())
+	defer eng.Close()
+	eng.RegisterProviderFactory(enginetest.StubKind, stub.Factory())
+	_ = eng.UpsertProviderProfile(engine.ProviderProfile{ID: enginetest.StubProfileID, Kind: enginetest.StubKind})
+	eng.RegisterPipeline(engine.PipelineDef{
+		Type:    "hello",
+		Version: "v1",
+		Steps: []engine.StepDef{
+			{ID: "draft", Kind: engine.StepKindLLM, Mode: engine.StepModeSingle, ProviderProfileID: enginetest.StubProfileID, Export: true},
+		},
+	})
+
+	job, _ := eng.RunJob(context.Background(), engine.JobRequest{
+		PipelineType: "hello",
+		Mode:         "sync",
+		Input:        engine.JobInput{Sources: []engine.Source{{Kind: engine.SourceKindNote, Content: "hi"}}},
+	})
+	data := job.Result.Items[0].Data.(map[string]any)
+	fmt.Println(job.Status, data["text"], len(stub.Calls()))
+	// Output: succeeded hello from the stub 1
+}
+
+func TestStubProviderSeededResponses(t *testing.T) {
+	stub := enginetest.NewStubProvider().
+		Respond("final", enginetest.StubResponse{Output: "A"}, enginetest.StubResponse{Output: "B"}, enginetest.StubResponse{Output: "C"})
+	eng := enginetest.NewEngine(t, stub)
+	registerSummary(eng)
+
+	run := func(seed int64) *engine.Job {
+		req := syncRequest()
+		req.Seed = &seed
+		job, err := eng.RunJob(context.Background(), req)
+		if err != nil || job.Status != engine.JobStatusSucceeded {
+			t.Fatalf("ジョブ実行に失敗しました: %v %+v", err, job)
+		}
+		return job
+	}
+	output := func(job *engine.Job) any {
+		data, _ := job.Result.Items[0].Data.(map[string]any)
+		return data["text"]
+	}
+	first, second := run(4), run(4)
+	if output(first) != "B" || output(second) != "B" {
+		t.Fatalf("同じ seed では同じ応答が選ばれるべきです: %v %v", output(first), output(second))
+	}
+	if got := output(run(5)); got != "C" {
+		t.Fatalf("seed 5 では 3 件目の応答が選ばれるべきです: %v", got)
+	}
+	if opts := first.Input.Options; opts == nil || opts.Seed == nil || *opts.Seed != 4 {
+		t.Fatalf("seed がジョブに保存されていません: %+v", opts)
+	}
+	if meta := first.Result.Items[0].ProviderMeta; meta == nil || meta.Seed == nil || *meta.Seed != 4 || meta.SeedIgnored {
+		t.Fatalf("provider_meta に seed が記録されていません: %+v", meta)
+	}
+
+	rerunReq := engine.NewRerunRequest(first, engine.RerunOptions{
+		OverrideInput: &engine.JobInput{Sources: []engine.Source{{Kind: engine.SourceKindNote, Content: "差し替え"}}},
+	})
+	rerunReq.Mode = "sync"
+	rerun, err := eng.RunJob(context.Background(), rerunReq)
+	if err != nil {
+		t.Fatalf("リランに失敗しました: %v", err)
+	}
+	if output(rerun) != "B" {
+		t.Fatalf("リランは親ジョブの seed を再現するべきです: %v", output(rerun))
+	}
+}
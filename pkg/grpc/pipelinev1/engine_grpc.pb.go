@@ -0,0 +1,297 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: pipelineengine/v1/engine.proto
+
+package pipelinev1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	PipelineEngine_RunJob_FullMethodName       = "/pipelineengine.v1.PipelineEngine/RunJob"
+	PipelineEngine_RunJobStream_FullMethodName = "/pipelineengine.v1.PipelineEngine/RunJobStream"
+	PipelineEngine_GetJob_FullMethodName       = "/pipelineengine.v1.PipelineEngine/GetJob"
+	PipelineEngine_CancelJob_FullMethodName    = "/pipelineengine.v1.PipelineEngine/CancelJob"
+	PipelineEngine_RerunJob_FullMethodName     = "/pipelineengine.v1.PipelineEngine/RerunJob"
+)
+
+// PipelineEngineClient is the client API for PipelineEngine service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// PipelineEngine exposes the job API of the HTTP server over gRPC. Job
+// requests, jobs and event payloads travel as the JSON documents of the HTTP
+// API (engine.JobRequest, engine.Job and StreamingEvent.data), so both
+// transports share one schema and new engine fields need no proto change.
+type PipelineEngineClient interface {
+	// RunJob creates a job. Sync and dry_run jobs reply once they finished;
+	// cancelling the call cancels them.
+	RunJob(ctx context.Context, in *RunJobRequest, opts ...grpc.CallOption) (*JobReply, error)
+	// RunJobStream creates a job and streams its events, starting with
+	// job_queued and ending with stream_finished.
+	RunJobStream(ctx context.Context, in *RunJobRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[JobEvent], error)
+	GetJob(ctx context.Context, in *GetJobRequest, opts ...grpc.CallOption) (*JobReply, error)
+	CancelJob(ctx context.Context, in *CancelJobRequest, opts ...grpc.CallOption) (*JobReply, error)
+	// RerunJob starts a child job of job_id, like POST /v1/jobs/{id}/rerun.
+	RerunJob(ctx context.Context, in *RerunJobRequest, opts ...grpc.CallOption) (*JobReply, error)
+}
+
+type pipelineEngineClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPipelineEngineClient(cc grpc.ClientConnInterface) PipelineEngineClient {
+	return &pipelineEngineClient{cc}
+}
+
+func (c *pipelineEngineClient) RunJob(ctx context.Context, in *RunJobRequest, opts ...grpc.CallOption) (*JobReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(JobReply)
+	err := c.cc.Invoke(ctx, PipelineEngine_RunJob_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pipelineEngineClient) RunJobStream(ctx context.Context, in *RunJobRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[JobEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &PipelineEngine_ServiceDesc.Streams[0], PipelineEngine_RunJobStream_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[RunJobRequest, JobEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type PipelineEngine_RunJobStreamClient = grpc.ServerStreamingClient[JobEvent]
+
+func (c *pipelineEngineClient) GetJob(ctx context.Context, in *GetJobRequest, opts ...grpc.CallOption) (*JobReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(JobReply)
+	err := c.cc.Invoke(ctx, PipelineEngine_GetJob_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pipelineEngineClient) CancelJob(ctx context.Context, in *CancelJobRequest, opts ...grpc.CallOption) (*JobReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(JobReply)
+	err := c.cc.Invoke(ctx, PipelineEngine_CancelJob_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pipelineEngineClient) RerunJob(ctx context.Context, in *RerunJobRequest, opts ...grpc.CallOption) (*JobReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(JobReply)
+	err := c.cc.Invoke(ctx, PipelineEngine_RerunJob_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// PipelineEngineServer is the server API for PipelineEngine service.
+// All implementations must embed UnimplementedPipelineEngineServer
+// for forward compatibility.
+//
+// PipelineEngine exposes the job API of the HTTP server over gRPC. Job
+// requests, jobs and event payloads travel as the JSON documents of the HTTP
+// API (engine.JobRequest, engine.Job and StreamingEvent.data), so both
+// transports share one schema and new engine fields need no proto change.
+type PipelineEngineServer interface {
+	// RunJob creates a job. Sync and dry_run jobs reply once they finished;
+	// cancelling the call cancels them.
+	RunJob(context.Context, *RunJobRequest) (*JobReply, error)
+	// RunJobStream creates a job and streams its events, starting with
+	// job_queued and ending with stream_finished.
+	RunJobStream(*RunJobRequest, grpc.ServerStreamingServer[JobEvent]) error
+	GetJob(context.Context, *GetJobRequest) (*JobReply, error)
+	CancelJob(context.Context, *CancelJobRequest) (*JobReply, error)
+	// RerunJob starts a child job of job_id, like POST /v1/jobs/{id}/rerun.
+	RerunJob(context.Context, *RerunJobRequest) (*JobReply, error)
+	mustEmbedUnimplementedPipelineEngineServer()
+}
+
+// UnimplementedPipelineEngineServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedPipelineEngineServer struct{}
+
+func (UnimplementedPipelineEngineServer) RunJob(context.Context, *RunJobRequest) (*JobReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RunJob not implemented")
+}
+func (UnimplementedPipelineEngineServer) RunJobStream(*RunJobRequest, grpc.ServerStreamingServer[JobEvent]) error {
+	return status.Errorf(codes.Unimplemented, "method RunJobStream not implemented")
+}
+func (UnimplementedPipelineEngineServer) GetJob(context.Context, *GetJobRequest) (*JobReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetJob not implemented")
+}
+func (UnimplementedPipelineEngineServer) CancelJob(context.Context, *CancelJobRequest) (*JobReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CancelJob not implemented")
+}
+func (UnimplementedPipelineEngineServer) RerunJob(context.Context, *RerunJobRequest) (*JobReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RerunJob not implemented")
+}
+func (UnimplementedPipelineEngineServer) mustEmbedUnimplementedPipelineEngineServer() {}
+func (UnimplementedPipelineEngineServer) testEmbeddedByValue()                        {}
+
+// UnsafePipelineEngineServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PipelineEngineServer will
+// result in compilation errors.
+type UnsafePipelineEngineServer interface {
+	mustEmbedUnimplementedPipelineEngineServer()
+}
+
+func RegisterPipelineEngineServer(s grpc.ServiceRegistrar, srv PipelineEngineServer) {
+	// If the following call pancis, it indicates UnimplementedPipelineEngineServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&PipelineEngine_ServiceDesc, srv)
+}
+
+func _PipelineEngine_RunJob_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RunJobRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PipelineEngineServer).RunJob(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PipelineEngine_RunJob_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PipelineEngineServer).RunJob(ctx, req.(*RunJobRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PipelineEngine_RunJobStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(RunJobRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(PipelineEngineServer).RunJobStream(m, &grpc.GenericServerStream[RunJobRequest, JobEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type PipelineEngine_RunJobStreamServer = grpc.ServerStreamingServer[JobEvent]
+
+func _PipelineEngine_GetJob_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetJobRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PipelineEngineServer).GetJob(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PipelineEngine_GetJob_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PipelineEngineServer).GetJob(ctx, req.(*GetJobRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PipelineEngine_CancelJob_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CancelJobRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PipelineEngineServer).CancelJob(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PipelineEngine_CancelJob_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PipelineEngineServer).CancelJob(ctx, req.(*CancelJobRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PipelineEngine_RerunJob_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RerunJobRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PipelineEngineServer).RerunJob(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PipelineEngine_RerunJob_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PipelineEngineServer).RerunJob(ctx, req.(*RerunJobRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// PipelineEngine_ServiceDesc is the grpc.ServiceDesc for PipelineEngine service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var PipelineEngine_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "pipelineengine.v1.PipelineEngine",
+	HandlerType: (*PipelineEngineServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "RunJob",
+			Handler:    _PipelineEngine_RunJob_Handler,
+		},
+		{
+			MethodName: "GetJob",
+			Handler:    _PipelineEngine_GetJob_Handler,
+		},
+		{
+			MethodName: "CancelJob",
+			Handler:    _PipelineEngine_CancelJob_Handler,
+		},
+		{
+			MethodName: "RerunJob",
+			Handler:    _PipelineEngine_RerunJob_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "RunJobStream",
+			Handler:       _PipelineEngine_RunJobStream_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "pipelineengine/v1/engine.proto",
+}
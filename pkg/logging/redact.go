@@ -0,0 +1,127 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// RedactedValue replaces sensitive values in logged payloads.
+const RedactedValue = "[REDACTED]"
+
+var (
+	providerIO   atomic.Bool
+	redactMu     sync.RWMutex
+	redactFields = defaultRedactFields()
+	// alwaysRedact lists credential keys and header names that are masked
+	// whatever SetRedactFields is given.
+	alwaysRedact = map[string]bool{
+		"authorization":       true,
+		"proxy-authorization": true,
+		"api_key":             true,
+		"api-key":             true,
+		"apikey":              true,
+		"x-api-key":           true,
+	}
+)
+
+func defaultRedactFields() []string {
+	return []string{"token", "access_token", "refresh_token", "password", "secret"}
+}
+
+// SetProviderIO toggles logging of raw provider request/response bodies.
+// Bodies are only logged when this flag is set and the level is debug.
+func SetProviderIO(enabled bool) {
+	providerIO.Store(enabled)
+}
+
+// ProviderIOEnabled reports whether provider I/O should be logged.
+func ProviderIOEnabled() bool {
+	return providerIO.Load() && effectiveLevel() <= LevelDebug
+}
+
+// SetRedactFields replaces the configurable list of field names that are
+// redacted from logged payloads. Names match whole JSON keys and header names
+// case-insensitively, so "token" masks token but not max_tokens. Credentials
+// such as Authorization and api_key are always redacted regardless of this
+// list.
+func SetRedactFields(patterns []string) {
+	cleaned := make([]string, 0, len(patterns))
+	for _, p := range patterns {
+		if p = strings.ToLower(strings.TrimSpace(p)); p != "" {
+			cleaned = append(cleaned, p)
+		}
+	}
+	redactMu.Lock()
+	defer redactMu.Unlock()
+	redactFields = cleaned
+}
+
+func shouldRedact(key string) bool {
+	lower := strings.ToLower(key)
+	if alwaysRedact[lower] {
+		return true
+	}
+	redactMu.RLock()
+	defer redactMu.RUnlock()
+	for _, name := range redactFields {
+		if lower == name {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactHeaders flattens headers for logging, masking sensitive entries.
+func RedactHeaders(h http.Header) map[string]string {
+	result := make(map[string]string, len(h))
+	for key, values := range h {
+		if shouldRedact(key) {
+			result[key] = RedactedValue
+			continue
+		}
+		result[key] = strings.Join(values, ",")
+	}
+	return result
+}
+
+// RedactJSON returns body with sensitive fields masked. Non-JSON bodies are
+// never logged verbatim; only their size is reported.
+func RedactJSON(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	var decoded any
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		return fmt.Sprintf("<non-json body, %d bytes>", len(body))
+	}
+	out, err := json.Marshal(redactValue(decoded))
+	if err != nil {
+		return fmt.Sprintf("<unencodable body, %d bytes>", len(body))
+	}
+	return string(out)
+}
+
+func redactValue(v any) any {
+	switch val := v.(type) {
+	case map[string]any:
+		for key, inner := range val {
+			if shouldRedact(key) {
+				val[key] = RedactedValue
+				continue
+			}
+			val[key] = redactValue(inner)
+		}
+		return val
+	case []any:
+		for i, inner := range val {
+			val[i] = redactValue(inner)
+		}
+		return val
+	default:
+		return v
+	}
+}
@@ -0,0 +1,69 @@
+package logging
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestProviderIOEnabledRequiresDebug(t *testing.T) {
+	defer SetProviderIO(false)
+	SetProviderIO(true)
+	SetLevel(LevelInfo)
+	if ProviderIOEnabled() {
+		t.Fatal("provider io should stay disabled above debug level")
+	}
+	SetLevel(LevelDebug)
+	if !ProviderIOEnabled() {
+		t.Fatal("provider io should be enabled at debug level")
+	}
+	SetProviderIO(false)
+	if ProviderIOEnabled() {
+		t.Fatal("provider io should be disabled without the flag")
+	}
+}
+
+func TestRedactJSON(t *testing.T) {
+	defer SetRedactFields(defaultRedactFields())
+	SetRedactFields([]string{"User_Email"})
+	body := []byte(`{"model":"gpt","api_key":"sk-1","messages":[{"role":"user","user_email":"a@b.c"}]}`)
+	out := RedactJSON(body)
+	if strings.Contains(out, "sk-1") || strings.Contains(out, "a@b.c") {
+		t.Fatalf("sensitive values leaked: %s", out)
+	}
+	if !strings.Contains(out, `"model":"gpt"`) {
+		t.Fatalf("non-sensitive fields should be kept: %s", out)
+	}
+	if got := RedactJSON([]byte("Bearer sk-raw")); strings.Contains(got, "sk-raw") {
+		t.Fatalf("non-json body leaked: %s", got)
+	}
+}
+
+func TestRedactJSONMatchesWholeKeys(t *testing.T) {
+	defer SetRedactFields(defaultRedactFields())
+	SetRedactFields(defaultRedactFields())
+	body := []byte(`{"max_tokens":256,"Token":"t-1","access_token":"t-2","refresh_token":"t-3","password":"p","client":{"Secret":"s"},"prompt_tokens":12}`)
+	out := RedactJSON(body)
+	for _, leaked := range []string{"t-1", "t-2", "t-3", `"p"`, `"s"`} {
+		if strings.Contains(out, leaked) {
+			t.Fatalf("sensitive value %s leaked: %s", leaked, out)
+		}
+	}
+	if !strings.Contains(out, `"max_tokens":256`) || !strings.Contains(out, `"prompt_tokens":12`) {
+		t.Fatalf("keys that merely contain a redacted name should be kept: %s", out)
+	}
+}
+
+func TestRedactHeaders(t *testing.T) {
+	h := http.Header{}
+	h.Set("Authorization", "Bearer sk-1")
+	h.Set("X-Api-Key", "sk-2")
+	h.Set("Content-Type", "application/json")
+	out := RedactHeaders(h)
+	if out["Authorization"] != RedactedValue || out["X-Api-Key"] != RedactedValue {
+		t.Fatalf("credential headers not redacted: %+v", out)
+	}
+	if out["Content-Type"] != "application/json" {
+		t.Fatalf("unexpected content type: %+v", out)
+	}
+}
@@ -1,6 +1,7 @@
 package adapter
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -34,6 +35,7 @@ type EngineClient interface {
 	StreamExistingJob(ctx context.Context, jobID string, afterSeq uint64) (<-chan engine.StreamingEvent, error)
 	ListPipelines(ctx context.Context) ([]engine.PipelineDef, error)
 	GetMetrics(ctx context.Context) (map[string]map[string]int64, error)
+	GetJobResults(ctx context.Context, jobID string, query gosdk.ResultsQuery) (*gosdk.ResultsPage, error)
 }
 
 // SDKClient adapts the Go SDK client to EngineClient.
@@ -85,6 +87,10 @@ func (c *SDKClient) GetMetrics(ctx context.Context) (map[string]map[string]int64
 	return c.client.GetMetrics(ctx)
 }
 
+func (c *SDKClient) GetJobResults(ctx context.Context, jobID string, query gosdk.ResultsQuery) (*gosdk.ResultsPage, error) {
+	return c.client.GetJobResults(ctx, jobID, query)
+}
+
 // Tool describes a MCP tool entry returned from tools/list.
 type Tool struct {
 	Name        string                 `json:"name"`
@@ -112,6 +118,11 @@ type Adapter struct {
 	enc    *json.Encoder
 	dec    *json.Decoder
 	mu     sync.Mutex
+	// knownJobs lists job IDs seen through tool calls, oldest first.
+	knownJobs []string
+	// inflight holds cancel funcs of running streamJob calls keyed by request ID.
+	inflight map[string]context.CancelFunc
+	wg       sync.WaitGroup
 }
 
 // NewAdapter creates a new Adapter.
@@ -133,13 +144,14 @@ func NewAdapter(opts Options) *Adapter {
 		tools = defaultTools()
 	}
 	return &Adapter{
-		client: opts.Client,
-		reader: reader,
-		writer: writer,
-		logger: logger,
-		tools:  tools,
-		enc:    json.NewEncoder(writer),
-		dec:    json.NewDecoder(reader),
+		client:   opts.Client,
+		reader:   reader,
+		writer:   writer,
+		logger:   logger,
+		tools:    tools,
+		enc:      json.NewEncoder(writer),
+		dec:      json.NewDecoder(reader),
+		inflight: map[string]context.CancelFunc{},
 	}
 }
 
@@ -148,6 +160,7 @@ func (a *Adapter) Run(ctx context.Context) error {
 	if a.client == nil {
 		return errors.New("adapter client is nil")
 	}
+	defer a.wg.Wait()
 	for {
 		select {
 		case <-ctx.Done():
@@ -155,57 +168,144 @@ func (a *Adapter) Run(ctx context.Context) error {
 		default:
 		}
 
-		var req rpcRequest
-		if err := a.dec.Decode(&req); err != nil {
+		var raw json.RawMessage
+		if err := a.dec.Decode(&raw); err != nil {
 			if errors.Is(err, io.EOF) {
 				return nil
 			}
 			return err
 		}
-		if req.JSONRPC != "" && req.JSONRPC != jsonRPCVersion {
-			a.respondError(req.ID, errCodeInvalidRequest, "invalid jsonrpc version", nil)
+		if isBatch(raw) {
+			a.handleBatch(ctx, raw)
 			continue
 		}
-		if req.Method == "" {
-			a.respondError(req.ID, errCodeInvalidRequest, "method is required", nil)
+		var req rpcRequest
+		if err := json.Unmarshal(raw, &req); err != nil {
+			return err
+		}
+		a.dispatch(ctx, req)
+	}
+}
+
+// handleBatch processes a JSON-RPC batch array and writes the collected
+// responses as one array. Notifications contribute no entries, and nothing is
+// written when the batch only contains notifications.
+func (a *Adapter) handleBatch(ctx context.Context, raw json.RawMessage) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(raw, &items); err != nil || len(items) == 0 {
+		a.writeMessage(rpcResponse{
+			JSONRPC: jsonRPCVersion,
+			ID:      nullID,
+			Error:   &rpcError{Code: errCodeInvalidRequest, Message: "invalid batch"},
+		})
+		return
+	}
+
+	batch := &responseBatch{responses: make([]rpcResponse, 0, len(items))}
+	ctx = withResponseBatch(ctx, batch)
+	for _, item := range items {
+		var req rpcRequest
+		if err := json.Unmarshal(item, &req); err != nil {
+			a.send(ctx, rpcResponse{
+				JSONRPC: jsonRPCVersion,
+				ID:      nullID,
+				Error:   &rpcError{Code: errCodeInvalidRequest, Message: "invalid request", Data: err.Error()},
+			})
 			continue
 		}
+		a.dispatch(ctx, req)
+	}
 
-		switch req.Method {
-		case "initialize":
-			result := map[string]any{
-				"protocolVersion": "0.1",
-				"serverInfo": map[string]string{
-					"name":    "pipeline-engine-mcp",
-					"version": "0.1.0",
-				},
-				"capabilities": map[string]any{
-					"prompts":      map[string]bool{"list": false},
-					"resources":    map[string]bool{"list": false},
-					"tools":        map[string]bool{"list": true, "call": true},
-					"experimental": map[string]bool{},
-				},
-			}
-			a.respondResult(req.ID, result)
-		case "tools/list":
-			result := map[string]any{"tools": a.tools}
-			a.respondResult(req.ID, result)
-		case "tools/call":
-			a.handleToolCall(ctx, req)
-		default:
-			a.respondError(req.ID, errCodeMethodNotFound, "method not implemented", nil)
+	if len(batch.responses) > 0 {
+		a.writeMessage(batch.responses)
+	}
+}
+
+// responseBatch collects the responses of one JSON-RPC batch. It travels in
+// the context of the batch's calls, so responses of calls running in the
+// background for earlier messages are never mixed into it.
+type responseBatch struct {
+	mu        sync.Mutex
+	responses []rpcResponse
+}
+
+func (b *responseBatch) add(resp rpcResponse) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.responses = append(b.responses, resp)
+}
+
+type responseBatchKey struct{}
+
+func withResponseBatch(ctx context.Context, batch *responseBatch) context.Context {
+	return context.WithValue(ctx, responseBatchKey{}, batch)
+}
+
+// batchFromContext returns the batch the call of ctx belongs to, or nil.
+func batchFromContext(ctx context.Context) *responseBatch {
+	batch, _ := ctx.Value(responseBatchKey{}).(*responseBatch)
+	return batch
+}
+
+func (a *Adapter) dispatch(ctx context.Context, req rpcRequest) {
+	if req.JSONRPC != "" && req.JSONRPC != jsonRPCVersion {
+		a.respondError(ctx, req.ID, errCodeInvalidRequest, "invalid jsonrpc version", nil)
+		return
+	}
+	if req.Method == "" {
+		a.respondError(ctx, req.ID, errCodeInvalidRequest, "method is required", nil)
+		return
+	}
+
+	switch req.Method {
+	case "initialize":
+		result := map[string]any{
+			"protocolVersion": "0.1",
+			"serverInfo": map[string]string{
+				"name":    "pipeline-engine-mcp",
+				"version": "0.1.0",
+			},
+			"capabilities": map[string]any{
+				"prompts":      map[string]bool{"list": true, "get": true},
+				"resources":    map[string]bool{"list": true, "read": true},
+				"tools":        map[string]bool{"list": true, "call": true},
+				"experimental": map[string]bool{},
+			},
 		}
+		a.respondResult(ctx, req.ID, result)
+	case "tools/list":
+		result := map[string]any{"tools": a.tools}
+		a.respondResult(ctx, req.ID, result)
+	case "tools/call":
+		a.handleToolCall(ctx, req)
+	case "prompts/list":
+		a.handlePromptsList(ctx, req.ID)
+	case "prompts/get":
+		a.handlePromptsGet(ctx, req)
+	case "resources/list":
+		a.handleResourcesList(ctx, req.ID)
+	case "resources/read":
+		a.handleResourcesRead(ctx, req)
+	case "notifications/cancelled", "$/cancelRequest":
+		a.handleCancelRequest(req)
+	default:
+		a.respondError(ctx, req.ID, errCodeMethodNotFound, "method not implemented", nil)
 	}
 }
 
+func isBatch(raw json.RawMessage) bool {
+	trimmed := bytes.TrimLeft(raw, " \t\r\n")
+	return len(trimmed) > 0 && trimmed[0] == '['
+}
+
 func (a *Adapter) handleToolCall(ctx context.Context, req rpcRequest) {
 	if len(req.Params) == 0 {
-		a.respondError(req.ID, errCodeInvalidParams, "missing params", nil)
+		a.respondError(ctx, req.ID, errCodeInvalidParams, "missing params", nil)
 		return
 	}
 	var params toolCallParams
 	if err := json.Unmarshal(req.Params, &params); err != nil {
-		a.respondError(req.ID, errCodeInvalidParams, "invalid params", err.Error())
+		a.respondError(ctx, req.ID, errCodeInvalidParams, "invalid params", err.Error())
 		return
 	}
 	switch params.ToolName {
@@ -220,39 +320,111 @@ func (a *Adapter) handleToolCall(ctx context.Context, req rpcRequest) {
 	case "upsertProviderProfile":
 		a.handleUpsertProviderProfile(ctx, req.ID, params.Arguments)
 	case "streamJob":
-		a.handleStreamJob(ctx, req.ID, params.Arguments)
+		a.runCancellable(ctx, req.ID, func(ctx context.Context) {
+			a.handleStreamJob(ctx, req.ID, params.Arguments)
+		})
 	case "listPipelines":
 		a.handleListPipelines(ctx, req.ID)
 	case "listMetrics":
 		a.handleListMetrics(ctx, req.ID)
 	default:
-		a.respondError(req.ID, errCodeMethodNotFound, "tool not found", params.ToolName)
+		a.respondError(ctx, req.ID, errCodeMethodNotFound, "tool not found", params.ToolName)
 	}
 }
 
+// runCancellable runs a long-lived tool call in the background so that later
+// messages, including cancellation notifications for it, keep being read.
+// Calls inside a batch and notifications without an ID run inline.
+func (a *Adapter) runCancellable(ctx context.Context, id json.RawMessage, fn func(ctx context.Context)) {
+	key := requestKey(id)
+	a.mu.Lock()
+	_, duplicate := a.inflight[key]
+	inline := batchFromContext(ctx) != nil || key == ""
+	a.mu.Unlock()
+	if inline {
+		fn(ctx)
+		return
+	}
+	if duplicate {
+		a.respondError(ctx, id, errCodeInvalidRequest, "request id already in flight", nil)
+		return
+	}
+
+	callCtx, cancel := context.WithCancel(ctx)
+	a.mu.Lock()
+	a.inflight[key] = cancel
+	a.mu.Unlock()
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		defer func() {
+			a.mu.Lock()
+			delete(a.inflight, key)
+			a.mu.Unlock()
+			cancel()
+		}()
+		fn(callCtx)
+	}()
+}
+
+// handleCancelRequest cancels an in-flight call. It accepts both the MCP
+// notifications/cancelled form ({"requestId": ...}) and the LSP-style
+// $/cancelRequest form ({"id": ...}). Unknown IDs are ignored.
+func (a *Adapter) handleCancelRequest(req rpcRequest) {
+	var params cancelRequestParams
+	if len(req.Params) == 0 || json.Unmarshal(req.Params, &params) != nil {
+		return
+	}
+	target := params.RequestID
+	if len(target) == 0 {
+		target = params.ID
+	}
+	key := requestKey(target)
+	if key == "" {
+		return
+	}
+	a.mu.Lock()
+	cancel, ok := a.inflight[key]
+	a.mu.Unlock()
+	if ok {
+		a.logger.Printf("cancelling request %s", key)
+		cancel()
+	}
+}
+
+func requestKey(id json.RawMessage) string {
+	trimmed := bytes.TrimSpace(id)
+	if len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null")) {
+		return ""
+	}
+	return string(trimmed)
+}
+
 func (a *Adapter) handleStartPipeline(ctx context.Context, id json.RawMessage, raw json.RawMessage) {
 	var args startPipelineArgs
 	if err := json.Unmarshal(raw, &args); err != nil {
-		a.respondError(id, errCodeInvalidParams, "invalid arguments", err.Error())
+		a.respondError(ctx, id, errCodeInvalidParams, "invalid arguments", err.Error())
 		return
 	}
 	if args.PipelineType == "" {
-		a.respondError(id, errCodeInvalidParams, "pipeline_type is required", nil)
+		a.respondError(ctx, id, errCodeInvalidParams, "pipeline_type is required", nil)
 		return
 	}
 	req := engine.JobRequest{
-		PipelineType:  engine.PipelineType(args.PipelineType),
-		Input:         args.Input,
-		Mode:          args.Mode,
-		FromStepID:    args.FromStepID,
-		ReuseUpstream: args.ReuseUpstream,
+		PipelineType:    engine.PipelineType(args.PipelineType),
+		PipelineVersion: args.PipelineVersion,
+		Input:           args.Input,
+		Mode:            args.Mode,
+		FromStepID:      args.FromStepID,
+		ReuseUpstream:   args.ReuseUpstream,
 	}
 	if args.Stream {
 		eventCh, job, err := a.client.StreamJob(ctx, req)
 		if err != nil {
-			a.respondError(id, errCodeInternalError, "stream job failed", err.Error())
+			a.respondError(ctx, id, errCodeInternalError, "stream job failed", err.Error())
 			return
 		}
+		a.rememberJob(job)
 		a.emitToolEvent("startPipeline", engine.StreamingEvent{
 			Event: "job_queued",
 			JobID: job.ID,
@@ -266,158 +438,163 @@ func (a *Adapter) handleStartPipeline(ctx context.Context, id json.RawMessage, r
 			}
 			a.emitToolEvent("startPipeline", evt)
 		}
-		a.respondResult(id, map[string]any{
+		a.respondResult(ctx, id, map[string]any{
 			"job": job,
 		})
 		return
 	}
 	job, err := a.client.CreateJob(ctx, req)
 	if err != nil {
-		a.respondError(id, errCodeInternalError, "create job failed", err.Error())
+		a.respondError(ctx, id, errCodeInternalError, "create job failed", err.Error())
 		return
 	}
-	a.respondResult(id, map[string]any{"job": job})
+	a.rememberJob(job)
+	a.respondResult(ctx, id, map[string]any{"job": job})
 }
 
 func (a *Adapter) handleGetJob(ctx context.Context, id json.RawMessage, raw json.RawMessage) {
 	var args getJobArgs
 	if err := json.Unmarshal(raw, &args); err != nil {
-		a.respondError(id, errCodeInvalidParams, "invalid arguments", err.Error())
+		a.respondError(ctx, id, errCodeInvalidParams, "invalid arguments", err.Error())
 		return
 	}
 	if args.JobID == "" {
-		a.respondError(id, errCodeInvalidParams, "job_id is required", nil)
+		a.respondError(ctx, id, errCodeInvalidParams, "job_id is required", nil)
 		return
 	}
 	job, err := a.client.GetJob(ctx, args.JobID)
 	if err != nil {
-		a.respondError(id, errCodeInternalError, "get job failed", err.Error())
+		a.respondError(ctx, id, errCodeInternalError, "get job failed", err.Error())
 		return
 	}
-	a.respondResult(id, map[string]any{"job": job})
+	a.rememberJob(job)
+	a.respondResult(ctx, id, map[string]any{"job": job})
 }
 
 func (a *Adapter) handleCancelJob(ctx context.Context, id json.RawMessage, raw json.RawMessage) {
 	var args cancelJobArgs
 	if err := json.Unmarshal(raw, &args); err != nil {
-		a.respondError(id, errCodeInvalidParams, "invalid arguments", err.Error())
+		a.respondError(ctx, id, errCodeInvalidParams, "invalid arguments", err.Error())
 		return
 	}
 	if args.JobID == "" {
-		a.respondError(id, errCodeInvalidParams, "job_id is required", nil)
+		a.respondError(ctx, id, errCodeInvalidParams, "job_id is required", nil)
 		return
 	}
 	job, err := a.client.CancelJob(ctx, args.JobID, args.Reason)
 	if err != nil {
-		a.respondError(id, errCodeInternalError, "cancel job failed", err.Error())
+		a.respondError(ctx, id, errCodeInternalError, "cancel job failed", err.Error())
 		return
 	}
-	a.respondResult(id, map[string]any{"job": job})
+	a.respondResult(ctx, id, map[string]any{"job": job})
 }
 
 func (a *Adapter) handleRerunJob(ctx context.Context, id json.RawMessage, raw json.RawMessage) {
 	var args rerunJobArgs
 	if err := json.Unmarshal(raw, &args); err != nil {
-		a.respondError(id, errCodeInvalidParams, "invalid arguments", err.Error())
+		a.respondError(ctx, id, errCodeInvalidParams, "invalid arguments", err.Error())
 		return
 	}
 	if args.JobID == "" {
-		a.respondError(id, errCodeInvalidParams, "job_id is required", nil)
+		a.respondError(ctx, id, errCodeInvalidParams, "job_id is required", nil)
 		return
 	}
 	req := gosdk.RerunRequest{
 		FromStepID:    args.FromStepID,
 		ReuseUpstream: args.ReuseUpstream,
+		ReuseSteps:    args.ReuseSteps,
 		OverrideInput: args.OverrideInput,
 	}
 	job, err := a.client.RerunJob(ctx, args.JobID, req)
 	if err != nil {
-		a.respondError(id, errCodeInternalError, "rerun job failed", err.Error())
+		a.respondError(ctx, id, errCodeInternalError, "rerun job failed", err.Error())
 		return
 	}
-	a.respondResult(id, map[string]any{"job": job})
+	a.rememberJob(job)
+	a.respondResult(ctx, id, map[string]any{"job": job})
 }
 
 func (a *Adapter) handleUpsertProviderProfile(ctx context.Context, id json.RawMessage, raw json.RawMessage) {
 	var profile engine.ProviderProfile
 	if err := json.Unmarshal(raw, &profile); err != nil {
-		a.respondError(id, errCodeInvalidParams, "invalid profile", err.Error())
+		a.respondError(ctx, id, errCodeInvalidParams, "invalid profile", err.Error())
 		return
 	}
 	if profile.ID == "" {
-		a.respondError(id, errCodeInvalidParams, "id is required", nil)
+		a.respondError(ctx, id, errCodeInvalidParams, "id is required", nil)
 		return
 	}
 	if err := a.client.UpsertProviderProfile(ctx, profile); err != nil {
-		a.respondError(id, errCodeInternalError, "upsert provider failed", err.Error())
+		a.respondError(ctx, id, errCodeInternalError, "upsert provider failed", err.Error())
 		return
 	}
-	a.respondResult(id, map[string]any{"profile": profile})
+	a.respondResult(ctx, id, map[string]any{"profile": profile})
 }
 
 func (a *Adapter) handleStreamJob(ctx context.Context, id json.RawMessage, raw json.RawMessage) {
 	var args streamJobArgs
 	if err := json.Unmarshal(raw, &args); err != nil {
-		a.respondError(id, errCodeInvalidParams, "invalid arguments", err.Error())
+		a.respondError(ctx, id, errCodeInvalidParams, "invalid arguments", err.Error())
 		return
 	}
 	if args.JobID == "" {
-		a.respondError(id, errCodeInvalidParams, "job_id is required", nil)
+		a.respondError(ctx, id, errCodeInvalidParams, "job_id is required", nil)
 		return
 	}
 	eventCh, err := a.client.StreamExistingJob(ctx, args.JobID, args.AfterSeq)
 	if err != nil {
-		a.respondError(id, errCodeInternalError, "stream job failed", err.Error())
+		a.respondError(ctx, id, errCodeInternalError, "stream job failed", err.Error())
 		return
 	}
-	for evt := range eventCh {
+	for {
 		select {
 		case <-ctx.Done():
+			// Cancelled calls get no response; see MCP notifications/cancelled.
 			return
-		default:
+		case evt, ok := <-eventCh:
+			if !ok {
+				a.respondResult(ctx, id, map[string]any{"job_id": args.JobID})
+				return
+			}
+			a.emitToolEvent("streamJob", evt)
 		}
-		a.emitToolEvent("streamJob", evt)
 	}
-	a.respondResult(id, map[string]any{"job_id": args.JobID})
 }
 
 func (a *Adapter) handleListPipelines(ctx context.Context, id json.RawMessage) {
 	defs, err := a.client.ListPipelines(ctx)
 	if err != nil {
-		a.respondError(id, errCodeInternalError, "list pipelines failed", err.Error())
+		a.respondError(ctx, id, errCodeInternalError, "list pipelines failed", err.Error())
 		return
 	}
-	a.respondResult(id, map[string]any{"pipelines": defs})
+	a.respondResult(ctx, id, map[string]any{"pipelines": defs})
 }
 
 func (a *Adapter) handleListMetrics(ctx context.Context, id json.RawMessage) {
 	data, err := a.client.GetMetrics(ctx)
 	if err != nil {
-		a.respondError(id, errCodeInternalError, "list metrics failed", err.Error())
+		a.respondError(ctx, id, errCodeInternalError, "list metrics failed", err.Error())
 		return
 	}
-	a.respondResult(id, map[string]any{"metrics": data})
+	a.respondResult(ctx, id, map[string]any{"metrics": data})
 }
 
-func (a *Adapter) respondResult(id json.RawMessage, result interface{}) {
+func (a *Adapter) respondResult(ctx context.Context, id json.RawMessage, result interface{}) {
 	if len(id) == 0 {
 		return
 	}
-	resp := rpcResponse{
+	a.send(ctx, rpcResponse{
 		JSONRPC: jsonRPCVersion,
 		ID:      id,
 		Result:  result,
-	}
-	a.mu.Lock()
-	defer a.mu.Unlock()
-	_ = a.enc.Encode(&resp)
+	})
 }
 
-func (a *Adapter) respondError(id json.RawMessage, code int, message string, data interface{}) {
+func (a *Adapter) respondError(ctx context.Context, id json.RawMessage, code int, message string, data interface{}) {
 	if len(id) == 0 {
 		return
 	}
-	resp := rpcResponse{
+	a.send(ctx, rpcResponse{
 		JSONRPC: jsonRPCVersion,
 		ID:      id,
 		Error: &rpcError{
@@ -425,23 +602,41 @@ func (a *Adapter) respondError(id json.RawMessage, code int, message string, dat
 			Message: message,
 			Data:    data,
 		},
+	})
+}
+
+// send writes a response, or adds it to the batch the call of ctx belongs to.
+func (a *Adapter) send(ctx context.Context, resp rpcResponse) {
+	if batch := batchFromContext(ctx); batch != nil {
+		batch.add(resp)
+		return
 	}
+	a.writeMessage(&resp)
+}
+
+func (a *Adapter) writeMessage(msg interface{}) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
-	_ = a.enc.Encode(&resp)
+	_ = a.enc.Encode(msg)
 }
 
 func (a *Adapter) emitToolEvent(toolName string, evt engine.StreamingEvent) {
+	kind := classifyEventKind(evt.Event)
+	params := map[string]any{
+		"toolName": toolName,
+		"event":    evt.Event,
+		"kind":     kind,
+		"seq":      evt.Seq,
+		"payload":  evt,
+	}
+	if jobErr := eventJobError(evt); kind == "error" && jobErr != nil {
+		params["errorCode"] = jobErr.Code
+		params["retryable"] = gosdk.IsRetryableJobError(jobErr)
+	}
 	notification := rpcNotification{
 		JSONRPC: jsonRPCVersion,
 		Method:  "tool_event",
-		Params: map[string]any{
-			"toolName": toolName,
-			"event":    evt.Event,
-			"kind":     classifyEventKind(evt.Event),
-			"seq":      evt.Seq,
-			"payload":  evt,
-		},
+		Params:  params,
 	}
 	a.mu.Lock()
 	defer a.mu.Unlock()
@@ -461,6 +656,26 @@ func classifyEventKind(eventName string) string {
 	}
 }
 
+// eventJobError returns the error carried by job_failed and step_failed
+// events, or nil.
+func eventJobError(evt engine.StreamingEvent) *engine.JobError {
+	switch data := evt.Data.(type) {
+	case engine.JobStatusEvent:
+		return data.Error
+	case *engine.Job:
+		return data.Error
+	case engine.StepEvent:
+		return data.Error
+	case engine.StepExecution:
+		return data.Error
+	default:
+		return nil
+	}
+}
+
+// nullID is used for error responses to requests whose id could not be read.
+var nullID = json.RawMessage("null")
+
 type rpcRequest struct {
 	JSONRPC string          `json:"jsonrpc"`
 	ID      json.RawMessage `json:"id"`
@@ -468,6 +683,11 @@ type rpcRequest struct {
 	Params  json.RawMessage `json:"params"`
 }
 
+type cancelRequestParams struct {
+	RequestID json.RawMessage `json:"requestId"`
+	ID        json.RawMessage `json:"id"`
+}
+
 type rpcResponse struct {
 	JSONRPC string          `json:"jsonrpc"`
 	ID      json.RawMessage `json:"id"`
@@ -493,12 +713,13 @@ type toolCallParams struct {
 }
 
 type startPipelineArgs struct {
-	PipelineType  string          `json:"pipeline_type"`
-	Input         engine.JobInput `json:"input"`
-	Mode          string          `json:"mode,omitempty"`
-	Stream        bool            `json:"stream,omitempty"`
-	FromStepID    *engine.StepID  `json:"from_step_id,omitempty"`
-	ReuseUpstream bool            `json:"reuse_upstream,omitempty"`
+	PipelineType    string          `json:"pipeline_type"`
+	PipelineVersion string          `json:"pipeline_version,omitempty"`
+	Input           engine.JobInput `json:"input"`
+	Mode            string          `json:"mode,omitempty"`
+	Stream          bool            `json:"stream,omitempty"`
+	FromStepID      *engine.StepID  `json:"from_step_id,omitempty"`
+	ReuseUpstream   bool            `json:"reuse_upstream,omitempty"`
 }
 
 type getJobArgs struct {
@@ -514,6 +735,7 @@ type rerunJobArgs struct {
 	JobID         string           `json:"job_id"`
 	FromStepID    *engine.StepID   `json:"from_step_id,omitempty"`
 	ReuseUpstream bool             `json:"reuse_upstream,omitempty"`
+	ReuseSteps    []engine.StepID  `json:"reuse_steps,omitempty"`
 	OverrideInput *engine.JobInput `json:"override_input,omitempty"`
 }
 
@@ -534,10 +756,11 @@ func defaultTools() []Tool {
 					"input",
 				},
 				"properties": map[string]any{
-					"pipeline_type": map[string]string{"type": "string"},
-					"input":         map[string]string{"type": "object"},
-					"mode":          map[string]string{"type": "string"},
-					"stream":        map[string]string{"type": "boolean"},
+					"pipeline_type":    map[string]string{"type": "string"},
+					"pipeline_version": map[string]string{"type": "string"},
+					"input":            map[string]string{"type": "object"},
+					"mode":             map[string]string{"type": "string"},
+					"stream":           map[string]string{"type": "boolean"},
 				},
 			},
 		},
@@ -569,6 +792,7 @@ func defaultTools() []Tool {
 					"job_id":         map[string]string{"type": "string"},
 					"from_step_id":   map[string]string{"type": "string"},
 					"reuse_upstream": map[string]string{"type": "boolean"},
+					"reuse_steps":    map[string]any{"type": "array", "items": map[string]string{"type": "string"}},
 				},
 			},
 		},
@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"io"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -122,6 +124,41 @@ func TestAdapterStartPipelineStreamEmitsEvents(t *testing.T) {
 	}
 }
 
+func TestAdapterToolEventCarriesProviderErrorCode(t *testing.T) {
+	job := sampleJob("job-fail")
+	failed := *job
+	failed.Status = engine.JobStatusFailed
+	failed.Error = &engine.JobError{Code: engine.ErrCodeProviderAPI, Message: "openai api error: 503", Details: map[string]any{"status_code": float64(503)}}
+	client := &stubClient{
+		streamEvents:    []engine.StreamingEvent{{Event: "job_failed", JobID: job.ID, Data: engine.JobStatusEvent{Job: failed}}},
+		streamJobResult: job,
+	}
+	req := `{"jsonrpc":"2.0","id":5,"method":"tools/call","params":{"toolName":"startPipeline","arguments":{"pipeline_type":"demo","stream":true}}}`
+	var buf bytes.Buffer
+	a := NewAdapter(Options{Client: client, Reader: strings.NewReader(req), Writer: &buf})
+	if err := a.Run(context.Background()); err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	var found bool
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if !strings.Contains(line, `"event":"job_failed"`) {
+			continue
+		}
+		var notification rpcNotification
+		if err := json.Unmarshal([]byte(line), &notification); err != nil {
+			t.Fatalf("decode tool event: %v", err)
+		}
+		params, _ := notification.Params.(map[string]any)
+		if params["kind"] != "error" || params["errorCode"] != engine.ErrCodeProviderAPI || params["retryable"] != true {
+			t.Fatalf("unexpected error classification: %#v", params)
+		}
+		found = true
+	}
+	if !found {
+		t.Fatalf("job_failed tool event not emitted: %s", buf.String())
+	}
+}
+
 func TestAdapterGetJob(t *testing.T) {
 	job := sampleJob("job-xyz")
 	client := &stubClient{getJobResult: job}
@@ -144,6 +181,196 @@ func TestAdapterGetJob(t *testing.T) {
 	}
 }
 
+func TestAdapterBatchRequest(t *testing.T) {
+	client := &stubClient{getJobResult: sampleJob("job-batch")}
+	req := `[{"jsonrpc":"2.0","id":1,"method":"tools/list"},{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"toolName":"getJob","arguments":{"job_id":"job-batch"}}}]`
+	var buf bytes.Buffer
+	a := NewAdapter(Options{
+		Client: client,
+		Reader: strings.NewReader(req),
+		Writer: &buf,
+	})
+	if err := a.Run(context.Background()); err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	var responses []rpcResponse
+	if err := json.Unmarshal(buf.Bytes(), &responses); err != nil {
+		t.Fatalf("decode batch response: %v (%s)", err, buf.String())
+	}
+	if len(responses) != 2 {
+		t.Fatalf("expected 2 responses, got %d", len(responses))
+	}
+	if string(responses[0].ID) != "1" || responses[0].Error != nil {
+		t.Fatalf("unexpected tools/list response: %+v", responses[0])
+	}
+	result, _ := responses[0].Result.(map[string]any)
+	if tools, _ := result["tools"].([]any); len(tools) == 0 {
+		t.Fatalf("tools/list result missing tools: %+v", responses[0].Result)
+	}
+	if string(responses[1].ID) != "2" || responses[1].Error != nil {
+		t.Fatalf("unexpected getJob response: %+v", responses[1])
+	}
+	payload, _ := responses[1].Result.(map[string]any)
+	jobData, _ := payload["job"].(map[string]any)
+	if jobData["id"] != "job-batch" {
+		t.Fatalf("getJob result mismatch: %+v", payload)
+	}
+}
+
+func TestAdapterBatchNotificationsOnly(t *testing.T) {
+	req := `[{"jsonrpc":"2.0","method":"tools/list"}]`
+	var buf bytes.Buffer
+	a := NewAdapter(Options{
+		Client: &stubClient{},
+		Reader: strings.NewReader(req),
+		Writer: &buf,
+	})
+	if err := a.Run(context.Background()); err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("expected no response for notification-only batch, got %s", buf.String())
+	}
+}
+
+func TestAdapterResourcesListAndRead(t *testing.T) {
+	job := sampleJob("job-done")
+	job.Status = engine.JobStatusSucceeded
+	job.Result = &engine.JobResult{Items: []engine.ResultItem{{ID: "item-1", Label: "summary"}}}
+	client := &stubClient{
+		getJobResult: job,
+		resultsPage:  &gosdk.ResultsPage{JobID: "job-done", Items: job.Result.Items, Total: 1},
+	}
+	req := strings.Join([]string{
+		`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"toolName":"getJob","arguments":{"job_id":"job-done"}}}`,
+		`{"jsonrpc":"2.0","id":2,"method":"resources/list"}`,
+		`{"jsonrpc":"2.0","id":3,"method":"resources/read","params":{"uri":"job://job-done/results"}}`,
+		`{"jsonrpc":"2.0","id":4,"method":"resources/read","params":{"uri":"file:///etc/passwd"}}`,
+	}, "\n")
+	var buf bytes.Buffer
+	a := NewAdapter(Options{Client: client, Reader: strings.NewReader(req), Writer: &buf})
+	if err := a.Run(context.Background()); err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected 4 responses, got %d: %s", len(lines), buf.String())
+	}
+
+	var listResp struct {
+		Result struct {
+			Resources []Resource `json:"resources"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &listResp); err != nil {
+		t.Fatalf("decode resources/list: %v", err)
+	}
+	if len(listResp.Result.Resources) != 1 || listResp.Result.Resources[0].URI != "job://job-done/results" {
+		t.Fatalf("unexpected resources: %+v", listResp.Result.Resources)
+	}
+
+	var readResp struct {
+		Result struct {
+			Contents []ResourceContent `json:"contents"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal([]byte(lines[2]), &readResp); err != nil {
+		t.Fatalf("decode resources/read: %v", err)
+	}
+	if client.resultsJobID != "job-done" {
+		t.Fatalf("expected results lookup for job-done, got %q", client.resultsJobID)
+	}
+	if len(readResp.Result.Contents) != 1 || !strings.Contains(readResp.Result.Contents[0].Text, "item-1") {
+		t.Fatalf("unexpected resource contents: %+v", readResp.Result.Contents)
+	}
+
+	var badResp rpcResponse
+	if err := json.Unmarshal([]byte(lines[3]), &badResp); err != nil {
+		t.Fatalf("decode invalid read: %v", err)
+	}
+	if badResp.Error == nil || badResp.Error.Code != errCodeInvalidParams {
+		t.Fatalf("expected invalid params error, got %+v", badResp)
+	}
+}
+
+func TestAdapterPromptsListAndGet(t *testing.T) {
+	client := &stubClient{
+		pipelines: []engine.PipelineDef{
+			{
+				Type:    "summarize",
+				Version: "v1",
+				Steps: []engine.StepDef{{
+					ID: "summarize",
+					Prompt: &engine.PromptTemplate{
+						System: "Reply in {{.Options.Language}}",
+						User:   "Summarize:{{range .Sources}} {{.Content}}{{end}}",
+					},
+				}},
+			},
+			{Type: "no-prompt", Version: "v1", Steps: []engine.StepDef{{ID: "raw"}}},
+		},
+	}
+	req := strings.Join([]string{
+		`{"jsonrpc":"2.0","id":1,"method":"prompts/list"}`,
+		`{"jsonrpc":"2.0","id":2,"method":"prompts/get","params":{"name":"summarize","arguments":{"sources":"first\nsecond","language":"ja"}}}`,
+		`{"jsonrpc":"2.0","id":3,"method":"prompts/get","params":{"name":"summarize","arguments":{}}}`,
+	}, "\n")
+	var buf bytes.Buffer
+	a := NewAdapter(Options{Client: client, Reader: strings.NewReader(req), Writer: &buf})
+	if err := a.Run(context.Background()); err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 responses, got %d: %s", len(lines), buf.String())
+	}
+
+	var listResp struct {
+		Result struct {
+			Prompts []Prompt `json:"prompts"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &listResp); err != nil {
+		t.Fatalf("decode prompts/list: %v", err)
+	}
+	if len(listResp.Result.Prompts) != 1 {
+		t.Fatalf("expected only pipelines with templates, got %+v", listResp.Result.Prompts)
+	}
+	args := listResp.Result.Prompts[0].Arguments
+	if len(args) != 2 || args[0].Name != "sources" || !args[0].Required || args[1].Name != "language" || args[1].Required {
+		t.Fatalf("unexpected prompt arguments: %+v", args)
+	}
+
+	var getResp struct {
+		Result struct {
+			Messages []struct {
+				Role    string `json:"role"`
+				Content struct {
+					Text string `json:"text"`
+				} `json:"content"`
+			} `json:"messages"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &getResp); err != nil {
+		t.Fatalf("decode prompts/get: %v", err)
+	}
+	if len(getResp.Result.Messages) != 1 {
+		t.Fatalf("unexpected messages: %+v", getResp.Result.Messages)
+	}
+	if got := getResp.Result.Messages[0].Content.Text; got != "Reply in ja\nSummarize: first second" {
+		t.Fatalf("unexpected rendered prompt: %q", got)
+	}
+
+	var missing rpcResponse
+	if err := json.Unmarshal([]byte(lines[2]), &missing); err != nil {
+		t.Fatalf("decode prompts/get error: %v", err)
+	}
+	if missing.Error == nil || missing.Error.Code != errCodeInvalidParams {
+		t.Fatalf("expected invalid params for missing sources, got %+v", missing)
+	}
+}
+
 func TestAdapterToolsList(t *testing.T) {
 	client := &stubClient{}
 	req := strings.Join([]string{
@@ -239,6 +466,143 @@ func TestAdapterStreamJobAfterSeq(t *testing.T) {
 	}
 }
 
+func TestAdapterStreamJobCancelRequest(t *testing.T) {
+	feed := make(chan engine.StreamingEvent)
+	client := &stubClient{streamExistingCh: feed}
+	in, inWriter := io.Pipe()
+	out := &lockedBuffer{}
+	a := NewAdapter(Options{Client: client, Reader: in, Writer: out})
+	done := make(chan error, 1)
+	go func() { done <- a.Run(context.Background()) }()
+
+	writeLine := func(line string) {
+		if _, err := io.WriteString(inWriter, line+"\n"); err != nil {
+			t.Fatalf("write request: %v", err)
+		}
+	}
+	waitFor := func(substr string) {
+		deadline := time.Now().Add(2 * time.Second)
+		for !strings.Contains(out.String(), substr) {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %q, output: %s", substr, out.String())
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	writeLine(`{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"toolName":"streamJob","arguments":{"job_id":"job-c"}}}`)
+	feed <- engine.StreamingEvent{Event: "provider_chunk", JobID: "job-c", Seq: 1}
+	waitFor(`"seq":1`)
+
+	writeLine(`{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":7,"reason":"user"}}`)
+	select {
+	case <-client.streamCtxDone():
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected stream context to be cancelled")
+	}
+	select {
+	case feed <- engine.StreamingEvent{Event: "provider_chunk", JobID: "job-c", Seq: 2}:
+		t.Fatal("expected tool to stop reading events after cancellation")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// The adapter keeps serving other requests after the cancellation.
+	writeLine(`{"jsonrpc":"2.0","id":8,"method":"tools/list"}`)
+	waitFor(`"id":8`)
+	inWriter.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+
+	output := out.String()
+	if strings.Contains(output, `"seq":2`) {
+		t.Fatalf("unexpected event after cancellation: %s", output)
+	}
+	if strings.Contains(output, `"id":7`) {
+		t.Fatalf("cancelled request must not get a response: %s", output)
+	}
+}
+
+func TestAdapterBatchWhileStreamJobFinishes(t *testing.T) {
+	feed := make(chan engine.StreamingEvent)
+	client := &stubClient{streamExistingCh: feed, getJobResult: sampleJob("job-batch")}
+	in, inWriter := io.Pipe()
+	out := &lockedBuffer{}
+	a := NewAdapter(Options{Client: client, Reader: in, Writer: out})
+	done := make(chan error, 1)
+	go func() { done <- a.Run(context.Background()) }()
+
+	waitFor := func(substr string) bool {
+		deadline := time.Now().Add(2 * time.Second)
+		for !strings.Contains(out.String(), substr) {
+			if time.Now().After(deadline) {
+				return false
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		return true
+	}
+
+	// The stream finishes while the batch's getJob call is in flight.
+	streamReplied := make(chan bool, 1)
+	client.getJobHook = func() {
+		close(feed)
+		streamReplied <- waitFor(`"id":7,`)
+	}
+	if _, err := io.WriteString(inWriter, `{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"toolName":"streamJob","arguments":{"job_id":"job-s"}}}`+"\n"); err != nil {
+		t.Fatalf("write request: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for client.streamCtxDone() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for streamJob to start")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if _, err := io.WriteString(inWriter, `[{"jsonrpc":"2.0","id":8,"method":"tools/call","params":{"toolName":"getJob","arguments":{"job_id":"job-batch"}}},{"jsonrpc":"2.0","id":9,"method":"tools/list"}]`+"\n"); err != nil {
+		t.Fatalf("write batch: %v", err)
+	}
+	if !<-streamReplied {
+		t.Fatalf("streamJob response was held back by the batch: %s", out.String())
+	}
+	if !waitFor(`"id":9`) {
+		t.Fatalf("timed out waiting for the batch response: %s", out.String())
+	}
+	inWriter.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+
+	dec := json.NewDecoder(strings.NewReader(out.String()))
+	var streamResponses int
+	var batch []rpcResponse
+	for dec.More() {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			t.Fatalf("decode output: %v (%s)", err, out.String())
+		}
+		if isBatch(raw) {
+			if batch != nil {
+				t.Fatalf("expected a single batch response: %s", out.String())
+			}
+			if err := json.Unmarshal(raw, &batch); err != nil {
+				t.Fatalf("decode batch response: %v", err)
+			}
+			continue
+		}
+		var resp rpcResponse
+		if err := json.Unmarshal(raw, &resp); err == nil && string(resp.ID) == "7" {
+			streamResponses++
+		}
+	}
+	if streamResponses != 1 {
+		t.Fatalf("expected one standalone streamJob response, got %d: %s", streamResponses, out.String())
+	}
+	if len(batch) != 2 || string(batch[0].ID) != "8" || string(batch[1].ID) != "9" {
+		t.Fatalf("batch response must hold exactly its own replies: %+v", batch)
+	}
+}
+
 func TestAdapterListPipelinesTool(t *testing.T) {
 	client := &stubClient{
 		pipelines: []engine.PipelineDef{{Type: "demo", Version: "v1"}},
@@ -290,12 +654,20 @@ type stubClient struct {
 	streamJobResult *engine.Job
 	streamExisting  []engine.StreamingEvent
 	streamAfterSeq  uint64
-	getJobResult    *engine.Job
+	// streamExistingCh, when set, is returned from StreamExistingJob as-is.
+	streamExistingCh <-chan engine.StreamingEvent
+	streamMu         sync.Mutex
+	streamCtx        context.Context
+	getJobResult     *engine.Job
+	// getJobHook, when set, runs inside GetJob before it returns.
+	getJobHook      func()
 	cancelJobResult *engine.Job
 	rerunJobResult  *engine.Job
 	profiles        []engine.ProviderProfile
 	pipelines       []engine.PipelineDef
 	metrics         map[string]map[string]int64
+	resultsJobID    string
+	resultsPage     *gosdk.ResultsPage
 }
 
 func (s *stubClient) CreateJob(ctx context.Context, req engine.JobRequest) (*engine.Job, error) {
@@ -315,6 +687,9 @@ func (s *stubClient) StreamJob(ctx context.Context, req engine.JobRequest) (<-ch
 }
 
 func (s *stubClient) GetJob(ctx context.Context, jobID string) (*engine.Job, error) {
+	if s.getJobHook != nil {
+		s.getJobHook()
+	}
 	return s.getJobResult, nil
 }
 
@@ -333,6 +708,12 @@ func (s *stubClient) UpsertProviderProfile(ctx context.Context, profile engine.P
 
 func (s *stubClient) StreamExistingJob(ctx context.Context, jobID string, afterSeq uint64) (<-chan engine.StreamingEvent, error) {
 	s.streamAfterSeq = afterSeq
+	if s.streamExistingCh != nil {
+		s.streamMu.Lock()
+		s.streamCtx = ctx
+		s.streamMu.Unlock()
+		return s.streamExistingCh, nil
+	}
 	events := s.streamExisting
 	if len(events) == 0 {
 		events = s.streamEvents
@@ -355,6 +736,41 @@ func (s *stubClient) GetMetrics(ctx context.Context) (map[string]map[string]int6
 	return s.metrics, nil
 }
 
+func (s *stubClient) GetJobResults(ctx context.Context, jobID string, query gosdk.ResultsQuery) (*gosdk.ResultsPage, error) {
+	s.resultsJobID = jobID
+	if s.resultsPage == nil {
+		return &gosdk.ResultsPage{JobID: jobID}, nil
+	}
+	return s.resultsPage, nil
+}
+
+func (s *stubClient) streamCtxDone() <-chan struct{} {
+	s.streamMu.Lock()
+	defer s.streamMu.Unlock()
+	if s.streamCtx == nil {
+		return nil
+	}
+	return s.streamCtx.Done()
+}
+
+// lockedBuffer is a goroutine-safe writer for adapters running in the background.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
 func sampleJob(id string) *engine.Job {
 	now := time.Unix(0, 0).UTC()
 	return &engine.Job{
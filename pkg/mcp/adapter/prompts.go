@@ -0,0 +1,244 @@
+package adapter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/example/pipeline-engine/internal/engine"
+)
+
+// Prompt describes an MCP prompt entry returned from prompts/list.
+type Prompt struct {
+	Name        string           `json:"name"`
+	Description string           `json:"description,omitempty"`
+	Arguments   []PromptArgument `json:"arguments,omitempty"`
+}
+
+// PromptArgument describes a single prompt argument. MCP arguments are
+// strings, so the expected value type is stated in the description.
+type PromptArgument struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Required    bool   `json:"required,omitempty"`
+}
+
+type getPromptParams struct {
+	Name      string            `json:"name"`
+	Arguments map[string]string `json:"arguments"`
+}
+
+const sourcesArgument = "sources"
+
+// optionArguments maps JobOptions template fields to prompt arguments.
+var optionArguments = map[string]PromptArgument{
+	"Language":    {Name: "language", Description: "Output language (string), e.g. ja or en"},
+	"DetailLevel": {Name: "detail_level", Description: "Detail level (string)"},
+	"MaxTokens":   {Name: "max_tokens", Description: "Maximum tokens (integer)"},
+}
+
+// pipelinePrompt turns the first step's PromptTemplate into an MCP prompt.
+// Pipelines whose first step has no template are not exposed.
+func pipelinePrompt(def engine.PipelineDef) (Prompt, bool) {
+	if len(def.Steps) == 0 || def.Steps[0].Prompt == nil {
+		return Prompt{}, false
+	}
+	step := def.Steps[0]
+	label := step.Name
+	if label == "" {
+		label = string(step.ID)
+	}
+	return Prompt{
+		Name:        string(def.Type),
+		Description: fmt.Sprintf("Prompt of step %q in pipeline %s (%s)", label, def.Type, def.Version),
+		Arguments:   promptArguments(step.Prompt),
+	}, true
+}
+
+// promptArguments derives arguments from the template variables referenced
+// by the system and user templates: .Sources becomes a required "sources"
+// argument and .Options.<Field> becomes an optional argument per option.
+func promptArguments(tmpl *engine.PromptTemplate) []PromptArgument {
+	refs := map[string]bool{}
+	for _, text := range []string{tmpl.System, tmpl.User} {
+		collectTemplateFields(text, refs)
+	}
+
+	var args []PromptArgument
+	if refs["Sources"] {
+		args = append(args, PromptArgument{
+			Name:        sourcesArgument,
+			Description: "Input content (string); each non-empty line becomes a note source",
+			Required:    true,
+		})
+	}
+	var options []PromptArgument
+	for field, arg := range optionArguments {
+		if refs["Options."+field] {
+			options = append(options, arg)
+		}
+	}
+	sort.Slice(options, func(i, j int) bool { return options[i].Name < options[j].Name })
+	return append(args, options...)
+}
+
+// collectTemplateFields records root-level field chains such as "Sources" and
+// "Options.Language" referenced by a text/template. Unparseable templates are
+// ignored.
+func collectTemplateFields(text string, refs map[string]bool) {
+	if text == "" {
+		return
+	}
+	tmpl, err := template.New("prompt").Funcs(engine.PromptFuncs()).Parse(text)
+	if err != nil {
+		return
+	}
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil {
+			walkTemplateNode(t.Tree.Root, refs)
+		}
+	}
+}
+
+func walkTemplateNode(node parse.Node, refs map[string]bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			walkTemplateNode(child, refs)
+		}
+	case *parse.ActionNode:
+		walkTemplateNode(n.Pipe, refs)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			walkTemplateNode(cmd, refs)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			walkTemplateNode(arg, refs)
+		}
+	case *parse.FieldNode:
+		recordFieldChain(n.Ident, refs)
+	case *parse.VariableNode:
+		if len(n.Ident) > 1 && n.Ident[0] == "$" {
+			recordFieldChain(n.Ident[1:], refs)
+		}
+	case *parse.IfNode:
+		walkBranch(&n.BranchNode, refs)
+	case *parse.RangeNode:
+		walkBranch(&n.BranchNode, refs)
+	case *parse.WithNode:
+		walkBranch(&n.BranchNode, refs)
+	}
+}
+
+func walkBranch(n *parse.BranchNode, refs map[string]bool) {
+	walkTemplateNode(n.Pipe, refs)
+	walkTemplateNode(n.List, refs)
+	walkTemplateNode(n.ElseList, refs)
+}
+
+func recordFieldChain(ident []string, refs map[string]bool) {
+	if len(ident) == 0 {
+		return
+	}
+	refs[ident[0]] = true
+	if len(ident) > 1 {
+		refs[ident[0]+"."+ident[1]] = true
+	}
+}
+
+// promptInput converts prompt arguments into a JobInput for rendering.
+func promptInput(args map[string]string) (engine.JobInput, error) {
+	var input engine.JobInput
+	for _, line := range strings.Split(args[sourcesArgument], "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			input.Sources = append(input.Sources, engine.Source{Kind: engine.SourceKindNote, Content: line})
+		}
+	}
+	opts := &engine.JobOptions{
+		Language:    args["language"],
+		DetailLevel: args["detail_level"],
+	}
+	if raw := args["max_tokens"]; raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return input, fmt.Errorf("max_tokens must be an integer: %w", err)
+		}
+		opts.MaxTokens = n
+	}
+	input.Options = opts
+	return input, nil
+}
+
+func (a *Adapter) handlePromptsList(ctx context.Context, id json.RawMessage) {
+	defs, err := a.client.ListPipelines(ctx)
+	if err != nil {
+		a.respondError(ctx, id, errCodeInternalError, "list pipelines failed", err.Error())
+		return
+	}
+	prompts := make([]Prompt, 0, len(defs))
+	for _, def := range defs {
+		if prompt, ok := pipelinePrompt(def); ok {
+			prompts = append(prompts, prompt)
+		}
+	}
+	sort.Slice(prompts, func(i, j int) bool { return prompts[i].Name < prompts[j].Name })
+	a.respondResult(ctx, id, map[string]any{"prompts": prompts})
+}
+
+func (a *Adapter) handlePromptsGet(ctx context.Context, req rpcRequest) {
+	var params getPromptParams
+	if len(req.Params) == 0 {
+		a.respondError(ctx, req.ID, errCodeInvalidParams, "missing params", nil)
+		return
+	}
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		a.respondError(ctx, req.ID, errCodeInvalidParams, "invalid params", err.Error())
+		return
+	}
+	defs, err := a.client.ListPipelines(ctx)
+	if err != nil {
+		a.respondError(ctx, req.ID, errCodeInternalError, "list pipelines failed", err.Error())
+		return
+	}
+	for _, def := range defs {
+		if string(def.Type) != params.Name {
+			continue
+		}
+		prompt, ok := pipelinePrompt(def)
+		if !ok {
+			break
+		}
+		for _, arg := range prompt.Arguments {
+			if arg.Required && strings.TrimSpace(params.Arguments[arg.Name]) == "" {
+				a.respondError(ctx, req.ID, errCodeInvalidParams, fmt.Sprintf("%s is required", arg.Name), nil)
+				return
+			}
+		}
+		input, err := promptInput(params.Arguments)
+		if err != nil {
+			a.respondError(ctx, req.ID, errCodeInvalidParams, "invalid arguments", err.Error())
+			return
+		}
+		text := engine.RenderPrompt(def.Steps[0], input)
+		a.respondResult(ctx, req.ID, map[string]any{
+			"description": prompt.Description,
+			"messages": []map[string]any{
+				{"role": "user", "content": map[string]string{"type": "text", "text": text}},
+			},
+		})
+		return
+	}
+	a.respondError(ctx, req.ID, errCodeInvalidParams, "prompt not found", params.Name)
+}
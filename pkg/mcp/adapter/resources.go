@@ -0,0 +1,130 @@
+package adapter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/example/pipeline-engine/internal/engine"
+	gosdk "github.com/example/pipeline-engine/pkg/sdk/go"
+)
+
+const (
+	jobResourceScheme = "job://"
+	jobResourceSuffix = "/results"
+	// maxKnownJobs bounds how many job IDs the adapter remembers for resources/list.
+	maxKnownJobs = 100
+)
+
+// Resource describes an MCP resource entry returned from resources/list.
+type Resource struct {
+	URI         string `json:"uri"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	MimeType    string `json:"mimeType,omitempty"`
+}
+
+// ResourceContent is a single content entry returned from resources/read.
+type ResourceContent struct {
+	URI      string `json:"uri"`
+	MimeType string `json:"mimeType,omitempty"`
+	Text     string `json:"text"`
+}
+
+type readResourceParams struct {
+	URI string `json:"uri"`
+}
+
+func jobResourceURI(jobID string) string {
+	return jobResourceScheme + jobID + jobResourceSuffix
+}
+
+func parseJobResourceURI(uri string) (string, bool) {
+	if !strings.HasPrefix(uri, jobResourceScheme) || !strings.HasSuffix(uri, jobResourceSuffix) {
+		return "", false
+	}
+	jobID := strings.TrimSuffix(strings.TrimPrefix(uri, jobResourceScheme), jobResourceSuffix)
+	if jobID == "" || strings.Contains(jobID, "/") {
+		return "", false
+	}
+	return jobID, true
+}
+
+// rememberJob records a job touched through the adapter so its results can be
+// offered via resources/list. Only the most recent maxKnownJobs are kept.
+func (a *Adapter) rememberJob(job *engine.Job) {
+	if job == nil || job.ID == "" {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i, id := range a.knownJobs {
+		if id == job.ID {
+			a.knownJobs = append(a.knownJobs[:i], a.knownJobs[i+1:]...)
+			break
+		}
+	}
+	a.knownJobs = append(a.knownJobs, job.ID)
+	if len(a.knownJobs) > maxKnownJobs {
+		a.knownJobs = a.knownJobs[len(a.knownJobs)-maxKnownJobs:]
+	}
+}
+
+func (a *Adapter) snapshotKnownJobs() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]string(nil), a.knownJobs...)
+}
+
+// handleResourcesList lists the results of succeeded jobs seen by this adapter.
+func (a *Adapter) handleResourcesList(ctx context.Context, id json.RawMessage) {
+	resources := make([]Resource, 0)
+	for _, jobID := range a.snapshotKnownJobs() {
+		job, err := a.client.GetJob(ctx, jobID)
+		if err != nil || job == nil || job.Status != engine.JobStatusSucceeded {
+			continue
+		}
+		count := 0
+		if job.Result != nil {
+			count = len(job.Result.Items)
+		}
+		resources = append(resources, Resource{
+			URI:         jobResourceURI(job.ID),
+			Name:        fmt.Sprintf("%s results (%s)", job.PipelineType, job.ID),
+			Description: fmt.Sprintf("%d exported result items", count),
+			MimeType:    "application/json",
+		})
+	}
+	a.respondResult(ctx, id, map[string]any{"resources": resources})
+}
+
+func (a *Adapter) handleResourcesRead(ctx context.Context, req rpcRequest) {
+	var params readResourceParams
+	if len(req.Params) == 0 {
+		a.respondError(ctx, req.ID, errCodeInvalidParams, "missing params", nil)
+		return
+	}
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		a.respondError(ctx, req.ID, errCodeInvalidParams, "invalid params", err.Error())
+		return
+	}
+	jobID, ok := parseJobResourceURI(params.URI)
+	if !ok {
+		a.respondError(ctx, req.ID, errCodeInvalidParams, "unsupported resource uri", params.URI)
+		return
+	}
+	page, err := a.client.GetJobResults(ctx, jobID, gosdk.ResultsQuery{})
+	if err != nil {
+		a.respondError(ctx, req.ID, errCodeInternalError, "read resource failed", err.Error())
+		return
+	}
+	body, err := json.MarshalIndent(page.Items, "", "  ")
+	if err != nil {
+		a.respondError(ctx, req.ID, errCodeInternalError, "encode resource failed", err.Error())
+		return
+	}
+	a.respondResult(ctx, req.ID, map[string]any{
+		"contents": []ResourceContent{{URI: params.URI, MimeType: "application/json", Text: string(body)}},
+	})
+}
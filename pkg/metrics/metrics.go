@@ -2,6 +2,7 @@ package metrics
 
 import (
 	"expvar"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -11,10 +12,30 @@ var (
 	providerCallCount   = expvar.NewMap("provider_call_count")
 	providerCallLatency = expvar.NewMap("provider_call_latency_ms")
 	providerCallErrors  = expvar.NewMap("provider_call_errors")
-	providerChunkCount  = expvar.NewMap("provider_chunk_count")
-	mapMu               sync.Mutex
+	// Calls abandoned because the job or step was cancelled or timed out;
+	// they are not counted as errors.
+	providerCallCancelled = expvar.NewMap("provider_call_cancelled")
+	providerChunkCount    = expvar.NewMap("provider_chunk_count")
+	// Per-model maps are keyed "<kind>/<model>".
+	providerModelCount     = expvar.NewMap("provider_model_call_count")
+	providerModelLatency   = expvar.NewMap("provider_model_call_latency_ms")
+	providerModelErrors    = expvar.NewMap("provider_model_call_errors")
+	providerModelCancelled = expvar.NewMap("provider_model_call_cancelled")
+	jobsEvicted            = expvar.NewInt("jobs_evicted")
+	// Job outcomes are keyed by terminal status; the per-pipeline map by
+	// "<pipeline_type>/<status>".
+	jobCount         = expvar.NewMap("job_count")
+	jobPipelineCount = expvar.NewMap("job_pipeline_count")
+	jobDuration      = expvar.NewMap("job_duration_ms")
+	// Jobs currently executing, keyed by pipeline type.
+	pipelineInFlight = expvar.NewMap("pipeline_in_flight")
+	mapMu            sync.Mutex
 )
 
+// jobDurationBuckets are the upper bounds (ms) of the job_duration_ms
+// histogram. Buckets are cumulative like Prometheus "le" buckets.
+var jobDurationBuckets = []int64{100, 500, 1000, 5000, 10000, 30000, 60000, 300000, 900000}
+
 // ObserveProviderCall records duration and success/failure of a provider call.
 func ObserveProviderCall(kind string, duration time.Duration, err error) {
 	key := normalize(kind)
@@ -25,6 +46,27 @@ func ObserveProviderCall(kind string, duration time.Duration, err error) {
 	}
 }
 
+// ObserveProviderModelCall records a provider call under both its kind (as
+// ObserveProviderCall does) and its kind/model pair.
+func ObserveProviderModelCall(kind, model string, duration time.Duration, err error) {
+	ObserveProviderCall(kind, duration, err)
+	key := normalize(kind) + "/" + normalize(model)
+	addInt(providerModelCount, key, 1)
+	addInt(providerModelLatency, key, duration.Milliseconds())
+	if err != nil {
+		addInt(providerModelErrors, key, 1)
+	}
+}
+
+// ObserveProviderModelCancelled records a provider call that ended because
+// its caller cancelled it or ran out of time. It counts as a call, under both
+// the kind and kind/model keys, but not as an error.
+func ObserveProviderModelCancelled(kind, model string, duration time.Duration) {
+	ObserveProviderModelCall(kind, model, duration, nil)
+	addInt(providerCallCancelled, normalize(kind), 1)
+	addInt(providerModelCancelled, normalize(kind)+"/"+normalize(model), 1)
+}
+
 // ObserveProviderChunks increments chunk counters for streaming output.
 func ObserveProviderChunks(kind string, count int) {
 	if count <= 0 {
@@ -33,6 +75,37 @@ func ObserveProviderChunks(kind string, count int) {
 	addInt(providerChunkCount, normalize(kind), int64(count))
 }
 
+// ObserveJobsEvicted increments the count of jobs removed by TTL expiry.
+func ObserveJobsEvicted(count int) {
+	if count <= 0 {
+		return
+	}
+	jobsEvicted.Add(int64(count))
+}
+
+// ObserveJobOutcome records a job reaching a terminal status after duration
+// (from creation, so queueing time is included).
+func ObserveJobOutcome(pipelineType, status string, duration time.Duration) {
+	status = normalize(status)
+	addInt(jobCount, status, 1)
+	addInt(jobPipelineCount, normalize(pipelineType)+"/"+status, 1)
+	ms := duration.Milliseconds()
+	for _, bound := range jobDurationBuckets {
+		if ms <= bound {
+			addInt(jobDuration, "le_"+strconv.FormatInt(bound, 10), 1)
+		}
+	}
+	addInt(jobDuration, "le_inf", 1)
+	addInt(jobDuration, "count", 1)
+	addInt(jobDuration, "sum", ms)
+}
+
+// ObservePipelineInFlight adjusts the number of executing jobs of a pipeline
+// type by delta (+1 when a job starts, -1 when it finishes).
+func ObservePipelineInFlight(pipelineType string, delta int) {
+	addInt(pipelineInFlight, normalize(pipelineType), int64(delta))
+}
+
 func normalize(kind string) string {
 	if strings.TrimSpace(kind) == "" {
 		return "unknown"
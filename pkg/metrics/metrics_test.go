@@ -16,6 +16,70 @@ func TestObserveProviderCall(t *testing.T) {
 	}
 }
 
+func TestObservePipelineInFlight(t *testing.T) {
+	ObservePipelineInFlight("summary.v1", 1)
+	ObservePipelineInFlight("summary.v1", 1)
+	ObservePipelineInFlight("summary.v1", -1)
+	if val := pipelineInFlight.Get("summary.v1"); val == nil || val.String() != "1" {
+		t.Fatalf("expected 1 in-flight job, got %v", val)
+	}
+}
+
+func TestObserveProviderModelCall(t *testing.T) {
+	ObserveProviderModelCall("ollama-model", "llama3", 4*time.Millisecond, nil)
+	ObserveProviderModelCall("ollama-model", "llama3", 6*time.Millisecond, assertError{})
+	ObserveProviderModelCall("ollama-model", "", time.Millisecond, nil)
+	if val := providerCallCount.Get("ollama-model"); val == nil || val.String() != "3" {
+		t.Fatalf("expected kind-level call count 3, got %v", val)
+	}
+	if val := providerModelCount.Get("ollama-model/llama3"); val == nil || val.String() != "2" {
+		t.Fatalf("expected model call count 2, got %v", val)
+	}
+	if val := providerModelLatency.Get("ollama-model/llama3"); val == nil || val.String() != "10" {
+		t.Fatalf("expected model latency 10, got %v", val)
+	}
+	if val := providerModelErrors.Get("ollama-model/llama3"); val == nil || val.String() != "1" {
+		t.Fatalf("expected model error count 1, got %v", val)
+	}
+	if val := providerModelCount.Get("ollama-model/unknown"); val == nil || val.String() != "1" {
+		t.Fatalf("expected empty model to be recorded as unknown, got %v", val)
+	}
+}
+
+func TestObserveProviderModelCancelled(t *testing.T) {
+	ObserveProviderModelCall("cancel-kind", "m", time.Millisecond, assertError{})
+	ObserveProviderModelCancelled("cancel-kind", "m", 2*time.Millisecond)
+	if val := providerCallCount.Get("cancel-kind"); val == nil || val.String() != "2" {
+		t.Fatalf("expected call count 2, got %v", val)
+	}
+	if val := providerCallErrors.Get("cancel-kind"); val == nil || val.String() != "1" {
+		t.Fatalf("cancelled call must not count as an error, got %v", val)
+	}
+	if val := providerCallCancelled.Get("cancel-kind"); val == nil || val.String() != "1" {
+		t.Fatalf("expected cancelled count 1, got %v", val)
+	}
+	if val := providerModelCancelled.Get("cancel-kind/m"); val == nil || val.String() != "1" {
+		t.Fatalf("expected model cancelled count 1, got %v", val)
+	}
+}
+
+func TestObserveJobOutcome(t *testing.T) {
+	ObserveJobOutcome("outcome.v1", "succeeded", 80*time.Millisecond)
+	ObserveJobOutcome("outcome.v1", "failed", 2*time.Second)
+	ObserveJobOutcome("outcome.v1", "succeeded", time.Hour)
+	if val := jobCount.Get("succeeded"); val == nil || val.String() != "2" {
+		t.Fatalf("expected succeeded count 2, got %v", val)
+	}
+	if val := jobPipelineCount.Get("outcome.v1/failed"); val == nil || val.String() != "1" {
+		t.Fatalf("expected pipeline failed count 1, got %v", val)
+	}
+	for key, want := range map[string]string{"le_100": "1", "le_5000": "2", "le_900000": "2", "le_inf": "3", "count": "3", "sum": "3602080"} {
+		if val := jobDuration.Get(key); val == nil || val.String() != want {
+			t.Fatalf("expected %s=%s, got %v", key, want, val)
+		}
+	}
+}
+
 func TestObserveProviderChunks(t *testing.T) {
 	ObserveProviderChunks("ollama", 3)
 	if val := providerChunkCount.Get("ollama"); val == nil || val.String() != "3" {
@@ -0,0 +1,328 @@
+// Package pipeline builds engine.PipelineDef values with a fluent API that
+// checks the pipeline's structure as steps are added:
+//
+//	def, err := pipeline.New("notes.summarize.v1").
+//		DefaultProfile("openai-default").
+//		Step("summarize").Prompt("", "Summarize:\n{{range .Sources}}{{.Content}}\n{{end}}").
+//		Step("polish").DependsOn("summarize").InputMapping("summary", "/summarize/0/data/text").
+//		Prompt("", "Polish this:\n{{.Vars.summary}}").Output(engine.ContentMarkdown).Export().
+//		Build()
+//
+// Step-level methods apply to the step most recently added with Step. The
+// builder enforces unique step IDs and that dependencies, input bindings and
+// input mappings refer to steps defined earlier; provider profiles and step
+// config are still checked by BasicEngine.ValidatePipeline at registration.
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/example/pipeline-engine/internal/engine"
+)
+
+// DefaultVersion is the version of pipelines built without calling Version.
+const DefaultVersion = "v1"
+
+// Builder accumulates a PipelineDef. Mistakes are recorded as they happen and
+// reported together by Build, so calls can be chained without checking errors.
+type Builder struct {
+	def  engine.PipelineDef
+	ids  map[engine.StepID]bool
+	errs []error
+}
+
+// New starts a pipeline of the given type. New steps are LLM steps in single
+// mode producing text until changed with Kind, Mode or Output.
+func New(pipelineType engine.PipelineType) *Builder {
+	b := &Builder{
+		def: engine.PipelineDef{Type: pipelineType, Version: DefaultVersion},
+		ids: map[engine.StepID]bool{},
+	}
+	if pipelineType == "" {
+		b.errs = append(b.errs, errors.New("pipeline type must not be empty"))
+	}
+	return b
+}
+
+// Version sets the pipeline version.
+func (b *Builder) Version(version string) *Builder {
+	if version == "" {
+		b.errs = append(b.errs, errors.New("pipeline version must not be empty"))
+		return b
+	}
+	b.def.Version = version
+	return b
+}
+
+// DefaultProfile sets the provider profile of steps without their own.
+func (b *Builder) DefaultProfile(id engine.ProviderProfileID) *Builder {
+	b.def.DefaultProviderProfileID = id
+	return b
+}
+
+// ExportSink names the registered export sink exported items are written to.
+func (b *Builder) ExportSink(name string) *Builder {
+	b.def.ExportSink = name
+	return b
+}
+
+// RequireSources rejects jobs whose sources do not satisfy req.
+func (b *Builder) RequireSources(req engine.SourceRequirement) *Builder {
+	if req.MinCount < 0 {
+		b.errs = append(b.errs, fmt.Errorf("required sources min count must not be negative: %d", req.MinCount))
+		return b
+	}
+	req.Kinds = slices.Clone(req.Kinds)
+	b.def.RequiredSources = &req
+	return b
+}
+
+// MaxConcurrency caps how many jobs of the pipeline execute at once.
+func (b *Builder) MaxConcurrency(n int) *Builder {
+	if n < 0 {
+		b.errs = append(b.errs, fmt.Errorf("max concurrency must not be negative: %d", n))
+		return b
+	}
+	b.def.MaxConcurrency = n
+	return b
+}
+
+// Step appends a step and makes it the target of the step-level methods.
+func (b *Builder) Step(id engine.StepID) *Builder {
+	switch {
+	case id == "":
+		b.errs = append(b.errs, fmt.Errorf("step %d: id must not be empty", len(b.def.Steps)))
+	case b.ids[id]:
+		b.errs = append(b.errs, fmt.Errorf("step %s: duplicate step id", id))
+	}
+	b.ids[id] = true
+	b.def.Steps = append(b.def.Steps, engine.StepDef{
+		ID:         id,
+		Kind:       engine.StepKindLLM,
+		Mode:       engine.StepModeSingle,
+		OutputType: engine.ContentText,
+	})
+	return b
+}
+
+// Name sets the current step's display name.
+func (b *Builder) Name(name string) *Builder {
+	return b.update("Name", func(step *engine.StepDef) error {
+		step.Name = name
+		return nil
+	})
+}
+
+// Kind sets the current step's kind.
+func (b *Builder) Kind(kind engine.StepKind) *Builder {
+	return b.update("Kind", func(step *engine.StepDef) error {
+		step.Kind = kind
+		return nil
+	})
+}
+
+// Mode sets the current step's mode.
+func (b *Builder) Mode(mode engine.StepMode) *Builder {
+	return b.update("Mode", func(step *engine.StepDef) error {
+		step.Mode = mode
+		return nil
+	})
+}
+
+// Profile sets the current step's provider profile and the profiles tried
+// in order when it fails.
+func (b *Builder) Profile(id engine.ProviderProfileID, fallbacks ...engine.ProviderProfileID) *Builder {
+	return b.update("Profile", func(step *engine.StepDef) error {
+		if id == "" {
+			return errors.New("provider profile id must not be empty")
+		}
+		step.ProviderProfileID = id
+		step.Fallbacks = slices.Clone(fallbacks)
+		return nil
+	})
+}
+
+// Prompt sets the current step's system and user prompt templates.
+func (b *Builder) Prompt(system, user string) *Builder {
+	return b.update("Prompt", func(step *engine.StepDef) error {
+		if step.Prompt == nil {
+			step.Prompt = &engine.PromptTemplate{}
+		}
+		step.Prompt.System = system
+		step.Prompt.User = user
+		return nil
+	})
+}
+
+// DependsOn adds dependencies of the current step on steps defined earlier.
+func (b *Builder) DependsOn(ids ...engine.StepID) *Builder {
+	return b.update("DependsOn", func(step *engine.StepDef) error {
+		var errs []error
+		for _, id := range ids {
+			if err := b.checkEarlier(step, id); err != nil {
+				errs = append(errs, fmt.Errorf("depends on %w", err))
+				continue
+			}
+			if !slices.Contains(step.DependsOn, id) {
+				step.DependsOn = append(step.DependsOn, id)
+			}
+		}
+		return errors.Join(errs...)
+	})
+}
+
+// InputFrom binds the outputs of an earlier step to .Inputs.<name>.
+func (b *Builder) InputFrom(name string, from engine.StepID) *Builder {
+	return b.bindInput(engine.InputBinding{Name: name, Step: from})
+}
+
+// InputFromEach binds the outputs of an earlier step to .Inputs.<name> and
+// makes a per_item step iterate over them.
+func (b *Builder) InputFromEach(name string, from engine.StepID) *Builder {
+	return b.bindInput(engine.InputBinding{Name: name, Step: from, PerItem: true})
+}
+
+func (b *Builder) bindInput(binding engine.InputBinding) *Builder {
+	return b.update("InputFrom", func(step *engine.StepDef) error {
+		if binding.Name == "" {
+			return fmt.Errorf("input binding for step %s has no name", binding.Step)
+		}
+		for _, existing := range step.InputFrom {
+			if existing.Name == binding.Name {
+				return fmt.Errorf("duplicate input binding %s", binding.Name)
+			}
+			if binding.PerItem && existing.PerItem {
+				return fmt.Errorf("input %s: only one input binding can be per_item", binding.Name)
+			}
+		}
+		if err := b.checkEarlier(step, binding.Step); err != nil {
+			return fmt.Errorf("input %s references %w", binding.Name, err)
+		}
+		step.InputFrom = append(step.InputFrom, binding)
+		return nil
+	})
+}
+
+// InputMapping binds .Vars.<name> to a JSON pointer into the outputs of an
+// earlier step, e.g. "/classify/0/data/route".
+func (b *Builder) InputMapping(name, pointer string) *Builder {
+	return b.update("InputMapping", func(step *engine.StepDef) error {
+		if name == "" {
+			return fmt.Errorf("input mapping %s has no name", pointer)
+		}
+		if !strings.HasPrefix(pointer, "/") {
+			return fmt.Errorf("input mapping %s: json pointer %q must start with /", name, pointer)
+		}
+		first, _, _ := strings.Cut(pointer[1:], "/")
+		from := engine.StepID(strings.ReplaceAll(strings.ReplaceAll(first, "~1", "/"), "~0", "~"))
+		if err := b.checkEarlier(step, from); err != nil {
+			return fmt.Errorf("input mapping %s references %w", name, err)
+		}
+		if step.InputMapping == nil {
+			step.InputMapping = map[string]string{}
+		}
+		step.InputMapping[name] = pointer
+		return nil
+	})
+}
+
+// Output sets the content type the current step produces.
+func (b *Builder) Output(contentType engine.ContentType) *Builder {
+	return b.update("Output", func(step *engine.StepDef) error {
+		step.OutputType = contentType
+		return nil
+	})
+}
+
+// Config sets a key of the current step's config, e.g. "timeout_ms".
+func (b *Builder) Config(key string, value any) *Builder {
+	return b.update("Config", func(step *engine.StepDef) error {
+		if step.Config == nil {
+			step.Config = map[string]any{}
+		}
+		step.Config[key] = value
+		return nil
+	})
+}
+
+// PostProcess appends output transforms such as "extract_json".
+func (b *Builder) PostProcess(names ...string) *Builder {
+	return b.update("PostProcess", func(step *engine.StepDef) error {
+		step.PostProcess = append(step.PostProcess, names...)
+		return nil
+	})
+}
+
+// Export includes the current step's results in the job result.
+func (b *Builder) Export() *Builder {
+	return b.update("Export", func(step *engine.StepDef) error {
+		step.Export = true
+		return nil
+	})
+}
+
+// ExportTag exports the current step's results under tag.
+func (b *Builder) ExportTag(tag string) *Builder {
+	return b.update("ExportTag", func(step *engine.StepDef) error {
+		step.Export = true
+		step.ExportTag = tag
+		return nil
+	})
+}
+
+// Configure edits the current step directly, for fields without a builder
+// method such as Tools or ModelTiers. Changes to the ID are rejected.
+func (b *Builder) Configure(fn func(step *engine.StepDef)) *Builder {
+	return b.update("Configure", func(step *engine.StepDef) error {
+		id := step.ID
+		fn(step)
+		if step.ID != id {
+			step.ID = id
+			return errors.New("Configure must not change the step id")
+		}
+		return nil
+	})
+}
+
+// Build returns the pipeline definition, or every problem found while it was
+// built.
+func (b *Builder) Build() (engine.PipelineDef, error) {
+	errs := b.errs
+	if len(b.def.Steps) == 0 {
+		errs = append(errs, fmt.Errorf("pipeline %s has no steps", b.def.Type))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return engine.PipelineDef{}, err
+	}
+	def := b.def
+	def.Steps = slices.Clone(b.def.Steps)
+	return def, nil
+}
+
+// update applies fn to the current step, recording an error when no step has
+// been added yet or fn rejects its arguments.
+func (b *Builder) update(method string, fn func(step *engine.StepDef) error) *Builder {
+	if len(b.def.Steps) == 0 {
+		b.errs = append(b.errs, fmt.Errorf("%s called before Step", method))
+		return b
+	}
+	step := &b.def.Steps[len(b.def.Steps)-1]
+	if err := fn(step); err != nil {
+		b.errs = append(b.errs, fmt.Errorf("step %s: %w", step.ID, err))
+	}
+	return b
+}
+
+// checkEarlier returns an error unless id names a step added before step.
+func (b *Builder) checkEarlier(step *engine.StepDef, id engine.StepID) error {
+	if id == step.ID {
+		return fmt.Errorf("step %s, which is the step itself", id)
+	}
+	if !b.ids[id] {
+		return fmt.Errorf("step %s, which is not defined earlier", id)
+	}
+	return nil
+}
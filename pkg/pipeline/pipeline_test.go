@@ -0,0 +1,128 @@
+package pipeline_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/example/pipeline-engine/internal/engine"
+	"github.com/example/pipeline-engine/pkg/enginetest"
+	"github.com/example/pipeline-engine/pkg/pipeline"
+)
+
+func TestBuilderBuildsPipelineDef(t *testing.T) {
+	def, err := pipeline.New("notes.review").
+		Version("v2").
+		DefaultProfile(enginetest.StubProfileID).
+		RequireSources(engine.SourceRequirement{MinCount: 1}).
+		MaxConcurrency(2).
+		Step("classify").Name("Classify").Output(engine.ContentJSON).PostProcess("extract_json").
+		Step("draft").DependsOn("classify").InputMapping("route", "/classify/0/data/route").
+		Prompt("system", "route={{.Vars.route}}").Config("timeout_ms", 1000).
+		Step("final").Profile("primary", "backup").DependsOn("draft", "draft").InputFrom("draft", "draft").ExportTag("answer").
+		Build()
+	if err != nil {
+		t.Fatalf("Build に失敗しました: %v", err)
+	}
+	if def.Type != "notes.review" || def.Version != "v2" || def.DefaultProviderProfileID != enginetest.StubProfileID || def.RequiredSources.MinCount != 1 || def.MaxConcurrency != 2 {
+		t.Fatalf("パイプライン設定が想定外です: %+v", def)
+	}
+	if len(def.Steps) != 3 {
+		t.Fatalf("ステップ数が想定外です: %d", len(def.Steps))
+	}
+	classify, draft, final := def.Steps[0], def.Steps[1], def.Steps[2]
+	if classify.Kind != engine.StepKindLLM || classify.Mode != engine.StepModeSingle || classify.OutputType != engine.ContentJSON || classify.Name != "Classify" || classify.PostProcess[0] != "extract_json" {
+		t.Fatalf("classify ステップが想定外です: %+v", classify)
+	}
+	if draft.DependsOn[0] != "classify" || draft.InputMapping["route"] != "/classify/0/data/route" || draft.Prompt.User != "route={{.Vars.route}}" || draft.Config["timeout_ms"] != 1000 || draft.Export {
+		t.Fatalf("draft ステップが想定外です: %+v", draft)
+	}
+	if len(final.DependsOn) != 1 || final.ProviderProfileID != "primary" || final.Fallbacks[0] != "backup" || final.InputFrom[0].Step != "draft" || !final.Export || final.ExportTag != "answer" {
+		t.Fatalf("final ステップが想定外です: %+v", final)
+	}
+}
+
+func TestBuilderReportsStructuralErrors(t *testing.T) {
+	cases := []struct {
+		name    string
+		builder *pipeline.Builder
+		want    []string
+	}{
+		{"ステップなし", pipeline.New("empty"), []string{"has no steps"}},
+		{"ステップ前の呼び出し", pipeline.New("p").Export().Step("a"), []string{"Export called before Step"}},
+		{"重複 ID", pipeline.New("p").Step("a").Step("a"), []string{"step a: duplicate step id"}},
+		{"未定義の依存", pipeline.New("p").Step("a").DependsOn("b").Step("b"), []string{"step a: depends on step b, which is not defined earlier"}},
+		{"自己依存", pipeline.New("p").Step("a").DependsOn("a"), []string{"which is the step itself"}},
+		{"不正な入力", pipeline.New("p").Step("a").Step("b").InputFrom("", "a").InputFrom("x", "c").InputMapping("v", "a/0"), []string{
+			"input binding for step a has no name",
+			"input x references step c",
+			"must start with /",
+		}},
+		{"ID の変更", pipeline.New("p").Step("a").Configure(func(step *engine.StepDef) { step.ID = "b" }), []string{"must not change the step id"}},
+	}
+	for _, tc := range cases {
+		_, err := tc.builder.Build()
+		if err == nil {
+			t.Fatalf("%s: エラーが返されていません", tc.name)
+		}
+		for _, want := range tc.want {
+			if !strings.Contains(err.Error(), want) {
+				t.Fatalf("%s: エラーに %q が含まれていません: %v", tc.name, want, err)
+			}
+		}
+	}
+}
+
+func TestBuilderPipelineRunsOnEngine(t *testing.T) {
+	stub := enginetest.NewStubProvider().Respond("draft", enginetest.StubResponse{Output: "下書き"})
+	eng := enginetest.NewEngine(t, stub)
+	def, err := pipeline.New("builder.run").
+		DefaultProfile(enginetest.StubProfileID).
+		Step("draft").
+		Step("final").DependsOn("draft").InputMapping("draft", "/draft/0/data/text").Prompt("", "清書: {{.Vars.draft}}").Export().
+		Build()
+	if err != nil {
+		t.Fatalf("Build に失敗しました: %v", err)
+	}
+	if err := eng.ValidatePipeline(def); err != nil {
+		t.Fatalf("ValidatePipeline が失敗しました: %v", err)
+	}
+	eng.RegisterPipeline(def)
+
+	job, err := eng.RunJob(context.Background(), engine.JobRequest{
+		PipelineType: "builder.run",
+		Mode:         "sync",
+		Input:        engine.JobInput{Sources: []engine.Source{{Kind: engine.SourceKindNote, Content: "memo"}}},
+	})
+	if err != nil || job.Status != engine.JobStatusSucceeded {
+		t.Fatalf("ジョブが成功していません: %v %+v", err, job)
+	}
+	if calls := stub.CallsFor("final"); len(calls) != 1 || calls[0].Prompt != "清書: 下書き" {
+		t.Fatalf("マッピングされたプロンプトが想定外です: %+v", calls)
+	}
+}
+
+func ExampleNew() {
+	def, err := pipeline.New("notes.summarize.v1").
+		DefaultProfile("openai-default").
+		Step("summarize").
+		Prompt("You are a concise assistant.", "Summarize:\n{{range .Sources}}{{.Content}}\n{{end}}").
+		Step("polish").
+		DependsOn("summarize").
+		InputMapping("summary", "/summarize/0/data/text").
+		Prompt("", "Polish this summary:\n{{.Vars.summary}}").
+		Output(engine.ContentMarkdown).
+		Export().
+		Build()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, step := range def.Steps {
+		fmt.Println(step.ID, step.OutputType, step.DependsOn, step.Export)
+	}
+	// Output:
+	// summarize text [] false
+	// polish markdown [summarize] true
+}
@@ -9,6 +9,9 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -25,6 +28,7 @@ type Client struct {
 type RerunRequest struct {
 	FromStepID    *engine.StepID   `json:"from_step_id,omitempty"`
 	ReuseUpstream bool             `json:"reuse_upstream,omitempty"`
+	ReuseSteps    []engine.StepID  `json:"reuse_steps,omitempty"`
 	OverrideInput *engine.JobInput `json:"override_input,omitempty"`
 }
 
@@ -43,15 +47,148 @@ func (c *Client) CreateJob(ctx context.Context, req engine.JobRequest) (*engine.
 	return c.postJob(ctx, "/v1/jobs", req)
 }
 
+// IsRetryableJobError reports whether a failed job or step error is a
+// transient provider failure worth resubmitting: a provider network error, or
+// a provider API error with status 429 or 5xx. Decode errors and other codes
+// are not retryable.
+func IsRetryableJobError(jobErr *engine.JobError) bool {
+	if jobErr == nil {
+		return false
+	}
+	switch jobErr.Code {
+	case engine.ErrCodeProviderNetwork:
+		return true
+	case engine.ErrCodeProviderAPI:
+		details, _ := jobErr.Details.(map[string]any)
+		var status int
+		switch v := details["status_code"].(type) {
+		case float64:
+			status = int(v)
+		case int:
+			status = v
+		}
+		return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+	default:
+		return false
+	}
+}
+
+// BatchError describes why a single request within a batch was rejected.
+type BatchError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// BatchItem is the outcome of one request in a batch submission. Exactly one
+// of Job or Error is set.
+type BatchItem struct {
+	Index int         `json:"index"`
+	Job   *engine.Job `json:"job,omitempty"`
+	Error *BatchError `json:"error,omitempty"`
+}
+
+// BatchResult is the response of POST /v1/jobs/batch.
+type BatchResult struct {
+	BatchID string      `json:"batch_id"`
+	Items   []BatchItem `json:"items"`
+	Created int         `json:"created"`
+	Failed  int         `json:"failed"`
+}
+
+// CreateJobs submits several job requests in one POST /v1/jobs/batch call.
+// Per-request failures are reported in the returned items rather than as an error.
+func (c *Client) CreateJobs(ctx context.Context, reqs []engine.JobRequest) (*BatchResult, error) {
+	body, err := json.Marshal(reqs)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/v1/jobs/batch", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient().Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("http error: %s", resp.Status)
+	}
+
+	var result BatchResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
 // GetJob retrieves a job via GET /v1/jobs/{id}.
 func (c *Client) GetJob(ctx context.Context, jobID string) (*engine.Job, error) {
-	url := fmt.Sprintf("%s/v1/jobs/%s", c.BaseURL, jobID)
+	return c.getJob(ctx, fmt.Sprintf("%s/v1/jobs/%s", c.BaseURL, jobID))
+}
+
+// GetJobWithoutResults retrieves a job via GET /v1/jobs/{id}?include_results=false,
+// omitting result items. Use GetJobResults to page through them separately.
+func (c *Client) GetJobWithoutResults(ctx context.Context, jobID string) (*engine.Job, error) {
+	return c.getJob(ctx, fmt.Sprintf("%s/v1/jobs/%s?include_results=false", c.BaseURL, jobID))
+}
+
+// ErrWaitTimeout is returned by WaitForJob, together with the job's latest
+// state, when the job is still running at the timeout.
+var ErrWaitTimeout = errors.New("job did not finish before the wait timeout")
+
+// maxWaitPerRequest matches the server's cap on a single GET
+// /v1/jobs/{id}/wait; WaitForJob repeats the request for longer waits.
+const maxWaitPerRequest = 60 * time.Second
+
+// WaitForJob blocks until the job reaches a terminal status via the GET
+// /v1/jobs/{id}/wait long poll instead of polling GetJob. When timeout
+// elapses first, the job's latest state is returned with ErrWaitTimeout.
+func (c *Client) WaitForJob(ctx context.Context, jobID string, timeout time.Duration) (*engine.Job, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		wait := min(time.Until(deadline), maxWaitPerRequest)
+		if wait < 0 {
+			wait = 0
+		}
+		endpoint := fmt.Sprintf("%s/v1/jobs/%s/wait?%s", c.BaseURL, jobID, url.Values{"timeout": {wait.String()}}.Encode())
+		job, err := c.getJobWithin(ctx, endpoint, wait)
+		if err != nil {
+			return nil, err
+		}
+		switch job.Status {
+		case engine.JobStatusSucceeded, engine.JobStatusFailed, engine.JobStatusCancelled:
+			return job, nil
+		}
+		if !time.Now().Before(deadline) {
+			return job, ErrWaitTimeout
+		}
+	}
+}
+
+func (c *Client) getJob(ctx context.Context, url string) (*engine.Job, error) {
+	return c.getJobWithin(ctx, url, 0)
+}
+
+// getJobWithin fetches a job from url, extending the HTTP client timeout so
+// a request the server may hold open for wait is not cut short.
+func (c *Client) getJobWithin(ctx context.Context, url string, wait time.Duration) (*engine.Job, error) {
 	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	resp, err := c.httpClient().Do(httpReq)
+	client := c.httpClient()
+	if wait > 0 && client.Timeout > 0 {
+		extended := *client
+		extended.Timeout += wait
+		client = &extended
+	}
+	resp, err := client.Do(httpReq)
 	if err != nil {
 		return nil, err
 	}
@@ -64,10 +201,84 @@ func (c *Client) GetJob(ctx context.Context, jobID string) (*engine.Job, error)
 	return decodeJob(resp.Body)
 }
 
+// ResultsQuery filters and paginates GET /v1/jobs/{id}/results.
+type ResultsQuery struct {
+	Tag    string
+	Limit  int
+	Offset int
+}
+
+// ResultsPage is a page of exported result items.
+type ResultsPage struct {
+	JobID  string              `json:"job_id"`
+	Tag    string              `json:"tag,omitempty"`
+	Items  []engine.ResultItem `json:"items"`
+	Total  int                 `json:"total"`
+	Limit  int                 `json:"limit,omitempty"`
+	Offset int                 `json:"offset"`
+}
+
+// GetJobResults retrieves a page of exported results via GET /v1/jobs/{id}/results
+// without the surrounding job envelope. A non-empty Tag limits the results to
+// items exported with that ExportTag; a zero Limit returns every remaining item.
+func (c *Client) GetJobResults(ctx context.Context, jobID string, query ResultsQuery) (*ResultsPage, error) {
+	params := url.Values{}
+	if query.Tag != "" {
+		params.Set("tag", query.Tag)
+	}
+	if query.Limit > 0 {
+		params.Set("limit", strconv.Itoa(query.Limit))
+	}
+	if query.Offset > 0 {
+		params.Set("offset", strconv.Itoa(query.Offset))
+	}
+	endpoint := fmt.Sprintf("%s/v1/jobs/%s/results", c.BaseURL, jobID)
+	if len(params) > 0 {
+		endpoint += "?" + params.Encode()
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient().Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("http error: %s", resp.Status)
+	}
+
+	var page ResultsPage
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
 // CancelJob cancels the job via POST /v1/jobs/{id}/cancel.
 func (c *Client) CancelJob(ctx context.Context, jobID string, reason string) (*engine.Job, error) {
+	return c.CancelJobWithDetails(ctx, jobID, engine.Cancellation{Reason: reason})
+}
+
+// CancelJobWithDetails cancels the job with a structured cancellation (who
+// cancelled and an optional code). At is assigned by the server. With
+// cancellation.Strict, cancelling a finished job returns an error wrapping
+// engine.ErrJobTerminal instead of the unchanged job.
+func (c *Client) CancelJobWithDetails(ctx context.Context, jobID string, cancellation engine.Cancellation) (*engine.Job, error) {
 	url := fmt.Sprintf("%s/v1/jobs/%s/cancel", c.BaseURL, jobID)
-	payload := map[string]string{"reason": reason}
+	payload := map[string]any{"reason": cancellation.Reason}
+	if cancellation.By != "" {
+		payload["by"] = string(cancellation.By)
+	}
+	if cancellation.Code != "" {
+		payload["code"] = cancellation.Code
+	}
+	if cancellation.Strict {
+		payload["strict"] = true
+	}
 	body, err := json.Marshal(payload)
 	if err != nil {
 		return nil, err
@@ -85,6 +296,36 @@ func (c *Client) CancelJob(ctx context.Context, jobID string, reason string) (*e
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusConflict {
+		var apiErr struct {
+			Error engine.JobError `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&apiErr); err == nil && apiErr.Error.Code == "job_terminal" {
+			return nil, fmt.Errorf("%w: %s", engine.ErrJobTerminal, apiErr.Error.Message)
+		}
+	}
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("http error: %s", resp.Status)
+	}
+
+	return decodeJob(resp.Body)
+}
+
+// SkipStep triggers POST /v1/jobs/{id}/steps/{stepID}/skip to abandon a running
+// step while letting the rest of the job continue.
+func (c *Client) SkipStep(ctx context.Context, jobID string, stepID engine.StepID) (*engine.Job, error) {
+	url := fmt.Sprintf("%s/v1/jobs/%s/steps/%s/skip", c.BaseURL, jobID, stepID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
 	if resp.StatusCode >= 400 {
 		return nil, fmt.Errorf("http error: %s", resp.Status)
 	}
@@ -195,22 +436,12 @@ func (c *Client) StreamJobs(ctx context.Context, req engine.JobRequest) (<-chan
 		closeFn()
 		return nil, nil, err
 	}
-	job, ok := jobEvent.Data.(map[string]interface{})
+	queued, ok := jobEvent.Data.(engine.JobStatusEvent)
 	if !ok {
 		closeFn()
 		return nil, nil, errors.New("invalid job_queued payload")
 	}
-	jobBytes, err := json.Marshal(job)
-	if err != nil {
-		closeFn()
-		return nil, nil, err
-	}
-	var jobStruct engine.Job
-	if err := json.Unmarshal(jobBytes, &jobStruct); err != nil {
-		closeFn()
-		return nil, nil, err
-	}
-	return eventsCh, &jobStruct, nil
+	return eventsCh, &queued.Job, nil
 }
 
 func readNDJSONStream(resp *http.Response) ([]byte, chan engine.StreamingEvent, func(), error) {
@@ -320,7 +551,11 @@ func (c *Client) StreamJobByID(ctx context.Context, jobID string, afterSeq ...ui
 			if unmarshalErr := json.Unmarshal(trimmed, &evt); unmarshalErr != nil {
 				return
 			}
-			ch <- evt
+			select {
+			case ch <- evt:
+			case <-ctx.Done():
+				return
+			}
 			if err != nil {
 				return
 			}
@@ -329,6 +564,112 @@ func (c *Client) StreamJobByID(ctx context.Context, jobID string, afterSeq ...ui
 	return ch, nil
 }
 
+// ListJobs lists stored jobs via GET /v1/jobs. A non-empty parentJobID limits
+// the listing to reruns created directly from that job.
+func (c *Client) ListJobs(ctx context.Context, parentJobID string) ([]*engine.Job, error) {
+	endpoint := c.BaseURL + "/v1/jobs"
+	if parentJobID != "" {
+		endpoint += "?" + url.Values{"parent_job_id": {parentJobID}}.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("http error: %s", resp.Status)
+	}
+	var payload struct {
+		Jobs []*engine.Job `json:"jobs"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		return nil, err
+	}
+	return payload.Jobs, nil
+}
+
+// GetJobLineage returns the rerun ancestors and children of a job via
+// GET /v1/jobs/{id}/lineage.
+func (c *Client) GetJobLineage(ctx context.Context, jobID string) (*engine.JobLineage, error) {
+	endpoint := fmt.Sprintf("%s/v1/jobs/%s/lineage", c.BaseURL, jobID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("http error: %s", resp.Status)
+	}
+	var lineage engine.JobLineage
+	if err := json.NewDecoder(resp.Body).Decode(&lineage); err != nil {
+		return nil, err
+	}
+	return &lineage, nil
+}
+
+// GetJobDiff compares the exported items of a job with those of a related
+// job via GET /v1/jobs/{id}/diff. An empty againstID compares with the job's
+// parent.
+func (c *Client) GetJobDiff(ctx context.Context, jobID, againstID string) (*engine.JobDiff, error) {
+	endpoint := fmt.Sprintf("%s/v1/jobs/%s/diff", c.BaseURL, jobID)
+	if againstID != "" {
+		endpoint += "?" + url.Values{"against": {againstID}}.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("http error: %s", resp.Status)
+	}
+	var diff engine.JobDiff
+	if err := json.NewDecoder(resp.Body).Decode(&diff); err != nil {
+		return nil, err
+	}
+	return &diff, nil
+}
+
+// GetJobEvents returns the server's logged streaming events for a job after
+// afterSeq via GET /v1/jobs/{id}/events. Pass 0 for the full history.
+func (c *Client) GetJobEvents(ctx context.Context, jobID string, afterSeq uint64) ([]engine.StreamingEvent, error) {
+	endpoint := fmt.Sprintf("%s/v1/jobs/%s/events", c.BaseURL, jobID)
+	if afterSeq > 0 {
+		endpoint += "?" + url.Values{"after_seq": {strconv.FormatUint(afterSeq, 10)}}.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("http error: %s", resp.Status)
+	}
+	var payload struct {
+		Events []engine.StreamingEvent `json:"events"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		return nil, err
+	}
+	return payload.Events, nil
+}
+
 func (c *Client) ListPipelines(ctx context.Context) ([]engine.PipelineDef, error) {
 	url := c.BaseURL + "/v1/config/pipelines"
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
@@ -352,6 +693,36 @@ func (c *Client) ListPipelines(ctx context.Context) ([]engine.PipelineDef, error
 	return payload.Pipelines, nil
 }
 
+// LintPipeline checks def via POST /v1/config/pipelines/lint without
+// registering it.
+func (c *Client) LintPipeline(ctx context.Context, def engine.PipelineDef) ([]engine.Finding, error) {
+	body, err := json.Marshal(def)
+	if err != nil {
+		return nil, err
+	}
+	url := c.BaseURL + "/v1/config/pipelines/lint"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("http error: %s", resp.Status)
+	}
+	var payload struct {
+		Findings []engine.Finding `json:"findings"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		return nil, err
+	}
+	return payload.Findings, nil
+}
+
 func (c *Client) GetMetrics(ctx context.Context) (map[string]map[string]int64, error) {
 	url := c.BaseURL + "/v1/metrics"
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
@@ -372,3 +743,53 @@ func (c *Client) GetMetrics(ctx context.Context) (map[string]map[string]int64, e
 	}
 	return payload, nil
 }
+
+// ErrCapabilitiesUnsupported is returned by GetCapabilities when the server
+// predates GET /v1/capabilities; callers should assume its feature set.
+var ErrCapabilitiesUnsupported = errors.New("server does not expose /v1/capabilities")
+
+// Capabilities is the body of GET /v1/capabilities.
+type Capabilities struct {
+	Version string `json:"version"`
+	engine.Capabilities
+}
+
+// SupportsEvent reports whether the server emits the named stream event.
+func (c *Capabilities) SupportsEvent(name string) bool {
+	return slices.Contains(c.Events, name)
+}
+
+// SupportsStepKind reports whether the server runs steps of kind.
+func (c *Capabilities) SupportsStepKind(kind engine.StepKind) bool {
+	return slices.Contains(c.StepKinds, kind)
+}
+
+// SupportsJobMode reports whether the server accepts JobRequest.Mode mode.
+func (c *Capabilities) SupportsJobMode(mode string) bool {
+	return slices.Contains(c.JobModes, mode)
+}
+
+// GetCapabilities fetches the server version and supported modes, kinds and
+// events via GET /v1/capabilities.
+func (c *Client) GetCapabilities(ctx context.Context) (*Capabilities, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+"/v1/capabilities", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrCapabilitiesUnsupported
+	}
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("http error: %s", resp.Status)
+	}
+	var payload Capabilities
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		return nil, err
+	}
+	return &payload, nil
+}
@@ -3,10 +3,13 @@ package gosdk
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/example/pipeline-engine/internal/engine"
 )
@@ -84,6 +87,28 @@ func TestClientRerunAndCancelJob(t *testing.T) {
 	}
 }
 
+func TestClientStrictCancelOfFinishedJob(t *testing.T) {
+	t.Parallel()
+
+	var payload map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		_, _ = w.Write([]byte(`{"error":{"code":"job_terminal","message":"job already finished: job job-1 is succeeded"}}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	_, err := client.CancelJobWithDetails(context.Background(), "job-1", engine.Cancellation{Reason: "stop", Strict: true})
+	if !errors.Is(err, engine.ErrJobTerminal) {
+		t.Fatalf("expected ErrJobTerminal, got %v", err)
+	}
+	if payload["strict"] != true {
+		t.Fatalf("strict flag not sent: %+v", payload)
+	}
+}
+
 func TestClientStreamJobs(t *testing.T) {
 	t.Parallel()
 
@@ -211,6 +236,162 @@ func TestClientListPipelines(t *testing.T) {
 	}
 }
 
+func TestClientLintPipeline(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/config/pipelines/lint" {
+			t.Fatalf("unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+		var def engine.PipelineDef
+		if err := json.NewDecoder(r.Body).Decode(&def); err != nil || def.Type != "demo" {
+			t.Fatalf("unexpected body: %+v %v", def, err)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"findings": []engine.Finding{{Severity: engine.SeverityWarning, Code: "no_exported_steps", Message: "no step is exported"}},
+		})
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	findings, err := client.LintPipeline(context.Background(), engine.PipelineDef{Type: "demo", Version: "v1"})
+	if err != nil {
+		t.Fatalf("LintPipeline failed: %v", err)
+	}
+	if len(findings) != 1 || findings[0].Code != "no_exported_steps" {
+		t.Fatalf("unexpected findings: %+v", findings)
+	}
+}
+
+func TestClientListJobsAndLineage(t *testing.T) {
+	t.Parallel()
+
+	parent := "job-a"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/jobs":
+			if got := r.URL.Query().Get("parent_job_id"); got != parent {
+				t.Fatalf("unexpected parent_job_id: %q", got)
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"jobs": []engine.Job{{ID: "job-b", ParentJobID: &parent}},
+			})
+		case "/v1/jobs/job-b/lineage":
+			_ = json.NewEncoder(w).Encode(engine.JobLineage{
+				Job:       &engine.Job{ID: "job-b", ParentJobID: &parent},
+				Ancestors: []*engine.Job{{ID: parent}},
+				Children:  []*engine.Job{},
+			})
+		default:
+			t.Fatalf("unexpected path: %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	jobs, err := client.ListJobs(context.Background(), parent)
+	if err != nil {
+		t.Fatalf("ListJobs failed: %v", err)
+	}
+	if len(jobs) != 1 || jobs[0].ID != "job-b" {
+		t.Fatalf("unexpected jobs: %+v", jobs)
+	}
+	lineage, err := client.GetJobLineage(context.Background(), "job-b")
+	if err != nil {
+		t.Fatalf("GetJobLineage failed: %v", err)
+	}
+	if len(lineage.Ancestors) != 1 || lineage.Ancestors[0].ID != parent {
+		t.Fatalf("unexpected lineage: %+v", lineage)
+	}
+}
+
+func TestClientWaitForJob(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/jobs/job-1/wait" {
+			t.Fatalf("unexpected path: %s", r.URL.Path)
+		}
+		if _, err := time.ParseDuration(r.URL.Query().Get("timeout")); err != nil {
+			t.Fatalf("unexpected timeout: %q", r.URL.Query().Get("timeout"))
+		}
+		status := engine.JobStatusRunning
+		if calls.Add(1) > 1 {
+			status = engine.JobStatusSucceeded
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"job": engine.Job{ID: "job-1", Status: status}})
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	job, err := client.WaitForJob(context.Background(), "job-1", time.Minute)
+	if err != nil {
+		t.Fatalf("WaitForJob failed: %v", err)
+	}
+	if job.Status != engine.JobStatusSucceeded || calls.Load() != 2 {
+		t.Fatalf("expected WaitForJob to repeat the long poll until the job finished: %+v after %d calls", job, calls.Load())
+	}
+
+	calls.Store(0)
+	job, err = client.WaitForJob(context.Background(), "job-1", 0)
+	if !errors.Is(err, ErrWaitTimeout) || job == nil || job.Status != engine.JobStatusRunning {
+		t.Fatalf("expected ErrWaitTimeout with the running job, got %+v, %v", job, err)
+	}
+}
+
+func TestClientGetJobDiff(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/jobs/job-b/diff" || r.URL.Query().Get("against") != "job-a" {
+			t.Fatalf("unexpected request: %s", r.URL)
+		}
+		_ = json.NewEncoder(w).Encode(engine.JobDiff{
+			JobID:     "job-b",
+			AgainstID: "job-a",
+			ResultDiff: engine.ResultDiff{
+				Items:   []engine.ItemDiff{{StepID: "final", Label: "final", Status: engine.ItemChanged}},
+				Changed: 1,
+			},
+		})
+	}))
+	defer server.Close()
+
+	diff, err := NewClient(server.URL).GetJobDiff(context.Background(), "job-b", "job-a")
+	if err != nil {
+		t.Fatalf("GetJobDiff failed: %v", err)
+	}
+	if diff.AgainstID != "job-a" || diff.Changed != 1 || len(diff.Items) != 1 || diff.Items[0].Status != engine.ItemChanged {
+		t.Fatalf("unexpected diff: %+v", diff)
+	}
+}
+
+func TestClientGetJobEvents(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/jobs/job-1/events" {
+			t.Fatalf("unexpected path: %s", r.URL.Path)
+		}
+		if got := r.URL.Query().Get("after_seq"); got != "2" {
+			t.Fatalf("unexpected after_seq: %q", got)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"events": []engine.StreamingEvent{{Seq: 3, Event: "job_completed", JobID: "job-1"}},
+		})
+	}))
+	defer server.Close()
+
+	events, err := NewClient(server.URL).GetJobEvents(context.Background(), "job-1", 2)
+	if err != nil {
+		t.Fatalf("GetJobEvents failed: %v", err)
+	}
+	if len(events) != 1 || events[0].Seq != 3 || events[0].Event != "job_completed" {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+}
+
 func TestClientGetMetrics(t *testing.T) {
 	t.Parallel()
 
@@ -233,3 +414,152 @@ func TestClientGetMetrics(t *testing.T) {
 		t.Fatalf("unexpected metrics: %+v", data)
 	}
 }
+
+func TestClientGetJobResults(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/jobs/job-1/results" {
+			t.Fatalf("unexpected path: %s", r.URL.Path)
+		}
+		query := r.URL.Query()
+		if query.Get("tag") != "report" || query.Get("limit") != "10" || query.Get("offset") != "20" {
+			t.Fatalf("unexpected query: %s", r.URL.RawQuery)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"job_id": "job-1",
+			"tag":    "report",
+			"items":  []engine.ResultItem{{ID: "item-21", Tag: "report"}},
+			"total":  21,
+			"limit":  10,
+			"offset": 20,
+		})
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	page, err := client.GetJobResults(context.Background(), "job-1", ResultsQuery{Tag: "report", Limit: 10, Offset: 20})
+	if err != nil {
+		t.Fatalf("GetJobResults failed: %v", err)
+	}
+	if page.Total != 21 || len(page.Items) != 1 || page.Items[0].Tag != "report" {
+		t.Fatalf("unexpected results page: %+v", page)
+	}
+}
+
+func TestClientCreateJobs(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/jobs/batch" {
+			t.Fatalf("unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+		var reqs []engine.JobRequest
+		if err := json.NewDecoder(r.Body).Decode(&reqs); err != nil {
+			t.Fatalf("decode batch: %v", err)
+		}
+		if len(reqs) != 2 {
+			t.Fatalf("unexpected batch size: %d", len(reqs))
+		}
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(BatchResult{
+			BatchID: "batch-1",
+			Items: []BatchItem{
+				{Index: 0, Job: &engine.Job{ID: "job-1", BatchID: "batch-1"}},
+				{Index: 1, Error: &BatchError{Code: "invalid_request", Message: "pipeline_type is required"}},
+			},
+			Created: 1,
+			Failed:  1,
+		})
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	result, err := client.CreateJobs(context.Background(), []engine.JobRequest{
+		{PipelineType: "demo"},
+		{},
+	})
+	if err != nil {
+		t.Fatalf("CreateJobs failed: %v", err)
+	}
+	if result.BatchID != "batch-1" || len(result.Items) != 2 || result.Items[1].Error == nil {
+		t.Fatalf("unexpected batch result: %+v", result)
+	}
+}
+
+func TestIsRetryableJobError(t *testing.T) {
+	cases := []struct {
+		name string
+		err  *engine.JobError
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "network", err: &engine.JobError{Code: engine.ErrCodeProviderNetwork}, want: true},
+		{name: "rate limited", err: &engine.JobError{Code: engine.ErrCodeProviderAPI, Details: map[string]any{"status_code": float64(429)}}, want: true},
+		{name: "server error", err: &engine.JobError{Code: engine.ErrCodeProviderAPI, Details: map[string]any{"status_code": float64(503)}}, want: true},
+		{name: "client error", err: &engine.JobError{Code: engine.ErrCodeProviderAPI, Details: map[string]any{"status_code": float64(401)}}, want: false},
+		{name: "decode", err: &engine.JobError{Code: engine.ErrCodeProviderDecode}, want: false},
+		{name: "other", err: &engine.JobError{Code: "step_failed"}, want: false},
+	}
+	for _, tc := range cases {
+		if got := IsRetryableJobError(tc.err); got != tc.want {
+			t.Errorf("%s: got %v want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestClientGetJobWithoutResults(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/jobs/job-1" || r.URL.Query().Get("include_results") != "false" {
+			t.Fatalf("unexpected request: %s", r.URL.String())
+		}
+		_ = json.NewEncoder(w).Encode(jobEnvelope{Job: engine.Job{ID: "job-1"}})
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	job, err := client.GetJobWithoutResults(context.Background(), "job-1")
+	if err != nil {
+		t.Fatalf("GetJobWithoutResults failed: %v", err)
+	}
+	if job.ID != "job-1" || job.Result != nil {
+		t.Fatalf("unexpected job: %+v", job)
+	}
+}
+
+func TestClientGetCapabilities(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/capabilities" {
+			http.NotFound(w, r)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"version":    "0.2.0",
+			"job_modes":  []string{"async", "sync"},
+			"step_kinds": []string{"llm"},
+			"events":     []string{"job_queued", "job_completed"},
+		})
+	}))
+	defer server.Close()
+
+	caps, err := NewClient(server.URL).GetCapabilities(context.Background())
+	if err != nil {
+		t.Fatalf("GetCapabilities failed: %v", err)
+	}
+	if caps.Version != "0.2.0" || !caps.SupportsJobMode("sync") || caps.SupportsJobMode("dry_run") {
+		t.Fatalf("unexpected capabilities: %+v", caps)
+	}
+	if !caps.SupportsEvent("job_completed") || caps.SupportsEvent("heartbeat") || caps.SupportsStepKind(engine.StepKindRetrieve) {
+		t.Fatalf("unexpected feature detection: %+v", caps)
+	}
+
+	old := httptest.NewServer(http.NotFoundHandler())
+	defer old.Close()
+	if _, err := NewClient(old.URL).GetCapabilities(context.Background()); !errors.Is(err, ErrCapabilitiesUnsupported) {
+		t.Fatalf("expected ErrCapabilitiesUnsupported, got %v", err)
+	}
+}
@@ -1,6 +1,6 @@
 import test from "node:test";
 import assert from "node:assert/strict";
-import { PipelineEngineClient } from "./client.js";
+import { JobTerminalError, PipelineEngineClient } from "./client.js";
 import type { FetchLike, JobRequest, StreamingEvent } from "./types.js";
 
 const encoder = new TextEncoder();
@@ -101,6 +101,56 @@ test("listPipelines fetches definitions", async () => {
   assert.equal(pipelines[0].type, "demo");
 });
 
+test("lintPipeline posts the definition and returns findings", async () => {
+  let captured: { url: string; body: string } | undefined;
+  const fetchMock: FetchLike = async (url, init) => {
+    captured = { url: url.toString(), body: String(init?.body) };
+    return jsonResponse({ findings: [{ severity: "warning", code: "no_exported_steps", message: "no step is exported" }] });
+  };
+  const client = new PipelineEngineClient({ baseUrl: "http://localhost:8085", fetch: fetchMock });
+  const findings = await client.lintPipeline({ type: "demo", version: "v1", steps: [] });
+  assert.equal(captured?.url, "http://localhost:8085/v1/config/pipelines/lint");
+  assert.equal(JSON.parse(captured?.body ?? "{}").type, "demo");
+  assert.equal(findings[0].code, "no_exported_steps");
+});
+
+test("waitForJob long-polls the wait endpoint", async () => {
+  let capturedUrl = "";
+  const fetchMock: FetchLike = async (url) => {
+    capturedUrl = url.toString();
+    return jsonResponse({ job: { id: "job-1", status: "succeeded" } });
+  };
+  const client = new PipelineEngineClient({ baseUrl: "http://localhost:8085", fetch: fetchMock });
+  const job = await client.waitForJob("job-1", 1500);
+  assert.equal(capturedUrl, "http://localhost:8085/v1/jobs/job-1/wait?timeout=2s");
+  assert.equal(job.status, "succeeded");
+});
+
+test("waitForJob repeats the request until the job finishes", async () => {
+  const urls: string[] = [];
+  const fetchMock: FetchLike = async (url) => {
+    urls.push(url.toString());
+    return jsonResponse({ job: { id: "job-1", status: urls.length < 3 ? "running" : "succeeded" } });
+  };
+  const client = new PipelineEngineClient({ baseUrl: "http://localhost:8085", fetch: fetchMock });
+  const job = await client.waitForJob("job-1", 150_000);
+  assert.equal(job.status, "succeeded");
+  assert.equal(urls.length, 3);
+  assert.equal(urls[0], "http://localhost:8085/v1/jobs/job-1/wait?timeout=60s");
+});
+
+test("waitForJob returns the running job once the deadline passes", async () => {
+  let calls = 0;
+  const fetchMock: FetchLike = async () => {
+    calls++;
+    return jsonResponse({ job: { id: "job-1", status: "running" } });
+  };
+  const client = new PipelineEngineClient({ baseUrl: "http://localhost:8085", fetch: fetchMock });
+  const job = await client.waitForJob("job-1", 0);
+  assert.equal(job.status, "running");
+  assert.equal(calls, 1);
+});
+
 test("getMetrics returns payload", async () => {
   const fetchMock: FetchLike = async () =>
     jsonResponse({ provider_call_count: { openai: 5 }, provider_call_latency: { openai: 10 } });
@@ -108,3 +158,32 @@ test("getMetrics returns payload", async () => {
   const metrics = await client.getMetrics();
   assert.equal(metrics.provider_call_count.openai, 5);
 });
+
+test("getCapabilities returns undefined for servers without the endpoint", async () => {
+  const fetchMock: FetchLike = async (url) => {
+    if (url.toString().startsWith("http://new")) {
+      return jsonResponse({ version: "0.2.0", job_modes: ["async", "sync", "dry_run"], provider_kinds: [], step_kinds: ["llm"], step_modes: [], content_types: [], events: ["job_queued"] });
+    }
+    return new Response("404 page not found", { status: 404 });
+  };
+  const caps = await new PipelineEngineClient({ baseUrl: "http://new", fetch: fetchMock }).getCapabilities();
+  assert.equal(caps?.version, "0.2.0");
+  assert.ok(caps?.job_modes.includes("dry_run"));
+  const old = await new PipelineEngineClient({ baseUrl: "http://old", fetch: fetchMock }).getCapabilities();
+  assert.equal(old, undefined);
+});
+
+test("strict cancelJob throws JobTerminalError for finished jobs", async () => {
+  let capturedBody = "";
+  const fetchMock: FetchLike = async (_url, init) => {
+    capturedBody = init?.body?.toString() ?? "";
+    return jsonResponse({ error: { code: "job_terminal", message: "job already finished: job job-9 is succeeded" } }, 409);
+  };
+  const client = new PipelineEngineClient({ baseUrl: "http://localhost:9000", fetch: fetchMock });
+  await assert.rejects(client.cancelJob("job-9", "stop", { strict: true }), (err: unknown) => {
+    assert.ok(err instanceof JobTerminalError);
+    assert.equal(err.jobID, "job-9");
+    return true;
+  });
+  assert.equal(capturedBody, JSON.stringify({ reason: "stop", strict: true }));
+});
@@ -1,8 +1,11 @@
 import {
+  Capabilities,
   EngineConfigInput,
   FetchLike,
   Job,
   JobRequest,
+  JobStatus,
+  LintFinding,
   PipelineDef,
   ProviderProfileInput,
   StreamingEvent
@@ -18,6 +21,26 @@ export interface StreamJobsResult {
   events: AsyncIterable<StreamingEvent>;
 }
 
+export interface CancelJobOptions {
+  /** Reject cancelling an already finished job instead of treating it as a no-op. */
+  strict?: boolean;
+}
+
+/** Thrown by a strict cancelJob when the job has already finished (HTTP 409 job_terminal). */
+export class JobTerminalError extends Error {
+  readonly code = "job_terminal";
+
+  constructor(readonly jobID: string, message: string) {
+    super(message);
+    this.name = "JobTerminalError";
+  }
+}
+
+/** Matches the server's cap on a single GET /v1/jobs/{id}/wait. */
+const MAX_WAIT_PER_REQUEST_MS = 60_000;
+
+const TERMINAL_STATUSES: ReadonlySet<JobStatus> = new Set<JobStatus>(["succeeded", "failed", "cancelled"]);
+
 export class PipelineEngineClient {
   private readonly baseUrl: string;
   private readonly fetchImpl: FetchLike;
@@ -41,12 +64,44 @@ export class PipelineEngineClient {
     return this.requestJSON(`/v1/jobs/${jobID}`, { method: "GET" });
   }
 
-  async cancelJob(jobID: string, reason = "user"): Promise<Job> {
-    return this.requestJSON(`/v1/jobs/${jobID}/cancel`, {
+  /**
+   * Long-polls GET /v1/jobs/{id}/wait until the job is terminal or
+   * `timeoutMs` elapses, repeating the request since the server caps each
+   * one at 60s, and returns the job at that point; check its status to tell
+   * the two apart.
+   */
+  async waitForJob(jobID: string, timeoutMs = 30_000): Promise<Job> {
+    const deadline = Date.now() + timeoutMs;
+    for (;;) {
+      const waitMs = Math.min(Math.max(0, deadline - Date.now()), MAX_WAIT_PER_REQUEST_MS);
+      const seconds = Math.ceil(waitMs / 1000);
+      const job = await this.requestJSON(`/v1/jobs/${jobID}/wait?timeout=${seconds}s`, { method: "GET" });
+      if (TERMINAL_STATUSES.has(job.status) || Date.now() >= deadline) {
+        return job;
+      }
+    }
+  }
+
+  /**
+   * Cancels a job. Cancelling a finished job returns it unchanged unless
+   * `options.strict` is set, in which case a JobTerminalError is thrown.
+   */
+  async cancelJob(jobID: string, reason = "user", options: CancelJobOptions = {}): Promise<Job> {
+    const resp = await this.fetchImpl(`${this.baseUrl}/v1/jobs/${jobID}/cancel`, {
       method: "POST",
       headers: { "Content-Type": "application/json" },
-      body: JSON.stringify({ reason })
+      body: JSON.stringify(options.strict ? { reason, strict: true } : { reason })
     });
+    if (resp.status === 409) {
+      const payload = await resp.json().catch(() => undefined);
+      if (payload?.error?.code === "job_terminal") {
+        throw new JobTerminalError(jobID, payload.error.message ?? "job already finished");
+      }
+    }
+    if (!resp.ok) {
+      throw new Error(`http error: ${resp.status} ${resp.statusText}`);
+    }
+    return this.decodeJob(await resp.json());
   }
 
   async rerunJob(jobID: string, payload: Record<string, unknown>): Promise<Job> {
@@ -189,6 +244,35 @@ export class PipelineEngineClient {
     return (json.pipelines as PipelineDef[]) ?? [];
   }
 
+  /** Lints a pipeline definition without registering it. */
+  async lintPipeline(def: PipelineDef): Promise<LintFinding[]> {
+    const resp = await this.fetchImpl(`${this.baseUrl}/v1/config/pipelines/lint`, {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify(def)
+    });
+    if (!resp.ok) {
+      throw new Error(`http error: ${resp.status} ${resp.statusText}`);
+    }
+    const json = await resp.json();
+    return (json.findings as LintFinding[]) ?? [];
+  }
+
+  /**
+   * Returns the server version and supported modes, kinds and events, or
+   * undefined when the server predates /v1/capabilities (HTTP 404).
+   */
+  async getCapabilities(): Promise<Capabilities | undefined> {
+    const resp = await this.fetchImpl(`${this.baseUrl}/v1/capabilities`);
+    if (resp.status === 404) {
+      return undefined;
+    }
+    if (!resp.ok) {
+      throw new Error(`http error: ${resp.status} ${resp.statusText}`);
+    }
+    return resp.json();
+  }
+
   async getMetrics(): Promise<Record<string, Record<string, number>>> {
     const resp = await this.fetchImpl(`${this.baseUrl}/v1/metrics`);
     if (!resp.ok) {
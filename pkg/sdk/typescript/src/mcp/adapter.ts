@@ -3,11 +3,13 @@ import type { Readable, Writable } from "node:stream";
 import type { PipelineEngineClient } from "../client.js";
 import type {
   Job,
+  JobError,
   JobRequest,
   PipelineDef,
   ProviderProfileInput,
   StreamingEvent
 } from "../types.js";
+import { isRetryableJobError } from "../types.js";
 
 const JSONRPC_VERSION = "2.0";
 
@@ -221,6 +223,9 @@ export class MCPAdapter {
     if (typeof args.reuse_upstream === "boolean") {
       payload.reuse_upstream = args.reuse_upstream;
     }
+    if (Array.isArray(args.reuse_steps)) {
+      payload.reuse_steps = args.reuse_steps;
+    }
     if (args.override_input) {
       payload.override_input = args.override_input;
     }
@@ -276,16 +281,23 @@ export class MCPAdapter {
   }
 
   private emitToolEvent(toolName: string, evt: StreamingEvent): void {
+    const kind = classifyEventKind(evt.event);
+    const params: Record<string, unknown> = {
+      toolName,
+      event: evt.event,
+      kind,
+      seq: evt.seq,
+      payload: evt
+    };
+    const jobError = (evt.data as { error?: JobError } | undefined)?.error;
+    if (kind === "error" && jobError) {
+      params.errorCode = jobError.code;
+      params.retryable = isRetryableJobError(jobError);
+    }
     const notification = {
       jsonrpc: JSONRPC_VERSION,
       method: "tool_event",
-      params: {
-        toolName,
-        event: evt.event,
-        kind: classifyEventKind(evt.event),
-        seq: evt.seq,
-        payload: evt
-      }
+      params
     };
     this.writeJSON(notification);
   }
@@ -373,6 +385,7 @@ function defaultTools(): ToolDefinition[] {
           job_id: { type: "string" },
           from_step_id: { type: "string" },
           reuse_upstream: { type: "boolean" },
+          reuse_steps: { type: "array", items: { type: "string" } },
           override_input: { type: "object" }
         }
       }
@@ -5,26 +5,64 @@ export interface Source {
   kind: string;
   label?: string;
   content: string;
+  /** Keeps content out of storage and responses (replaced by "[REDACTED]"). */
+  sensitive?: boolean;
+  /** Set on stored copies whose sensitive content was replaced. */
+  redacted?: boolean;
+  /** Ranks the source for `source_order: "priority"` steps; higher comes first. */
+  priority?: number;
+}
+
+export interface ChatMessage {
+  role: "system" | "user" | "assistant";
+  content: string;
 }
 
 export interface JobInput {
   sources: Source[];
+  /** Prior conversation turns, oldest first. */
+  history?: ChatMessage[];
   options?: Record<string, unknown>;
 }
 
 export interface JobRequest {
   pipeline_type: string;
+  pipeline_version?: string;
   input: JobInput;
   mode?: string;
   parent_job_id?: string;
   from_step_id?: string;
   reuse_upstream?: boolean;
+  reuse_steps?: string[];
+  batch_id?: string;
+  /** Name of a registered export sink; overrides the pipeline's. */
+  export_sink?: string;
+  /** Additionally exports these steps' results for this job only. */
+  export_steps?: string[];
+  /** Requests reproducible sampling; overrides input.options.seed. */
+  seed?: number;
+  webhook?: Webhook;
+  /**
+   * Profiles (e.g. with the caller's own API key) resolved before registered
+   * ones for this job only; never stored.
+   */
+  ephemeral_providers?: ProviderProfileInput[];
+}
+
+/**
+ * Posts the job's streaming events to url as they occur. events defaults to
+ * the terminal job_completed / job_failed / job_cancelled events.
+ */
+export interface Webhook {
+  url: string;
+  events?: string[];
 }
 
 export interface StepExecution {
   step_id: string;
   status: StepExecutionStatus;
   chunks?: StepChunk[];
+  chunk_count?: number;
   error?: JobError;
 }
 
@@ -34,14 +72,41 @@ export interface StepDef {
   name?: string;
   mode?: string;
   depends_on?: string[];
+  /** Template variable name → JSON pointer into upstream outputs (.Vars.<name>). */
+  input_mapping?: Record<string, string>;
   provider_profile_id?: string;
   output_type?: string;
+  /** "json_strict" requests native JSON output and requires the output to parse as JSON. */
+  output_format?: "text" | "json_strict" | "json_loose";
+  /** JSON Schema a json_strict step's output follows. */
+  output_schema?: Record<string, unknown>;
 }
 
 export interface PipelineDef {
   type: string;
   version: string;
   steps: StepDef[];
+  default_provider_profile_id?: string;
+  export_sink?: string;
+  /** Jobs whose sources do not match are rejected with invalid_input. */
+  required_sources?: SourceRequirement;
+  /** Jobs of this pipeline executing at once; further jobs stay queued. */
+  max_concurrency?: number;
+}
+
+export type LintSeverity = "error" | "warning" | "info";
+
+export interface LintFinding {
+  severity: LintSeverity;
+  code: string;
+  /** Omitted for findings about the pipeline as a whole. */
+  step?: string;
+  message: string;
+}
+
+export interface SourceRequirement {
+  min_count?: number;
+  kinds?: string[];
 }
 
 export interface StepChunk {
@@ -72,6 +137,12 @@ export interface Job {
   result?: JobResult;
   step_executions?: StepExecution[];
   error?: JobError;
+  batch_id?: string;
+  export_sink?: string;
+  export_steps?: string[];
+  webhook?: Webhook;
+  /** IDs of the ephemeral_providers the job ran with. */
+  ephemeral_profiles?: string[];
 }
 
 export interface JobResult {
@@ -85,6 +156,30 @@ export interface ResultItem {
   kind: string;
   content_type: string;
   data: Record<string, unknown>;
+  /** Set when the job wrote the item to an export sink; data is then null. */
+  uri?: string;
+  /** Describes the provider call that produced the item. */
+  provider_meta?: ProviderMeta;
+  /** Partial output of a step whose job was cancelled mid-stream. */
+  incomplete?: boolean;
+}
+
+export interface TokenUsage {
+  prompt_tokens: number;
+  completion_tokens: number;
+  total_tokens: number;
+}
+
+export interface ProviderMeta {
+  provider?: string;
+  model?: string;
+  finish_reason?: string;
+  usage?: TokenUsage;
+  latency_ms: number;
+  /** Seed the provider sampled with. */
+  seed?: number;
+  /** Set when a seed was requested but the provider does not support it. */
+  seed_ignored?: boolean;
 }
 
 export interface JobError {
@@ -93,6 +188,25 @@ export interface JobError {
   details?: unknown;
 }
 
+/**
+ * Returns true when a job or step error is a transient provider failure worth
+ * resubmitting: provider_network_error, or provider_api_error with status 429
+ * or 5xx.
+ */
+export function isRetryableJobError(error?: JobError | null): boolean {
+  if (!error) {
+    return false;
+  }
+  if (error.code === "provider_network_error") {
+    return true;
+  }
+  if (error.code === "provider_api_error") {
+    const status = (error.details as { status_code?: number } | undefined)?.status_code ?? 0;
+    return status === 429 || status >= 500;
+  }
+  return false;
+}
+
 export interface StreamingEvent<T = any> {
   seq?: number;
   event: string;
@@ -100,4 +214,15 @@ export interface StreamingEvent<T = any> {
   data: T;
 }
 
+/** Body of GET /v1/capabilities. */
+export interface Capabilities {
+  version: string;
+  job_modes: string[];
+  provider_kinds: string[];
+  step_kinds: string[];
+  step_modes: string[];
+  content_types: string[];
+  events: string[];
+}
+
 export type FetchLike = typeof fetch;